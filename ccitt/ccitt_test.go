@@ -17,6 +17,7 @@ limitations under the License.
 package ccitt
 
 import (
+	"bytes"
 	"fmt"
 	"image"
 	"image/color"
@@ -161,6 +162,71 @@ func TestCCITT(t *testing.T) {
 
 }
 
+func testRoundTrip(t *testing.T, fileName string, mode, w, h int, inverse, align bool) {
+
+	f, err := os.Open(fileName)
+	if err != nil {
+		t.Errorf("%s: %v", fileName, err)
+		return
+	}
+	defer f.Close()
+
+	buf, err := ioutil.ReadAll(NewReader(f, mode, w, inverse, align))
+	if err != nil {
+		t.Errorf("%s: %v", fileName, err)
+		return
+	}
+
+	stride := (w + 7) / 8
+
+	// Reencode the decoded image using Group 4 encoding.
+	var b bytes.Buffer
+	wc := NewWriter(&b, w, inverse)
+	if _, err = wc.Write(buf[:stride*h]); err != nil {
+		t.Errorf("%s: %v", fileName, err)
+		return
+	}
+	if err = wc.Close(); err != nil {
+		t.Errorf("%s: %v", fileName, err)
+		return
+	}
+
+	buf, err = ioutil.ReadAll(NewReader(&b, Group4, w, inverse, false))
+	if err != nil {
+		t.Errorf("%s: %v", fileName, err)
+		return
+	}
+
+	fnNoExt := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	img, err := readImgFromPNG(fnNoExt + ".png")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	compare(t, fnNoExt, imgForBuf(buf, w, h), img)
+}
+
+func TestWriter(t *testing.T) {
+
+	for _, tt := range []struct {
+		fileName string
+		mode     int
+		w, h     int
+		inverse  bool
+		align    bool
+	}{
+		{"testdata/scan2.gr3", Group3, 1656, 2339, false, false},
+		{"testdata/amt.gr4", Group4, 43, 38, false, false},
+		{"testdata/lc.gr4", Group4, 154, 154, false, false},
+		{"testdata/do.gr4", Group4, 613, 373, true, false},
+		{"testdata/t6diagram.gr4", Group4, 1163, 2433, false, false},
+		{"testdata/hl.gr4", Group4, 2548, 3300, false, true},
+	} {
+		testRoundTrip(t, tt.fileName, tt.mode, tt.w, tt.h, tt.inverse, tt.align)
+	}
+}
+
 func TestBitBuf(t *testing.T) {
 
 	var b bitBuf
@@ -0,0 +1,246 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccitt
+
+import (
+	"io"
+)
+
+// Run length codes by run length.
+var termWCodes, termBCodes, makeupWCodes, makeupBCodes, makeupBigCodes = codesByRunLength()
+
+func codesByRunLength() (tw, tb, mw, mb, mbig map[int]string) {
+
+	invert := func(m map[string]int) map[int]string {
+		inv := map[int]string{}
+		for k, v := range m {
+			inv[v] = k
+		}
+		return inv
+	}
+
+	return invert(termW), invert(termB), invert(makeupW), invert(makeupB), invert(makeupBig)
+}
+
+// bitWriter collects code bits msb first.
+type bitWriter struct {
+	buf  []byte
+	bits int
+}
+
+func (bw *bitWriter) writeCode(code string) {
+	for _, c := range code {
+		if bw.bits%8 == 0 {
+			bw.buf = append(bw.buf, 0)
+		}
+		if c == '1' {
+			bw.buf[len(bw.buf)-1] |= 0x80 >> uint(bw.bits%8)
+		}
+		bw.bits++
+	}
+}
+
+func (bw *bitWriter) writeRunLength(l int, white bool) {
+
+	term, makeup := termWCodes, makeupWCodes
+	if !white {
+		term, makeup = termBCodes, makeupBCodes
+	}
+
+	for ; l > 2560; l -= 2560 {
+		bw.writeCode(makeupBigCodes[2560])
+	}
+
+	if l >= 64 {
+		m := l / 64 * 64
+		if m > 1728 {
+			bw.writeCode(makeupBigCodes[m])
+		} else {
+			bw.writeCode(makeup[m])
+		}
+		l -= m
+	}
+
+	bw.writeCode(term[l])
+}
+
+type encoder struct {
+	w      io.Writer
+	width  int
+	inv    bool // set pixel bits represent black pixels.
+	stride int  // row length in bytes
+	row    []byte
+	ref    []int // changing elements of the reference row.
+	bw     bitWriter
+	err    error
+}
+
+// white returns true if pixel x of a packed row is white.
+func (e *encoder) white(row []byte, x int) bool {
+	set := row[x/8]&(0x80>>uint(x%8)) > 0
+	return set != e.inv
+}
+
+// changingElements returns the positions of the pixels of a packed row differing in color from their left neighbour,
+// assuming an imaginary white pixel left of the row. The result is terminated by two elements at width.
+func (e *encoder) changingElements(row []byte) []int {
+
+	var a []int
+
+	white := true
+	for x := 0; x < e.width; x++ {
+		if e.white(row, x) != white {
+			a = append(a, x)
+			white = !white
+		}
+	}
+
+	return append(a, e.width, e.width)
+}
+
+// nextElement returns the index of the first changing element right of a0 with given parity or any parity if parity < 0.
+// Even elements turn to black, odd elements to white.
+func nextElement(a []int, a0, parity int) int {
+
+	w := a[len(a)-1]
+
+	for i, x := range a {
+		if x == w {
+			return i
+		}
+		if x > a0 && (parity < 0 || i%2 == parity) {
+			return i
+		}
+	}
+
+	return len(a) - 2
+}
+
+// encodeRow encodes a row using two-dimensional coding, see T.6 2.2
+func (e *encoder) encodeRow(row []byte) {
+
+	cur := e.changingElements(row)
+	ref := e.ref
+
+	a0, white := -1, true
+
+	for a0 < e.width {
+
+		i := nextElement(cur, a0, -1)
+		a1 := cur[i]
+
+		// b1 has the opposite color of a0.
+		parity := 0
+		if !white {
+			parity = 1
+		}
+		j := nextElement(ref, a0, parity)
+		b1, b2 := ref[j], ref[j+1]
+
+		if b2 < a1 {
+			e.bw.writeCode(mP)
+			a0 = b2
+			continue
+		}
+
+		if d := a1 - b1; d >= -3 && d <= 3 {
+			e.bw.writeCode([]string{mVL3, mVL2, mVL1, mV0, mVR1, mVR2, mVR3}[d+3])
+			a0, white = a1, !white
+			continue
+		}
+
+		a2 := cur[i+1]
+		start := a0
+		if start < 0 {
+			start = 0
+		}
+
+		e.bw.writeCode(mH)
+		e.bw.writeRunLength(a1-start, white)
+		e.bw.writeRunLength(a2-a1, !white)
+		a0 = a2
+	}
+
+	e.ref = cur
+}
+
+func (e *encoder) Write(p []byte) (int, error) {
+
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	n := len(p)
+
+	for len(p) > 0 {
+		m := e.stride - len(e.row)
+		if m > len(p) {
+			m = len(p)
+		}
+		e.row = append(e.row, p[:m]...)
+		p = p[m:]
+		if len(e.row) == e.stride {
+			e.encodeRow(e.row)
+			e.row = e.row[:0]
+		}
+	}
+
+	// Flush complete bytes.
+	if i := e.bw.bits / 8; i > 0 {
+		if _, e.err = e.w.Write(e.bw.buf[:i]); e.err != nil {
+			return 0, e.err
+		}
+		e.bw.buf = append([]byte{}, e.bw.buf[i:]...)
+		e.bw.bits -= i * 8
+	}
+
+	return n, nil
+}
+
+// Close writes the end of facsimile block and flushes any pending bits.
+// An incomplete last row is ignored.
+func (e *encoder) Close() error {
+
+	if e.err != nil {
+		return e.err
+	}
+
+	e.bw.writeCode(eofb)
+
+	_, e.err = e.w.Write(e.bw.buf)
+	if e.err != nil {
+		return e.err
+	}
+
+	e.err = errClosed
+
+	return nil
+}
+
+// NewWriter creates a new WriteCloser encoding rows of packed 1 bit pixels written to it using Group 4 encoding.
+// Set pixel bits represent white pixels unless inverse is true.
+// It is the caller's responsibility to call Close on the WriteCloser when done.
+func NewWriter(w io.Writer, width int, inverse bool) io.WriteCloser {
+
+	stride := width / 8
+	if width%8 > 0 {
+		stride++
+	}
+
+	// The imaginary row above the first row is white.
+	return &encoder{w: w, width: width, inv: inverse, stride: stride, ref: []int{width, width}}
+}
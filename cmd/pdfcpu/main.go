@@ -31,7 +31,7 @@ import (
 var (
 	fileStats, mode, pageSelection string
 	upw, opw, key, perm            string
-	verbose, veryVerbose           bool
+	verbose, veryVerbose, force    bool
 
 	needStackTrace = true
 )
@@ -53,6 +53,8 @@ func init() {
 	permUsage := "encrypt, perm set: none|all"
 	flag.StringVar(&perm, "perm", "none", permUsage)
 
+	flag.BoolVar(&force, "force", false, "encrypt: allow weak encryption")
+
 	pageSelectionUsage := "a comma separated list of pages or page ranges, see pdfcpu help split/extract"
 	flag.StringVar(&pageSelection, "pages", "", pageSelectionUsage)
 	flag.StringVar(&pageSelection, "p", "", pageSelectionUsage)
@@ -86,28 +88,34 @@ func main() {
 	}
 
 	for k, v := range map[string]func(config *pdfcpu.Configuration) *api.Command{
-		"validate":  prepareValidateCommand,
-		"optimize":  prepareOptimizeCommand,
-		"o":         prepareOptimizeCommand,
-		"split":     prepareSplitCommand,
-		"s":         prepareSplitCommand,
-		"merge":     prepareMergeCommand,
-		"m":         prepareMergeCommand,
-		"extract":   prepareExtractCommand,
-		"ext":       prepareExtractCommand,
-		"trim":      prepareTrimCommand,
-		"t":         prepareTrimCommand,
-		"attach":    prepareAttachmentCommand,
-		"decrypt":   prepareDecryptCommand,
-		"d":         prepareDecryptCommand,
-		"dec":       prepareDecryptCommand,
-		"encrypt":   prepareEncryptCommand,
-		"enc":       prepareEncryptCommand,
-		"changeupw": prepareChangeUserPasswordCommand,
-		"changeopw": prepareChangeOwnerPasswordCommand,
-		"perm":      preparePermissionsCommand,
-		"stamp":     prepareAddStampsCommand,
-		"watermark": prepareAddWatermarksCommand,
+		"validate":    prepareValidateCommand,
+		"optimize":    prepareOptimizeCommand,
+		"o":           prepareOptimizeCommand,
+		"split":       prepareSplitCommand,
+		"s":           prepareSplitCommand,
+		"merge":       prepareMergeCommand,
+		"m":           prepareMergeCommand,
+		"extract":     prepareExtractCommand,
+		"ext":         prepareExtractCommand,
+		"trim":        prepareTrimCommand,
+		"t":           prepareTrimCommand,
+		"collect":     prepareCollectCommand,
+		"remove":      prepareRemovePagesCommand,
+		"crop":        prepareCropCommand,
+		"resize":      prepareResizeCommand,
+		"poster":      preparePosterCommand,
+		"attach":      prepareAttachmentCommand,
+		"decrypt":     prepareDecryptCommand,
+		"d":           prepareDecryptCommand,
+		"dec":         prepareDecryptCommand,
+		"encrypt":     prepareEncryptCommand,
+		"enc":         prepareEncryptCommand,
+		"changeupw":   prepareChangeUserPasswordCommand,
+		"changeopw":   prepareChangeOwnerPasswordCommand,
+		"perm":        preparePermissionsCommand,
+		"stamp":       prepareAddStampsCommand,
+		"watermark":   prepareAddWatermarksCommand,
+		"unwatermark": prepareRemoveWatermarksCommand,
 	} {
 		if command == k {
 			cmd = v(config)
@@ -149,21 +157,27 @@ func helpString(topic string) string {
 		usageShort, usageLong string
 		usagePageSelection    bool
 	}{
-		"validate":  {usageValidate, usageLongValidate, false},
-		"optimize":  {usageOptimize, usageLongOptimize, false},
-		"split":     {usageSplit, usageLongSplit, false},
-		"merge":     {usageMerge, usageLongMerge, false},
-		"extract":   {usageExtract, usageLongExtract, false},
-		"trim":      {usageTrim, usageLongTrim, true},
-		"attach":    {usageAttach, usageLongAttach, false},
-		"perm":      {usagePerm, usageLongPerm, false},
-		"encrypt":   {usageEncrypt, usageLongEncrypt, false},
-		"decrypt":   {usageDecrypt, usageLongDecrypt, false},
-		"changeupw": {usageChangeUserPW, usageLongChangeUserPW, false},
-		"changeopw": {usageChangeOwnerPW, usageLongChangeOwnerPW, false},
-		"stamp":     {usageStamp, usageLongStamp, true},
-		"watermark": {usageWatermark, usageLongWatermark, true},
-		"version":   {usageVersion, usageLongVersion, false},
+		"validate":    {usageValidate, usageLongValidate, false},
+		"optimize":    {usageOptimize, usageLongOptimize, false},
+		"split":       {usageSplit, usageLongSplit, false},
+		"merge":       {usageMerge, usageLongMerge, false},
+		"extract":     {usageExtract, usageLongExtract, false},
+		"trim":        {usageTrim, usageLongTrim, true},
+		"collect":     {usageCollect, usageLongCollect, false},
+		"remove":      {usageRemovePages, usageLongRemovePages, true},
+		"crop":        {usageCrop, usageLongCrop, true},
+		"resize":      {usageResize, usageLongResize, true},
+		"poster":      {usagePoster, usageLongPoster, true},
+		"attach":      {usageAttach, usageLongAttach, false},
+		"perm":        {usagePerm, usageLongPerm, false},
+		"encrypt":     {usageEncrypt, usageLongEncrypt, false},
+		"decrypt":     {usageDecrypt, usageLongDecrypt, false},
+		"changeupw":   {usageChangeUserPW, usageLongChangeUserPW, false},
+		"changeopw":   {usageChangeOwnerPW, usageLongChangeOwnerPW, false},
+		"stamp":       {usageStamp, usageLongStamp, true},
+		"watermark":   {usageWatermark, usageLongWatermark, true},
+		"unwatermark": {usageRemoveWatermarks, usageLongRemoveWatermarks, true},
+		"version":     {usageVersion, usageLongVersion, false},
 	} {
 		if topic == k {
 			if v.usagePageSelection {
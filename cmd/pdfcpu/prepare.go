@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/jplu/pdfcpu/pkg/api"
 	"github.com/jplu/pdfcpu/pkg/pdfcpu"
@@ -114,8 +115,8 @@ func prepareMergeCommand(config *pdfcpu.Configuration) *api.Command {
 
 func allowedExtracMode(s string) bool {
 
-	return mode == "image" || mode == "font" || mode == "page" || mode == "content" || mode == "meta" ||
-		mode == "i" || mode == "p" || mode == "c" || mode == "m"
+	return mode == "image" || mode == "font" || mode == "page" || mode == "content" || mode == "meta" || mode == "text" ||
+		mode == "i" || mode == "p" || mode == "c" || mode == "m" || mode == "t"
 }
 
 func prepareExtractCommand(config *pdfcpu.Configuration) *api.Command {
@@ -146,6 +147,11 @@ func prepareExtractCommand(config *pdfcpu.Configuration) *api.Command {
 		cmd = api.ExtractFontsCommand(filenameIn, dirnameOut, pages, config)
 
 	case "page", "p":
+		// outDir names the file to extract into if it ends with .pdf and is no existing directory.
+		if fi, err := os.Stat(dirnameOut); (err != nil || !fi.IsDir()) && strings.HasSuffix(strings.ToLower(dirnameOut), ".pdf") {
+			cmd = api.ExtractPagesToFileCommand(filenameIn, dirnameOut, pages, config)
+			break
+		}
 		cmd = api.ExtractPagesCommand(filenameIn, dirnameOut, pages, config)
 
 	case "content", "c":
@@ -153,6 +159,9 @@ func prepareExtractCommand(config *pdfcpu.Configuration) *api.Command {
 
 	case "meta", "m":
 		cmd = api.ExtractMetadataCommand(filenameIn, dirnameOut, config)
+
+	case "text", "t":
+		cmd = api.ExtractTextCommand(filenameIn, dirnameOut, pages, config)
 	}
 
 	return cmd
@@ -182,6 +191,54 @@ func prepareTrimCommand(config *pdfcpu.Configuration) *api.Command {
 	return api.TrimCommand(filenameIn, filenameOut, pages, config)
 }
 
+func prepareCollectCommand(config *pdfcpu.Configuration) *api.Command {
+
+	if len(flag.Args()) == 0 || len(flag.Args()) > 2 || pageSelection == "" {
+		fmt.Fprintf(os.Stderr, "%s\n\n", usageCollect)
+		os.Exit(1)
+	}
+
+	pages, err := api.ParsePageSelection(pageSelection)
+	if err != nil {
+		log.Fatalf("collect: problem with flag pageSelection: %v", err)
+	}
+
+	filenameIn := flag.Arg(0)
+	ensurePdfExtension(filenameIn)
+
+	filenameOut := defaultFilenameOut(filenameIn)
+	if len(flag.Args()) == 2 {
+		filenameOut = flag.Arg(1)
+		ensurePdfExtension(filenameOut)
+	}
+
+	return api.CollectCommand(filenameIn, filenameOut, pages, config)
+}
+
+func prepareRemovePagesCommand(config *pdfcpu.Configuration) *api.Command {
+
+	if len(flag.Args()) == 0 || len(flag.Args()) > 2 || pageSelection == "" {
+		fmt.Fprintf(os.Stderr, "%s\n\n", usageRemovePages)
+		os.Exit(1)
+	}
+
+	pages, err := api.ParsePageSelection(pageSelection)
+	if err != nil {
+		log.Fatalf("remove: problem with flag pageSelection: %v", err)
+	}
+
+	filenameIn := flag.Arg(0)
+	ensurePdfExtension(filenameIn)
+
+	filenameOut := defaultFilenameOut(filenameIn)
+	if len(flag.Args()) == 2 {
+		filenameOut = flag.Arg(1)
+		ensurePdfExtension(filenameOut)
+	}
+
+	return api.RemovePagesCommand(filenameIn, filenameOut, pages, config)
+}
+
 func prepareListAttachmentsCommand(config *pdfcpu.Configuration) *api.Command {
 
 	if len(flag.Args()) != 1 || pageSelection != "" {
@@ -398,6 +455,8 @@ func prepareEncryptCommand(config *pdfcpu.Configuration) *api.Command {
 		config.EncryptUsing128BitKey = false
 	}
 
+	config.ForceWeakEncryption = force
+
 	if perm == "all" {
 		config.UserAccessPermissions = pdfcpu.PermissionsAll
 	}
@@ -503,3 +562,114 @@ func prepareAddStampsCommand(config *pdfcpu.Configuration) *api.Command {
 func prepareAddWatermarksCommand(config *pdfcpu.Configuration) *api.Command {
 	return prepareWatermarksCommand(config, false)
 }
+
+func prepareCropCommand(config *pdfcpu.Configuration) *api.Command {
+
+	if len(flag.Args()) < 2 || len(flag.Args()) > 3 {
+		fmt.Fprintf(os.Stderr, "%s\n\n", usageCrop)
+		os.Exit(1)
+	}
+
+	pages, err := api.ParsePageSelection(pageSelection)
+	if err != nil {
+		log.Fatalf("crop: problem with flag pageSelection: %v", err)
+	}
+
+	c, err := pdfcpu.ParseCropDetails(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("crop: %v", err)
+	}
+
+	filenameIn := flag.Arg(1)
+	ensurePdfExtension(filenameIn)
+
+	filenameOut := defaultFilenameOut(filenameIn)
+	if len(flag.Args()) == 3 {
+		filenameOut = flag.Arg(2)
+		ensurePdfExtension(filenameOut)
+	}
+
+	return api.CropCommand(filenameIn, filenameOut, pages, c, config)
+}
+
+func prepareResizeCommand(config *pdfcpu.Configuration) *api.Command {
+
+	if len(flag.Args()) < 2 || len(flag.Args()) > 3 {
+		fmt.Fprintf(os.Stderr, "%s\n\n", usageResize)
+		os.Exit(1)
+	}
+
+	pages, err := api.ParsePageSelection(pageSelection)
+	if err != nil {
+		log.Fatalf("resize: problem with flag pageSelection: %v", err)
+	}
+
+	r, err := pdfcpu.ParseResizeDetails(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("resize: %v", err)
+	}
+
+	filenameIn := flag.Arg(1)
+	ensurePdfExtension(filenameIn)
+
+	filenameOut := defaultFilenameOut(filenameIn)
+	if len(flag.Args()) == 3 {
+		filenameOut = flag.Arg(2)
+		ensurePdfExtension(filenameOut)
+	}
+
+	return api.ResizeCommand(filenameIn, filenameOut, pages, r, config)
+}
+
+func preparePosterCommand(config *pdfcpu.Configuration) *api.Command {
+
+	if len(flag.Args()) < 2 || len(flag.Args()) > 3 {
+		fmt.Fprintf(os.Stderr, "%s\n\n", usagePoster)
+		os.Exit(1)
+	}
+
+	pages, err := api.ParsePageSelection(pageSelection)
+	if err != nil {
+		log.Fatalf("poster: problem with flag pageSelection: %v", err)
+	}
+
+	p, err := pdfcpu.ParsePosterDetails(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("poster: %v", err)
+	}
+
+	filenameIn := flag.Arg(1)
+	ensurePdfExtension(filenameIn)
+
+	filenameOut := defaultFilenameOut(filenameIn)
+	if len(flag.Args()) == 3 {
+		filenameOut = flag.Arg(2)
+		ensurePdfExtension(filenameOut)
+	}
+
+	return api.PosterCommand(filenameIn, filenameOut, pages, p, config)
+}
+
+func prepareRemoveWatermarksCommand(config *pdfcpu.Configuration) *api.Command {
+
+	if len(flag.Args()) < 1 || len(flag.Args()) > 2 {
+		fmt.Fprintf(os.Stderr, "%s\n\n", usageRemoveWatermarks)
+		os.Exit(1)
+	}
+
+	pages, err := api.ParsePageSelection(pageSelection)
+	if err != nil {
+		log.Fatalf("unwatermark: problem with flag pageSelection: %v", err)
+	}
+
+	filenameIn := flag.Arg(0)
+	ensurePdfExtension(filenameIn)
+
+	filenameOut := defaultFilenameOut(filenameIn)
+	if len(flag.Args()) == 2 {
+		filenameOut = flag.Arg(1)
+		ensurePdfExtension(filenameOut)
+	}
+
+	return api.RemoveWatermarksCommand(filenameIn, filenameOut, pages, config)
+}
@@ -29,8 +29,13 @@ The commands are:
 	optimize	optimize PDF by getting rid of redundant page resources
 	split		split multi-page PDF into several single-page PDFs
 	merge		concatenate 2 or more PDFs
-	extract		extract images, fonts, content, pages, metadata
+	extract		extract images, fonts, content, pages, metadata, text
 	trim		create trimmed version
+	collect		create custom sequence of selected pages
+	remove		remove selected pages
+	crop		set page boxes of selected pages
+	resize		scale selected pages to a new page size
+	poster		split selected pages into tiles
 	attach		list, add, remove, extract embedded file attachments
 	perm		list, add user access permissions
 	encrypt		set password protection		
@@ -39,6 +44,7 @@ The commands are:
 	changeopw	change owner password
 	stamp		add stamps
 	watermark	add watermarks
+	unwatermark	remove stamps and watermarks
 	version		print version
    
 	Single-letter Unix-style supported for commands and flags.
@@ -90,8 +96,8 @@ verbose, v ... turn on logging
    outFile ... output pdf file
    inFiles ... a list of at least 2 pdf files subject to concatenation.`
 
-	usageExtract     = "usage: pdfcpu extract [-v(erbose)|vv] -mode image|font|content|page|meta [-pages pageSelection] [-upw userpw] [-opw ownerpw] inFile outDir"
-	usageLongExtract = `Extract exports inFile's images, fonts, content or pages into outDir.
+	usageExtract     = "usage: pdfcpu extract [-v(erbose)|vv] -mode image|font|content|page|meta|text [-pages pageSelection] [-upw userpw] [-opw ownerpw] inFile outDir"
+	usageLongExtract = `Extract exports inFile's images, fonts, content, pages or text into outDir.
 
 verbose, v ... turn on logging
         vv ... verbose logging
@@ -107,8 +113,9 @@ verbose, v ... turn on logging
   image ... extract images (supported PDF filters: Flate, DCTDecode, JPXDecode)
    font ... extract font files (supported font types: TrueType)
 content ... extract raw page content
-   page ... extract single page PDFs
-   meta ... extract all metadata (page selection does not apply)`
+   page ... extract single page PDFs, or all selected pages into outDir if it is a .pdf file and not a directory
+   meta ... extract all metadata (page selection does not apply)
+   text ... extract the text of each page as UTF-8`
 
 	usageTrim     = "usage: pdfcpu trim [-v(erbose)|vv] [-pages pageSelection] [-upw userpw] [-opw ownerpw] inFile [outFile]"
 	usageLongTrim = `Trim generates a trimmed version of inFile for selected pages.
@@ -121,6 +128,111 @@ verbose, v ... turn on logging
     inFile ... input pdf file
    outFile ... output pdf file (default: inFile-new.pdf)`
 
+	usageCollect     = "usage: pdfcpu collect [-v(erbose)|vv] -pages pageSequence [-upw userpw] [-opw ownerpw] inFile [outFile]"
+	usageLongCollect = `Collect generates a version of inFile made of a custom sequence of pages.
+
+verbose, v ... turn on logging
+        vv ... verbose logging
+     pages ... page sequence
+       upw ... user password
+       opw ... owner password
+    inFile ... input pdf file
+   outFile ... output pdf file (default: inFile-new.pdf)
+
+<pages> uses the page selection syntax but gets evaluated from left to right into an ordered sequence:
+pages may be repeated and ranges may be descending, eg. "3,1,1,5-2" yields the pages 3,1,1,5,4,3,2.
+A negated expression removes all occurrences of its pages collected so far.`
+
+	usageRemovePages     = "usage: pdfcpu remove [-v(erbose)|vv] -pages pageSelection [-upw userpw] [-opw ownerpw] inFile [outFile]"
+	usageLongRemovePages = `Remove generates a version of inFile without the selected pages.
+
+verbose, v ... turn on logging
+        vv ... verbose logging
+     pages ... page selection
+       upw ... user password
+       opw ... owner password
+    inFile ... input pdf file
+   outFile ... output pdf file (default: inFile-new.pdf)`
+
+	usageCrop     = "usage: pdfcpu crop [-v(erbose)|vv] [-pages pageSelection] [-upw userpw] [-opw ownerpw] description inFile [outFile]"
+	usageLongCrop = `Crop sets the crop box for selected pages.
+
+ verbose, v ... turn on logging
+         vv ... verbose logging
+      pages ... page selection
+        upw ... user password
+        opw ... owner password
+description ... crop box as rectangle, margins or dimensions, additional page boxes
+     inFile ... input pdf file
+    outFile ... output pdf file (default: inFile-new.pdf)
+
+<description> is a comma separated configuration string containing exactly one of:
+
+   rect: llx lly urx ury, an absolute rectangle, gets clipped to the media box
+ margin: t r b l, margins inside the media box, also 't' for all or 't r' for vertical and horizontal margins
+    dim: w h, dimensions positioned within the media box
+
+    optional entries:
+
+    pos: position of dim: tl, tc, tr, l, c, r, bl, bc, br (default: c)
+  boxes: page boxes set along with the crop box: trim, bleed, art
+
+All values are in user space units (1/72 inch) and refer to the unrotated page.
+
+e.g. 'margin:36'                  'rect:0 0 300 400'
+     'margin:20 40, boxes:trim'   'dim:420 595, pos:tl, boxes:trim bleed'`
+
+	usageResize     = "usage: pdfcpu resize [-v(erbose)|vv] [-pages pageSelection] [-upw userpw] [-opw ownerpw] description inFile [outFile]"
+	usageLongResize = `Resize scales the content of selected pages to a new page size preserving the aspect ratio.
+
+ verbose, v ... turn on logging
+         vv ... verbose logging
+      pages ... page selection
+        upw ... user password
+        opw ... owner password
+description ... paper size or dimensions, margin
+     inFile ... input pdf file
+    outFile ... output pdf file (default: inFile-new.pdf)
+
+<description> is a comma separated configuration string containing exactly one of:
+
+  paper: A0-A8, B3-B6, Letter, Legal, Tabloid, Ledger, Executive
+         matching the orientation of each page, append L or P for landscape or portrait, eg. A4L
+    dim: w h, width and height in user space units (1/72 inch)
+
+    optional entries:
+
+ margin: the minimum space around the page content in user space units (default: 0)
+
+e.g. 'paper:A4'   'paper:LetterP, margin:36'   'dim:500 500'`
+
+	usagePoster     = "usage: pdfcpu poster [-v(erbose)|vv] [-pages pageSelection] [-upw userpw] [-opw ownerpw] description inFile [outFile]"
+	usageLongPoster = `Poster splits selected pages into tiles at the original scale for printing large format pages on standard paper.
+
+ verbose, v ... turn on logging
+         vv ... verbose logging
+      pages ... page selection
+        upw ... user password
+        opw ... owner password
+description ... grid or tile size, overlap
+     inFile ... input pdf file
+    outFile ... output pdf file (default: inFile-new.pdf)
+
+<description> is a comma separated configuration string containing exactly one of:
+
+   grid: cols rows, the number of tiles across and down
+  paper: A0-A8, B3-B6, Letter, Legal, Tabloid, Ledger, Executive
+         in the orientation resulting in fewer tiles, append L or P for landscape or portrait, eg. A4L
+    dim: w h, tile width and height in user space units (1/72 inch)
+
+    optional entries:
+
+overlap: the content repeated along adjacent tile edges in user space units (default: 0)
+
+Tiles replace their page ordered by rows from top left to bottom right.
+
+e.g. 'grid:2 2'   'paper:A4, overlap:20'   'dim:500 700'`
+
 	usagePageSelection = `<pages> selects pages for processing and is a comma separated list of expressions:
 
 	Valid expressions are:
@@ -173,13 +285,14 @@ verbose, v ... turn on logging
        opw ... owner password
     sinFile ... input pdf file`
 
-	usageEncrypt     = "usage: pdfcpu encrypt [-v(erbose)|vv] [-mode rc4|aes] [-key 40|128] [perm none|all] [-upw userpw] [-opw ownerpw] inFile [outFile]"
+	usageEncrypt     = "usage: pdfcpu encrypt [-v(erbose)|vv] [-mode rc4|aes] [-key 40|128] [-force] [perm none|all] [-upw userpw] [-opw ownerpw] inFile [outFile]"
 	usageLongEncrypt = `Encrypt sets a password protection based on user and owner password.
 
 verbose, v ... turn on logging
         vv ... verbose logging
       mode ... algorithm (default=aes)
        key ... key length in bits (default=128)
+     force ... allow RC4 with a 40 bit key
       perm ... user access permissions
        upw ... user password
        opw ... owner password
@@ -268,6 +381,17 @@ description ... font, font size, text, color, image/pdf file name, pdf page#, ro
 
 ` + usageWMDescription
 
+	usageRemoveWatermarks     = "usage: pdfcpu unwatermark [-v(erbose)|vv] [-pages pageSelection] [-upw userpw] [-opw ownerpw] inFile [outFile]"
+	usageLongRemoveWatermarks = `Unwatermark removes stamps and watermarks added by pdfcpu from selected pages.
+
+verbose, v ... turn on logging
+        vv ... verbose logging
+     pages ... page selection
+       upw ... user password
+       opw ... owner password
+    inFile ... input pdf file
+   outFile ... output pdf file (default: inFile-new.pdf)`
+
 	usageVersion     = "usage: pdfcpu version"
 	usageLongVersion = "Version prints the pdfcpu version"
 )
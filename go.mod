@@ -1,3 +1,3 @@
 module github.com/jplu/pdfcpu
 
-require github.com/pkg/errors v0.8.0
+require github.com/pkg/errors v0.9.1
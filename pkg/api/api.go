@@ -20,13 +20,17 @@ package api
 import (
 	"bufio"
 	"bytes"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/fs"
 	"io/ioutil"
-	"os"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jplu/pdfcpu/pkg/log"
@@ -52,10 +56,67 @@ func stringSet(slice []string) pdf.StringSet {
 }
 
 // ReadContext uses an io.Readseeker to build an internal structure holding its cross reference table aka the Context.
+// The content of all streams gets loaded into memory, streaming large files from rs is not supported.
 func ReadContext(rs io.ReadSeeker, fileIn string, fileSize int64, config *pdf.Configuration) (*pdf.Context, error) {
 	return pdf.Read(rs, fileIn, fileSize, config)
 }
 
+// ReadContextAt builds a Context for a PDF file of size bytes embedded in rs at offset,
+// eg. within a mail archive or another container format, without copying it out first.
+// rs needs to stay open as long as ctx is in use.
+func ReadContextAt(rs io.ReadSeeker, offset, size int64, config *pdf.Configuration) (*pdf.Context, error) {
+
+	if offset < 0 || size <= 0 {
+		return nil, errors.Errorf("ReadContextAt: invalid section offset=%d size=%d", offset, size)
+	}
+
+	return pdf.Read(pdf.NewSectionReadSeeker(rs, offset, size), "", size, config)
+}
+
+// ReadPageContext uses an io.ReadSeeker to build a minimal Context holding a single page only.
+func ReadPageContext(rs io.ReadSeeker, pageNr int, config *pdf.Configuration) (*pdf.Context, error) {
+	return pdf.ReadPage(rs, pageNr, config)
+}
+
+// PageCount returns the page count of a PDF file for quick triage, eg. of uploads.
+// Only the cross reference table, the catalog and the page tree root get read, the file does not get validated.
+func PageCount(rs io.ReadSeeker) (int, error) {
+	return pdf.ReadPageCount(rs, pdf.NewDefaultConfiguration())
+}
+
+// PDFVersion returns the version of a PDF file for quick triage, eg. of uploads.
+// Only the header, the cross reference table and the catalog get read, the file does not get validated.
+func PDFVersion(rs io.ReadSeeker) (pdf.Version, error) {
+	return pdf.ReadVersion(rs, pdf.NewDefaultConfiguration())
+}
+
+// Probe checks the integrity of header, startxref, %%EOF marker, cross reference sections and trailers of a PDF file
+// without loading any objects, eg. for filtering obviously broken uploads.
+// Passing the probe does not mean the file is valid.
+func Probe(rs io.ReadSeeker) error {
+	return pdf.Probe(rs, pdf.NewDefaultConfiguration())
+}
+
+// InheritedPageAttrs returns Resources, MediaBox, CropBox and Rotate in effect for page pageNr
+// taking into account any attributes inherited from ancestor page tree nodes.
+func InheritedPageAttrs(ctx *pdf.Context, pageNr int) (*pdf.InheritedPageAttrs, error) {
+
+	if pageNr < 1 || pageNr > ctx.PageCount {
+		return nil, pdf.NewError(pdf.ErrPageOutOfRange, "InheritedPageAttrs: invalid page number %d", pageNr)
+	}
+
+	d, attrs, err := ctx.PageDict(pageNr)
+	if err != nil {
+		return nil, err
+	}
+
+	if d == nil {
+		return nil, errors.Errorf("InheritedPageAttrs: missing page %d", pageNr)
+	}
+
+	return attrs, nil
+}
+
 // ValidateContext validates a PDF context.
 func ValidateContext(ctx *pdf.Context) error {
 	return validate.XRefTable(ctx.XRefTable)
@@ -72,6 +133,12 @@ func WriteContext(ctx *pdf.Context, w io.Writer) error {
 	return pdf.Write(ctx)
 }
 
+// WriteContextAt writes a PDF context using an io.WriterAt.
+// Object streams get encoded and file segments get written concurrently.
+func WriteContextAt(ctx *pdf.Context, wa io.WriterAt) error {
+	return pdf.WriteAt(ctx, wa)
+}
+
 // MergeContexts merges a sequence of PDF's represented by a slice of ReadSeekerCloser.
 func MergeContexts(rsc []pdf.ReadSeekerCloser, config *pdf.Configuration) (*pdf.Context, error) {
 
@@ -118,6 +185,11 @@ func MergeContexts(rsc []pdf.ReadSeekerCloser, config *pdf.Configuration) (*pdf.
 		return nil, err
 	}
 
+	// Use one resource name for fonts shared by the merged files.
+	if _, err = pdf.ShareFontResourceNames(ctxDest); err != nil {
+		return nil, err
+	}
+
 	err = ValidateContext(ctxDest)
 
 	return ctxDest, err
@@ -170,12 +242,17 @@ func Validate(cmd *Command) ([]string, error) {
 // Write generates a PDF file for a given Context.
 func Write(ctx *pdf.Context) error {
 
-	fmt.Printf("writing %s ...\n", ctx.Write.DirName+ctx.Write.FileName)
+	fmt.Printf("writing %s ...\n", ctx.Write.FilePath())
 	//logInfoAPI.Printf("writing to %s..\n", fileName)
 
 	err := pdf.Write(ctx)
 	if err != nil {
-		return errors.Wrap(err, "Write failed.")
+		return errors.Wrap(err, "write failed")
+	}
+
+	err = verifyWrite(ctx)
+	if err != nil {
+		return err
 	}
 
 	if ctx.StatsFileName != "" {
@@ -188,6 +265,104 @@ func Write(ctx *pdf.Context) error {
 	return nil
 }
 
+// verifyWrite re-reads and validates the file just written for ctx if the configuration asks for it.
+func verifyWrite(ctx *pdf.Context) error {
+
+	if !ctx.VerifyWrite {
+		return nil
+	}
+
+	_, err := readWrittenFile(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "verify write (mode=%s)", ctx.ValidationModeString())
+	}
+
+	return nil
+}
+
+// readWrittenFile reads and validates the file just written for ctx.
+func readWrittenFile(ctx *pdf.Context) (*pdf.Context, error) {
+
+	fileName := ctx.Write.FilePath()
+
+	// Any password change has been applied to ctx during writing.
+	config := *ctx.Configuration
+	config.Mode = pdf.VALIDATE
+	config.UserPWNew = nil
+	config.OwnerPWNew = nil
+
+	ctxOut, err := ReadContextFromFile(fileName, &config)
+	if err != nil {
+		return nil, errors.Wrap(err, fileName)
+	}
+
+	err = ValidateContext(ctxOut)
+	if err != nil {
+		return nil, errors.Wrap(err, fileName)
+	}
+
+	return ctxOut, nil
+}
+
+// pageDigests returns the content digests of the selected pages of ctx in ascending page order.
+// No page selection means all pages are selected.
+func pageDigests(ctx *pdf.Context, selectedPages pdf.IntSet) ([][]byte, error) {
+
+	var digests [][]byte
+
+	for i := 1; i <= ctx.PageCount; i++ {
+
+		if len(selectedPages) > 0 && !selectedPages[i] {
+			continue
+		}
+
+		d, err := ctx.PageContentDigest(i)
+		if err != nil {
+			return nil, err
+		}
+
+		digests = append(digests, d)
+	}
+
+	return digests, nil
+}
+
+// verifyPages re-reads the file just written for ctx
+// and compares its page count and page content digests against digests.
+func verifyPages(ctx *pdf.Context, digests [][]byte) error {
+
+	ctxOut, err := readWrittenFile(ctx)
+	if err != nil {
+		return errors.Wrap(err, "verify pages")
+	}
+
+	fileName := ctx.Write.FilePath()
+
+	if ctxOut.PageCount != len(digests) {
+		return errors.Errorf("verify pages: %s: page count %d, expected %d", fileName, ctxOut.PageCount, len(digests))
+	}
+
+	var mismatches []int
+
+	for i, d := range digests {
+
+		dOut, err := ctxOut.PageContentDigest(i + 1)
+		if err != nil {
+			return errors.Wrapf(err, "verify pages: %s", fileName)
+		}
+
+		if !bytes.Equal(d, dOut) {
+			mismatches = append(mismatches, i+1)
+		}
+	}
+
+	if len(mismatches) > 0 {
+		return errors.Errorf("verify pages: %s: content mismatch for pages %v", fileName, mismatches)
+	}
+
+	return nil
+}
+
 // singlePageFileName generates a filename for a Context and a specific page number.
 func singlePageFileName(ctx *pdf.Context, pageNr int) string {
 
@@ -196,34 +371,144 @@ func singlePageFileName(ctx *pdf.Context, pageNr int) string {
 	return fileName + "_" + strconv.Itoa(pageNr) + ".pdf"
 }
 
-func writeSinglePagePDF(ctx *pdf.Context, pageNr int, dirOut string) error {
+// SplitEncryptionFunc supplies the encryption settings for the single page file fileName written for pageNr.
+// Returning nil writes the file without changing the encryption of the input.
+type SplitEncryptionFunc func(pageNr int, fileName string) *pdf.EncryptionSettings
+
+// PageFile is a single page file written for page PageNr.
+type PageFile struct {
+	PageNr   int
+	FileName string // The path of the file written.
+}
+
+// pageFileNames returns the paths of pf.
+func pageFileNames(pf []PageFile) []string {
+
+	ss := make([]string, len(pf))
+	for i, f := range pf {
+		ss[i] = f.FileName
+	}
+
+	return ss
+}
+
+// writeSinglePagePDF writes page pageNr of ctx into a file in dirOut and returns its path.
+func writeSinglePagePDF(ctx *pdf.Context, pageNr int, dirOut string, f SplitEncryptionFunc) (string, error) {
+
+	fileName := singlePageFileName(ctx, pageNr)
+
+	if ctx.SplitDocumentData {
+		// Reduce the document data for this file on a copy.
+		ctx = ctx.Clone()
+		err := pdf.KeepDocumentDataForPage(ctx.XRefTable, pageNr)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if f != nil {
+		if es := f(pageNr, fileName); es != nil {
+			// Keep the encryption setup for this file away from the remaining pages.
+			if !ctx.SplitDocumentData {
+				ctx = ctx.Clone()
+			}
+			ctx.ApplyEncryption(*es)
+		}
+	}
+
+	if ctx.RecordProvenance {
+		pp := pdf.PageProvenance{FileName: filepath.Base(ctx.Read.FileName), From: pageNr, Thru: pageNr, At: 1}
+		err := pdf.RecordProvenance(ctx, []pdf.PageProvenance{pp})
+		if err != nil {
+			return "", err
+		}
+	}
 
 	ctx.ResetWriteContext()
 
 	w := ctx.Write
 	w.Command = "Split"
 	w.ExtractPageNr = pageNr
-	w.DirName = dirOut + "/"
-	w.FileName = singlePageFileName(ctx, pageNr)
-	fmt.Printf("writing %s ...\n", w.DirName+w.FileName)
+	w.DirName = dirOut
+	w.FileName = fileName
+	fmt.Printf("writing %s ...\n", w.FilePath())
 
-	return pdf.Write(ctx)
+	err := pdf.Write(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return w.FilePath(), verifyWrite(ctx)
 }
 
-func writeSinglePagePDFs(ctx *pdf.Context, selectedPages pdf.IntSet, dirOut string) error {
+// writeSinglePagePDFs writes selected pages of ctx into single page files in dirOut
+// and returns the files written in page order.
+func writeSinglePagePDFs(ctx *pdf.Context, selectedPages pdf.IntSet, dirOut string, f SplitEncryptionFunc) ([]PageFile, error) {
 
 	ensureSelectedPages(ctx, &selectedPages)
 
-	for i, v := range selectedPages {
-		if v {
-			err := writeSinglePagePDF(ctx, i, dirOut)
+	if ctx.MaterializePageAttrs {
+		err := ctx.MaterializeInheritedPageAttrs()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var m *splitManifest
+
+	if ctx.SplitSkipExisting {
+		var err error
+		if m, err = readSplitManifest(ctx.FileSystem(), dirOut); err != nil {
+			return nil, err
+		}
+	}
+
+	var pf []PageFile
+
+	for _, i := range pdf.SortedSelectedPages(selectedPages) {
+
+		if m != nil {
+			fileName := filepath.Join(dirOut, singlePageFileName(ctx, i))
+			ok, err := m.written(fileName)
 			if err != nil {
-				return err
+				return nil, err
+			}
+			if ok {
+				log.Info.Printf("skipping existing file %s\n", fileName)
+				pf = append(pf, PageFile{PageNr: i, FileName: fileName})
+				continue
+			}
+		}
+
+		fileName, err := writeSinglePagePDF(ctx, i, dirOut, f)
+		if err != nil {
+			return nil, err
+		}
+
+		if m != nil {
+			if err = m.record(fileName); err != nil {
+				return nil, err
 			}
 		}
+
+		pf = append(pf, PageFile{PageNr: i, FileName: fileName})
 	}
 
-	return nil
+	return pf, nil
+}
+
+// configForMode returns a copy of config, or of the default configuration if config is nil, set up for mode.
+// The mode determines the permissions needed for processing encrypted files.
+func configForMode(config *pdf.Configuration, mode pdf.CommandMode) *pdf.Configuration {
+
+	if config == nil {
+		config = pdf.NewDefaultConfiguration()
+	}
+
+	c := *config
+	c.Mode = mode
+
+	return &c
 }
 
 func readAndValidate(fileIn string, config *pdf.Configuration, from1 time.Time) (ctx *pdf.Context, dur1, dur2 float64, err error) {
@@ -307,8 +592,30 @@ func OptimizeIO(file io.Reader, fileOut string, decrypt bool) error {
 	return nil
 }
 
+// ReadImagePolicy reads the image recompression rules of jsonFile for use as Configuration.ImagePolicy.
+func ReadImagePolicy(jsonFile string, config *pdf.Configuration) (pdf.ImagePolicy, error) {
+
+	f, err := config.FileSystem().Open(jsonFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p, err := pdf.ParseImagePolicy(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "ReadImagePolicy: %s", jsonFile)
+	}
+
+	return p, nil
+}
+
 // Optimize reads in fileIn, does validation, optimization and writes the result to fileOut.
 func Optimize(cmd *Command) ([]string, error) {
+	_, err := optimize(cmd)
+	return nil, err
+}
+
+func optimize(cmd *Command) (*pdf.Context, error) {
 	fileIn := *cmd.InFile
 	fileOut := *cmd.OutFile
 	config := cmd.Config
@@ -337,10 +644,11 @@ func Optimize(cmd *Command) ([]string, error) {
 	durTotal := time.Since(fromStart).Seconds()
 	logOperationStats(ctx, "write", durRead, durVal, durOpt, durWrite, durTotal)
 
-	return nil, nil
+	return ctx, nil
 }
 
 // Split generates a sequence of single page PDF files in dirOut creating one file for every page of inFile.
+// The paths of the files written get returned in page order, cmd.PageFiles also carries their page numbers.
 func Split(cmd *Command) ([]string, error) {
 
 	fileIn := *cmd.InFile
@@ -358,7 +666,7 @@ func Split(cmd *Command) ([]string, error) {
 
 	fromWrite := time.Now()
 
-	err = writeSinglePagePDFs(ctx, nil, dirOut)
+	pf, err := writeSinglePagePDFs(ctx, nil, dirOut, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -367,23 +675,62 @@ func Split(cmd *Command) ([]string, error) {
 	durTotal := time.Since(fromStart).Seconds()
 	logOperationStats(ctx, "split", durRead, durVal, durOpt, durWrite, durTotal)
 
-	return nil, nil
+	cmd.PageFiles = pf
+
+	return pageFileNames(pf), nil
+}
+
+// SplitAndEncrypt generates a single page PDF file in dirOut for every page of fileIn
+// and protects each file using the encryption settings supplied by f.
+// The files written get returned in page order.
+func SplitAndEncrypt(fileIn, dirOut string, f SplitEncryptionFunc, config *pdf.Configuration) ([]PageFile, error) {
+
+	fromStart := time.Now()
+
+	fmt.Printf("splitting %s into %s ...\n", fileIn, dirOut)
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.SPLIT), fromStart)
+	if err != nil {
+		return nil, err
+	}
+
+	fromWrite := time.Now()
+
+	pf, err := writeSinglePagePDFs(ctx, nil, dirOut, f)
+	if err != nil {
+		return nil, err
+	}
+
+	durWrite := time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	logOperationStats(ctx, "split, encrypt", durRead, durVal, durOpt, durWrite, durTotal)
+
+	return pf, nil
 }
 
 // appendTo appends fileIn to ctxDest's page tree.
-func appendTo(fileIn string, ctxDest *pdf.Context) error {
+// If page verification is on the content digests of the appended pages are returned.
+func appendTo(fileIn string, ctxDest *pdf.Context) ([][]byte, error) {
 
 	log.Stats.Printf("appendTo: appending %s to %s\n", fileIn, ctxDest.Read.FileName)
 
 	// Build a Context for fileIn.
 	ctxSource, _, _, err := readAndValidate(fileIn, ctxDest.Configuration, time.Now())
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	var digests [][]byte
+	if ctxDest.VerifyPages {
+		digests, err = pageDigests(ctxSource, nil)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// Merge the source context into the dest context.
 	fmt.Printf("merging in %s ...\n", fileIn)
-	return pdf.MergeXRefTables(ctxSource, ctxDest)
+	return digests, pdf.MergeXRefTables(ctxSource, ctxDest)
 }
 
 // Merge some PDF files together and write the result to fileOut.
@@ -409,9 +756,29 @@ func Merge(cmd *Command) ([]string, error) {
 		log.Stats.Println("Ensure V1.5 for writing object & xref streams")
 	}
 
+	var digests [][]byte
+	if config.VerifyPages {
+		digests, err = pageDigests(ctxDest, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	pp := []pdf.PageProvenance{{FileName: filepath.Base(filesIn[0]), From: 1, Thru: ctxDest.PageCount, At: 1}}
+
 	// Repeatedly merge files into fileDest's xref table.
 	for _, f := range filesIn[1:] {
-		err = appendTo(f, ctxDest)
+		pageCount := ctxDest.PageCount
+		d, err := appendTo(f, ctxDest)
+		if err != nil {
+			return nil, err
+		}
+		digests = append(digests, d...)
+		pp = append(pp, pdf.PageProvenance{FileName: filepath.Base(f), From: 1, Thru: ctxDest.PageCount - pageCount, At: pageCount + 1})
+	}
+
+	if config.RecordProvenance {
+		err = pdf.RecordProvenance(ctxDest, pp)
 		if err != nil {
 			return nil, err
 		}
@@ -422,6 +789,21 @@ func Merge(cmd *Command) ([]string, error) {
 		return nil, err
 	}
 
+	// Use one resource name for fonts shared by the merged files.
+	remapped, err := pdf.ShareFontResourceNames(ctxDest)
+	if err != nil {
+		return nil, err
+	}
+
+	// Pages with remapped font names are expected to carry their new content.
+	if config.VerifyPages {
+		for _, i := range pdf.SortedSelectedPages(remapped) {
+			if digests[i-1], err = ctxDest.PageContentDigest(i); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	err = ValidateContext(ctxDest)
 	if err != nil {
 		return nil, err
@@ -438,80 +820,391 @@ func Merge(cmd *Command) ([]string, error) {
 		return nil, err
 	}
 
+	if config.VerifyPages {
+		err = verifyPages(ctxDest, digests)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	log.Stats.Printf("XRefTable:\n%s\n", ctxDest)
 
 	return nil, nil
 }
 
-func imageObjNrs(ctx *pdf.Context, page int) []int {
-
-	// TODO Exclude SMask image objects.
+// extractPagesContext returns a Context holding the selected pages of ctx only.
+func extractPagesContext(ctx *pdf.Context, pageSelection []string) (*pdf.Context, error) {
 
-	o := []int{}
+	if err := prepareExtractPages(ctx, pageSelection); err != nil {
+		return nil, err
+	}
 
-	for k, v := range ctx.Optimize.PageImages[page-1] {
-		if v {
-			o = append(o, k)
-		}
+	var buf bytes.Buffer
+	if err := WriteContext(ctx, &buf); err != nil {
+		return nil, err
 	}
 
-	return o
-}
+	ctxPages, err := ReadContext(bytes.NewReader(buf.Bytes()), ctx.Read.FileName, int64(buf.Len()), ctx.Configuration)
+	if err != nil {
+		return nil, err
+	}
 
-func imageFilenameWithoutExtension(dir, resID string, pageNr, objNr int) string {
-	return filepath.Join(dir, fmt.Sprintf("%s_%d_%d", resID, pageNr, objNr))
+	return ctxPages, ValidateContext(ctxPages)
 }
 
-func doExtractImages(ctx *pdf.Context, selectedPages pdf.IntSet, isFile bool) ([]byte, error) {
-	var img []byte
-	visited := pdf.IntSet{}
-	isFile =false
-	for pageNr, v := range selectedPages {
-
-		if v {
+// InsertFrom inserts the selected pages of fileSrc, all pages if none are selected, into fileDest
+// behind page afterPage and writes the result to fileOut. For afterPage 0 the pages get inserted in front of the first page.
+func InsertFrom(fileSrc, fileDest, fileOut string, afterPage int, selectedPages []string, config *pdf.Configuration) error {
 
-			log.Info.Printf("writing images for page %d\n", pageNr)
+	fromStart := time.Now()
 
-			for _, objNr := range imageObjNrs(ctx, pageNr) {
+	fmt.Printf("inserting %s into %s behind page %d ...\n", fileSrc, fileDest, afterPage)
 
-				if visited[objNr] {
-					continue
-				}
+	ctxDest, durRead, durVal, err := readAndValidate(fileDest, configForMode(config, pdf.INSERTPAGES), fromStart)
+	if err != nil {
+		return err
+	}
 
-				visited[objNr] = true
+	if afterPage < 0 || afterPage > ctxDest.PageCount {
+		return pdf.NewError(pdf.ErrPageOutOfRange, "InsertFrom: invalid page number: %d", afterPage)
+	}
 
-				output, err := pdf.ExtractImageData(ctx, objNr)
-				if err != nil {
-					return nil, err
-				}
+	if ctxDest.XRefTable.Version() < pdf.V15 {
+		v, _ := pdf.PDFVersion("1.5")
+		ctxDest.XRefTable.RootVersion = &v
+		log.Stats.Println("Ensure V1.5 for writing object & xref streams")
+	}
 
-				if output == nil {
-					continue
-				}
+	ctxSource, _, _, err := readAndValidate(fileSrc, configForMode(config, pdf.EXTRACTPAGES), time.Now())
+	if err != nil {
+		return err
+	}
 
-				filename := imageFilenameWithoutExtension(ctx.Write.DirName, output.ResourceNames[0], pageNr, objNr)
+	if len(selectedPages) > 0 {
+		if ctxSource, err = extractPagesContext(ctxSource, selectedPages); err != nil {
+			return err
+		}
+	}
 
-				_, img, err = pdf.WriteImage(ctx.XRefTable, filename, output.ImageDict, objNr, isFile)
-				if err != nil {
-					return nil, err
-				}
+	fromOpt := time.Now()
 
-			}
+	if err = pdf.InsertXRefTables(ctxSource, ctxDest, afterPage); err != nil {
+		return err
+	}
 
-		}
+	if err = OptimizeContext(ctxDest); err != nil {
+		return err
+	}
 
+	if err = ValidateContext(ctxDest); err != nil {
+		return err
 	}
 
-	return img, nil
-}
+	durOpt := time.Since(fromOpt).Seconds()
+	fromWrite := time.Now()
 
-// ExtractImages dumps embedded image resources from fileIn into dirOut for selected pages.
+	ctxDest.Write.Command = "Merge"
+
+	dirName, fileName := filepath.Split(fileOut)
+	ctxDest.Write.DirName = dirName
+	ctxDest.Write.FileName = fileName
+
+	if err = Write(ctxDest); err != nil {
+		return err
+	}
+
+	durWrite := time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	logOperationStats(ctxDest, "insert, write", durRead, durVal, durOpt, durWrite, durTotal)
+
+	return nil
+}
+
+// imageObjNrs returns the image objects of page excluding images serving as masks only.
+func imageObjNrs(ctx *pdf.Context, page int, masks pdf.IntSet) []int {
+
+	o := []int{}
+
+	for k, v := range ctx.Optimize.PageImages[page-1] {
+		if v && !masks[k] {
+			o = append(o, k)
+		}
+	}
+
+	return o
+}
+
+// Default extraction file name templates, see Configuration.ExtractFileNameTemplate.
+const (
+	imageFileNameTemplate   = "{name}_{page}_{obj}"
+	fontFileNameTemplate    = "{name}_{page}_{obj}"
+	contentFileNameTemplate = "{page}_{obj}"
+	textFileNameTemplate    = "{file}_{page}"
+)
+
+// extractFileNamer generates the names of the files written by an extraction.
+type extractFileNamer struct {
+	ctx      *pdf.Context
+	template string
+	used     map[string]bool
+}
+
+func newExtractFileNamer(ctx *pdf.Context, defaultTemplate string) *extractFileNamer {
+
+	t := ctx.ExtractFileNameTemplate
+	if t == "" {
+		t = defaultTemplate
+	}
+
+	return &extractFileNamer{ctx: ctx, template: t, used: map[string]bool{}}
+}
+
+// fileName returns the path of the file for a resource without extension.
+// Names already generated by this namer get a numeric suffix.
+func (n *extractFileNamer) fileName(resName string, pageNr, objNr int) string {
+
+	var file string
+	if n.ctx.Read.FileName != "" {
+		file = strings.TrimSuffix(filepath.Base(n.ctx.Read.FileName), ".pdf")
+	}
+
+	r := strings.NewReplacer(
+		"{file}", file,
+		"{name}", resName,
+		"{page}", strconv.Itoa(pageNr),
+		"{obj}", strconv.Itoa(objNr))
+
+	fn := r.Replace(n.template)
+
+	for i, s := 2, fn; n.used[fn]; i++ {
+		fn = fmt.Sprintf("%s_%d", s, i)
+	}
+	n.used[fn] = true
+
+	return filepath.Join(n.ctx.Write.DirName, fn)
+}
+
+// skip returns true if fileName exists and existing files are to be kept.
+func (n *extractFileNamer) skip(fileName string) bool {
+
+	if !n.ctx.ExtractSkipExisting {
+		return false
+	}
+
+	_, err := fs.Stat(n.ctx.FileSystem(), fileName)
+
+	return err == nil
+}
+
+// write passes f to sink if not nil or else writes f to disk unless existing files are to be kept.
+// Returns false if f got skipped.
+func (n *extractFileNamer) write(sink extractSink, f ExtractedFile) (bool, error) {
+
+	if sink != nil {
+		return true, sink(f)
+	}
+
+	if n.skip(f.Name) {
+		log.Info.Printf("skipping existing file %s\n", f.Name)
+		return false, nil
+	}
+
+	return true, pdf.WriteFile(n.ctx.FileSystem(), f.Name, f.Data, pdf.ExtractFilePerm)
+}
+
+// skipImage returns true if an image file for fileName exists and existing files are to be kept.
+// The extension of an image file depends on its encoding and color space.
+func (n *extractFileNamer) skipImage(fileName string) bool {
+
+	for _, ext := range []string{".png", ".jpg", ".jpx", ".tif", ".jb2"} {
+		if n.skip(fileName + ext) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ImagePlacementsFile is the name of the file holding the image placements written by ExtractImages.
+const ImagePlacementsFile = "imagePlacements.json"
+
+// imagePlacementsJSON returns the placements of the images of selected pages
+// referring to the files the images got extracted to.
+func imagePlacementsJSON(ctx *pdf.Context, selectedPages pdf.IntSet, files map[int]string) ([]byte, error) {
+
+	pp := []pdf.ImagePlacement{}
+
+	for _, pageNr := range pdf.SortedSelectedPages(selectedPages) {
+
+		placements, err := pdf.PageImagePlacements(ctx.XRefTable, pageNr)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range placements {
+			p.File = files[p.ObjNr]
+			pp = append(pp, p)
+		}
+	}
+
+	return json.MarshalIndent(map[string][]pdf.ImagePlacement{"placements": pp}, "", "\t")
+}
+
+// ExtractedFile is a file generated by an extraction.
+type ExtractedFile struct {
+	Name   string // The file name as written to disk.
+	PageNr int    // The page the resource got extracted for, 0 for document level resources.
+	ObjNr  int    // The object number of the resource, 0 for inline images and generated files.
+	Data   []byte
+
+	// Set for images only.
+	resourceName string
+	sd           *pdf.StreamDict
+}
+
+// extractSink consumes the files generated by an extraction instead of writing them to disk.
+type extractSink func(f ExtractedFile) error
+
+// doExtractImages writes the images of selected pages to disk or passes them to sink if not nil.
+func doExtractImages(ctx *pdf.Context, selectedPages pdf.IntSet, sink extractSink) ([]string, error) {
+	var written []string
+	visited := pdf.IntSet{}
+	masks := pdf.MaskObjNrs(ctx)
+	files := map[int]string{}
+	namer := newExtractFileNamer(ctx, imageFileNameTemplate)
+	isFile := sink == nil
+
+	write := func(filename, resourceName string, sd *pdf.StreamDict, pageNr, objNr int) error {
+
+		if isFile && namer.skipImage(filename) {
+			log.Info.Printf("skipping existing image file %s\n", filename)
+			return nil
+		}
+
+		filename, b, err := pdf.WriteImage(ctx.XRefTable, filename, sd, objNr, isFile)
+		if err != nil || filename == "" {
+			return err
+		}
+
+		if !isFile {
+			f := ExtractedFile{Name: filename, PageNr: pageNr, ObjNr: objNr, Data: b, resourceName: resourceName, sd: sd}
+			if err = sink(f); err != nil {
+				return err
+			}
+		}
+
+		written = append(written, filename)
+		if objNr > 0 {
+			files[objNr] = filepath.Base(filename)
+		}
+
+		return nil
+	}
+
+	for _, pageNr := range pdf.SortedSelectedPages(selectedPages) {
+
+		log.Info.Printf("writing images for page %d\n", pageNr)
+
+		for _, objNr := range imageObjNrs(ctx, pageNr, masks) {
+
+			if visited[objNr] {
+				continue
+			}
+
+			visited[objNr] = true
+
+			output, err := pdf.ExtractImageData(ctx, objNr)
+			if err != nil {
+				return nil, err
+			}
+
+			if output == nil {
+				continue
+			}
+
+			resourceName := output.ResourceNames[0]
+			filename := namer.fileName(resourceName, pageNr, objNr)
+
+			if err = write(filename, resourceName, output.ImageDict, pageNr, objNr); err != nil {
+				return nil, err
+			}
+		}
+
+		sds, err := pdf.ExtractInlineImages(ctx, pageNr)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, sd := range sds {
+
+			resourceName := fmt.Sprintf("Inline%d", i+1)
+			filename := namer.fileName(resourceName, pageNr, 0)
+
+			if err = write(filename, resourceName, sd, pageNr, 0); err != nil {
+				return nil, err
+			}
+		}
+
+	}
+
+	if ctx.ExtractImagePlacements {
+
+		bb, err := imagePlacementsJSON(ctx, selectedPages, files)
+		if err != nil {
+			return nil, err
+		}
+
+		f := ExtractedFile{Name: filepath.Join(ctx.Write.DirName, ImagePlacementsFile), Data: bb}
+
+		ok, err := namer.write(sink, f)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			written = append(written, f.Name)
+		}
+	}
+
+	return written, nil
+}
+
+// ImagePlacements returns the placements of the image XObjects painted on selected pages of fileIn.
+func ImagePlacements(fileIn string, selectedPages []string, config *pdf.Configuration) ([]pdf.ImagePlacement, error) {
+
+	ctx, _, _, _, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.EXTRACTIMAGES), time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	pages, err := pagesForPageSelection(ctx.PageCount, selectedPages)
+	if err != nil {
+		return nil, err
+	}
+
+	ensureSelectedPages(ctx, &pages)
+
+	var pp []pdf.ImagePlacement
+
+	for _, pageNr := range pdf.SortedSelectedPages(pages) {
+		placements, err := pdf.PageImagePlacements(ctx.XRefTable, pageNr)
+		if err != nil {
+			return nil, err
+		}
+		pp = append(pp, placements...)
+	}
+
+	return pp, nil
+}
+
+// ExtractImages dumps embedded image resources from fileIn into dirOut for selected pages
+// and returns the paths of the files written.
 func ExtractImages(cmd *Command) ([]string, error) {
 
 	fileIn := *cmd.InFile
 	dirOut := *cmd.OutDir
 	pageSelection := cmd.PageSelection
-	config := cmd.Config
+	config := configForMode(cmd.Config, cmd.Mode)
 
 	fromStart := time.Now()
 
@@ -532,7 +1225,7 @@ func ExtractImages(cmd *Command) ([]string, error) {
 	ensureSelectedPages(ctx, &pages)
 
 	ctx.Write.DirName = dirOut
-	_, err = doExtractImages(ctx, pages, true)
+	files, err := doExtractImages(ctx, pages, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -542,34 +1235,123 @@ func ExtractImages(cmd *Command) ([]string, error) {
 	log.Stats.Printf("XRefTable:\n%s\n", ctx)
 	pdf.TimingStats("write images", durRead, durVal, durOpt, durWrite, durTotal)
 
-	return nil, nil
+	return files, nil
 }
 
-// ExtractImagesFromIO dumps embedded image from an IO reader into a byte array.
-func ExtractImagesFromIO(file io.Reader) ([]byte, error) {
-	var selectedPages []string
-	config := pdf.NewDefaultConfiguration()
+// ExtractedImage is an image extracted in memory along with its properties.
+type ExtractedImage struct {
+	PageNr       int    // The page the image got extracted for.
+	ObjNr        int    // The object number of the image, 0 for inline images.
+	ResourceName string // The name of the image in the page resources or Inline1, Inline2.. for inline images.
+	ColorSpace   string // The color space family eg. DeviceRGB, ICCBased or Indexed.
+	Width        int
+	Height       int
+	Data         []byte // The image encoded as MIME.
+	MIME         string // The media type of Data eg. image/png.
+}
 
-	b, err := ioutil.ReadAll(file)
+// The media types of the image formats used for extraction.
+var imageMIMETypes = map[string]string{
+	".png": "image/png",
+	".jpg": "image/jpeg",
+	".tif": "image/tiff",
+	".jpx": "image/jpx",
+	".jb2": "image/x-jbig2",
+}
+
+// colorSpaceFamily returns the name of the color space family of the image sd.
+func colorSpaceFamily(ctx *pdf.Context, sd *pdf.StreamDict) string {
+
+	o, err := ctx.DereferenceDictEntry(sd.Dict, "ColorSpace")
+	if err != nil || o == nil {
+		return ""
+	}
+
+	switch cs := o.(type) {
+	case pdf.Name:
+		return cs.Value()
+	case pdf.Array:
+		if len(cs) > 0 {
+			if n, ok := cs[0].(pdf.Name); ok {
+				return n.Value()
+			}
+		}
+	}
+
+	return ""
+}
+
+func newExtractedImage(ctx *pdf.Context, f ExtractedFile) ExtractedImage {
+
+	img := ExtractedImage{
+		PageNr:       f.PageNr,
+		ObjNr:        f.ObjNr,
+		ResourceName: f.resourceName,
+		Data:         f.Data,
+		MIME:         imageMIMETypes[filepath.Ext(f.Name)],
+	}
+
+	if f.sd != nil {
+		img.ColorSpace = colorSpaceFamily(ctx, f.sd)
+		if w := f.sd.IntEntry("Width"); w != nil {
+			img.Width = *w
+		}
+		if h := f.sd.IntEntry("Height"); h != nil {
+			img.Height = *h
+		}
+	}
+
+	return img
+}
+
+// ExtractImageSlicesFromContext returns the images of selected pages of ctx in memory along with their properties.
+func ExtractImageSlicesFromContext(ctx *pdf.Context, selectedPages []string) ([]ExtractedImage, error) {
+
+	ff, err := ExtractImagesFromContext(ctx, selectedPages)
 	if err != nil {
 		return nil, err
 	}
 
-	var img []byte
+	images := make([]ExtractedImage, len(ff))
+	for i, f := range ff {
+		images[i] = newExtractedImage(ctx, f)
+	}
 
-	ctx, err := ReadContext(bytes.NewReader(b), "", 0, config)
+	return images, nil
+}
+
+// ExtractImageSlices returns the images of selected pages of the PDF read from r in memory along with their properties.
+// If no pages are selected the images of all pages get extracted.
+func ExtractImageSlices(r io.Reader, selectedPages []string, config *pdf.Configuration) ([]ExtractedImage, error) {
+
+	config = configForMode(config, pdf.EXTRACTIMAGES)
+
+	b, err := ioutil.ReadAll(r)
 	if err != nil {
 		return nil, err
 	}
 
-	err = OptimizeContext(ctx)
-
+	ctx, err := ReadContext(bytes.NewReader(b), "", int64(len(b)), config)
 	if err != nil {
 		return nil, err
 	}
 
-	for i := 0;i< ctx.PageCount ;i++  {
-		selectedPages = append(selectedPages, strconv.Itoa(i+1))
+	return ExtractImageSlicesFromContext(ctx, selectedPages)
+}
+
+// prepareExtraction validates and optimizes ctx if necessary and returns the pages selected.
+func prepareExtraction(ctx *pdf.Context, selectedPages []string) (pdf.IntSet, error) {
+
+	if !ctx.Valid {
+		if err := ValidateContext(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if !ctx.Optimized {
+		if err := OptimizeContext(ctx); err != nil {
+			return nil, err
+		}
 	}
 
 	pages, err := pagesForPageSelection(ctx.PageCount, selectedPages)
@@ -579,12 +1361,74 @@ func ExtractImagesFromIO(file io.Reader) ([]byte, error) {
 
 	ensureSelectedPages(ctx, &pages)
 
-	img, err = doExtractImages(ctx, pages, false)
+	return pages, nil
+}
+
+// extractFromContext collects the files generated by extract for selected pages of ctx in memory.
+func extractFromContext(ctx *pdf.Context, selectedPages []string,
+	extract func(ctx *pdf.Context, pages pdf.IntSet, sink extractSink) ([]string, error)) ([]ExtractedFile, error) {
+
+	pages, err := prepareExtraction(ctx, selectedPages)
+	if err != nil {
+		return nil, err
+	}
+
+	// Generate plain file names.
+	dirName := ctx.Write.DirName
+	ctx.Write.DirName = ""
+	defer func() { ctx.Write.DirName = dirName }()
+
+	ff := []ExtractedFile{}
+
+	_, err = extract(ctx, pages, func(f ExtractedFile) error {
+		ff = append(ff, f)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ff, nil
+}
+
+// ExtractImagesFromContext returns the images of selected pages of ctx in memory
+// encoded the same way ExtractImages writes them.
+func ExtractImagesFromContext(ctx *pdf.Context, selectedPages []string) ([]ExtractedFile, error) {
+	return extractFromContext(ctx, selectedPages, doExtractImages)
+}
+
+// ExtractFontsFromContext returns the embedded fontfiles of selected pages of ctx in memory.
+func ExtractFontsFromContext(ctx *pdf.Context, selectedPages []string) ([]ExtractedFile, error) {
+	return extractFromContext(ctx, selectedPages, doExtractFonts)
+}
+
+// ExtractContentFromContext returns the decoded content streams of selected pages of ctx in memory.
+func ExtractContentFromContext(ctx *pdf.Context, selectedPages []string) ([]ExtractedFile, error) {
+	return extractFromContext(ctx, selectedPages, doExtractContent)
+}
+
+// ExtractMetadataFromContext returns all metadata streams of ctx in memory.
+func ExtractMetadataFromContext(ctx *pdf.Context) ([]ExtractedFile, error) {
+	return extractFromContext(ctx, nil, func(ctx *pdf.Context, pages pdf.IntSet, sink extractSink) ([]string, error) {
+		return doExtractMetadata(ctx, sink)
+	})
+}
+
+// ExtractAttachmentsFromContext returns the embedded files of ctx in memory.
+// If no files are specified all embedded files get extracted.
+func ExtractAttachmentsFromContext(ctx *pdf.Context, files []string) ([]ExtractedFile, error) {
+
+	ff := []ExtractedFile{}
+
+	err := pdf.AttachExtractFunc(ctx, stringSet(files), func(fileName string, content []byte) error {
+		ff = append(ff, ExtractedFile{Name: fileName, Data: content})
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	return img, nil
+	return ff, nil
 }
 
 func fontObjNrs(ctx *pdf.Context, page int) []int {
@@ -600,56 +1444,59 @@ func fontObjNrs(ctx *pdf.Context, page int) []int {
 	return o
 }
 
-func doExtractFonts(ctx *pdf.Context, selectedPages pdf.IntSet) error {
+// doExtractFonts writes the fonts of selected pages to disk or passes them to sink if not nil.
+func doExtractFonts(ctx *pdf.Context, selectedPages pdf.IntSet, sink extractSink) ([]string, error) {
 
+	var written []string
 	visited := pdf.IntSet{}
+	namer := newExtractFileNamer(ctx, fontFileNameTemplate)
 
-	for p, v := range selectedPages {
-
-		if v {
-
-			log.Info.Printf("writing fonts for page %d\n", p)
+	for _, p := range pdf.SortedSelectedPages(selectedPages) {
 
-			for _, objNr := range fontObjNrs(ctx, p) {
+		log.Info.Printf("writing fonts for page %d\n", p)
 
-				if visited[objNr] {
-					continue
-				}
+		for _, objNr := range fontObjNrs(ctx, p) {
 
-				visited[objNr] = true
+			if visited[objNr] {
+				continue
+			}
 
-				fo, err := pdf.ExtractFontData(ctx, objNr)
-				if err != nil {
-					return err
-				}
+			visited[objNr] = true
 
-				if fo == nil {
-					continue
-				}
+			fo, err := pdf.ExtractFontData(ctx, objNr)
+			if err != nil {
+				return nil, err
+			}
 
-				fileName := fmt.Sprintf("%s/%s_%d_%d.%s", ctx.Write.DirName, fo.ResourceNames[0], p, objNr, fo.Extension)
+			if fo == nil {
+				continue
+			}
 
-				err = ioutil.WriteFile(fileName, fo.Data, os.ModePerm)
-				if err != nil {
-					return err
-				}
+			f := ExtractedFile{Name: namer.fileName(fo.ResourceNames[0], p, objNr) + "." + fo.Extension, PageNr: p, ObjNr: objNr, Data: fo.Data}
 
+			ok, err := namer.write(sink, f)
+			if err != nil {
+				return nil, err
 			}
 
+			if ok {
+				written = append(written, f.Name)
+			}
 		}
 
 	}
 
-	return nil
+	return written, nil
 }
 
-// ExtractFonts dumps embedded fontfiles from fileIn into dirOut for selected pages.
+// ExtractFonts dumps embedded fontfiles from fileIn into dirOut for selected pages
+// and returns the paths of the files written.
 func ExtractFonts(cmd *Command) ([]string, error) {
 
 	fileIn := *cmd.InFile
 	dirOut := *cmd.OutDir
 	pageSelection := cmd.PageSelection
-	config := cmd.Config
+	config := configForMode(cmd.Config, cmd.Mode)
 
 	fromStart := time.Now()
 
@@ -670,7 +1517,7 @@ func ExtractFonts(cmd *Command) ([]string, error) {
 	ensureSelectedPages(ctx, &pages)
 
 	ctx.Write.DirName = dirOut
-	err = doExtractFonts(ctx, pages)
+	files, err := doExtractFonts(ctx, pages, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -680,16 +1527,25 @@ func ExtractFonts(cmd *Command) ([]string, error) {
 	log.Stats.Printf("XRefTable:\n%s\n", ctx)
 	pdf.TimingStats("write fonts", durRead, durVal, durOpt, durWrite, durTotal)
 
-	return nil, nil
+	return files, nil
 }
 
 // ExtractPages generates single page PDF files from fileIn in dirOut for selected pages.
+// If the command has an output file all selected pages get written into this file instead.
+// The paths of the files written get returned in page order, cmd.PageFiles also carries their page numbers.
 func ExtractPages(cmd *Command) ([]string, error) {
 
+	if cmd.OutFile != nil {
+		if err := extractPagesToFile(*cmd.InFile, *cmd.OutFile, cmd.PageSelection, cmd.Config); err != nil {
+			return nil, err
+		}
+		return []string{*cmd.OutFile}, nil
+	}
+
 	fileIn := *cmd.InFile
 	dirOut := *cmd.OutDir
 	pageSelection := cmd.PageSelection
-	config := cmd.Config
+	config := configForMode(cmd.Config, cmd.Mode)
 
 	fromStart := time.Now()
 
@@ -707,7 +1563,7 @@ func ExtractPages(cmd *Command) ([]string, error) {
 		return nil, err
 	}
 
-	err = writeSinglePagePDFs(ctx, pages, dirOut)
+	pf, err := writeSinglePagePDFs(ctx, pages, dirOut, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -717,7 +1573,77 @@ func ExtractPages(cmd *Command) ([]string, error) {
 	log.Stats.Printf("XRefTable:\n%s\n", ctx)
 	pdf.TimingStats("write PDFs", durRead, durVal, durOpt, durWrite, durTotal)
 
-	return nil, nil
+	cmd.PageFiles = pf
+
+	return pageFileNames(pf), nil
+}
+
+// prepareExtractPages sets up ctx for writing the selected pages into a single file.
+func prepareExtractPages(ctx *pdf.Context, pageSelection []string) error {
+
+	pages, err := pagesForPageSelection(ctx.PageCount, pageSelection)
+	if err != nil {
+		return err
+	}
+
+	ensureSelectedPages(ctx, &pages)
+
+	ctx.Write.Command = "Trim"
+	ctx.Write.ExtractPages = pages
+
+	return nil
+}
+
+func extractPagesToFile(fileIn, fileOut string, pageSelection []string, config *pdf.Configuration) error {
+
+	fromStart := time.Now()
+
+	fmt.Printf("extracting pages from %s into %s ...\n", fileIn, fileOut)
+
+	ctx, durRead, durVal, err := readAndValidate(fileIn, config, fromStart)
+	if err != nil {
+		return err
+	}
+
+	fromWrite := time.Now()
+
+	if err = prepareExtractPages(ctx, pageSelection); err != nil {
+		return err
+	}
+
+	dirName, fileName := filepath.Split(fileOut)
+	ctx.Write.DirName = dirName
+	ctx.Write.FileName = fileName
+
+	if err = Write(ctx); err != nil {
+		return err
+	}
+
+	durWrite := time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	log.Stats.Printf("XRefTable:\n%s\n", ctx)
+	pdf.TimingStats("write PDF", durRead, durVal, 0, durWrite, durTotal)
+
+	return nil
+}
+
+// ExtractPagesToWriter writes the selected pages of the PDF read from rs as a single PDF to w.
+func ExtractPagesToWriter(rs io.ReadSeeker, w io.Writer, pageSelection []string, config *pdf.Configuration) error {
+
+	ctx, err := ReadContext(rs, "", 0, config)
+	if err != nil {
+		return err
+	}
+
+	if err = ValidateContext(ctx); err != nil {
+		return err
+	}
+
+	if err = prepareExtractPages(ctx, pageSelection); err != nil {
+		return err
+	}
+
+	return WriteContext(ctx, w)
 }
 
 func contentObjNrs(ctx *pdf.Context, page int) ([]int, error) {
@@ -785,69 +1711,194 @@ func contentObjNrs(ctx *pdf.Context, page int) ([]int, error) {
 	return objNrs, nil
 }
 
-func doExtractContent(ctx *pdf.Context, selectedPages pdf.IntSet) error {
+// doExtractContent writes the content streams of selected pages to disk or passes them to sink if not nil.
+func doExtractContent(ctx *pdf.Context, selectedPages pdf.IntSet, sink extractSink) ([]string, error) {
 
+	var written []string
 	visited := pdf.IntSet{}
+	namer := newExtractFileNamer(ctx, contentFileNameTemplate)
 
-	for p, v := range selectedPages {
+	for _, p := range pdf.SortedSelectedPages(selectedPages) {
 
-		if v {
+		log.Info.Printf("writing content for page %d\n", p)
+
+		objNrs, err := contentObjNrs(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+
+		if objNrs == nil {
+			continue
+		}
+
+		for _, objNr := range objNrs {
+
+			if visited[objNr] {
+				continue
+			}
 
-			log.Info.Printf("writing content for page %d\n", p)
+			visited[objNr] = true
 
-			objNrs, err := contentObjNrs(ctx, p)
+			b, err := pdf.ExtractStreamData(ctx, objNr)
 			if err != nil {
-				return err
+				return nil, err
 			}
 
-			if objNrs == nil {
+			if b == nil {
 				continue
 			}
 
-			for _, objNr := range objNrs {
+			f := ExtractedFile{Name: namer.fileName("Content", p, objNr) + ".txt", PageNr: p, ObjNr: objNr, Data: b}
 
-				if visited[objNr] {
-					continue
-				}
+			ok, err := namer.write(sink, f)
+			if err != nil {
+				return nil, err
+			}
 
-				visited[objNr] = true
+			if ok {
+				written = append(written, f.Name)
+			}
+		}
 
-				b, err := pdf.ExtractStreamData(ctx, objNr)
-				if err != nil {
-					return err
-				}
+	}
 
-				if b == nil {
-					continue
-				}
+	return written, nil
+}
 
-				fileName := fmt.Sprintf("%s/%d_%d.txt", ctx.Write.DirName, p, objNr)
+// ExtractContent dumps "PDF source" files from fileIn into dirOut for selected pages
+// and returns the paths of the files written.
+func ExtractContent(cmd *Command) ([]string, error) {
 
-				err = ioutil.WriteFile(fileName, b, os.ModePerm)
-				if err != nil {
-					return err
-				}
+	fileIn := *cmd.InFile
+	dirOut := *cmd.OutDir
+	pageSelection := cmd.PageSelection
+	config := configForMode(cmd.Config, cmd.Mode)
 
-			}
+	fromStart := time.Now()
+
+	fmt.Printf("extracting content from %s into %s ...\n", fileIn, dirOut)
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, config, fromStart)
+	if err != nil {
+		return nil, err
+	}
+
+	fromWrite := time.Now()
+
+	pages, err := pagesForPageSelection(ctx.PageCount, pageSelection)
+	if err != nil {
+		return nil, err
+	}
+
+	ensureSelectedPages(ctx, &pages)
+
+	ctx.Write.DirName = dirOut
+	files, err := doExtractContent(ctx, pages, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	durWrite := time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	log.Stats.Printf("XRefTable:\n%s\n", ctx)
+	pdf.TimingStats("write content", durRead, durVal, durOpt, durWrite, durTotal)
+
+	return files, nil
+}
+
+// ExtractTextFromContext returns the text of page pageNr as UTF-8.
+// Glyphs are mapped to Unicode using the font encodings and ToUnicode CMaps,
+// spaces and line breaks are inferred from the glyph positions.
+func ExtractTextFromContext(ctx *pdf.Context, pageNr int) (string, error) {
+
+	if pageNr < 1 || pageNr > ctx.PageCount {
+		return "", pdf.NewError(pdf.ErrPageOutOfRange, "ExtractTextFromContext: invalid page number %d, must be between 1 and %d", pageNr, ctx.PageCount)
+	}
+
+	return pdf.PageText(ctx.XRefTable, pageNr)
+}
+
+// ExtractTextSpansFromContext returns the text of page pageNr as runs of text sharing font and font size
+// along with their bounding boxes in default user space.
+func ExtractTextSpansFromContext(ctx *pdf.Context, pageNr int) ([]pdf.TextSpan, error) {
+
+	if pageNr < 1 || pageNr > ctx.PageCount {
+		return nil, pdf.NewError(pdf.ErrPageOutOfRange, "ExtractTextSpansFromContext: invalid page number %d, must be between 1 and %d", pageNr, ctx.PageCount)
+	}
+
+	return pdf.PageTextSpans(ctx.XRefTable, pageNr)
+}
+
+// TextSpans returns the text spans of selected pages of fileIn.
+func TextSpans(fileIn string, selectedPages []string, config *pdf.Configuration) ([]pdf.TextSpan, error) {
+
+	ctx, _, _, _, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.EXTRACTTEXT), time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	pages, err := pagesForPageSelection(ctx.PageCount, selectedPages)
+	if err != nil {
+		return nil, err
+	}
+
+	ensureSelectedPages(ctx, &pages)
+
+	var spans []pdf.TextSpan
+
+	for _, pageNr := range pdf.SortedSelectedPages(pages) {
+		ss, err := pdf.PageTextSpans(ctx.XRefTable, pageNr)
+		if err != nil {
+			return nil, err
+		}
+		spans = append(spans, ss...)
+	}
+
+	return spans, nil
+}
+
+// doExtractText writes the text of selected pages to disk or passes it to sink if not nil.
+func doExtractText(ctx *pdf.Context, selectedPages pdf.IntSet, sink extractSink) ([]string, error) {
+
+	var written []string
+	namer := newExtractFileNamer(ctx, textFileNameTemplate)
+
+	for _, p := range pdf.SortedSelectedPages(selectedPages) {
+
+		log.Info.Printf("writing text for page %d\n", p)
+
+		s, err := ExtractTextFromContext(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+
+		f := ExtractedFile{Name: namer.fileName("Text", p, 0) + ".txt", PageNr: p, Data: []byte(s)}
 
+		ok, err := namer.write(sink, f)
+		if err != nil {
+			return nil, err
 		}
 
+		if ok {
+			written = append(written, f.Name)
+		}
 	}
 
-	return nil
+	return written, nil
 }
 
-// ExtractContent dumps "PDF source" files from fileIn into dirOut for selected pages.
-func ExtractContent(cmd *Command) ([]string, error) {
+// ExtractText writes the text of selected pages of fileIn into dirOut as one UTF-8 file per page
+// and returns the paths of the files written.
+func ExtractText(cmd *Command) ([]string, error) {
 
 	fileIn := *cmd.InFile
 	dirOut := *cmd.OutDir
 	pageSelection := cmd.PageSelection
-	config := cmd.Config
+	config := configForMode(cmd.Config, cmd.Mode)
 
 	fromStart := time.Now()
 
-	fmt.Printf("extracting content from %s into %s ...\n", fileIn, dirOut)
+	fmt.Printf("extracting text from %s into %s ...\n", fileIn, dirOut)
 
 	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, config, fromStart)
 	if err != nil {
@@ -864,7 +1915,7 @@ func ExtractContent(cmd *Command) ([]string, error) {
 	ensureSelectedPages(ctx, &pages)
 
 	ctx.Write.DirName = dirOut
-	err = doExtractContent(ctx, pages)
+	files, err := doExtractText(ctx, pages, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -872,84 +1923,90 @@ func ExtractContent(cmd *Command) ([]string, error) {
 	durWrite := time.Since(fromWrite).Seconds()
 	durTotal := time.Since(fromStart).Seconds()
 	log.Stats.Printf("XRefTable:\n%s\n", ctx)
-	pdf.TimingStats("write content", durRead, durVal, durOpt, durWrite, durTotal)
+	pdf.TimingStats("write text", durRead, durVal, durOpt, durWrite, durTotal)
 
-	return nil, nil
+	return files, nil
 }
 
-func extractMetadataStream(ctx *pdf.Context, obj pdf.Object, objNr int, dt string) error {
+func extractMetadataStream(ctx *pdf.Context, obj pdf.Object, objNr int, dt string, namer *extractFileNamer, sink extractSink) (string, error) {
 
 	ir, _ := obj.(pdf.IndirectRef)
 	sObjNr := ir.ObjectNumber.Value()
 	b, err := pdf.ExtractStreamData(ctx, sObjNr)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	if b == nil {
-		return nil
+		return "", nil
 	}
 
-	fileName := fmt.Sprintf("%s/%d_%s.txt", ctx.Write.DirName, objNr, dt)
+	f := ExtractedFile{Name: filepath.Join(ctx.Write.DirName, fmt.Sprintf("%d_%s.txt", objNr, dt)), ObjNr: sObjNr, Data: b}
+
+	ok, err := namer.write(sink, f)
+	if err != nil || !ok {
+		return "", err
+	}
 
-	return ioutil.WriteFile(fileName, b, os.ModePerm)
+	return f.Name, nil
 }
 
-func doExtractMetadata(ctx *pdf.Context, selectedPages pdf.IntSet) error {
+// doExtractMetadata writes all metadata streams to disk or passes them to sink if not nil.
+func doExtractMetadata(ctx *pdf.Context, sink extractSink) ([]string, error) {
+
+	var written []string
+	namer := newExtractFileNamer(ctx, "")
 
 	for k, v := range ctx.XRefTable.Table {
 		if v.Free || v.Compressed {
 			continue
 		}
-		switch d := v.Object.(type) {
-
-		case pdf.Dict:
 
-			o, found := d.Find("Metadata")
-			if !found || o == nil {
-				continue
-			}
+		var d pdf.Dict
 
-			dt := "unknown"
-			if d.Type() != nil {
-				dt = *d.Type()
-			}
+		switch o := v.Object.(type) {
 
-			err := extractMetadataStream(ctx, o, k, dt)
-			if err != nil {
-				return err
-			}
+		case pdf.Dict:
+			d = o
 
 		case pdf.StreamDict:
+			d = o.Dict
 
-			o, found := d.Find("Metadata")
-			if !found || o == nil {
-				continue
-			}
+		default:
+			continue
+		}
 
-			dt := "unknown"
-			if d.Type() != nil {
-				dt = *d.Type()
-			}
+		o, found := d.Find("Metadata")
+		if !found || o == nil {
+			continue
+		}
 
-			err := extractMetadataStream(ctx, o, k, dt)
-			if err != nil {
-				return err
-			}
+		dt := "unknown"
+		if d.Type() != nil {
+			dt = *d.Type()
+		}
+
+		fileName, err := extractMetadataStream(ctx, o, k, dt, namer, sink)
+		if err != nil {
+			return nil, err
+		}
 
+		if fileName != "" {
+			written = append(written, fileName)
 		}
 	}
 
-	return nil
+	return written, nil
 }
 
-// ExtractMetadata dumps all metadata dict entries for fileIn into dirOut.
+// ExtractMetadata dumps all metadata dict entries for fileIn into dirOut
+// and returns the paths of the files written.
 func ExtractMetadata(cmd *Command) ([]string, error) {
 
 	fileIn := *cmd.InFile
 	dirOut := *cmd.OutDir
 	pageSelection := cmd.PageSelection
-	config := cmd.Config
+	config := configForMode(cmd.Config, cmd.Mode)
 
 	fromStart := time.Now()
 
@@ -970,7 +2027,7 @@ func ExtractMetadata(cmd *Command) ([]string, error) {
 	ensureSelectedPages(ctx, &pages)
 
 	ctx.Write.DirName = dirOut
-	err = doExtractMetadata(ctx, pages)
+	files, err := doExtractMetadata(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -980,138 +2037,1838 @@ func ExtractMetadata(cmd *Command) ([]string, error) {
 	log.Stats.Printf("XRefTable:\n%s\n", ctx)
 	pdf.TimingStats("write metadata", durRead, durVal, durOpt, durWrite, durTotal)
 
-	return nil, nil
+	return files, nil
 }
 
 // Trim generates a trimmed version of fileIn containing all pages selected.
 func Trim(cmd *Command) ([]string, error) {
 
-	fileIn := *cmd.InFile
-	fileOut := *cmd.OutFile
-	pageSelection := cmd.PageSelection
-	config := cmd.Config
+	fileIn := *cmd.InFile
+	fileOut := *cmd.OutFile
+	pageSelection := cmd.PageSelection
+	config := configForMode(cmd.Config, cmd.Mode)
+
+	// pageSelection points to an empty slice if flag pages was omitted.
+
+	fromStart := time.Now()
+
+	fmt.Printf("trimming %s ...\n", fileIn)
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, config, fromStart)
+	if err != nil {
+		return nil, err
+	}
+
+	fromWrite := time.Now()
+
+	pages, err := pagesForPageSelection(ctx.PageCount, pageSelection)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx.Write.Command = "Trim"
+	ctx.Write.ExtractPages = pages
+
+	var digests [][]byte
+	if ctx.VerifyPages {
+		digests, err = pageDigests(ctx, pages)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	dirName, fileName := filepath.Split(fileOut)
+	ctx.Write.DirName = dirName
+	ctx.Write.FileName = fileName
+
+	err = Write(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if ctx.VerifyPages {
+		err = verifyPages(ctx, digests)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	durWrite := time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	logOperationStats(ctx, "trim, write", durRead, durVal, durOpt, durWrite, durTotal)
+
+	return nil, nil
+}
+
+// Collect assembles fileOut from the pages of fileIn in the order given by cmd.PageSelection.
+// Pages may be repeated and ranges may be descending, e.g. "3,1,1,5-2".
+func Collect(cmd *Command) ([]string, error) {
+
+	fileIn := *cmd.InFile
+	fileOut := *cmd.OutFile
+	config := cmd.Config
+
+	fromStart := time.Now()
+
+	fmt.Printf("collecting pages of %s ...\n", fileIn)
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.COLLECT), fromStart)
+	if err != nil {
+		return nil, err
+	}
+
+	fromWrite := time.Now()
+
+	pages, err := pagesForPageSequence(ctx.PageCount, cmd.PageSelection)
+	if err != nil {
+		return nil, err
+	}
+
+	var digests [][]byte
+	if ctx.VerifyPages {
+		for _, p := range pages {
+			d, err := ctx.PageContentDigest(p)
+			if err != nil {
+				return nil, err
+			}
+			digests = append(digests, d)
+		}
+	}
+
+	if err = ctx.Collect(pages); err != nil {
+		return nil, err
+	}
+
+	// Like trimming drop document level data referring to pages and annotations.
+	ctx.Write.Command = "Trim"
+
+	dirName, fileName := filepath.Split(fileOut)
+	ctx.Write.DirName = dirName
+	ctx.Write.FileName = fileName
+
+	err = Write(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if ctx.VerifyPages {
+		err = verifyPages(ctx, digests)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	durWrite := time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	logOperationStats(ctx, "collect, write", durRead, durVal, durOpt, durWrite, durTotal)
+
+	return nil, nil
+}
+
+// RemovePages writes fileIn without the pages selected by cmd.PageSelection to fileOut.
+// Resources only used by removed pages get dropped.
+func RemovePages(cmd *Command) ([]string, error) {
+
+	fileIn := *cmd.InFile
+	fileOut := *cmd.OutFile
+	config := cmd.Config
+
+	fromStart := time.Now()
+
+	fmt.Printf("removing pages from %s ...\n", fileIn)
+
+	ctx, durRead, durVal, err := readAndValidate(fileIn, configForMode(config, pdf.REMOVEPAGES), fromStart)
+	if err != nil {
+		return nil, err
+	}
+
+	pages, err := pagesForPageSelection(ctx.PageCount, cmd.PageSelection)
+	if err != nil {
+		return nil, err
+	}
+	if len(pages) == 0 {
+		return nil, errors.New("RemovePages: missing page selection")
+	}
+
+	var digests [][]byte
+	if ctx.VerifyPages {
+		kept := pdf.IntSet{}
+		for i := 1; i <= ctx.PageCount; i++ {
+			kept[i] = !pages[i]
+		}
+		digests, err = pageDigests(ctx, kept)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err = ctx.RemovePages(pages); err != nil {
+		return nil, err
+	}
+
+	// Optimize the remaining pages only.
+	fromOpt := time.Now()
+	if err = OptimizeContext(ctx); err != nil {
+		return nil, err
+	}
+	durOpt := time.Since(fromOpt).Seconds()
+
+	fromWrite := time.Now()
+
+	// Like trimming drop document level data referring to pages.
+	ctx.Write.Command = "Trim"
+
+	dirName, fileName := filepath.Split(fileOut)
+	ctx.Write.DirName = dirName
+	ctx.Write.FileName = fileName
+
+	err = Write(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if ctx.VerifyPages {
+		err = verifyPages(ctx, digests)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	durWrite := time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	logOperationStats(ctx, "remove pages, write", durRead, durVal, durOpt, durWrite, durTotal)
+
+	return nil, nil
+}
+
+// Crop sets the crop box described by cmd.Crop for the pages of fileIn selected by cmd.PageSelection
+// and writes the result to fileOut. All pages are selected by default.
+func Crop(cmd *Command) ([]string, error) {
+
+	fileIn := *cmd.InFile
+	fileOut := *cmd.OutFile
+	config := cmd.Config
+
+	if cmd.Crop == nil {
+		return nil, errors.New("Crop: missing crop description")
+	}
+
+	fromStart := time.Now()
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.CROP), fromStart)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("cropping %s ...\n", fileIn)
+
+	from := time.Now()
+
+	pages, err := pagesForPageSelection(ctx.PageCount, cmd.PageSelection)
+	if err != nil {
+		return nil, err
+	}
+
+	ensureSelectedPages(ctx, &pages)
+
+	if err = ctx.Crop(pages, cmd.Crop); err != nil {
+		return nil, err
+	}
+
+	durCrop := time.Since(from).Seconds()
+
+	fromWrite := time.Now()
+
+	dirName, fileName := filepath.Split(fileOut)
+	ctx.Write.DirName = dirName
+	ctx.Write.FileName = fileName
+
+	err = Write(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	durWrite := durCrop + time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	logOperationStats(ctx, "crop, write", durRead, durVal, durOpt, durWrite, durTotal)
+
+	return nil, nil
+}
+
+// Resize scales the content of the pages of fileIn selected by cmd.PageSelection to the page size described by cmd.Resize
+// and writes the result to fileOut. All pages are selected by default.
+func Resize(cmd *Command) ([]string, error) {
+
+	fileIn := *cmd.InFile
+	fileOut := *cmd.OutFile
+	config := cmd.Config
+
+	if cmd.Resize == nil {
+		return nil, errors.New("Resize: missing resize description")
+	}
+
+	fromStart := time.Now()
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.RESIZE), fromStart)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("resizing %s ...\n", fileIn)
+
+	from := time.Now()
+
+	pages, err := pagesForPageSelection(ctx.PageCount, cmd.PageSelection)
+	if err != nil {
+		return nil, err
+	}
+
+	ensureSelectedPages(ctx, &pages)
+
+	if err = ctx.Resize(pages, cmd.Resize); err != nil {
+		return nil, err
+	}
+
+	durResize := time.Since(from).Seconds()
+
+	fromWrite := time.Now()
+
+	dirName, fileName := filepath.Split(fileOut)
+	ctx.Write.DirName = dirName
+	ctx.Write.FileName = fileName
+
+	err = Write(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	durWrite := durResize + time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	logOperationStats(ctx, "resize, write", durRead, durVal, durOpt, durWrite, durTotal)
+
+	return nil, nil
+}
+
+// Poster splits the pages of fileIn selected by cmd.PageSelection into tiles as described by cmd.Poster
+// and writes the result to fileOut. All pages are selected by default.
+func Poster(cmd *Command) ([]string, error) {
+
+	fileIn := *cmd.InFile
+	fileOut := *cmd.OutFile
+	config := cmd.Config
+
+	if cmd.Poster == nil {
+		return nil, errors.New("Poster: missing poster description")
+	}
+
+	fromStart := time.Now()
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.POSTER), fromStart)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("splitting %s into tiles ...\n", fileIn)
+
+	from := time.Now()
+
+	pages, err := pagesForPageSelection(ctx.PageCount, cmd.PageSelection)
+	if err != nil {
+		return nil, err
+	}
+
+	ensureSelectedPages(ctx, &pages)
+
+	if err = ctx.Poster(pages, cmd.Poster); err != nil {
+		return nil, err
+	}
+
+	durPoster := time.Since(from).Seconds()
+
+	fromWrite := time.Now()
+
+	dirName, fileName := filepath.Split(fileOut)
+	ctx.Write.DirName = dirName
+	ctx.Write.FileName = fileName
+
+	err = Write(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	durWrite := durPoster + time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	logOperationStats(ctx, "poster, write", durRead, durVal, durOpt, durWrite, durTotal)
+
+	return nil, nil
+}
+
+// Encrypt fileIn and write result to fileOut.
+// The returned lines describe the encryption and key derivation used.
+func Encrypt(cmd *Command) ([]string, error) {
+
+	ctx, err := optimize(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return pdf.EncryptionReport(ctx), nil
+}
+
+// Decrypt fileIn and write result to fileOut.
+func Decrypt(cmd *Command) ([]string, error) {
+	return Optimize(cmd)
+}
+
+func DecryptIO(file io.Reader, fileOut string) error {
+	return OptimizeIO(file, fileOut, true)
+}
+
+// ChangeUserPassword of fileIn and write result to fileOut.
+func ChangeUserPassword(cmd *Command) ([]string, error) {
+	cmd.Config.Mode = pdf.CHANGEUPW
+	cmd.Config.UserPW = *cmd.PWOld
+	cmd.Config.UserPWNew = cmd.PWNew
+	return Optimize(cmd)
+}
+
+// ChangeOwnerPassword of fileIn and write result to fileOut.
+func ChangeOwnerPassword(cmd *Command) ([]string, error) {
+	cmd.Config.Mode = pdf.CHANGEOPW
+	cmd.Config.OwnerPW = *cmd.PWOld
+	cmd.Config.OwnerPWNew = cmd.PWNew
+	return Optimize(cmd)
+}
+
+// ListAttachments returns a list of embedded file attachments.
+func ListAttachments(fileIn string, config *pdf.Configuration) ([]string, error) {
+
+	fromStart := time.Now()
+
+	//fmt.Println("Attachments:")
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, config, fromStart)
+	if err != nil {
+		return nil, err
+	}
+
+	fromWrite := time.Now()
+
+	list, err := pdf.AttachList(ctx.XRefTable)
+	if err != nil {
+		return nil, err
+	}
+
+	durWrite := time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	log.Stats.Printf("XRefTable:\n%s\n", ctx)
+	pdf.TimingStats("list files", durRead, durVal, durOpt, durWrite, durTotal)
+
+	return list, nil
+}
+
+// AddAttachments embeds files into a PDF.
+func AddAttachments(fileIn string, files []string, config *pdf.Configuration) error {
+	return AddAttachmentsWithOptionsToFile(fileIn, "", attachmentOptions(files), config)
+}
+
+// AddAttachmentsToFile embeds files into fileIn and writes the result to fileOut.
+// An empty fileOut updates fileIn.
+func AddAttachmentsToFile(fileIn, fileOut string, files []string, config *pdf.Configuration) error {
+	return AddAttachmentsWithOptionsToFile(fileIn, fileOut, attachmentOptions(files), config)
+}
+
+func attachmentOptions(files []string) map[string]pdf.AttachmentOptions {
+	m := map[string]pdf.AttachmentOptions{}
+	for _, fileName := range files {
+		m[fileName] = pdf.AttachmentOptions{}
+	}
+	return m
+}
+
+// AddAttachmentsWithOptions embeds files into a PDF using individual options per file.
+func AddAttachmentsWithOptions(fileIn string, files map[string]pdf.AttachmentOptions, config *pdf.Configuration) error {
+	return AddAttachmentsWithOptionsToFile(fileIn, "", files, config)
+}
+
+// AddAttachmentsWithOptionsToFile embeds files into fileIn using individual options per file and writes the result to fileOut.
+// An empty fileOut updates fileIn.
+func AddAttachmentsWithOptionsToFile(fileIn, fileOut string, files map[string]pdf.AttachmentOptions, config *pdf.Configuration) error {
+
+	fromStart := time.Now()
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.ADDATTACHMENTS), fromStart)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("adding %d attachments to %s ...\n", len(files), fileIn)
+
+	from := time.Now()
+	var ok bool
+
+	ok, err = pdf.AttachAddWithOptions(ctx.XRefTable, files)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		fmt.Println("no attachment added.")
+		if fileOut == "" || fileOut == fileIn {
+			return nil
+		}
+	}
+
+	durAdd := time.Since(from).Seconds()
+
+	fromWrite := time.Now()
+
+	if err = writeFileOut(ctx, fileIn, fileOut); err != nil {
+		return err
+	}
+
+	durWrite := durAdd + time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	logOperationStats(ctx, "add attachment, write", durRead, durVal, durOpt, durWrite, durTotal)
+
+	return nil
+}
+
+// AddAttachmentsToWriter embeds files into the PDF read from rs and writes the result to w.
+func AddAttachmentsToWriter(rs io.ReadSeeker, w io.Writer, files []string, config *pdf.Configuration) error {
+
+	ctx, err := readValidateAndOptimizeContext(rs, configForMode(config, pdf.ADDATTACHMENTS))
+	if err != nil {
+		return err
+	}
+
+	if _, err = pdf.AttachAddWithOptions(ctx.XRefTable, attachmentOptions(files)); err != nil {
+		return err
+	}
+
+	return WriteContext(ctx, w)
+}
+
+// RemoveAttachments deletes embedded files from a PDF.
+// No files means all attachments get removed.
+func RemoveAttachments(fileIn string, files []string, config *pdf.Configuration) error {
+	return RemoveAttachmentsToFile(fileIn, "", files, config)
+}
+
+// RemoveAttachmentsToFile deletes embedded files from fileIn and writes the result to fileOut.
+// No files means all attachments get removed. An empty fileOut updates fileIn.
+func RemoveAttachmentsToFile(fileIn, fileOut string, files []string, config *pdf.Configuration) error {
+
+	fromStart := time.Now()
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.REMOVEATTACHMENTS), fromStart)
+	if err != nil {
+		return err
+	}
+
+	if len(files) > 0 {
+		fmt.Printf("removing %d attachments from %s ...\n", len(files), fileIn)
+	} else {
+		fmt.Printf("removing all attachments from %s ...\n", fileIn)
+	}
+
+	from := time.Now()
+
+	var ok bool
+	ok, err = pdf.AttachRemove(ctx.XRefTable, stringSet(files))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		fmt.Println("no attachment removed.")
+		if fileOut == "" || fileOut == fileIn {
+			return nil
+		}
+	}
+
+	durRemove := time.Since(from).Seconds()
+
+	fromWrite := time.Now()
+
+	if err = writeFileOut(ctx, fileIn, fileOut); err != nil {
+		return err
+	}
+
+	durWrite := durRemove + time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	logOperationStats(ctx, "remove att, write", durRead, durVal, durOpt, durWrite, durTotal)
+
+	return nil
+}
+
+// RemoveAttachmentsToWriter deletes embedded files from the PDF read from rs and writes the result to w.
+// No files means all attachments get removed.
+func RemoveAttachmentsToWriter(rs io.ReadSeeker, w io.Writer, files []string, config *pdf.Configuration) error {
+
+	ctx, err := readValidateAndOptimizeContext(rs, configForMode(config, pdf.REMOVEATTACHMENTS))
+	if err != nil {
+		return err
+	}
+
+	if _, err = pdf.AttachRemove(ctx.XRefTable, stringSet(files)); err != nil {
+		return err
+	}
+
+	return WriteContext(ctx, w)
+}
+
+// readValidateAndOptimizeContext reads, validates and optimizes the PDF read from rs.
+func readValidateAndOptimizeContext(rs io.ReadSeeker, config *pdf.Configuration) (*pdf.Context, error) {
+
+	ctx, err := ReadContext(rs, "", 0, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = ValidateContext(ctx); err != nil {
+		return nil, err
+	}
+
+	if err = OptimizeContext(ctx); err != nil {
+		return nil, err
+	}
+
+	return ctx, nil
+}
+
+// writeFileOut writes ctx to fileOut, or back to fileIn if fileOut is empty.
+func writeFileOut(ctx *pdf.Context, fileIn, fileOut string) error {
+
+	if fileOut == "" {
+		fileOut = fileIn
+	}
+
+	dirName, fileName := filepath.Split(fileOut)
+	ctx.Write.DirName = dirName
+	ctx.Write.FileName = fileName
+
+	return Write(ctx)
+}
+
+// ExtractAttachments extracts embedded files from a PDF.
+func ExtractAttachments(fileIn, dirOut string, files []string, config *pdf.Configuration) error {
+
+	fromStart := time.Now()
+
+	fmt.Printf("extracting attachments from %s into %s ...\n", fileIn, dirOut)
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, config, fromStart)
+	if err != nil {
+		return err
+	}
+
+	fromWrite := time.Now()
+
+	ctx.Write.DirName = dirOut
+	err = pdf.AttachExtract(ctx, stringSet(files))
+	if err != nil {
+		return err
+	}
+
+	durWrite := time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	log.Stats.Printf("XRefTable:\n%s\n", ctx)
+	pdf.TimingStats("write files", durRead, durVal, durOpt, durWrite, durTotal)
+
+	return nil
+}
+
+// ListPermissions returns a list of user access permissions.
+func ListPermissions(fileIn string, config *pdf.Configuration) ([]string, error) {
+
+	fromStart := time.Now()
+
+	//fmt.Println("User access permissions:")
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, config, fromStart)
+	if err != nil {
+		return nil, err
+	}
+
+	fromList := time.Now()
+	list := pdf.Permissions(ctx)
+
+	durList := time.Since(fromList).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	log.Stats.Printf("XRefTable:\n%s\n", ctx)
+	pdf.TimingStats("list permissions", durRead, durVal, durOpt, durList, durTotal)
+
+	return list, nil
+}
+
+// AddPermissions sets the user access permissions.
+func AddPermissions(fileIn string, config *pdf.Configuration) error {
+	return AddPermissionsToFile(fileIn, "", config)
+}
+
+// AddPermissionsToFile sets the user access permissions of fileIn and writes the result to fileOut.
+// An empty fileOut updates fileIn.
+func AddPermissionsToFile(fileIn, fileOut string, config *pdf.Configuration) error {
+
+	fromStart := time.Now()
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.ADDPERMISSIONS), fromStart)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("adding permissions to %s ...\n", fileIn)
+
+	fromWrite := time.Now()
+
+	if err = writeFileOut(ctx, fileIn, fileOut); err != nil {
+		return err
+	}
+
+	durWrite := time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	logOperationStats(ctx, "write", durRead, durVal, durOpt, durWrite, durTotal)
+
+	return nil
+}
+
+// AddPermissionsToWriter sets the user access permissions of the PDF read from rs and writes the result to w.
+func AddPermissionsToWriter(rs io.ReadSeeker, w io.Writer, config *pdf.Configuration) error {
+
+	ctx, err := readValidateAndOptimizeContext(rs, configForMode(config, pdf.ADDPERMISSIONS))
+	if err != nil {
+		return err
+	}
+
+	return WriteContext(ctx, w)
+}
+
+// AddSignatureFields adds empty signature fields to fileIn and writes the result to fileOut.
+func AddSignatureFields(fileIn, fileOut string, fields []pdf.SignatureField, config *pdf.Configuration) error {
+
+	fromStart := time.Now()
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.ADDFORMFIELDS), fromStart)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("adding %d signature fields to %s ...\n", len(fields), fileIn)
+
+	fromWrite := time.Now()
+
+	err = pdf.AddSignatureFields(ctx.XRefTable, fields)
+	if err != nil {
+		return err
+	}
+
+	dirName, fileName := filepath.Split(fileOut)
+	ctx.Write.DirName = dirName
+	ctx.Write.FileName = fileName
+
+	err = Write(ctx)
+	if err != nil {
+		return err
+	}
+
+	durWrite := time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	logOperationStats(ctx, "add signature fields, write", durRead, durVal, durOpt, durWrite, durTotal)
+
+	return nil
+}
+
+// AddFormFields adds text fields, check boxes and signature fields to fileIn and writes the result to fileOut.
+func AddFormFields(fileIn, fileOut string, fields []pdf.FormField, config *pdf.Configuration) error {
+
+	fromStart := time.Now()
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.ADDFORMFIELDS), fromStart)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("adding %d form fields to %s ...\n", len(fields), fileIn)
+
+	fromWrite := time.Now()
+
+	err = pdf.AddFormFields(ctx.XRefTable, fields)
+	if err != nil {
+		return err
+	}
+
+	dirName, fileName := filepath.Split(fileOut)
+	ctx.Write.DirName = dirName
+	ctx.Write.FileName = fileName
+
+	err = Write(ctx)
+	if err != nil {
+		return err
+	}
+
+	durWrite := time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	logOperationStats(ctx, "add form fields, write", durRead, durVal, durOpt, durWrite, durTotal)
+
+	return nil
+}
+
+// LockFormFields makes the form fields of fileIn named by their fully qualified names read-only
+// and writes the result to fileOut. If fieldNames is empty all fields get locked.
+// If removeActions is true any actions triggered by the locked fields are removed too.
+func LockFormFields(fileIn, fileOut string, fieldNames []string, removeActions bool, config *pdf.Configuration) error {
+
+	fromStart := time.Now()
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.LOCKFORMFIELDS), fromStart)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("locking form fields of %s ...\n", fileIn)
+
+	fromWrite := time.Now()
+
+	n, err := pdf.LockFormFields(ctx.XRefTable, fieldNames, removeActions)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		fmt.Println("no form fields found.")
+	}
+
+	dirName, fileName := filepath.Split(fileOut)
+	ctx.Write.DirName = dirName
+	ctx.Write.FileName = fileName
+
+	err = Write(ctx)
+	if err != nil {
+		return err
+	}
+
+	durWrite := time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	logOperationStats(ctx, "lock form fields, write", durRead, durVal, durOpt, durWrite, durTotal)
+
+	return nil
+}
+
+// SetTabOrder sets the tab order of the annotations of the selected pages of fileIn and writes the result to fileOut.
+// order is one of R (row), C (column), S (structure) or W (widget), an empty order removes the tab order.
+func SetTabOrder(fileIn, fileOut string, selectedPages []string, order string, config *pdf.Configuration) error {
+
+	fromStart := time.Now()
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.SETTABORDER), fromStart)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("setting tab order of %s ...\n", fileIn)
+
+	fromWrite := time.Now()
+
+	pages, err := pagesForPageSelection(ctx.PageCount, selectedPages)
+	if err != nil {
+		return err
+	}
+
+	ensureSelectedPages(ctx, &pages)
+
+	err = pdf.SetTabOrder(ctx.XRefTable, pages, order)
+	if err != nil {
+		return err
+	}
+
+	dirName, fileName := filepath.Split(fileOut)
+	ctx.Write.DirName = dirName
+	ctx.Write.FileName = fileName
+
+	err = Write(ctx)
+	if err != nil {
+		return err
+	}
+
+	durWrite := time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	logOperationStats(ctx, "set tab order, write", durRead, durVal, durOpt, durWrite, durTotal)
+
+	return nil
+}
+
+// SetCalculationOrder sets the order in which the form fields of fileIn named by their fully qualified names get recalculated
+// and writes the result to fileOut. An empty list removes the calculation order.
+func SetCalculationOrder(fileIn, fileOut string, fieldNames []string, config *pdf.Configuration) error {
+
+	fromStart := time.Now()
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.SETCALCULATIONORDER), fromStart)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("setting calculation order of %s ...\n", fileIn)
+
+	fromWrite := time.Now()
+
+	err = pdf.SetCalculationOrder(ctx.XRefTable, fieldNames)
+	if err != nil {
+		return err
+	}
+
+	dirName, fileName := filepath.Split(fileOut)
+	ctx.Write.DirName = dirName
+	ctx.Write.FileName = fileName
+
+	err = Write(ctx)
+	if err != nil {
+		return err
+	}
+
+	durWrite := time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	logOperationStats(ctx, "set calculation order, write", durRead, durVal, durOpt, durWrite, durTotal)
+
+	return nil
+}
+
+// FillFormFields sets the values of form fields by fully qualified field name and regenerates their appearances.
+func FillFormFields(fileIn, fileOut string, values map[string]string, config *pdf.Configuration) error {
+
+	fromStart := time.Now()
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.FILLFORMFIELDS), fromStart)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("filling form fields of %s ...\n", fileIn)
+
+	fromWrite := time.Now()
+
+	err = pdf.FillFormFields(ctx.XRefTable, values)
+	if err != nil {
+		return err
+	}
+
+	dirName, fileName := filepath.Split(fileOut)
+	ctx.Write.DirName = dirName
+	ctx.Write.FileName = fileName
+
+	err = Write(ctx)
+	if err != nil {
+		return err
+	}
+
+	durWrite := time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	logOperationStats(ctx, "fill form fields, write", durRead, durVal, durOpt, durWrite, durTotal)
+
+	return nil
+}
+
+// ChoiceOptions returns the options of a list box or combo box.
+func ChoiceOptions(fileIn, fieldName string, config *pdf.Configuration) ([]pdf.ChoiceOption, error) {
+
+	fromStart := time.Now()
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, config, fromStart)
+	if err != nil {
+		return nil, err
+	}
+
+	fromList := time.Now()
+
+	options, err := pdf.ChoiceOptions(ctx.XRefTable, fieldName)
+	if err != nil {
+		return nil, err
+	}
+
+	durList := time.Since(fromList).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	pdf.TimingStats("list choice options", durRead, durVal, durOpt, durList, durTotal)
+
+	return options, nil
+}
+
+// SetChoiceOptions replaces the options of a list box or combo box.
+func SetChoiceOptions(fileIn, fileOut, fieldName string, options []pdf.ChoiceOption, config *pdf.Configuration) error {
+
+	fromStart := time.Now()
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.SETCHOICEOPTIONS), fromStart)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("setting options of %s in %s ...\n", fieldName, fileIn)
+
+	fromWrite := time.Now()
+
+	err = pdf.SetChoiceOptions(ctx.XRefTable, fieldName, options)
+	if err != nil {
+		return err
+	}
+
+	dirName, fileName := filepath.Split(fileOut)
+	ctx.Write.DirName = dirName
+	ctx.Write.FileName = fileName
+
+	err = Write(ctx)
+	if err != nil {
+		return err
+	}
+
+	durWrite := time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	logOperationStats(ctx, "set choice options, write", durRead, durVal, durOpt, durWrite, durTotal)
+
+	return nil
+}
+
+// ButtonExportValues returns the values check boxes and radio button groups accept by fully qualified field name.
+func ButtonExportValues(fileIn string, config *pdf.Configuration) (map[string][]string, error) {
+
+	fromStart := time.Now()
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, config, fromStart)
+	if err != nil {
+		return nil, err
+	}
+
+	fromList := time.Now()
+
+	m, err := pdf.ButtonExportValues(ctx.XRefTable)
+	if err != nil {
+		return nil, err
+	}
+
+	durList := time.Since(fromList).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	pdf.TimingStats("list button export values", durRead, durVal, durOpt, durList, durTotal)
+
+	return m, nil
+}
+
+// AddStampAnnotations adds rubber stamp annotations to fileIn and writes the result to fileOut.
+func AddStampAnnotations(fileIn, fileOut string, stamps []pdf.StampAnnotation, config *pdf.Configuration) error {
+
+	fromStart := time.Now()
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.ADDANNOTATIONS), fromStart)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("adding %d stamps to %s ...\n", len(stamps), fileIn)
+
+	fromWrite := time.Now()
+
+	err = pdf.AddStampAnnotations(ctx.XRefTable, stamps)
+	if err != nil {
+		return err
+	}
+
+	dirName, fileName := filepath.Split(fileOut)
+	ctx.Write.DirName = dirName
+	ctx.Write.FileName = fileName
+
+	err = Write(ctx)
+	if err != nil {
+		return err
+	}
+
+	durWrite := time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	logOperationStats(ctx, "add stamp annotations, write", durRead, durVal, durOpt, durWrite, durTotal)
+
+	return nil
+}
+
+// AddFreeTextAnnotations adds free text annotations to fileIn and writes the result to fileOut.
+func AddFreeTextAnnotations(fileIn, fileOut string, annots []pdf.FreeTextAnnotation, config *pdf.Configuration) error {
+
+	fromStart := time.Now()
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.ADDANNOTATIONS), fromStart)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("adding %d free text annotations to %s ...\n", len(annots), fileIn)
+
+	fromWrite := time.Now()
+
+	err = pdf.AddFreeTextAnnotations(ctx.XRefTable, annots)
+	if err != nil {
+		return err
+	}
+
+	dirName, fileName := filepath.Split(fileOut)
+	ctx.Write.DirName = dirName
+	ctx.Write.FileName = fileName
+
+	err = Write(ctx)
+	if err != nil {
+		return err
+	}
+
+	durWrite := time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	logOperationStats(ctx, "add free text annotations, write", durRead, durVal, durOpt, durWrite, durTotal)
+
+	return nil
+}
+
+// FlattenAnnotations renders annotations of the selected pages into the page content.
+// If subtypes is not empty only annotations of these subtypes get flattened.
+func FlattenAnnotations(fileIn, fileOut string, selectedPages, subtypes []string, config *pdf.Configuration) error {
+
+	fromStart := time.Now()
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.FLATTENANNOTATIONS), fromStart)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("flattening annotations of %s ...\n", fileIn)
+
+	fromWrite := time.Now()
+
+	pages, err := pagesForPageSelection(ctx.PageCount, selectedPages)
+	if err != nil {
+		return err
+	}
+
+	ensureSelectedPages(ctx, &pages)
+
+	n, err := pdf.FlattenAnnotations(ctx.XRefTable, pages, subtypes)
+	if err != nil {
+		return err
+	}
+
+	log.Info.Printf("flattened %d annotations\n", n)
+
+	dirName, fileName := filepath.Split(fileOut)
+	ctx.Write.DirName = dirName
+	ctx.Write.FileName = fileName
+
+	err = Write(ctx)
+	if err != nil {
+		return err
+	}
+
+	durWrite := time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	logOperationStats(ctx, "flatten annotations, write", durRead, durVal, durOpt, durWrite, durTotal)
+
+	return nil
+}
+
+// Grayscale converts either the images or the stamps and watermarks of the selected pages of fileIn to grayscale.
+func Grayscale(fileIn, fileOut string, selectedPages []string, mode pdf.GrayscaleMode, config *pdf.Configuration) error {
+
+	fromStart := time.Now()
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.GRAYSCALE), fromStart)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("converting %s to grayscale ...\n", fileIn)
+
+	fromWrite := time.Now()
+
+	pages, err := pagesForPageSelection(ctx.PageCount, selectedPages)
+	if err != nil {
+		return err
+	}
+
+	ensureSelectedPages(ctx, &pages)
+
+	n, err := pdf.Grayscale(ctx.XRefTable, pages, mode)
+	if err != nil {
+		return err
+	}
+
+	log.Info.Printf("converted %d objects to grayscale\n", n)
+
+	dirName, fileName := filepath.Split(fileOut)
+	ctx.Write.DirName = dirName
+	ctx.Write.FileName = fileName
+
+	err = Write(ctx)
+	if err != nil {
+		return err
+	}
+
+	durWrite := time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	logOperationStats(ctx, "grayscale, write", durRead, durVal, durOpt, durWrite, durTotal)
+
+	return nil
+}
+
+// Booklet imposes the pages of fileIn 2-up in saddle-stitch order and writes the result to fileOut.
+// The output can be printed duplex and folded into a booklet, blank pages pad the page count to a multiple of 4.
+func Booklet(fileIn, fileOut string, config *pdf.Configuration) error {
+
+	fromStart := time.Now()
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.BOOKLET), fromStart)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("creating booklet from %s ...\n", fileIn)
+
+	fromWrite := time.Now()
+
+	if err = pdf.Booklet(ctx.XRefTable); err != nil {
+		return err
+	}
+
+	dirName, fileName := filepath.Split(fileOut)
+	ctx.Write.DirName = dirName
+	ctx.Write.FileName = fileName
+
+	err = Write(ctx)
+	if err != nil {
+		return err
+	}
+
+	durWrite := time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	logOperationStats(ctx, "booklet, write", durRead, durVal, durOpt, durWrite, durTotal)
+
+	return nil
+}
+
+// annotationsFile is the JSON document used for annotation exchange.
+type annotationsFile struct {
+	Annotations []pdf.Annotation `json:"annotations"`
+}
+
+// ExportAnnotations writes the markup annotations of selected pages of fileIn to jsonFile.
+func ExportAnnotations(fileIn, jsonFile string, selectedPages []string, config *pdf.Configuration) error {
+
+	fromStart := time.Now()
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.EXPORTANNOTATIONS), fromStart)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("exporting annotations of %s to %s ...\n", fileIn, jsonFile)
+
+	fromWrite := time.Now()
+
+	pages, err := pagesForPageSelection(ctx.PageCount, selectedPages)
+	if err != nil {
+		return err
+	}
+
+	ensureSelectedPages(ctx, &pages)
+
+	annots, err := pdf.ExportAnnotations(ctx.XRefTable, pages)
+	if err != nil {
+		return err
+	}
+
+	if annots == nil {
+		annots = []pdf.Annotation{}
+	}
+
+	bb, err := json.MarshalIndent(annotationsFile{Annotations: annots}, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	if err = pdf.WriteFile(ctx.FileSystem(), jsonFile, bb, pdf.ExtractFilePerm); err != nil {
+		return err
+	}
+
+	durWrite := time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	pdf.TimingStats("export annotations", durRead, durVal, durOpt, durWrite, durTotal)
+
+	return nil
+}
+
+// ImportAnnotations adds the annotations of jsonFile to fileIn and writes the result to fileOut.
+// Annotations whose id matches an existing annotation of the same page update that annotation.
+func ImportAnnotations(fileIn, jsonFile, fileOut string, config *pdf.Configuration) error {
+
+	bb, err := fs.ReadFile(config.FileSystem(), jsonFile)
+	if err != nil {
+		return err
+	}
+
+	var af annotationsFile
+	if err = json.Unmarshal(bb, &af); err != nil {
+		return errors.Wrapf(err, "ImportAnnotations: %s", jsonFile)
+	}
+
+	fromStart := time.Now()
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.ADDANNOTATIONS), fromStart)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("importing annotations from %s into %s ...\n", jsonFile, fileIn)
+
+	fromWrite := time.Now()
+
+	n, err := pdf.ImportAnnotations(ctx.XRefTable, af.Annotations)
+	if err != nil {
+		return err
+	}
+
+	log.Info.Printf("imported %d annotations\n", n)
+
+	dirName, fileName := filepath.Split(fileOut)
+	ctx.Write.DirName = dirName
+	ctx.Write.FileName = fileName
+
+	err = Write(ctx)
+	if err != nil {
+		return err
+	}
+
+	durWrite := time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	logOperationStats(ctx, "import annotations, write", durRead, durVal, durOpt, durWrite, durTotal)
+
+	return nil
+}
+
+// Comments returns a summary of the markup annotations of selected pages of fileIn.
+func Comments(fileIn string, selectedPages []string, config *pdf.Configuration) ([]pdf.Comment, error) {
+
+	fromStart := time.Now()
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.EXTRACTTEXT), fromStart)
+	if err != nil {
+		return nil, err
+	}
+
+	fromList := time.Now()
+
+	pages, err := pagesForPageSelection(ctx.PageCount, selectedPages)
+	if err != nil {
+		return nil, err
+	}
+
+	ensureSelectedPages(ctx, &pages)
+
+	comments, err := pdf.Comments(ctx.XRefTable, pages)
+	if err != nil {
+		return nil, err
+	}
+
+	durList := time.Since(fromList).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	pdf.TimingStats("list comments", durRead, durVal, durOpt, durList, durTotal)
+
+	return comments, nil
+}
+
+func writeCommentsCSV(w io.Writer, comments []pdf.Comment) error {
+
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write([]string{"page", "type", "author", "date", "quote", "text"}); err != nil {
+		return err
+	}
+
+	for _, c := range comments {
+		date := ""
+		if c.Date != nil {
+			date = c.Date.Format(time.RFC3339)
+		}
+		if err := cw.Write([]string{strconv.Itoa(c.Page), c.Type, c.Author, date, c.Quote, c.Text}); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// ExportComments writes a comment summary of selected pages of fileIn to fileOut.
+// The format is JSON or CSV depending on the extension of fileOut.
+func ExportComments(fileIn, fileOut string, selectedPages []string, config *pdf.Configuration) error {
+
+	ext := strings.ToLower(filepath.Ext(fileOut))
+	if ext != ".json" && ext != ".csv" {
+		return errors.Errorf("ExportComments: unsupported format %q, use .json or .csv", ext)
+	}
+
+	comments, err := Comments(fileIn, selectedPages, config)
+	if err != nil {
+		return err
+	}
+
+	if comments == nil {
+		comments = []pdf.Comment{}
+	}
+
+	fmt.Printf("writing comment summary of %s to %s ...\n", fileIn, fileOut)
+
+	var b bytes.Buffer
+
+	if ext == ".csv" {
+		err = writeCommentsCSV(&b, comments)
+	} else {
+		var bb []byte
+		bb, err = json.MarshalIndent(map[string][]pdf.Comment{"comments": comments}, "", "\t")
+		b.Write(bb)
+	}
+	if err != nil {
+		return err
+	}
+
+	return pdf.WriteFile(config.FileSystem(), fileOut, b.Bytes(), pdf.ExtractFilePerm)
+}
+
+// AddCommentSummary appends summary pages listing the comments of selected pages of fileIn and writes the result to fileOut.
+func AddCommentSummary(fileIn, fileOut string, selectedPages []string, config *pdf.Configuration) error {
+
+	fromStart := time.Now()
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.ADDCOMMENTSUMMARY), fromStart)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("adding comment summary to %s ...\n", fileIn)
+
+	fromWrite := time.Now()
+
+	pages, err := pagesForPageSelection(ctx.PageCount, selectedPages)
+	if err != nil {
+		return err
+	}
+
+	ensureSelectedPages(ctx, &pages)
+
+	comments, err := pdf.Comments(ctx.XRefTable, pages)
+	if err != nil {
+		return err
+	}
+
+	n, err := pdf.AppendCommentSummary(ctx.XRefTable, comments)
+	if err != nil {
+		return err
+	}
+
+	log.Info.Printf("added %d summary pages for %d comments\n", n, len(comments))
+
+	dirName, fileName := filepath.Split(fileOut)
+	ctx.Write.DirName = dirName
+	ctx.Write.FileName = fileName
+
+	err = Write(ctx)
+	if err != nil {
+		return err
+	}
+
+	durWrite := time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	logOperationStats(ctx, "add comment summary, write", durRead, durVal, durOpt, durWrite, durTotal)
+
+	return nil
+}
+
+// AddMeasureAnnotations adds dimension lines, area polygons and perimeter polylines to fileIn and writes the result to fileOut.
+func AddMeasureAnnotations(fileIn, fileOut string, annots []pdf.MeasureAnnotation, config *pdf.Configuration) error {
+
+	fromStart := time.Now()
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.ADDANNOTATIONS), fromStart)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("adding %d measure annotations to %s ...\n", len(annots), fileIn)
+
+	fromWrite := time.Now()
+
+	err = pdf.AddMeasureAnnotations(ctx.XRefTable, annots)
+	if err != nil {
+		return err
+	}
+
+	dirName, fileName := filepath.Split(fileOut)
+	ctx.Write.DirName = dirName
+	ctx.Write.FileName = fileName
+
+	err = Write(ctx)
+	if err != nil {
+		return err
+	}
+
+	durWrite := time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	logOperationStats(ctx, "add measure annotations, write", durRead, durVal, durOpt, durWrite, durTotal)
+
+	return nil
+}
+
+// MeasureAnnotations returns the measuring Line, Polygon and PolyLine annotations of selected pages of fileIn.
+func MeasureAnnotations(fileIn string, selectedPages []string, config *pdf.Configuration) ([]pdf.MeasureAnnotation, error) {
+
+	fromStart := time.Now()
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, config, fromStart)
+	if err != nil {
+		return nil, err
+	}
+
+	fromList := time.Now()
+
+	pages, err := pagesForPageSelection(ctx.PageCount, selectedPages)
+	if err != nil {
+		return nil, err
+	}
+
+	ensureSelectedPages(ctx, &pages)
+
+	annots, err := pdf.MeasureAnnotations(ctx.XRefTable, pages)
+	if err != nil {
+		return nil, err
+	}
+
+	durList := time.Since(fromList).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	pdf.TimingStats("list measure annotations", durRead, durVal, durOpt, durList, durTotal)
+
+	return annots, nil
+}
+
+// ExportPageText writes the text of selected pages of fileIn into dirOut as one Markdown, HTML or JSON file per page.
+// format is either "md", "html" or "json". Headings, lists and columns are recognized by an approximate layout analysis.
+// JSON files hold the text spans of a page, see pdf.TextSpan.
+func ExportPageText(fileIn, dirOut string, selectedPages []string, format string, config *pdf.Configuration) error {
+
+	pageText := pdf.PageMarkdown
+	switch format {
+	case "md":
+	case "html":
+		pageText = pdf.PageHTML
+	case "json":
+		pageText = pdf.PageTextSpansJSON
+	default:
+		return errors.Errorf("ExportPageText: unsupported format %q, use md, html or json", format)
+	}
+
+	fromStart := time.Now()
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.EXTRACTTEXT), fromStart)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("exporting text from %s into %s ...\n", fileIn, dirOut)
+
+	fromWrite := time.Now()
+
+	pages, err := pagesForPageSelection(ctx.PageCount, selectedPages)
+	if err != nil {
+		return err
+	}
+
+	ensureSelectedPages(ctx, &pages)
+
+	_, base := filepath.Split(fileIn)
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+
+	for _, p := range pdf.SortedSelectedPages(pages) {
+
+		log.Info.Printf("exporting text of page %d\n", p)
+
+		s, err := pageText(ctx.XRefTable, p)
+		if err != nil {
+			return err
+		}
+
+		fileName := filepath.Join(dirOut, fmt.Sprintf("%s_%d.%s", base, p, format))
+
+		if err = pdf.WriteFile(ctx.FileSystem(), fileName, []byte(s), pdf.ExtractFilePerm); err != nil {
+			return err
+		}
+	}
+
+	durWrite := time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	log.Stats.Printf("XRefTable:\n%s\n", ctx)
+	pdf.TimingStats("write text", durRead, durVal, durOpt, durWrite, durTotal)
+
+	return nil
+}
+
+// ExportOCRText writes the text of selected pages of fileIn including word and line boxes to fileOut.
+// format is either "hocr" or "alto".
+func ExportOCRText(fileIn, fileOut string, selectedPages []string, format string, config *pdf.Configuration) error {
+
+	if format != "hocr" && format != "alto" {
+		return errors.Errorf("ExportOCRText: unsupported format %q, use hocr or alto", format)
+	}
+
+	fromStart := time.Now()
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.EXTRACTTEXT), fromStart)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("exporting %s from %s to %s ...\n", format, fileIn, fileOut)
+
+	fromWrite := time.Now()
+
+	pages, err := pagesForPageSelection(ctx.PageCount, selectedPages)
+	if err != nil {
+		return err
+	}
+
+	ensureSelectedPages(ctx, &pages)
+
+	b, err := pdf.OCRText(ctx.XRefTable, pdf.SortedSelectedPages(pages), format)
+	if err != nil {
+		return err
+	}
+
+	if err = pdf.WriteFile(ctx.FileSystem(), fileOut, b, pdf.ExtractFilePerm); err != nil {
+		return err
+	}
+
+	durWrite := time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	log.Stats.Printf("XRefTable:\n%s\n", ctx)
+	pdf.TimingStats("write "+format, durRead, durVal, durOpt, durWrite, durTotal)
+
+	return nil
+}
+
+// WordFrequencies returns per page and document wide word counts for selected pages of fileIn.
+func WordFrequencies(fileIn string, selectedPages []string, config *pdf.Configuration) (*pdf.WordStats, error) {
+
+	fromStart := time.Now()
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.EXTRACTTEXT), fromStart)
+	if err != nil {
+		return nil, err
+	}
+
+	fromList := time.Now()
+
+	pages, err := pagesForPageSelection(ctx.PageCount, selectedPages)
+	if err != nil {
+		return nil, err
+	}
+
+	ensureSelectedPages(ctx, &pages)
+
+	ws, err := pdf.WordFrequencies(ctx.XRefTable, pdf.SortedSelectedPages(pages))
+	if err != nil {
+		return nil, err
+	}
+
+	durList := time.Since(fromList).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	log.Stats.Printf("XRefTable:\n%s\n", ctx)
+	pdf.TimingStats("list word frequencies", durRead, durVal, durOpt, durList, durTotal)
+
+	return ws, nil
+}
+
+// DetectLanguages detects the language of selected pages of fileIn and of their text as a whole.
+func DetectLanguages(fileIn string, selectedPages []string, config *pdf.Configuration) (*pdf.Languages, error) {
+
+	fromStart := time.Now()
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.EXTRACTTEXT), fromStart)
+	if err != nil {
+		return nil, err
+	}
+
+	fromList := time.Now()
+
+	pages, err := pagesForPageSelection(ctx.PageCount, selectedPages)
+	if err != nil {
+		return nil, err
+	}
+
+	ensureSelectedPages(ctx, &pages)
+
+	l, err := pdf.DetectLanguages(ctx.XRefTable, pdf.SortedSelectedPages(pages))
+	if err != nil {
+		return nil, err
+	}
+
+	durList := time.Since(fromList).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	log.Stats.Printf("XRefTable:\n%s\n", ctx)
+	pdf.TimingStats("detect languages", durRead, durVal, durOpt, durList, durTotal)
+
+	return l, nil
+}
+
+// SetLanguage sets the document language of fileIn in the catalog /Lang and in the XMP metadata and writes the result to fileOut.
+// lang is a BCP 47 language tag like "en" or "de-CH", if empty the language is detected from the text of all pages.
+func SetLanguage(fileIn, fileOut, lang string, config *pdf.Configuration) error {
+
+	fromStart := time.Now()
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.SETLANGUAGE), fromStart)
+	if err != nil {
+		return err
+	}
+
+	fromWrite := time.Now()
+
+	if lang == "" {
+		pages := pdf.IntSet{}
+		ensureSelectedPages(ctx, &pages)
+		l, err := pdf.DetectLanguages(ctx.XRefTable, pdf.SortedSelectedPages(pages))
+		if err != nil {
+			return err
+		}
+		if l.Lang == "" {
+			return errors.Errorf("SetLanguage: unable to detect the language of %s", fileIn)
+		}
+		lang = l.Lang
+	}
+
+	fmt.Printf("setting language of %s to %s ...\n", fileIn, lang)
+
+	if err = pdf.SetLanguage(ctx.XRefTable, lang); err != nil {
+		return err
+	}
+
+	dirName, fileName := filepath.Split(fileOut)
+	ctx.Write.DirName = dirName
+	ctx.Write.FileName = fileName
+
+	err = Write(ctx)
+	if err != nil {
+		return err
+	}
+
+	durWrite := time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	logOperationStats(ctx, "set language, write", durRead, durVal, durOpt, durWrite, durTotal)
+
+	return nil
+}
+
+// AssociatedFiles returns the files associated with the document, pages and objects of fileIn.
+func AssociatedFiles(fileIn string, config *pdf.Configuration) ([]pdf.AssociatedFile, error) {
+
+	fromStart := time.Now()
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, config, fromStart)
+	if err != nil {
+		return nil, err
+	}
+
+	fromList := time.Now()
+
+	list, err := pdf.AssociatedFiles(ctx.XRefTable)
+	if err != nil {
+		return nil, err
+	}
+
+	durList := time.Since(fromList).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	log.Stats.Printf("XRefTable:\n%s\n", ctx)
+	pdf.TimingStats("list associated files", durRead, durVal, durOpt, durList, durTotal)
+
+	return list, nil
+}
 
-	// pageSelection points to an empty slice if flag pages was omitted.
+// AddAssociatedFiles embeds files into fileIn, associates them with the document, pages or objects and writes the result to fileOut.
+func AddAssociatedFiles(fileIn, fileOut string, files []pdf.AssociatedFile, config *pdf.Configuration) error {
 
 	fromStart := time.Now()
 
-	fmt.Printf("trimming %s ...\n", fileIn)
-
-	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, config, fromStart)
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.ADDATTACHMENTS), fromStart)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
+	fmt.Printf("adding %d associated files to %s ...\n", len(files), fileIn)
+
 	fromWrite := time.Now()
 
-	pages, err := pagesForPageSelection(ctx.PageCount, pageSelection)
-	if err != nil {
-		return nil, err
+	if err = pdf.AddAssociatedFiles(ctx.XRefTable, files); err != nil {
+		return err
 	}
 
-	ctx.Write.Command = "Trim"
-	ctx.Write.ExtractPages = pages
-
 	dirName, fileName := filepath.Split(fileOut)
 	ctx.Write.DirName = dirName
 	ctx.Write.FileName = fileName
 
 	err = Write(ctx)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	durWrite := time.Since(fromWrite).Seconds()
 	durTotal := time.Since(fromStart).Seconds()
-	logOperationStats(ctx, "trim, write", durRead, durVal, durOpt, durWrite, durTotal)
-
-	return nil, nil
-}
-
-// Encrypt fileIn and write result to fileOut.
-func Encrypt(cmd *Command) ([]string, error) {
-	return Optimize(cmd)
-}
-
-// Decrypt fileIn and write result to fileOut.
-func Decrypt(cmd *Command) ([]string, error) {
-	return Optimize(cmd)
-}
-
-func DecryptIO(file io.Reader, fileOut string) error {
-	return OptimizeIO(file, fileOut, true)
-}
-
-// ChangeUserPassword of fileIn and write result to fileOut.
-func ChangeUserPassword(cmd *Command) ([]string, error) {
-	cmd.Config.UserPW = *cmd.PWOld
-	cmd.Config.UserPWNew = cmd.PWNew
-	return Optimize(cmd)
-}
+	logOperationStats(ctx, "add associated files, write", durRead, durVal, durOpt, durWrite, durTotal)
 
-// ChangeOwnerPassword of fileIn and write result to fileOut.
-func ChangeOwnerPassword(cmd *Command) ([]string, error) {
-	cmd.Config.OwnerPW = *cmd.PWOld
-	cmd.Config.OwnerPWNew = cmd.PWNew
-	return Optimize(cmd)
+	return nil
 }
 
-// ListAttachments returns a list of embedded file attachments.
-func ListAttachments(fileIn string, config *pdf.Configuration) ([]string, error) {
+// ListUsageRights returns a list of usage rights signatures and other permission handlers.
+func ListUsageRights(fileIn string, config *pdf.Configuration) ([]string, error) {
 
 	fromStart := time.Now()
 
-	//fmt.Println("Attachments:")
-
 	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, config, fromStart)
 	if err != nil {
 		return nil, err
 	}
 
-	fromWrite := time.Now()
+	fromList := time.Now()
 
-	list, err := pdf.AttachList(ctx.XRefTable)
+	list, err := pdf.UsageRights(ctx.XRefTable)
 	if err != nil {
 		return nil, err
 	}
 
-	durWrite := time.Since(fromWrite).Seconds()
+	durList := time.Since(fromList).Seconds()
 	durTotal := time.Since(fromStart).Seconds()
-	log.Stats.Printf("XRefTable:\n%s\n", ctx)
-	pdf.TimingStats("list files", durRead, durVal, durOpt, durWrite, durTotal)
+	pdf.TimingStats("list usage rights", durRead, durVal, durOpt, durList, durTotal)
 
 	return list, nil
 }
 
-// AddAttachments embeds files into a PDF.
-func AddAttachments(fileIn string, files []string, config *pdf.Configuration) error {
+// RemoveUsageRights removes usage rights signatures from fileIn and writes the result to fileOut.
+// Usage rights signatures get invalidated anyway by any rewrite of a document.
+func RemoveUsageRights(fileIn, fileOut string, config *pdf.Configuration) error {
 
 	fromStart := time.Now()
 
-	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, config, fromStart)
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.REMOVEUSAGERIGHTS), fromStart)
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("adding %d attachments to %s ...\n", len(files), fileIn)
+	fmt.Printf("removing usage rights from %s ...\n", fileIn)
 
-	from := time.Now()
-	var ok bool
+	fromWrite := time.Now()
 
-	ok, err = pdf.AttachAdd(ctx.XRefTable, stringSet(files))
+	ok, err := pdf.RemoveUsageRights(ctx.XRefTable)
 	if err != nil {
 		return err
 	}
 	if !ok {
-		fmt.Println("no attachment added.")
-		return nil
+		fmt.Println("no usage rights found.")
 	}
 
-	durAdd := time.Since(from).Seconds()
-
-	fromWrite := time.Now()
-
-	fileOut := fileIn
 	dirName, fileName := filepath.Split(fileOut)
 	ctx.Write.DirName = dirName
 	ctx.Write.FileName = fileName
@@ -1121,194 +3878,258 @@ func AddAttachments(fileIn string, files []string, config *pdf.Configuration) er
 		return err
 	}
 
-	durWrite := durAdd + time.Since(fromWrite).Seconds()
+	durWrite := time.Since(fromWrite).Seconds()
 	durTotal := time.Since(fromStart).Seconds()
-	logOperationStats(ctx, "add attachment, write", durRead, durVal, durOpt, durWrite, durTotal)
+	logOperationStats(ctx, "remove usage rights, write", durRead, durVal, durOpt, durWrite, durTotal)
 
 	return nil
 }
 
-// RemoveAttachments deletes embedded files from a PDF.
-func RemoveAttachments(fileIn string, files []string, config *pdf.Configuration) error {
+// ToolFingerprints returns traces of the software that produced or modified fileIn.
+func ToolFingerprints(fileIn string, config *pdf.Configuration) ([]string, error) {
+
+	ctx, err := ReadContextFromFile(fileIn, config)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := ctx.FileSystem().Open(fileIn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return pdf.ToolFingerprints(ctx, f)
+}
+
+// AddWatermarks adds watermarks to all pages selected.
+func AddWatermarks(cmd *Command) ([]string, error) {
+
+	fileIn := *cmd.InFile
+	fileOut := *cmd.OutFile
+	pageSelection := cmd.PageSelection
+	wm := cmd.Watermark
+	config := configForMode(cmd.Config, cmd.Mode)
 
 	fromStart := time.Now()
 
 	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, config, fromStart)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if len(files) > 0 {
-		fmt.Printf("removing %d attachments from %s ...\n", len(files), fileIn)
-	} else {
-		fmt.Printf("removing all attachments from %s ...\n", fileIn)
-	}
+	fmt.Printf("%sing %s ...\n", wm.OnTopString(), fileIn)
 
 	from := time.Now()
 
-	var ok bool
-	ok, err = pdf.AttachRemove(ctx.XRefTable, stringSet(files))
+	pages, err := pagesForPageSelection(ctx.PageCount, pageSelection)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if !ok {
-		fmt.Println("no attachment removed.")
-		return nil
+
+	ensureSelectedPages(ctx, &pages)
+
+	err = pdf.AddWatermarks(ctx, pages, wm)
+	if err != nil {
+		return nil, err
 	}
 
-	durRemove := time.Since(from).Seconds()
+	log.Stats.Printf("XRefTable:\n%s\n", ctx)
+
+	durStamp := time.Since(from).Seconds()
 
 	fromWrite := time.Now()
 
-	fileOut := fileIn
 	dirName, fileName := filepath.Split(fileOut)
 	ctx.Write.DirName = dirName
 	ctx.Write.FileName = fileName
 
 	err = Write(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	durWrite := durRemove + time.Since(fromWrite).Seconds()
+	durWrite := durStamp + time.Since(fromWrite).Seconds()
 	durTotal := time.Since(fromStart).Seconds()
-	logOperationStats(ctx, "remove att, write", durRead, durVal, durOpt, durWrite, durTotal)
+	logOperationStats(ctx, "watermark, write", durRead, durVal, durOpt, durWrite, durTotal)
 
-	return nil
+	return nil, nil
 }
 
-// ExtractAttachments extracts embedded files from a PDF.
-func ExtractAttachments(fileIn, dirOut string, files []string, config *pdf.Configuration) error {
+// RemoveWatermarks removes all watermarks and stamps from selected pages of a file.
+func RemoveWatermarks(cmd *Command) ([]string, error) {
 
-	fromStart := time.Now()
+	fileIn := *cmd.InFile
+	fileOut := *cmd.OutFile
+	config := cmd.Config
 
-	fmt.Printf("extracting attachments from %s into %s ...\n", fileIn, dirOut)
+	fromStart := time.Now()
 
-	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, config, fromStart)
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.REMOVEWATERMARKS), fromStart)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	fromWrite := time.Now()
+	fmt.Printf("removing watermarks from %s ...\n", fileIn)
 
-	ctx.Write.DirName = dirOut
-	err = pdf.AttachExtract(ctx, stringSet(files))
+	from := time.Now()
+
+	pages, err := pagesForPageSelection(ctx.PageCount, cmd.PageSelection)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	durWrite := time.Since(fromWrite).Seconds()
-	durTotal := time.Since(fromStart).Seconds()
-	log.Stats.Printf("XRefTable:\n%s\n", ctx)
-	pdf.TimingStats("write files", durRead, durVal, durOpt, durWrite, durTotal)
+	ensureSelectedPages(ctx, &pages)
 
-	return nil
-}
+	if err = pdf.RemoveWatermarks(ctx, pages); err != nil {
+		return nil, err
+	}
 
-// ListPermissions returns a list of user access permissions.
-func ListPermissions(fileIn string, config *pdf.Configuration) ([]string, error) {
+	durRemove := time.Since(from).Seconds()
 
-	fromStart := time.Now()
+	fromWrite := time.Now()
 
-	//fmt.Println("User access permissions:")
+	dirName, fileName := filepath.Split(fileOut)
+	ctx.Write.DirName = dirName
+	ctx.Write.FileName = fileName
 
-	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, config, fromStart)
+	err = Write(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	fromList := time.Now()
-	list := pdf.Permissions(ctx)
-
-	durList := time.Since(fromList).Seconds()
+	durWrite := durRemove + time.Since(fromWrite).Seconds()
 	durTotal := time.Since(fromStart).Seconds()
-	log.Stats.Printf("XRefTable:\n%s\n", ctx)
-	pdf.TimingStats("list permissions", durRead, durVal, durOpt, durList, durTotal)
+	logOperationStats(ctx, "remove watermarks, write", durRead, durVal, durOpt, durWrite, durTotal)
 
-	return list, nil
+	return nil, nil
 }
 
-// AddPermissions sets the user access permissions.
-func AddPermissions(fileIn string, config *pdf.Configuration) error {
+// AddWatermarksFunc adds the watermarks or stamps computed by f for each selected page of fileIn and writes the result to fileOut.
+func AddWatermarksFunc(fileIn, fileOut string, selectedPages []string, f pdf.WatermarkFunc, config *pdf.Configuration) error {
 
 	fromStart := time.Now()
 
-	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, config, fromStart)
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.ADDWATERMARKS), fromStart)
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("adding permissions to %s ...\n", fileIn)
+	fmt.Printf("watermarking %s ...\n", fileIn)
+
+	from := time.Now()
+
+	pages, err := pagesForPageSelection(ctx.PageCount, selectedPages)
+	if err != nil {
+		return err
+	}
+
+	ensureSelectedPages(ctx, &pages)
+
+	if err = pdf.AddWatermarksFunc(ctx, pages, f); err != nil {
+		return err
+	}
+
+	durStamp := time.Since(from).Seconds()
 
 	fromWrite := time.Now()
 
-	fileOut := fileIn
 	dirName, fileName := filepath.Split(fileOut)
 	ctx.Write.DirName = dirName
 	ctx.Write.FileName = fileName
 
-	err = Write(ctx)
-	if err != nil {
+	if err = Write(ctx); err != nil {
 		return err
 	}
 
-	durWrite := time.Since(fromWrite).Seconds()
+	durWrite := durStamp + time.Since(fromWrite).Seconds()
 	durTotal := time.Since(fromStart).Seconds()
-	logOperationStats(ctx, "write", durRead, durVal, durOpt, durWrite, durTotal)
+	logOperationStats(ctx, "watermark, write", durRead, durVal, durOpt, durWrite, durTotal)
 
 	return nil
 }
 
-// AddWatermarks adds watermarks to all pages selected.
-func AddWatermarks(cmd *Command) ([]string, error) {
+// stampRecipient writes the output for r based on a clone of ctx.
+func stampRecipient(ctx *pdf.Context, selectedPages pdf.IntSet, dirOut string, fields []pdf.StampField, r pdf.Recipient) error {
 
-	fileIn := *cmd.InFile
-	fileOut := *cmd.OutFile
-	pageSelection := cmd.PageSelection
-	wm := cmd.Watermark
-	config := cmd.Config
+	ctx = ctx.Clone()
+
+	err := pdf.StampRecipient(ctx, selectedPages, fields, r)
+	if err != nil {
+		return err
+	}
+
+	ctx.Write.DirName = dirOut
+	ctx.Write.FileName = r.FileName
+
+	return Write(ctx)
+}
+
+// StampRecipients produces a personalized copy of fileIn in dirOut for each recipient,
+// with the recipient's values stamped on the selected pages at the positions given by fields.
+// fileIn is parsed once, outputs are written in parallel.
+// Returns the names of the files written.
+func StampRecipients(fileIn, dirOut string, selectedPages []string, fields []pdf.StampField, recipients []pdf.Recipient, config *pdf.Configuration) ([]string, error) {
 
 	fromStart := time.Now()
 
-	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, config, fromStart)
+	fileNames := pdf.StringSet{}
+	for _, r := range recipients {
+		if r.FileName == "" {
+			return nil, errors.New("stamp recipients: missing output file name")
+		}
+		if fileNames[r.FileName] {
+			return nil, errors.Errorf("stamp recipients: duplicate output file name %s", r.FileName)
+		}
+		fileNames[r.FileName] = true
+	}
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.ADDWATERMARKS), fromStart)
 	if err != nil {
 		return nil, err
 	}
 
-	fmt.Printf("%sing %s ...\n", wm.OnTopString(), fileIn)
+	fmt.Printf("stamping %d recipients of %s ...\n", len(recipients), fileIn)
 
-	from := time.Now()
+	fromWrite := time.Now()
 
-	pages, err := pagesForPageSelection(ctx.PageCount, pageSelection)
+	pages, err := pagesForPageSelection(ctx.PageCount, selectedPages)
 	if err != nil {
 		return nil, err
 	}
 
 	ensureSelectedPages(ctx, &pages)
 
-	err = pdf.AddWatermarks(ctx, pages, wm)
-	if err != nil {
-		return nil, err
-	}
+	// ctx serves as read only base for all outputs.
+	var wg sync.WaitGroup
+	sem := make(chan bool, runtime.NumCPU())
+	errs := make([]error, len(recipients))
 
-	log.Stats.Printf("XRefTable:\n%s\n", ctx)
+	for i, r := range recipients {
 
-	durStamp := time.Since(from).Seconds()
+		wg.Add(1)
+		sem <- true
 
-	fromWrite := time.Now()
+		go func(i int, r pdf.Recipient) {
+			defer func() { <-sem; wg.Done() }()
+			errs[i] = stampRecipient(ctx, pages, dirOut, fields, r)
+		}(i, r)
+	}
 
-	dirName, fileName := filepath.Split(fileOut)
-	ctx.Write.DirName = dirName
-	ctx.Write.FileName = fileName
+	wg.Wait()
 
-	err = Write(ctx)
-	if err != nil {
-		return nil, err
+	var list []string
+
+	for i, r := range recipients {
+		if errs[i] != nil {
+			return nil, errors.Wrapf(errs[i], "stamp recipient %s", r.FileName)
+		}
+		list = append(list, filepath.Join(dirOut, r.FileName))
 	}
 
-	durWrite := durStamp + time.Since(fromWrite).Seconds()
+	durWrite := time.Since(fromWrite).Seconds()
 	durTotal := time.Since(fromStart).Seconds()
-	logOperationStats(ctx, "watermark, write", durRead, durVal, durOpt, durWrite, durTotal)
+	logOperationStats(ctx, "stamp recipients, write", durRead, durVal, durOpt, durWrite, durTotal)
 
-	return nil, nil
+	return list, nil
 }
@@ -0,0 +1,175 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"io"
+	"path/filepath"
+	"time"
+
+	pdf "github.com/jplu/pdfcpu/pkg/pdfcpu"
+	"github.com/pkg/errors"
+)
+
+// The archive formats supported for extracting into an io.Writer.
+const (
+	ArchiveZip = "zip"
+	ArchiveTar = "tar"
+)
+
+// archiveWriter streams extracted files into a zip or tar archive.
+type archiveWriter struct {
+	zw      *zip.Writer
+	tw      *tar.Writer
+	modTime time.Time
+}
+
+func newArchiveWriter(w io.Writer, format string, modTime time.Time) (*archiveWriter, error) {
+
+	switch format {
+
+	case ArchiveZip:
+		return &archiveWriter{zw: zip.NewWriter(w), modTime: modTime}, nil
+
+	case ArchiveTar:
+		return &archiveWriter{tw: tar.NewWriter(w), modTime: modTime}, nil
+	}
+
+	return nil, errors.Errorf("unsupported archive format %q, use %s or %s", format, ArchiveZip, ArchiveTar)
+}
+
+// add writes an extracted file into the archive.
+func (aw *archiveWriter) add(f ExtractedFile) error {
+
+	name, b := filepath.ToSlash(f.Name), f.Data
+
+	if aw.zw != nil {
+		fw, err := aw.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: aw.modTime})
+		if err != nil {
+			return err
+		}
+		_, err = fw.Write(b)
+		return err
+	}
+
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(b)), ModTime: aw.modTime, Typeflag: tar.TypeReg}
+	if err := aw.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	_, err := aw.tw.Write(b)
+
+	return err
+}
+
+// Close finishes the archive without closing the underlying writer.
+func (aw *archiveWriter) Close() error {
+	if aw.zw != nil {
+		return aw.zw.Close()
+	}
+	return aw.tw.Close()
+}
+
+func readValidateAndOptimizeRS(rs io.ReadSeeker, config *pdf.Configuration) (*pdf.Context, error) {
+
+	ctx, err := ReadContext(rs, "", 0, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = ValidateContext(ctx); err != nil {
+		return nil, err
+	}
+
+	if err = OptimizeContext(ctx); err != nil {
+		return nil, err
+	}
+
+	return ctx, nil
+}
+
+// extractToArchive runs extract for the selected pages of rs and streams the files generated into w.
+func extractToArchive(rs io.ReadSeeker, w io.Writer, format string, selectedPages []string, config *pdf.Configuration,
+	extract func(ctx *pdf.Context, pages pdf.IntSet, sink extractSink) ([]string, error)) ([]string, error) {
+
+	aw, err := newArchiveWriter(w, format, config.CurrentTime())
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, err := readValidateAndOptimizeRS(rs, config)
+	if err != nil {
+		return nil, err
+	}
+
+	pages, err := pagesForPageSelection(ctx.PageCount, selectedPages)
+	if err != nil {
+		return nil, err
+	}
+
+	ensureSelectedPages(ctx, &pages)
+
+	files, err := extract(ctx, pages, aw.add)
+	if err != nil {
+		return nil, err
+	}
+
+	return files, aw.Close()
+}
+
+// ExtractImagesToArchive streams the images of selected pages of rs into a zip or tar archive written to w
+// and returns the names of the archive entries.
+func ExtractImagesToArchive(rs io.ReadSeeker, w io.Writer, format string, selectedPages []string, config *pdf.Configuration) ([]string, error) {
+	return extractToArchive(rs, w, format, selectedPages, configForMode(config, pdf.EXTRACTIMAGES), doExtractImages)
+}
+
+// ExtractFontsToArchive streams the embedded fontfiles of selected pages of rs into a zip or tar archive written to w
+// and returns the names of the archive entries.
+func ExtractFontsToArchive(rs io.ReadSeeker, w io.Writer, format string, selectedPages []string, config *pdf.Configuration) ([]string, error) {
+	return extractToArchive(rs, w, format, selectedPages, configForMode(config, pdf.EXTRACTFONTS), doExtractFonts)
+}
+
+// ExtractAttachmentsToArchive streams the embedded files of rs into a zip or tar archive written to w
+// and returns the names of the archive entries. If no files are specified all embedded files get extracted.
+func ExtractAttachmentsToArchive(rs io.ReadSeeker, w io.Writer, format string, files []string, config *pdf.Configuration) ([]string, error) {
+
+	config = configForMode(config, pdf.EXTRACTATTACHMENTS)
+
+	aw, err := newArchiveWriter(w, format, config.CurrentTime())
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, err := readValidateAndOptimizeRS(rs, config)
+	if err != nil {
+		return nil, err
+	}
+
+	var written []string
+
+	err = pdf.AttachExtractFunc(ctx, stringSet(files), func(fileName string, content []byte) error {
+		written = append(written, fileName)
+		return aw.add(ExtractedFile{Name: fileName, Data: content})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return written, aw.Close()
+}
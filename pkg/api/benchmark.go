@@ -0,0 +1,220 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"time"
+
+	pdf "github.com/jplu/pdfcpu/pkg/pdfcpu"
+	"github.com/pkg/errors"
+)
+
+// Operations measured by Benchmark.
+const (
+	BenchmarkRead     = "read"
+	BenchmarkValidate = "validate"
+	BenchmarkOptimize = "optimize"
+	BenchmarkWrite    = "write"
+)
+
+// BenchmarkResult represents the accumulated throughput of an operation.
+type BenchmarkResult struct {
+	Operation string
+	Files     int
+	Pages     int
+	Bytes     int64 // input file size
+	Duration  time.Duration
+}
+
+// PagesPerSecond returns the number of pages processed per second.
+func (r BenchmarkResult) PagesPerSecond() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Pages) / r.Duration.Seconds()
+}
+
+// MBPerSecond returns the number of input megabytes processed per second.
+func (r BenchmarkResult) MBPerSecond() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) / (1024 * 1024) / r.Duration.Seconds()
+}
+
+func (r BenchmarkResult) String() string {
+	return fmt.Sprintf("%-8s: %4d files %6d pages %10.3fs %10.1f pages/s %8.2f MB/s",
+		r.Operation, r.Files, r.Pages, r.Duration.Seconds(), r.PagesPerSecond(), r.MBPerSecond())
+}
+
+// BenchmarkOptions configures a benchmark run.
+type BenchmarkOptions struct {
+	Iterations     int    // number of runs over the corpus, defaults to 1.
+	CPUProfileFile string // if set, a pprof CPU profile gets written to this file.
+	MemProfileFile string // if set, a pprof heap profile gets written to this file.
+}
+
+// LoadCorpus returns the sorted names of all PDF files in dir and its subdirectories.
+func LoadCorpus(dir string) ([]string, error) {
+
+	var fileNames []string
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(strings.ToLower(info.Name()), ".pdf") {
+			fileNames = append(fileNames, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(fileNames)
+
+	return fileNames, nil
+}
+
+func benchmarkFile(fileName string, config *pdf.Configuration, m map[string]*BenchmarkResult) error {
+
+	fileInfo, err := fs.Stat(config.FileSystem(), fileName)
+	if err != nil {
+		return err
+	}
+
+	from := time.Now()
+	ctx, err := ReadContextFromFile(fileName, config)
+	if err != nil {
+		return errors.Wrapf(err, "benchmark: %s", fileName)
+	}
+	durRead := time.Since(from)
+
+	from = time.Now()
+	err = ValidateContext(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "benchmark: %s", fileName)
+	}
+	durVal := time.Since(from)
+
+	from = time.Now()
+	err = OptimizeContext(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "benchmark: %s", fileName)
+	}
+	durOpt := time.Since(from)
+
+	from = time.Now()
+	err = WriteContext(ctx, ioutil.Discard)
+	if err != nil {
+		return errors.Wrapf(err, "benchmark: %s", fileName)
+	}
+	durWrite := time.Since(from)
+
+	for op, dur := range map[string]time.Duration{
+		BenchmarkRead:     durRead,
+		BenchmarkValidate: durVal,
+		BenchmarkOptimize: durOpt,
+		BenchmarkWrite:    durWrite,
+	} {
+		r := m[op]
+		r.Files++
+		r.Pages += ctx.PageCount
+		r.Bytes += fileInfo.Size()
+		r.Duration += dur
+	}
+
+	return nil
+}
+
+func writeMemProfile(fileName string) error {
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	runtime.GC()
+
+	return pprof.WriteHeapProfile(f)
+}
+
+// Benchmark reads, validates, optimizes and writes each of fileNames and returns the throughput per operation.
+// Written output gets discarded.
+func Benchmark(fileNames []string, config *pdf.Configuration, opts BenchmarkOptions) (results []BenchmarkResult, err error) {
+
+	if len(fileNames) == 0 {
+		return nil, errors.New("benchmark: missing input files")
+	}
+
+	if opts.Iterations < 1 {
+		opts.Iterations = 1
+	}
+
+	if opts.CPUProfileFile != "" {
+		f, err := os.Create(opts.CPUProfileFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		err = pprof.StartCPUProfile(f)
+		if err != nil {
+			return nil, err
+		}
+		defer pprof.StopCPUProfile()
+	}
+
+	ops := []string{BenchmarkRead, BenchmarkValidate, BenchmarkOptimize, BenchmarkWrite}
+
+	m := map[string]*BenchmarkResult{}
+	for _, op := range ops {
+		m[op] = &BenchmarkResult{Operation: op}
+	}
+
+	for i := 0; i < opts.Iterations; i++ {
+		for _, fileName := range fileNames {
+			err = benchmarkFile(fileName, config, m)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if opts.MemProfileFile != "" {
+		err = writeMemProfile(opts.MemProfileFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, op := range ops {
+		results = append(results, *m[op])
+	}
+
+	return results, nil
+}
@@ -352,3 +352,25 @@ func exampleProcessWatermark() {
 	}
 
 }
+
+func exampleBenchmark() {
+
+	// Collect all PDF files in corpusDir.
+	fileNames, err := LoadCorpus("corpusDir")
+	if err != nil {
+		return
+	}
+
+	opts := BenchmarkOptions{Iterations: 3, CPUProfileFile: "cpu.prof"}
+
+	results, err := Benchmark(fileNames, pdfcpu.NewDefaultConfiguration(), opts)
+	if err != nil {
+		return
+	}
+
+	// Print throughput per operation.
+	for _, r := range results {
+		fmt.Println(r)
+	}
+
+}
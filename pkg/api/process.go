@@ -34,6 +34,11 @@ type Command struct {
 	PWOld         *string            //    -         -        -      -       -      -      -       -       -      -       -        -         *          *       -     -       -
 	PWNew         *string            //    -         -        -      -       -      -      -       -       -      -       -        -         *          *       -     -       -
 	Watermark     *pdf.Watermark     //    -         -        -      -       -      -      -       -       -      -       -        -         -          -       -     -       -
+	Crop          *pdf.Crop          // The page boxes to set for CROP.
+	Resize        *pdf.Resize        // The new page size for RESIZE.
+	Poster        *pdf.Poster        // The tiling for POSTER.
+	Warnings      []pdf.Warning      // Non fatal problems encountered by Process.
+	PageFiles     []PageFile         // The files written by SPLIT and EXTRACTPAGES.
 }
 
 // Process executes a pdfcpu command.
@@ -48,6 +53,16 @@ func Process(cmd *Command) (out []string, err error) {
 
 	cmd.Config.Mode = cmd.Mode
 
+	// Collect warnings into cmd.Warnings and still notify any hook already installed.
+	onWarning := cmd.Config.Hooks.OnWarning
+	cmd.Config.Hooks.OnWarning = func(w pdf.Warning) {
+		cmd.Warnings = append(cmd.Warnings, w)
+		if onWarning != nil {
+			onWarning(w)
+		}
+	}
+	defer func() { cmd.Config.Hooks.OnWarning = onWarning }()
+
 	for k, v := range map[pdf.CommandMode]func(cmd *Command) ([]string, error){
 		pdf.VALIDATE:           Validate,
 		pdf.OPTIMIZE:           Optimize,
@@ -58,8 +73,15 @@ func Process(cmd *Command) (out []string, err error) {
 		pdf.EXTRACTPAGES:       ExtractPages,
 		pdf.EXTRACTCONTENT:     ExtractContent,
 		pdf.EXTRACTMETADATA:    ExtractMetadata,
+		pdf.EXTRACTTEXT:        ExtractText,
 		pdf.TRIM:               Trim,
+		pdf.COLLECT:            Collect,
+		pdf.REMOVEPAGES:        RemovePages,
+		pdf.CROP:               Crop,
+		pdf.RESIZE:             Resize,
+		pdf.POSTER:             Poster,
 		pdf.ADDWATERMARKS:      AddWatermarks,
+		pdf.REMOVEWATERMARKS:   RemoveWatermarks,
 		pdf.LISTATTACHMENTS:    processAttachments,
 		pdf.ADDATTACHMENTS:     processAttachments,
 		pdf.REMOVEATTACHMENTS:  processAttachments,
@@ -147,6 +169,16 @@ func ExtractPagesCommand(pdfFileNameIn, dirNameOut string, pageSelection []strin
 		Config:        config}
 }
 
+// ExtractPagesToFileCommand creates a new command to extract specific pages of a file into a single file.
+func ExtractPagesToFileCommand(pdfFileNameIn, pdfFileNameOut string, pageSelection []string, config *pdf.Configuration) *Command {
+	return &Command{
+		Mode:          pdf.EXTRACTPAGES,
+		InFile:        &pdfFileNameIn,
+		OutFile:       &pdfFileNameOut,
+		PageSelection: pageSelection,
+		Config:        config}
+}
+
 // ExtractContentCommand creates a new command to extract page content streams.
 func ExtractContentCommand(pdfFileNameIn, dirNameOut string, pageSelection []string, config *pdf.Configuration) *Command {
 	return &Command{
@@ -157,6 +189,16 @@ func ExtractContentCommand(pdfFileNameIn, dirNameOut string, pageSelection []str
 		Config:        config}
 }
 
+// ExtractTextCommand creates a new command to extract the text of pages.
+func ExtractTextCommand(pdfFileNameIn, dirNameOut string, pageSelection []string, config *pdf.Configuration) *Command {
+	return &Command{
+		Mode:          pdf.EXTRACTTEXT,
+		InFile:        &pdfFileNameIn,
+		OutDir:        &dirNameOut,
+		PageSelection: pageSelection,
+		Config:        config}
+}
+
 // ExtractMetadataCommand creates a new command to extract metadata streams.
 func ExtractMetadataCommand(pdfFileNameIn, dirNameOut string, config *pdf.Configuration) *Command {
 	return &Command{
@@ -177,6 +219,59 @@ func TrimCommand(pdfFileNameIn, pdfFileNameOut string, pageSelection []string, c
 		Config:        config}
 }
 
+// CollectCommand creates a new command to assemble a file from an ordered page sequence of another file.
+func CollectCommand(pdfFileNameIn, pdfFileNameOut string, pageSequence []string, config *pdf.Configuration) *Command {
+	return &Command{
+		Mode:          pdf.COLLECT,
+		InFile:        &pdfFileNameIn,
+		OutFile:       &pdfFileNameOut,
+		PageSelection: pageSequence,
+		Config:        config}
+}
+
+// RemovePagesCommand creates a new command to remove selected pages of a file.
+func RemovePagesCommand(pdfFileNameIn, pdfFileNameOut string, pageSelection []string, config *pdf.Configuration) *Command {
+	return &Command{
+		Mode:          pdf.REMOVEPAGES,
+		InFile:        &pdfFileNameIn,
+		OutFile:       &pdfFileNameOut,
+		PageSelection: pageSelection,
+		Config:        config}
+}
+
+// CropCommand creates a new command to set the crop box of selected pages of a file.
+func CropCommand(pdfFileNameIn, pdfFileNameOut string, pageSelection []string, c *pdf.Crop, config *pdf.Configuration) *Command {
+	return &Command{
+		Mode:          pdf.CROP,
+		InFile:        &pdfFileNameIn,
+		OutFile:       &pdfFileNameOut,
+		PageSelection: pageSelection,
+		Crop:          c,
+		Config:        config}
+}
+
+// ResizeCommand creates a new command to scale the content of selected pages of a file to a new page size.
+func ResizeCommand(pdfFileNameIn, pdfFileNameOut string, pageSelection []string, r *pdf.Resize, config *pdf.Configuration) *Command {
+	return &Command{
+		Mode:          pdf.RESIZE,
+		InFile:        &pdfFileNameIn,
+		OutFile:       &pdfFileNameOut,
+		PageSelection: pageSelection,
+		Resize:        r,
+		Config:        config}
+}
+
+// PosterCommand creates a new command to split selected pages of a file into tiles.
+func PosterCommand(pdfFileNameIn, pdfFileNameOut string, pageSelection []string, p *pdf.Poster, config *pdf.Configuration) *Command {
+	return &Command{
+		Mode:          pdf.POSTER,
+		InFile:        &pdfFileNameIn,
+		OutFile:       &pdfFileNameOut,
+		PageSelection: pageSelection,
+		Poster:        p,
+		Config:        config}
+}
+
 // ListAttachmentsCommand create a new command to list attachments.
 func ListAttachmentsCommand(pdfFileNameIn string, config *pdf.Configuration) *Command {
 	return &Command{
@@ -194,6 +289,17 @@ func AddAttachmentsCommand(pdfFileNameIn string, fileNamesIn []string, config *p
 		Config:  config}
 }
 
+// AddAttachmentsToFileCommand creates a new command to add attachments writing the result to pdfFileNameOut.
+// An empty pdfFileNameOut updates pdfFileNameIn.
+func AddAttachmentsToFileCommand(pdfFileNameIn, pdfFileNameOut string, fileNamesIn []string, config *pdf.Configuration) *Command {
+	return &Command{
+		Mode:    pdf.ADDATTACHMENTS,
+		InFile:  &pdfFileNameIn,
+		OutFile: &pdfFileNameOut,
+		InFiles: fileNamesIn,
+		Config:  config}
+}
+
 // RemoveAttachmentsCommand creates a new command to remove attachments.
 func RemoveAttachmentsCommand(pdfFileNameIn string, fileNamesIn []string, config *pdf.Configuration) *Command {
 	return &Command{
@@ -203,6 +309,17 @@ func RemoveAttachmentsCommand(pdfFileNameIn string, fileNamesIn []string, config
 		Config:  config}
 }
 
+// RemoveAttachmentsToFileCommand creates a new command to remove attachments writing the result to pdfFileNameOut.
+// An empty pdfFileNameOut updates pdfFileNameIn.
+func RemoveAttachmentsToFileCommand(pdfFileNameIn, pdfFileNameOut string, fileNamesIn []string, config *pdf.Configuration) *Command {
+	return &Command{
+		Mode:    pdf.REMOVEATTACHMENTS,
+		InFile:  &pdfFileNameIn,
+		OutFile: &pdfFileNameOut,
+		InFiles: fileNamesIn,
+		Config:  config}
+}
+
 // ExtractAttachmentsCommand creates a new command to extract attachments.
 func ExtractAttachmentsCommand(pdfFileNameIn, dirNameOut string, fileNamesIn []string, config *pdf.Configuration) *Command {
 	return &Command{
@@ -269,6 +386,24 @@ func AddPermissionsCommand(pdfFileNameIn string, config *pdf.Configuration) *Com
 		Config: config}
 }
 
+// AddPermissionsToFileCommand creates a new command to add permissions writing the result to pdfFileNameOut.
+// An empty pdfFileNameOut updates pdfFileNameIn.
+func AddPermissionsToFileCommand(pdfFileNameIn, pdfFileNameOut string, config *pdf.Configuration) *Command {
+	return &Command{
+		Mode:    pdf.ADDPERMISSIONS,
+		InFile:  &pdfFileNameIn,
+		OutFile: &pdfFileNameOut,
+		Config:  config}
+}
+
+// outFile returns the output file of cmd, empty for in place updates.
+func outFile(cmd *Command) string {
+	if cmd.OutFile == nil {
+		return ""
+	}
+	return *cmd.OutFile
+}
+
 func processAttachments(cmd *Command) (out []string, err error) {
 
 	switch cmd.Mode {
@@ -277,10 +412,10 @@ func processAttachments(cmd *Command) (out []string, err error) {
 		out, err = ListAttachments(*cmd.InFile, cmd.Config)
 
 	case pdf.ADDATTACHMENTS:
-		err = AddAttachments(*cmd.InFile, cmd.InFiles, cmd.Config)
+		err = AddAttachmentsToFile(*cmd.InFile, outFile(cmd), cmd.InFiles, cmd.Config)
 
 	case pdf.REMOVEATTACHMENTS:
-		err = RemoveAttachments(*cmd.InFile, cmd.InFiles, cmd.Config)
+		err = RemoveAttachmentsToFile(*cmd.InFile, outFile(cmd), cmd.InFiles, cmd.Config)
 
 	case pdf.EXTRACTATTACHMENTS:
 		err = ExtractAttachments(*cmd.InFile, *cmd.OutDir, cmd.InFiles, cmd.Config)
@@ -317,7 +452,7 @@ func processPermissions(cmd *Command) (out []string, err error) {
 		out, err = ListPermissions(*cmd.InFile, cmd.Config)
 
 	case pdf.ADDPERMISSIONS:
-		err = AddPermissions(*cmd.InFile, cmd.Config)
+		err = AddPermissionsToFile(*cmd.InFile, outFile(cmd), cmd.Config)
 	}
 
 	return out, err
@@ -334,3 +469,14 @@ func AddWatermarksCommand(pdfFileNameIn, pdfFileNameOut string, pageSelection []
 		Watermark:     wm,
 		Config:        config}
 }
+
+// RemoveWatermarksCommand creates a new command to remove watermarks and stamps from a file.
+func RemoveWatermarksCommand(pdfFileNameIn, pdfFileNameOut string, pageSelection []string, config *pdf.Configuration) *Command {
+
+	return &Command{
+		Mode:          pdf.REMOVEWATERMARKS,
+		InFile:        &pdfFileNameIn,
+		OutFile:       &pdfFileNameOut,
+		PageSelection: pageSelection,
+		Config:        config}
+}
@@ -17,16 +17,32 @@ limitations under the License.
 package api
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"image"
+	_ "image/jpeg"
+	"image/png"
 	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/jplu/pdfcpu/pkg/filter"
+	"github.com/jplu/pdfcpu/pkg/fonts/metrics"
 	pdf "github.com/jplu/pdfcpu/pkg/pdfcpu"
 	"github.com/jplu/pdfcpu/pkg/pdfcpu/validate"
+	"github.com/jplu/pdfcpu/pkg/types"
 )
 
 var inDir, outDir, resDir string
@@ -188,6 +204,49 @@ func TestReadSeekerAndWriter(t *testing.T) {
 
 }
 
+func TestWriteContextAt(t *testing.T) {
+
+	config := pdf.NewDefaultConfiguration()
+
+	for _, f := range []string{"CenterOfWhy.pdf", "go.pdf", "annotTest.pdf"} {
+
+		fileIn := filepath.Join(inDir, f)
+		fileOut := filepath.Join(outDir, "test.pdf")
+
+		ctx, err := ReadContextFromFile(fileIn, config)
+		if err != nil {
+			t.Fatalf("TestWriteContextAt Read: %v\n", err)
+		}
+
+		err = ValidateContext(ctx)
+		if err != nil {
+			t.Fatalf("TestWriteContextAt Validate: %v\n", err)
+		}
+
+		err = OptimizeContext(ctx)
+		if err != nil {
+			t.Fatalf("TestWriteContextAt Optimize: %v\n", err)
+		}
+
+		w, err := os.Create(fileOut)
+		if err != nil {
+			t.Fatalf("TestWriteContextAt Create: %v\n", err)
+		}
+
+		err = WriteContextAt(ctx, w)
+		w.Close()
+		if err != nil {
+			t.Fatalf("TestWriteContextAt Write: %v\n", err)
+		}
+
+		_, err = Process(ValidateCommand(fileOut, config))
+		if err != nil {
+			t.Fatalf("TestWriteContextAt %s: %v\n", f, err)
+		}
+	}
+
+}
+
 func TestMergeUsingReadSeekerCloser(t *testing.T) {
 
 	rr := []pdf.ReadSeekerCloser{}
@@ -267,6 +326,210 @@ func TestGetPageCount(t *testing.T) {
 
 }
 
+func TestReadPageContext(t *testing.T) {
+
+	config := pdf.NewDefaultConfiguration()
+
+	for _, tt := range []struct {
+		fileName string
+		pageNr   int
+	}{
+		{"CenterOfWhy.pdf", 12},
+		{"go.pdf", 1},
+		{"Acroforms2.pdf", 2},
+		{"annotTest.pdf", 1},
+	} {
+
+		inFile := filepath.Join(inDir, tt.fileName)
+		outFile := filepath.Join(outDir, "page.pdf")
+
+		f, err := os.Open(inFile)
+		if err != nil {
+			t.Fatalf("TestReadPageContext Open: %v\n", err)
+		}
+
+		ctx, err := ReadPageContext(f, tt.pageNr, config)
+		f.Close()
+		if err != nil {
+			t.Fatalf("TestReadPageContext %s: %v\n", tt.fileName, err)
+		}
+
+		err = ValidateContext(ctx)
+		if err != nil {
+			t.Fatalf("TestReadPageContext %s Validate: %v\n", tt.fileName, err)
+		}
+
+		if ctx.PageCount != 1 {
+			t.Fatalf("TestReadPageContext %s: pageCount should be 1 but is %d\n", tt.fileName, ctx.PageCount)
+		}
+
+		ctx.Write.DirName = outDir + "/"
+		ctx.Write.FileName = "page.pdf"
+
+		err = Write(ctx)
+		if err != nil {
+			t.Fatalf("TestReadPageContext %s Write: %v\n", tt.fileName, err)
+		}
+
+		_, err = Process(ValidateCommand(outFile, config))
+		if err != nil {
+			t.Fatalf("TestReadPageContext %s: %v\n", tt.fileName, err)
+		}
+	}
+
+	// Page tree nodes missing their type.
+	fileName := filepath.Join(outDir, "untypedPageTree.pdf")
+	objs := []string{
+		"<</Type/Catalog/Pages 2 0 R>>",
+		"<</Kids[3 0 R 4 0 R]/Count 2>>",
+		"<</Parent 2 0 R/MediaBox[0 0 100 100]>>",
+		"<</Parent 2 0 R/MediaBox[0 0 200 200]>>",
+		"(" + strings.Repeat(" ", 1024) + ")", // The trailer is looked up within the last 1024 bytes.
+	}
+
+	if err := writeObjectsPDF(fileName, objs); err != nil {
+		t.Fatalf("TestReadPageContext: %v\n", err)
+	}
+
+	bb, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("TestReadPageContext: %v\n", err)
+	}
+
+	ctx, err := ReadPageContext(bytes.NewReader(bb), 2, config)
+	if err != nil {
+		t.Fatalf("TestReadPageContext %s: %v\n", fileName, err)
+	}
+
+	if _, found := ctx.Find(4); !found {
+		t.Fatalf("TestReadPageContext %s: missing page 2\n", fileName)
+	}
+
+	if _, err = ReadPageContext(bytes.NewReader(bb), 3, config); err == nil {
+		t.Fatalf("TestReadPageContext %s: page 3 should not be found\n", fileName)
+	}
+}
+
+func TestPageObjects(t *testing.T) {
+
+	config := pdf.NewDefaultConfiguration()
+	inFile := filepath.Join(inDir, "TheGoProgrammingLanguageCh1.pdf")
+
+	ctx, err := ReadContextFromFile(inFile, config)
+	if err != nil {
+		t.Fatalf("TestPageObjects: %v\n", err)
+	}
+
+	err = ValidateContext(ctx)
+	if err != nil {
+		t.Fatalf("TestPageObjects: %v\n", err)
+	}
+
+	pages := map[int]int{}
+	for i := 1; i <= ctx.PageCount; i++ {
+		ir, err := ctx.PageIndRef(i)
+		if err != nil {
+			t.Fatalf("TestPageObjects page %d: %v\n", i, err)
+		}
+		pages[ir.ObjectNumber.Value()] = i
+	}
+
+	for i := 1; i <= ctx.PageCount; i++ {
+
+		m, err := ctx.PageObjects(i)
+		if err != nil {
+			t.Fatalf("TestPageObjects page %d: %v\n", i, err)
+		}
+
+		// Links and article beads do not pull in other pages.
+		for objNr := range m {
+			if p, ok := pages[objNr]; ok && p != i {
+				t.Fatalf("TestPageObjects page %d: includes page %d\n", i, p)
+			}
+		}
+
+		pageDict, _, err := ctx.PageDict(i)
+		if err != nil {
+			t.Fatalf("TestPageObjects page %d: %v\n", i, err)
+		}
+
+		ir := pageDict.IndirectRefEntry("Contents")
+		if ir == nil {
+			continue
+		}
+
+		if m[ir.ObjectNumber.Value()] == 0 {
+			t.Fatalf("TestPageObjects page %d: missing content stream obj#%d\n", i, ir.ObjectNumber.Value())
+		}
+	}
+
+	if _, err = ctx.PageObjects(ctx.PageCount + 1); err == nil {
+		t.Fatalf("TestPageObjects: expected error for page %d\n", ctx.PageCount+1)
+	}
+
+}
+
+func TestBenchmark(t *testing.T) {
+
+	fileNames, err := LoadCorpus(inDir)
+	if err != nil {
+		t.Fatalf("TestBenchmark: %v\n", err)
+	}
+
+	if len(fileNames) == 0 {
+		t.Fatalf("TestBenchmark: empty corpus %s\n", inDir)
+	}
+
+	opts := BenchmarkOptions{
+		Iterations:     2,
+		CPUProfileFile: filepath.Join(outDir, "cpu.prof"),
+		MemProfileFile: filepath.Join(outDir, "mem.prof"),
+	}
+
+	fileNames = []string{filepath.Join(inDir, "go.pdf"), filepath.Join(inDir, "CenterOfWhy.pdf")}
+
+	results, err := Benchmark(fileNames, pdf.NewDefaultConfiguration(), opts)
+	if err != nil {
+		t.Fatalf("TestBenchmark: %v\n", err)
+	}
+
+	for _, r := range results {
+		if r.Files != 4 || r.Pages == 0 || r.Bytes == 0 {
+			t.Fatalf("TestBenchmark: unexpected result: %s\n", r)
+		}
+	}
+
+	for _, fileName := range []string{opts.CPUProfileFile, opts.MemProfileFile} {
+		if _, err = os.Stat(fileName); err != nil {
+			t.Fatalf("TestBenchmark: %v\n", err)
+		}
+	}
+
+	// Input files are read from the configured file system.
+	bb, err := ioutil.ReadFile(fileNames[0])
+	if err != nil {
+		t.Fatalf("TestBenchmark: %v\n", err)
+	}
+
+	config := pdf.NewDefaultConfiguration()
+	config.FS = pdf.NewMemFileSystem()
+	if err = pdf.WriteFile(config.FS, "go.pdf", bb, 0644); err != nil {
+		t.Fatalf("TestBenchmark: %v\n", err)
+	}
+
+	results, err = Benchmark([]string{"go.pdf"}, config, BenchmarkOptions{})
+	if err != nil {
+		t.Fatalf("TestBenchmark: %v\n", err)
+	}
+
+	for _, r := range results {
+		if r.Files != 1 || r.Pages == 0 || r.Bytes != int64(len(bb)) {
+			t.Fatalf("TestBenchmark: unexpected result: %s\n", r)
+		}
+	}
+
+}
+
 // Validate all PDFs in testdata.
 func TestValidateCommand(t *testing.T) {
 
@@ -436,867 +699,7459 @@ func TestOptimizeCommandWithCRLF(t *testing.T) {
 }
 
 // Split a test PDF file up into single page PDFs.
-func TestSplitCommand(t *testing.T) {
+func TestVerifyWrite(t *testing.T) {
 
-	_, err := Process(SplitCommand("testdata/Acroforms2.pdf", outDir, pdf.NewDefaultConfiguration()))
-	if err != nil {
-		t.Fatalf("TestSplitCommand: %v\n", err)
-	}
-}
+	inFile := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	outFile := filepath.Join(outDir, "test.pdf")
 
-// Merge all PDFs in testdir into out/test.pdf.
-func TestMergeCommand(t *testing.T) {
+	config := pdf.NewDefaultConfiguration()
+	config.VerifyWrite = true
 
-	files, err := ioutil.ReadDir(inDir)
+	_, err := Process(OptimizeCommand(inFile, outFile, config))
 	if err != nil {
-		t.Fatalf("TestMergeCommmand: %v\n", err)
+		t.Fatalf("TestVerifyWrite - optimize: %v\n", err)
 	}
 
-	inFiles := []string{}
-	for _, file := range files {
-		if strings.HasSuffix(file.Name(), "pdf") {
-			inFile := filepath.Join(inDir, file.Name())
-			inFiles = append(inFiles, inFile)
-		}
+	_, err = Process(SplitCommand(inFile, outDir, config))
+	if err != nil {
+		t.Fatalf("TestVerifyWrite - split: %v\n", err)
 	}
 
-	config := pdf.NewDefaultConfiguration()
+	config = pdf.NewDefaultConfiguration()
+	config.VerifyWrite = true
+	config.OwnerPW = "opw"
+	_, err = Process(EncryptCommand(inFile, outFile, config))
+	if err != nil {
+		t.Fatalf("TestVerifyWrite - encrypt: %v\n", err)
+	}
 
-	outFile := filepath.Join(outDir, "test.pdf")
-	_, err = Process(MergeCommand(inFiles, outFile, config))
+	// Corrupt the written file and verify again.
+	err = ioutil.WriteFile(outFile, []byte("%PDF-1.7\n"), os.ModePerm)
 	if err != nil {
-		t.Fatalf("TestMergeCommand: %v\n", err)
+		t.Fatalf("TestVerifyWrite: %v\n", err)
 	}
 
-	_, err = Process(ValidateCommand(outFile, config))
+	ctx, err := ReadContextFromFile(inFile, pdf.NewDefaultConfiguration())
 	if err != nil {
-		t.Fatalf("TestMergeCommand: %v\n", err)
+		t.Fatalf("TestVerifyWrite: %v\n", err)
+	}
+	ctx.VerifyWrite = true
+	ctx.Write.DirName, ctx.Write.FileName = filepath.Split(outFile)
+
+	if err = verifyWrite(ctx); err == nil {
+		t.Fatalf("TestVerifyWrite - verify of corrupt %s should fail!\n", outFile)
 	}
 
 }
 
-// Trim test PDF file so that only the first two pages are rendered.
-func TestTrimCommand(t *testing.T) {
+// Generate CreationDate and ModDate using an injected clock and time zone.
+func TestConfigurationNow(t *testing.T) {
 
-	inFile := filepath.Join(inDir, "pike-stanford.pdf")
+	inFile := filepath.Join(inDir, "go.pdf")
 	outFile := filepath.Join(outDir, "test.pdf")
 
 	config := pdf.NewDefaultConfiguration()
+	config.Now = func() time.Time { return time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC) }
+	config.TimeZone = time.FixedZone("", -(5*60*60 + 30*60))
 
-	_, err := Process(TrimCommand(inFile, outFile, []string{"-2"}, config))
+	_, err := Process(OptimizeCommand(inFile, outFile, config))
 	if err != nil {
-		t.Fatalf("TestTrimCommand: %v\n", err)
+		t.Fatalf("TestConfigurationNow: %v\n", err)
 	}
 
-	_, err = Process(ValidateCommand(outFile, config))
+	ctx, err := ReadContextFromFile(outFile, pdf.NewDefaultConfiguration())
 	if err != nil {
-		t.Fatalf("TestTrimCommand: %v\n", err)
+		t.Fatalf("TestConfigurationNow: %v\n", err)
 	}
 
-}
-
-// Add text watermark to all pages of inFile starting at page 1 using a rotation angle of 20 degrees.
-func TestWatermarkText(t *testing.T) {
-
-	inFile := filepath.Join(inDir, "Acroforms2.pdf")
-	outFile := filepath.Join(outDir, "testwm.pdf")
-
-	onTop := false
-	wm, err := pdf.ParseWatermarkDetails("Draft, s:0.7, r:20", onTop)
+	d, err := ctx.DereferenceDict(*ctx.Info)
 	if err != nil {
-		t.Fatalf("TestWatermarkText: %v\n", err)
+		t.Fatalf("TestConfigurationNow: %v\n", err)
 	}
 
-	config := pdf.NewDefaultConfiguration()
-
-	_, err = Process(AddWatermarksCommand(inFile, outFile, []string{"1-"}, wm, config))
-	if err != nil {
-		t.Fatalf("TestWatermarkText: %v\n", err)
-	}
+	want := "D:20180101213405-05'30'"
 
-	_, err = Process(ValidateCommand(outFile, config))
-	if err != nil {
-		t.Fatalf("TestWatermarkText: %v\n", err)
+	for _, k := range []string{"CreationDate", "ModDate"} {
+		sl := d.StringLiteralEntry(k)
+		if sl == nil || sl.Value() != want {
+			t.Fatalf("TestConfigurationNow: %s = %v, want %s\n", k, sl, want)
+		}
 	}
 
 }
 
-// Add a greenish, slightly transparent stroked and filled text stamp to all odd pages of inFile other than page 1
-// using the default rotation which is aligned along the first diagonal running from lower left to upper right corner.
-func TestStampText(t *testing.T) {
+func TestSplitCommand(t *testing.T) {
 
-	inFile := filepath.Join(inDir, "pike-stanford.pdf")
-	outFile := filepath.Join(outDir, "testStampText1.pdf")
+	// A trailing separator does not end up in the paths returned.
+	cmd := SplitCommand("testdata/Acroforms2.pdf", outDir+string(filepath.Separator), pdf.NewDefaultConfiguration())
 
-	onTop := true
-	wm, err := pdf.ParseWatermarkDetails("Demo, f:Courier, c: 0 .8 0, o:0.8, m:2", onTop)
+	out, err := Process(cmd)
 	if err != nil {
-		t.Fatalf("TestStampText: %v\n", err)
+		t.Fatalf("TestSplitCommand: %v\n", err)
 	}
 
-	config := pdf.NewDefaultConfiguration()
+	ctx := readAndValidateFile(t, "testdata/Acroforms2.pdf")
 
-	_, err = Process(AddWatermarksCommand(inFile, outFile, []string{"odd", "!1"}, wm, config))
-	if err != nil {
-		t.Fatalf("TestStampText: %v\n", err)
+	if len(out) != ctx.PageCount || len(cmd.PageFiles) != ctx.PageCount {
+		t.Fatalf("TestSplitCommand: want %d files, got %d %d\n", ctx.PageCount, len(out), len(cmd.PageFiles))
 	}
 
-	_, err = Process(ValidateCommand(outFile, config))
-	if err != nil {
-		t.Fatalf("TestStampText: %v\n", err)
+	for i, pf := range cmd.PageFiles {
+		if pf.PageNr != i+1 || pf.FileName != out[i] || pf.FileName != filepath.Join(outDir, fmt.Sprintf("Acroforms2_%d.pdf", i+1)) {
+			t.Fatalf("TestSplitCommand: unexpected file for page %d: %+v\n", i+1, pf)
+		}
+		if ctx := readAndValidateFile(t, pf.FileName); ctx.PageCount != 1 {
+			t.Fatalf("TestSplitCommand: %s: want 1 page, got %d\n", pf.FileName, ctx.PageCount)
+		}
 	}
-
 }
 
-// Add a red filled text stamp to all odd pages of inFile other than page 1 using a font size of 48 points
-// and the default rotation which is aligned along the first diagonal running from lower left to upper right corner.
-
-func TestStampTextUsingFontsize(t *testing.T) {
+func TestSplitSkipExisting(t *testing.T) {
 
-	inFile := filepath.Join(inDir, "pike-stanford.pdf")
-	outFile := filepath.Join(outDir, "testStampText2.pdf")
-
-	onTop := true
-	wm, err := pdf.ParseWatermarkDetails("Demo, f:Courier, c: 1 0 0, o:1, s:1 abs, p:48", onTop)
-	if err != nil {
-		t.Fatalf("TestStampTextUsingFontsize: %v\n", err)
+	dir := filepath.Join(outDir, "splitSkipExisting")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("TestSplitSkipExisting: %v\n", err)
 	}
+	defer os.RemoveAll(dir)
 
 	config := pdf.NewDefaultConfiguration()
+	config.SplitSkipExisting = true
 
-	_, err = Process(AddWatermarksCommand(inFile, outFile, []string{"odd", "!1"}, wm, config))
+	out, err := Process(SplitCommand("testdata/Acroforms2.pdf", dir, config))
 	if err != nil {
-		t.Fatalf("TestStampTextUsingFontsize: %v\n", err)
+		t.Fatalf("TestSplitSkipExisting: %v\n", err)
 	}
 
-	_, err = Process(ValidateCommand(outFile, config))
-	if err != nil {
-		t.Fatalf("TestStampTextUsingFontsize: %v\n", err)
+	if len(out) < 3 {
+		t.Fatalf("TestSplitSkipExisting: want at least 3 files, got %d\n", len(out))
 	}
 
-}
-
-// Add image watermark to inFile starting at page 1 using no rotation.
-func TestWatermarkImage(t *testing.T) {
-
-	inFile := filepath.Join(inDir, "Acroforms2.pdf")
-	outFile := filepath.Join(outDir, "testWMImageRel.pdf")
-	imageFile := filepath.Join(resDir, "pdfchip3.png")
+	// Simulate an interrupted run: one file cut off, one file missing.
+	// Files written completely keep their modification time on resume.
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for _, fn := range out {
+		if err = os.Chtimes(fn, past, past); err != nil {
+			t.Fatalf("TestSplitSkipExisting: %v\n", err)
+		}
+	}
 
-	onTop := false
-	wm, err := pdf.ParseWatermarkDetails(imageFile+", r:0", onTop)
-	if err != nil {
-		t.Fatalf("TestWatermarkImage: %v\n", err)
+	if err = os.Truncate(out[0], 100); err != nil {
+		t.Fatalf("TestSplitSkipExisting: %v\n", err)
 	}
 
-	config := pdf.NewDefaultConfiguration()
+	if err = os.Remove(out[1]); err != nil {
+		t.Fatalf("TestSplitSkipExisting: %v\n", err)
+	}
 
-	_, err = Process(AddWatermarksCommand(inFile, outFile, []string{"1-"}, wm, config))
+	out2, err := Process(SplitCommand("testdata/Acroforms2.pdf", dir, config))
 	if err != nil {
-		t.Fatalf("TestWatermarkImage: %v\n", err)
+		t.Fatalf("TestSplitSkipExisting: %v\n", err)
 	}
 
-	_, err = Process(ValidateCommand(outFile, config))
-	if err != nil {
-		t.Fatalf("TestWatermarkImage: %v\n", err)
+	if len(out2) != len(out) {
+		t.Fatalf("TestSplitSkipExisting: want %d files, got %d\n", len(out), len(out2))
 	}
 
-}
+	for i, fn := range out2 {
 
-// Add image stamp to inFile using absolute scaling and a a negative rotation of 90.
-func TestStampImageAbsScaling(t *testing.T) {
+		fi, err := os.Stat(fn)
+		if err != nil {
+			t.Fatalf("TestSplitSkipExisting: %v\n", err)
+		}
 
-	inFile := filepath.Join(inDir, "Acroforms2.pdf")
-	outFile := filepath.Join(outDir, "testWMImageAbs.pdf")
-	imageFile := filepath.Join(resDir, "pdfchip3.png")
+		rewritten := !fi.ModTime().Equal(past)
+		if rewritten != (i < 2) {
+			t.Fatalf("TestSplitSkipExisting: %s: rewritten=%t\n", fn, rewritten)
+		}
 
-	onTop := true
-	wm, err := pdf.ParseWatermarkDetails(imageFile+", s:.5 a, r:-90", onTop)
+		readAndValidateFile(t, fn)
+	}
+
+	if _, err = os.Stat(filepath.Join(dir, SplitManifestFile)); err != nil {
+		t.Fatalf("TestSplitSkipExisting: %v\n", err)
+	}
+}
+
+// Merge all PDFs in testdir into out/test.pdf.
+func TestMergeCommand(t *testing.T) {
+
+	files, err := ioutil.ReadDir(inDir)
 	if err != nil {
-		t.Fatalf("TestStampImageAbsScaling: %v\n", err)
+		t.Fatalf("TestMergeCommmand: %v\n", err)
+	}
+
+	inFiles := []string{}
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), "pdf") {
+			inFile := filepath.Join(inDir, file.Name())
+			inFiles = append(inFiles, inFile)
+		}
 	}
 
 	config := pdf.NewDefaultConfiguration()
 
-	_, err = Process(AddWatermarksCommand(inFile, outFile, []string{"1-"}, wm, config))
+	outFile := filepath.Join(outDir, "test.pdf")
+	_, err = Process(MergeCommand(inFiles, outFile, config))
 	if err != nil {
-		t.Fatalf("TestStampImageAbsScaling: %v\n", err)
+		t.Fatalf("TestMergeCommand: %v\n", err)
 	}
 
 	_, err = Process(ValidateCommand(outFile, config))
 	if err != nil {
-		t.Fatalf("TestStampImageAbsScaling: %v\n", err)
+		t.Fatalf("TestMergeCommand: %v\n", err)
 	}
 
 }
 
-// Add PDF stamp to all pages of inFile using the 2nd page of pdfFile
-// and rotate along the 2nd diagonal running from upper left to lower right corner.
-func TestStampPDF(t *testing.T) {
+// Merge a file with a copy of itself using differing font dicts
+// and verify identical font programs get embedded only once.
+func TestMergeConsolidatesFontFiles(t *testing.T) {
 
-	inFile := filepath.Join(inDir, "Acroforms2.pdf")
-	pdfFile := filepath.Join(inDir, "Wonderwall.pdf")
-	outFile := filepath.Join(outDir, "testStampPDF.pdf")
+	config := pdf.NewDefaultConfiguration()
+	inFile := filepath.Join(inDir, "go.pdf")
 
-	onTop := true
-	wm, err := pdf.ParseWatermarkDetails(pdfFile+":2, d:2", onTop)
+	ctxDest, err := ReadContextFromFile(inFile, config)
 	if err != nil {
-		t.Fatalf("TestStampPDF: %v\n", err)
+		t.Fatalf("TestMergeConsolidatesFontFiles: %v\n", err)
 	}
 
-	config := pdf.NewDefaultConfiguration()
+	ctxSource, err := ReadContextFromFile(inFile, config)
+	if err != nil {
+		t.Fatalf("TestMergeConsolidatesFontFiles: %v\n", err)
+	}
 
-	_, err = Process(AddWatermarksCommand(inFile, outFile, nil, wm, config))
+	// Make the source font dicts differ from the dest font dicts.
+	for _, entry := range ctxSource.Table {
+		if d, ok := entry.Object.(pdf.Dict); ok && d.Type() != nil && *d.Type() == "Font" {
+			d.Insert("Name", pdf.Name("F"))
+		}
+	}
+
+	err = pdf.MergeXRefTables(ctxSource, ctxDest)
 	if err != nil {
-		t.Fatalf("TestStampPDF: %v\n", err)
+		t.Fatalf("TestMergeConsolidatesFontFiles: %v\n", err)
+	}
+
+	err = OptimizeContext(ctxDest)
+	if err != nil {
+		t.Fatalf("TestMergeConsolidatesFontFiles: %v\n", err)
+	}
+
+	if len(ctxDest.Optimize.DuplicateFontFiles) == 0 {
+		t.Fatal("TestMergeConsolidatesFontFiles: no duplicate font files detected")
+	}
+
+	outFile := filepath.Join(outDir, "test.pdf")
+	ctxDest.Write.Command = "Merge"
+	ctxDest.Write.DirName, ctxDest.Write.FileName = filepath.Split(outFile)
+
+	err = Write(ctxDest)
+	if err != nil {
+		t.Fatalf("TestMergeConsolidatesFontFiles: %v\n", err)
 	}
 
 	_, err = Process(ValidateCommand(outFile, config))
 	if err != nil {
-		t.Fatalf("TestStampPDF: %v\n", err)
+		t.Fatalf("TestMergeConsolidatesFontFiles: %v\n", err)
 	}
 
 }
 
-func TestExtractImagesCommand(t *testing.T) {
+// pageFontObjNrs returns the object numbers of the fonts of page p by resource name.
+func pageFontObjNrs(t *testing.T, ctx *pdf.Context, p int) map[string]int {
+	t.Helper()
 
-	files, err := ioutil.ReadDir(inDir)
+	_, inhPAttrs, err := ctx.PageDict(p)
 	if err != nil {
-		t.Fatalf("TestExtractImagesCommand: %v\n", err)
+		t.Fatalf("pageFontObjNrs: %v\n", err)
 	}
 
-	c := pdf.NewDefaultConfiguration()
-
-	cmd := ExtractImagesCommand("", outDir, nil, c)
+	m := map[string]int{}
 
-	for _, file := range files {
+	fonts, err := ctx.DereferenceDict(inhPAttrs.Resources()["Font"])
+	if err != nil {
+		t.Fatalf("pageFontObjNrs: %v\n", err)
+	}
 
-		if !strings.HasSuffix(file.Name(), "pdf") {
-			continue
+	for k, o := range fonts {
+		if ir, ok := o.(pdf.IndirectRef); ok {
+			m[k] = ir.ObjectNumber.Value()
 		}
+	}
 
-		inFile := filepath.Join(inDir, file.Name())
-		cmd.InFile = &inFile
+	return m
+}
 
-		// Extract all images.
-		_, err := Process(cmd)
-		if err != nil {
-			t.Fatalf("TestExtractImageCommand: %v\n", err)
-		}
+// Merged copies of a file share one copy of each font under the resource names used by their content.
+func TestMergeSharesFonts(t *testing.T) {
 
-	}
+	inFile := filepath.Join(inDir, "go.pdf")
+	outFile := filepath.Join(outDir, "test.pdf")
 
-	// Extract images starting with page 1.
-	inFile := filepath.Join(inDir, "testImage.pdf")
-	_, err = Process(ExtractImagesCommand(inFile, outDir, []string{"1-"}, pdf.NewDefaultConfiguration()))
-	if err != nil {
-		t.Fatalf("TestExtractImageCommand: %v\n", err)
+	config := pdf.NewDefaultConfiguration()
+	config.VerifyPages = true
+
+	if _, err := Process(MergeCommand([]string{inFile, inFile}, outFile, config)); err != nil {
+		t.Fatalf("TestMergeSharesFonts: %v\n", err)
 	}
 
-}
+	ctxIn := readAndValidateFile(t, inFile)
+	ctx := readAndValidateFile(t, outFile)
 
-func TestExtractFontsCommand(t *testing.T) {
+	n, shared := ctxIn.PageCount, 0
 
-	cmd := ExtractFontsCommand("", outDir, nil, pdf.NewDefaultConfiguration())
+	for p := 1; p <= n; p++ {
 
-	for _, fn := range []string{"5116.DCT_Filter.pdf", "testImage.pdf", "go.pdf"} {
+		want := pageFontObjNrs(t, ctx, p)
+		if len(want) == 0 {
+			continue
+		}
+		shared++
 
-		fn = filepath.Join(inDir, fn)
-		cmd.InFile = &fn
+		got := pageFontObjNrs(t, ctx, n+p)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("TestMergeSharesFonts: page %d: want fonts %v, got %v\n", n+p, want, got)
+		}
 
-		_, err := Process(cmd)
+		digest, err := ctx.PageContentDigest(n + p)
 		if err != nil {
-			t.Fatalf("TestExtractFontsCommand: %v\n", err)
+			t.Fatalf("TestMergeSharesFonts: %v\n", err)
+		}
+		wantDigest, err := ctxIn.PageContentDigest(p)
+		if err != nil {
+			t.Fatalf("TestMergeSharesFonts: %v\n", err)
+		}
+		if !bytes.Equal(digest, wantDigest) {
+			t.Fatalf("TestMergeSharesFonts: page %d: content changed\n", n+p)
 		}
+	}
 
+	if shared == 0 {
+		t.Fatal("TestMergeSharesFonts: no fonts found\n")
 	}
+}
+
+// Merged files using different resource names for the same fonts share one name after merging.
+func TestMergeSharesFontNames(t *testing.T) {
 
 	inFile := filepath.Join(inDir, "go.pdf")
-	_, err := Process(ExtractFontsCommand(inFile, outDir, []string{"1-3"}, pdf.NewDefaultConfiguration()))
+	renamedFile := filepath.Join(outDir, "renamedFonts.pdf")
+	outFile := filepath.Join(outDir, "test.pdf")
+
+	// Rename the fonts of the first page.
+	ctx := readAndValidateFile(t, inFile)
+
+	pageDict, inhPAttrs, err := ctx.PageDict(1)
 	if err != nil {
-		t.Fatalf("TestExtractFontsCommand: %v\n", err)
+		t.Fatalf("TestMergeSharesFontNames: %v\n", err)
 	}
 
-}
+	fonts, err := ctx.DereferenceDict(inhPAttrs.Resources()["Font"])
+	if err != nil {
+		t.Fatalf("TestMergeSharesFontNames: %v\n", err)
+	}
 
-func TestExtractContentCommand(t *testing.T) {
+	ir := pageDict.IndirectRefEntry("Contents")
+	if ir == nil {
+		t.Fatal("TestMergeSharesFontNames: missing content stream\n")
+	}
+	entry, _ := ctx.FindTableEntryForIndRef(ir)
+	sd, ok := entry.Object.(pdf.StreamDict)
+	if !ok || len(sd.FilterPipeline) != 1 || sd.FilterPipeline[0].Name != filter.Flate {
+		t.Fatal("TestMergeSharesFontNames: unexpected content stream\n")
+	}
 
-	inFile := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	f, err := filter.NewFilter(filter.Flate, nil)
+	if err != nil {
+		t.Fatalf("TestMergeSharesFontNames: %v\n", err)
+	}
 
-	_, err := Process(ExtractContentCommand(inFile, outDir, nil, pdf.NewDefaultConfiguration()))
+	bb, err := f.Decode(bytes.NewReader(sd.Raw))
 	if err != nil {
-		t.Fatalf("TestExtractContentCommand: %v\n", err)
+		t.Fatalf("TestMergeSharesFontNames: %v\n", err)
 	}
+	content := bb.Bytes()
 
-}
+	var names []string
+	for k := range fonts {
+		names = append(names, k)
+	}
 
-func TestExtractPagesCommand(t *testing.T) {
+	for _, k := range names {
+		fonts["Renamed"+k] = fonts[k]
+		delete(fonts, k)
+		content = bytes.Replace(content, []byte("/"+k+" "), []byte("/Renamed"+k+" "), -1)
+	}
 
-	inFile := filepath.Join(inDir, "TheGoProgrammingLanguageCh1.pdf")
+	sd.Raw = content
+	sd.FilterPipeline = nil
+	sd.Delete("Filter")
+	l := int64(len(content))
+	sd.StreamLength = &l
+	sd.Update("Length", pdf.Integer(l))
+	entry.Object = sd
 
-	_, err := Process(ExtractPagesCommand(inFile, outDir, []string{"1"}, pdf.NewDefaultConfiguration()))
+	w, err := os.Create(renamedFile)
 	if err != nil {
-		t.Fatalf("TestExtractPagesCommand: %v\n", err)
+		t.Fatalf("TestMergeSharesFontNames: %v\n", err)
+	}
+	if err = WriteContext(ctx, w); err != nil {
+		t.Fatalf("TestMergeSharesFontNames: %v\n", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("TestMergeSharesFontNames: %v\n", err)
 	}
 
-}
+	config := pdf.NewDefaultConfiguration()
+	config.VerifyPages = true
 
-func TestEncryptUPWOnly(t *testing.T) {
+	if _, err := Process(MergeCommand([]string{inFile, renamedFile}, outFile, config)); err != nil {
+		t.Fatalf("TestMergeSharesFontNames: %v\n", err)
+	}
 
-	// Test for setting only the user password.
+	ctxIn := readAndValidateFile(t, inFile)
+	ctx = readAndValidateFile(t, outFile)
+	n := ctxIn.PageCount
 
-	t.Log("running TestEncryptUPWOnly..")
+	// The first page of the renamed file uses the fonts of the first page under their original names.
+	want := pageFontObjNrs(t, ctx, 1)
+	got := pageFontObjNrs(t, ctx, n+1)
+	if len(want) == 0 || fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("TestMergeSharesFontNames: page %d: want fonts %v, got %v\n", n+1, want, got)
+	}
 
-	inFile := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	// Its content got remapped back to the original content.
+	digest, err := ctx.PageContentDigest(n + 1)
+	if err != nil {
+		t.Fatalf("TestMergeSharesFontNames: %v\n", err)
+	}
+	wantDigest, err := ctxIn.PageContentDigest(1)
+	if err != nil {
+		t.Fatalf("TestMergeSharesFontNames: %v\n", err)
+	}
+	if !bytes.Equal(digest, wantDigest) {
+		t.Fatalf("TestMergeSharesFontNames: page %d: content not remapped\n", n+1)
+	}
+}
+
+// Trim test PDF file so that only the first two pages are rendered.
+func TestTrimCommand(t *testing.T) {
+
+	inFile := filepath.Join(inDir, "pike-stanford.pdf")
 	outFile := filepath.Join(outDir, "test.pdf")
 
-	// Encrypt upw only
-	t.Log("Encrypt upw only")
 	config := pdf.NewDefaultConfiguration()
-	config.UserPW = "upw"
-	_, err := Process(EncryptCommand(inFile, outFile, config))
+
+	_, err := Process(TrimCommand(inFile, outFile, []string{"-2"}, config))
 	if err != nil {
-		t.Fatalf("TestEncryptUPWOnly - encrypt with upw only to %s: %v\n", outFile, err)
+		t.Fatalf("TestTrimCommand: %v\n", err)
 	}
 
-	// Validate wrong upw
-	t.Log("Validate wrong upw fails")
-	config = pdf.NewDefaultConfiguration()
-	config.UserPW = "upwWrong"
 	_, err = Process(ValidateCommand(outFile, config))
-	if err == nil {
-		t.Fatalf("TestEncryptUPWOnly - validate %s using wrong upw should fail!\n", outFile)
+	if err != nil {
+		t.Fatalf("TestTrimCommand: %v\n", err)
 	}
 
-	// Validate wrong opw
-	t.Log("Validate wrong opw fails")
-	config = pdf.NewDefaultConfiguration()
-	config.OwnerPW = "opwWrong"
-	_, err = Process(ValidateCommand(outFile, config))
-	if err == nil {
-		t.Fatalf("TestEncryptUPWOnly - validate %s using wrong opw should fail!\n", outFile)
-	}
+}
 
-	// Validate default opw=upw (if there is no ownerpw set)
-	t.Log("Validate default opw")
-	config = pdf.NewDefaultConfiguration()
-	config.OwnerPW = "upw"
-	_, err = Process(ValidateCommand(outFile, config))
-	if err != nil {
-		t.Fatalf("TestEncryptUPWOnly - validate %s using default opw: %s!\n", outFile, err)
-	}
+func TestCollectCommand(t *testing.T) {
 
-	// Validate upw
-	t.Log("Validate upw")
-	config = pdf.NewDefaultConfiguration()
-	config.UserPW = "upw"
-	_, err = Process(ValidateCommand(outFile, config))
+	inFile := filepath.Join(inDir, "pike-stanford.pdf")
+	outFile := filepath.Join(outDir, "test.pdf")
+
+	config := pdf.NewDefaultConfiguration()
+	config.VerifyPages = true
+
+	_, err := Process(CollectCommand(inFile, outFile, []string{"3", "1", "1", "5-2"}, config))
 	if err != nil {
-		t.Fatalf("TestEncryptUPWOnly - validate %s using upw: %v\n", outFile, err)
+		t.Fatalf("TestCollectCommand: %v\n", err)
 	}
 
-	// Optimize wrong opw
-	t.Log("Optimize wrong opw fails")
-	config = pdf.NewDefaultConfiguration()
-	config.OwnerPW = "opwWrong"
-	_, err = Process(OptimizeCommand(outFile, outFile, config))
-	if err == nil {
-		t.Fatalf("TestEncryptUPWOnly - optimize %s using wrong opw should fail!\n", outFile)
-	}
+	ctxIn := readAndValidateFile(t, inFile)
+	ctx := readAndValidateFile(t, outFile)
 
-	// Optimize empty opw
-	t.Log("Optimize empty opw fails")
-	config = pdf.NewDefaultConfiguration()
-	config.OwnerPW = ""
-	_, err = Process(OptimizeCommand(outFile, outFile, config))
-	if err == nil {
-		t.Fatalf("TestEncryptUPWOnly - optimize %s using empty opw should fail!\n", outFile)
-	}
+	pages := []int{3, 1, 1, 5, 4, 3, 2}
 
-	// Optimize wrong upw
-	t.Log("Optimize wrong upw fails")
-	config = pdf.NewDefaultConfiguration()
-	config.UserPW = "upwWrong"
-	_, err = Process(OptimizeCommand(outFile, outFile, config))
-	if err == nil {
-		t.Fatalf("TestEncryptUPWOnly - optimize %s using wrong upw should fail!\n", outFile)
+	if ctx.PageCount != len(pages) {
+		t.Fatalf("TestCollectCommand: want %d pages, got %d\n", len(pages), ctx.PageCount)
 	}
 
-	// Optimize upw
-	t.Log("Optimize upw")
-	config = pdf.NewDefaultConfiguration()
-	config.UserPW = "upw"
-	_, err = Process(OptimizeCommand(outFile, outFile, config))
-	if err != nil {
-		t.Fatalf("TestEncryptUPWOnly - optimize %s using upw: %v\n", outFile, err)
-	}
+	for i, p := range pages {
 
-	//Change upw wrong upwOld
-	t.Log("ChangeUserPW wrong upwOld fails")
-	config = pdf.NewDefaultConfiguration()
-	pwOld := "upwWrong"
-	pwNew := "upwNew"
-	_, err = Process(ChangeUserPWCommand(outFile, outFile, config, &pwOld, &pwNew))
-	if err == nil {
-		t.Fatalf("TestEncryptUPWOnly - %s change userPW using wrong upwOld should fail\n", outFile)
+		want, err := ctxIn.PageContentDigest(p)
+		if err != nil {
+			t.Fatalf("TestCollectCommand: %v\n", err)
+		}
+
+		got, err := ctx.PageContentDigest(i + 1)
+		if err != nil {
+			t.Fatalf("TestCollectCommand: %v\n", err)
+		}
+
+		if !bytes.Equal(got, want) {
+			t.Fatalf("TestCollectCommand: page %d: want content of page %d\n", i+1, p)
+		}
 	}
 
-	// Change upw
-	t.Log("ChangeUserPW")
-	config = pdf.NewDefaultConfiguration()
-	pwOld = "upw"
-	pwNew = "upwNew"
-	_, err = Process(ChangeUserPWCommand(outFile, outFile, config, &pwOld, &pwNew))
+	// Repeated pages are distinct page objects.
+	ir2, err := ctx.PageIndRef(2)
 	if err != nil {
-		t.Fatalf("TestEncryptUPWOnly - %s change userPW: %v\n", outFile, err)
+		t.Fatalf("TestCollectCommand: %v\n", err)
+	}
+	ir3, err := ctx.PageIndRef(3)
+	if err != nil {
+		t.Fatalf("TestCollectCommand: %v\n", err)
+	}
+	if ir2.ObjectNumber == ir3.ObjectNumber {
+		t.Fatalf("TestCollectCommand: pages 2 and 3 share obj#%d\n", ir2.ObjectNumber)
 	}
+}
 
-	// Decrypt wrong opw
+func TestCollectArticleThreads(t *testing.T) {
+
+	// Article beads still refer to dropped pages and their former page tree nodes.
+	inFile := filepath.Join(inDir, "CenterOfWhy.pdf")
+	outFile := filepath.Join(outDir, "test.pdf")
+
+	if _, err := Process(CollectCommand(inFile, outFile, []string{"2", "1", "1"}, pdf.NewDefaultConfiguration())); err != nil {
+		t.Fatalf("TestCollectArticleThreads: %v\n", err)
+	}
+
+	if ctx := readAndValidateFile(t, outFile); ctx.PageCount != 3 {
+		t.Fatalf("TestCollectArticleThreads: want 3 pages, got %d\n", ctx.PageCount)
+	}
+}
+
+func TestRemovePagesCommand(t *testing.T) {
+
+	inFile := filepath.Join(inDir, "Wonderwall.pdf")
+	outFile := filepath.Join(outDir, "test.pdf")
+
+	config := pdf.NewDefaultConfiguration()
+	config.VerifyPages = true
+
+	_, err := Process(RemovePagesCommand(inFile, outFile, []string{"2-5", "!5"}, config))
+	if err != nil {
+		t.Fatalf("TestRemovePagesCommand: %v\n", err)
+	}
+
+	ctxIn := readAndValidateFile(t, inFile)
+	ctx := readAndValidateFile(t, outFile)
+
+	pages := []int{1, 5, 6}
+
+	if ctx.PageCount != len(pages) {
+		t.Fatalf("TestRemovePagesCommand: want %d pages, got %d\n", len(pages), ctx.PageCount)
+	}
+
+	for i, p := range pages {
+
+		want, err := ctxIn.PageContentDigest(p)
+		if err != nil {
+			t.Fatalf("TestRemovePagesCommand: %v\n", err)
+		}
+
+		got, err := ctx.PageContentDigest(i + 1)
+		if err != nil {
+			t.Fatalf("TestRemovePagesCommand: %v\n", err)
+		}
+
+		if !bytes.Equal(got, want) {
+			t.Fatalf("TestRemovePagesCommand: page %d: want content of page %d\n", i+1, p)
+		}
+	}
+
+	// Each page has an image of its own, images of removed pages are gone.
+	images, err := ExtractImageSlicesFromContext(ctx, nil)
+	if err != nil {
+		t.Fatalf("TestRemovePagesCommand: %v\n", err)
+	}
+	if len(images) != len(pages) {
+		t.Fatalf("TestRemovePagesCommand: want %d images, got %d\n", len(pages), len(images))
+	}
+
+	used := pdf.IntSet{}
+	for _, img := range images {
+		used[img.ObjNr] = true
+	}
+
+	for objNr, e := range ctx.Table {
+		sd, ok := e.Object.(pdf.StreamDict)
+		if !ok || sd.Subtype() == nil || *sd.Subtype() != "Image" {
+			continue
+		}
+		if !used[objNr] {
+			t.Fatalf("TestRemovePagesCommand: orphaned image obj#%d\n", objNr)
+		}
+	}
+
+	// Removing all pages fails.
+	if _, err = Process(RemovePagesCommand(inFile, outFile, []string{"1-"}, config)); err == nil {
+		t.Fatalf("TestRemovePagesCommand: removing all pages should fail\n")
+	}
+}
+
+func TestRemovePagesArticleThreads(t *testing.T) {
+
+	// Article beads still refer to removed pages and their former page tree nodes.
+	inFile := filepath.Join(inDir, "CenterOfWhy.pdf")
+	outFile := filepath.Join(outDir, "test.pdf")
+
+	if _, err := Process(RemovePagesCommand(inFile, outFile, []string{"3-"}, pdf.NewDefaultConfiguration())); err != nil {
+		t.Fatalf("TestRemovePagesArticleThreads: %v\n", err)
+	}
+
+	if ctx := readAndValidateFile(t, outFile); ctx.PageCount != 2 {
+		t.Fatalf("TestRemovePagesArticleThreads: want 2 pages, got %d\n", ctx.PageCount)
+	}
+}
+
+// Verify page counts and page contents after trimming and merging.
+
+func TestResizeCommand(t *testing.T) {
+
+	for _, s := range []string{"", "paper:A4, dim:100 100", "paper:A11", "paper:L", "margin:10", "dim:100 100, margin:50", "dim:100"} {
+		if _, err := pdf.ParseResizeDetails(s); err == nil {
+			t.Fatalf("TestResizeCommand: %q: want error\n", s)
+		}
+	}
+
+	inFile := filepath.Join(inDir, "Wonderwall.pdf")
+	outFile := filepath.Join(outDir, "resize.pdf")
+
+	ctxIn := readAndValidateFile(t, inFile)
+
+	for _, tt := range []struct {
+		desc string
+		want types.Dim
+	}{
+		{"paper:A4, margin:36", *types.PaperSize["A4"]},
+		{"paper:A5L", types.Dim{W: types.PaperSize["A5"].H, H: types.PaperSize["A5"].W}},
+		{"dim:200 100", types.Dim{W: 200, H: 100}},
+	} {
+
+		r, err := pdf.ParseResizeDetails(tt.desc)
+		if err != nil {
+			t.Fatalf("TestResizeCommand: %s: %v\n", tt.desc, err)
+		}
+
+		if _, err = Process(ResizeCommand(inFile, outFile, []string{"1-3"}, r, pdf.NewDefaultConfiguration())); err != nil {
+			t.Fatalf("TestResizeCommand: %s: %v\n", tt.desc, err)
+		}
+
+		ctx := readAndValidateFile(t, outFile)
+
+		for p := 1; p <= ctx.PageCount; p++ {
+
+			_, inhPAttrs, err := ctx.PageDict(p)
+			if err != nil {
+				t.Fatalf("TestResizeCommand: %s: %v\n", tt.desc, err)
+			}
+
+			_, inhPAttrsIn, err := ctxIn.PageDict(p)
+			if err != nil {
+				t.Fatalf("TestResizeCommand: %s: %v\n", tt.desc, err)
+			}
+
+			want := rectForArray(ctxIn, inhPAttrsIn.MediaBox())
+			if p <= 3 {
+				want = types.NewRectangle(0, 0, tt.want.W, tt.want.H)
+			}
+
+			mb := rectForArray(ctx, inhPAttrs.MediaBox())
+			if mb != want {
+				t.Fatalf("TestResizeCommand: %s: page %d: want media box %s, got %s\n", tt.desc, p, want, mb)
+			}
+
+			if p > 3 {
+				continue
+			}
+
+			// The image keeps its aspect ratio and stays within the margins.
+			ipp, err := pdf.PageImagePlacements(ctx.XRefTable, p)
+			if err != nil || len(ipp) != 1 {
+				t.Fatalf("TestResizeCommand: %s: page %d: want 1 image, got %d: %v\n", tt.desc, p, len(ipp), err)
+			}
+
+			ippIn, err := pdf.PageImagePlacements(ctxIn.XRefTable, p)
+			if err != nil || len(ippIn) != 1 {
+				t.Fatalf("TestResizeCommand: %s: page %d: want 1 image, got %d: %v\n", tt.desc, p, len(ippIn), err)
+			}
+
+			ip, ipIn := ipp[0], ippIn[0]
+
+			if math.Abs(ip.Width/ip.Height-ipIn.Width/ipIn.Height) > 0.01 {
+				t.Fatalf("TestResizeCommand: %s: page %d: distorted image %.2f x %.2f\n", tt.desc, p, ip.Width, ip.Height)
+			}
+
+			m := r.Margin - 0.01
+			if ip.X < m || ip.Y < m || ip.X+ip.Width > mb.Width()-m || ip.Y+ip.Height > mb.Height()-m {
+				t.Fatalf("TestResizeCommand: %s: page %d: image outside margins: %v\n", tt.desc, p, ip)
+			}
+		}
+	}
+}
+
+func TestPosterCommand(t *testing.T) {
+
+	for _, s := range []string{"", "grid:2 2, paper:A4", "grid:0 2", "grid:2", "paper:A11", "overlap:10", "dim:100 100, overlap:100"} {
+		if _, err := pdf.ParsePosterDetails(s); err == nil {
+			t.Fatalf("TestPosterCommand: %q: want error\n", s)
+		}
+	}
+
+	inFile := filepath.Join(inDir, "Wonderwall.pdf")
+	outFile := filepath.Join(outDir, "poster.pdf")
+
+	ctxIn := readAndValidateFile(t, inFile)
+
+	ippIn, err := pdf.PageImagePlacements(ctxIn.XRefTable, 1)
+	if err != nil || len(ippIn) != 1 {
+		t.Fatalf("TestPosterCommand: want 1 image, got %d: %v\n", len(ippIn), err)
+	}
+	ipIn := ippIn[0]
+
+	// 595 x 842 split into 2 x 3 tiles overlapping by 10.
+	p, err := pdf.ParsePosterDetails("grid:2 3, overlap:10")
+	if err != nil {
+		t.Fatalf("TestPosterCommand: %v\n", err)
+	}
+
+	if _, err = Process(PosterCommand(inFile, outFile, []string{"1"}, p, pdf.NewDefaultConfiguration())); err != nil {
+		t.Fatalf("TestPosterCommand: %v\n", err)
+	}
+
+	ctx := readAndValidateFile(t, outFile)
+
+	if ctx.PageCount != ctxIn.PageCount+5 {
+		t.Fatalf("TestPosterCommand: want %d pages, got %d\n", ctxIn.PageCount+5, ctx.PageCount)
+	}
+
+	w, h := (595+10)/2., (842+2*10)/3.
+
+	for i, o := range []struct{ x, y float64 }{{0, 842 - h}, {w - 10, 842 - h}, {0, 0}, {w - 10, 0}} {
+
+		pageNr := []int{1, 2, 5, 6}[i]
+
+		_, inhPAttrs, err := ctx.PageDict(pageNr)
+		if err != nil {
+			t.Fatalf("TestPosterCommand: %v\n", err)
+		}
+
+		if mb := rectForArray(ctx, inhPAttrs.MediaBox()); math.Abs(mb.Width()-w) > 0.01 || math.Abs(mb.Height()-h) > 0.01 {
+			t.Fatalf("TestPosterCommand: page %d: want tile size %.2f x %.2f, got %s\n", pageNr, w, h, mb)
+		}
+
+		// Every tile shows its region of the image at the original scale.
+		ipp, err := pdf.PageImagePlacements(ctx.XRefTable, pageNr)
+		if err != nil || len(ipp) != 1 {
+			t.Fatalf("TestPosterCommand: page %d: want 1 image, got %d: %v\n", pageNr, len(ipp), err)
+		}
+
+		ip := ipp[0]
+		if math.Abs(ip.X-(ipIn.X-o.x)) > 0.01 || math.Abs(ip.Y-(ipIn.Y-o.y)) > 0.01 || math.Abs(ip.Width-ipIn.Width) > 0.01 {
+			t.Fatalf("TestPosterCommand: page %d: unexpected image placement %v, source %v\n", pageNr, ip, ipIn)
+		}
+	}
+
+	// A5 landscape needs 1 x 3 tiles, portrait 2 x 2.
+	if p, err = pdf.ParsePosterDetails("paper:A5"); err != nil {
+		t.Fatalf("TestPosterCommand: %v\n", err)
+	}
+
+	if _, err = Process(PosterCommand(inFile, outFile, nil, p, pdf.NewDefaultConfiguration())); err != nil {
+		t.Fatalf("TestPosterCommand: %v\n", err)
+	}
+
+	ctx = readAndValidateFile(t, outFile)
+
+	if ctx.PageCount != 3*ctxIn.PageCount {
+		t.Fatalf("TestPosterCommand: want %d pages, got %d\n", 3*ctxIn.PageCount, ctx.PageCount)
+	}
+
+	_, inhPAttrs, err := ctx.PageDict(1)
+	if err != nil {
+		t.Fatalf("TestPosterCommand: %v\n", err)
+	}
+
+	a5 := types.PaperSize["A5"]
+	if mb := rectForArray(ctx, inhPAttrs.MediaBox()); mb != types.NewRectangle(0, 0, a5.H, a5.W) {
+		t.Fatalf("TestPosterCommand: want landscape A5 tiles, got %s\n", mb)
+	}
+}
+
+func TestPosterOutlines(t *testing.T) {
+
+	// Outlines and named destinations still refer to the page replaced by its tiles.
+	inFile := filepath.Join(inDir, "T4.pdf")
+	outFile := filepath.Join(outDir, "poster.pdf")
+
+	ctxIn := readAndValidateFile(t, inFile)
+
+	if _, err := Process(PosterCommand(inFile, outFile, []string{"1"}, &pdf.Poster{Cols: 2, Rows: 2}, pdf.NewDefaultConfiguration())); err != nil {
+		t.Fatalf("TestPosterOutlines: %v\n", err)
+	}
+
+	if ctx := readAndValidateFile(t, outFile); ctx.PageCount != ctxIn.PageCount+3 {
+		t.Fatalf("TestPosterOutlines: want %d pages, got %d\n", ctxIn.PageCount+3, ctx.PageCount)
+	}
+}
+func rectForArray(ctx *pdf.Context, a pdf.Array) types.Rectangle {
+	return types.NewRectangle(ctx.DereferenceNumber(a[0]), ctx.DereferenceNumber(a[1]), ctx.DereferenceNumber(a[2]), ctx.DereferenceNumber(a[3]))
+}
+
+func TestCropCommand(t *testing.T) {
+
+	for _, s := range []string{"", "margin:10, rect:0 0 10 10", "pos:tl", "margin:10, pos:c", "dim:0 10", "margin:1 2 3", "boxes:crop, margin:10", "rect:1 1 1 5"} {
+		if _, err := pdf.ParseCropDetails(s); err == nil {
+			t.Fatalf("TestCropCommand: %q: want error\n", s)
+		}
+	}
+
+	inFile := filepath.Join(inDir, "Wonderwall.pdf")
+	outFile := filepath.Join(outDir, "crop.pdf")
+
+	ctxIn := readAndValidateFile(t, inFile)
+	_, inhPAttrs, err := ctxIn.PageDict(1)
+	if err != nil {
+		t.Fatalf("TestCropCommand: %v\n", err)
+	}
+	mb := rectForArray(ctxIn, inhPAttrs.MediaBox())
+
+	for _, tt := range []struct {
+		desc string
+		want types.Rectangle
+	}{
+		{"margin:10 20, boxes:trim bleed", types.NewRectangle(mb.LL.X+20, mb.LL.Y+10, mb.UR.X-20, mb.UR.Y-10)},
+		{"dim:100 50, pos:tr", types.NewRectangle(mb.UR.X-100, mb.UR.Y-50, mb.UR.X, mb.UR.Y)},
+		{"rect:-10 -10 100 100", types.NewRectangle(mb.LL.X, mb.LL.Y, 100, 100)},
+	} {
+
+		c, err := pdf.ParseCropDetails(tt.desc)
+		if err != nil {
+			t.Fatalf("TestCropCommand: %s: %v\n", tt.desc, err)
+		}
+
+		if _, err = Process(CropCommand(inFile, outFile, []string{"1-2"}, c, pdf.NewDefaultConfiguration())); err != nil {
+			t.Fatalf("TestCropCommand: %s: %v\n", tt.desc, err)
+		}
+
+		ctx := readAndValidateFile(t, outFile)
+
+		for p := 1; p <= ctx.PageCount; p++ {
+
+			d, inhPAttrs, err := ctx.PageDict(p)
+			if err != nil {
+				t.Fatalf("TestCropCommand: %s: %v\n", tt.desc, err)
+			}
+
+			want := mb
+			if p <= 2 {
+				want = tt.want
+			}
+
+			if got := rectForArray(ctx, inhPAttrs.CropBox()); got != want {
+				t.Fatalf("TestCropCommand: %s: page %d: want crop box %s, got %s\n", tt.desc, p, want, got)
+			}
+
+			for _, k := range c.Boxes {
+				if a := d.ArrayEntry(k); p <= 2 && (a == nil || rectForArray(ctx, a) != want) {
+					t.Fatalf("TestCropCommand: %s: page %d: want %s %s, got %v\n", tt.desc, p, k, want, a)
+				}
+			}
+		}
+	}
+}
+func TestVerifyPages(t *testing.T) {
+
+	inFile := filepath.Join(inDir, "pike-stanford.pdf")
+	outFile := filepath.Join(outDir, "test.pdf")
+
+	config := pdf.NewDefaultConfiguration()
+	config.VerifyPages = true
+
+	_, err := Process(TrimCommand(inFile, outFile, []string{"2-4", "7"}, config))
+	if err != nil {
+		t.Fatalf("TestVerifyPages - trim: %v\n", err)
+	}
+
+	inFiles := []string{inFile, filepath.Join(inDir, "go.pdf")}
+	_, err = Process(MergeCommand(inFiles, outFile, config))
+	if err != nil {
+		t.Fatalf("TestVerifyPages - merge: %v\n", err)
+	}
+
+	// Verification against a wrong plan fails.
+	ctx, err := ReadContextFromFile(inFile, config)
+	if err != nil {
+		t.Fatalf("TestVerifyPages: %v\n", err)
+	}
+
+	err = ValidateContext(ctx)
+	if err != nil {
+		t.Fatalf("TestVerifyPages: %v\n", err)
+	}
+
+	digests, err := pageDigests(ctx, pdf.IntSet{1: true, 2: true})
+	if err != nil {
+		t.Fatalf("TestVerifyPages: %v\n", err)
+	}
+
+	ctx.Write.DirName, ctx.Write.FileName = filepath.Split(outFile)
+
+	if err = verifyPages(ctx, digests); err == nil {
+		t.Fatalf("TestVerifyPages - page count mismatch for %s should fail!\n", outFile)
+	}
+
+	digests, err = pageDigests(ctx, nil)
+	if err != nil {
+		t.Fatalf("TestVerifyPages: %v\n", err)
+	}
+
+	digests = append(digests[1:], digests[0])
+
+	if err = verifyPages(ctx, digests); err == nil {
+		t.Fatalf("TestVerifyPages - content mismatch for %s should fail!\n", outFile)
+	}
+
+}
+
+// Add text watermark to all pages of inFile starting at page 1 using a rotation angle of 20 degrees.
+func TestWatermarkText(t *testing.T) {
+
+	inFile := filepath.Join(inDir, "Acroforms2.pdf")
+	outFile := filepath.Join(outDir, "testwm.pdf")
+
+	onTop := false
+	wm, err := pdf.ParseWatermarkDetails("Draft, s:0.7, r:20", onTop)
+	if err != nil {
+		t.Fatalf("TestWatermarkText: %v\n", err)
+	}
+
+	config := pdf.NewDefaultConfiguration()
+
+	_, err = Process(AddWatermarksCommand(inFile, outFile, []string{"1-"}, wm, config))
+	if err != nil {
+		t.Fatalf("TestWatermarkText: %v\n", err)
+	}
+
+	_, err = Process(ValidateCommand(outFile, config))
+	if err != nil {
+		t.Fatalf("TestWatermarkText: %v\n", err)
+	}
+
+}
+
+// Add a greenish, slightly transparent stroked and filled text stamp to all odd pages of inFile other than page 1
+// using the default rotation which is aligned along the first diagonal running from lower left to upper right corner.
+func TestStampText(t *testing.T) {
+
+	inFile := filepath.Join(inDir, "pike-stanford.pdf")
+	outFile := filepath.Join(outDir, "testStampText1.pdf")
+
+	onTop := true
+	wm, err := pdf.ParseWatermarkDetails("Demo, f:Courier, c: 0 .8 0, o:0.8, m:2", onTop)
+	if err != nil {
+		t.Fatalf("TestStampText: %v\n", err)
+	}
+
+	config := pdf.NewDefaultConfiguration()
+
+	_, err = Process(AddWatermarksCommand(inFile, outFile, []string{"odd", "!1"}, wm, config))
+	if err != nil {
+		t.Fatalf("TestStampText: %v\n", err)
+	}
+
+	_, err = Process(ValidateCommand(outFile, config))
+	if err != nil {
+		t.Fatalf("TestStampText: %v\n", err)
+	}
+
+}
+
+// Add a red filled text stamp to all odd pages of inFile other than page 1 using a font size of 48 points
+// and the default rotation which is aligned along the first diagonal running from lower left to upper right corner.
+
+func TestStampTextUsingFontsize(t *testing.T) {
+
+	inFile := filepath.Join(inDir, "pike-stanford.pdf")
+	outFile := filepath.Join(outDir, "testStampText2.pdf")
+
+	onTop := true
+	wm, err := pdf.ParseWatermarkDetails("Demo, f:Courier, c: 1 0 0, o:1, s:1 abs, p:48", onTop)
+	if err != nil {
+		t.Fatalf("TestStampTextUsingFontsize: %v\n", err)
+	}
+
+	config := pdf.NewDefaultConfiguration()
+
+	_, err = Process(AddWatermarksCommand(inFile, outFile, []string{"odd", "!1"}, wm, config))
+	if err != nil {
+		t.Fatalf("TestStampTextUsingFontsize: %v\n", err)
+	}
+
+	_, err = Process(ValidateCommand(outFile, config))
+	if err != nil {
+		t.Fatalf("TestStampTextUsingFontsize: %v\n", err)
+	}
+
+}
+
+// Add image watermark to inFile starting at page 1 using no rotation.
+func TestWatermarkImage(t *testing.T) {
+
+	inFile := filepath.Join(inDir, "Acroforms2.pdf")
+	outFile := filepath.Join(outDir, "testWMImageRel.pdf")
+	imageFile := filepath.Join(resDir, "pdfchip3.png")
+
+	onTop := false
+	wm, err := pdf.ParseWatermarkDetails(imageFile+", r:0", onTop)
+	if err != nil {
+		t.Fatalf("TestWatermarkImage: %v\n", err)
+	}
+
+	config := pdf.NewDefaultConfiguration()
+
+	_, err = Process(AddWatermarksCommand(inFile, outFile, []string{"1-"}, wm, config))
+	if err != nil {
+		t.Fatalf("TestWatermarkImage: %v\n", err)
+	}
+
+	_, err = Process(ValidateCommand(outFile, config))
+	if err != nil {
+		t.Fatalf("TestWatermarkImage: %v\n", err)
+	}
+
+}
+
+// Add image stamp to inFile using absolute scaling and a a negative rotation of 90.
+func TestStampImageAbsScaling(t *testing.T) {
+
+	inFile := filepath.Join(inDir, "Acroforms2.pdf")
+	outFile := filepath.Join(outDir, "testWMImageAbs.pdf")
+	imageFile := filepath.Join(resDir, "pdfchip3.png")
+
+	onTop := true
+	wm, err := pdf.ParseWatermarkDetails(imageFile+", s:.5 a, r:-90", onTop)
+	if err != nil {
+		t.Fatalf("TestStampImageAbsScaling: %v\n", err)
+	}
+
+	config := pdf.NewDefaultConfiguration()
+
+	_, err = Process(AddWatermarksCommand(inFile, outFile, []string{"1-"}, wm, config))
+	if err != nil {
+		t.Fatalf("TestStampImageAbsScaling: %v\n", err)
+	}
+
+	_, err = Process(ValidateCommand(outFile, config))
+	if err != nil {
+		t.Fatalf("TestStampImageAbsScaling: %v\n", err)
+	}
+
+}
+
+// Add PDF stamp to all pages of inFile using the 2nd page of pdfFile
+// and rotate along the 2nd diagonal running from upper left to lower right corner.
+func TestStampPDF(t *testing.T) {
+
+	inFile := filepath.Join(inDir, "Acroforms2.pdf")
+	pdfFile := filepath.Join(inDir, "Wonderwall.pdf")
+	outFile := filepath.Join(outDir, "testStampPDF.pdf")
+
+	onTop := true
+	wm, err := pdf.ParseWatermarkDetails(pdfFile+":2, d:2", onTop)
+	if err != nil {
+		t.Fatalf("TestStampPDF: %v\n", err)
+	}
+
+	config := pdf.NewDefaultConfiguration()
+
+	_, err = Process(AddWatermarksCommand(inFile, outFile, nil, wm, config))
+	if err != nil {
+		t.Fatalf("TestStampPDF: %v\n", err)
+	}
+
+	_, err = Process(ValidateCommand(outFile, config))
+	if err != nil {
+		t.Fatalf("TestStampPDF: %v\n", err)
+	}
+
+	// The page gets imported as a form XObject the stamp form refers to.
+	srcCtx := readAndValidateFile(t, pdfFile)
+	_, srcAttrs, err := srcCtx.PageDict(2)
+	if err != nil {
+		t.Fatalf("TestStampPDF: %v\n", err)
+	}
+	a := srcAttrs.CropBox()
+	if a == nil {
+		a = srcAttrs.MediaBox()
+	}
+	vp := rectForArray(srcCtx, a)
+
+	ctx := readAndValidateFile(t, outFile)
+
+	_, inhPAttrs, err := ctx.PageDict(1)
+	if err != nil {
+		t.Fatalf("TestStampPDF: %v\n", err)
+	}
+
+	xObjDict, err := ctx.DereferenceDict(inhPAttrs.Resources()["XObject"])
+	if err != nil {
+		t.Fatalf("TestStampPDF: %v\n", err)
+	}
+
+	var found bool
+
+	for _, o := range xObjDict {
+		sd, err := ctx.DereferenceStreamDict(o)
+		if err != nil || sd == nil || sd.Dict["OC"] == nil {
+			continue
+		}
+		resDict, err := ctx.DereferenceDict(sd.Dict["Resources"])
+		if err != nil {
+			t.Fatalf("TestStampPDF: %v\n", err)
+		}
+		fm0, err := ctx.DereferenceStreamDict(resDict.DictEntry("XObject")["Fm0"])
+		if err != nil || fm0 == nil {
+			t.Fatalf("TestStampPDF: missing imported page: %v\n", err)
+		}
+		if s := fm0.Subtype(); s == nil || *s != "Form" {
+			t.Fatalf("TestStampPDF: imported page is no form: %v\n", s)
+		}
+		if bb := rectForArray(ctx, fm0.ArrayEntry("BBox")); bb != vp {
+			t.Fatalf("TestStampPDF: want BBox %s, got %s\n", vp, bb)
+		}
+		found = true
+	}
+
+	if !found {
+		t.Fatal("TestStampPDF: missing stamp form\n")
+	}
+}
+
+func pageContentDigests(t *testing.T, fileName string) [][]byte {
+
+	ctx := readAndValidateFile(t, fileName)
+
+	var dd [][]byte
+
+	for i := 1; i <= ctx.PageCount; i++ {
+		d, err := ctx.PageContentDigest(i)
+		if err != nil {
+			t.Fatalf("%s: %v\n", fileName, err)
+		}
+		dd = append(dd, d)
+	}
+
+	return dd
+}
+
+func TestRemoveWatermarks(t *testing.T) {
+
+	config := pdf.NewDefaultConfiguration()
+
+	for _, tt := range []struct {
+		fileName, desc string
+		onTop          bool
+	}{
+		{"pike-stanford.pdf", "Draft, d:2", true},
+		{"Acroforms2.pdf", "Confidential", false},
+		{"5116.DCT_Filter.pdf", "testdata/resources/pdfchip3.png, o:0.5", true},
+		{"Acroforms2.pdf", "testdata/Wonderwall.pdf:2", false},
+	} {
+
+		inFile := filepath.Join(inDir, tt.fileName)
+		wmFile := filepath.Join(outDir, "testRemoveWatermarksIn.pdf")
+		outFile := filepath.Join(outDir, "testRemoveWatermarks.pdf")
+
+		want := pageContentDigests(t, inFile)
+
+		wm, err := pdf.ParseWatermarkDetails(tt.desc, tt.onTop)
+		if err != nil {
+			t.Fatalf("TestRemoveWatermarks: %v\n", err)
+		}
+
+		if _, err = Process(AddWatermarksCommand(inFile, wmFile, nil, wm, config)); err != nil {
+			t.Fatalf("TestRemoveWatermarks: %s: %v\n", tt.desc, err)
+		}
+
+		// Remove the first page only.
+		if _, err = Process(RemoveWatermarksCommand(wmFile, outFile, []string{"1"}, config)); err != nil {
+			t.Fatalf("TestRemoveWatermarks: %s: %v\n", tt.desc, err)
+		}
+
+		got := pageContentDigests(t, outFile)
+		for i := range want {
+			if removed := bytes.Equal(got[i], want[i]); removed != (i == 0) {
+				t.Fatalf("TestRemoveWatermarks: %s: page %d removed=%t\n", tt.desc, i+1, removed)
+			}
+		}
+
+		if _, err = Process(RemoveWatermarksCommand(outFile, outFile, nil, config)); err != nil {
+			t.Fatalf("TestRemoveWatermarks: %s: %v\n", tt.desc, err)
+		}
+
+		got = pageContentDigests(t, outFile)
+		for i := range want {
+			if !bytes.Equal(got[i], want[i]) {
+				t.Fatalf("TestRemoveWatermarks: %s: page %d content not restored\n", tt.desc, i+1)
+			}
+		}
+
+		ctx := readAndValidateFile(t, outFile)
+		if ctx.RootDict["OCProperties"] != nil {
+			t.Fatalf("TestRemoveWatermarks: %s: OCProperties left\n", tt.desc)
+		}
+
+		// Nothing left to remove.
+		if _, err = Process(RemoveWatermarksCommand(outFile, outFile, nil, config)); err == nil {
+			t.Fatalf("TestRemoveWatermarks: %s: want error\n", tt.desc)
+		}
+
+		// The file may get stamped again.
+		if wm, err = pdf.ParseWatermarkDetails(tt.desc, tt.onTop); err != nil {
+			t.Fatalf("TestRemoveWatermarks: %v\n", err)
+		}
+		if _, err = Process(AddWatermarksCommand(outFile, outFile, nil, wm, config)); err != nil {
+			t.Fatalf("TestRemoveWatermarks: %s: %v\n", tt.desc, err)
+		}
+	}
+}
+
+// Remove watermarks named by earlier versions of pdfcpu from pages
+// sharing their resources and content streams.
+func TestRemoveWatermarksSharedResources(t *testing.T) {
+
+	inFile := filepath.Join(outDir, "legacyWatermarks.pdf")
+	outFile := filepath.Join(outDir, "legacyWatermarksRemoved.pdf")
+
+	content := " /Artifact <</Subtype /Watermark /Type /Pagination >>BDC q 1.00 0.00 0.00 1.00 10.00 10.00 cm /GS0 gs /Fm0 Do Q EMC " +
+		"0 0 m 100 100 l S"
+	form := "0 0 50 50 re f"
+
+	// Pages 1 and 2 share their content, all pages inherit their resources.
+	objs := []string{
+		"<</Type/Catalog/Pages 2 0 R/OCProperties<</OCGs[8 0 R]/D<</Order[8 0 R]/ON[8 0 R]>>>>>>",
+		"<</Type/Pages/Kids[3 0 R 4 0 R 5 0 R]/Count 3/MediaBox[0 0 200 200]" +
+			"/Resources<</ExtGState<</GS0 9 0 R>>/XObject<</Fm0 7 0 R>>>>>>",
+		"<</Type/Page/Parent 2 0 R/Contents 6 0 R>>",
+		"<</Type/Page/Parent 2 0 R/Contents 6 0 R>>",
+		"<</Type/Page/Parent 2 0 R/Contents 10 0 R>>",
+		fmt.Sprintf("<</Length %d>>\nstream\n%s\nendstream", len(content), content),
+		fmt.Sprintf("<</Type/XObject/Subtype/Form/BBox[0 0 50 50]/OC 8 0 R/Length %d>>\nstream\n%s\nendstream", len(form), form),
+		"<</Type/OCG/Name(Background)>>",
+		"<</Type/ExtGState/CA 0.5/ca 0.5>>",
+		fmt.Sprintf("<</Length %d>>\nstream\n%s\nendstream", len(content), content),
+	}
+
+	if err := writeObjectsPDF(inFile, objs); err != nil {
+		t.Fatalf("TestRemoveWatermarksSharedResources: %v\n", err)
+	}
+
+	hasWatermark := func(ctx *pdf.Context, p int) bool {
+		t.Helper()
+		_, attrs, err := ctx.PageDict(p)
+		if err != nil {
+			t.Fatalf("TestRemoveWatermarksSharedResources: %v\n", err)
+		}
+		xObjs, err := ctx.DereferenceDict(attrs.Resources()["XObject"])
+		if err != nil {
+			t.Fatalf("TestRemoveWatermarksSharedResources: %v\n", err)
+		}
+		_, found := xObjs.Find("Fm0")
+		return found
+	}
+
+	config := pdf.NewDefaultConfiguration()
+
+	if _, err := Process(RemoveWatermarksCommand(inFile, outFile, []string{"1-2"}, config)); err != nil {
+		t.Fatalf("TestRemoveWatermarksSharedResources: %v\n", err)
+	}
+
+	ctx := readAndValidateFile(t, outFile)
+
+	for i, want := range []bool{false, false, true} {
+		if got := hasWatermark(ctx, i+1); got != want {
+			t.Fatalf("TestRemoveWatermarksSharedResources: page %d: want watermark %t, got %t\n", i+1, want, got)
+		}
+	}
+
+	if ctx.RootDict["OCProperties"] == nil {
+		t.Fatal("TestRemoveWatermarksSharedResources: OCProperties removed while in use\n")
+	}
+
+	if _, err := Process(RemoveWatermarksCommand(outFile, outFile, nil, config)); err != nil {
+		t.Fatalf("TestRemoveWatermarksSharedResources: %v\n", err)
+	}
+
+	ctx = readAndValidateFile(t, outFile)
+
+	if hasWatermark(ctx, 3) || ctx.RootDict["OCProperties"] != nil {
+		t.Fatal("TestRemoveWatermarksSharedResources: watermark left\n")
+	}
+}
+
+// Stamp a serial number computed at stamping time onto all pages but page 2.
+func TestStampFunc(t *testing.T) {
+
+	inFile := filepath.Join(inDir, "pike-stanford.pdf")
+	outFile := filepath.Join(outDir, "testStampFunc.pdf")
+
+	var pages []int
+
+	f := func(pageNr int, pageDim types.Rectangle) (*pdf.Watermark, error) {
+		if pageDim.Width() <= 0 {
+			return nil, fmt.Errorf("page %d: missing page dimensions", pageNr)
+		}
+		pages = append(pages, pageNr)
+		if pageNr == 2 {
+			return nil, nil
+		}
+		return pdf.ParseWatermarkDetails(fmt.Sprintf("No. %05d, f:Courier, p:12, r:0", pageNr), true)
+	}
+
+	config := pdf.NewDefaultConfiguration()
+
+	if err := AddWatermarksFunc(inFile, outFile, nil, f, config); err != nil {
+		t.Fatalf("TestStampFunc: %v\n", err)
+	}
+
+	ctx := readAndValidateFile(t, outFile)
+
+	if len(pages) != ctx.PageCount || !sort.IntsAreSorted(pages) {
+		t.Fatalf("TestStampFunc: want pages in order, got %v\n", pages)
+	}
+
+	for p := 1; p <= ctx.PageCount; p++ {
+
+		_, inhPAttrs, err := ctx.PageDict(p)
+		if err != nil {
+			t.Fatalf("TestStampFunc: %v\n", err)
+		}
+
+		xobjs, err := ctx.DereferenceDict(inhPAttrs.Resources()["XObject"])
+		if err != nil {
+			t.Fatalf("TestStampFunc: %v\n", err)
+		}
+
+		serial := fmt.Sprintf("(No. %05d)", p)
+		found := false
+		for _, o := range xobjs {
+			sd, err := ctx.DereferenceStreamDict(o)
+			if err != nil || sd == nil || sd.Subtype() == nil || *sd.Subtype() != "Form" {
+				continue
+			}
+			if strings.Contains(streamContent(t, sd), serial) {
+				found = true
+			}
+		}
+
+		if found != (p != 2) {
+			t.Fatalf("TestStampFunc: page %d: stamp found: %t\n", p, found)
+		}
+	}
+}
+
+// pageXObjects returns the image and form XObjects of page p.
+func pageXObjects(t *testing.T, ctx *pdf.Context, p int) (images, forms []*pdf.StreamDict) {
+
+	t.Helper()
+
+	_, inhPAttrs, err := ctx.PageDict(p)
+	if err != nil {
+		t.Fatalf("page %d: %v\n", p, err)
+	}
+
+	xobjs, err := ctx.DereferenceDict(inhPAttrs.Resources()["XObject"])
+	if err != nil {
+		t.Fatalf("page %d: %v\n", p, err)
+	}
+
+	for _, o := range xobjs {
+		sd, err := ctx.DereferenceStreamDict(o)
+		if err != nil || sd == nil || sd.Subtype() == nil {
+			continue
+		}
+		switch *sd.Subtype() {
+		case "Image":
+			images = append(images, sd)
+		case "Form":
+			forms = append(forms, sd)
+		}
+	}
+
+	return images, forms
+}
+
+func TestBooklet(t *testing.T) {
+
+	inFile := filepath.Join(inDir, "Wonderwall.pdf")
+	outFile := filepath.Join(outDir, "booklet.pdf")
+
+	if got, want := fmt.Sprint(pdf.BookletPageOrder(6)), "[8 1 2 7 6 3 4 5]"; got != want {
+		t.Fatalf("TestBooklet: page order want %s, got %s\n", want, got)
+	}
+
+	config := pdf.NewDefaultConfiguration()
+
+	if err := Booklet(inFile, outFile, config); err != nil {
+		t.Fatalf("TestBooklet: %v\n", err)
+	}
+
+	ctxIn := readAndValidateFile(t, inFile)
+	ctx := readAndValidateFile(t, outFile)
+
+	// 6 pages padded to 8 make up 4 sheet sides, pages 7 and 8 are blank.
+	if ctx.PageCount != 4 {
+		t.Fatalf("TestBooklet: want 4 pages, got %d\n", ctx.PageCount)
+	}
+
+	pageText := func(ctx *pdf.Context, p int) string {
+		t.Helper()
+		s, err := ExtractTextFromContext(ctx, p)
+		if err != nil {
+			t.Fatalf("TestBooklet: page %d: %v\n", p, err)
+		}
+		return s
+	}
+
+	_, inhPAttrs, err := ctxIn.PageDict(1)
+	if err != nil {
+		t.Fatalf("TestBooklet: %v\n", err)
+	}
+	w := ctxIn.DereferenceNumber(inhPAttrs.MediaBox()[2])
+
+	for i, pages := range [][]int{{1}, {2}, {6, 3}, {4, 5}} {
+
+		_, forms := pageXObjects(t, ctx, i+1)
+		if len(forms) != len(pages) {
+			t.Fatalf("TestBooklet: side %d: want %d pages, got %d\n", i+1, len(pages), len(forms))
+		}
+
+		_, inhPAttrs, err := ctx.PageDict(i + 1)
+		if err != nil {
+			t.Fatalf("TestBooklet: %v\n", err)
+		}
+		if got := ctx.DereferenceNumber(inhPAttrs.MediaBox()[2]); got != 2*w {
+			t.Fatalf("TestBooklet: side %d: want width %.2f, got %.2f\n", i+1, 2*w, got)
+		}
+
+		var want string
+		for _, p := range pages {
+			want += pageText(ctxIn, p)
+		}
+		if got := pageText(ctx, i+1); got != want {
+			t.Fatalf("TestBooklet: side %d: want text\n%s\ngot\n%s\n", i+1, want, got)
+		}
+	}
+}
+
+func TestBookletNamedDestinations(t *testing.T) {
+
+	// The named destinations refer to pages, which must survive their removal.
+	inFile := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	outFile := filepath.Join(outDir, "bookletDests.pdf")
+
+	ctxIn := readAndValidateFile(t, inFile)
+	if _, found := ctxIn.Names["Dests"]; !found {
+		t.Fatalf("TestBookletNamedDestinations: %s has no named destinations\n", inFile)
+	}
+
+	if err := Booklet(inFile, outFile, pdf.NewDefaultConfiguration()); err != nil {
+		t.Fatalf("TestBookletNamedDestinations: %v\n", err)
+	}
+
+	ctx := readAndValidateFile(t, outFile)
+	if want := (ctxIn.PageCount + 3) / 4 * 2; ctx.PageCount != want {
+		t.Fatalf("TestBookletNamedDestinations: want %d pages, got %d\n", want, ctx.PageCount)
+	}
+	if _, found := ctx.Names["Dests"]; found {
+		t.Fatalf("TestBookletNamedDestinations: named destinations not removed\n")
+	}
+}
+
+func TestGrayscale(t *testing.T) {
+
+	inFile := filepath.Join(outDir, "grayscale.pdf")
+	outFile := filepath.Join(outDir, "grayscaled.pdf")
+
+	config := pdf.NewDefaultConfiguration()
+
+	wm, err := pdf.ParseWatermarkDetails("Appendix, c:1 0 0, r:0", true)
+	if err != nil {
+		t.Fatalf("TestGrayscale: %v\n", err)
+	}
+
+	if _, err = Process(AddWatermarksCommand(filepath.Join(inDir, "GoForOptimization.pdf"), inFile, nil, wm, config)); err != nil {
+		t.Fatalf("TestGrayscale: %v\n", err)
+	}
+
+	stampContent := func(ctx *pdf.Context, p int) string {
+		t.Helper()
+		_, forms := pageXObjects(t, ctx, p)
+		for _, sd := range forms {
+			if _, found := sd.Find("OC"); found {
+				return streamContent(t, sd)
+			}
+		}
+		t.Fatalf("TestGrayscale: page %d: missing stamp\n", p)
+		return ""
+	}
+
+	allGray := func(ctx *pdf.Context, p int) bool {
+		t.Helper()
+		images, _ := pageXObjects(t, ctx, p)
+		for _, sd := range images {
+			if cs := sd.NameEntry("ColorSpace"); cs == nil || *cs != pdf.DeviceGrayCS {
+				return false
+			}
+		}
+		return len(images) > 0
+	}
+
+	// Keep the cover in color.
+	if err = Grayscale(inFile, outFile, []string{"2-"}, pdf.GrayscaleImages, config); err != nil {
+		t.Fatalf("TestGrayscale: %v\n", err)
+	}
+
+	ctx := readAndValidateFile(t, outFile)
+
+	if allGray(ctx, 1) || !allGray(ctx, 2) {
+		t.Fatal("TestGrayscale: want images of page 2 in grayscale only\n")
+	}
+
+	if s := stampContent(ctx, 2); !strings.Contains(s, "1.000000 0.000000 0.000000 rg") {
+		t.Fatalf("TestGrayscale: stamp should keep its color: %s\n", s)
+	}
+
+	if err = Grayscale(inFile, outFile, nil, pdf.GrayscaleStamps, config); err != nil {
+		t.Fatalf("TestGrayscale: %v\n", err)
+	}
+
+	ctx = readAndValidateFile(t, outFile)
+
+	if allGray(ctx, 2) {
+		t.Fatal("TestGrayscale: images should keep their color\n")
+	}
+
+	if s := stampContent(ctx, 2); strings.Contains(s, "rg") || !strings.Contains(s, "0.300 g") {
+		t.Fatalf("TestGrayscale: stamp not converted: %s\n", s)
+	}
+
+	// Convert a JPEG.
+	inFile = filepath.Join(inDir, "BuildingWebappsWithGo.pdf")
+	if err = Grayscale(inFile, outFile, []string{"1"}, pdf.GrayscaleImages, config); err != nil {
+		t.Fatalf("TestGrayscale: %v\n", err)
+	}
+
+	ctx = readAndValidateFile(t, outFile)
+
+	if !allGray(ctx, 1) {
+		t.Fatal("TestGrayscale: JPEG not converted\n")
+	}
+}
+
+// bilevelSamples returns the decoded samples of the CCITT encoded image sd.
+func bilevelSamples(t *testing.T, sd *pdf.StreamDict) []byte {
+
+	t.Helper()
+
+	parms := map[string]int{}
+	if d := sd.DictEntry("DecodeParms"); d != nil {
+		for _, k := range []string{"K", "Columns", "BlackIs1"} {
+			if i := d.IntEntry(k); i != nil {
+				parms[k] = *i
+			}
+			if b := d.BooleanEntry(k); b != nil && *b {
+				parms[k] = 1
+			}
+		}
+	}
+
+	f, err := filter.NewFilter(filter.CCITTFax, parms)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	b, err := f.Decode(bytes.NewReader(sd.Raw))
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	w, h := *sd.IntEntry("Width"), *sd.IntEntry("Height")
+
+	return b.Bytes()[:(w+7)/8*h]
+}
+
+func TestImagePolicy(t *testing.T) {
+
+	policyFile := filepath.Join(outDir, "imagePolicy.json")
+	policy := `[
+		{"kind": "bilevel", "compression": "ccittG4"},
+		{"kind": "rgb", "minDPI": 150, "compression": "jpeg", "dpi": 100, "quality": 80},
+		{"kind": "rgb", "filter": "FlateDecode", "compression": "keep"}
+	]`
+
+	// The policy file is read from the configured file system.
+	fsys := pdf.NewMemFileSystem()
+	if err := pdf.WriteFile(fsys, policyFile, []byte(policy), 0644); err != nil {
+		t.Fatalf("TestImagePolicy: %v\n", err)
+	}
+
+	config := pdf.NewDefaultConfiguration()
+	config.FS = fsys
+
+	p, err := ReadImagePolicy(policyFile, config)
+	if err != nil {
+		t.Fatalf("TestImagePolicy: %v\n", err)
+	}
+
+	config = pdf.NewDefaultConfiguration()
+	config.ImagePolicy = p
+
+	// Downsample the cover image rendered at about 200 dpi.
+	inFile := filepath.Join(inDir, "BuildingWebappsWithGo.pdf")
+	outFile := filepath.Join(outDir, "imagePolicy.pdf")
+
+	if _, err = Process(OptimizeCommand(inFile, outFile, config)); err != nil {
+		t.Fatalf("TestImagePolicy: %v\n", err)
+	}
+
+	images1, _ := pageXObjects(t, readAndValidateFile(t, inFile), 1)
+	images2, _ := pageXObjects(t, readAndValidateFile(t, outFile), 1)
+	if len(images1) != 1 || len(images2) != 1 {
+		t.Fatalf("TestImagePolicy: want 1 cover image, got %d %d\n", len(images1), len(images2))
+	}
+
+	w1, w2 := *images1[0].IntEntry("Width"), *images2[0].IntEntry("Width")
+	if w2 > w1/2+1 || len(images2[0].Raw) >= len(images1[0].Raw) || !images2[0].HasSoleFilterNamed(filter.DCT) {
+		t.Fatalf("TestImagePolicy: cover not downsampled: width %d -> %d, %d -> %d bytes\n", w1, w2, len(images1[0].Raw), len(images2[0].Raw))
+	}
+
+	// Store the bilevel images of a scan uncompressed.
+	inFile = filepath.Join(outDir, "imagePolicyBilevel.pdf")
+
+	ctx := readAndValidateFile(t, filepath.Join(inDir, "T6.pdf"))
+
+	samples := map[int][]byte{}
+
+	for objNr, entry := range ctx.Table {
+		sd, ok := entry.Object.(pdf.StreamDict)
+		if !ok || sd.Subtype() == nil || *sd.Subtype() != "Image" {
+			continue
+		}
+		samples[objNr] = bilevelSamples(t, &sd)
+		sd.Raw, sd.Content, sd.FilterPipeline = samples[objNr], nil, nil
+		sd.Delete("Filter")
+		sd.Delete("DecodeParms")
+		l := int64(len(sd.Raw))
+		sd.StreamLength = &l
+		sd.Update("Length", pdf.Integer(l))
+		entry.Object = sd
+	}
+
+	f, err := os.Create(inFile)
+	if err != nil {
+		t.Fatalf("TestImagePolicy: %v\n", err)
+	}
+	if err = WriteContext(ctx, f); err != nil {
+		t.Fatalf("TestImagePolicy: %v\n", err)
+	}
+	f.Close()
+
+	if _, err = Process(OptimizeCommand(inFile, outFile, config)); err != nil {
+		t.Fatalf("TestImagePolicy: %v\n", err)
+	}
+
+	ctx = readAndValidateFile(t, outFile)
+
+	n := 0
+	for _, entry := range ctx.Table {
+		sd, ok := entry.Object.(pdf.StreamDict)
+		if !ok || sd.Subtype() == nil || *sd.Subtype() != "Image" {
+			continue
+		}
+		n++
+		if !sd.HasSoleFilterNamed(filter.CCITTFax) {
+			t.Fatalf("TestImagePolicy: want CCITT G4 image, got %v\n", sd.Dict)
+		}
+		found := false
+		for _, b := range samples {
+			if bytes.Equal(b, bilevelSamples(t, &sd)) {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatal("TestImagePolicy: image samples changed\n")
+		}
+	}
+
+	if n != len(samples) {
+		t.Fatalf("TestImagePolicy: want %d images, got %d\n", len(samples), n)
+	}
+}
+
+// Extract a DCT encoded image with a soft mask as RGBA PNG.
+func TestExtractImagesSoftMask(t *testing.T) {
+
+	inFile := filepath.Join(outDir, "softMask.pdf")
+	dirOut := filepath.Join(outDir, "softMask")
+
+	ctx := readAndValidateFile(t, filepath.Join(inDir, "BuildingWebappsWithGo.pdf"))
+
+	_, inhPAttrs, err := ctx.PageDict(1)
+	if err != nil {
+		t.Fatalf("TestExtractImagesSoftMask: %v\n", err)
+	}
+
+	xobjs, err := ctx.DereferenceDict(inhPAttrs.Resources()["XObject"])
+	if err != nil || len(xobjs) != 1 {
+		t.Fatalf("TestExtractImagesSoftMask: want 1 cover image, %v\n", err)
+	}
+
+	var cover *pdf.StreamDict
+	for _, o := range xobjs {
+		if cover, err = ctx.DereferenceStreamDict(o); err != nil {
+			t.Fatalf("TestExtractImagesSoftMask: %v\n", err)
+		}
+	}
+
+	// The left half of the cover is transparent.
+	w, h := *cover.IntEntry("Width"), *cover.IntEntry("Height")
+	sm := make([]byte, w*h)
+	for i := range sm {
+		if i%w >= w/2 {
+			sm[i] = 0xFF
+		}
+	}
+
+	fi, err := filter.NewFilter(filter.Flate, nil)
+	if err != nil {
+		t.Fatalf("TestExtractImagesSoftMask: %v\n", err)
+	}
+
+	buf, err := fi.Encode(bytes.NewReader(sm))
+	if err != nil {
+		t.Fatalf("TestExtractImagesSoftMask: %v\n", err)
+	}
+
+	l := int64(buf.Len())
+	sd := pdf.NewStreamDict(
+		pdf.Dict(map[string]pdf.Object{
+			"Type":             pdf.Name("XObject"),
+			"Subtype":          pdf.Name("Image"),
+			"Width":            pdf.Integer(w),
+			"Height":           pdf.Integer(h),
+			"ColorSpace":       pdf.Name(pdf.DeviceGrayCS),
+			"BitsPerComponent": pdf.Integer(8),
+			"Filter":           pdf.Name(filter.Flate),
+			"Length":           pdf.Integer(l),
+		}),
+		0, &l, nil, []pdf.PDFFilter{{Name: filter.Flate}})
+	sd.Raw = buf.Bytes()
+
+	ir, err := ctx.IndRefForNewObject(sd)
+	if err != nil {
+		t.Fatalf("TestExtractImagesSoftMask: %v\n", err)
+	}
+
+	cover.Insert("SMask", *ir)
+
+	// The soft mask is also registered as page resource but must not be extracted on its own.
+	xobjs.Insert("SM1", *ir)
+
+	f, err := os.Create(inFile)
+	if err != nil {
+		t.Fatalf("TestExtractImagesSoftMask: %v\n", err)
+	}
+	if err = WriteContext(ctx, f); err != nil {
+		t.Fatalf("TestExtractImagesSoftMask: %v\n", err)
+	}
+	f.Close()
+
+	if err = os.MkdirAll(dirOut, 0755); err != nil {
+		t.Fatalf("TestExtractImagesSoftMask: %v\n", err)
+	}
+
+	if _, err = Process(ExtractImagesCommand(inFile, dirOut, []string{"1"}, pdf.NewDefaultConfiguration())); err != nil {
+		t.Fatalf("TestExtractImagesSoftMask: %v\n", err)
+	}
+
+	files, err := ioutil.ReadDir(dirOut)
+	if err != nil {
+		t.Fatalf("TestExtractImagesSoftMask: %v\n", err)
+	}
+
+	if len(files) != 1 || filepath.Ext(files[0].Name()) != ".png" {
+		var fns []string
+		for _, fi := range files {
+			fns = append(fns, fi.Name())
+		}
+		t.Fatalf("TestExtractImagesSoftMask: want 1 png, got %v\n", fns)
+	}
+
+	f, err = os.Open(filepath.Join(dirOut, files[0].Name()))
+	if err != nil {
+		t.Fatalf("TestExtractImagesSoftMask: %v\n", err)
+	}
+	defer f.Close()
+
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatalf("TestExtractImagesSoftMask: %v\n", err)
+	}
+
+	_, _, _, a1 := img.At(0, 0).RGBA()
+	_, _, _, a2 := img.At(w-1, h-1).RGBA()
+	if img.Bounds().Dx() != w || a1 != 0 || a2 != 0xFFFF {
+		t.Fatalf("TestExtractImagesSoftMask: soft mask not applied: width=%d alpha=%d,%d\n", img.Bounds().Dx(), a1, a2)
+	}
+}
+
+func TestImagePlacements(t *testing.T) {
+
+	inFile := filepath.Join(outDir, "imagePlacements.pdf")
+	dirOut := filepath.Join(outDir, "imagePlacements")
+
+	config := pdf.NewDefaultConfiguration()
+
+	// Stamp a rotated image onto the cover.
+	wm, err := pdf.ParseWatermarkDetails(filepath.Join(resDir, "pdfchip3.png")+", s:.5 a, r:-90", true)
+	if err != nil {
+		t.Fatalf("TestImagePlacements: %v\n", err)
+	}
+
+	if _, err = Process(AddWatermarksCommand(filepath.Join(inDir, "BuildingWebappsWithGo.pdf"), inFile, []string{"1"}, wm, config)); err != nil {
+		t.Fatalf("TestImagePlacements: %v\n", err)
+	}
+
+	pp, err := ImagePlacements(inFile, []string{"1"}, config)
+	if err != nil {
+		t.Fatalf("TestImagePlacements: %v\n", err)
+	}
+
+	// The cover fills the page followed by the stamp.
+	if len(pp) != 2 {
+		t.Fatalf("TestImagePlacements: want 2 placements, got %d\n", len(pp))
+	}
+
+	cover, stamp := pp[0], pp[1]
+	if cover.Rotation != 0 || cover.Width < 590 || cover.Height < 840 {
+		t.Fatalf("TestImagePlacements: unexpected cover placement %+v\n", cover)
+	}
+	if stamp.Rotation != -90 || stamp.Width >= cover.Width {
+		t.Fatalf("TestImagePlacements: unexpected stamp placement %+v\n", stamp)
+	}
+
+	// Extraction records the files written along with the placements.
+	if err = os.MkdirAll(dirOut, 0755); err != nil {
+		t.Fatalf("TestImagePlacements: %v\n", err)
+	}
+
+	config.ExtractImagePlacements = true
+	if _, err = Process(ExtractImagesCommand(inFile, dirOut, []string{"1"}, config)); err != nil {
+		t.Fatalf("TestImagePlacements: %v\n", err)
+	}
+
+	bb, err := ioutil.ReadFile(filepath.Join(dirOut, ImagePlacementsFile))
+	if err != nil {
+		t.Fatalf("TestImagePlacements: %v\n", err)
+	}
+
+	var placements struct {
+		Placements []pdf.ImagePlacement
+	}
+	if err = json.Unmarshal(bb, &placements); err != nil {
+		t.Fatalf("TestImagePlacements: %v\n", err)
+	}
+
+	if len(placements.Placements) != 2 {
+		t.Fatalf("TestImagePlacements: want 2 placements, got %d\n", len(placements.Placements))
+	}
+
+	for _, p := range placements.Placements {
+		if p.File == "" {
+			t.Fatalf("TestImagePlacements: missing file for obj#%d\n", p.ObjNr)
+		}
+		if _, err = os.Stat(filepath.Join(dirOut, p.File)); err != nil {
+			t.Fatalf("TestImagePlacements: %v\n", err)
+		}
+		if p.Matrix != pp[0].Matrix && p.Matrix != pp[1].Matrix {
+			t.Fatalf("TestImagePlacements: unexpected placement %+v\n", p)
+		}
+	}
+}
+
+func TestExtractFileNames(t *testing.T) {
+
+	inFile := filepath.Join(inDir, "GoForOptimization.pdf")
+	dirOut := filepath.Join(outDir, "extractFileNames")
+
+	if err := os.MkdirAll(dirOut, 0755); err != nil {
+		t.Fatalf("TestExtractFileNames: %v\n", err)
+	}
+
+	config := pdf.NewDefaultConfiguration()
+	config.ExtractFileNameTemplate = "{file}_{page}"
+
+	files, err := Process(ExtractImagesCommand(inFile, dirOut, []string{"1-2"}, config))
+	if err != nil {
+		t.Fatalf("TestExtractFileNames: %v\n", err)
+	}
+
+	// Page 1 has 13 images, page 2 adds 2.
+	if len(files) != 15 {
+		t.Fatalf("TestExtractFileNames: want 15 images, got %d: %v\n", len(files), files)
+	}
+
+	names := map[string]bool{}
+	for _, fn := range files {
+		if names[fn] {
+			t.Fatalf("TestExtractFileNames: %s written twice\n", fn)
+		}
+		names[fn] = true
+		if _, err = os.Stat(fn); err != nil {
+			t.Fatalf("TestExtractFileNames: %v\n", err)
+		}
+	}
+
+	for _, fn := range []string{"GoForOptimization_1.png", "GoForOptimization_1_13.png", "GoForOptimization_2_2.png"} {
+		if !names[filepath.Join(dirOut, fn)] {
+			t.Fatalf("TestExtractFileNames: missing %s in %v\n", fn, files)
+		}
+	}
+
+	// Keep the files already extracted.
+	config.ExtractSkipExisting = true
+
+	if files, err = Process(ExtractImagesCommand(inFile, dirOut, []string{"1-2"}, config)); err != nil {
+		t.Fatalf("TestExtractFileNames: %v\n", err)
+	}
+
+	if len(files) != 0 {
+		t.Fatalf("TestExtractFileNames: want no images written, got %v\n", files)
+	}
+
+	for _, cmd := range []*Command{
+		ExtractFontsCommand(inFile, dirOut, []string{"1"}, config),
+		ExtractContentCommand(inFile, dirOut, []string{"1"}, config),
+	} {
+		if files, err = Process(cmd); err != nil {
+			t.Fatalf("TestExtractFileNames: %v\n", err)
+		}
+		if len(files) == 0 {
+			t.Fatalf("TestExtractFileNames: mode %d: no files written\n", cmd.Mode)
+		}
+		for _, fn := range files {
+			if !strings.HasPrefix(filepath.Base(fn), "GoForOptimization_1") {
+				t.Fatalf("TestExtractFileNames: unexpected file name %s\n", fn)
+			}
+		}
+	}
+}
+
+// Extract images, fonts and attachments into archives as a web service would.
+func TestExtractToArchive(t *testing.T) {
+
+	config := pdf.NewDefaultConfiguration()
+
+	f, err := os.Open(filepath.Join(inDir, "GoForOptimization.pdf"))
+	if err != nil {
+		t.Fatalf("TestExtractToArchive: %v\n", err)
+	}
+	defer f.Close()
+
+	var buf bytes.Buffer
+
+	if _, err = ExtractImagesToArchive(f, &buf, "rar", nil, config); err == nil {
+		t.Fatal("TestExtractToArchive: want error for unsupported archive format\n")
+	}
+
+	files, err := ExtractImagesToArchive(f, &buf, ArchiveZip, []string{"1"}, config)
+	if err != nil {
+		t.Fatalf("TestExtractToArchive: %v\n", err)
+	}
+
+	// The caller's configuration is left untouched.
+	if config.Mode != pdf.NewDefaultConfiguration().Mode {
+		t.Fatalf("TestExtractToArchive: config.Mode changed to %d\n", config.Mode)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("TestExtractToArchive: %v\n", err)
+	}
+
+	if len(files) != 13 || len(zr.File) != len(files) {
+		t.Fatalf("TestExtractToArchive: want 13 images, got %d files, %d entries\n", len(files), len(zr.File))
+	}
+
+	for i, zf := range zr.File {
+		if zf.Name != files[i] {
+			t.Fatalf("TestExtractToArchive: want entry %s, got %s\n", files[i], zf.Name)
+		}
+		if filepath.Ext(zf.Name) != ".png" {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			t.Fatalf("TestExtractToArchive: %v\n", err)
+		}
+		if _, err = png.Decode(rc); err != nil {
+			t.Fatalf("TestExtractToArchive: %s: %v\n", zf.Name, err)
+		}
+		rc.Close()
+	}
+
+	// Fonts into a tar archive.
+	buf.Reset()
+
+	if files, err = ExtractFontsToArchive(f, &buf, ArchiveTar, []string{"1"}, config); err != nil {
+		t.Fatalf("TestExtractToArchive: %v\n", err)
+	}
+
+	if len(files) == 0 {
+		t.Fatal("TestExtractToArchive: no fonts extracted\n")
+	}
+
+	tr := tar.NewReader(&buf)
+	for _, fn := range files {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatalf("TestExtractToArchive: %v\n", err)
+		}
+		if hdr.Name != fn || hdr.Size == 0 {
+			t.Fatalf("TestExtractToArchive: want entry %s, got %s with %d bytes\n", fn, hdr.Name, hdr.Size)
+		}
+	}
+	if _, err = tr.Next(); err != io.EOF {
+		t.Fatalf("TestExtractToArchive: want end of archive, got %v\n", err)
+	}
+
+	// Attachments into a zip archive.
+	fileName := filepath.Join(outDir, "archiveAttachments.pdf")
+	if err = copyFile(filepath.Join(inDir, "go.pdf"), fileName); err != nil {
+		t.Fatalf("TestExtractToArchive: %v\n", err)
+	}
+
+	attachment := filepath.Join(resDir, "test.wav")
+	if err = AddAttachments(fileName, []string{attachment}, config); err != nil {
+		t.Fatalf("TestExtractToArchive: %v\n", err)
+	}
+
+	bb, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("TestExtractToArchive: %v\n", err)
+	}
+
+	buf.Reset()
+
+	if files, err = ExtractAttachmentsToArchive(bytes.NewReader(bb), &buf, ArchiveZip, nil, config); err != nil {
+		t.Fatalf("TestExtractToArchive: %v\n", err)
+	}
+
+	if zr, err = zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len())); err != nil {
+		t.Fatalf("TestExtractToArchive: %v\n", err)
+	}
+
+	if len(files) != 1 || len(zr.File) != 1 || zr.File[0].Name != "test.wav" {
+		t.Fatalf("TestExtractToArchive: want test.wav, got %v\n", files)
+	}
+
+	rc, err := zr.File[0].Open()
+	if err != nil {
+		t.Fatalf("TestExtractToArchive: %v\n", err)
+	}
+	defer rc.Close()
+
+	got, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("TestExtractToArchive: %v\n", err)
+	}
+
+	want, err := ioutil.ReadFile(attachment)
+	if err != nil {
+		t.Fatalf("TestExtractToArchive: %v\n", err)
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Fatal("TestExtractToArchive: attachment content mismatch\n")
+	}
+}
+
+func TestExtractFromContext(t *testing.T) {
+
+	inFile := filepath.Join(inDir, "GoForOptimization.pdf")
+	ctx := readAndValidateFile(t, inFile)
+
+	images, err := ExtractImagesFromContext(ctx, []string{"1"})
+	if err != nil {
+		t.Fatalf("TestExtractFromContext: %v\n", err)
+	}
+
+	if len(images) != 13 {
+		t.Fatalf("TestExtractFromContext: want 13 images, got %d\n", len(images))
+	}
+
+	for _, f := range images {
+		if f.PageNr != 1 || f.ObjNr == 0 || filepath.Base(f.Name) != f.Name {
+			t.Fatalf("TestExtractFromContext: unexpected image %s page %d obj %d\n", f.Name, f.PageNr, f.ObjNr)
+		}
+		if filepath.Ext(f.Name) != ".png" {
+			continue
+		}
+		if _, err = png.Decode(bytes.NewReader(f.Data)); err != nil {
+			t.Fatalf("TestExtractFromContext: %s: %v\n", f.Name, err)
+		}
+	}
+
+	fonts, err := ExtractFontsFromContext(ctx, []string{"1"})
+	if err != nil {
+		t.Fatalf("TestExtractFromContext: %v\n", err)
+	}
+
+	content, err := ExtractContentFromContext(ctx, []string{"1"})
+	if err != nil {
+		t.Fatalf("TestExtractFromContext: %v\n", err)
+	}
+
+	if len(fonts) == 0 || len(content) == 0 || len(content[0].Data) == 0 {
+		t.Fatalf("TestExtractFromContext: got %d fonts, %d content streams\n", len(fonts), len(content))
+	}
+
+	if _, err = ExtractMetadataFromContext(ctx); err != nil {
+		t.Fatalf("TestExtractFromContext: %v\n", err)
+	}
+
+	// Nothing got written to disk.
+	if ctx.Write.DirName != "" {
+		t.Fatalf("TestExtractFromContext: unexpected output dir %s\n", ctx.Write.DirName)
+	}
+
+	// Files written are not executable.
+	dirOut := filepath.Join(outDir, "extractPerm")
+	if err = os.MkdirAll(dirOut, 0755); err != nil {
+		t.Fatalf("TestExtractFromContext: %v\n", err)
+	}
+
+	files, err := Process(ExtractImagesCommand(inFile, dirOut, []string{"1"}, pdf.NewDefaultConfiguration()))
+	if err != nil {
+		t.Fatalf("TestExtractFromContext: %v\n", err)
+	}
+
+	for _, fn := range files {
+		fi, err := os.Stat(fn)
+		if err != nil {
+			t.Fatalf("TestExtractFromContext: %v\n", err)
+		}
+		if fi.Mode().Perm()&^0644 != 0 {
+			t.Fatalf("TestExtractFromContext: %s has permissions %v\n", fn, fi.Mode().Perm())
+		}
+	}
+
+	// Attachments.
+	fileName := filepath.Join(outDir, "contextAttachments.pdf")
+	if err = copyFile(filepath.Join(inDir, "go.pdf"), fileName); err != nil {
+		t.Fatalf("TestExtractFromContext: %v\n", err)
+	}
+
+	attachment := filepath.Join(resDir, "test.wav")
+	if err = AddAttachments(fileName, []string{attachment}, pdf.NewDefaultConfiguration()); err != nil {
+		t.Fatalf("TestExtractFromContext: %v\n", err)
+	}
+
+	want, err := ioutil.ReadFile(attachment)
+	if err != nil {
+		t.Fatalf("TestExtractFromContext: %v\n", err)
+	}
+
+	ff, err := ExtractAttachmentsFromContext(readAndValidateFile(t, fileName), nil)
+	if err != nil {
+		t.Fatalf("TestExtractFromContext: %v\n", err)
+	}
+
+	if len(ff) != 1 || ff[0].Name != "test.wav" || !bytes.Equal(ff[0].Data, want) {
+		t.Fatalf("TestExtractFromContext: want test.wav, got %d files\n", len(ff))
+	}
+}
+
+func TestConfigurationValidate(t *testing.T) {
+
+	inFile := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	outFile := filepath.Join(outDir, "config.pdf")
+	encFile := filepath.Join(outDir, "configEncrypted.pdf")
+
+	config := pdf.NewDefaultConfiguration()
+	config.UserPW = "upw"
+	config.OwnerPW = "opw"
+	if _, err := Process(EncryptCommand(inFile, encFile, config)); err != nil {
+		t.Fatalf("TestConfigurationValidate: %v\n", err)
+	}
+
+	pw := "upw"
+
+	for _, tt := range []struct {
+		msg string
+		f   func(c *pdf.Configuration)
+		cmd func(c *pdf.Configuration) *Command
+	}{
+		{"validation mode", func(c *pdf.Configuration) { c.ValidationMode = 5 }, func(c *pdf.Configuration) *Command { return ValidateCommand(inFile, c) }},
+		{"eol", func(c *pdf.Configuration) { c.Eol = "\t" }, func(c *pdf.Configuration) *Command { return OptimizeCommand(inFile, outFile, c) }},
+		{"fan out", func(c *pdf.Configuration) { c.PageTreeFanOut = 1 }, func(c *pdf.Configuration) *Command { return OptimizeCommand(inFile, outFile, c) }},
+		{"hybrid xref", func(c *pdf.Configuration) {
+			c.WriteHybridXRef, c.WriteXRefStream, c.WriteObjectStream = true, false, false
+		}, func(c *pdf.Configuration) *Command { return OptimizeCommand(inFile, outFile, c) }},
+		{"hybrid xref using object streams", func(c *pdf.Configuration) {
+			c.WriteHybridXRef, c.WriteXRefStream, c.WriteObjectStream = true, false, true
+		}, func(c *pdf.Configuration) *Command { return OptimizeCommand(inFile, outFile, c) }},
+		{"image policy", func(c *pdf.Configuration) {
+			c.ImagePolicy = pdf.ImagePolicy{{Kind: pdf.ImageRGB, Compression: pdf.ImageCCITTG4}}
+		}, func(c *pdf.Configuration) *Command { return OptimizeCommand(inFile, outFile, c) }},
+		{"new password", func(c *pdf.Configuration) {}, func(c *pdf.Configuration) *Command { return ChangeUserPWCommand(encFile, outFile, c, &pw, nil) }},
+		{"AES 40 bit", func(c *pdf.Configuration) { c.EncryptUsing128BitKey = false }, func(c *pdf.Configuration) *Command { return EncryptCommand(inFile, outFile, c) }},
+		{"forbid weak", func(c *pdf.Configuration) { c.ForbidWeakEncryption = true }, func(c *pdf.Configuration) *Command { return EncryptCommand(inFile, outFile, c) }},
+		{"unencrypted metadata", func(c *pdf.Configuration) {
+			c.EncryptUsingAES, c.EncryptUsing128BitKey, c.ForceWeakEncryption, c.EncryptMetadata = false, false, true, false
+		}, func(c *pdf.Configuration) *Command { return EncryptCommand(inFile, outFile, c) }},
+	} {
+		config := pdf.NewDefaultConfiguration()
+		config.UserPW = "upw"
+		config.OwnerPW = "opw"
+		tt.f(config)
+		if _, err := Process(tt.cmd(config)); !errors.Is(err, pdf.ErrInvalidConfig) {
+			t.Fatalf("TestConfigurationValidate %s: want ErrInvalidConfig, got %v\n", tt.msg, err)
+		}
+	}
+
+	// Encryption settings are checked when writing, also for a context configured after reading.
+	ctx, err := ReadContextFromFile(inFile, nil)
+	if err != nil {
+		t.Fatalf("TestConfigurationValidate: %v\n", err)
+	}
+	ctx.Mode = pdf.ENCRYPT
+	ctx.EncryptUsing128BitKey = false
+	if err = WriteContext(ctx, ioutil.Discard); !errors.Is(err, pdf.ErrInvalidConfig) {
+		t.Fatalf("TestConfigurationValidate: write: want ErrInvalidConfig, got %v\n", err)
+	}
+
+	config = pdf.NewDefaultConfiguration()
+	if ss := config.NonDefaults(); len(ss) > 0 {
+		t.Fatalf("TestConfigurationValidate: want no non defaults, got %v\n", ss)
+	}
+
+	config.OwnerPW = "secret"
+	config.PageTreeFanOut = 20
+	if got, want := fmt.Sprint(config.NonDefaults()), "[PageTreeFanOut: 20 OwnerPW: set]"; got != want {
+		t.Fatalf("TestConfigurationValidate: want %s, got %s\n", want, got)
+	}
+}
+
+func TestTargetVersion(t *testing.T) {
+
+	inFile := filepath.Join(inDir, "Wonderwall.pdf")
+	outFile := filepath.Join(outDir, "targetVersion.pdf")
+
+	// Xref streams and object streams get avoided for PDF 1.4.
+	v := pdf.V14
+	config := pdf.NewDefaultConfiguration()
+	config.TargetVersion = &v
+	if _, err := Process(OptimizeCommand(inFile, outFile, config)); err != nil {
+		t.Fatalf("TestTargetVersion: %v\n", err)
+	}
+
+	ctx := readAndValidateFile(t, outFile)
+	if *ctx.HeaderVersion != pdf.V14 {
+		t.Fatalf("TestTargetVersion: want version %s, got %s\n", pdf.V14, ctx.HeaderVersion)
+	}
+	if ctx.Read.UsingXRefStreams || ctx.Read.UsingObjectStreams {
+		t.Fatal("TestTargetVersion: unexpected xref streams or object streams")
+	}
+
+	// The tab order of pages is a PDF 1.5 page entry and gets dropped.
+	inFileTabs := filepath.Join(inDir, "go.pdf")
+	if _, err := Process(OptimizeCommand(inFileTabs, outFile, config)); err != nil {
+		t.Fatalf("TestTargetVersion: %v\n", err)
+	}
+	readAndValidateFile(t, outFile)
+
+	// The document metadata is a PDF 1.4 feature.
+	v = pdf.V13
+	config = pdf.NewDefaultConfiguration()
+	config.TargetVersion = &v
+	_, err := Process(OptimizeCommand(inFile, outFile, config))
+	if !errors.Is(err, pdf.ErrTargetVersion) {
+		t.Fatalf("TestTargetVersion: want ErrTargetVersion, got %v\n", err)
+	}
+	if !strings.Contains(err.Error(), "Metadata (PDF 1.4)") {
+		t.Fatalf("TestTargetVersion: missing blocking feature: %v\n", err)
+	}
+
+	// AES needs PDF 1.6.
+	v = pdf.V15
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW, config.OwnerPW = "upw", "opw"
+	config.TargetVersion = &v
+	if _, err := Process(EncryptCommand(inFile, outFile, config)); !errors.Is(err, pdf.ErrInvalidConfig) {
+		t.Fatalf("TestTargetVersion: want ErrInvalidConfig, got %v\n", err)
+	}
+}
+
+func TestTypedErrors(t *testing.T) {
+
+	// Not a PDF.
+	_, err := ReadContext(strings.NewReader("no pdf here"), "", 11, pdf.NewDefaultConfiguration())
+	if !errors.Is(err, pdf.ErrNotAPDF) {
+		t.Fatalf("TestTypedErrors: want ErrNotAPDF, got %v\n", err)
+	}
+
+	// Encrypted files.
+	inFile := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	outFile := filepath.Join(outDir, "typedErrors.pdf")
+
+	config := pdf.NewDefaultConfiguration()
+	config.UserPW = "upw"
+	config.OwnerPW = "opw"
+	if _, err = Process(EncryptCommand(inFile, outFile, config)); err != nil {
+		t.Fatalf("TestTypedErrors: %v\n", err)
+	}
+
+	_, err = ReadContextFromFile(outFile, pdf.NewDefaultConfiguration())
+	if !errors.Is(err, pdf.ErrEncrypted) {
+		t.Fatalf("TestTypedErrors: want ErrEncrypted, got %v\n", err)
+	}
+
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "upwWrong"
+	_, err = ReadContextFromFile(outFile, config)
+	if !errors.Is(err, pdf.ErrInvalidPassword) {
+		t.Fatalf("TestTypedErrors: want ErrInvalidPassword, got %v\n", err)
+	}
+
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "upw"
+	config.OwnerPW = "opw"
+	_, err = Process(EncryptCommand(outFile, outFile, config))
+	if !errors.Is(err, pdf.ErrEncrypted) {
+		t.Fatalf("TestTypedErrors: encrypting twice: want ErrEncrypted, got %v\n", err)
+	}
+
+	// Page numbers.
+	ctx := readAndValidateFile(t, inFile)
+
+	if _, err = ExtractTextFromContext(ctx, ctx.PageCount+1); !errors.Is(err, pdf.ErrPageOutOfRange) {
+		t.Fatalf("TestTypedErrors: want ErrPageOutOfRange, got %v\n", err)
+	}
+
+	if _, err = InheritedPageAttrs(ctx, 0); !errors.Is(err, pdf.ErrPageOutOfRange) {
+		t.Fatalf("TestTypedErrors: want ErrPageOutOfRange, got %v\n", err)
+	}
+
+	stamps := []pdf.StampAnnotation{{Page: ctx.PageCount + 1, Rect: types.NewRectangle(50, 700, 250, 760)}}
+	if err = AddStampAnnotations(inFile, filepath.Join(outDir, "typedErrors.pdf"), stamps, nil); !errors.Is(err, pdf.ErrPageOutOfRange) {
+		t.Fatalf("TestTypedErrors: want ErrPageOutOfRange, got %v\n", err)
+	}
+
+	// Validation errors refer to the offending object.
+	ir, err := ctx.PageIndRef(1)
+	if err != nil {
+		t.Fatalf("TestTypedErrors: %v\n", err)
+	}
+
+	d, err := ctx.DereferenceDict(*ir)
+	if err != nil {
+		t.Fatalf("TestTypedErrors: %v\n", err)
+	}
+	d.Delete("Parent")
+
+	var ve *pdf.ValidationError
+	if err = ValidateContext(ctx); !errors.As(err, &ve) {
+		t.Fatalf("TestTypedErrors: want ValidationError, got %v\n", err)
+	}
+
+	if ve.ObjNr != ir.ObjectNumber.Value() {
+		t.Fatalf("TestTypedErrors: want obj#%d, got obj#%d\n", ir.ObjectNumber.Value(), ve.ObjNr)
+	}
+}
+
+func TestWarnings(t *testing.T) {
+
+	// This file uses a Type0 font not supported for extraction.
+	inFile := filepath.Join(inDir, "The_Go_Language_Gigon-Odienne-Wartel.pdf")
+
+	var hooked []pdf.Warning
+
+	config := pdf.NewDefaultConfiguration()
+	config.Hooks.OnWarning = func(w pdf.Warning) { hooked = append(hooked, w) }
+
+	cmd := ExtractFontsCommand(inFile, outDir, nil, config)
+
+	if _, err := Process(cmd); err != nil {
+		t.Fatalf("TestWarnings: %v\n", err)
+	}
+
+	if len(cmd.Warnings) != 1 {
+		t.Fatalf("TestWarnings: want 1 warning, got %v\n", cmd.Warnings)
+	}
+
+	w := cmd.Warnings[0]
+	if w.Op != "extractFont" || w.ObjNr != 17 {
+		t.Fatalf("TestWarnings: unexpected warning: %s\n", w)
+	}
+
+	if len(hooked) != 1 || hooked[0] != w {
+		t.Fatalf("TestWarnings: hook got %v\n", hooked)
+	}
+
+	if config.Hooks.OnWarning == nil {
+		t.Fatal("TestWarnings: OnWarning hook not restored")
+	}
+
+	// Context based callers read the warnings off the context.
+	ctx := readAndValidateFile(t, inFile)
+
+	if _, err := ExtractFontsFromContext(ctx, nil); err != nil {
+		t.Fatalf("TestWarnings: %v\n", err)
+	}
+
+	if ww := ctx.Warnings(); len(ww) != 1 || ww[0] != w {
+		t.Fatalf("TestWarnings: context warnings: %v\n", ww)
+	}
+}
+
+func TestExtractImagesDecoded(t *testing.T) {
+
+	for _, tt := range []struct {
+		fileName string
+		ext      string
+	}{
+		{"blank-scan.pdf", ".jpg"},        // FlateDecode,DCTDecode
+		{"ProgrammingInJava.pdf", ".png"}, // LZWDecode
+		{"Wonderwall.pdf", ".png"},        // image masks
+	} {
+
+		ctx := readAndValidateFile(t, filepath.Join(inDir, tt.fileName))
+
+		images, err := ExtractImagesFromContext(ctx, nil)
+		if err != nil {
+			t.Fatalf("TestExtractImagesDecoded %s: %v\n", tt.fileName, err)
+		}
+
+		if ww := ctx.Warnings(); len(ww) > 0 {
+			t.Fatalf("TestExtractImagesDecoded %s: unexpected warnings: %v\n", tt.fileName, ww)
+		}
+
+		var found bool
+
+		for _, f := range images {
+			if filepath.Ext(f.Name) != tt.ext {
+				continue
+			}
+			found = true
+			if _, _, err = image.Decode(bytes.NewReader(f.Data)); err != nil {
+				t.Fatalf("TestExtractImagesDecoded %s: %s: %v\n", tt.fileName, f.Name, err)
+			}
+		}
+
+		if !found {
+			t.Fatalf("TestExtractImagesDecoded %s: no %s image extracted\n", tt.fileName, tt.ext)
+		}
+	}
+}
+
+func TestDeterministicOutput(t *testing.T) {
+
+	config := func() *pdf.Configuration {
+		c := pdf.NewDefaultConfiguration()
+		c.Now = func() time.Time { return time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC) }
+		return c
+	}
+
+	inFiles := []string{
+		filepath.Join(inDir, "Acroforms2.pdf"),
+		filepath.Join(inDir, "TheGoProgrammingLanguageCh1.pdf"),
+	}
+
+	for _, tt := range []struct {
+		name string
+		cmd  func(outFile string) *Command
+	}{
+		{"optimize", func(outFile string) *Command { return OptimizeCommand(inFiles[1], outFile, config()) }},
+		{"merge", func(outFile string) *Command { return MergeCommand(inFiles, outFile, config()) }},
+	} {
+
+		var want []byte
+
+		for i := 0; i < 3; i++ {
+
+			outFile := filepath.Join(outDir, "deterministic.pdf")
+
+			if _, err := Process(tt.cmd(outFile)); err != nil {
+				t.Fatalf("TestDeterministicOutput %s: %v\n", tt.name, err)
+			}
+
+			b, err := ioutil.ReadFile(outFile)
+			if err != nil {
+				t.Fatalf("TestDeterministicOutput %s: %v\n", tt.name, err)
+			}
+
+			if want != nil && !bytes.Equal(b, want) {
+				t.Fatalf("TestDeterministicOutput %s: output differs in run %d\n", tt.name, i+1)
+			}
+
+			want = b
+		}
+	}
+}
+
+func TestExtractImageSlices(t *testing.T) {
+
+	f, err := os.Open(filepath.Join(inDir, "GoForOptimization.pdf"))
+	if err != nil {
+		t.Fatalf("TestExtractImageSlices: %v\n", err)
+	}
+	defer f.Close()
+
+	images, err := ExtractImageSlices(f, []string{"1"}, nil)
+	if err != nil {
+		t.Fatalf("TestExtractImageSlices: %v\n", err)
+	}
+
+	if len(images) != 13 {
+		t.Fatalf("TestExtractImageSlices: want 13 images, got %d\n", len(images))
+	}
+
+	objNrs := map[int]bool{}
+
+	for _, img := range images {
+
+		if img.PageNr != 1 || img.ObjNr == 0 || img.ResourceName == "" || img.ColorSpace == "" {
+			t.Fatalf("TestExtractImageSlices: unexpected image %+v\n", img)
+		}
+
+		if objNrs[img.ObjNr] {
+			t.Fatalf("TestExtractImageSlices: obj#%d extracted twice\n", img.ObjNr)
+		}
+		objNrs[img.ObjNr] = true
+
+		if img.MIME != "image/png" && img.MIME != "image/jpeg" {
+			continue
+		}
+
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(img.Data))
+		if err != nil {
+			t.Fatalf("TestExtractImageSlices: obj#%d: %v\n", img.ObjNr, err)
+		}
+
+		if "image/"+format != img.MIME || cfg.Width != img.Width || cfg.Height != img.Height {
+			t.Fatalf("TestExtractImageSlices: obj#%d: got %s %dx%d for %+v\n", img.ObjNr, format, cfg.Width, cfg.Height, img)
+		}
+	}
+}
+
+func TestExtractImagesCommand(t *testing.T) {
+
+	files, err := ioutil.ReadDir(inDir)
+	if err != nil {
+		t.Fatalf("TestExtractImagesCommand: %v\n", err)
+	}
+
+	c := pdf.NewDefaultConfiguration()
+
+	cmd := ExtractImagesCommand("", outDir, nil, c)
+
+	for _, file := range files {
+
+		if !strings.HasSuffix(file.Name(), "pdf") {
+			continue
+		}
+
+		inFile := filepath.Join(inDir, file.Name())
+		cmd.InFile = &inFile
+
+		// Extract all images.
+		_, err := Process(cmd)
+		if err != nil {
+			t.Fatalf("TestExtractImageCommand: %v\n", err)
+		}
+
+	}
+
+	// Extract images starting with page 1.
+	inFile := filepath.Join(inDir, "testImage.pdf")
+	_, err = Process(ExtractImagesCommand(inFile, outDir, []string{"1-"}, pdf.NewDefaultConfiguration()))
+	if err != nil {
+		t.Fatalf("TestExtractImageCommand: %v\n", err)
+	}
+
+}
+
+func TestExtractFontsCommand(t *testing.T) {
+
+	cmd := ExtractFontsCommand("", outDir, nil, pdf.NewDefaultConfiguration())
+
+	for _, fn := range []string{"5116.DCT_Filter.pdf", "testImage.pdf", "go.pdf"} {
+
+		fn = filepath.Join(inDir, fn)
+		cmd.InFile = &fn
+
+		_, err := Process(cmd)
+		if err != nil {
+			t.Fatalf("TestExtractFontsCommand: %v\n", err)
+		}
+
+	}
+
+	inFile := filepath.Join(inDir, "go.pdf")
+	_, err := Process(ExtractFontsCommand(inFile, outDir, []string{"1-3"}, pdf.NewDefaultConfiguration()))
+	if err != nil {
+		t.Fatalf("TestExtractFontsCommand: %v\n", err)
+	}
+
+}
+
+func TestExtractContentCommand(t *testing.T) {
+
+	inFile := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+
+	_, err := Process(ExtractContentCommand(inFile, outDir, nil, pdf.NewDefaultConfiguration()))
+	if err != nil {
+		t.Fatalf("TestExtractContentCommand: %v\n", err)
+	}
+
+}
+
+func TestExtractPagesCommand(t *testing.T) {
+
+	inFile := filepath.Join(inDir, "TheGoProgrammingLanguageCh1.pdf")
+
+	cmd := ExtractPagesCommand(inFile, outDir, []string{"3", "1"}, pdf.NewDefaultConfiguration())
+
+	out, err := Process(cmd)
+	if err != nil {
+		t.Fatalf("TestExtractPagesCommand: %v\n", err)
+	}
+
+	want := []PageFile{
+		{PageNr: 1, FileName: outDir + "/TheGoProgrammingLanguageCh1_1.pdf"},
+		{PageNr: 3, FileName: outDir + "/TheGoProgrammingLanguageCh1_3.pdf"},
+	}
+
+	if len(out) != 2 || len(cmd.PageFiles) != 2 || cmd.PageFiles[0] != want[0] || cmd.PageFiles[1] != want[1] ||
+		out[0] != want[0].FileName || out[1] != want[1].FileName {
+		t.Fatalf("TestExtractPagesCommand: want %v, got %v %v\n", want, out, cmd.PageFiles)
+	}
+
+}
+
+// Extract selected pages into a single file and into a writer.
+func TestExtractPagesToFile(t *testing.T) {
+
+	inFile := filepath.Join(inDir, "TheGoProgrammingLanguageCh1.pdf")
+	outFile := filepath.Join(outDir, "extractPages.pdf")
+	config := pdf.NewDefaultConfiguration()
+
+	_, err := Process(ExtractPagesToFileCommand(inFile, outFile, []string{"2-4", "7"}, config))
+	if err != nil {
+		t.Fatalf("TestExtractPagesToFile: %v\n", err)
+	}
+
+	if ctx := readAndValidateFile(t, outFile); ctx.PageCount != 4 {
+		t.Fatalf("TestExtractPagesToFile: want 4 pages, got %d\n", ctx.PageCount)
+	}
+
+	f, err := os.Open(inFile)
+	if err != nil {
+		t.Fatalf("TestExtractPagesToFile: %v\n", err)
+	}
+	defer f.Close()
+
+	var buf bytes.Buffer
+	if err = ExtractPagesToWriter(f, &buf, []string{"1", "3"}, config); err != nil {
+		t.Fatalf("TestExtractPagesToFile: %v\n", err)
+	}
+
+	ctx, err := ReadContext(bytes.NewReader(buf.Bytes()), "", int64(buf.Len()), config)
+	if err != nil {
+		t.Fatalf("TestExtractPagesToFile: %v\n", err)
+	}
+	if err = ValidateContext(ctx); err != nil {
+		t.Fatalf("TestExtractPagesToFile: %v\n", err)
+	}
+	if ctx.PageCount != 2 {
+		t.Fatalf("TestExtractPagesToFile: want 2 pages, got %d\n", ctx.PageCount)
+	}
+}
+
+func TestEncryptUPWOnly(t *testing.T) {
+
+	// Test for setting only the user password.
+
+	t.Log("running TestEncryptUPWOnly..")
+
+	inFile := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	outFile := filepath.Join(outDir, "test.pdf")
+
+	// Encrypt upw only
+	t.Log("Encrypt upw only")
+	config := pdf.NewDefaultConfiguration()
+	config.UserPW = "upw"
+	_, err := Process(EncryptCommand(inFile, outFile, config))
+	if err != nil {
+		t.Fatalf("TestEncryptUPWOnly - encrypt with upw only to %s: %v\n", outFile, err)
+	}
+
+	// Validate wrong upw
+	t.Log("Validate wrong upw fails")
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "upwWrong"
+	_, err = Process(ValidateCommand(outFile, config))
+	if err == nil {
+		t.Fatalf("TestEncryptUPWOnly - validate %s using wrong upw should fail!\n", outFile)
+	}
+
+	// Validate wrong opw
+	t.Log("Validate wrong opw fails")
+	config = pdf.NewDefaultConfiguration()
+	config.OwnerPW = "opwWrong"
+	_, err = Process(ValidateCommand(outFile, config))
+	if err == nil {
+		t.Fatalf("TestEncryptUPWOnly - validate %s using wrong opw should fail!\n", outFile)
+	}
+
+	// Validate default opw=upw (if there is no ownerpw set)
+	t.Log("Validate default opw")
+	config = pdf.NewDefaultConfiguration()
+	config.OwnerPW = "upw"
+	_, err = Process(ValidateCommand(outFile, config))
+	if err != nil {
+		t.Fatalf("TestEncryptUPWOnly - validate %s using default opw: %s!\n", outFile, err)
+	}
+
+	// Validate upw
+	t.Log("Validate upw")
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "upw"
+	_, err = Process(ValidateCommand(outFile, config))
+	if err != nil {
+		t.Fatalf("TestEncryptUPWOnly - validate %s using upw: %v\n", outFile, err)
+	}
+
+	// Optimize wrong opw
+	t.Log("Optimize wrong opw fails")
+	config = pdf.NewDefaultConfiguration()
+	config.OwnerPW = "opwWrong"
+	_, err = Process(OptimizeCommand(outFile, outFile, config))
+	if err == nil {
+		t.Fatalf("TestEncryptUPWOnly - optimize %s using wrong opw should fail!\n", outFile)
+	}
+
+	// Optimize empty opw
+	t.Log("Optimize empty opw fails")
+	config = pdf.NewDefaultConfiguration()
+	config.OwnerPW = ""
+	_, err = Process(OptimizeCommand(outFile, outFile, config))
+	if err == nil {
+		t.Fatalf("TestEncryptUPWOnly - optimize %s using empty opw should fail!\n", outFile)
+	}
+
+	// Optimize wrong upw
+	t.Log("Optimize wrong upw fails")
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "upwWrong"
+	_, err = Process(OptimizeCommand(outFile, outFile, config))
+	if err == nil {
+		t.Fatalf("TestEncryptUPWOnly - optimize %s using wrong upw should fail!\n", outFile)
+	}
+
+	// Optimize upw
+	t.Log("Optimize upw")
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "upw"
+	_, err = Process(OptimizeCommand(outFile, outFile, config))
+	if err != nil {
+		t.Fatalf("TestEncryptUPWOnly - optimize %s using upw: %v\n", outFile, err)
+	}
+
+	//Change upw wrong upwOld
+	t.Log("ChangeUserPW wrong upwOld fails")
+	config = pdf.NewDefaultConfiguration()
+	pwOld := "upwWrong"
+	pwNew := "upwNew"
+	_, err = Process(ChangeUserPWCommand(outFile, outFile, config, &pwOld, &pwNew))
+	if err == nil {
+		t.Fatalf("TestEncryptUPWOnly - %s change userPW using wrong upwOld should fail\n", outFile)
+	}
+
+	// Change upw
+	t.Log("ChangeUserPW")
+	config = pdf.NewDefaultConfiguration()
+	pwOld = "upw"
+	pwNew = "upwNew"
+	_, err = Process(ChangeUserPWCommand(outFile, outFile, config, &pwOld, &pwNew))
+	if err != nil {
+		t.Fatalf("TestEncryptUPWOnly - %s change userPW: %v\n", outFile, err)
+	}
+
+	// Decrypt wrong opw
 	t.Log("Decrypt wrong opw fails")
 	config = pdf.NewDefaultConfiguration()
-	config.OwnerPW = "opwWrong"
-	_, err = Process(DecryptCommand(outFile, outFile, config))
+	config.OwnerPW = "opwWrong"
+	_, err = Process(DecryptCommand(outFile, outFile, config))
+	if err == nil {
+		t.Fatalf("TestEncryptUPWOnly - %s decrypt using wrong opw should fail\n", outFile)
+	}
+
+	// Decrypt wrong upw
+	t.Log("Decrypt wrong upw fails")
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "upw"
+	_, err = Process(DecryptCommand(outFile, outFile, config))
+	if err == nil {
+		t.Fatalf("TestEncryptUPWOnly - %s decrypt using wrong upw should fail\n", outFile)
+	}
+
+	// Decrypt upw
+	t.Log("Decrypt upw")
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "upwNew"
+	_, err = Process(DecryptCommand(outFile, outFile, config))
+	if err != nil {
+		t.Fatalf("TestEncryptUPWOnly - %s decrypt using upw: %v\n", outFile, err)
+	}
+
+}
+
+func TestEncryptOPWOnly(t *testing.T) {
+
+	// Test for setting only the owner password.
+
+	t.Log("running TestEncryptOPWOnly..")
+
+	inFile := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	outFile := filepath.Join(outDir, "test.pdf")
+
+	// Encrypt opw only
+	t.Log("Encrypt opw only")
+	config := pdf.NewDefaultConfiguration()
+	config.OwnerPW = "opw"
+	_, err := Process(EncryptCommand(inFile, outFile, config))
+	if err != nil {
+		t.Fatalf("TestEncryptOPWOnly - encrypt with opw only to %s: %v\n", outFile, err)
+	}
+
+	// Validate wrong opw succeeds with fallback to empty upw
+	t.Log("Validate wrong opw succeeds with fallback to empty upw")
+	config = pdf.NewDefaultConfiguration()
+	config.OwnerPW = "opwWrong"
+	_, err = Process(ValidateCommand(outFile, config))
+	if err != nil {
+		t.Fatalf("TestEncryptOPWOnly - validate %s using wrong opw succeeds falling back to empty upw!: %v\n", outFile, err)
+	}
+
+	// Validate opw
+	t.Log("Validate opw")
+	config = pdf.NewDefaultConfiguration()
+	config.OwnerPW = "opw"
+	_, err = Process(ValidateCommand(outFile, config))
+	if err != nil {
+		t.Fatalf("TestEncryptOPWOnly - validate %s using opw: %v\n", outFile, err)
+	}
+
+	// Validate wrong upw
+	t.Log("Validate wrong upw fails")
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "upwWrong"
+	_, err = Process(ValidateCommand(outFile, config))
+	if err == nil {
+		t.Fatalf("TestEncryptOPWOnly - validate %s using wrong upw should fail!\n", outFile)
+	}
+
+	// Validate no pw using empty upw
+	t.Log("Validate no pw using empty upw")
+	config = pdf.NewDefaultConfiguration()
+	_, err = Process(ValidateCommand(outFile, config))
+	if err != nil {
+		t.Fatalf("TestEncryptOPWOnly - validate %s no pw using empty upw: %v\n", outFile, err)
+	}
+
+	// Optimize wrong opw, succeeds with fallback to empty upw
+	t.Log("Optimize wrong opw succeeds with fallback to empty upw")
+	config = pdf.NewDefaultConfiguration()
+	config.OwnerPW = "opwWrong"
+	_, err = Process(OptimizeCommand(outFile, outFile, config))
+	if err != nil {
+		t.Fatalf("TestEncryptOPWOnly - optimize %s using wrong opw succeeds falling back to empty upw: %v\n", outFile, err)
+	}
+
+	// Optimize opw
+	t.Log("Optimize opw")
+	config = pdf.NewDefaultConfiguration()
+	config.OwnerPW = "opw"
+	_, err = Process(OptimizeCommand(outFile, outFile, config))
+	if err != nil {
+		t.Fatalf("TestEncryptOPWOnly - optimize %s using opw: %v\n", outFile, err)
+	}
+
+	// Optimize wrong upw
+	t.Log("Optimize wrong upw fails")
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "upwWrong"
+	_, err = Process(OptimizeCommand(outFile, outFile, config))
+	if err == nil {
+		t.Fatalf("TestEncryptOPWOnly - optimize %s using wrong upw should fail!\n", outFile)
+	}
+
+	// Optimize empty upw
+	t.Log("Optimize empty upw")
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = ""
+	_, err = Process(OptimizeCommand(outFile, outFile, config))
+	if err != nil {
+		t.Fatalf("TestEncryptOPWOnly - optimize %s using upw: %v\n", outFile, err)
+	}
+
+	// Change opw wrong upw
+	t.Log("ChangeOwnerPW wrong upw fails")
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "upw"
+	pwOld := "opw"
+	pwNew := "opwNew"
+	_, err = Process(ChangeOwnerPWCommand(outFile, outFile, config, &pwOld, &pwNew))
+	if err == nil {
+		t.Fatalf("TestEncryptOPWOnly - %s change opw using wrong upw should fail\n", outFile)
+	}
+
+	// Change opw wrong opwOld
+	t.Log("ChangeOwnerPW wrong opwOld fails")
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = ""
+	pwOld = "opwOldWrong"
+	pwNew = "opwNew"
+	_, err = Process(ChangeOwnerPWCommand(outFile, outFile, config, &pwOld, &pwNew))
+	if err == nil {
+		t.Fatalf("TestEncryptOPWOnly - %s change opw using wrong opwOld should fail\n", outFile)
+	}
+
+	// Change opw
+	t.Log("ChangeOwnerPW")
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = ""
+	pwOld = "opw"
+	pwNew = "opwNew"
+	_, err = Process(ChangeOwnerPWCommand(outFile, outFile, config, &pwOld, &pwNew))
+	if err != nil {
+		t.Fatalf("TestEncryptOPWOnly - %s change opw: %v\n", outFile, err)
+	}
+
+	// Decrypt wrong upw
+	t.Log("Decrypt wrong upw fails")
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "upwWrong"
+	_, err = Process(DecryptCommand(outFile, outFile, config))
+	if err == nil {
+		t.Fatalf("TestEncryptOPWOnly - %s decrypt using wrong upw should fail \n", outFile)
+	}
+
+	// Decrypt wrong opw succeeds because of fallback to empty upw.
+	t.Log("Decrypt wrong opw succeeds because of fallback to empty upw")
+	config = pdf.NewDefaultConfiguration()
+	config.OwnerPW = "opw"
+	_, err = Process(DecryptCommand(outFile, outFile, config))
+	if err != nil {
+		t.Fatalf("TestEncryptOPWOnly - %s decrypt using opw: %v\n", outFile, err)
+	}
+
+}
+
+func TestEncrypt(t *testing.T) {
+
+	t.Log("running TestEncrypt..")
+
+	inFile := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	outFile := filepath.Join(outDir, "test.pdf")
+
+	// Encrypt opw and upw
+	t.Log("Encrypt")
+	config := pdf.NewDefaultConfiguration()
+	config.UserPW = "upw"
+	config.OwnerPW = "opw"
+	_, err := Process(EncryptCommand(inFile, outFile, config))
+	if err != nil {
+		t.Fatalf("TestEncrypt - encrypt to %s: %v\n", outFile, err)
+	}
+
+	// Validate wrong opw
+	t.Log("Validate wrong opw fails")
+	config = pdf.NewDefaultConfiguration()
+	config.OwnerPW = "opwWrong"
+	_, err = Process(ValidateCommand(outFile, config))
+	if err == nil {
+		t.Fatalf("TestEncrypt - validate %s using wrong opw should fail!\n", outFile)
+	}
+
+	// Validate opw
+	t.Log("Validate opw")
+	config = pdf.NewDefaultConfiguration()
+	config.OwnerPW = "opw"
+	_, err = Process(ValidateCommand(outFile, config))
+	if err != nil {
+		t.Fatalf("TestEncrypt - validate %s using opw: %v\n", outFile, err)
+	}
+
+	// Validate wrong upw
+	t.Log("Validate wrong upw fails")
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "upwWrong"
+	_, err = Process(ValidateCommand(outFile, config))
+	if err == nil {
+		t.Fatalf("TestEncrypt - validate %s using wrong upw should fail!\n", outFile)
+	}
+
+	// Validate upw
+	t.Log("Validate upw")
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "upw"
+	_, err = Process(ValidateCommand(outFile, config))
+	if err != nil {
+		t.Fatalf("TestEncrypt - validate %s using upw: %v\n", outFile, err)
+	}
+
+	// Change upw to "" = remove document open password.
+	t.Log("ChangeUserPW to \"\"")
+	config = pdf.NewDefaultConfiguration()
+	config.OwnerPW = "opw"
+	pwOld := "upw"
+	pwNew := ""
+	_, err = Process(ChangeUserPWCommand(outFile, outFile, config, &pwOld, &pwNew))
+	if err != nil {
+		t.Fatalf("TestEncrypt - %s change userPW to \"\": %v\n", outFile, err)
+	}
+
+	// Validate upw
+	t.Log("Validate upw")
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = ""
+	_, err = Process(ValidateCommand(outFile, config))
+	if err != nil {
+		t.Fatalf("TestEncrypt - validate %s using upw: %v\n", outFile, err)
+	}
+
+	// Validate no pw
+	t.Log("Validate upw")
+	config = pdf.NewDefaultConfiguration()
+	_, err = Process(ValidateCommand(outFile, config))
+	if err != nil {
+		t.Fatalf("TestEncrypt - validate %s: %v\n", outFile, err)
+	}
+
+	// Change opw
+	t.Log("ChangeOwnerPW")
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = ""
+	pwOld = "opw"
+	pwNew = "opwNew"
+	_, err = Process(ChangeOwnerPWCommand(outFile, outFile, config, &pwOld, &pwNew))
+	if err != nil {
+		t.Fatalf("TestEncrypt - %s change opw: %v\n", outFile, err)
+	}
+
+	// Decrypt wrong upw
+	t.Log("Decrypt wrong upw fails")
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "upwWrong"
+	_, err = Process(DecryptCommand(outFile, outFile, config))
+	if err == nil {
+		t.Fatalf("TestEncrypt - %s decrypt using wrong upw should fail\n", outFile)
+	}
+
+	// Decrypt wrong opw succeeds on empty upw
+	t.Log("Decrypt wrong opw succeeds on empty upw")
+	config = pdf.NewDefaultConfiguration()
+	config.OwnerPW = "opwWrong"
+	_, err = Process(DecryptCommand(outFile, outFile, config))
+	if err != nil {
+		t.Fatalf("TestEncrypt - %s decrypt wrong opw, empty upw: %v\n", outFile, err)
+	}
+}
+
+func encryptDecrypt(fileName string, config *pdf.Configuration, t *testing.T) {
+
+	inFile := filepath.Join(inDir, fileName)
+	outFile := filepath.Join(outDir, "test.pdf")
+
+	t.Log(inFile)
+
+	// Encrypt
+	t.Log("Encrypt")
+	_, err := Process(EncryptCommand(inFile, outFile, config))
+	if err != nil {
+		t.Fatalf("TestEncryptDecrypt - encrypt %s: %v\n", outFile, err)
+	}
+
+	// Encrypt already encrypted
+	t.Log("Encrypt already encrypted")
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "upw"
+	config.OwnerPW = "opw"
+	_, err = Process(EncryptCommand(outFile, outFile, config))
+	if err == nil {
+		t.Fatalf("TestEncryptDecrypt - encrypt encrypted %s\n", outFile)
+	}
+
+	// Validate using wrong owner pw
+	t.Log("Validate wrong ownerPW")
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "upw"
+	config.OwnerPW = "opwWrong"
+	_, err = Process(ValidateCommand(outFile, config))
+	if err != nil {
+		t.Fatalf("TestEncryptDecrypt - validate %s using wrong ownerPW: %v\n", outFile, err)
+	}
+
+	// Optimize using wrong owner pw
+	t.Log("Optimize wrong ownerPW")
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "upw"
+	config.OwnerPW = "opwWrong"
+	_, err = Process(OptimizeCommand(outFile, outFile, config))
+	if err != nil {
+		t.Fatalf("TestEncryptDecrypt - optimize %s using wrong ownerPW: %v\n", outFile, err)
+	}
+
+	// Trim using wrong owner pw, falls back to upw and fails with insufficient permissions.
+	t.Log("Trim wrong ownerPW, fallback to upw and fail with insufficient permissions.")
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "upw"
+	config.OwnerPW = "opwWrong"
+	// pageSelection = nil, writes w/o trimming anything, but sufficient for testing.
+	_, err = Process(TrimCommand(outFile, outFile, nil, config))
+	if err == nil {
+		t.Fatalf("TestEncryptDecrypt - trim %s using wrong ownerPW should fail: \n", outFile)
+	}
+
+	// Split using wrong owner pw, falls back to upw and fails with insufficient permissions.
+	t.Log("Split wrong ownerPW, fallback to upw and fail with insufficient permissions.")
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "upw"
+	config.OwnerPW = "opwWrong"
+	_, err = Process(SplitCommand(outFile, outDir, config))
+	if err == nil {
+		t.Fatalf("TestEncryptDecrypt - split %s using wrong ownerPW should fail: \n", outFile)
+	}
+
+	// Add permissions
+	t.Log("Add user access permissions")
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "upw"
+	config.OwnerPW = "opw"
+	config.UserAccessPermissions = pdf.PermissionsAll
+	_, err = Process(AddPermissionsCommand(outFile, config))
+	if err != nil {
+		t.Fatalf("TestEncryptDecrypt - %s add permissions: %v\n", outFile, err)
+	}
+
+	// Add permissions writing to an io.Writer leaving the configuration alone.
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "upw"
+	config.OwnerPW = "opw"
+	config.UserAccessPermissions = pdf.PermissionsAll
+	f, err := os.Open(outFile)
+	if err != nil {
+		t.Fatalf("TestEncryptDecrypt: %v\n", err)
+	}
+	defer f.Close()
+	if err = AddPermissionsToWriter(f, ioutil.Discard, config); err != nil {
+		t.Fatalf("TestEncryptDecrypt - %s add permissions: %v\n", outFile, err)
+	}
+	if config.Mode != pdf.VALIDATE {
+		t.Fatalf("TestEncryptDecrypt - add permissions changed config mode to %d\n", config.Mode)
+	}
+
+	// Split using wrong owner pw, falls back to upw
+	t.Log("Split wrong ownerPW")
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "upw"
+	config.OwnerPW = "opwWrong"
+	_, err = Process(SplitCommand(outFile, outDir, config))
+	if err != nil {
+		t.Fatalf("TestEncryptDecrypt - split %s using wrong ownerPW: %v\n", outFile, err)
+	}
+
+	// Validate
+	t.Log("Validate")
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "upw"
+	config.OwnerPW = "opw"
+	_, err = Process(ValidateCommand(outFile, config))
+	if err != nil {
+		t.Fatalf("TestEncryptDecrypt - validate %s: %v\n", outFile, err)
+	}
+
+	// ChangeUserPW using wrong userpw
+	t.Log("ChangeUserPW wrong userpw")
+	config = pdf.NewDefaultConfiguration()
+	config.OwnerPW = "opw"
+	pwOld := "upwWrong"
+	pwNew := "upwNew"
+	_, err = Process(ChangeUserPWCommand(outFile, outFile, config, &pwOld, &pwNew))
+	if err == nil {
+		t.Fatalf("TestEncryption - %s change userPW using wrong userPW should fail:\n", outFile)
+	}
+
+	// ChangeUserPW
+	t.Log("ChangeUserPW")
+	config = pdf.NewDefaultConfiguration()
+	config.OwnerPW = "opw"
+	pwOld = "upw"
+	pwNew = "upwNew"
+	_, err = Process(ChangeUserPWCommand(outFile, outFile, config, &pwOld, &pwNew))
+	if err != nil {
+		t.Fatalf("TestEncryption - change userPW %s: %v\n", outFile, err)
+	}
+
+	// ChangeOwnerPW
+	t.Log("ChangeOwnerPW")
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "upwNew"
+	pwOld = "opw"
+	pwNew = "opwNew"
+	_, err = Process(ChangeOwnerPWCommand(outFile, outFile, config, &pwOld, &pwNew))
+	if err != nil {
+		t.Fatalf("TestEncryption - change ownerPW %s: %v\n", outFile, err)
+	}
+
+	// Decrypt using wrong pw
+	t.Log("\nDecrypt using wrong pw")
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "upwWrong"
+	config.OwnerPW = "opwWrong"
+	_, err = Process(DecryptCommand(outFile, outFile, config))
+	if err == nil {
+		t.Fatalf("TestEncryptDecrypt - decrypt using wrong pw %s\n", outFile)
+	}
+
+	// Decrypt
+	t.Log("\nDecrypt")
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "upwNew"
+	config.OwnerPW = "opwNew"
+	_, err = Process(DecryptCommand(outFile, outFile, config))
+	if err != nil {
+		t.Fatalf("TestEncryptDecrypt - decrypt %s: %v\n", outFile, err)
+	}
+
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+
+	config := pdf.NewDefaultConfiguration()
+	config.UserPW = "upw"
+	config.OwnerPW = "opw"
+	encryptDecrypt("5116.DCT_Filter.pdf", config, t)
+
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "upw"
+	config.OwnerPW = "opw"
+	config.EncryptUsingAES = false
+	config.EncryptUsing128BitKey = false
+	config.ForceWeakEncryption = true
+	encryptDecrypt("networkProgr.pdf", config, t)
+}
+
+func TestEncryptMetadata(t *testing.T) {
+
+	fileName := filepath.Join(inDir, "Wonderwall.pdf")
+	outFile := filepath.Join(outDir, "encryptMetadata.pdf")
+
+	ff, err := ExtractMetadataFromContext(readAndValidateFile(t, fileName))
+	if err != nil || len(ff) != 1 {
+		t.Fatalf("TestEncryptMetadata: missing metadata: %v\n", err)
+	}
+	md := ff[0].Data
+
+	for _, encryptMetadata := range []bool{true, false} {
+
+		config := pdf.NewDefaultConfiguration()
+		config.UserPW = "upw"
+		config.OwnerPW = "opw"
+		config.EncryptMetadata = encryptMetadata
+		if _, err := Process(EncryptCommand(fileName, outFile, config)); err != nil {
+			t.Fatalf("TestEncryptMetadata: %v\n", err)
+		}
+
+		// The metadata stream is uncompressed.
+		b, err := ioutil.ReadFile(outFile)
+		if err != nil {
+			t.Fatalf("TestEncryptMetadata: %v\n", err)
+		}
+		if bytes.Contains(b, md) == encryptMetadata {
+			t.Fatalf("TestEncryptMetadata: EncryptMetadata=%t: metadata readable: %t\n", encryptMetadata, !encryptMetadata)
+		}
+
+		config = pdf.NewDefaultConfiguration()
+		config.UserPW = "upw"
+		ctx, err := ReadContextFromFile(outFile, config)
+		if err != nil {
+			t.Fatalf("TestEncryptMetadata: %v\n", err)
+		}
+		if ctx.E.Emd != encryptMetadata {
+			t.Fatalf("TestEncryptMetadata: want EncryptMetadata=%t\n", encryptMetadata)
+		}
+
+		ff, err := ExtractMetadataFromContext(ctx)
+		if err != nil || len(ff) != 1 || !bytes.Equal(ff[0].Data, md) {
+			t.Fatalf("TestEncryptMetadata: EncryptMetadata=%t: corrupted metadata: %v\n", encryptMetadata, err)
+		}
+	}
+}
+
+func TestEncryptPolicy(t *testing.T) {
+
+	fileName := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	outFile := filepath.Join(outDir, "out.pdf")
+
+	// RC4 with a 40 bit key needs to be forced.
+	config := pdf.NewDefaultConfiguration()
+	config.UserPW = "upw"
+	config.OwnerPW = "opw"
+	config.EncryptUsingAES = false
+	config.EncryptUsing128BitKey = false
+	_, err := Process(EncryptCommand(fileName, outFile, config))
+	if err == nil {
+		t.Fatalf("TestEncryptPolicy - encrypting %s using RC4-40 should fail\n", fileName)
+	}
+
+	// Passwords not satisfying the policy.
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "upw"
+	config.OwnerPW = "opw"
+	config.PasswordPolicy = &pdf.PasswordPolicy{MinLength: 8, MinCharClasses: 3}
+	_, err = Process(EncryptCommand(fileName, outFile, config))
+	if err == nil {
+		t.Fatalf("TestEncryptPolicy - encrypting %s using weak passwords should fail\n", fileName)
+	}
+
+	// Passwords satisfying the policy.
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "userPW2018"
+	config.OwnerPW = "ownerPW#2018"
+	config.PasswordPolicy = &pdf.PasswordPolicy{MinLength: 8, MinCharClasses: 3}
+	report, err := Process(EncryptCommand(fileName, outFile, config))
+	if err != nil {
+		t.Fatalf("TestEncryptPolicy - encrypt %s: %v\n", fileName, err)
+	}
+	if len(report) == 0 || !strings.HasPrefix(report[0], "algorithm: AES 128 bit") {
+		t.Fatalf("TestEncryptPolicy - unexpected encryption report: %v\n", report)
+	}
+	for _, s := range report {
+		t.Log(s)
+	}
+
+	// Changing the user password applies the policy to the new password.
+	config = pdf.NewDefaultConfiguration()
+	config.OwnerPW = "ownerPW#2018"
+	config.PasswordPolicy = &pdf.PasswordPolicy{MinLength: 8, MinCharClasses: 3}
+	pwOld := "userPW2018"
+	pwNew := "upw"
+	_, err = Process(ChangeUserPWCommand(outFile, outFile, config, &pwOld, &pwNew))
+	if err == nil {
+		t.Fatalf("TestEncryptPolicy - changing the user password of %s to a weak password should fail\n", outFile)
+	}
+}
+
+func TestForbidWeakEncryption(t *testing.T) {
+
+	fileName := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	outFile := filepath.Join(outDir, "legacy.pdf")
+	outFile2 := filepath.Join(outDir, "out.pdf")
+
+	config := pdf.NewDefaultConfiguration()
+	config.UserPW = "upw"
+	config.OwnerPW = "opw"
+	config.EncryptUsingAES = false
+	_, err := Process(EncryptCommand(fileName, outFile, config))
+	if err != nil {
+		t.Fatalf("TestForbidWeakEncryption - encrypt %s: %v\n", fileName, err)
+	}
+
+	// Encrypting is forbidden.
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "upw"
+	config.OwnerPW = "opw"
+	config.ForbidWeakEncryption = true
+	_, err = Process(EncryptCommand(fileName, outFile2, config))
+	if err == nil || !strings.Contains(err.Error(), "ForceWeakEncryption") {
+		t.Fatalf("TestForbidWeakEncryption - encrypt %s should fail mentioning the override: %v\n", fileName, err)
+	}
+
+	// Reading legacy encrypted files is allowed.
+	_, err = Process(ValidateCommand(outFile, config))
+	if err != nil {
+		t.Fatalf("TestForbidWeakEncryption - validate %s: %v\n", outFile, err)
+	}
+
+	// Writing legacy encrypted files is forbidden.
+	_, err = Process(OptimizeCommand(outFile, outFile2, config))
+	if err == nil {
+		t.Fatalf("TestForbidWeakEncryption - optimize %s should fail\n", outFile)
+	}
+
+	// Unless forced.
+	config.ForceWeakEncryption = true
+	_, err = Process(OptimizeCommand(outFile, outFile2, config))
+	if err != nil {
+		t.Fatalf("TestForbidWeakEncryption - forced optimize %s: %v\n", outFile, err)
+	}
+
+	// Decrypting legacy encrypted files is allowed.
+	config.ForceWeakEncryption = false
+	_, err = Process(DecryptCommand(outFile, outFile2, config))
+	if err != nil {
+		t.Fatalf("TestForbidWeakEncryption - decrypt %s: %v\n", outFile, err)
+	}
+}
+
+func TestEnforcePermissions(t *testing.T) {
+
+	fileName := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	outFile := filepath.Join(outDir, "restricted.pdf")
+
+	config := pdf.NewDefaultConfiguration()
+	config.UserPW = "upw"
+	config.OwnerPW = "opw"
+	config.UserAccessPermissions = pdf.PermissionsNone
+	_, err := Process(EncryptCommand(fileName, outFile, config))
+	if err != nil {
+		t.Fatalf("TestEnforcePermissions - encrypt %s: %v\n", fileName, err)
+	}
+
+	// Extracting using the user password only is refused, also when bypassing Process.
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "upw"
+	_, err = ExtractContent(ExtractContentCommand(outFile, outDir, nil, config))
+	if err == nil {
+		t.Fatalf("TestEnforcePermissions - extract content from %s using the user password should fail\n", outFile)
+	}
+
+	// Override for authorized use.
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "upw"
+	config.IgnorePermissions = true
+	_, err = Process(ExtractContentCommand(outFile, outDir, nil, config))
+	if err != nil {
+		t.Fatalf("TestEnforcePermissions - extract content from %s ignoring permissions: %v\n", outFile, err)
+	}
+
+	// The owner password grants full access.
+	config = pdf.NewDefaultConfiguration()
+	config.OwnerPW = "opw"
+	_, err = Process(ExtractContentCommand(outFile, outDir, nil, config))
+	if err != nil {
+		t.Fatalf("TestEnforcePermissions - extract content from %s using the owner password: %v\n", outFile, err)
+	}
+}
+
+func TestEnforcePermissionsOfCommands(t *testing.T) {
+
+	fileName := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	outFile := filepath.Join(outDir, "restrictedCommands.pdf")
+	fileOut := filepath.Join(outDir, "restrictedCommandsOut.pdf")
+
+	config := pdf.NewDefaultConfiguration()
+	config.UserPW = "upw"
+	config.OwnerPW = "opw"
+	config.UserAccessPermissions = pdf.PermissionsNone
+	if _, err := Process(EncryptCommand(fileName, outFile, config)); err != nil {
+		t.Fatalf("TestEnforcePermissionsOfCommands - encrypt %s: %v\n", fileName, err)
+	}
+
+	bb, err := ioutil.ReadFile(outFile)
+	if err != nil {
+		t.Fatalf("TestEnforcePermissionsOfCommands: %v\n", err)
+	}
+
+	jsonFile := filepath.Join(outDir, "restrictedCommands.json")
+	jsonFileIn := filepath.Join(outDir, "restrictedCommandsIn.json")
+	if err = ioutil.WriteFile(jsonFileIn, []byte(`{"annotations": []}`), 0644); err != nil {
+		t.Fatalf("TestEnforcePermissionsOfCommands: %v\n", err)
+	}
+
+	for name, f := range map[string]func(config *pdf.Configuration) error{
+		"TextSpans": func(config *pdf.Configuration) error {
+			_, err := TextSpans(outFile, nil, config)
+			return err
+		},
+		"RenderPages": func(config *pdf.Configuration) error {
+			_, err := RenderPages(outFile, outDir, []string{"1"}, RenderPNG, 10, config)
+			return err
+		},
+		"ExportPageText": func(config *pdf.Configuration) error {
+			return ExportPageText(outFile, outDir, nil, "md", config)
+		},
+		"ExportOCRText": func(config *pdf.Configuration) error {
+			return ExportOCRText(outFile, filepath.Join(outDir, "restricted.hocr"), nil, "hocr", config)
+		},
+		"WordFrequencies": func(config *pdf.Configuration) error {
+			_, err := WordFrequencies(outFile, nil, config)
+			return err
+		},
+		"DetectLanguages": func(config *pdf.Configuration) error {
+			_, err := DetectLanguages(outFile, nil, config)
+			return err
+		},
+		"ExtractImageSlices": func(config *pdf.Configuration) error {
+			_, err := ExtractImageSlices(bytes.NewReader(bb), nil, config)
+			return err
+		},
+		"Comments": func(config *pdf.Configuration) error {
+			_, err := Comments(outFile, nil, config)
+			return err
+		},
+		"ExtractText": func(config *pdf.Configuration) error {
+			_, err := ExtractText(ExtractTextCommand(outFile, outDir, nil, config))
+			return err
+		},
+		"FillFormFields": func(config *pdf.Configuration) error {
+			return FillFormFields(outFile, fileOut, map[string]string{}, config)
+		},
+		"AddFormFields": func(config *pdf.Configuration) error {
+			return AddFormFields(outFile, fileOut, nil, config)
+		},
+		"Grayscale": func(config *pdf.Configuration) error {
+			return Grayscale(outFile, fileOut, nil, pdf.GrayscaleImages, config)
+		},
+		"Booklet": func(config *pdf.Configuration) error {
+			return Booklet(outFile, fileOut, config)
+		},
+		"FlattenAnnotations": func(config *pdf.Configuration) error {
+			return FlattenAnnotations(outFile, fileOut, nil, nil, config)
+		},
+		"LockFormFields": func(config *pdf.Configuration) error {
+			return LockFormFields(outFile, fileOut, nil, false, config)
+		},
+		"SetLanguage": func(config *pdf.Configuration) error {
+			return SetLanguage(outFile, fileOut, "en", config)
+		},
+		"ImagePlacements": func(config *pdf.Configuration) error {
+			_, err := ImagePlacements(outFile, nil, config)
+			return err
+		},
+		"AddSignatureFields": func(config *pdf.Configuration) error {
+			return AddSignatureFields(outFile, fileOut, nil, config)
+		},
+		"SetTabOrder": func(config *pdf.Configuration) error {
+			return SetTabOrder(outFile, fileOut, nil, pdf.TabOrderRow, config)
+		},
+		"SetCalculationOrder": func(config *pdf.Configuration) error {
+			return SetCalculationOrder(outFile, fileOut, nil, config)
+		},
+		"SetChoiceOptions": func(config *pdf.Configuration) error {
+			return SetChoiceOptions(outFile, fileOut, "choice", nil, config)
+		},
+		"AddStampAnnotations": func(config *pdf.Configuration) error {
+			return AddStampAnnotations(outFile, fileOut, nil, config)
+		},
+		"AddFreeTextAnnotations": func(config *pdf.Configuration) error {
+			return AddFreeTextAnnotations(outFile, fileOut, nil, config)
+		},
+		"ExportAnnotations": func(config *pdf.Configuration) error {
+			return ExportAnnotations(outFile, jsonFile, nil, config)
+		},
+		"ImportAnnotations": func(config *pdf.Configuration) error {
+			return ImportAnnotations(outFile, jsonFileIn, fileOut, config)
+		},
+		"AddCommentSummary": func(config *pdf.Configuration) error {
+			return AddCommentSummary(outFile, fileOut, nil, config)
+		},
+		"AddMeasureAnnotations": func(config *pdf.Configuration) error {
+			return AddMeasureAnnotations(outFile, fileOut, nil, config)
+		},
+		"AddAssociatedFiles": func(config *pdf.Configuration) error {
+			return AddAssociatedFiles(outFile, fileOut, nil, config)
+		},
+		"RemoveUsageRights": func(config *pdf.Configuration) error {
+			return RemoveUsageRights(outFile, fileOut, config)
+		},
+		"SplitAndEncrypt": func(config *pdf.Configuration) error {
+			_, err := SplitAndEncrypt(outFile, outDir, nil, config)
+			return err
+		},
+		"InsertFrom": func(config *pdf.Configuration) error {
+			return InsertFrom(outFile, outFile, fileOut, 0, nil, config)
+		},
+		"AddWatermarksFunc": func(config *pdf.Configuration) error {
+			return AddWatermarksFunc(outFile, fileOut, nil, func(int, types.Rectangle) (*pdf.Watermark, error) { return nil, nil }, config)
+		},
+		"StampRecipients": func(config *pdf.Configuration) error {
+			_, err := StampRecipients(outFile, outDir, nil, nil, nil, config)
+			return err
+		},
+		"Collect": func(config *pdf.Configuration) error {
+			_, err := Collect(CollectCommand(outFile, fileOut, []string{"1"}, config))
+			return err
+		},
+		"RemovePages": func(config *pdf.Configuration) error {
+			_, err := RemovePages(RemovePagesCommand(outFile, fileOut, []string{"1"}, config))
+			return err
+		},
+		"Crop": func(config *pdf.Configuration) error {
+			c, err := pdf.ParseCropDetails("margin:10")
+			if err != nil {
+				return err
+			}
+			_, err = Crop(CropCommand(outFile, fileOut, nil, c, config))
+			return err
+		},
+		"Resize": func(config *pdf.Configuration) error {
+			r, err := pdf.ParseResizeDetails("paper:A5")
+			if err != nil {
+				return err
+			}
+			_, err = Resize(ResizeCommand(outFile, fileOut, nil, r, config))
+			return err
+		},
+		"Poster": func(config *pdf.Configuration) error {
+			p, err := pdf.ParsePosterDetails("paper:A5")
+			if err != nil {
+				return err
+			}
+			_, err = Poster(PosterCommand(outFile, fileOut, nil, p, config))
+			return err
+		},
+		"RemoveWatermarks": func(config *pdf.Configuration) error {
+			_, err := RemoveWatermarks(RemoveWatermarksCommand(outFile, fileOut, nil, config))
+			return err
+		},
+		"ExtractImages": func(config *pdf.Configuration) error {
+			_, err := ExtractImages(ExtractImagesCommand(outFile, outDir, nil, config))
+			return err
+		},
+		"Trim": func(config *pdf.Configuration) error {
+			_, err := Trim(TrimCommand(outFile, fileOut, nil, config))
+			return err
+		},
+		"AddWatermarks": func(config *pdf.Configuration) error {
+			wm, err := pdf.ParseWatermarkDetails("Draft", false)
+			if err != nil {
+				return err
+			}
+			_, err = AddWatermarks(AddWatermarksCommand(outFile, fileOut, nil, wm, config))
+			return err
+		},
+	} {
+
+		// Using the user password only is refused.
+		config := pdf.NewDefaultConfiguration()
+		config.UserPW = "upw"
+
+		err := f(config)
+		if err == nil || !strings.Contains(err.Error(), "Insufficient access permissions") {
+			t.Fatalf("TestEnforcePermissionsOfCommands - %s using the user password: want permission error, got %v\n", name, err)
+		}
+		if config.Mode != pdf.VALIDATE {
+			t.Fatalf("TestEnforcePermissionsOfCommands - %s changed the configuration passed in\n", name)
+		}
+
+		// The owner password grants access.
+		config = pdf.NewDefaultConfiguration()
+		config.OwnerPW = "opw"
+
+		if err = f(config); err != nil && strings.Contains(err.Error(), "Insufficient access permissions") {
+			t.Fatalf("TestEnforcePermissionsOfCommands - %s using the owner password: %v\n", name, err)
+		}
+	}
+}
+
+func TestAddStampAnnotationsPermissions(t *testing.T) {
+
+	fileName := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	outFile := filepath.Join(outDir, "restrictedAnnotations.pdf")
+	fileOut := filepath.Join(outDir, "restrictedAnnotationsOut.pdf")
+
+	// Adding annotations depends on the annotate permission (bit 6) only.
+	for _, tt := range []struct {
+		name  string
+		perms int16
+		ok    bool
+	}{
+		{"annotate only", pdf.PermissionsNone | 0x0020, true},
+		{"all but annotate", pdf.PermissionsAll &^ 0x0020, false},
+	} {
+
+		config := pdf.NewDefaultConfiguration()
+		config.UserPW = "upw"
+		config.OwnerPW = "opw"
+		config.UserAccessPermissions = tt.perms
+		if _, err := Process(EncryptCommand(fileName, outFile, config)); err != nil {
+			t.Fatalf("TestAddStampAnnotationsPermissions - %s: %v\n", tt.name, err)
+		}
+
+		config = pdf.NewDefaultConfiguration()
+		config.UserPW = "upw"
+
+		err := AddStampAnnotations(outFile, fileOut, nil, config)
+		if denied := err != nil && strings.Contains(err.Error(), "Insufficient access permissions"); denied == tt.ok {
+			t.Fatalf("TestAddStampAnnotationsPermissions - %s: want ok=%t, got %v\n", tt.name, tt.ok, err)
+		}
+	}
+}
+
+func TestAddSignatureFields(t *testing.T) {
+
+	fileName := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	outFile := filepath.Join(outDir, "sigFields.pdf")
+
+	config := pdf.NewDefaultConfiguration()
+
+	fields := []pdf.SignatureField{
+		{Name: "Approver", Page: 1, Rect: types.NewRectangle(50, 50, 250, 100), Label: "Sign here (approver)"},
+		{Name: "Reviewer", Page: 1, Rect: types.NewRectangle(300, 50, 500, 100)},
+	}
+
+	err := AddSignatureFields(fileName, outFile, fields, config)
+	if err != nil {
+		t.Fatalf("TestAddSignatureFields - add signature fields to %s: %v\n", fileName, err)
+	}
+
+	ctx, err := ReadContextFromFile(outFile, config)
+	if err != nil {
+		t.Fatalf("TestAddSignatureFields - read %s: %v\n", outFile, err)
+	}
+
+	err = ValidateContext(ctx)
+	if err != nil {
+		t.Fatalf("TestAddSignatureFields - validate %s: %v\n", outFile, err)
+	}
+
+	rootDict, err := ctx.Catalog()
+	if err != nil {
+		t.Fatalf("TestAddSignatureFields - %s: %v\n", outFile, err)
+	}
+
+	d, err := ctx.DereferenceDict(rootDict["AcroForm"])
+	if err != nil || d == nil {
+		t.Fatalf("TestAddSignatureFields - %s: missing AcroForm %v\n", outFile, err)
+	}
+
+	fieldRefs := d.ArrayEntry("Fields")
+	if len(fieldRefs) != 2 {
+		t.Fatalf("TestAddSignatureFields - %s: want 2 fields, got %d\n", outFile, len(fieldRefs))
+	}
+
+	// Widgets need to refer to their page.
+	pageIndRef, err := ctx.PageIndRef(1)
+	if err != nil {
+		t.Fatalf("TestAddSignatureFields - %s: %v\n", outFile, err)
+	}
+
+	for _, o := range fieldRefs {
+		wd, err := ctx.DereferenceDict(o)
+		if err != nil {
+			t.Fatalf("TestAddSignatureFields - %s: %v\n", outFile, err)
+		}
+		if ir := wd.IndirectRefEntry("P"); ir == nil || !ir.Equals(*pageIndRef) {
+			t.Fatalf("TestAddSignatureFields - %s: want P %v, got %v\n", outFile, pageIndRef, ir)
+		}
+	}
+
+	// Field names need to be unique.
+	err = AddSignatureFields(outFile, outFile, fields[:1], config)
+	if err == nil {
+		t.Fatalf("TestAddSignatureFields - adding a duplicate signature field to %s should fail\n", outFile)
+	}
+}
+
+func TestUsageRights(t *testing.T) {
+
+	fileName := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	outFile := filepath.Join(outDir, "readerExtended.pdf")
+
+	config := pdf.NewDefaultConfiguration()
+
+	// Simulate a Reader extended document.
+	ctx, err := ReadContextFromFile(fileName, config)
+	if err != nil {
+		t.Fatalf("TestUsageRights - read %s: %v\n", fileName, err)
+	}
+
+	rootDict, err := ctx.Catalog()
+	if err != nil {
+		t.Fatalf("TestUsageRights - %s: %v\n", fileName, err)
+	}
+
+	transformParams := pdf.Dict(map[string]pdf.Object{
+		"Type":     pdf.Name("TransformParams"),
+		"Document": pdf.Array{pdf.Name("FullSave")},
+		"Form":     pdf.Array{pdf.Name("FillIn"), pdf.Name("Import")},
+		"V":        pdf.Name("2.2"),
+	})
+
+	sigDict := pdf.Dict(map[string]pdf.Object{
+		"Type":      pdf.Name("Sig"),
+		"Filter":    pdf.Name("Adobe.PPKLite"),
+		"SubFilter": pdf.Name("adbe.pkcs7.detached"),
+		"Reference": pdf.Array{pdf.Dict(map[string]pdf.Object{
+			"Type":            pdf.Name("SigRef"),
+			"TransformMethod": pdf.Name("UR3"),
+			"TransformParams": transformParams,
+		})},
+	})
+
+	rootDict.Insert("Perms", pdf.Dict(map[string]pdf.Object{"UR3": sigDict}))
+
+	ctx.Write.DirName, ctx.Write.FileName = filepath.Split(outFile)
+	err = Write(ctx)
+	if err != nil {
+		t.Fatalf("TestUsageRights - write %s: %v\n", outFile, err)
+	}
+
+	list, err := ListUsageRights(outFile, config)
+	if err != nil {
+		t.Fatalf("TestUsageRights - list usage rights of %s: %v\n", outFile, err)
+	}
+	if len(list) != 1 || !strings.HasPrefix(list[0], "UR3:") || !strings.Contains(list[0], "Form=FillIn,Import") {
+		t.Fatalf("TestUsageRights - %s: unexpected usage rights: %v\n", outFile, list)
+	}
+
+	err = RemoveUsageRights(outFile, outFile, config)
+	if err != nil {
+		t.Fatalf("TestUsageRights - remove usage rights from %s: %v\n", outFile, err)
+	}
+
+	list, err = ListUsageRights(outFile, config)
+	if err != nil {
+		t.Fatalf("TestUsageRights - list usage rights of %s: %v\n", outFile, err)
+	}
+	if len(list) > 0 {
+		t.Fatalf("TestUsageRights - %s: usage rights not removed: %v\n", outFile, list)
+	}
+}
+
+func TestToolFingerprints(t *testing.T) {
+
+	fileName := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	outFile := filepath.Join(outDir, "fingerprints.pdf")
+
+	config := pdf.NewDefaultConfiguration()
+
+	list, err := ToolFingerprints(fileName, config)
+	if err != nil {
+		t.Fatalf("TestToolFingerprints - %s: %v\n", fileName, err)
+	}
+	if len(list) == 0 || !strings.HasPrefix(list[0], "revisions: ") {
+		t.Fatalf("TestToolFingerprints - %s: unexpected report: %v\n", fileName, list)
+	}
+
+	_, err = Process(OptimizeCommand(fileName, outFile, config))
+	if err != nil {
+		t.Fatalf("TestToolFingerprints - optimize %s: %v\n", fileName, err)
+	}
+
+	list, err = ToolFingerprints(outFile, config)
+	if err != nil {
+		t.Fatalf("TestToolFingerprints - %s: %v\n", outFile, err)
+	}
+
+	var found bool
+	for _, s := range list {
+		t.Log(s)
+		if s == "Producer: "+pdf.PDFCPULongVersion {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("TestToolFingerprints - %s: pdfcpu Producer missing: %v\n", outFile, list)
+	}
+
+	// Producer entries of encrypted files need to be decrypted.
+	encFile := filepath.Join(outDir, "fingerprintsEnc.pdf")
+
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "upw"
+	config.OwnerPW = "opw"
+	config.WriteObjectStream = false
+
+	_, err = Process(EncryptCommand(outFile, encFile, config))
+	if err != nil {
+		t.Fatalf("TestToolFingerprints - encrypt %s: %v\n", outFile, err)
+	}
+
+	config = pdf.NewDefaultConfiguration()
+	config.UserPW = "upw"
+	config.OwnerPW = "opw"
+
+	list, err = ToolFingerprints(encFile, config)
+	if err != nil {
+		t.Fatalf("TestToolFingerprints - %s: %v\n", encFile, err)
+	}
+
+	for _, s := range list {
+		if strings.HasPrefix(s, "Producer: ") && s != "Producer: "+pdf.PDFCPULongVersion {
+			t.Fatalf("TestToolFingerprints - %s: unexpected Producer: %v\n", encFile, list)
+		}
+	}
+}
+
+func infoEntry(t *testing.T, fileName, key string) string {
+
+	ctx, err := ReadContextFromFile(fileName, pdf.NewDefaultConfiguration())
+	if err != nil {
+		t.Fatalf("read %s: %v\n", fileName, err)
+	}
+
+	d, err := ctx.DereferenceDict(*ctx.Info)
+	if err != nil {
+		t.Fatalf("%s: %v\n", fileName, err)
+	}
+
+	s, err := ctx.DereferenceText(d[key])
+	if err != nil {
+		t.Fatalf("%s: %v\n", fileName, err)
+	}
+
+	return s
+}
+
+func TestProducerConfiguration(t *testing.T) {
+
+	fileName := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	outFile := filepath.Join(outDir, "producer.pdf")
+	outFile2 := filepath.Join(outDir, "producer2.pdf")
+
+	config := pdf.NewDefaultConfiguration()
+	config.CustomProducer = "ACME Writer 1.0"
+	_, err := Process(OptimizeCommand(fileName, outFile, config))
+	if err != nil {
+		t.Fatalf("TestProducerConfiguration - optimize %s: %v\n", fileName, err)
+	}
+
+	if s := infoEntry(t, outFile, "Producer"); s != config.CustomProducer {
+		t.Fatalf("TestProducerConfiguration - %s: want Producer %q, got %q\n", outFile, config.CustomProducer, s)
+	}
+
+	modDate := infoEntry(t, outFile, "ModDate")
+
+	config = pdf.NewDefaultConfiguration()
+	config.PreserveProducer = true
+	config.SuppressModDate = true
+	config.Now = func() time.Time { return time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC) }
+	_, err = Process(OptimizeCommand(outFile, outFile2, config))
+	if err != nil {
+		t.Fatalf("TestProducerConfiguration - optimize %s: %v\n", outFile, err)
+	}
+
+	if s := infoEntry(t, outFile2, "Producer"); s != "ACME Writer 1.0" {
+		t.Fatalf("TestProducerConfiguration - %s: Producer not preserved, got %q\n", outFile2, s)
+	}
+
+	if s := infoEntry(t, outFile2, "ModDate"); s != modDate {
+		t.Fatalf("TestProducerConfiguration - %s: ModDate modified: %q -> %q\n", outFile2, modDate, s)
+	}
+
+	if s := infoEntry(t, outFile2, "Creator"); s != "FrameMaker 5.5.6" {
+		t.Fatalf("TestProducerConfiguration - %s: Creator modified, got %q\n", outFile2, s)
+	}
+}
+
+func TestTextStrings(t *testing.T) {
+
+	var xRefTable pdf.XRefTable
+
+	// Text strings as written by various producers.
+	for _, tt := range []struct {
+		s    pdf.Object
+		want string
+	}{
+		{pdf.StringLiteral("Caf\\351 \\200 \\222"), "Café • ™"},
+		{pdf.HexLiteral("feff005a00fc00720069006300680020d83dde00"), "Zürich 😀"},
+		{pdf.HexLiteral("fffe5a00fc00"), "Zü"},
+		{pdf.HexLiteral("efbbbf5ac3bc"), "Zü"},
+		{pdf.HexLiteral("a0"), "€"},
+	} {
+		s, err := xRefTable.DereferenceText(tt.s)
+		if err != nil {
+			t.Fatalf("TestTextStrings - %s: %v\n", tt.s, err)
+		}
+		if s != tt.want {
+			t.Fatalf("TestTextStrings - %s: want %q, got %q\n", tt.s, tt.want, s)
+		}
+	}
+
+	if _, err := pdf.HexLiteralToString("feff00"); err == nil {
+		t.Fatal("TestTextStrings: odd length UTF-16BE should fail\n")
+	}
+	if _, err := pdf.HexLiteralToString("feffd83d"); err == nil {
+		t.Fatal("TestTextStrings: truncated surrogate pair should fail\n")
+	}
+
+	fileName := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	formFile := filepath.Join(outDir, "textStrings.pdf")
+	outFile := filepath.Join(outDir, "textStringsFilled.pdf")
+
+	// Anything beyond PDFDocEncoding gets written as UTF-16BE.
+	for _, producer := range []string{"Café – “pdfcpu”", "Zürich 日本"} {
+		config := pdf.NewDefaultConfiguration()
+		config.CustomProducer = producer
+		if _, err := Process(OptimizeCommand(fileName, formFile, config)); err != nil {
+			t.Fatalf("TestTextStrings - optimize %s: %v\n", fileName, err)
+		}
+		if s := infoEntry(t, formFile, "Producer"); s != producer {
+			t.Fatalf("TestTextStrings - %s: want Producer %q, got %q\n", formFile, producer, s)
+		}
+	}
+
+	config := pdf.NewDefaultConfiguration()
+
+	fields := []pdf.FormField{{Type: pdf.FormFieldText, Name: "Straße", Page: 1, Rect: types.NewRectangle(50, 700, 250, 720)}}
+	if err := AddFormFields(fileName, formFile, fields, config); err != nil {
+		t.Fatalf("TestTextStrings: %v\n", err)
+	}
+
+	if err := FillFormFields(formFile, outFile, map[string]string{"Straße": "Jörg Müller"}, config); err != nil {
+		t.Fatalf("TestTextStrings: %v\n", err)
+	}
+
+	// Values not renderable by the standard fonts fail.
+	if err := FillFormFields(formFile, outFile, map[string]string{"Straße": "日本"}, config); err == nil {
+		t.Fatal("TestTextStrings: unrenderable values should fail\n")
+	}
+
+	ctx := readAndValidateFile(t, outFile)
+
+	rootDict, err := ctx.Catalog()
+	if err != nil {
+		t.Fatalf("TestTextStrings: %v\n", err)
+	}
+
+	d, err := ctx.DereferenceDict(rootDict.DictEntry("AcroForm").ArrayEntry("Fields")[0])
+	if err != nil {
+		t.Fatalf("TestTextStrings: %v\n", err)
+	}
+
+	for k, want := range map[string]string{"T": "Straße", "V": "Jörg Müller"} {
+		s, err := ctx.DereferenceText(d[k])
+		if err != nil {
+			t.Fatalf("TestTextStrings: %v\n", err)
+		}
+		if s != want {
+			t.Fatalf("TestTextStrings: want %s %q, got %q\n", k, want, s)
+		}
+	}
+}
+
+func readAndValidateFile(t *testing.T, fileName string) *pdf.Context {
+
+	ctx, err := ReadContextFromFile(fileName, pdf.NewDefaultConfiguration())
+	if err != nil {
+		t.Fatalf("read %s: %v\n", fileName, err)
+	}
+
+	err = ValidateContext(ctx)
+	if err != nil {
+		t.Fatalf("validate %s: %v\n", fileName, err)
+	}
+
+	return ctx
+}
+
+func TestDiffContexts(t *testing.T) {
+
+	fileName := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	outFile := filepath.Join(outDir, "diff.pdf")
+
+	config := pdf.NewDefaultConfiguration()
+	config.SuppressModDate = true
+	config.PreserveProducer = true
+	_, err := Process(OptimizeCommand(fileName, outFile, config))
+	if err != nil {
+		t.Fatalf("TestDiffContexts - optimize %s: %v\n", fileName, err)
+	}
+
+	// Rewriting renumbers objects but does not change the document.
+	a := readAndValidateFile(t, fileName)
+	b := readAndValidateFile(t, outFile)
+
+	diffs, err := pdf.DiffContexts(a, b)
+	if err != nil {
+		t.Fatalf("TestDiffContexts - %v\n", err)
+	}
+	for _, d := range diffs {
+		t.Log(d)
+	}
+	if len(diffs) > 0 {
+		t.Fatalf("TestDiffContexts - %s %s: want no differences, got %d\n", fileName, outFile, len(diffs))
+	}
+
+	// Modify b.
+	d, _, err := b.PageDict(1)
+	if err != nil {
+		t.Fatalf("TestDiffContexts - %v\n", err)
+	}
+	d.Update("Rotate", pdf.Integer(90))
+	d.Delete("Contents")
+
+	diffs, err = pdf.DiffContexts(a, b)
+	if err != nil {
+		t.Fatalf("TestDiffContexts - %v\n", err)
+	}
+
+	var modified, removed bool
+	for _, d := range diffs {
+		t.Log(d)
+		if d.Path == "Page 1/Rotate" && d.B == "90" {
+			modified = true
+		}
+		if strings.HasPrefix(d.Path, "Page 1/Contents") && d.B == "" {
+			removed = true
+		}
+	}
+	if !modified || !removed {
+		t.Fatalf("TestDiffContexts - unexpected differences: %v\n", diffs)
+	}
+}
+
+func TestContextClone(t *testing.T) {
+
+	fileName := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+
+	ctx := readAndValidateFile(t, fileName)
+
+	err := OptimizeContext(ctx)
+	if err != nil {
+		t.Fatalf("TestContextClone - optimize %s: %v\n", fileName, err)
+	}
+
+	// Use one parsed document as base for per recipient watermarks.
+	for _, recipient := range []string{"Alice", "Bob"} {
+
+		ctx1 := ctx.Clone()
+
+		wm, err := pdf.ParseWatermarkDetails("Confidential "+recipient, false)
+		if err != nil {
+			t.Fatalf("TestContextClone - %v\n", err)
+		}
+
+		err = pdf.AddWatermarks(ctx1, pdf.IntSet{1: true}, wm)
+		if err != nil {
+			t.Fatalf("TestContextClone - watermark for %s: %v\n", recipient, err)
+		}
+
+		outFile := filepath.Join(outDir, "clone"+recipient+".pdf")
+		ctx1.Write.DirName, ctx1.Write.FileName = filepath.Split(outFile)
+		err = Write(ctx1)
+		if err != nil {
+			t.Fatalf("TestContextClone - write %s: %v\n", outFile, err)
+		}
+
+		readAndValidateFile(t, outFile)
+	}
+
+	// ctx is unaffected.
+	diffs, err := pdf.DiffContexts(ctx, readAndValidateFile(t, fileName))
+	if err != nil {
+		t.Fatalf("TestContextClone - %v\n", err)
+	}
+	if len(diffs) > 0 {
+		t.Fatalf("TestContextClone - original context modified: %v\n", diffs)
+	}
+}
+
+func TestConcurrentExtraction(t *testing.T) {
+
+	for _, fn := range []string{"go.pdf", "testImage.pdf"} {
+
+		fileName := filepath.Join(inDir, fn)
+
+		ctx := readAndValidateFile(t, fileName)
+
+		err := OptimizeContext(ctx)
+		if err != nil {
+			t.Fatalf("TestConcurrentExtraction - optimize %s: %v\n", fileName, err)
+		}
+
+		want := make([]string, ctx.PageCount)
+		for i := 1; i <= ctx.PageCount; i++ {
+			if want[i-1], err = ExtractTextFromContext(ctx, i); err != nil {
+				t.Fatalf("TestConcurrentExtraction - %s: %v\n", fileName, err)
+			}
+		}
+
+		var wg sync.WaitGroup
+		errs := make(chan error, ctx.PageCount)
+		texts := make([]string, ctx.PageCount)
+
+		// Pages share fonts and images, extract each page in a separate goroutine.
+		for i := 1; i <= ctx.PageCount; i++ {
+
+			wg.Add(1)
+
+			go func(pageNr int) {
+
+				defer wg.Done()
+
+				if _, _, err := ctx.PageDict(pageNr); err != nil {
+					errs <- err
+					return
+				}
+
+				text, err := ExtractTextFromContext(ctx, pageNr)
+				if err != nil {
+					errs <- err
+					return
+				}
+				texts[pageNr-1] = text
+
+				if _, err := ExtractTextSpansFromContext(ctx, pageNr); err != nil {
+					errs <- err
+					return
+				}
+
+				for objNr, v := range ctx.Optimize.PageImages[pageNr-1] {
+					if v {
+						if _, err := pdf.ExtractImageData(ctx, objNr); err != nil {
+							errs <- err
+							return
+						}
+					}
+				}
+
+				for objNr, v := range ctx.Optimize.PageFonts[pageNr-1] {
+					if v {
+						if _, err := pdf.ExtractFontData(ctx, objNr); err != nil {
+							errs <- err
+							return
+						}
+					}
+				}
+
+			}(i)
+		}
+
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			t.Fatalf("TestConcurrentExtraction - %s: %v\n", fileName, err)
+		}
+
+		for i, text := range texts {
+			if text != want[i] {
+				t.Fatalf("TestConcurrentExtraction - %s: page %d: want text %q, got %q\n", fileName, i+1, want[i], text)
+			}
+		}
+
+		// Extraction leaves the image and font registries untouched.
+		for objNr, img := range ctx.Optimize.ImageObjects {
+			if img.ImageDict.Content != nil {
+				t.Fatalf("TestConcurrentExtraction - %s: image obj#%d modified\n", fileName, objNr)
+			}
+		}
+
+		for objNr, fo := range ctx.Optimize.FontObjects {
+			if fo.Data != nil {
+				t.Fatalf("TestConcurrentExtraction - %s: font obj#%d modified\n", fileName, objNr)
+			}
+		}
+	}
+}
+
+func TestStampRecipients(t *testing.T) {
+
+	fileName := filepath.Join(inDir, "go.pdf")
+
+	fields := []pdf.StampField{
+		{Key: "name", X: 20, Y: 40},
+		{Key: "email", X: 20, Y: 28, FontName: "Courier", FontSize: 8},
+		{Key: "serial", X: 20, Y: 16, FontSize: 8},
+	}
+
+	var recipients []pdf.Recipient
+	for i, name := range []string{"Alice", "Bob", "Carol", "Dave"} {
+		recipients = append(recipients, pdf.Recipient{
+			FileName: "recipient" + name + ".pdf",
+			Values: map[string]string{
+				"name":   name + " (Vertrieb Zürich)",
+				"email":  strings.ToLower(name) + "@example.com",
+				"serial": fmt.Sprintf("No. %04d", i+1),
+			},
+		})
+	}
+
+	fileNames, err := StampRecipients(fileName, outDir, []string{"1-2"}, fields, recipients, pdf.NewDefaultConfiguration())
+	if err != nil {
+		t.Fatalf("TestStampRecipients: %v\n", err)
+	}
+
+	if len(fileNames) != len(recipients) {
+		t.Fatalf("TestStampRecipients: want %d files, got %v\n", len(recipients), fileNames)
+	}
+
+	for i, fn := range fileNames {
+
+		ctx := readAndValidateFile(t, fn)
+
+		pageDict, _, err := ctx.PageDict(2)
+		if err != nil {
+			t.Fatalf("TestStampRecipients - %s: %v\n", fn, err)
+		}
+
+		a := pageDict.ArrayEntry("Contents")
+		ir, _ := a[len(a)-1].(pdf.IndirectRef)
+
+		b, err := pdf.ExtractStreamData(ctx, ir.ObjectNumber.Value())
+		if err != nil {
+			t.Fatalf("TestStampRecipients - %s: %v\n", fn, err)
+		}
+
+		want := recipients[i].Values["email"]
+		if !bytes.Contains(b, []byte(want)) {
+			t.Fatalf("TestStampRecipients - %s: missing %s in %s\n", fn, want, b)
+		}
+
+		// Text gets encoded for WinAnsiEncoding.
+		if want := "Z\xfcrich"; !bytes.Contains(b, []byte(want)) {
+			t.Fatalf("TestStampRecipients - %s: missing WinAnsi encoded %q in %q\n", fn, want, b)
+		}
+	}
+
+	// Output file names need to be unique.
+	recipients[1].FileName = recipients[0].FileName
+	if _, err = StampRecipients(fileName, outDir, nil, fields, recipients, pdf.NewDefaultConfiguration()); err == nil {
+		t.Fatal("TestStampRecipients: expected duplicate file name error\n")
+	}
+}
+
+func TestSplitAndEncrypt(t *testing.T) {
+
+	fileName := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	dir := filepath.Join(outDir, "splitEncrypt")
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("TestSplitAndEncrypt: %v\n", err)
+	}
+
+	// Protect even pages using a password per page, leave odd pages alone.
+	passwords := map[string]string{}
+
+	f := func(pageNr int, fileName string) *pdf.EncryptionSettings {
+		if pageNr%2 > 0 {
+			passwords[fileName] = ""
+			return nil
+		}
+		pw := fmt.Sprintf("upw%d", pageNr)
+		passwords[fileName] = pw
+		return &pdf.EncryptionSettings{
+			UserPW:                pw,
+			OwnerPW:               "opw",
+			EncryptUsingAES:       true,
+			EncryptUsing128BitKey: true,
+			UserAccessPermissions: pdf.PermissionsNone,
+		}
+	}
+
+	pf, err := SplitAndEncrypt(fileName, dir, f, pdf.NewDefaultConfiguration())
+	if err != nil {
+		t.Fatalf("TestSplitAndEncrypt: %v\n", err)
+	}
+
+	if len(passwords) < 2 {
+		t.Fatalf("TestSplitAndEncrypt: want at least 2 files, got %d\n", len(passwords))
+	}
+
+	if len(pf) != len(passwords) {
+		t.Fatalf("TestSplitAndEncrypt: want %d files returned, got %d\n", len(passwords), len(pf))
+	}
+
+	for fn, pw := range passwords {
+
+		fn = filepath.Join(dir, fn)
+
+		if pw == "" {
+			ctx := readAndValidateFile(t, fn)
+			if ctx.Encrypt != nil {
+				t.Fatalf("TestSplitAndEncrypt - %s: unexpected encryption\n", fn)
+			}
+			continue
+		}
+
+		config := pdf.NewDefaultConfiguration()
+		config.UserPW = "wrong"
+		if _, err = Process(ValidateCommand(fn, config)); err == nil {
+			t.Fatalf("TestSplitAndEncrypt - %s: expected password error\n", fn)
+		}
+
+		config = pdf.NewDefaultConfiguration()
+		config.UserPW = pw
+		if _, err = Process(ValidateCommand(fn, config)); err != nil {
+			t.Fatalf("TestSplitAndEncrypt - %s: %v\n", fn, err)
+		}
+	}
+}
+
+func TestRecordProvenance(t *testing.T) {
+
+	config := pdf.NewDefaultConfiguration()
+	config.RecordProvenance = true
+
+	// Merge
+	inFiles := []string{filepath.Join(inDir, "go.pdf"), filepath.Join(inDir, "5116.DCT_Filter.pdf")}
+	outFile := filepath.Join(outDir, "provenance.pdf")
+
+	_, err := Process(MergeCommand(inFiles, outFile, config))
+	if err != nil {
+		t.Fatalf("TestRecordProvenance - merge: %v\n", err)
+	}
+
+	n1 := readAndValidateFile(t, inFiles[0]).PageCount
+	n2 := readAndValidateFile(t, inFiles[1]).PageCount
+
+	want := fmt.Sprintf("go.pdf pages 1-%d as 1-%d; 5116.DCT_Filter.pdf pages 1-%d as %d-%d", n1, n1, n2, n1+1, n1+n2)
+	if got := infoEntry(t, outFile, "Provenance"); got != want {
+		t.Fatalf("TestRecordProvenance - merge: want %q, got %q\n", want, got)
+	}
+
+	// Split
+	dir := filepath.Join(outDir, "provenance")
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("TestRecordProvenance: %v\n", err)
+	}
+
+	_, err = Process(SplitCommand(outFile, dir, config))
+	if err != nil {
+		t.Fatalf("TestRecordProvenance - split: %v\n", err)
+	}
+
+	want = "provenance.pdf page 2 as 1"
+	if got := infoEntry(t, filepath.Join(dir, "provenance_2.pdf"), "Provenance"); got != want {
+		t.Fatalf("TestRecordProvenance - split: want %q, got %q\n", want, got)
+	}
+}
+
+// minimalPDFBody returns the objects, xref table and trailer of a single page PDF using eol
+// with object offsets starting at offset.
+func minimalPDFBody(offset int64, eol string) []byte {
+
+	content := "BT /F1 24 Tf 72 720 Td (Hello) Tj ET"
+
+	objs := []string{
+		"<</Type/Catalog/Pages 2 0 R>>",
+		"<</Type/Pages/Kids[3 0 R]/Count 1>>",
+		"<</Type/Page/Parent 2 0 R/MediaBox[0 0 612 792]/Contents 4 0 R" +
+			"/Resources<</Font<</F1 5 0 R>>>>>>",
+		fmt.Sprintf("<</Length %d>>%sstream%s%s%sendstream", len(content), eol, eol, content, eol),
+		"<</Type/Font/Subtype/Type1/BaseFont/Helvetica>>",
+	}
+
+	var b bytes.Buffer
+	var offsets []int64
+
+	for i, o := range objs {
+		offsets = append(offsets, offset+int64(b.Len()))
+		fmt.Fprintf(&b, "%d 0 obj%s%s%sendobj%s", i+1, eol, o, eol, eol)
+	}
+
+	xref := offset + int64(b.Len())
+
+	// Xref entries are 20 bytes long.
+	entryEol := eol
+	if len(eol) == 1 {
+		entryEol = " " + eol
+	}
+
+	fmt.Fprintf(&b, "xref%s0 %d%s0000000000 65535 f%s", eol, len(objs)+1, eol, entryEol)
+	for _, off := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n%s", off, entryEol)
+	}
+	fmt.Fprintf(&b, "trailer%s<</Size %d/Root 1 0 R>>%sstartxref%s%d%s%%%%EOF%s", eol, len(objs)+1, eol, eol, xref, eol, eol)
+
+	return b.Bytes()
+}
+
+// writeSparsePDF writes a single page PDF whose objects start at offset.
+// The gap after the header does not occupy any disk space on file systems supporting sparse files.
+func writeSparsePDF(fileName string, offset int64) error {
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err = f.WriteString("%PDF-1.4\n"); err != nil {
+		return err
+	}
+
+	_, err = f.WriteAt(minimalPDFBody(offset, "\n"), offset)
+
+	return err
+}
+
+func TestLargeFileOffsets(t *testing.T) {
+
+	if testing.Short() {
+		t.Skip("skipping 3GB sparse file test in short mode")
+	}
+
+	fileName := filepath.Join(outDir, "large.pdf")
+
+	err := writeSparsePDF(fileName, 3<<30)
+	if err != nil {
+		t.Fatalf("TestLargeFileOffsets: %v\n", err)
+	}
+	defer os.Remove(fileName)
+
+	ctx := readAndValidateFile(t, fileName)
+
+	if ctx.PageCount != 1 {
+		t.Fatalf("TestLargeFileOffsets: want 1 page, got %d\n", ctx.PageCount)
+	}
+
+	b, err := pdf.ExtractStreamData(ctx, 4)
+	if err != nil {
+		t.Fatalf("TestLargeFileOffsets: %v\n", err)
+	}
+
+	if !bytes.Contains(b, []byte("Hello")) {
+		t.Fatalf("TestLargeFileOffsets: corrupt content stream: %s\n", b)
+	}
+
+	outFile := filepath.Join(outDir, "largeOptimized.pdf")
+	_, err = Process(OptimizeCommand(fileName, outFile, pdf.NewDefaultConfiguration()))
+	if err != nil {
+		t.Fatalf("TestLargeFileOffsets: %v\n", err)
+	}
+
+	readAndValidateFile(t, outFile)
+}
+
+func TestLeadingJunk(t *testing.T) {
+
+	bom := "\xEF\xBB\xBF"
+
+	for _, tt := range []struct {
+		name     string
+		junk     string
+		eol      string
+		relative bool // offsets relative to the header.
+	}{
+		{"bom", bom, "\n", false},
+		{"bomRelative", bom, "\n", true},
+		{"junkCR", "Content-Type: application/pdf\r\r", "\r", false},
+		{"junkCRRelative", "Content-Type: application/pdf\r\r", "\r", true},
+	} {
+
+		header := "%PDF-1.4" + tt.eol
+
+		offset := int64(len(header))
+		if !tt.relative {
+			offset += int64(len(tt.junk))
+		}
+
+		fileName := filepath.Join(outDir, "leadingJunk"+tt.name+".pdf")
+
+		b := append([]byte(tt.junk+header), minimalPDFBody(offset, tt.eol)...)
+		if err := ioutil.WriteFile(fileName, b, 0644); err != nil {
+			t.Fatalf("TestLeadingJunk: %v\n", err)
+		}
+
+		ctx := readAndValidateFile(t, fileName)
+
+		sd, err := ctx.DereferenceStreamDict(*pdf.NewIndirectRef(4, 0))
+		if err != nil || sd == nil {
+			t.Fatalf("TestLeadingJunk - %s: missing content stream: %v\n", tt.name, err)
+		}
+
+		if !bytes.Contains(sd.Raw, []byte("Hello")) {
+			t.Fatalf("TestLeadingJunk - %s: corrupt content stream: %s\n", tt.name, sd.Raw)
+		}
+
+		// Strict mode insists on a header at the beginning of the file.
+		config := pdf.NewDefaultConfiguration()
+		config.ValidationMode = pdf.ValidationStrict
+		if _, err = ReadContextFromFile(fileName, config); err == nil {
+			t.Fatalf("TestLeadingJunk - %s: strict mode should fail\n", tt.name)
+		}
+	}
+}
+
+func TestReadContextAt(t *testing.T) {
+
+	// A PDF file embedded in some container.
+	prefix := []byte("From: someone@example.com\r\nContent-Type: application/pdf\r\n\r\n")
+	pdfFile := append([]byte("%PDF-1.4\n"), minimalPDFBody(9, "\n")...)
+	suffix := []byte("\r\n--boundary--\r\n")
+
+	b := append(append(append([]byte{}, prefix...), pdfFile...), suffix...)
+
+	ctx, err := ReadContextAt(bytes.NewReader(b), int64(len(prefix)), int64(len(pdfFile)), pdf.NewDefaultConfiguration())
+	if err != nil {
+		t.Fatalf("TestReadContextAt: %v\n", err)
+	}
+
+	err = ValidateContext(ctx)
+	if err != nil {
+		t.Fatalf("TestReadContextAt: %v\n", err)
+	}
+
+	if ctx.PageCount != 1 {
+		t.Fatalf("TestReadContextAt: want 1 page, got %d\n", ctx.PageCount)
+	}
+
+	var buf bytes.Buffer
+	err = WriteContext(ctx, &buf)
+	if err != nil {
+		t.Fatalf("TestReadContextAt: %v\n", err)
+	}
+
+	ctx, err = ReadContext(bytes.NewReader(buf.Bytes()), "", int64(buf.Len()), pdf.NewDefaultConfiguration())
+	if err != nil {
+		t.Fatalf("TestReadContextAt: %v\n", err)
+	}
+
+	err = ValidateContext(ctx)
+	if err != nil {
+		t.Fatalf("TestReadContextAt: %v\n", err)
+	}
+
+	// The section needs to hold the whole PDF file.
+	_, err = ReadContextAt(bytes.NewReader(b), int64(len(prefix)), int64(len(pdfFile)/2), pdf.NewDefaultConfiguration())
+	if err == nil {
+		t.Fatal("TestReadContextAt: truncated section should fail\n")
+	}
+}
+
+func TestHybridXRef(t *testing.T) {
+
+	fileName := filepath.Join(inDir, "CenterOfWhy.pdf")
+
+	ctx, err := ReadContextFromFile(fileName, pdf.NewDefaultConfiguration())
+	if err != nil {
+		t.Fatalf("TestHybridXRef: %v\n", err)
+	}
+
+	err = ValidateContext(ctx)
+	if err != nil {
+		t.Fatalf("TestHybridXRef: %v\n", err)
+	}
+
+	ctx.WriteHybridXRef = true
+
+	var buf bytes.Buffer
+	err = WriteContext(ctx, &buf)
+	if err != nil {
+		t.Fatalf("TestHybridXRef: %v\n", err)
+	}
+
+	if !bytes.Contains(buf.Bytes(), []byte("/XRefStm")) {
+		t.Fatal("TestHybridXRef: missing XRefStm\n")
+	}
+
+	ctxHybrid, err := ReadContext(bytes.NewReader(buf.Bytes()), "", int64(buf.Len()), pdf.NewDefaultConfiguration())
+	if err != nil {
+		t.Fatalf("TestHybridXRef: %v\n", err)
+	}
+
+	// Objects hidden in object streams need to take precedence over their free xref table entries.
+	err = ValidateContext(ctxHybrid)
+	if err != nil {
+		t.Fatalf("TestHybridXRef: %v\n", err)
+	}
+
+	if !ctxHybrid.Read.Hybrid {
+		t.Fatal("TestHybridXRef: want hybrid file\n")
+	}
+
+	ctx, err = ReadContextFromFile(fileName, pdf.NewDefaultConfiguration())
+	if err != nil {
+		t.Fatalf("TestHybridXRef: %v\n", err)
+	}
+
+	err = ValidateContext(ctx)
+	if err != nil {
+		t.Fatalf("TestHybridXRef: %v\n", err)
+	}
+
+	diffs, err := pdf.DiffContexts(ctx, ctxHybrid)
+	if err != nil {
+		t.Fatalf("TestHybridXRef: %v\n", err)
+	}
+
+	// Writing updates the document information dict.
+	for _, d := range diffs {
+		if !strings.HasPrefix(d.Path, "Info/") {
+			t.Errorf("TestHybridXRef: %s\n", d)
+		}
+	}
+}
+
+func TestObjectStreamPacking(t *testing.T) {
+
+	config := pdf.NewDefaultConfiguration()
+	config.MaxObjectsPerObjectStream = 5
+	config.ObjectStreamExcludedTypes = []string{"Page"}
+
+	ctx, err := ReadContextFromFile(filepath.Join(inDir, "CenterOfWhy.pdf"), config)
+	if err != nil {
+		t.Fatalf("TestObjectStreamPacking: %v\n", err)
+	}
+
+	err = ValidateContext(ctx)
+	if err != nil {
+		t.Fatalf("TestObjectStreamPacking: %v\n", err)
+	}
+
+	var buf bytes.Buffer
+	err = WriteContext(ctx, &buf)
+	if err != nil {
+		t.Fatalf("TestObjectStreamPacking: %v\n", err)
+	}
+
+	w := ctx.Write
+	if w.ObjectStreams == 0 || w.ObjectStreamObjects > 5*w.ObjectStreams {
+		t.Fatalf("TestObjectStreamPacking: %d objects in %d object streams\n", w.ObjectStreamObjects, w.ObjectStreams)
+	}
+
+	if w.ObjectStreamEncodedSize == 0 || w.ObjectStreamSize == 0 {
+		t.Fatalf("TestObjectStreamPacking: missing packed sizes\n")
+	}
+
+	ctx, err = ReadContext(bytes.NewReader(buf.Bytes()), "", int64(buf.Len()), pdf.NewDefaultConfiguration())
+	if err != nil {
+		t.Fatalf("TestObjectStreamPacking: %v\n", err)
+	}
+
+	err = ValidateContext(ctx)
+	if err != nil {
+		t.Fatalf("TestObjectStreamPacking: %v\n", err)
+	}
+
+	pages := 0
+	for objNr, e := range ctx.Table {
+		d, ok := e.Object.(pdf.Dict)
+		if !ok || d.Type() == nil || *d.Type() != "Page" {
+			continue
+		}
+		if e.ObjectStream != nil {
+			t.Errorf("TestObjectStreamPacking: page dict obj#%d compressed\n", objNr)
+		}
+		pages++
+	}
+
+	if pages != ctx.PageCount {
+		t.Fatalf("TestObjectStreamPacking: want %d page dicts, got %d\n", ctx.PageCount, pages)
+	}
+}
+
+func TestHooks(t *testing.T) {
+
+	fileName := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	outFile := filepath.Join(outDir, "hooks.pdf")
+
+	var objsRead, pagesProcessed int
+
+	config := pdf.NewDefaultConfiguration()
+
+	config.Hooks.OnObjectRead = func(objNr, genNr int, o pdf.Object) (pdf.Object, error) {
+		objsRead++
+		if d, ok := o.(pdf.Dict); ok && d["Creator"] != nil {
+			d.Update("Creator", pdf.StringLiteral("scrubbed"))
+		}
+		return o, nil
+	}
+
+	config.Hooks.OnPageProcessed = func(ctx *pdf.Context, pageNr int, pageDict pdf.Dict) error {
+		pagesProcessed++
+		pageDict.Update("Rotate", pdf.Integer(90))
+		return nil
+	}
+
+	config.Hooks.OnBeforeWriteObject = func(objNr, genNr int, o pdf.Object) (pdf.Object, error) {
+		if d, ok := o.(pdf.Dict); ok && d["Producer"] != nil {
+			d.Update("Producer", pdf.StringLiteral("scrubbed"))
+		}
+		return o, nil
+	}
+
+	_, err := Process(OptimizeCommand(fileName, outFile, config))
+	if err != nil {
+		t.Fatalf("TestHooks: %v\n", err)
+	}
+
+	if objsRead == 0 {
+		t.Fatal("TestHooks: OnObjectRead not called\n")
+	}
+
+	for _, k := range []string{"Creator", "Producer"} {
+		if s := infoEntry(t, outFile, k); s != "scrubbed" {
+			t.Fatalf("TestHooks: want %s scrubbed, got %q\n", k, s)
+		}
+	}
+
+	ctx := readAndValidateFile(t, outFile)
+
+	if pagesProcessed != ctx.PageCount {
+		t.Fatalf("TestHooks: want %d pages processed, got %d\n", ctx.PageCount, pagesProcessed)
+	}
+
+	for i := 1; i <= ctx.PageCount; i++ {
+		d, _, err := ctx.PageDict(i)
+		if err != nil {
+			t.Fatalf("TestHooks: %v\n", err)
+		}
+		if r := d.IntEntry("Rotate"); r == nil || *r != 90 {
+			t.Fatalf("TestHooks: page %d not rotated\n", i)
+		}
+	}
+}
+
+func TestFileSystem(t *testing.T) {
+
+	bb, err := ioutil.ReadFile(filepath.Join(inDir, "Acroforms2.pdf"))
+	if err != nil {
+		t.Fatalf("TestFileSystem: %v\n", err)
+	}
+
+	fsys := pdf.NewMemFileSystem()
+	if err = pdf.WriteFile(fsys, "in/Acroforms2.pdf", bb, 0644); err != nil {
+		t.Fatalf("TestFileSystem: %v\n", err)
+	}
+
+	config := pdf.NewDefaultConfiguration()
+	config.FS = fsys
+	config.SplitSkipExisting = true
+
+	if _, err = Process(OptimizeCommand("in/Acroforms2.pdf", "out/Acroforms2.pdf", config)); err != nil {
+		t.Fatalf("TestFileSystem: %v\n", err)
+	}
+
+	out, err := Process(SplitCommand("out/Acroforms2.pdf", "split", config))
+	if err != nil {
+		t.Fatalf("TestFileSystem: %v\n", err)
+	}
+
+	// Resuming skips the files recorded in the manifest.
+	out2, err := Process(SplitCommand("out/Acroforms2.pdf", "split", config))
+	if err != nil {
+		t.Fatalf("TestFileSystem: %v\n", err)
+	}
+	if len(out2) != len(out) {
+		t.Fatalf("TestFileSystem: want %d files, got %d\n", len(out), len(out2))
+	}
+
+	want := append([]string{"in/Acroforms2.pdf", "out/Acroforms2.pdf", filepath.Join("split", SplitManifestFile)}, out...)
+	sort.Strings(want)
+
+	if got := fsys.Names(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("TestFileSystem: want files %v, got %v\n", want, got)
+	}
+
+	for _, fn := range out {
+		if _, err = os.Stat(fn); !os.IsNotExist(err) {
+			t.Fatalf("TestFileSystem: %s written to local file system\n", fn)
+		}
+		ctx, err := ReadContextFromFile(fn, config)
+		if err != nil {
+			t.Fatalf("TestFileSystem: %s: %v\n", fn, err)
+		}
+		if err = ValidateContext(ctx); err != nil {
+			t.Fatalf("TestFileSystem: %s: %v\n", fn, err)
+		}
+		if ctx.PageCount != 1 {
+			t.Fatalf("TestFileSystem: %s: want 1 page, got %d\n", fn, ctx.PageCount)
+		}
+	}
+
+	if _, err = ReadContextFromFile("in/missing.pdf", config); err == nil {
+		t.Fatal("TestFileSystem: missing file read\n")
+	}
+}
+
+func TestValidationRules(t *testing.T) {
+
+	fileName := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+
+	validate.RegisterRule("output-intent", func(xRefTable *pdf.XRefTable) []validate.Violation {
+		if _, found := xRefTable.RootDict.Find("OutputIntents"); !found {
+			return []validate.Violation{{Message: "missing OutputIntents"}}
+		}
+		return nil
+	})
+	defer validate.UnregisterRule("output-intent")
+
+	validate.RegisterRule("pages", func(xRefTable *pdf.XRefTable) []validate.Violation {
+		if xRefTable.PageCount == 0 {
+			return []validate.Violation{{Message: "no pages"}}
+		}
+		return nil
+	})
+	defer validate.UnregisterRule("pages")
+
+	ctx, err := ReadContextFromFile(fileName, pdf.NewDefaultConfiguration())
+	if err != nil {
+		t.Fatalf("TestValidationRules: %v\n", err)
+	}
+
+	err = ValidateContext(ctx)
+
+	e, ok := err.(*validate.ViolationsError)
+	if !ok {
+		t.Fatalf("TestValidationRules: want violations, got %v\n", err)
+	}
+
+	if len(e.Violations) != 1 || e.Violations[0].RuleID != "output-intent" {
+		t.Fatalf("TestValidationRules: unexpected violations: %v\n", e.Violations)
+	}
+
+	// Suppressed rules do not block.
+	config := pdf.NewDefaultConfiguration()
+	config.SuppressedRules = []string{"output-intent"}
+
+	ctx, err = ReadContextFromFile(fileName, config)
+	if err != nil {
+		t.Fatalf("TestValidationRules: %v\n", err)
+	}
+
+	err = ValidateContext(ctx)
+	if err != nil {
+		t.Fatalf("TestValidationRules: %v\n", err)
+	}
+
+	// Neither do warnings.
+	validate.RegisterRule("output-intent", func(xRefTable *pdf.XRefTable) []validate.Violation {
+		return []validate.Violation{{Severity: validate.SeverityWarning, Message: "missing OutputIntents"}}
+	})
+
+	ctx, err = ReadContextFromFile(fileName, pdf.NewDefaultConfiguration())
+	if err != nil {
+		t.Fatalf("TestValidationRules: %v\n", err)
+	}
+
+	findings, err := validate.XRefTableFindings(ctx.XRefTable)
+	if err != nil {
+		t.Fatalf("TestValidationRules: %v\n", err)
+	}
+
+	if len(findings) != 1 || findings[0].String() != "warning output-intent: missing OutputIntents" {
+		t.Fatalf("TestValidationRules: unexpected findings: %v\n", findings)
+	}
+
+	// Built-in checks may be suppressed or lowered as well.
+	validate.UnregisterRule("output-intent")
+
+	badPageLayout := func(config *pdf.Configuration) *pdf.Context {
+		ctx, err := ReadContextFromFile(fileName, config)
+		if err != nil {
+			t.Fatalf("TestValidationRules: %v\n", err)
+		}
+		ctx.RootDict["PageLayout"] = pdf.Integer(1)
+		return ctx
+	}
+
+	if err = ValidateContext(badPageLayout(pdf.NewDefaultConfiguration())); err == nil {
+		t.Fatal("TestValidationRules: invalid PageLayout should fail\n")
+	}
+
+	config = pdf.NewDefaultConfiguration()
+	config.SuppressedRules = []string{"catalog.PageLayout"}
+	if err = ValidateContext(badPageLayout(config)); err != nil {
+		t.Fatalf("TestValidationRules: %v\n", err)
+	}
+
+	validate.SetRuleSeverity("catalog.PageLayout", validate.SeverityInfo)
+	defer validate.ResetRuleSeverity("catalog.PageLayout")
+
+	findings, err = validate.XRefTableFindings(badPageLayout(pdf.NewDefaultConfiguration()).XRefTable)
+	if err != nil {
+		t.Fatalf("TestValidationRules: %v\n", err)
+	}
+
+	if len(findings) != 1 || findings[0].RuleID != "catalog.PageLayout" || findings[0].Severity != validate.SeverityInfo {
+		t.Fatalf("TestValidationRules: unexpected findings: %v\n", findings)
+	}
+}
+
+func TestPageCountAndVersion(t *testing.T) {
+
+	for _, fn := range []string{"CenterOfWhy.pdf", "Hybrid-PDF.pdf", "5116.DCT_Filter.pdf", "go.pdf"} {
+
+		fileName := filepath.Join(inDir, fn)
+
+		ctx := readAndValidateFile(t, fileName)
+
+		f, err := os.Open(fileName)
+		if err != nil {
+			t.Fatalf("TestPageCountAndVersion: %v\n", err)
+		}
+
+		n, err := PageCount(f)
+		if err != nil {
+			t.Fatalf("TestPageCountAndVersion %s: %v\n", fn, err)
+		}
+
+		if n != ctx.PageCount {
+			t.Errorf("TestPageCountAndVersion %s: want %d pages, got %d\n", fn, ctx.PageCount, n)
+		}
+
+		v, err := PDFVersion(f)
+		if err != nil {
+			t.Fatalf("TestPageCountAndVersion %s: %v\n", fn, err)
+		}
+
+		if v != ctx.Version() {
+			t.Errorf("TestPageCountAndVersion %s: want version %s, got %s\n", fn, ctx.Version(), v)
+		}
+
+		f.Close()
+	}
+
+	_, err := PageCount(bytes.NewReader([]byte("%PDF-1.4\ngarbage")))
 	if err == nil {
-		t.Fatalf("TestEncryptUPWOnly - %s decrypt using wrong opw should fail\n", outFile)
+		t.Fatal("TestPageCountAndVersion: corrupt file should fail\n")
+	}
+}
+
+func TestProbe(t *testing.T) {
+
+	for _, fn := range []string{"CenterOfWhy.pdf", "Hybrid-PDF.pdf", "go.pdf"} {
+		f, err := os.Open(filepath.Join(inDir, fn))
+		if err != nil {
+			t.Fatalf("TestProbe: %v\n", err)
+		}
+		if err = Probe(f); err != nil {
+			t.Errorf("TestProbe %s: %v\n", fn, err)
+		}
+		f.Close()
+	}
+
+	ok := append([]byte("%PDF-1.4\n"), minimalPDFBody(9, "\n")...)
+
+	if err := Probe(bytes.NewReader(ok)); err != nil {
+		t.Fatalf("TestProbe: %v\n", err)
+	}
+
+	xref := bytes.LastIndex(ok, []byte("startxref"))
+
+	for _, tt := range []struct {
+		name string
+		b    []byte
+	}{
+		{"empty", nil},
+		{"no header", ok[9:]},
+		{"truncated", ok[:len(ok)-10]},
+		{"bad startxref", append(append([]byte{}, ok[:xref]...), []byte("startxref\n42\n%%EOF\n")...)},
+		{"no trailer", bytes.Replace(ok, []byte("trailer"), []byte("xxxxxxx"), 1)},
+	} {
+		if err := Probe(bytes.NewReader(tt.b)); err == nil {
+			t.Errorf("TestProbe: %s should fail\n", tt.name)
+		}
+	}
+}
+
+func TestExtractCFFFontsAsOTF(t *testing.T) {
+
+	fileName := filepath.Join(inDir, "TheGoProgrammingLanguageCh1.pdf")
+
+	ctx := readAndValidateFile(t, fileName)
+
+	if err := OptimizeContext(ctx); err != nil {
+		t.Fatalf("TestExtractCFFFontsAsOTF: %v\n", err)
+	}
+
+	u16 := func(b []byte) int { return int(b[0])<<8 | int(b[1]) }
+	u32 := func(b []byte) uint32 { return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3]) }
+
+	checksum := func(b []byte) (sum uint32) {
+		for len(b)%4 > 0 {
+			b = append(b[:len(b):len(b)], 0)
+		}
+		for i := 0; i < len(b); i += 4 {
+			sum += u32(b[i:])
+		}
+		return sum
+	}
+
+	var found, mapped int
+
+	for objNr := range ctx.Optimize.FontObjects {
+
+		fo, err := pdf.ExtractFontData(ctx, objNr)
+		if err != nil {
+			t.Fatalf("TestExtractCFFFontsAsOTF: obj#%d: %v\n", objNr, err)
+		}
+		if fo == nil || fo.Extension != "otf" {
+			continue
+		}
+
+		found++
+		b := fo.Data
+
+		if string(b[:4]) != "OTTO" {
+			t.Fatalf("TestExtractCFFFontsAsOTF: obj#%d: missing OTTO signature\n", objNr)
+		}
+
+		// The whole font checksums to the magic value once head.checkSumAdjustment is in place.
+		if sum := checksum(b); sum != 0xB1B0AFBA {
+			t.Fatalf("TestExtractCFFFontsAsOTF: obj#%d: font checksum %x\n", objNr, sum)
+		}
+
+		tables := map[string][]byte{}
+		for i, n := 0, u16(b[4:]); i < n; i++ {
+			rec := b[12+16*i:]
+			tag, off, length := string(rec[:4]), u32(rec[8:]), u32(rec[12:])
+			if int(off+length) > len(b) {
+				t.Fatalf("TestExtractCFFFontsAsOTF: obj#%d: table %s out of bounds\n", objNr, tag)
+			}
+			tables[tag] = b[off : off+length]
+			if tag != "head" && checksum(tables[tag]) != u32(rec[4:]) {
+				t.Fatalf("TestExtractCFFFontsAsOTF: obj#%d: bad checksum for table %s\n", objNr, tag)
+			}
+		}
+
+		for _, tag := range []string{"CFF ", "OS/2", "cmap", "head", "hhea", "hmtx", "maxp", "name", "post"} {
+			if tables[tag] == nil {
+				t.Fatalf("TestExtractCFFFontsAsOTF: obj#%d: missing table %s\n", objNr, tag)
+			}
+		}
+
+		numGlyphs := u16(tables["maxp"][4:])
+		if numGlyphs == 0 || u16(tables["hhea"][34:]) != numGlyphs || len(tables["hmtx"]) != 4*numGlyphs {
+			t.Fatalf("TestExtractCFFFontsAsOTF: obj#%d: inconsistent glyph count %d\n", objNr, numGlyphs)
+		}
+
+		// Look up 'e' in the format 4 subtable.
+		sub := tables["cmap"][u32(tables["cmap"][8:]):]
+		segCount := u16(sub[6:]) / 2
+		for i := 0; i < segCount; i++ {
+			end, start := u16(sub[14+2*i:]), u16(sub[16+2*segCount+2*i:])
+			if start <= 'e' && 'e' <= end {
+				delta := u16(sub[16+4*segCount+2*i:])
+				if gid := ('e' + delta) & 0xFFFF; gid > 0 && gid < numGlyphs {
+					mapped++
+				}
+				break
+			}
+		}
+	}
+
+	if found == 0 {
+		t.Fatalf("TestExtractCFFFontsAsOTF: no CFF fonts extracted from %s\n", fileName)
+	}
+
+	if mapped == 0 {
+		t.Fatalf("TestExtractCFFFontsAsOTF: no font maps Unicode to glyphs\n")
+	}
+}
+
+func rebalancePageTree(t *testing.T, ctx *pdf.Context, fanOut int) {
+
+	pageCount := ctx.PageCount
+
+	digests, err := pageDigests(ctx, pdf.IntSet{})
+	if err != nil {
+		t.Fatalf("TestRebalancePageTree: %v\n", err)
+	}
+
+	// The number of objects per page covers the inherited page attributes.
+	pageObjs := make([]int, pageCount)
+	for i := range pageObjs {
+		m, err := ctx.PageObjects(i + 1)
+		if err != nil {
+			t.Fatalf("TestRebalancePageTree: %v\n", err)
+		}
+		pageObjs[i] = len(m)
+	}
+
+	if vs := validate.CheckPageTree(ctx.XRefTable); len(vs) > 0 {
+		t.Fatalf("TestRebalancePageTree: unexpected violations: %v\n", vs)
+	}
+
+	// Break the page count.
+	root, err := ctx.Pages()
+	if err != nil {
+		t.Fatalf("TestRebalancePageTree: %v\n", err)
+	}
+
+	rootDict, err := ctx.DereferenceDict(*root)
+	if err != nil {
+		t.Fatalf("TestRebalancePageTree: %v\n", err)
+	}
+
+	rootDict.Update("Count", pdf.Integer(pageCount+1))
+
+	vs := validate.CheckPageTree(ctx.XRefTable)
+	if len(vs) != 1 || vs[0].Severity != validate.SeverityWarning {
+		t.Fatalf("TestRebalancePageTree: want 1 warning, got %v\n", vs)
+	}
+
+	// Writing with a fan-out repairs the page tree.
+	ctx.PageTreeFanOut = fanOut
+
+	var buf bytes.Buffer
+	if err = WriteContext(ctx, &buf); err != nil {
+		t.Fatalf("TestRebalancePageTree: %v\n", err)
+	}
+
+	ctx, err = ReadContext(bytes.NewReader(buf.Bytes()), "", int64(buf.Len()), pdf.NewDefaultConfiguration())
+	if err != nil {
+		t.Fatalf("TestRebalancePageTree: %v\n", err)
+	}
+
+	if err = ValidateContext(ctx); err != nil {
+		t.Fatalf("TestRebalancePageTree: %v\n", err)
+	}
+
+	if ctx.PageCount != pageCount {
+		t.Fatalf("TestRebalancePageTree: want %d pages, got %d\n", pageCount, ctx.PageCount)
+	}
+
+	if vs := validate.CheckPageTree(ctx.XRefTable); len(vs) > 0 {
+		t.Fatalf("TestRebalancePageTree: unexpected violations: %v\n", vs)
+	}
+
+	digests1, err := pageDigests(ctx, pdf.IntSet{})
+	if err != nil {
+		t.Fatalf("TestRebalancePageTree: %v\n", err)
+	}
+
+	for i := range digests {
+		if !bytes.Equal(digests[i], digests1[i]) {
+			t.Fatalf("TestRebalancePageTree: page %d content changed\n", i+1)
+		}
+		m, err := ctx.PageObjects(i + 1)
+		if err != nil {
+			t.Fatalf("TestRebalancePageTree: %v\n", err)
+		}
+		if len(m) != pageObjs[i] {
+			t.Fatalf("TestRebalancePageTree: page %d: want %d objects, got %d\n", i+1, pageObjs[i], len(m))
+		}
+	}
+
+	for objNr, e := range ctx.Table {
+		d, ok := e.Object.(pdf.Dict)
+		if !ok || d.Type() == nil || *d.Type() != "Pages" {
+			continue
+		}
+		if n := len(d.ArrayEntry("Kids")); n > fanOut {
+			t.Fatalf("TestRebalancePageTree: page tree node obj#%d has %d kids\n", objNr, n)
+		}
+	}
+}
+
+func TestRebalancePageTree(t *testing.T) {
+
+	for _, fn := range []string{"CenterOfWhy.pdf", "ECSTR11-01.pdf"} {
+		rebalancePageTree(t, readAndValidateFile(t, filepath.Join(inDir, fn)), 3)
+	}
+
+	// Let the last page of OptimizeTest.pdf inherit its resources from an intermediate page tree node.
+	ctx := readAndValidateFile(t, filepath.Join(inDir, "OptimizeTest.pdf"))
+
+	pageDict, _, err := ctx.PageDict(ctx.PageCount)
+	if err != nil {
+		t.Fatalf("TestRebalancePageTree: %v\n", err)
+	}
+
+	parent, err := ctx.DereferenceDict(*pageDict.IndirectRefEntry("Parent"))
+	if err != nil {
+		t.Fatalf("TestRebalancePageTree: %v\n", err)
+	}
+
+	parent.Update("Resources", pageDict.Delete("Resources"))
+
+	rebalancePageTree(t, ctx, 3)
+}
+
+func TestInheritedPageAttrs(t *testing.T) {
+
+	// Let the last page of OptimizeTest.pdf inherit its resources and media box from an intermediate page tree node.
+	ctx := readAndValidateFile(t, filepath.Join(inDir, "OptimizeTest.pdf"))
+
+	pageNr := ctx.PageCount
+
+	pageDict, _, err := ctx.PageDict(pageNr)
+	if err != nil {
+		t.Fatalf("TestInheritedPageAttrs: %v\n", err)
+	}
+
+	parent, err := ctx.DereferenceDict(*pageDict.IndirectRefEntry("Parent"))
+	if err != nil {
+		t.Fatalf("TestInheritedPageAttrs: %v\n", err)
+	}
+
+	parent.Update("Resources", pageDict.Delete("Resources"))
+	parent.Update("MediaBox", pdf.Array{pdf.Integer(0), pdf.Integer(0), pdf.Integer(300), pdf.Integer(400)})
+	pageDict.Delete("MediaBox")
+	parent.Update("Rotate", pdf.Integer(90))
+
+	attrs, err := InheritedPageAttrs(ctx, pageNr)
+	if err != nil {
+		t.Fatalf("TestInheritedPageAttrs: %v\n", err)
+	}
+
+	if attrs.Resources() == nil {
+		t.Fatal("TestInheritedPageAttrs: missing inherited resources\n")
+	}
+
+	if mb := attrs.MediaBox(); len(mb) != 4 || mb[3] != pdf.Integer(400) {
+		t.Fatalf("TestInheritedPageAttrs: unexpected media box %v\n", mb)
+	}
+
+	if cb := attrs.CropBox(); len(cb) != 4 || cb[2] != pdf.Integer(300) {
+		t.Fatalf("TestInheritedPageAttrs: crop box should default to media box, got %v\n", cb)
+	}
+
+	if attrs.Rotate() != 90 {
+		t.Fatalf("TestInheritedPageAttrs: want rotate 90, got %d\n", attrs.Rotate())
+	}
+
+	if _, err = InheritedPageAttrs(ctx, pageNr+1); err == nil {
+		t.Fatal("TestInheritedPageAttrs: invalid page number should fail\n")
+	}
+
+	inFile := filepath.Join(outDir, "inherited.pdf")
+
+	var buf bytes.Buffer
+	if err = WriteContext(ctx, &buf); err != nil {
+		t.Fatalf("TestInheritedPageAttrs: %v\n", err)
+	}
+	if err = ioutil.WriteFile(inFile, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("TestInheritedPageAttrs: %v\n", err)
+	}
+
+	// Single page files get self-contained page dicts.
+	config := pdf.NewDefaultConfiguration()
+	config.MaterializePageAttrs = true
+
+	dirOut := filepath.Join(outDir, "inherited")
+	if err = os.MkdirAll(dirOut, os.ModePerm); err != nil {
+		t.Fatalf("TestInheritedPageAttrs: %v\n", err)
+	}
+
+	if _, err = Process(ExtractPagesCommand(inFile, dirOut, []string{fmt.Sprintf("%d", pageNr)}, config)); err != nil {
+		t.Fatalf("TestInheritedPageAttrs: %v\n", err)
+	}
+
+	ctx = readAndValidateFile(t, filepath.Join(dirOut, fmt.Sprintf("inherited_%d.pdf", pageNr)))
+
+	pageDict, _, err = ctx.PageDict(1)
+	if err != nil {
+		t.Fatalf("TestInheritedPageAttrs: %v\n", err)
+	}
+
+	for _, k := range []string{"Resources", "MediaBox", "Rotate"} {
+		if _, found := pageDict.Find(k); !found {
+			t.Fatalf("TestInheritedPageAttrs: missing %s in page dict\n", k)
+		}
+	}
+}
+
+// writeInlineImagePDF writes a single page PDF showing a 8x8 grayscale inline image
+// whose data contains "EI" and a 2x2 ASCIIHex encoded RGB inline image.
+func writeInlineImagePDF(fileName string) ([]byte, error) {
+
+	data := bytes.Repeat([]byte{0x00, 0x80, 0xFF, 0x20}, 16)
+	copy(data[20:], " EI ")
+
+	content := "q 64 0 0 64 72 600 cm BI /W 8 /H 8 /BPC 8 /CS /G ID " + string(data) + " EI Q\n" +
+		"q 16 0 0 16 200 600 cm BI /W 2 /H 2 /BPC 8 /CS /RGB /F /AHx ID 00FF0000FF00FFFF000000FF> EI Q"
+
+	objs := []string{
+		"<</Type/Catalog/Pages 2 0 R>>",
+		"<</Type/Pages/Kids[3 0 R]/Count 1>>",
+		"<</Type/Page/Parent 2 0 R/MediaBox[0 0 612 792]/Contents 4 0 R/Resources<<>>>>",
+		fmt.Sprintf("<</Length %d>>\nstream\n%s\nendstream", len(content), content),
+	}
+
+	return data, writeObjectsPDF(fileName, objs)
+}
+
+// writeObjectsPDF writes a PDF file made of objs, the first object being the catalog.
+func writeObjectsPDF(fileName string, objs []string) error {
+
+	var b bytes.Buffer
+	var offsets []int
+
+	b.WriteString("%PDF-1.7\n")
+	for i, o := range objs {
+		offsets = append(offsets, b.Len())
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, o)
+	}
+
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objs)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&b, "trailer\n<</Size %d/Root 1 0 R>>\nstartxref\n%d\n%%%%EOF\n", len(objs)+1, xref)
+
+	return ioutil.WriteFile(fileName, b.Bytes(), 0644)
+}
+
+func TestInlineImages(t *testing.T) {
+
+	fileName := filepath.Join(outDir, "inlineImages.pdf")
+
+	data, err := writeInlineImagePDF(fileName)
+	if err != nil {
+		t.Fatalf("TestInlineImages: %v\n", err)
+	}
+
+	ctx := readAndValidateFile(t, fileName)
+
+	sd, err := ctx.DereferenceStreamDict(pdf.IndirectRef{ObjectNumber: 4})
+	if err != nil {
+		t.Fatalf("TestInlineImages: %v\n", err)
+	}
+
+	imgs, err := pdf.ContentStreamInlineImages(*sd)
+	if err != nil {
+		t.Fatalf("TestInlineImages: %v\n", err)
+	}
+
+	if len(imgs) != 2 {
+		t.Fatalf("TestInlineImages: want 2 inline images, got %d\n", len(imgs))
+	}
+
+	if !bytes.Equal(imgs[0].Data, data) {
+		t.Fatalf("TestInlineImages: corrupt image data: %v\n", imgs[0].Data)
+	}
+
+	if cs := imgs[1].ImageDict().NameEntry("ColorSpace"); cs == nil || *cs != "DeviceRGB" {
+		t.Fatalf("TestInlineImages: unexpected color space %v\n", cs)
+	}
+
+	// Extracted images are Flate encoded.
+	sds, err := pdf.ExtractInlineImages(ctx, 1)
+	if err != nil {
+		t.Fatalf("TestInlineImages: %v\n", err)
+	}
+
+	if len(sds) != 2 {
+		t.Fatalf("TestInlineImages: want 2 extracted images, got %d\n", len(sds))
+	}
+
+	for i, want := range []int{64, 12} {
+		f, err := filter.NewFilter(filter.Flate, nil)
+		if err != nil {
+			t.Fatalf("TestInlineImages: %v\n", err)
+		}
+		b, err := f.Decode(bytes.NewReader(sds[i].Raw))
+		if err != nil {
+			t.Fatalf("TestInlineImages: %v\n", err)
+		}
+		if b.Len() != want {
+			t.Fatalf("TestInlineImages: image %d: want %d bytes, got %d\n", i+1, want, b.Len())
+		}
+	}
+
+	// Only the large inline image gets converted into an image XObject.
+	outFile := filepath.Join(outDir, "inlineImagesOptimized.pdf")
+
+	config := pdf.NewDefaultConfiguration()
+	config.InlineImageThreshold = 32
+
+	if _, err = Process(OptimizeCommand(fileName, outFile, config)); err != nil {
+		t.Fatalf("TestInlineImages: %v\n", err)
+	}
+
+	ctx = readAndValidateFile(t, outFile)
+
+	pageDict, attrs, err := ctx.PageDict(1)
+	if err != nil {
+		t.Fatalf("TestInlineImages: %v\n", err)
+	}
+
+	xObjs := attrs.Resources().DictEntry("XObject")
+	if len(xObjs) != 1 {
+		t.Fatalf("TestInlineImages: want 1 image XObject, got %v\n", xObjs)
+	}
+
+	sd, err = ctx.DereferenceStreamDict(*pageDict.IndirectRefEntry("Contents"))
+	if err != nil {
+		t.Fatalf("TestInlineImages: %v\n", err)
+	}
+
+	if imgs, err = pdf.ContentStreamInlineImages(*sd); err != nil || len(imgs) != 1 {
+		t.Fatalf("TestInlineImages: want 1 remaining inline image, got %d (%v)\n", len(imgs), err)
+	}
+
+	// Validation tolerates content streams with corrupt inline images, extraction does not.
+	ctx = readAndValidateFile(t, fileName)
+
+	entry, _ := ctx.FindTableEntryLight(4)
+	sd1 := entry.Object.(pdf.StreamDict)
+	sd1.Raw = []byte("q BI /W 8 /H 8 /BPC 8 /CS /G ID abc")
+	sd1.Content = sd1.Raw
+	entry.Object = sd1
+
+	ctx.XRefTable.ValidationMode = pdf.ValidationStrict
+	if err = ValidateContext(ctx); err != nil {
+		t.Fatalf("TestInlineImages: %v\n", err)
+	}
+
+	if _, err = pdf.ExtractInlineImages(ctx, 1); err == nil {
+		t.Fatal("TestInlineImages: extracting corrupt inline image should fail\n")
+	}
+}
+
+func TestFlattenTransparency(t *testing.T) {
+
+	for _, fn := range []string{"OptimizeTest.pdf", "annotTest.pdf", "Hybrid-PDF.pdf"} {
+
+		inFile := filepath.Join(inDir, fn)
+		outFile := filepath.Join(outDir, "flattened_"+fn)
+
+		ctx := readAndValidateFile(t, inFile)
+		if vs := validate.CheckTransparency(ctx.XRefTable); len(vs) == 0 {
+			t.Fatalf("TestFlattenTransparency %s: missing transparency\n", fn)
+		}
+
+		config := pdf.NewDefaultConfiguration()
+		config.FlattenTransparency = true
+
+		if _, err := Process(OptimizeCommand(inFile, outFile, config)); err != nil {
+			t.Fatalf("TestFlattenTransparency %s: %v\n", fn, err)
+		}
+
+		ctx = readAndValidateFile(t, outFile)
+		if vs := validate.CheckTransparency(ctx.XRefTable); len(vs) > 0 {
+			t.Fatalf("TestFlattenTransparency %s: %s\n", fn, vs[0].Message)
+		}
+	}
+
+	// Soft masks of 8 bit RGB images get merged into the image.
+	ctx := readAndValidateFile(t, filepath.Join(inDir, "OptimizeTest.pdf"))
+
+	sd, err := ctx.DereferenceStreamDict(pdf.IndirectRef{ObjectNumber: 8})
+	if err != nil {
+		t.Fatalf("TestFlattenTransparency: %v\n", err)
+	}
+	raw := sd.Raw
+
+	if err = pdf.FlattenTransparency(ctx.XRefTable); err != nil {
+		t.Fatalf("TestFlattenTransparency: %v\n", err)
+	}
+
+	if sd, err = ctx.DereferenceStreamDict(pdf.IndirectRef{ObjectNumber: 8}); err != nil {
+		t.Fatalf("TestFlattenTransparency: %v\n", err)
+	}
+
+	if _, found := sd.Find("SMask"); found || bytes.Equal(sd.Raw, raw) {
+		t.Fatal("TestFlattenTransparency: soft mask not merged\n")
+	}
+
+	f, err := filter.NewFilter(filter.Flate, nil)
+	if err != nil {
+		t.Fatalf("TestFlattenTransparency: %v\n", err)
+	}
+
+	b, err := f.Decode(bytes.NewReader(sd.Raw))
+	if err != nil {
+		t.Fatalf("TestFlattenTransparency: %v\n", err)
+	}
+
+	if want := *sd.IntEntry("Width") * *sd.IntEntry("Height") * 3; b.Len() != want {
+		t.Fatalf("TestFlattenTransparency: want %d bytes of image data, got %d\n", want, b.Len())
+	}
+}
+
+// formFieldDicts returns the fields of the interactive form of fileName by fully qualified name.
+func formFieldDicts(t *testing.T, fileName string) map[string]pdf.Dict {
+
+	ctx := readAndValidateFile(t, fileName)
+
+	rootDict, err := ctx.Catalog()
+	if err != nil {
+		t.Fatalf("%s: %v\n", fileName, err)
+	}
+
+	acroForm, err := ctx.DereferenceDict(rootDict["AcroForm"])
+	if err != nil || acroForm == nil {
+		t.Fatalf("%s: missing AcroForm %v\n", fileName, err)
+	}
+
+	m := map[string]pdf.Dict{}
+
+	var walk func(fields pdf.Array, prefix string)
+	walk = func(fields pdf.Array, prefix string) {
+		for _, o := range fields {
+			d, err := ctx.DereferenceDict(o)
+			if err != nil {
+				t.Fatalf("%s: %v\n", fileName, err)
+			}
+			s, ok := d["T"].(pdf.StringLiteral)
+			if !ok {
+				continue
+			}
+			name := prefix + s.Value()
+			m[name] = d
+			walk(d.ArrayEntry("Kids"), name+".")
+		}
+	}
+	walk(acroForm.ArrayEntry("Fields"), "")
+
+	return m
+}
+
+func fieldReadOnly(d pdf.Dict) bool {
+	ff := d.IntEntry("Ff")
+	return ff != nil && *ff&pdf.FieldReadOnly > 0
+}
+
+func TestLockFormFields(t *testing.T) {
+
+	xRefTable, err := pdf.CreateAcroFormDemoXRef()
+	if err != nil {
+		t.Fatalf("TestLockFormFields: %v\n", err)
+	}
+
+	if err = pdf.CreatePDF(xRefTable, outDir+"/", "lockFormFields.pdf"); err != nil {
+		t.Fatalf("TestLockFormFields: %v\n", err)
+	}
+
+	inFile := filepath.Join(outDir, "lockFormFields.pdf")
+	outFile := filepath.Join(outDir, "lockedFormFields.pdf")
+
+	config := pdf.NewDefaultConfiguration()
+
+	// Lock all fields.
+	if err = LockFormFields(inFile, outFile, nil, false, config); err != nil {
+		t.Fatalf("TestLockFormFields: %v\n", err)
+	}
+
+	fields := formFieldDicts(t, outFile)
+	if len(fields) == 0 {
+		t.Fatal("TestLockFormFields: missing form fields\n")
+	}
+
+	for name, d := range fields {
+		if !fieldReadOnly(d) {
+			t.Fatalf("TestLockFormFields: field %s not locked\n", name)
+		}
+	}
+
+	// Lock a single field and remove its actions.
+	if err = LockFormFields(inFile, outFile, []string{"Reset"}, true, config); err != nil {
+		t.Fatalf("TestLockFormFields: %v\n", err)
+	}
+
+	fields = formFieldDicts(t, outFile)
+
+	for name, d := range fields {
+		if fieldReadOnly(d) != (name == "Reset") {
+			t.Fatalf("TestLockFormFields: field %s: unexpected read-only flag\n", name)
+		}
+	}
+
+	if _, found := fields["Reset"].Find("A"); found {
+		t.Fatal("TestLockFormFields: action of locked field not removed\n")
+	}
+
+	if _, found := fields["Submit"].Find("A"); !found {
+		t.Fatal("TestLockFormFields: action of unlocked field removed\n")
+	}
+
+	if err = LockFormFields(inFile, outFile, []string{"NoSuchField"}, false, config); err == nil {
+		t.Fatal("TestLockFormFields: locking an unknown field should fail\n")
+	}
+
+	// Locking a kid keeps the field flags inherited from its parent.
+	if xRefTable, err = pdf.CreateAcroFormDemoXRef(); err != nil {
+		t.Fatalf("TestLockFormFields: %v\n", err)
+	}
+
+	kidName := nestTextField(t, xRefTable, "Parent", pdf.FieldMultiline|pdf.FieldComb)
+
+	if err = pdf.CreatePDF(xRefTable, outDir+"/", "lockFormFields.pdf"); err != nil {
+		t.Fatalf("TestLockFormFields: %v\n", err)
+	}
+
+	if err = LockFormFields(inFile, outFile, []string{"Parent." + kidName}, false, config); err != nil {
+		t.Fatalf("TestLockFormFields: %v\n", err)
+	}
+
+	fields = formFieldDicts(t, outFile)
+
+	ff := fields["Parent."+kidName].IntEntry("Ff")
+	if want := pdf.FieldMultiline | pdf.FieldComb | pdf.FieldReadOnly; ff == nil || *ff != want {
+		t.Fatalf("TestLockFormFields: want Ff %d, got %v\n", want, ff)
+	}
+
+	if fieldReadOnly(fields["Parent"]) {
+		t.Fatal("TestLockFormFields: parent of locked field locked\n")
+	}
+}
+
+// nestTextField moves the first text field of xRefTable below a new parent field carrying the field flags ff
+// and returns the name of the text field.
+func nestTextField(t *testing.T, xRefTable *pdf.XRefTable, parentName string, ff int) string {
+
+	rootDict, err := xRefTable.Catalog()
+	if err != nil {
+		t.Fatalf("nestTextField: %v\n", err)
+	}
+
+	acroForm, err := xRefTable.DereferenceDict(rootDict["AcroForm"])
+	if err != nil || acroForm == nil {
+		t.Fatalf("nestTextField: missing AcroForm %v\n", err)
+	}
+
+	fields := acroForm.ArrayEntry("Fields")
+
+	for i, o := range fields {
+
+		ir, ok := o.(pdf.IndirectRef)
+		if !ok {
+			continue
+		}
+
+		d, err := xRefTable.DereferenceDict(ir)
+		if err != nil {
+			t.Fatalf("nestTextField: %v\n", err)
+		}
+
+		if ft := d.NameEntry("FT"); ft == nil || *ft != "Tx" || d["Kids"] != nil {
+			continue
+		}
+
+		parent := pdf.Dict(
+			map[string]pdf.Object{
+				"T":    pdf.StringLiteral(parentName),
+				"FT":   pdf.Name("Tx"),
+				"Ff":   pdf.Integer(ff),
+				"Kids": pdf.Array{ir},
+			},
+		)
+
+		pir, err := xRefTable.IndRefForNewObject(parent)
+		if err != nil {
+			t.Fatalf("nestTextField: %v\n", err)
+		}
+
+		d.Delete("FT")
+		d.Delete("Ff")
+		d.Insert("Parent", *pir)
+
+		fields[i] = *pir
+		acroForm.Update("Fields", fields)
+
+		return d["T"].(pdf.StringLiteral).Value()
+	}
+
+	t.Fatal("nestTextField: missing text field\n")
+
+	return ""
+}
+
+func TestAddFormFields(t *testing.T) {
+
+	fileName := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	outFile := filepath.Join(outDir, "formFields.pdf")
+
+	config := pdf.NewDefaultConfiguration()
+
+	fields := []pdf.FormField{
+		{Type: pdf.FormFieldText, Name: "Name", Page: 1, Rect: types.NewRectangle(50, 700, 250, 720), Default: "Jane (Doe)"},
+		{Type: pdf.FormFieldText, Name: "Notes", Page: 1, Rect: types.NewRectangle(50, 600, 250, 680), FontName: "Courier", FontSize: 9},
+		{Type: pdf.FormFieldCheckBox, Name: "Agree", Page: 1, Rect: types.NewRectangle(50, 550, 65, 565), Checked: true},
+		{Type: pdf.FormFieldSignature, Name: "Signature", Page: 1, Rect: types.NewRectangle(300, 50, 500, 100), Default: "Sign here"},
+	}
+
+	if err := AddFormFields(fileName, outFile, fields, config); err != nil {
+		t.Fatalf("TestAddFormFields: %v\n", err)
+	}
+
+	m := formFieldDicts(t, outFile)
+	if len(m) != len(fields) {
+		t.Fatalf("TestAddFormFields: want %d fields, got %d\n", len(fields), len(m))
+	}
+
+	if v, ok := m["Name"]["V"].(pdf.StringLiteral); !ok || v != "Jane \\(Doe\\)" {
+		t.Fatalf("TestAddFormFields: unexpected text field value %v\n", m["Name"]["V"])
+	}
+
+	if da, ok := m["Notes"]["DA"].(pdf.StringLiteral); !ok || da != "/Courier 9 Tf 0 g" {
+		t.Fatalf("TestAddFormFields: unexpected default appearance %v\n", m["Notes"]["DA"])
+	}
+
+	if as := m["Agree"].NameEntry("AS"); as == nil || *as != "Yes" {
+		t.Fatalf("TestAddFormFields: check box should be checked, got %v\n", as)
+	}
+
+	for name, ft := range map[string]string{"Name": "Tx", "Agree": "Btn", "Signature": "Sig"} {
+		if s := m[name].NameEntry("FT"); s == nil || *s != ft {
+			t.Fatalf("TestAddFormFields: field %s: want type %s, got %v\n", name, ft, s)
+		}
+		if _, found := m[name].Find("AP"); !found {
+			t.Fatalf("TestAddFormFields: field %s: missing appearance\n", name)
+		}
+	}
+
+	// Field names need to be unique.
+	if err := AddFormFields(outFile, outFile, fields[2:3], config); err == nil {
+		t.Fatal("TestAddFormFields: adding a duplicate field should fail\n")
+	}
+
+	bad := []pdf.FormField{{Type: pdf.FormFieldText, Name: "X", Page: 1, Rect: types.NewRectangle(0, 0, 10, 10), FontName: "Arial"}}
+	if err := AddFormFields(outFile, outFile, bad, config); err == nil {
+		t.Fatal("TestAddFormFields: unsupported fonts should fail\n")
+	}
+}
+
+func TestTabAndCalculationOrder(t *testing.T) {
+
+	config := pdf.NewDefaultConfiguration()
+
+	// Two form documents.
+	file1 := filepath.Join(outDir, "calcOrder1.pdf")
+	file2 := filepath.Join(outDir, "calcOrder2.pdf")
+
+	fields := []pdf.FormField{
+		{Type: pdf.FormFieldText, Name: "A", Page: 1, Rect: types.NewRectangle(50, 700, 250, 720)},
+		{Type: pdf.FormFieldText, Name: "B", Page: 1, Rect: types.NewRectangle(50, 650, 250, 670)},
+		{Type: pdf.FormFieldText, Name: "C", Page: 1, Rect: types.NewRectangle(50, 600, 250, 620)},
+	}
+
+	if err := AddFormFields(filepath.Join(inDir, "5116.DCT_Filter.pdf"), file1, fields[:2], config); err != nil {
+		t.Fatalf("TestTabAndCalculationOrder: %v\n", err)
+	}
+
+	if err := AddFormFields(filepath.Join(inDir, "5116.DCT_Filter.pdf"), file2, fields[2:], config); err != nil {
+		t.Fatalf("TestTabAndCalculationOrder: %v\n", err)
+	}
+
+	if err := SetTabOrder(file1, file1, nil, pdf.TabOrderColumn, config); err != nil {
+		t.Fatalf("TestTabAndCalculationOrder: %v\n", err)
+	}
+
+	if err := SetTabOrder(file1, file1, nil, "X", config); err == nil {
+		t.Fatal("TestTabAndCalculationOrder: invalid tab orders should fail\n")
+	}
+
+	if err := SetCalculationOrder(file1, file1, []string{"B", "A"}, config); err != nil {
+		t.Fatalf("TestTabAndCalculationOrder: %v\n", err)
+	}
+
+	if err := SetCalculationOrder(file2, file2, []string{"C"}, config); err != nil {
+		t.Fatalf("TestTabAndCalculationOrder: %v\n", err)
+	}
+
+	if err := SetCalculationOrder(file1, file1, []string{"C"}, config); err == nil {
+		t.Fatal("TestTabAndCalculationOrder: unknown fields should fail\n")
+	}
+
+	// Merging keeps fields, calculation order and tab order.
+	outFile := filepath.Join(outDir, "calcOrderMerged.pdf")
+
+	if _, err := Process(MergeCommand([]string{file1, file2}, outFile, config)); err != nil {
+		t.Fatalf("TestTabAndCalculationOrder: %v\n", err)
+	}
+
+	ctx := readAndValidateFile(t, outFile)
+
+	names, err := pdf.FormFieldNames(ctx.XRefTable)
+	if err != nil {
+		t.Fatalf("TestTabAndCalculationOrder: %v\n", err)
+	}
+
+	if strings.Join(names, ",") != "A,B,C" {
+		t.Fatalf("TestTabAndCalculationOrder: want fields A,B,C got %v\n", names)
+	}
+
+	co, err := pdf.CalculationOrder(ctx.XRefTable)
+	if err != nil {
+		t.Fatalf("TestTabAndCalculationOrder: %v\n", err)
+	}
+
+	if strings.Join(co, ",") != "B,A,C" {
+		t.Fatalf("TestTabAndCalculationOrder: want calculation order B,A,C got %v\n", co)
+	}
+
+	pageDict, _, err := ctx.PageDict(1)
+	if err != nil {
+		t.Fatalf("TestTabAndCalculationOrder: %v\n", err)
+	}
+
+	if tabs := pageDict.NameEntry("Tabs"); tabs == nil || *tabs != pdf.TabOrderColumn {
+		t.Fatalf("TestTabAndCalculationOrder: want tab order C, got %v\n", tabs)
+	}
+
+	// The widgets of merged fields stay on their pages.
+	var widgets int
+	for p := 1; p <= ctx.PageCount; p++ {
+		pageDict, _, err := ctx.PageDict(p)
+		if err != nil {
+			t.Fatalf("TestTabAndCalculationOrder: %v\n", err)
+		}
+		for _, o := range pageDict.ArrayEntry("Annots") {
+			d, err := ctx.DereferenceDict(o)
+			if err != nil {
+				t.Fatalf("TestTabAndCalculationOrder: %v\n", err)
+			}
+			if st := d.Subtype(); st != nil && *st == "Widget" {
+				widgets++
+			}
+		}
+	}
+
+	if widgets != len(fields) {
+		t.Fatalf("TestTabAndCalculationOrder: want %d widgets, got %d\n", len(fields), widgets)
+	}
+}
+
+// normalAppearance returns the decoded normal appearance of the widget annotation d.
+func normalAppearance(t *testing.T, ctx *pdf.Context, d pdf.Dict) string {
+
+	ap := d.DictEntry("AP")
+	if ap == nil {
+		t.Fatal("missing appearance dict\n")
+	}
+
+	sd, err := ctx.DereferenceStreamDict(ap["N"])
+	if err != nil || sd == nil {
+		t.Fatalf("missing normal appearance: %v\n", err)
+	}
+
+	return streamContent(t, sd)
+}
+
+// streamContent returns the decoded content of an uncompressed or Flate encoded stream.
+func streamContent(t *testing.T, sd *pdf.StreamDict) string {
+
+	if sd.FilterPipeline == nil {
+		return string(sd.Raw)
+	}
+
+	f, err := filter.NewFilter(filter.Flate, nil)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	b, err := f.Decode(bytes.NewReader(sd.Raw))
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	return b.String()
+}
+
+func TestFillFormFields(t *testing.T) {
+
+	fileName := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	formFile := filepath.Join(outDir, "fillForm.pdf")
+	outFile := filepath.Join(outDir, "fillFormFilled.pdf")
+
+	config := pdf.NewDefaultConfiguration()
+
+	fields := []pdf.FormField{
+		{Type: pdf.FormFieldText, Name: "Name", Page: 1, Rect: types.NewRectangle(50, 700, 250, 720)},
+		{Type: pdf.FormFieldText, Name: "Code", Page: 1, Rect: types.NewRectangle(50, 650, 150, 670)},
+		{Type: pdf.FormFieldText, Name: "Notes", Page: 1, Rect: types.NewRectangle(50, 550, 150, 630), FontName: "Courier", FontSize: 10},
+		{Type: pdf.FormFieldCheckBox, Name: "Agree", Page: 1, Rect: types.NewRectangle(50, 500, 65, 515)},
+	}
+
+	if err := AddFormFields(fileName, formFile, fields, config); err != nil {
+		t.Fatalf("TestFillFormFields: %v\n", err)
+	}
+
+	ctx := readAndValidateFile(t, formFile)
+
+	rootDict, err := ctx.Catalog()
+	if err != nil {
+		t.Fatalf("TestFillFormFields: %v\n", err)
+	}
+
+	acroForm := rootDict.DictEntry("AcroForm")
+
+	m := map[string]pdf.Dict{}
+	for _, o := range acroForm.ArrayEntry("Fields") {
+		d, err := ctx.DereferenceDict(o)
+		if err != nil {
+			t.Fatalf("TestFillFormFields: %v\n", err)
+		}
+		m[d["T"].(pdf.StringLiteral).Value()] = d
+	}
+
+	// Right aligned, a comb field of 5 cells and a multiline field.
+	m["Name"].Insert("Q", pdf.Integer(2))
+	m["Code"].Insert("Ff", pdf.Integer(pdf.FieldComb))
+	m["Code"].Insert("MaxLen", pdf.Integer(5))
+	m["Notes"].Insert("Ff", pdf.Integer(pdf.FieldMultiline))
+
+	values := map[string]string{
+		"Name":  "Jane Doe",
+		"Code":  "AB12",
+		"Notes": "one two three four five six seven",
+		"Agree": "Yes",
+	}
+
+	if err := pdf.FillFormFields(ctx.XRefTable, values); err != nil {
+		t.Fatalf("TestFillFormFields: %v\n", err)
+	}
+
+	if v, ok := m["Name"]["V"].(pdf.StringLiteral); !ok || v != "Jane Doe" {
+		t.Fatalf("TestFillFormFields: unexpected value %v\n", m["Name"]["V"])
+	}
+
+	// Auto sized Helvetica, right aligned.
+	s := normalAppearance(t, ctx, m["Name"])
+	if !strings.Contains(s, "/Helvetica 12.00 Tf") || !strings.Contains(s, "(Jane Doe) Tj") {
+		t.Fatalf("TestFillFormFields: unexpected appearance %s\n", s)
+	}
+	if w := metrics.TextWidth("Jane Doe", "Helvetica", 12); !strings.Contains(s, fmt.Sprintf("1 0 0 1 %.2f ", 198-w)) {
+		t.Fatalf("TestFillFormFields: text should be right aligned: %s\n", s)
+	}
+
+	// One glyph per comb cell.
+	s = normalAppearance(t, ctx, m["Code"])
+	if strings.Count(s, "Tj") != 4 || !strings.Contains(s, "(B) Tj") {
+		t.Fatalf("TestFillFormFields: unexpected comb field appearance %s\n", s)
+	}
+
+	// Wrapped lines using the font size of the default appearance.
+	s = normalAppearance(t, ctx, m["Notes"])
+	if !strings.Contains(s, "/Courier 10.00 Tf") || strings.Count(s, "Tj") < 2 {
+		t.Fatalf("TestFillFormFields: unexpected multiline field appearance %s\n", s)
+	}
+
+	if as := m["Agree"].NameEntry("AS"); as == nil || *as != "Yes" {
+		t.Fatalf("TestFillFormFields: check box should be checked, got %v\n", as)
+	}
+
+	if err := pdf.FillFormFields(ctx.XRefTable, map[string]string{"Code": "ABCDEF"}); err == nil {
+		t.Fatal("TestFillFormFields: values exceeding MaxLen should fail\n")
+	}
+
+	if err := pdf.FillFormFields(ctx.XRefTable, map[string]string{"Agree": "Maybe"}); err == nil {
+		t.Fatal("TestFillFormFields: unknown check box states should fail\n")
+	}
+
+	if err := FillFormFields(formFile, outFile, map[string]string{"Name": "John"}, config); err != nil {
+		t.Fatalf("TestFillFormFields: %v\n", err)
+	}
+
+	if err := FillFormFields(formFile, outFile, map[string]string{"Unknown": "John"}, config); err == nil {
+		t.Fatal("TestFillFormFields: unknown fields should fail\n")
+	}
+
+	readAndValidateFile(t, outFile)
+}
+
+func TestChoiceOptions(t *testing.T) {
+
+	fileName := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	formFile := filepath.Join(outDir, "choiceForm.pdf")
+	outFile := filepath.Join(outDir, "choiceFormEdited.pdf")
+
+	config := pdf.NewDefaultConfiguration()
+
+	fields := []pdf.FormField{
+		{Type: pdf.FormFieldText, Name: "Country", Page: 1, Rect: types.NewRectangle(50, 700, 250, 720)},
+		{Type: pdf.FormFieldText, Name: "Color", Page: 1, Rect: types.NewRectangle(50, 600, 250, 680)},
+	}
+
+	if err := AddFormFields(fileName, formFile, fields, config); err != nil {
+		t.Fatalf("TestChoiceOptions: %v\n", err)
+	}
+
+	// Turn the text fields into a combo box and a list box.
+	ctx := readAndValidateFile(t, formFile)
+
+	rootDict, err := ctx.Catalog()
+	if err != nil {
+		t.Fatalf("TestChoiceOptions: %v\n", err)
+	}
+
+	for _, o := range rootDict.DictEntry("AcroForm").ArrayEntry("Fields") {
+		d, err := ctx.DereferenceDict(o)
+		if err != nil {
+			t.Fatalf("TestChoiceOptions: %v\n", err)
+		}
+		d.Update("FT", pdf.Name("Ch"))
+		if d["T"].(pdf.StringLiteral) == "Country" {
+			d.Insert("Ff", pdf.Integer(pdf.FieldCombo))
+			d.Insert("Opt", pdf.Array{pdf.Array{pdf.StringLiteral("FR"), pdf.StringLiteral("France")}, pdf.StringLiteral("DE")})
+			continue
+		}
+		d.Insert("Opt", pdf.Array{pdf.StringLiteral("Red"), pdf.StringLiteral("Green")})
+		d.Insert("V", pdf.StringLiteral("Green"))
+	}
+
+	ctx.Write.DirName, ctx.Write.FileName = filepath.Split(formFile)
+	if err := Write(ctx); err != nil {
+		t.Fatalf("TestChoiceOptions: %v\n", err)
+	}
+
+	options, err := ChoiceOptions(formFile, "Country", config)
+	if err != nil {
+		t.Fatalf("TestChoiceOptions: %v\n", err)
+	}
+
+	want := []pdf.ChoiceOption{{Export: "FR", Display: "France"}, {Export: "DE", Display: "DE"}}
+	if len(options) != len(want) || options[0] != want[0] || options[1] != want[1] {
+		t.Fatalf("TestChoiceOptions: want %v, got %v\n", want, options)
+	}
+
+	// Add an option and reorder.
+	options = append([]pdf.ChoiceOption{{Export: "IT", Display: "Italy"}}, options...)
+	if err := SetChoiceOptions(formFile, outFile, "Country", options, config); err != nil {
+		t.Fatalf("TestChoiceOptions: %v\n", err)
+	}
+
+	// Remove the selected option of the list box.
+	if err := SetChoiceOptions(outFile, outFile, "Color", []pdf.ChoiceOption{{Export: "Red"}, {Export: "Blue"}}, config); err != nil {
+		t.Fatalf("TestChoiceOptions: %v\n", err)
+	}
+
+	if options, err = ChoiceOptions(outFile, "Country", config); err != nil {
+		t.Fatalf("TestChoiceOptions: %v\n", err)
+	}
+	if len(options) != 3 || options[0].Export != "IT" || options[1].Display != "France" {
+		t.Fatalf("TestChoiceOptions: unexpected options %v\n", options)
+	}
+
+	m := formFieldDicts(t, outFile)
+
+	if _, found := m["Color"].Find("V"); found {
+		t.Fatalf("TestChoiceOptions: removed option should be deselected, got %v\n", m["Color"]["V"])
+	}
+
+	if o := m["Country"]["Opt"].(pdf.Array); o[2] != pdf.StringLiteral("DE") {
+		t.Fatalf("TestChoiceOptions: options without display text should be plain strings, got %v\n", o)
+	}
+
+	if err := FillFormFields(outFile, outFile, map[string]string{"Color": "Blue", "Country": "FR"}, config); err != nil {
+		t.Fatalf("TestChoiceOptions: %v\n", err)
+	}
+
+	m = formFieldDicts(t, outFile)
+	if i := m["Color"].ArrayEntry("I"); len(i) != 1 || i[0] != pdf.Integer(1) {
+		t.Fatalf("TestChoiceOptions: want selected option 1, got %v\n", i)
+	}
+
+	dup := []pdf.ChoiceOption{{Export: "A"}, {Export: "A", Display: "B"}}
+	if err := SetChoiceOptions(outFile, outFile, "Color", dup, config); err == nil {
+		t.Fatal("TestChoiceOptions: duplicate export values should fail\n")
+	}
+
+	if _, err := ChoiceOptions(formFile, "Unknown", config); err == nil {
+		t.Fatal("TestChoiceOptions: unknown fields should fail\n")
+	}
+}
+
+func TestButtonExportValues(t *testing.T) {
+
+	xRefTable, err := pdf.CreateAcroFormDemoXRef()
+	if err != nil {
+		t.Fatalf("TestButtonExportValues: %v\n", err)
+	}
+
+	if err = pdf.CreatePDF(xRefTable, outDir+"/", "buttonExportValues.pdf"); err != nil {
+		t.Fatalf("TestButtonExportValues: %v\n", err)
+	}
+
+	inFile := filepath.Join(outDir, "buttonExportValues.pdf")
+	config := pdf.NewDefaultConfiguration()
+
+	m, err := ButtonExportValues(inFile, config)
+	if err != nil {
+		t.Fatalf("TestButtonExportValues: %v\n", err)
+	}
+
+	// Push buttons have no export values.
+	want := map[string]string{"CheckBox": "Yes", "Credit card.Radio1": "card1", "Credit card.Radio2": "card2"}
+	if len(m) != len(want) {
+		t.Fatalf("TestButtonExportValues: want %v, got %v\n", want, m)
+	}
+
+	for name, v := range want {
+		if len(m[name]) != 1 || m[name][0] != v {
+			t.Fatalf("TestButtonExportValues: field %s: want [%s], got %v\n", name, v, m[name])
+		}
+	}
+
+	// The export values are accepted when filling.
+	values := map[string]string{"CheckBox": "Off", "Credit card.Radio2": m["Credit card.Radio2"][0]}
+	if err := FillFormFields(inFile, inFile, values, config); err != nil {
+		t.Fatalf("TestButtonExportValues: %v\n", err)
+	}
+
+	d := formFieldDicts(t, inFile)["Credit card.Radio2"]
+	if as := d.NameEntry("AS"); as == nil || *as != "card2" {
+		t.Fatalf("TestButtonExportValues: radio button should be on, got %v\n", as)
+	}
+}
+
+// pageAnnotations returns the annotation dicts of a page by subtype.
+func pageAnnotations(t *testing.T, ctx *pdf.Context, page int) map[string][]pdf.Dict {
+
+	pageDict, _, err := ctx.PageDict(page)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	annots, err := ctx.DereferenceArray(pageDict["Annots"])
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	m := map[string][]pdf.Dict{}
+	for _, o := range annots {
+		d, err := ctx.DereferenceDict(o)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		if st := d.Subtype(); st != nil {
+			m[*st] = append(m[*st], d)
+		}
+	}
+
+	return m
+}
+
+func TestAddStampAnnotations(t *testing.T) {
+
+	fileName := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	outFile := filepath.Join(outDir, "stampAnnotations.pdf")
+
+	now := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	config := pdf.NewDefaultConfiguration()
+	config.Now = func() time.Time { return now }
+
+	stamps := []pdf.StampAnnotation{
+		{Page: 1, Rect: types.NewRectangle(50, 700, 250, 760), Name: "Approved", Contents: "Looks good", Author: "QA"},
+		{Page: 1, Rect: types.NewRectangle(50, 600, 250, 660)},
+		{Page: 1, Rect: types.NewRectangle(300, 600, 400, 700), Name: "Chip", ImageFile: filepath.Join(resDir, "pdfchip3.png")},
+	}
+
+	if err := AddStampAnnotations(fileName, outFile, stamps, config); err != nil {
+		t.Fatalf("TestAddStampAnnotations: %v\n", err)
+	}
+
+	ctx := readAndValidateFile(t, outFile)
+
+	a := pageAnnotations(t, ctx, 1)["Stamp"]
+	if len(a) != len(stamps) {
+		t.Fatalf("TestAddStampAnnotations: want %d stamps, got %d\n", len(stamps), len(a))
+	}
+
+	for i, name := range []string{"Approved", "Draft", "Chip"} {
+		if n := a[i].NameEntry("Name"); n == nil || *n != name {
+			t.Fatalf("TestAddStampAnnotations: want stamp %s, got %v\n", name, n)
+		}
+	}
+
+	if s := normalAppearance(t, ctx, a[0]); !strings.Contains(s, "(APPROVED) Tj") {
+		t.Fatalf("TestAddStampAnnotations: unexpected appearance %s\n", s)
+	}
+
+	if s := normalAppearance(t, ctx, a[2]); !strings.Contains(s, "/Im0 Do") {
+		t.Fatalf("TestAddStampAnnotations: unexpected appearance %s\n", s)
+	}
+
+	if c, ok := a[0]["Contents"].(pdf.StringLiteral); !ok || c != "Looks good" {
+		t.Fatalf("TestAddStampAnnotations: unexpected contents %v\n", a[0]["Contents"])
+	}
+
+	if d := a[0]["CreationDate"]; d != pdf.StringLiteral(pdf.DateString(now)) {
+		t.Fatalf("TestAddStampAnnotations: creation date %v not taken from the configured clock\n", d)
+	}
+
+	bad := []pdf.StampAnnotation{{Page: 1, Rect: types.NewRectangle(0, 0, 10, 10), Name: "Custom"}}
+	if err := AddStampAnnotations(fileName, outFile, bad, config); err == nil {
+		t.Fatal("TestAddStampAnnotations: custom stamps without image should fail\n")
+	}
+}
+
+func TestAddFreeTextAnnotations(t *testing.T) {
+
+	fileName := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	outFile := filepath.Join(outDir, "freeTextAnnotations.pdf")
+
+	config := pdf.NewDefaultConfiguration()
+
+	yellow := pdf.RGBColor{R: 1, G: 1, B: .6}
+
+	annots := []pdf.FreeTextAnnotation{
+		{
+			Page:        1,
+			Rect:        types.NewRectangle(300, 600, 450, 660),
+			Text:        "Please check this figure against the appendix.",
+			Author:      "Reviewer",
+			FontName:    "Times-Roman",
+			FontSize:    10,
+			Color:       pdf.RGBColor{R: .8},
+			Alignment:   1,
+			FillColor:   &yellow,
+			BorderWidth: 1,
+			Callout:     []types.Point{{X: 100, Y: 500}, {X: 200, Y: 630}, {X: 300, Y: 630}},
+		},
+		{Page: 1, Rect: types.NewRectangle(50, 50, 250, 80), Text: "Plain comment"},
+	}
+
+	if err := AddFreeTextAnnotations(fileName, outFile, annots, config); err != nil {
+		t.Fatalf("TestAddFreeTextAnnotations: %v\n", err)
+	}
+
+	ctx := readAndValidateFile(t, outFile)
+
+	a := pageAnnotations(t, ctx, 1)["FreeText"]
+	if len(a) != len(annots) {
+		t.Fatalf("TestAddFreeTextAnnotations: want %d annotations, got %d\n", len(annots), len(a))
+	}
+
+	d := a[0]
+
+	if it := d.NameEntry("IT"); it == nil || *it != "FreeTextCallout" {
+		t.Fatalf("TestAddFreeTextAnnotations: want intent FreeTextCallout, got %v\n", it)
+	}
+
+	if cl := d.ArrayEntry("CL"); len(cl) != 6 {
+		t.Fatalf("TestAddFreeTextAnnotations: unexpected callout line %v\n", cl)
+	}
+
+	// The annotation rectangle encloses the callout line.
+	if r := d.ArrayEntry("Rect"); r[0].(pdf.Float) >= 100 || r[1].(pdf.Float) >= 500 {
+		t.Fatalf("TestAddFreeTextAnnotations: unexpected rectangle %v\n", r)
+	}
+
+	if q := d.IntEntry("Q"); q == nil || *q != 1 {
+		t.Fatalf("TestAddFreeTextAnnotations: want centered text, got %v\n", q)
+	}
+
+	rc, err := ctx.DereferenceText(d["RC"])
+	if err != nil || !strings.Contains(rc, "against the appendix.</p>") {
+		t.Fatalf("TestAddFreeTextAnnotations: unexpected rich text %q %v\n", rc, err)
+	}
+
+	s := normalAppearance(t, ctx, d)
+	for _, want := range []string{"/Times-Roman 10.00 Tf", "1.000 1.000 0.600 rg", "100.00 500.00 m"} {
+		if !strings.Contains(s, want) {
+			t.Fatalf("TestAddFreeTextAnnotations: appearance misses %q: %s\n", want, s)
+		}
+	}
+
+	// The text got wrapped.
+	if strings.Count(s, "Tj") < 2 {
+		t.Fatalf("TestAddFreeTextAnnotations: text should be wrapped: %s\n", s)
+	}
+
+	if _, found := a[1].Find("CL"); found {
+		t.Fatal("TestAddFreeTextAnnotations: unexpected callout line\n")
+	}
+
+	bad := []pdf.FreeTextAnnotation{{Page: 1, Rect: types.NewRectangle(0, 0, 10, 10), Callout: []types.Point{{X: 1, Y: 1}}}}
+	if err := AddFreeTextAnnotations(fileName, outFile, bad, config); err == nil {
+		t.Fatal("TestAddFreeTextAnnotations: callout lines need at least 2 points\n")
+	}
+}
+
+func TestFlattenAnnotations(t *testing.T) {
+
+	fileName := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	inFile := filepath.Join(outDir, "flattenAnnotations.pdf")
+	outFile := filepath.Join(outDir, "flattenedAnnotations.pdf")
+
+	config := pdf.NewDefaultConfiguration()
+
+	stamps := []pdf.StampAnnotation{{Page: 1, Rect: types.NewRectangle(50, 700, 250, 760), Name: "Approved"}}
+	if err := AddStampAnnotations(fileName, inFile, stamps, config); err != nil {
+		t.Fatalf("TestFlattenAnnotations: %v\n", err)
+	}
+
+	annots := []pdf.FreeTextAnnotation{{Page: 1, Rect: types.NewRectangle(50, 50, 250, 80), Text: "Comment"}}
+	if err := AddFreeTextAnnotations(inFile, inFile, annots, config); err != nil {
+		t.Fatalf("TestFlattenAnnotations: %v\n", err)
+	}
+
+	fields := []pdf.FormField{{Type: pdf.FormFieldText, Name: "Name", Page: 1, Rect: types.NewRectangle(50, 600, 250, 620), Default: "Jane"}}
+	if err := AddFormFields(inFile, inFile, fields, config); err != nil {
+		t.Fatalf("TestFlattenAnnotations: %v\n", err)
+	}
+
+	// Flatten stamps only.
+	if err := FlattenAnnotations(inFile, outFile, nil, []string{"Stamp"}, config); err != nil {
+		t.Fatalf("TestFlattenAnnotations: %v\n", err)
+	}
+
+	ctx := readAndValidateFile(t, outFile)
+
+	m := pageAnnotations(t, ctx, 1)
+	if len(m["Stamp"]) != 0 || len(m["FreeText"]) != 1 || len(m["Widget"]) != 1 {
+		t.Fatalf("TestFlattenAnnotations: only the stamp should be flattened, got %v\n", m)
+	}
+
+	pageDict, _, err := ctx.PageDict(1)
+	if err != nil {
+		t.Fatalf("TestFlattenAnnotations: %v\n", err)
+	}
+
+	contents, err := ctx.DereferenceArray(pageDict["Contents"])
+	if err != nil || len(contents) < 3 {
+		t.Fatalf("TestFlattenAnnotations: unexpected page contents %v %v\n", pageDict["Contents"], err)
+	}
+
+	sd, err := ctx.DereferenceStreamDict(contents[len(contents)-1])
+	if err != nil {
+		t.Fatalf("TestFlattenAnnotations: %v\n", err)
+	}
+	if s := streamContent(t, sd); !strings.Contains(s, "/Fl0 Do") {
+		t.Fatalf("TestFlattenAnnotations: missing flattened appearance: %s\n", s)
+	}
+
+	// Flatten everything else including the form.
+	if err := FlattenAnnotations(outFile, outFile, []string{"1"}, nil, config); err != nil {
+		t.Fatalf("TestFlattenAnnotations: %v\n", err)
+	}
+
+	ctx = readAndValidateFile(t, outFile)
+
+	if m = pageAnnotations(t, ctx, 1); len(m) != 0 {
+		t.Fatalf("TestFlattenAnnotations: all annotations should be flattened, got %v\n", m)
+	}
+
+	if _, found := ctx.RootDict.Find("AcroForm"); found {
+		t.Fatal("TestFlattenAnnotations: the empty form should be removed\n")
+	}
+}
+
+func TestImportExportAnnotations(t *testing.T) {
+
+	fileName := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	outFile := filepath.Join(outDir, "importedAnnotations.pdf")
+	jsonIn := filepath.Join(outDir, "annotationsIn.json")
+	jsonOut := filepath.Join(outDir, "annotationsOut.json")
+
+	config := pdf.NewDefaultConfiguration()
+
+	writeJSON := func(s string) {
+		if err := ioutil.WriteFile(jsonIn, []byte(s), os.ModePerm); err != nil {
+			t.Fatalf("TestImportExportAnnotations: %v\n", err)
+		}
+	}
+
+	readJSON := func() annotationsFile {
+		bb, err := ioutil.ReadFile(jsonOut)
+		if err != nil {
+			t.Fatalf("TestImportExportAnnotations: %v\n", err)
+		}
+		var af annotationsFile
+		if err = json.Unmarshal(bb, &af); err != nil {
+			t.Fatalf("TestImportExportAnnotations: %v\n", err)
+		}
+		return af
+	}
+
+	writeJSON(`{"annotations":[
+		{"id":"c1","type":"Text","page":1,"rect":[50,700,70,720],"color":[1,0,0],"contents":"Fix typo","author":"Jane",
+		 "created":"2019-03-01T10:00:00Z","icon":"Comment"},
+		{"type":"Highlight","page":1,"rect":[72,600,300,612],"color":[1,1,0],"quadPoints":[72,612,300,612,72,600,300,600]}]}`)
+
+	if err := ImportAnnotations(fileName, jsonIn, outFile, config); err != nil {
+		t.Fatalf("TestImportExportAnnotations: %v\n", err)
+	}
+
+	if err := ExportAnnotations(outFile, jsonOut, nil, config); err != nil {
+		t.Fatalf("TestImportExportAnnotations: %v\n", err)
+	}
+
+	a := readJSON().Annotations
+	if len(a) != 2 {
+		t.Fatalf("TestImportExportAnnotations: want 2 annotations, got %d\n", len(a))
+	}
+
+	c := a[0]
+	if c.ID != "c1" || c.Type != "Text" || c.Contents != "Fix typo" || c.Author != "Jane" || c.Icon != "Comment" {
+		t.Fatalf("TestImportExportAnnotations: unexpected annotation %+v\n", c)
+	}
+
+	if c.Created == nil || !c.Created.Equal(time.Date(2019, 3, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Fatalf("TestImportExportAnnotations: unexpected creation date %v\n", c.Created)
+	}
+
+	if c.Rect != [4]float64{50, 700, 70, 720} || len(a[1].QuadPoints) != 8 {
+		t.Fatalf("TestImportExportAnnotations: unexpected geometry %v %v\n", c.Rect, a[1].QuadPoints)
+	}
+
+	// Reimporting an annotation with a known id updates the annotation.
+	// Text beyond Latin-1 gets encoded as UTF-16, the modification date is taken from the configured clock.
+	now := time.Date(2020, 5, 6, 7, 8, 9, 0, time.UTC)
+	config.Now = func() time.Time { return now }
+
+	writeJSON(`{"annotations":[{"id":"c1","type":"Text","page":1,"rect":[50,700,70,720],"contents":"Done ✓"}]}`)
+
+	if err := ImportAnnotations(outFile, jsonIn, outFile, config); err != nil {
+		t.Fatalf("TestImportExportAnnotations: %v\n", err)
+	}
+
+	if err := ExportAnnotations(outFile, jsonOut, []string{"1"}, config); err != nil {
+		t.Fatalf("TestImportExportAnnotations: %v\n", err)
+	}
+
+	a = readJSON().Annotations
+	if len(a) != 2 || a[0].Contents != "Done ✓" {
+		t.Fatalf("TestImportExportAnnotations: unexpected annotations %+v\n", a)
+	}
+
+	if a[0].Modified == nil || !a[0].Modified.Equal(now) {
+		t.Fatalf("TestImportExportAnnotations: unexpected modification date %v\n", a[0].Modified)
+	}
+
+	writeJSON(`{"annotations":[{"type":"Highlight","page":1,"rect":[72,600,300,612]}]}`)
+	if err := ImportAnnotations(outFile, jsonIn, outFile, config); err == nil {
+		t.Fatal("TestImportExportAnnotations: highlights without quadPoints should fail\n")
+	}
+}
+
+func TestCommentSummary(t *testing.T) {
+
+	fileName := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	annotFile := filepath.Join(outDir, "comments.pdf")
+	jsonFile := filepath.Join(outDir, "comments.json")
+	csvFile := filepath.Join(outDir, "comments.csv")
+	outFile := filepath.Join(outDir, "commentSummary.pdf")
+
+	config := pdf.NewDefaultConfiguration()
+
+	if err := ioutil.WriteFile(jsonFile, []byte(`{"annotations":[
+		{"type":"Highlight","page":1,"rect":[209,608,346,624],"contents":"Check title","author":"QA",
+		 "quadPoints":[209,624,346,624,209,608,346,608]},
+		{"type":"Text","page":2,"rect":[50,700,70,720],"contents":"Nice","author":"Jane"}]}`), os.ModePerm); err != nil {
+		t.Fatalf("TestCommentSummary: %v\n", err)
+	}
+
+	if err := ImportAnnotations(fileName, jsonFile, annotFile, config); err != nil {
+		t.Fatalf("TestCommentSummary: %v\n", err)
+	}
+
+	comments, err := Comments(annotFile, nil, config)
+	if err != nil {
+		t.Fatalf("TestCommentSummary: %v\n", err)
+	}
+
+	if len(comments) != 2 {
+		t.Fatalf("TestCommentSummary: want 2 comments, got %d\n", len(comments))
+	}
+
+	if c := comments[0]; c.Quote != "Adobe Developer Support" || c.Text != "Check title" || c.Author != "QA" || c.Date == nil {
+		t.Fatalf("TestCommentSummary: unexpected comment %+v\n", c)
+	}
+
+	if c := comments[1]; c.Page != 2 || c.Quote != "" || c.Text != "Nice" {
+		t.Fatalf("TestCommentSummary: unexpected comment %+v\n", c)
+	}
+
+	if err := ExportComments(annotFile, csvFile, nil, config); err != nil {
+		t.Fatalf("TestCommentSummary: %v\n", err)
+	}
+
+	bb, err := ioutil.ReadFile(csvFile)
+	if err != nil {
+		t.Fatalf("TestCommentSummary: %v\n", err)
+	}
+
+	if lines := strings.Split(strings.TrimSpace(string(bb)), "\n"); len(lines) != 3 || !strings.HasPrefix(lines[1], "1,Highlight,QA,") {
+		t.Fatalf("TestCommentSummary: unexpected csv\n%s\n", bb)
+	}
+
+	if err := ExportComments(annotFile, filepath.Join(outDir, "comments.txt"), nil, config); err == nil {
+		t.Fatal("TestCommentSummary: unsupported formats should fail\n")
+	}
+
+	ctx := readAndValidateFile(t, annotFile)
+	pageCount := ctx.PageCount
+
+	if err := AddCommentSummary(annotFile, outFile, nil, config); err != nil {
+		t.Fatalf("TestCommentSummary: %v\n", err)
+	}
+
+	ctx = readAndValidateFile(t, outFile)
+	if ctx.PageCount != pageCount+1 {
+		t.Fatalf("TestCommentSummary: want %d pages, got %d\n", pageCount+1, ctx.PageCount)
+	}
+
+	pageDict, _, err := ctx.PageDict(ctx.PageCount)
+	if err != nil {
+		t.Fatalf("TestCommentSummary: %v\n", err)
+	}
+
+	sd, err := ctx.DereferenceStreamDict(pageDict["Contents"])
+	if err != nil {
+		t.Fatalf("TestCommentSummary: %v\n", err)
+	}
+
+	if s := streamContent(t, sd); !strings.Contains(s, "(\"Adobe Developer Support\") Tj") {
+		t.Fatalf("TestCommentSummary: unexpected summary page content %s\n", s)
+	}
+}
+
+func TestMeasureAnnotations(t *testing.T) {
+
+	fileName := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	outFile := filepath.Join(outDir, "measureAnnotations.pdf")
+	optFile := filepath.Join(outDir, "measureAnnotationsOptimized.pdf")
+
+	config := pdf.NewDefaultConfiguration()
+
+	// 1 in = 4 ft
+	m := pdf.Measure{Ratio: "1 in = 4 ft", Unit: "ft", Factor: 4. / 72}
+
+	annots := []pdf.MeasureAnnotation{
+		{Page: 1, Type: "Line", Points: []types.Point{{X: 100, Y: 100}, {X: 244, Y: 100}}, Measure: m, Color: pdf.RGBColor{R: 1}},
+		{Page: 1, Type: "Polygon", Points: []types.Point{{X: 100, Y: 200}, {X: 172, Y: 200}, {X: 172, Y: 272}, {X: 100, Y: 272}}, Measure: m},
+		{Page: 1, Type: "PolyLine", Points: []types.Point{{X: 300, Y: 100}, {X: 300, Y: 172}, {X: 372, Y: 172}}, Measure: m, Contents: "Wall"},
+	}
+
+	if err := AddMeasureAnnotations(fileName, outFile, annots, config); err != nil {
+		t.Fatalf("TestMeasureAnnotations: %v\n", err)
+	}
+
+	// Measure dicts need to survive optimization.
+	if _, err := Process(OptimizeCommand(outFile, optFile, config)); err != nil {
+		t.Fatalf("TestMeasureAnnotations: %v\n", err)
+	}
+
+	got, err := MeasureAnnotations(optFile, nil, config)
+	if err != nil {
+		t.Fatalf("TestMeasureAnnotations: %v\n", err)
 	}
 
-	// Decrypt wrong upw
-	t.Log("Decrypt wrong upw fails")
-	config = pdf.NewDefaultConfiguration()
-	config.UserPW = "upw"
-	_, err = Process(DecryptCommand(outFile, outFile, config))
-	if err == nil {
-		t.Fatalf("TestEncryptUPWOnly - %s decrypt using wrong upw should fail\n", outFile)
+	if len(got) != len(annots) {
+		t.Fatalf("TestMeasureAnnotations: want %d measure annotations, got %d\n", len(annots), len(got))
 	}
 
-	// Decrypt upw
-	t.Log("Decrypt upw")
-	config = pdf.NewDefaultConfiguration()
-	config.UserPW = "upwNew"
-	_, err = Process(DecryptCommand(outFile, outFile, config))
+	for i, want := range []string{"8.00 ft", "16.00 sq ft", "8.00 ft"} {
+		if l := got[i].Label(); l != want {
+			t.Fatalf("TestMeasureAnnotations: %s: want %s, got %s\n", got[i].Type, want, l)
+		}
+	}
+
+	if got[0].Measure.Ratio != "1 in = 4 ft" || got[0].Contents != "8.00 ft" || got[0].Color.R != 1 {
+		t.Fatalf("TestMeasureAnnotations: unexpected line %+v\n", got[0])
+	}
+
+	if got[2].Contents != "Wall" || len(got[2].Points) != 3 {
+		t.Fatalf("TestMeasureAnnotations: unexpected polyline %+v\n", got[2])
+	}
+
+	bad := []pdf.MeasureAnnotation{{Page: 1, Type: "Polygon", Points: annots[0].Points, Measure: m}}
+	if err := AddMeasureAnnotations(fileName, outFile, bad, config); err == nil {
+		t.Fatal("TestMeasureAnnotations: polygons need at least 3 vertices\n")
+	}
+}
+
+func TestExportPageText(t *testing.T) {
+
+	fileName := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	config := pdf.NewDefaultConfiguration()
+
+	for _, format := range []string{"md", "html"} {
+		if err := ExportPageText(fileName, outDir, []string{"1"}, format, config); err != nil {
+			t.Fatalf("TestExportPageText(%s): %v\n", format, err)
+		}
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(outDir, "5116.DCT_Filter_1.md"))
 	if err != nil {
-		t.Fatalf("TestEncryptUPWOnly - %s decrypt using upw: %v\n", outFile, err)
+		t.Fatalf("TestExportPageText: %v\n", err)
+	}
+	if !strings.Contains(string(b), "# Supporting the DCT Filters\n") {
+		t.Fatalf("TestExportPageText: missing title heading:\n%s\n", b)
+	}
+
+	b, err = ioutil.ReadFile(filepath.Join(outDir, "5116.DCT_Filter_1.html"))
+	if err != nil {
+		t.Fatalf("TestExportPageText: %v\n", err)
+	}
+	for _, s := range []string{"<h1>Supporting the DCT Filters</h1>", "<p>Adobe Systems Incorporated</p>"} {
+		if !strings.Contains(string(b), s) {
+			t.Fatalf("TestExportPageText: missing %s:\n%s\n", s, b)
+		}
 	}
 
+	if err := ExportPageText(fileName, outDir, nil, "txt", config); err == nil {
+		t.Fatal("TestExportPageText: unsupported format should fail\n")
+	}
 }
 
-func TestEncryptOPWOnly(t *testing.T) {
+func TestTextSpans(t *testing.T) {
 
-	// Test for setting only the owner password.
+	fileName := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	config := pdf.NewDefaultConfiguration()
 
-	t.Log("running TestEncryptOPWOnly..")
+	spans, err := TextSpans(fileName, []string{"1"}, config)
+	if err != nil {
+		t.Fatalf("TestTextSpans: %v\n", err)
+	}
 
-	inFile := filepath.Join(inDir, "5116.DCT_Filter.pdf")
-	outFile := filepath.Join(outDir, "test.pdf")
+	var title *pdf.TextSpan
+	for i, ts := range spans {
+		if ts.PageNr != 1 || ts.Font == "" || ts.Size <= 0 || ts.Rect[0] >= ts.Rect[2] || ts.Rect[1] >= ts.Rect[3] {
+			t.Fatalf("TestTextSpans: unexpected span %+v\n", ts)
+		}
+		if strings.Contains(ts.Text, "Supporting the DCT Filters") {
+			title = &spans[i]
+		}
+	}
 
-	// Encrypt opw only
-	t.Log("Encrypt opw only")
+	if title == nil {
+		t.Fatalf("TestTextSpans: missing title in %d spans\n", len(spans))
+	}
+
+	// The title is set larger than the body text.
+	for _, ts := range spans {
+		if ts.Text == "Adobe Systems Incorporated" && ts.Size >= title.Size {
+			t.Fatalf("TestTextSpans: title size %.2f, body size %.2f\n", title.Size, ts.Size)
+		}
+	}
+
+	if err = ExportPageText(fileName, outDir, []string{"1"}, "json", config); err != nil {
+		t.Fatalf("TestTextSpans: %v\n", err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(outDir, "5116.DCT_Filter_1.json"))
+	if err != nil {
+		t.Fatalf("TestTextSpans: %v\n", err)
+	}
+
+	var ss []pdf.TextSpan
+	if err = json.Unmarshal(b, &ss); err != nil {
+		t.Fatalf("TestTextSpans: %v\n", err)
+	}
+
+	if len(ss) != len(spans) || ss[0] != spans[0] {
+		t.Fatalf("TestTextSpans: JSON holds %d spans, want %d\n", len(ss), len(spans))
+	}
+
+	ctx := readAndValidateFile(t, fileName)
+	if _, err = ExtractTextSpansFromContext(ctx, ctx.PageCount+1); !errors.Is(err, pdf.ErrPageOutOfRange) {
+		t.Fatalf("TestTextSpans: want ErrPageOutOfRange, got %v\n", err)
+	}
+}
+
+func TestRenderPages(t *testing.T) {
+
+	dirOut := filepath.Join(outDir, "render")
+	if err := os.MkdirAll(dirOut, 0755); err != nil {
+		t.Fatalf("TestRenderPages: %v\n", err)
+	}
+
+	fileName := filepath.Join(inDir, "5116.DCT_Filter.pdf")
 	config := pdf.NewDefaultConfiguration()
-	config.OwnerPW = "opw"
-	_, err := Process(EncryptCommand(inFile, outFile, config))
+
+	files, err := RenderPages(fileName, dirOut, []string{"1"}, RenderPNG, 36, config)
 	if err != nil {
-		t.Fatalf("TestEncryptOPWOnly - encrypt with opw only to %s: %v\n", outFile, err)
+		t.Fatalf("TestRenderPages: %v\n", err)
 	}
 
-	// Validate wrong opw succeeds with fallback to empty upw
-	t.Log("Validate wrong opw succeeds with fallback to empty upw")
-	config = pdf.NewDefaultConfiguration()
-	config.OwnerPW = "opwWrong"
-	_, err = Process(ValidateCommand(outFile, config))
+	if len(files) != 1 || filepath.Base(files[0]) != "5116.DCT_Filter_1.png" {
+		t.Fatalf("TestRenderPages: unexpected files %v\n", files)
+	}
+
+	f, err := os.Open(files[0])
 	if err != nil {
-		t.Fatalf("TestEncryptOPWOnly - validate %s using wrong opw succeeds falling back to empty upw!: %v\n", outFile, err)
+		t.Fatalf("TestRenderPages: %v\n", err)
 	}
+	defer f.Close()
 
-	// Validate opw
-	t.Log("Validate opw")
-	config = pdf.NewDefaultConfiguration()
-	config.OwnerPW = "opw"
-	_, err = Process(ValidateCommand(outFile, config))
+	img, err := png.Decode(f)
 	if err != nil {
-		t.Fatalf("TestEncryptOPWOnly - validate %s using opw: %v\n", outFile, err)
+		t.Fatalf("TestRenderPages: %v\n", err)
 	}
 
-	// Validate wrong upw
-	t.Log("Validate wrong upw fails")
-	config = pdf.NewDefaultConfiguration()
-	config.UserPW = "upwWrong"
-	_, err = Process(ValidateCommand(outFile, config))
-	if err == nil {
-		t.Fatalf("TestEncryptOPWOnly - validate %s using wrong upw should fail!\n", outFile)
+	// A letter size page at half the resolution of default user space.
+	if b := img.Bounds(); b.Dx() != 306 || b.Dy() != 396 {
+		t.Fatalf("TestRenderPages: unexpected size %v\n", b)
 	}
 
-	// Validate no pw using empty upw
-	t.Log("Validate no pw using empty upw")
-	config = pdf.NewDefaultConfiguration()
-	_, err = Process(ValidateCommand(outFile, config))
+	dark := 0
+	for y := 0; y < 396; y++ {
+		for x := 0; x < 306; x++ {
+			if r, _, _, _ := img.At(x, y).RGBA(); r < 0x8000 {
+				dark++
+			}
+		}
+	}
+	if dark == 0 {
+		t.Fatal("TestRenderPages: blank page\n")
+	}
+
+	// The cover image fills the page.
+	files, err = RenderPages(filepath.Join(inDir, "BuildingWebappsWithGo.pdf"), dirOut, []string{"1"}, RenderJPG, 36, config)
 	if err != nil {
-		t.Fatalf("TestEncryptOPWOnly - validate %s no pw using empty upw: %v\n", outFile, err)
+		t.Fatalf("TestRenderPages: %v\n", err)
 	}
 
-	// Optimize wrong opw, succeeds with fallback to empty upw
-	t.Log("Optimize wrong opw succeeds with fallback to empty upw")
-	config = pdf.NewDefaultConfiguration()
-	config.OwnerPW = "opwWrong"
-	_, err = Process(OptimizeCommand(outFile, outFile, config))
+	b, err := ioutil.ReadFile(files[0])
 	if err != nil {
-		t.Fatalf("TestEncryptOPWOnly - optimize %s using wrong opw succeeds falling back to empty upw: %v\n", outFile, err)
+		t.Fatalf("TestRenderPages: %v\n", err)
 	}
 
-	// Optimize opw
-	t.Log("Optimize opw")
-	config = pdf.NewDefaultConfiguration()
-	config.OwnerPW = "opw"
-	_, err = Process(OptimizeCommand(outFile, outFile, config))
+	img, _, err = image.Decode(bytes.NewReader(b))
 	if err != nil {
-		t.Fatalf("TestEncryptOPWOnly - optimize %s using opw: %v\n", outFile, err)
+		t.Fatalf("TestRenderPages: %v\n", err)
 	}
 
-	// Optimize wrong upw
-	t.Log("Optimize wrong upw fails")
-	config = pdf.NewDefaultConfiguration()
-	config.UserPW = "upwWrong"
-	_, err = Process(OptimizeCommand(outFile, outFile, config))
-	if err == nil {
-		t.Fatalf("TestEncryptOPWOnly - optimize %s using wrong upw should fail!\n", outFile)
+	if r, g, b, _ := img.At(5, 5).RGBA(); r > 0xf000 && g > 0xf000 && b > 0xf000 {
+		t.Fatal("TestRenderPages: missing cover image\n")
 	}
 
-	// Optimize empty upw
-	t.Log("Optimize empty upw")
-	config = pdf.NewDefaultConfiguration()
-	config.UserPW = ""
-	_, err = Process(OptimizeCommand(outFile, outFile, config))
+	// Page rotation swaps width and height.
+	ctx := readAndValidateFile(t, fileName)
+
+	d, _, err := ctx.PageDict(1)
 	if err != nil {
-		t.Fatalf("TestEncryptOPWOnly - optimize %s using upw: %v\n", outFile, err)
+		t.Fatalf("TestRenderPages: %v\n", err)
 	}
+	d.Update("Rotate", pdf.Integer(90))
 
-	// Change opw wrong upw
-	t.Log("ChangeOwnerPW wrong upw fails")
-	config = pdf.NewDefaultConfiguration()
-	config.UserPW = "upw"
-	pwOld := "opw"
-	pwNew := "opwNew"
-	_, err = Process(ChangeOwnerPWCommand(outFile, outFile, config, &pwOld, &pwNew))
-	if err == nil {
-		t.Fatalf("TestEncryptOPWOnly - %s change opw using wrong upw should fail\n", outFile)
+	rendered, err := RenderPageFromContext(ctx, 1, 36)
+	if err != nil {
+		t.Fatalf("TestRenderPages: %v\n", err)
 	}
 
-	// Change opw wrong opwOld
-	t.Log("ChangeOwnerPW wrong opwOld fails")
-	config = pdf.NewDefaultConfiguration()
-	config.UserPW = ""
-	pwOld = "opwOldWrong"
-	pwNew = "opwNew"
-	_, err = Process(ChangeOwnerPWCommand(outFile, outFile, config, &pwOld, &pwNew))
-	if err == nil {
-		t.Fatalf("TestEncryptOPWOnly - %s change opw using wrong opwOld should fail\n", outFile)
+	if b := rendered.Bounds(); b.Dx() != 396 || b.Dy() != 306 {
+		t.Fatalf("TestRenderPages: unexpected size of rotated page %v\n", b)
 	}
 
-	// Change opw
-	t.Log("ChangeOwnerPW")
-	config = pdf.NewDefaultConfiguration()
-	config.UserPW = ""
-	pwOld = "opw"
-	pwNew = "opwNew"
-	_, err = Process(ChangeOwnerPWCommand(outFile, outFile, config, &pwOld, &pwNew))
+	if _, err = RenderPageFromContext(ctx, ctx.PageCount+1, 36); !errors.Is(err, pdf.ErrPageOutOfRange) {
+		t.Fatalf("TestRenderPages: want ErrPageOutOfRange, got %v\n", err)
+	}
+
+	if _, err = RenderPageFromContext(ctx, 1, 0); err == nil {
+		t.Fatal("TestRenderPages: invalid resolution should fail\n")
+	}
+
+	if _, err = RenderPages(fileName, dirOut, nil, "gif", 72, config); err == nil {
+		t.Fatal("TestRenderPages: unsupported format should fail\n")
+	}
+}
+
+func TestExtractText(t *testing.T) {
+
+	fileName := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	dirOut := filepath.Join(outDir, "text")
+	config := pdf.NewDefaultConfiguration()
+
+	if err := os.MkdirAll(dirOut, 0755); err != nil {
+		t.Fatalf("TestExtractText: %v\n", err)
+	}
+
+	files, err := Process(ExtractTextCommand(fileName, dirOut, []string{"1-2"}, config))
 	if err != nil {
-		t.Fatalf("TestEncryptOPWOnly - %s change opw: %v\n", outFile, err)
+		t.Fatalf("TestExtractText: %v\n", err)
 	}
 
-	// Decrypt wrong upw
-	t.Log("Decrypt wrong upw fails")
-	config = pdf.NewDefaultConfiguration()
-	config.UserPW = "upwWrong"
-	_, err = Process(DecryptCommand(outFile, outFile, config))
-	if err == nil {
-		t.Fatalf("TestEncryptOPWOnly - %s decrypt using wrong upw should fail \n", outFile)
+	if len(files) != 2 || filepath.Base(files[0]) != "5116.DCT_Filter_1.txt" {
+		t.Fatalf("TestExtractText: unexpected files %v\n", files)
+	}
+
+	b, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("TestExtractText: %v\n", err)
+	}
+
+	ctx := readAndValidateFile(t, fileName)
+
+	s, err := ExtractTextFromContext(ctx, 1)
+	if err != nil {
+		t.Fatalf("TestExtractText: %v\n", err)
+	}
+
+	if s != string(b) {
+		t.Fatal("TestExtractText: file and context text differ\n")
+	}
+
+	for _, want := range []string{"Supporting the DCT Filters", "Adobe Systems Incorporated"} {
+		if !strings.Contains(s, want) {
+			t.Fatalf("TestExtractText: missing %q:\n%s\n", want, s)
+		}
+	}
+
+	if _, err = ExtractTextFromContext(ctx, ctx.PageCount+1); err == nil {
+		t.Fatal("TestExtractText: want error for invalid page\n")
+	}
+}
+
+func TestExportOCRText(t *testing.T) {
+
+	fileName := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	hocrFile := filepath.Join(outDir, "5116.hocr")
+	altoFile := filepath.Join(outDir, "5116.xml")
+	config := pdf.NewDefaultConfiguration()
+
+	if err := ExportOCRText(fileName, hocrFile, []string{"1-2"}, "hocr", config); err != nil {
+		t.Fatalf("TestExportOCRText(hocr): %v\n", err)
+	}
+
+	b, err := ioutil.ReadFile(hocrFile)
+	if err != nil {
+		t.Fatalf("TestExportOCRText: %v\n", err)
+	}
+	if strings.Count(string(b), `class="ocr_page"`) != 2 || !strings.Contains(string(b), ">Supporting</span>") {
+		t.Fatalf("TestExportOCRText: unexpected hOCR:\n%s\n", b)
+	}
+
+	if err := ExportOCRText(fileName, altoFile, []string{"1"}, "alto", config); err != nil {
+		t.Fatalf("TestExportOCRText(alto): %v\n", err)
+	}
+
+	if b, err = ioutil.ReadFile(altoFile); err != nil {
+		t.Fatalf("TestExportOCRText: %v\n", err)
+	}
+
+	var alto struct {
+		Pages []struct {
+			Width  int `xml:"WIDTH,attr"`
+			Height int `xml:"HEIGHT,attr"`
+			Words  []struct {
+				Content string `xml:"CONTENT,attr"`
+				HPos    int    `xml:"HPOS,attr"`
+				VPos    int    `xml:"VPOS,attr"`
+			} `xml:"PrintSpace>TextBlock>TextLine>String"`
+		} `xml:"Layout>Page"`
+	}
+
+	if err = xml.Unmarshal(b, &alto); err != nil {
+		t.Fatalf("TestExportOCRText: invalid ALTO: %v\n", err)
+	}
+
+	if len(alto.Pages) != 1 || len(alto.Pages[0].Words) == 0 {
+		t.Fatalf("TestExportOCRText: unexpected ALTO:\n%s\n", b)
+	}
+
+	p := alto.Pages[0]
+	for _, w := range p.Words {
+		if w.Content == "" || w.HPos < 0 || w.HPos > p.Width || w.VPos < 0 || w.VPos > p.Height {
+			t.Fatalf("TestExportOCRText: word %+v outside of page\n", w)
+		}
+	}
+
+	if err := ExportOCRText(fileName, altoFile, nil, "pdf", config); err == nil {
+		t.Fatal("TestExportOCRText: unsupported format should fail\n")
+	}
+}
+
+func TestWordFrequencies(t *testing.T) {
+
+	fileName := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	config := pdf.NewDefaultConfiguration()
+
+	ws, err := WordFrequencies(fileName, []string{"1-2"}, config)
+	if err != nil {
+		t.Fatalf("TestWordFrequencies: %v\n", err)
+	}
+
+	if len(ws.Pages) != 2 || ws.Pages[0].Page != 1 || ws.Pages[1].Page != 2 {
+		t.Fatalf("TestWordFrequencies: unexpected pages: %+v\n", ws.Pages)
+	}
+
+	if ws.Pages[0].Counts["adobe"] < 3 || ws.Pages[0].Counts["dct"] == 0 {
+		t.Fatalf("TestWordFrequencies: unexpected page 1 counts: %v\n", ws.Pages[0].Counts)
+	}
+
+	n := 0
+	for _, ps := range ws.Pages {
+		n += ps.Words
+	}
+	if n != ws.Words || ws.Counts["adobe"] < ws.Pages[0].Counts["adobe"] {
+		t.Fatalf("TestWordFrequencies: document stats do not add up\n")
+	}
+
+	top := ws.Top(3)
+	if len(top) != 3 || top[0].Count < top[1].Count || top[1].Count < top[2].Count {
+		t.Fatalf("TestWordFrequencies: unexpected top words: %v\n", top)
+	}
+
+	if got := pdf.Words("Docu-\nment, DOCUMENT's"); strings.Join(got, " ") != "document document's" {
+		t.Fatalf("TestWordFrequencies: unexpected words: %v\n", got)
+	}
+}
+
+func xmpMetadata(t *testing.T, ctx *pdf.Context) string {
+	t.Helper()
+
+	rootDict, err := ctx.Catalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sd, err := ctx.DereferenceStreamDict(rootDict["Metadata"])
+	if err != nil || sd == nil {
+		t.Fatalf("missing metadata: %v\n", err)
+	}
+
+	return streamContent(t, sd)
+}
+
+func TestLanguage(t *testing.T) {
+
+	for s, want := range map[string]string{
+		"The quick brown fox jumps over the lazy dog and it was not the first time that this happened.": "en",
+		"Der schnelle braune Fuchs springt über den faulen Hund und das ist nicht das erste Mal.":       "de",
+		"Le renard brun rapide saute par-dessus le chien paresseux et ce n'est pas la première fois.":   "fr",
+		"Η γρήγορη καφέ αλεπού πηδάει πάνω από τον τεμπέλη σκύλο.":                                      "el",
+		"素早い茶色の狐がのろまな犬を飛び越える。":                                                                          "ja",
+		"1234 5678": "",
+	} {
+		if got := pdf.DetectLanguage(s); got != want {
+			t.Fatalf("TestLanguage: %q: want %q, got %q\n", s, want, got)
+		}
+	}
+
+	fileName := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	outFile := filepath.Join(outDir, "language.pdf")
+	outFile2 := filepath.Join(outDir, "language2.pdf")
+	config := pdf.NewDefaultConfiguration()
+
+	l, err := DetectLanguages(fileName, []string{"1-3"}, config)
+	if err != nil {
+		t.Fatalf("TestLanguage: %v\n", err)
+	}
+	if l.Lang != "en" || len(l.Pages) != 3 || l.Pages[2] != "en" {
+		t.Fatalf("TestLanguage: unexpected languages: %+v\n", l)
+	}
+
+	// Detect and set.
+	if err := SetLanguage(fileName, outFile, "", config); err != nil {
+		t.Fatalf("TestLanguage: %v\n", err)
+	}
+
+	ctx := readAndValidateFile(t, outFile)
+	rootDict, _ := ctx.Catalog()
+	if lang := rootDict.StringEntry("Lang"); lang == nil || *lang != "en" {
+		t.Fatalf("TestLanguage: unexpected /Lang: %v\n", lang)
+	}
+	if s := xmpMetadata(t, ctx); !strings.Contains(s, "<dc:language><rdf:Bag><rdf:li>en</rdf:li></rdf:Bag></dc:language>") {
+		t.Fatalf("TestLanguage: unexpected XMP:\n%s\n", s)
 	}
 
-	// Decrypt wrong opw succeeds because of fallback to empty upw.
-	t.Log("Decrypt wrong opw succeeds because of fallback to empty upw")
-	config = pdf.NewDefaultConfiguration()
-	config.OwnerPW = "opw"
-	_, err = Process(DecryptCommand(outFile, outFile, config))
-	if err != nil {
-		t.Fatalf("TestEncryptOPWOnly - %s decrypt using opw: %v\n", outFile, err)
+	// Override, existing XMP metadata gets patched.
+	if err := SetLanguage(outFile, outFile2, "de-CH", config); err != nil {
+		t.Fatalf("TestLanguage: %v\n", err)
 	}
 
-}
+	ctx = readAndValidateFile(t, outFile2)
+	rootDict, _ = ctx.Catalog()
+	if lang := rootDict.StringEntry("Lang"); lang == nil || *lang != "de-CH" {
+		t.Fatalf("TestLanguage: unexpected /Lang: %v\n", lang)
+	}
+	if s := xmpMetadata(t, ctx); strings.Count(s, "<dc:language>") != 1 || !strings.Contains(s, "<rdf:li>de-CH</rdf:li>") {
+		t.Fatalf("TestLanguage: unexpected XMP:\n%s\n", s)
+	}
 
-func TestEncrypt(t *testing.T) {
+	// XMP metadata that cannot be patched is left untouched.
+	ir := rootDict.IndirectRefEntry("Metadata")
+	entry, found := ctx.FindTableEntryForIndRef(ir)
+	if !found {
+		t.Fatalf("TestLanguage: missing metadata\n")
+	}
+	bogus := []byte("<x:xmpmeta/>")
+	entry.Object = pdf.StreamDict{Dict: pdf.Dict(map[string]pdf.Object{"Type": pdf.Name("Metadata")}), Content: bogus, Raw: bogus}
 
-	t.Log("running TestEncrypt..")
+	if err := pdf.SetLanguage(ctx.XRefTable, "fr"); err != nil {
+		t.Fatalf("TestLanguage: %v\n", err)
+	}
+	if lang := rootDict.StringEntry("Lang"); lang == nil || *lang != "fr" {
+		t.Fatalf("TestLanguage: unexpected /Lang: %v\n", lang)
+	}
+	if ir1 := rootDict.IndirectRefEntry("Metadata"); ir1 == nil || !ir1.Equals(*ir) {
+		t.Fatalf("TestLanguage: metadata replaced\n")
+	}
+	if s := xmpMetadata(t, ctx); s != string(bogus) {
+		t.Fatalf("TestLanguage: unexpected XMP:\n%s\n", s)
+	}
 
-	inFile := filepath.Join(inDir, "5116.DCT_Filter.pdf")
-	outFile := filepath.Join(outDir, "test.pdf")
+	if err := SetLanguage(fileName, outFile, "not a tag", config); err == nil {
+		t.Fatal("TestLanguage: invalid language tag should fail\n")
+	}
+}
 
-	// Encrypt opw and upw
-	t.Log("Encrypt")
+func TestAssociatedFiles(t *testing.T) {
+
+	fileName := filepath.Join(inDir, "5116.DCT_Filter.pdf")
+	outFile := filepath.Join(outDir, "associatedFiles.pdf")
+	optFile := filepath.Join(outDir, "associatedFilesOptimized.pdf")
 	config := pdf.NewDefaultConfiguration()
-	config.UserPW = "upw"
-	config.OwnerPW = "opw"
-	_, err := Process(EncryptCommand(inFile, outFile, config))
-	if err != nil {
-		t.Fatalf("TestEncrypt - encrypt to %s: %v\n", outFile, err)
+
+	// Associate a file with the first font dict too.
+	ctx := readAndValidateFile(t, fileName)
+	objNr := 0
+	for nr, entry := range ctx.Table {
+		if d, ok := entry.Object.(pdf.Dict); ok && d.Type() != nil && *d.Type() == "Font" && (objNr == 0 || nr < objNr) {
+			objNr = nr
+		}
+	}
+	if objNr == 0 {
+		t.Fatal("TestAssociatedFiles: missing font\n")
 	}
 
-	// Validate wrong opw
-	t.Log("Validate wrong opw fails")
-	config = pdf.NewDefaultConfiguration()
-	config.OwnerPW = "opwWrong"
-	_, err = Process(ValidateCommand(outFile, config))
-	if err == nil {
-		t.Fatalf("TestEncrypt - validate %s using wrong opw should fail!\n", outFile)
+	files := []pdf.AssociatedFile{
+		{FileName: filepath.Join(resDir, "test.wav"), Relationship: "Source", Description: "Original recording"},
+		{FileName: filepath.Join(resDir, "pdfchip3.png"), Relationship: "Alternative", Page: 1},
+		{FileName: filepath.Join(resDir, "pdfchip3.png"), Relationship: "Data", ObjNr: objNr},
 	}
 
-	// Validate opw
-	t.Log("Validate opw")
-	config = pdf.NewDefaultConfiguration()
-	config.OwnerPW = "opw"
-	_, err = Process(ValidateCommand(outFile, config))
-	if err != nil {
-		t.Fatalf("TestEncrypt - validate %s using opw: %v\n", outFile, err)
+	if err := AddAssociatedFiles(fileName, outFile, files, config); err != nil {
+		t.Fatalf("TestAssociatedFiles: %v\n", err)
 	}
 
-	// Validate wrong upw
-	t.Log("Validate wrong upw fails")
-	config = pdf.NewDefaultConfiguration()
-	config.UserPW = "upwWrong"
-	_, err = Process(ValidateCommand(outFile, config))
-	if err == nil {
-		t.Fatalf("TestEncrypt - validate %s using wrong upw should fail!\n", outFile)
+	// Associations need to survive optimization.
+	if _, err := Process(OptimizeCommand(outFile, optFile, config)); err != nil {
+		t.Fatalf("TestAssociatedFiles: %v\n", err)
 	}
 
-	// Validate upw
-	t.Log("Validate upw")
-	config = pdf.NewDefaultConfiguration()
-	config.UserPW = "upw"
-	_, err = Process(ValidateCommand(outFile, config))
+	list, err := AssociatedFiles(optFile, config)
 	if err != nil {
-		t.Fatalf("TestEncrypt - validate %s using upw: %v\n", outFile, err)
+		t.Fatalf("TestAssociatedFiles: %v\n", err)
 	}
 
-	// Change upw to "" = remove document open password.
-	t.Log("ChangeUserPW to \"\"")
-	config = pdf.NewDefaultConfiguration()
-	config.OwnerPW = "opw"
-	pwOld := "upw"
-	pwNew := ""
-	_, err = Process(ChangeUserPWCommand(outFile, outFile, config, &pwOld, &pwNew))
-	if err != nil {
-		t.Fatalf("TestEncrypt - %s change userPW to \"\": %v\n", outFile, err)
+	if len(list) != 3 {
+		t.Fatalf("TestAssociatedFiles: want 3 associated files, got %d\n", len(list))
 	}
 
-	// Validate upw
-	t.Log("Validate upw")
-	config = pdf.NewDefaultConfiguration()
-	config.UserPW = ""
-	_, err = Process(ValidateCommand(outFile, config))
-	if err != nil {
-		t.Fatalf("TestEncrypt - validate %s using upw: %v\n", outFile, err)
+	want := []pdf.AssociatedFile{
+		{FileName: "test.wav", Relationship: "Source"},
+		{FileName: "pdfchip3.png", Relationship: "Alternative", Page: 1},
+		{FileName: "pdfchip3.png", Relationship: "Data"},
+	}
+	for i, af := range list {
+		if i == 2 {
+			if af.ObjNr == 0 {
+				t.Fatalf("TestAssociatedFiles: missing object association: %+v\n", af)
+			}
+			af.ObjNr = 0
+		}
+		if af.FileName != want[i].FileName || af.Relationship != want[i].Relationship || af.Page != want[i].Page {
+			t.Fatalf("TestAssociatedFiles: want %+v, got %+v\n", want[i], af)
+		}
+	}
+	if list[0].Description != "Original recording" {
+		t.Fatalf("TestAssociatedFiles: unexpected description: %s\n", list[0].Description)
 	}
 
-	// Validate no pw
-	t.Log("Validate upw")
-	config = pdf.NewDefaultConfiguration()
-	_, err = Process(ValidateCommand(outFile, config))
+	// Document level associated files are attachments too.
+	attachments, err := ListAttachments(optFile, config)
 	if err != nil {
-		t.Fatalf("TestEncrypt - validate %s: %v\n", outFile, err)
+		t.Fatalf("TestAssociatedFiles: %v\n", err)
+	}
+	if len(attachments) != 1 || attachments[0] != "test.wav" {
+		t.Fatalf("TestAssociatedFiles: unexpected attachments: %v\n", attachments)
 	}
 
-	// Change opw
-	t.Log("ChangeOwnerPW")
-	config = pdf.NewDefaultConfiguration()
-	config.UserPW = ""
-	pwOld = "opw"
-	pwNew = "opwNew"
-	_, err = Process(ChangeOwnerPWCommand(outFile, outFile, config, &pwOld, &pwNew))
-	if err != nil {
-		t.Fatalf("TestEncrypt - %s change opw: %v\n", outFile, err)
+	bad := []pdf.AssociatedFile{{FileName: filepath.Join(resDir, "test.wav"), Relationship: "Original"}}
+	if err := AddAssociatedFiles(fileName, outFile, bad, config); err == nil {
+		t.Fatal("TestAssociatedFiles: invalid relationship should fail\n")
 	}
+}
 
-	// Decrypt wrong upw
-	t.Log("Decrypt wrong upw fails")
-	config = pdf.NewDefaultConfiguration()
-	config.UserPW = "upwWrong"
-	_, err = Process(DecryptCommand(outFile, outFile, config))
-	if err == nil {
-		t.Fatalf("TestEncrypt - %s decrypt using wrong upw should fail\n", outFile)
+// outlineItems returns the titles of the outline items below parent and the number of their children.
+func outlineItems(t *testing.T, ctx *pdf.Context, parent pdf.Dict) ([]string, []int) {
+	t.Helper()
+
+	var (
+		titles []string
+		kids   []int
+	)
+
+	for o := parent["First"]; o != nil; {
+		d, err := ctx.DereferenceDict(o)
+		if err != nil || d == nil {
+			t.Fatalf("outlineItems: %v\n", err)
+		}
+		s, err := ctx.DereferenceText(d["Title"])
+		if err != nil {
+			t.Fatalf("outlineItems: %v\n", err)
+		}
+		titles = append(titles, s)
+		cc, _ := outlineItems(t, ctx, d)
+		kids = append(kids, len(cc))
+		o = d["Next"]
 	}
 
-	// Decrypt wrong opw succeeds on empty upw
-	t.Log("Decrypt wrong opw succeeds on empty upw")
-	config = pdf.NewDefaultConfiguration()
-	config.OwnerPW = "opwWrong"
-	_, err = Process(DecryptCommand(outFile, outFile, config))
+	return titles, kids
+}
+
+func rootOutlines(t *testing.T, ctx *pdf.Context) pdf.Dict {
+	t.Helper()
+
+	rootDict, err := ctx.Catalog()
 	if err != nil {
-		t.Fatalf("TestEncrypt - %s decrypt wrong opw, empty upw: %v\n", outFile, err)
+		t.Fatalf("rootOutlines: %v\n", err)
+	}
+
+	d, err := ctx.DereferenceDict(rootDict["Outlines"])
+	if err != nil || d == nil {
+		t.Fatalf("rootOutlines: missing outlines %v\n", err)
 	}
+
+	return d
 }
 
-func encryptDecrypt(fileName string, config *pdf.Configuration, t *testing.T) {
+// Merge files with outlines and verify the outlines get combined.
+func TestMergeOutlines(t *testing.T) {
 
-	inFile := filepath.Join(inDir, fileName)
-	outFile := filepath.Join(outDir, "test.pdf")
+	inFiles := []string{filepath.Join(inDir, "T6.pdf"), filepath.Join(inDir, "adobe_errata.pdf")}
 
-	t.Log(inFile)
+	var want []string
+	for _, f := range inFiles {
+		ctx := readAndValidateFile(t, f)
+		titles, _ := outlineItems(t, ctx, rootOutlines(t, ctx))
+		t.Logf("%s: %v\n", f, titles)
+		want = append(want, titles...)
+	}
 
-	// Encrypt
-	t.Log("Encrypt")
-	_, err := Process(EncryptCommand(inFile, outFile, config))
-	if err != nil {
-		t.Fatalf("TestEncryptDecrypt - encrypt %s: %v\n", outFile, err)
+	config := pdf.NewDefaultConfiguration()
+
+	// Concatenate the outlines.
+	config.MergeOutlines = pdf.OutlinesFlat
+	outFile := filepath.Join(outDir, "mergeOutlinesFlat.pdf")
+	if _, err := Process(MergeCommand(inFiles, outFile, config)); err != nil {
+		t.Fatalf("TestMergeOutlines: %v\n", err)
 	}
 
-	// Encrypt already encrypted
-	t.Log("Encrypt already encrypted")
-	config = pdf.NewDefaultConfiguration()
-	config.UserPW = "upw"
-	config.OwnerPW = "opw"
-	_, err = Process(EncryptCommand(outFile, outFile, config))
-	if err == nil {
-		t.Fatalf("TestEncryptDecrypt - encrypt encrypted %s\n", outFile)
+	ctx := readAndValidateFile(t, outFile)
+	got, _ := outlineItems(t, ctx, rootOutlines(t, ctx))
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("TestMergeOutlines flat: want %v, got %v\n", want, got)
 	}
 
-	// Validate using wrong owner pw
-	t.Log("Validate wrong ownerPW")
-	config = pdf.NewDefaultConfiguration()
-	config.UserPW = "upw"
-	config.OwnerPW = "opwWrong"
-	_, err = Process(ValidateCommand(outFile, config))
-	if err != nil {
-		t.Fatalf("TestEncryptDecrypt - validate %s using wrong ownerPW: %v\n", outFile, err)
+	// Nest the outlines of each file.
+	config.MergeOutlines = pdf.OutlinesNested
+	outFile = filepath.Join(outDir, "mergeOutlinesNested.pdf")
+	if _, err := Process(MergeCommand(inFiles, outFile, config)); err != nil {
+		t.Fatalf("TestMergeOutlines: %v\n", err)
 	}
 
-	// Optimize using wrong owner pw
-	t.Log("Optimize wrong ownerPW")
-	config = pdf.NewDefaultConfiguration()
-	config.UserPW = "upw"
-	config.OwnerPW = "opwWrong"
-	_, err = Process(OptimizeCommand(outFile, outFile, config))
-	if err != nil {
-		t.Fatalf("TestEncryptDecrypt - optimize %s using wrong ownerPW: %v\n", outFile, err)
+	ctx = readAndValidateFile(t, outFile)
+	got, kids := outlineItems(t, ctx, rootOutlines(t, ctx))
+	if strings.Join(got, "|") != "T6|adobe_errata" {
+		t.Fatalf("TestMergeOutlines nested: got %v\n", got)
+	}
+	if kids[0]+kids[1] != len(want) {
+		t.Fatalf("TestMergeOutlines nested: want %d children, got %v\n", len(want), kids)
 	}
 
-	// Trim using wrong owner pw, falls back to upw and fails with insufficient permissions.
-	t.Log("Trim wrong ownerPW, fallback to upw and fail with insufficient permissions.")
-	config = pdf.NewDefaultConfiguration()
-	config.UserPW = "upw"
-	config.OwnerPW = "opwWrong"
-	// pageSelection = nil, writes w/o trimming anything, but sufficient for testing.
-	_, err = Process(TrimCommand(outFile, outFile, nil, config))
-	if err == nil {
-		t.Fatalf("TestEncryptDecrypt - trim %s using wrong ownerPW should fail: \n", outFile)
+	// Merging a file with itself compares objects missing in both copies.
+	blankFile := filepath.Join(inDir, "blank-scan.pdf")
+	if _, err := Process(MergeCommand([]string{blankFile, blankFile}, outFile, config)); err != nil {
+		t.Fatalf("TestMergeOutlines: %v\n", err)
 	}
+}
 
-	// Split using wrong owner pw, falls back to upw and fails with insufficient permissions.
-	t.Log("Split wrong ownerPW, fallback to upw and fail with insufficient permissions.")
-	config = pdf.NewDefaultConfiguration()
-	config.UserPW = "upw"
-	config.OwnerPW = "opwWrong"
-	_, err = Process(SplitCommand(outFile, outDir, config))
-	if err == nil {
-		t.Fatalf("TestEncryptDecrypt - split %s using wrong ownerPW should fail: \n", outFile)
+// linkDestNames returns the named destinations referred to by link annotations.
+func linkDestNames(t *testing.T, ctx *pdf.Context) []string {
+	t.Helper()
+
+	var ss []string
+
+	for p := 1; p <= ctx.PageCount; p++ {
+		for _, d := range pageAnnotations(t, ctx, p)["Link"] {
+			o := d["Dest"]
+			if a, err := ctx.DereferenceDict(d["A"]); err == nil && a != nil && a.NameEntry("S") != nil && *a.NameEntry("S") == "GoTo" {
+				o = a["D"]
+			}
+			if s, ok := o.(pdf.StringLiteral); ok {
+				ss = append(ss, s.Value())
+			}
+		}
 	}
 
-	// Add permissions
-	t.Log("Add user access permissions")
-	config = pdf.NewDefaultConfiguration()
-	config.UserPW = "upw"
-	config.OwnerPW = "opw"
-	config.UserAccessPermissions = pdf.PermissionsAll
-	_, err = Process(AddPermissionsCommand(outFile, config))
+	return ss
+}
+
+// Merge a file with named destinations with itself and verify links resolve within each part.
+func TestMergeNamedDests(t *testing.T) {
+
+	inFile := filepath.Join(inDir, "adobe_supplement_iso32000_1.pdf")
+
+	ctx := readAndValidateFile(t, inFile)
+	keys, err := ctx.Names["Dests"].KeyList()
 	if err != nil {
-		t.Fatalf("TestEncryptDecrypt - %s add permissions: %v\n", outFile, err)
+		t.Fatalf("TestMergeNamedDests: %v\n", err)
 	}
+	links := linkDestNames(t, ctx)
 
-	// Split using wrong owner pw, falls back to upw
-	t.Log("Split wrong ownerPW")
-	config = pdf.NewDefaultConfiguration()
-	config.UserPW = "upw"
-	config.OwnerPW = "opwWrong"
-	_, err = Process(SplitCommand(outFile, outDir, config))
-	if err != nil {
-		t.Fatalf("TestEncryptDecrypt - split %s using wrong ownerPW: %v\n", outFile, err)
+	outFile := filepath.Join(outDir, "mergeNamedDests.pdf")
+	config := pdf.NewDefaultConfiguration()
+	if _, err := Process(MergeCommand([]string{inFile, inFile}, outFile, config)); err != nil {
+		t.Fatalf("TestMergeNamedDests: %v\n", err)
 	}
 
-	// Validate
-	t.Log("Validate")
-	config = pdf.NewDefaultConfiguration()
-	config.UserPW = "upw"
-	config.OwnerPW = "opw"
-	_, err = Process(ValidateCommand(outFile, config))
+	ctx = readAndValidateFile(t, outFile)
+	if ctx.Names["Dests"] == nil {
+		t.Fatal("TestMergeNamedDests: missing named destinations\n")
+	}
+
+	got, err := ctx.Names["Dests"].KeyList()
 	if err != nil {
-		t.Fatalf("TestEncryptDecrypt - validate %s: %v\n", outFile, err)
+		t.Fatalf("TestMergeNamedDests: %v\n", err)
+	}
+	if len(got) != 2*len(keys) {
+		t.Fatalf("TestMergeNamedDests: want %d names, got %d\n", 2*len(keys), len(got))
 	}
 
-	// ChangeUserPW using wrong userpw
-	t.Log("ChangeUserPW wrong userpw")
-	config = pdf.NewDefaultConfiguration()
-	config.OwnerPW = "opw"
-	pwOld := "upwWrong"
-	pwNew := "upwNew"
-	_, err = Process(ChangeUserPWCommand(outFile, outFile, config, &pwOld, &pwNew))
-	if err == nil {
-		t.Fatalf("TestEncryption - %s change userPW using wrong userPW should fail:\n", outFile)
+	names := linkDestNames(t, ctx)
+	if len(names) != 2*len(links) {
+		t.Fatalf("TestMergeNamedDests: want %d links, got %d\n", 2*len(links), len(names))
 	}
 
-	// ChangeUserPW
-	t.Log("ChangeUserPW")
-	config = pdf.NewDefaultConfiguration()
-	config.OwnerPW = "opw"
-	pwOld = "upw"
-	pwNew = "upwNew"
-	_, err = Process(ChangeUserPWCommand(outFile, outFile, config, &pwOld, &pwNew))
-	if err != nil {
-		t.Fatalf("TestEncryption - change userPW %s: %v\n", outFile, err)
+	renamed := 0
+	for _, s := range names {
+		if _, ok := ctx.Names["Dests"].Value(s); !ok {
+			t.Fatalf("TestMergeNamedDests: unresolved destination %q\n", s)
+		}
+		if strings.HasSuffix(s, "_2") {
+			renamed++
+		}
 	}
 
-	// ChangeOwnerPW
-	t.Log("ChangeOwnerPW")
-	config = pdf.NewDefaultConfiguration()
-	config.UserPW = "upwNew"
-	pwOld = "opw"
-	pwNew = "opwNew"
-	_, err = Process(ChangeOwnerPWCommand(outFile, outFile, config, &pwOld, &pwNew))
-	if err != nil {
-		t.Fatalf("TestEncryption - change ownerPW %s: %v\n", outFile, err)
+	// The links of the second part point to the renamed destinations.
+	if renamed != len(links) {
+		t.Fatalf("TestMergeNamedDests: want %d renamed links, got %d\n", len(links), renamed)
+	}
+}
+
+// outlineDests returns the explicit destinations of all outline items below parent.
+func outlineDests(t *testing.T, ctx *pdf.Context, parent pdf.Dict) []pdf.Array {
+	t.Helper()
+
+	var aa []pdf.Array
+
+	for o := parent["First"]; o != nil; {
+		d, err := ctx.DereferenceDict(o)
+		if err != nil || d == nil {
+			t.Fatalf("outlineDests: %v\n", err)
+		}
+		a, err := ctx.DereferenceArray(d["Dest"])
+		if err != nil {
+			t.Fatalf("outlineDests: %v\n", err)
+		}
+		aa = append(aa, a)
+		aa = append(aa, outlineDests(t, ctx, d)...)
+		o = d["Next"]
+	}
+
+	return aa
+}
+
+// Split a file with outlines and page labels and verify each file keeps what applies to its page.
+func TestSplitDocumentData(t *testing.T) {
+
+	fileName := filepath.Join(inDir, "adobe_errata.pdf")
+	dir := filepath.Join(outDir, "splitDocData")
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("TestSplitDocumentData: %v\n", err)
 	}
 
-	// Decrypt using wrong pw
-	t.Log("\nDecrypt using wrong pw")
-	config = pdf.NewDefaultConfiguration()
-	config.UserPW = "upwWrong"
-	config.OwnerPW = "opwWrong"
-	_, err = Process(DecryptCommand(outFile, outFile, config))
-	if err == nil {
-		t.Fatalf("TestEncryptDecrypt - decrypt using wrong pw %s\n", outFile)
+	ctx := readAndValidateFile(t, fileName)
+	pageCount := ctx.PageCount
+
+	config := pdf.NewDefaultConfiguration()
+	config.SplitDocumentData = true
+
+	if _, err := Process(SplitCommand(fileName, dir, config)); err != nil {
+		t.Fatalf("TestSplitDocumentData: %v\n", err)
 	}
 
-	// Decrypt
-	t.Log("\nDecrypt")
-	config = pdf.NewDefaultConfiguration()
-	config.UserPW = "upwNew"
-	config.OwnerPW = "opwNew"
-	_, err = Process(DecryptCommand(outFile, outFile, config))
-	if err != nil {
-		t.Fatalf("TestEncryptDecrypt - decrypt %s: %v\n", outFile, err)
+	withOutlines := 0
+
+	for p := 1; p <= pageCount; p++ {
+
+		ctx := readAndValidateFile(t, filepath.Join(dir, fmt.Sprintf("adobe_errata_%d.pdf", p)))
+
+		rootDict, err := ctx.Catalog()
+		if err != nil {
+			t.Fatalf("TestSplitDocumentData: %v\n", err)
+		}
+
+		labels, err := ctx.DereferenceDict(rootDict["PageLabels"])
+		if err != nil || labels == nil {
+			t.Fatalf("TestSplitDocumentData: page %d: missing page labels %v\n", p, err)
+		}
+		if nums := labels.ArrayEntry("Nums"); len(nums) != 2 || nums[0] != pdf.Integer(0) {
+			t.Fatalf("TestSplitDocumentData: page %d: want single page label, got %v\n", p, labels)
+		}
+
+		if rootDict["Outlines"] == nil {
+			continue
+		}
+		withOutlines++
+
+		page, err := ctx.PageIndRef(1)
+		if err != nil {
+			t.Fatalf("TestSplitDocumentData: %v\n", err)
+		}
+
+		for _, a := range outlineDests(t, ctx, rootOutlines(t, ctx)) {
+			if ir, ok := a[0].(pdf.IndirectRef); !ok || ir.ObjectNumber != page.ObjectNumber {
+				t.Fatalf("TestSplitDocumentData: page %d: outline item pointing to %v\n", p, a)
+			}
+		}
 	}
 
+	if withOutlines == 0 {
+		t.Fatal("TestSplitDocumentData: no outlines kept\n")
+	}
 }
 
-func TestEncryptDecrypt(t *testing.T) {
+// Insert pages of one file into another and verify the page order.
+func TestInsertFrom(t *testing.T) {
 
+	srcFile := filepath.Join(inDir, "adobe_supplement_iso32000_1.pdf")
+	destFile := filepath.Join(inDir, "adobeImplOfPDFSpec.pdf")
+	outFile := filepath.Join(outDir, "insertFrom.pdf")
 	config := pdf.NewDefaultConfiguration()
-	config.UserPW = "upw"
-	config.OwnerPW = "opw"
-	encryptDecrypt("5116.DCT_Filter.pdf", config, t)
 
-	config = pdf.NewDefaultConfiguration()
-	config.UserPW = "upw"
-	config.OwnerPW = "opw"
-	config.EncryptUsingAES = false
-	config.EncryptUsing128BitKey = false
-	encryptDecrypt("networkProgr.pdf", config, t)
+	digests := func(ctx *pdf.Context) []string {
+		var ss []string
+		for p := 1; p <= ctx.PageCount; p++ {
+			b, err := ctx.PageContentDigest(p)
+			if err != nil {
+				t.Fatalf("TestInsertFrom: %v\n", err)
+			}
+			ss = append(ss, fmt.Sprintf("%x", b))
+		}
+		return ss
+	}
+
+	src := digests(readAndValidateFile(t, srcFile))
+	dest := digests(readAndValidateFile(t, destFile))
+
+	for _, tt := range []struct {
+		afterPage int
+		pages     []string
+		want      []string
+	}{
+		{1, []string{"2-3"}, append(append(append([]string{}, dest[:1]...), src[1:3]...), dest[1:]...)},
+		{0, nil, append(append([]string{}, src...), dest...)},
+		{len(dest), []string{"1"}, append(append([]string{}, dest...), src[0])},
+	} {
+		if err := InsertFrom(srcFile, destFile, outFile, tt.afterPage, tt.pages, config); err != nil {
+			t.Fatalf("TestInsertFrom: %v\n", err)
+		}
+		got := digests(readAndValidateFile(t, outFile))
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Fatalf("TestInsertFrom: after page %d: unexpected page order\n", tt.afterPage)
+		}
+	}
+
+	if err := InsertFrom(srcFile, destFile, outFile, len(dest)+1, nil, config); err == nil {
+		t.Fatal("TestInsertFrom: want error for invalid page number\n")
+	}
+
+	// Inserting a file into itself compares objects missing in both copies.
+	blankFile := filepath.Join(inDir, "blank-scan.pdf")
+	n := readAndValidateFile(t, blankFile).PageCount
+	if err := InsertFrom(blankFile, blankFile, outFile, 1, nil, config); err != nil {
+		t.Fatalf("TestInsertFrom: %v\n", err)
+	}
+	if ctx := readAndValidateFile(t, outFile); ctx.PageCount != 2*n {
+		t.Fatalf("TestInsertFrom: want %d pages, got %d\n", 2*n, ctx.PageCount)
+	}
 }
 
 func copyFile(srcFileName, destFileName string) (err error) {
@@ -1307,7 +8162,7 @@ func copyFile(srcFileName, destFileName string) (err error) {
 	}
 	defer from.Close()
 
-	to, err := os.OpenFile(destFileName, os.O_RDWR|os.O_CREATE, 0666)
+	to, err := os.OpenFile(destFileName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
 		return
 	}
@@ -1440,6 +8295,352 @@ func TestAttachments(t *testing.T) {
 	testAttachmentsStage2(fileName, config, t)
 }
 
+func TestAtomicWrite(t *testing.T) {
+
+	err := prepareForAttachmentTest()
+	if err != nil {
+		t.Fatalf("prepare for attachments: %v\n", err)
+	}
+
+	fileName := filepath.Join(outDir, "go.pdf")
+	backupName := fileName + ".bak"
+	os.Remove(backupName)
+
+	if err = os.Chmod(fileName, 0600); err != nil {
+		t.Fatalf("TestAtomicWrite: %v\n", err)
+	}
+
+	original, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("TestAtomicWrite: %v\n", err)
+	}
+
+	config := pdf.NewDefaultConfiguration()
+	config.WriteBackup = true
+
+	err = AddAttachments(fileName, []string{filepath.Join(outDir, "golang.pdf")}, config)
+	if err != nil {
+		t.Fatalf("TestAtomicWrite - add attachments to %s: %v\n", fileName, err)
+	}
+
+	// The original survives as backup.
+	backup, err := ioutil.ReadFile(backupName)
+	if err != nil {
+		t.Fatalf("TestAtomicWrite - missing backup: %v\n", err)
+	}
+	if !bytes.Equal(backup, original) {
+		t.Fatalf("TestAtomicWrite - backup %s differs from original\n", backupName)
+	}
+
+	fi, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatalf("TestAtomicWrite: %v\n", err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("TestAtomicWrite - want permissions 0600, got %v\n", fi.Mode().Perm())
+	}
+
+	readAndValidateFile(t, fileName)
+
+	// No temporary files left behind.
+	tmpFiles, err := filepath.Glob(filepath.Join(outDir, ".*.tmp"))
+	if err != nil {
+		t.Fatalf("TestAtomicWrite: %v\n", err)
+	}
+	if len(tmpFiles) > 0 {
+		t.Fatalf("TestAtomicWrite - temporary files left: %v\n", tmpFiles)
+	}
+
+	os.Remove(backupName)
+}
+
+func TestAttachmentsOutFile(t *testing.T) {
+
+	err := prepareForAttachmentTest()
+	if err != nil {
+		t.Fatalf("prepare for attachments: %v\n", err)
+	}
+
+	config := pdf.NewDefaultConfiguration()
+
+	fileIn := filepath.Join(outDir, "go.pdf")
+	fileOut := filepath.Join(outDir, "goAttached.pdf")
+
+	original, err := ioutil.ReadFile(fileIn)
+	if err != nil {
+		t.Fatalf("TestAttachmentsOutFile: %v\n", err)
+	}
+
+	_, err = Process(AddAttachmentsToFileCommand(fileIn, fileOut, []string{filepath.Join(outDir, "golang.pdf")}, config))
+	if err != nil {
+		t.Fatalf("TestAttachmentsOutFile - add attachments: %v\n", err)
+	}
+
+	bb, err := ioutil.ReadFile(fileIn)
+	if err != nil {
+		t.Fatalf("TestAttachmentsOutFile: %v\n", err)
+	}
+	if !bytes.Equal(bb, original) {
+		t.Fatalf("TestAttachmentsOutFile: %s has been modified\n", fileIn)
+	}
+
+	list, err := ListAttachments(fileOut, config)
+	if err != nil {
+		t.Fatalf("TestAttachmentsOutFile: %v\n", err)
+	}
+	if len(list) != 1 {
+		t.Fatalf("TestAttachmentsOutFile: want 1 attachment, got %v\n", list)
+	}
+
+	// Remove the attachment again writing to an io.Writer.
+	f, err := os.Open(fileOut)
+	if err != nil {
+		t.Fatalf("TestAttachmentsOutFile: %v\n", err)
+	}
+	defer f.Close()
+
+	// The writer variants leave the configuration passed in alone.
+	config = pdf.NewDefaultConfiguration()
+	mode := config.Mode
+
+	var buf bytes.Buffer
+	if err = RemoveAttachmentsToWriter(f, &buf, nil, config); err != nil {
+		t.Fatalf("TestAttachmentsOutFile - remove attachments: %v\n", err)
+	}
+
+	if config.Mode != mode {
+		t.Fatalf("TestAttachmentsOutFile: config mode changed to %d\n", config.Mode)
+	}
+
+	// No configuration means the default configuration.
+	var buf1 bytes.Buffer
+	if err = AddAttachmentsToWriter(bytes.NewReader(buf.Bytes()), &buf1, []string{filepath.Join(outDir, "golang.pdf")}, nil); err != nil {
+		t.Fatalf("TestAttachmentsOutFile - add attachments: %v\n", err)
+	}
+
+	ctx, err := ReadContext(bytes.NewReader(buf.Bytes()), "", 0, config)
+	if err != nil {
+		t.Fatalf("TestAttachmentsOutFile: %v\n", err)
+	}
+	if err = ValidateContext(ctx); err != nil {
+		t.Fatalf("TestAttachmentsOutFile: %v\n", err)
+	}
+	if list, err = pdf.AttachList(ctx.XRefTable); err != nil || len(list) > 0 {
+		t.Fatalf("TestAttachmentsOutFile: want no attachments, got %v %v\n", list, err)
+	}
+}
+
+// embeddedFileStreamDict returns the stream dict of an attachment of a validated context.
+func embeddedFileStreamDict(ctx *pdf.Context, fileName string) (*pdf.StreamDict, error) {
+
+	o, ok := ctx.Names["EmbeddedFiles"].Value(fileName)
+	if !ok {
+		return nil, fmt.Errorf("missing attachment %s", fileName)
+	}
+
+	d, err := ctx.DereferenceDict(o)
+	if err != nil {
+		return nil, err
+	}
+
+	return ctx.DereferenceStreamDict(d.DictEntry("EF")["F"])
+}
+
+func TestAttachmentOptions(t *testing.T) {
+
+	err := prepareForAttachmentTest()
+	if err != nil {
+		t.Fatalf("prepare for attachments: %v\n", err)
+	}
+
+	config := pdf.NewDefaultConfiguration()
+
+	fileName := filepath.Join(outDir, "go.pdf")
+
+	files := map[string]pdf.AttachmentOptions{
+		filepath.Join(outDir, "golang.pdf"): {Compression: pdf.AttachmentCompressionNone},
+		filepath.Join(outDir, "test.wav"):   {Compression: pdf.AttachmentCompressionBest},
+	}
+
+	err = AddAttachmentsWithOptions(fileName, files, config)
+	if err != nil {
+		t.Fatalf("TestAttachmentOptions - add attachments to %s: %v\n", fileName, err)
+	}
+
+	ctx, err := ReadContextFromFile(fileName, config)
+	if err != nil {
+		t.Fatalf("TestAttachmentOptions: %v\n", err)
+	}
+
+	err = ValidateContext(ctx)
+	if err != nil {
+		t.Fatalf("TestAttachmentOptions: %v\n", err)
+	}
+
+	for f, opts := range files {
+
+		fi, err := os.Stat(f)
+		if err != nil {
+			t.Fatalf("TestAttachmentOptions: %v\n", err)
+		}
+
+		sd, err := embeddedFileStreamDict(ctx, filepath.Base(f))
+		if err != nil {
+			t.Fatalf("TestAttachmentOptions: %v\n", err)
+		}
+
+		_, compressed := sd.Find("Filter")
+		if compressed == (opts.Compression == pdf.AttachmentCompressionNone) {
+			t.Fatalf("TestAttachmentOptions - %s: unexpected Filter entry: %v\n", f, sd.Dict)
+		}
+
+		params := sd.DictEntry("Params")
+		if params == nil || params.IntEntry("Size") == nil || int64(*params.IntEntry("Size")) != fi.Size() {
+			t.Fatalf("TestAttachmentOptions - %s: unexpected Params: %v\n", f, params)
+		}
+
+		if params.HexLiteralEntry("CheckSum") == nil || params.StringLiteralEntry("ModDate") == nil {
+			t.Fatalf("TestAttachmentOptions - %s: unexpected Params: %v\n", f, params)
+		}
+	}
+
+}
+
+func testStreamedAttachment(t *testing.T, fileName string, encrypt bool) {
+
+	data := bytes.Repeat([]byte("streamed attachment content "), 1<<15)
+
+	config := pdf.NewDefaultConfiguration()
+
+	ctx, err := ReadContextFromFile(filepath.Join(inDir, "go.pdf"), config)
+	if err != nil {
+		t.Fatalf("TestStreamedAttachments: %v\n", err)
+	}
+
+	err = pdf.AttachAddFromReader(ctx.XRefTable, "data.txt", bytes.NewReader(data), time.Now(), pdf.AttachmentOptions{})
+	if err != nil {
+		t.Fatalf("TestStreamedAttachments: %v\n", err)
+	}
+
+	if encrypt {
+		ctx.Mode = pdf.ENCRYPT
+		ctx.OwnerPW = "opw"
+	}
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		t.Fatalf("TestStreamedAttachments: %v\n", err)
+	}
+
+	err = WriteContext(ctx, f)
+	if err != nil {
+		t.Fatalf("TestStreamedAttachments: %v\n", err)
+	}
+
+	f.Close()
+
+	// A stream source may be consumed only once.
+	ctx.ResetWriteContext()
+	if err = WriteContext(ctx, ioutil.Discard); err == nil || !strings.Contains(err.Error(), "consumed") {
+		t.Fatalf("TestStreamedAttachments: writing a consumed stream source should fail: %v\n", err)
+	}
+
+	config = pdf.NewDefaultConfiguration()
+	config.OwnerPW = "opw"
+
+	ctx, err = ReadContextFromFile(fileName, config)
+	if err != nil {
+		t.Fatalf("TestStreamedAttachments: %v\n", err)
+	}
+
+	err = ValidateContext(ctx)
+	if err != nil {
+		t.Fatalf("TestStreamedAttachments: %v\n", err)
+	}
+
+	sd, err := embeddedFileStreamDict(ctx, "data.txt")
+	if err != nil {
+		t.Fatalf("TestStreamedAttachments: %v\n", err)
+	}
+
+	size, err := ctx.DereferenceInteger(sd.DictEntry("Params")["Size"])
+	if err != nil || size == nil || size.Value() != len(data) {
+		t.Fatalf("TestStreamedAttachments: unexpected Size: %v %v\n", size, err)
+	}
+
+	err = ExtractAttachments(fileName, outDir, []string{"data.txt"}, config)
+	if err != nil {
+		t.Fatalf("TestStreamedAttachments: %v\n", err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(outDir, "data.txt"))
+	if err != nil {
+		t.Fatalf("TestStreamedAttachments: %v\n", err)
+	}
+
+	if !bytes.Equal(b, data) {
+		t.Fatalf("TestStreamedAttachments: extracted content differs\n")
+	}
+}
+
+// Stream attachments into the output file at write time.
+func TestStreamedAttachments(t *testing.T) {
+
+	testStreamedAttachment(t, filepath.Join(outDir, "streamed.pdf"), false)
+
+	// Encryption falls back to loading the stream source into memory.
+	testStreamedAttachment(t, filepath.Join(outDir, "streamedEncrypted.pdf"), true)
+
+	err := prepareForAttachmentTest()
+	if err != nil {
+		t.Fatalf("prepare for attachments: %v\n", err)
+	}
+
+	config := pdf.NewDefaultConfiguration()
+	config.VerifyWrite = true
+
+	fileName := filepath.Join(outDir, "go.pdf")
+
+	files := map[string]pdf.AttachmentOptions{
+		filepath.Join(outDir, "golang.pdf"): {Stream: true, Compression: pdf.AttachmentCompressionNone},
+		filepath.Join(outDir, "test.wav"):   {Stream: true, Compression: pdf.AttachmentCompressionBest},
+	}
+
+	err = AddAttachmentsWithOptions(fileName, files, config)
+	if err != nil {
+		t.Fatalf("TestStreamedAttachments - add attachments to %s: %v\n", fileName, err)
+	}
+
+	dirOut := filepath.Join(outDir, "streamed")
+	err = os.MkdirAll(dirOut, os.ModePerm)
+	if err != nil {
+		t.Fatalf("TestStreamedAttachments: %v\n", err)
+	}
+
+	err = ExtractAttachments(fileName, dirOut, nil, config)
+	if err != nil {
+		t.Fatalf("TestStreamedAttachments: %v\n", err)
+	}
+
+	for f := range files {
+
+		want, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatalf("TestStreamedAttachments: %v\n", err)
+		}
+
+		got, err := ioutil.ReadFile(filepath.Join(dirOut, filepath.Base(f)))
+		if err != nil {
+			t.Fatalf("TestStreamedAttachments: %v\n", err)
+		}
+
+		if !bytes.Equal(got, want) {
+			t.Fatalf("TestStreamedAttachments: extracted content of %s differs\n", f)
+		}
+	}
+
+}
+
 func TestListPermissionsCommand(t *testing.T) {
 
 	inFile := filepath.Join(inDir, "5116.DCT_Filter.pdf")
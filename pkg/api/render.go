@@ -0,0 +1,121 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jplu/pdfcpu/pkg/log"
+	pdf "github.com/jplu/pdfcpu/pkg/pdfcpu"
+	"github.com/pkg/errors"
+)
+
+// The image formats supported for rendering pages.
+const (
+	RenderPNG = "png"
+	RenderJPG = "jpg"
+)
+
+// RenderPageFromContext rasterizes page pageNr at dpi dots per inch.
+// Text is painted as gray bars since font programs are not interpreted.
+func RenderPageFromContext(ctx *pdf.Context, pageNr int, dpi float64) (image.Image, error) {
+	return pdf.RenderPage(ctx.XRefTable, pageNr, dpi)
+}
+
+func encodeRenderedPage(img image.Image, format string) ([]byte, error) {
+
+	var buf bytes.Buffer
+	var err error
+
+	if format == RenderJPG {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90})
+	} else {
+		err = png.Encode(&buf, img)
+	}
+
+	return buf.Bytes(), err
+}
+
+// RenderPages rasterizes selected pages of fileIn at dpi dots per inch into dirOut
+// as one PNG or JPEG file per page and returns the paths of the files written.
+// format is either "png" or "jpg".
+func RenderPages(fileIn, dirOut string, selectedPages []string, format string, dpi float64, config *pdf.Configuration) ([]string, error) {
+
+	if format != RenderPNG && format != RenderJPG {
+		return nil, errors.Errorf("RenderPages: unsupported format %q, use %s or %s", format, RenderPNG, RenderJPG)
+	}
+
+	fromStart := time.Now()
+
+	ctx, durRead, durVal, durOpt, err := readValidateAndOptimize(fileIn, configForMode(config, pdf.RENDERPAGES), fromStart)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("rendering %s into %s ...\n", fileIn, dirOut)
+
+	fromWrite := time.Now()
+
+	pages, err := pagesForPageSelection(ctx.PageCount, selectedPages)
+	if err != nil {
+		return nil, err
+	}
+
+	ensureSelectedPages(ctx, &pages)
+
+	_, base := filepath.Split(fileIn)
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+
+	var files []string
+
+	for _, p := range pdf.SortedSelectedPages(pages) {
+
+		log.Info.Printf("rendering page %d\n", p)
+
+		img, err := RenderPageFromContext(ctx, p, dpi)
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := encodeRenderedPage(img, format)
+		if err != nil {
+			return nil, err
+		}
+
+		fileName := filepath.Join(dirOut, fmt.Sprintf("%s_%d.%s", base, p, format))
+
+		if err = pdf.WriteFile(ctx.FileSystem(), fileName, b, pdf.ExtractFilePerm); err != nil {
+			return nil, err
+		}
+
+		files = append(files, fileName)
+	}
+
+	durWrite := time.Since(fromWrite).Seconds()
+	durTotal := time.Since(fromStart).Seconds()
+	log.Stats.Printf("XRefTable:\n%s\n", ctx)
+	pdf.TimingStats("render pages", durRead, durVal, durOpt, durWrite, durTotal)
+
+	return files, nil
+}
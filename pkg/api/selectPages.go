@@ -281,6 +281,126 @@ func pagesForPageSelection(pageCount int, pageSelection []string) (pdf.IntSet, e
 	return selectedPages(pageCount, pageSelection)
 }
 
+// pageSequenceRange returns the pages from thru in order, descending if from > thru.
+// Pages beyond pageCount are skipped.
+func pageSequenceRange(from, thru, pageCount int) []int {
+
+	var pages []int
+
+	step := 1
+	if from > thru {
+		step = -1
+	}
+
+	for i := from; ; i += step {
+		if i >= 1 && i <= pageCount {
+			pages = append(pages, i)
+		}
+		if i == thru {
+			break
+		}
+	}
+
+	return pages
+}
+
+// pageSequenceExp returns the pages of a single page sequence expression in order.
+func pageSequenceExp(v string, pageCount int) ([]int, error) {
+
+	if v == "even" || v == "odd" {
+		var pages []int
+		i := 1
+		if v == "even" {
+			i = 2
+		}
+		for ; i <= pageCount; i += 2 {
+			pages = append(pages, i)
+		}
+		return pages, nil
+	}
+
+	// -# ... first page thru page #
+	if v[0] == '-' {
+		i, err := strconv.Atoi(v[1:])
+		if err != nil {
+			return nil, err
+		}
+		return pageSequenceRange(1, i, pageCount), nil
+	}
+
+	// #- ... page # thru last page
+	if strings.HasSuffix(v, "-") {
+		i, err := strconv.Atoi(v[:len(v)-1])
+		if err != nil {
+			return nil, err
+		}
+		if i > pageCount {
+			return nil, nil
+		}
+		return pageSequenceRange(i, pageCount, pageCount), nil
+	}
+
+	// #-# ... page range, descending if the first page is greater than the second.
+	pr := strings.Split(v, "-")
+
+	from, err := strconv.Atoi(pr[0])
+	if err != nil {
+		return nil, err
+	}
+
+	thru := from
+	if len(pr) == 2 {
+		if thru, err = strconv.Atoi(pr[1]); err != nil {
+			return nil, err
+		}
+	}
+
+	return pageSequenceRange(from, thru, pageCount), nil
+}
+
+// pagesForPageSequence returns the page numbers of a page sequence in order.
+// The syntax is the one of page selections, but expressions get evaluated into an ordered sequence
+// allowing repetitions and descending ranges: "3,1,1,5-2" yields 3,1,1,5,4,3,2.
+// A negated expression removes all occurrences of its pages collected so far.
+func pagesForPageSequence(pageCount int, pageSequence []string) ([]int, error) {
+
+	var pages []int
+
+	for _, v := range pageSequence {
+
+		var negated bool
+		if negation(v[0]) {
+			negated = true
+			v = v[1:]
+		}
+
+		pp, err := pageSequenceExp(v, pageCount)
+		if err != nil {
+			return nil, err
+		}
+
+		if !negated {
+			pages = append(pages, pp...)
+			continue
+		}
+
+		remove := pdf.IntSet{}
+		for _, p := range pp {
+			remove[p] = true
+		}
+
+		var kept []int
+		for _, p := range pages {
+			if !remove[p] {
+				kept = append(kept, p)
+			}
+		}
+		pages = kept
+	}
+
+	return pages, nil
+}
+
 // Split, Extract, Stamp, Watermark: No page selection means all pages are selected.
 // EnsureSelectedPages selects all pages.
 func ensureSelectedPages(ctx *pdf.Context, selectedPages *pdf.IntSet) {
@@ -17,6 +17,7 @@ limitations under the License.
 package api
 
 import (
+	"fmt"
 	"regexp"
 	"testing"
 
@@ -133,3 +134,36 @@ func TestPageSelection(t *testing.T) {
 	doTestPageSelection("4-", pageCount, "00011", t)
 	doTestPageSelection("5-", pageCount, "00001", t)
 }
+
+func doTestPageSequence(s string, pageCount int, compareString string, t *testing.T) {
+
+	pageSequence, err := ParsePageSelection(s)
+	if err != nil {
+		t.Fatalf("TestPageSequence(%s) %v\n", s, err)
+	}
+
+	pages, err := pagesForPageSequence(pageCount, pageSequence)
+	if err != nil {
+		t.Fatalf("TestPageSequence(%s) %v\n", s, err)
+	}
+
+	resultString := strings.Trim(strings.Join(strings.Fields(fmt.Sprint(pages)), ","), "[]")
+
+	if resultString != compareString {
+		t.Fatalf("TestPageSequence(%s) expected:%s got:%s\n", s, compareString, resultString)
+	}
+}
+
+func TestPageSequence(t *testing.T) {
+
+	pageCount := 5
+
+	doTestPageSequence("3,1,1,5-2", pageCount, "3,1,1,5,4,3,2", t)
+	doTestPageSequence("even,odd", pageCount, "2,4,1,3,5", t)
+	doTestPageSequence("-3,-3", pageCount, "1,2,3,1,2,3", t)
+	doTestPageSequence("4-", pageCount, "4,5", t)
+	doTestPageSequence("1-,!2-3", pageCount, "1,4,5", t)
+	doTestPageSequence("2,2,n2,1", pageCount, "1", t)
+	doTestPageSequence("7-4", pageCount, "5,4", t)
+	doTestPageSequence("6,1", pageCount, "1", t)
+}
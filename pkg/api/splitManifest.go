@@ -0,0 +1,167 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	pdf "github.com/jplu/pdfcpu/pkg/pdfcpu"
+	"github.com/pkg/errors"
+)
+
+// SplitManifestFile is the name of the manifest written into the output dir when splitting with SplitSkipExisting.
+// Each line records the SHA-256 checksum, the size and the name of a file written: <sha256> <size> <name>
+const SplitManifestFile = "pdfcpu_split.manifest"
+
+type splitManifestEntry struct {
+	size int64
+	sum  string
+}
+
+// splitManifest tracks the files written by resumable split runs.
+type splitManifest struct {
+	fsys     pdf.FileSystem
+	fileName string
+	entries  map[string]splitManifestEntry // by file name without dir
+}
+
+// readSplitManifest returns the manifest of dirOut, which is empty if there is none yet.
+func readSplitManifest(fsys pdf.FileSystem, dirOut string) (*splitManifest, error) {
+
+	m := &splitManifest{
+		fsys:     fsys,
+		fileName: filepath.Join(dirOut, SplitManifestFile),
+		entries:  map[string]splitManifestEntry{},
+	}
+
+	f, err := fsys.Open(m.fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+
+	for s.Scan() {
+
+		// A line cut off by an interrupted run gets ignored.
+		ss := strings.SplitN(s.Text(), " ", 3)
+		if len(ss) != 3 {
+			continue
+		}
+
+		size, err := strconv.ParseInt(ss[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		m.entries[ss[2]] = splitManifestEntry{size: size, sum: ss[0]}
+	}
+
+	if err = s.Err(); err != nil {
+		return nil, errors.Wrapf(err, "reading %s", m.fileName)
+	}
+
+	return m, nil
+}
+
+func fileChecksum(fsys pdf.FileSystem, fileName string) (string, int64, error) {
+
+	f, err := fsys.Open(fileName)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// written returns true if fileName exists and matches size and checksum recorded.
+func (m *splitManifest) written(fileName string) (bool, error) {
+
+	e, found := m.entries[filepath.Base(fileName)]
+	if !found {
+		return false, nil
+	}
+
+	fi, err := fs.Stat(m.fsys, fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if fi.Size() != e.size {
+		return false, nil
+	}
+
+	sum, _, err := fileChecksum(m.fsys, fileName)
+	if err != nil {
+		return false, err
+	}
+
+	return sum == e.sum, nil
+}
+
+// record appends size and checksum of fileName to the manifest.
+func (m *splitManifest) record(fileName string) error {
+
+	sum, size, err := fileChecksum(m.fsys, fileName)
+	if err != nil {
+		return err
+	}
+
+	f, err := m.fsys.OpenFile(m.fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	name := filepath.Base(fileName)
+
+	if _, err = fmt.Fprintf(f, "%s %d %s\n", sum, size, name); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	m.entries[name] = splitManifestEntry{size: size, sum: sum}
+
+	return nil
+}
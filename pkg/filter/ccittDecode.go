@@ -30,8 +30,42 @@ type ccittDecode struct {
 }
 
 // Encode implements encoding for an CCITTDecode filter.
+// Only Group 4 encoding is supported.
 func (f ccittDecode) Encode(r io.Reader) (*bytes.Buffer, error) {
-	return nil, nil
+
+	log.Trace.Println("EncodeCCITT begin")
+
+	if k, ok := f.parms["K"]; !ok || k >= 0 {
+		return nil, errors.New("EncodeCCITT: K >= 0 currently unsupported")
+	}
+
+	columns := 1728
+	col, ok := f.parms["Columns"]
+	if ok {
+		columns = col
+	}
+
+	blackIs1 := false
+	v, ok := f.parms["BlackIs1"]
+	if ok && v == 1 {
+		blackIs1 = true
+	}
+
+	var b bytes.Buffer
+	wc := ccitt.NewWriter(&b, columns, blackIs1)
+
+	written, err := io.Copy(wc, r)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = wc.Close(); err != nil {
+		return nil, err
+	}
+
+	log.Trace.Printf("EncodeCCITT: encoded %d bytes.\n", written)
+
+	return &b, nil
 }
 
 // Decode implements decoding for a CCITTDecode filter.
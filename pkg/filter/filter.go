@@ -40,6 +40,9 @@ const (
 	JPX       = "JPXDecode"
 )
 
+// CompressionLevel is an encoding only parameter of the Flate filter taking a zlib compression level.
+const CompressionLevel = "CompressionLevel"
+
 var (
 
 	// ErrUnsupportedFilter signals an unsupported filter type.
@@ -55,8 +58,13 @@ type Filter interface {
 }
 
 // NewFilter returns a filter for given filterName and an optional parameter dictionary.
+// Custom filters registered under filterName take precedence.
 func NewFilter(filterName string, parms map[string]int) (filter Filter, err error) {
 
+	if f, ok := registeredFilter(filterName); ok {
+		return f(parms)
+	}
+
 	switch filterName {
 
 	case ASCII85:
@@ -19,6 +19,7 @@ package filter_test
 import (
 	"bufio"
 	"bytes"
+	"io"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -150,3 +151,105 @@ func TestWriter(t *testing.T) {
 		}
 	}
 }
+
+func TestBufferPooling(t *testing.T) {
+
+	defer filter.SetMaxPooledBufferSize(filter.DefaultMaxPooledBufferSize)
+
+	for _, max := range []int64{0, 1 << 10, filter.DefaultMaxPooledBufferSize} {
+
+		filter.SetMaxPooledBufferSize(max)
+
+		for _, filterName := range filter.List() {
+
+			f, err := filter.NewFilter(filterName, nil)
+			if err != nil {
+				t.Fatalf("Problem: %v\n", err)
+			}
+
+			for _, filename := range filenames {
+
+				raw, err := ioutil.ReadFile(filename)
+				if err != nil {
+					t.Fatalf("%s: %v", filename, err)
+				}
+
+				enc, err := f.Encode(bytes.NewReader(raw))
+				if err != nil {
+					t.Fatalf("Problem encoding: %v\n", err)
+				}
+
+				dec, err := f.Decode(enc)
+				if err != nil {
+					t.Fatalf("Problem decoding: %v\n", err)
+				}
+
+				// Recycle the intermediate result.
+				filter.PutBuffer(enc)
+
+				if !bytes.Equal(dec.Bytes(), raw) {
+					t.Fatalf("%s: %s roundtrip mismatch using pool limit %d", filename, filterName, max)
+				}
+			}
+		}
+	}
+
+}
+
+// xorFilter is a custom filter xoring all bytes with a key.
+type xorFilter struct {
+	key byte
+}
+
+func (f xorFilter) xor(r io.Reader) (*bytes.Buffer, error) {
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range b {
+		b[i] ^= f.key
+	}
+
+	return bytes.NewBuffer(b), nil
+}
+
+func (f xorFilter) Encode(r io.Reader) (*bytes.Buffer, error) { return f.xor(r) }
+
+func (f xorFilter) Decode(r io.Reader) (*bytes.Buffer, error) { return f.xor(r) }
+
+func TestRegister(t *testing.T) {
+
+	filterName := "XORDecode"
+
+	filter.Register(filterName, func(parms map[string]int) (filter.Filter, error) {
+		return xorFilter{byte(parms["Key"])}, nil
+	})
+
+	if ss := filter.Registered(); len(ss) != 1 || ss[0] != filterName {
+		t.Fatalf("want registered %s, got %v\n", filterName, ss)
+	}
+
+	encodeDecodeUsingFilterNamed(t, filterName)
+
+	f, err := filter.NewFilter(filterName, map[string]int{"Key": 0x5A})
+	if err != nil {
+		t.Fatalf("Problem: %v\n", err)
+	}
+
+	enc, err := f.Encode(bytes.NewReader([]byte("Hello")))
+	if err != nil {
+		t.Fatalf("Problem encoding: %v\n", err)
+	}
+
+	if enc.Bytes()[0] != 'H'^0x5A {
+		t.Fatalf("parameters not passed to custom filter\n")
+	}
+
+	filter.Unregister(filterName)
+
+	if _, err = filter.NewFilter(filterName, nil); err != filter.ErrUnsupportedFilter {
+		t.Fatalf("want %v, got %v\n", filter.ErrUnsupportedFilter, err)
+	}
+}
@@ -62,17 +62,42 @@ func (f flate) Encode(r io.Reader) (*bytes.Buffer, error) {
 
 	// TODO Optional decode parameters may need predictor preprocessing.
 
-	var b bytes.Buffer
-	w := zlib.NewWriter(&b)
-	defer w.Close()
+	b := newBuffer()
+
+	level, ok := f.parms[CompressionLevel]
+	if !ok {
+		level = zlib.DefaultCompression
+	}
+
+	var w *zlib.Writer
+
+	if level == zlib.DefaultCompression {
+		w = newZlibWriter(b)
+	} else {
+		var err error
+		w, err = zlib.NewWriterLevel(b, level)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	written, err := io.Copy(w, r)
 	if err != nil {
 		return nil, err
 	}
+
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if level == zlib.DefaultCompression {
+		putZlibWriter(w)
+	}
+
 	log.Trace.Printf("EncodeFlate end: %d bytes written\n", written)
 
-	return &b, nil
+	return b, nil
 }
 
 // Decode implements decoding for a Flate filter.
@@ -80,20 +105,24 @@ func (f flate) Decode(r io.Reader) (*bytes.Buffer, error) {
 
 	log.Trace.Println("DecodeFlate begin")
 
-	rc, err := zlib.NewReader(r)
+	rc, err := newZlibReader(r)
 	if err != nil {
 		return nil, err
 	}
-	defer rc.Close()
+
+	defer func() {
+		rc.Close()
+		putZlibReader(rc)
+	}()
 
 	// Optional decode parameters need postprocessing.
 	return f.decodePostProcess(rc)
 }
 
 func passThru(rin io.Reader) (*bytes.Buffer, error) {
-	var b bytes.Buffer
-	_, err := io.Copy(&b, rin)
-	return &b, err
+	b := newBuffer()
+	_, err := io.Copy(b, rin)
+	return b, err
 }
 
 func intMemberOf(i int, list []int) bool {
@@ -281,7 +310,7 @@ func (f flate) decodePostProcess(r io.Reader) (*bytes.Buffer, error) {
 	pr := make([]byte, rowSize)
 
 	// Output buffer
-	var b bytes.Buffer
+	b := newBuffer()
 
 	for {
 
@@ -324,5 +353,5 @@ func (f flate) decodePostProcess(r io.Reader) (*bytes.Buffer, error) {
 		return nil, errors.New("filter FlateDecode: postprocessing failed")
 	}
 
-	return &b, nil
+	return b, nil
 }
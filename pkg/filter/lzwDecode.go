@@ -34,14 +34,14 @@ func (f lzwDecode) Encode(r io.Reader) (*bytes.Buffer, error) {
 
 	log.Trace.Println("EncodeLZW begin")
 
-	var b bytes.Buffer
+	b := newBuffer()
 
 	ec, ok := f.parms["EarlyChange"]
 	if !ok {
 		ec = 1
 	}
 
-	wc := lzw.NewWriter(&b, ec == 1)
+	wc := lzw.NewWriter(b, ec == 1)
 	defer wc.Close()
 
 	written, err := io.Copy(wc, r)
@@ -50,7 +50,7 @@ func (f lzwDecode) Encode(r io.Reader) (*bytes.Buffer, error) {
 	}
 	log.Trace.Printf("EncodeLZW end: %d bytes written\n", written)
 
-	return &b, nil
+	return b, nil
 }
 
 // Decode implements decoding for an LZWDecode filter.
@@ -71,12 +71,12 @@ func (f lzwDecode) Decode(r io.Reader) (*bytes.Buffer, error) {
 	rc := lzw.NewReader(r, ec == 1)
 	defer rc.Close()
 
-	var b bytes.Buffer
-	written, err := io.Copy(&b, rc)
+	b := newBuffer()
+	written, err := io.Copy(b, rc)
 	if err != nil {
 		return nil, err
 	}
 	log.Trace.Printf("DecodeLZW: decoded %d bytes.\n", written)
 
-	return &b, nil
+	return b, nil
 }
@@ -0,0 +1,105 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filter
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultMaxPooledBufferSize is the default capacity limit for buffers kept for reuse.
+const DefaultMaxPooledBufferSize = 1 << 22 // 4 MB
+
+var (
+	maxPooledBufferSize int64 = DefaultMaxPooledBufferSize
+
+	bufferPool     sync.Pool
+	zlibWriterPool sync.Pool
+	zlibReaderPool sync.Pool
+)
+
+// SetMaxPooledBufferSize sets the capacity limit in bytes for buffers kept for reuse.
+// Bigger buffers are left to the garbage collector.
+// A limit <= 0 turns off buffer pooling.
+func SetMaxPooledBufferSize(n int64) {
+	atomic.StoreInt64(&maxPooledBufferSize, n)
+}
+
+// MaxPooledBufferSize returns the capacity limit in bytes for buffers kept for reuse.
+func MaxPooledBufferSize() int64 {
+	return atomic.LoadInt64(&maxPooledBufferSize)
+}
+
+// newBuffer returns an empty buffer, preferably a recycled one.
+func newBuffer() *bytes.Buffer {
+
+	if b, ok := bufferPool.Get().(*bytes.Buffer); ok {
+		return b
+	}
+
+	return &bytes.Buffer{}
+}
+
+// PutBuffer hands back a buffer returned by Encode or Decode for reuse.
+// The caller must not retain any reference to the buffer or its content.
+func PutBuffer(b *bytes.Buffer) {
+
+	if b == nil {
+		return
+	}
+
+	max := MaxPooledBufferSize()
+	if max <= 0 || int64(b.Cap()) > max {
+		return
+	}
+
+	b.Reset()
+	bufferPool.Put(b)
+}
+
+func newZlibWriter(w io.Writer) *zlib.Writer {
+
+	if zw, ok := zlibWriterPool.Get().(*zlib.Writer); ok {
+		zw.Reset(w)
+		return zw
+	}
+
+	return zlib.NewWriter(w)
+}
+
+func putZlibWriter(zw *zlib.Writer) {
+	zlibWriterPool.Put(zw)
+}
+
+func newZlibReader(r io.Reader) (io.ReadCloser, error) {
+
+	if zr, ok := zlibReaderPool.Get().(io.ReadCloser); ok {
+		if err := zr.(zlib.Resetter).Reset(r, nil); err != nil {
+			return nil, err
+		}
+		return zr, nil
+	}
+
+	return zlib.NewReader(r)
+}
+
+func putZlibReader(zr io.ReadCloser) {
+	zlibReaderPool.Put(zr)
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filter
+
+import (
+	"sort"
+	"sync"
+)
+
+// Factory returns a filter for an optional parameter dictionary.
+type Factory func(parms map[string]int) (Filter, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Factory{}
+)
+
+// Register makes a custom filter available under filterName for encoding and decoding streams,
+// eg. a proprietary filter or a Crypt filter.
+// A filter registered under the name of a filter implemented by pdfcpu takes precedence.
+// Buffers returned by custom filters never get recycled, so filters may return the buffer they read from or retain them.
+func Register(filterName string, f Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[filterName] = f
+}
+
+// Unregister removes a custom filter registered under filterName.
+func Unregister(filterName string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	delete(registry, filterName)
+}
+
+// Registered returns the sorted names of all custom filters.
+func Registered() []string {
+
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	ss := make([]string, 0, len(registry))
+	for k := range registry {
+		ss = append(ss, k)
+	}
+	sort.Strings(ss)
+
+	return ss
+}
+
+// IsRegistered returns true if a custom filter is registered under filterName.
+func IsRegistered(filterName string) bool {
+	_, ok := registeredFilter(filterName)
+	return ok
+}
+
+func registeredFilter(filterName string) (Factory, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	f, ok := registry[filterName]
+	return f, ok
+}
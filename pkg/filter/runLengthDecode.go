@@ -120,10 +120,10 @@ func (f runLengthDecode) Encode(r io.Reader) (*bytes.Buffer, error) {
 		return nil, err
 	}
 
-	var b bytes.Buffer
-	f.encode(&b, p)
+	b := newBuffer()
+	f.encode(b, p)
 
-	return &b, nil
+	return b, nil
 }
 
 // Decode implements decoding for an RunLengthDecode filter.
@@ -134,8 +134,8 @@ func (f runLengthDecode) Decode(r io.Reader) (*bytes.Buffer, error) {
 		return nil, err
 	}
 
-	var b bytes.Buffer
-	f.decode(&b, p)
+	b := newBuffer()
+	f.decode(b, p)
 
-	return &b, nil
+	return b, nil
 }
@@ -0,0 +1,585 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/jplu/pdfcpu/pkg/fonts/metrics"
+	"github.com/jplu/pdfcpu/pkg/log"
+	"github.com/jplu/pdfcpu/pkg/types"
+	"github.com/pkg/errors"
+)
+
+// addAnnotation adds the annotation d to the annotations of pageDict.
+func addAnnotation(xRefTable *XRefTable, pageDict, d Dict) (*IndirectRef, error) {
+
+	ir, err := xRefTable.IndRefForNewObject(d)
+	if err != nil {
+		return nil, err
+	}
+
+	o, err := xRefTable.Dereference(pageDict["Annots"])
+	if err != nil {
+		return nil, err
+	}
+
+	annots, _ := o.(Array)
+	pageDict.Update("Annots", append(annots, *ir))
+
+	return ir, nil
+}
+
+// markupAnnotationDict returns a new markup annotation dict of the given subtype for page.
+func markupAnnotationDict(xRefTable *XRefTable, subtype string, page int, r types.Rectangle, contents, author string) (Dict, Dict, error) {
+
+	if page < 1 || page > xRefTable.PageCount {
+		return nil, nil, NewError(ErrPageOutOfRange, "%s annotation: invalid page %d", subtype, page)
+	}
+
+	if r.Width() <= 0 || r.Height() <= 0 {
+		return nil, nil, errors.Errorf("%s annotation: invalid rectangle %s", subtype, r)
+	}
+
+	pageDict, _, err := xRefTable.PageDict(page)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := StringLiteral(DateString(xRefTable.currentTime()))
+
+	d := Dict(
+		map[string]Object{
+			"Type":         Name("Annot"),
+			"Subtype":      Name(subtype),
+			"Rect":         NewRectangle(r.LL.X, r.LL.Y, r.UR.X, r.UR.Y),
+			"F":            Integer(4), // Print
+			"M":            now,
+			"CreationDate": now,
+		},
+	)
+
+	for k, s := range map[string]string{"Contents": contents, "T": author} {
+		if len(s) == 0 {
+			continue
+		}
+		ts, err := textString(s)
+		if err != nil {
+			return nil, nil, err
+		}
+		d.Insert(k, ts)
+	}
+
+	return pageDict, d, nil
+}
+
+// The standard stamp names, see 12.5.6.12 Rubber Stamp Annotations.
+var stampNames = []string{
+	"Approved", "Experimental", "NotApproved", "AsIs", "Expired", "NotForPublicRelease", "Confidential",
+	"Final", "Sold", "Departmental", "ForComment", "TopSecret", "Draft", "ForPublicRelease",
+}
+
+// StampAnnotation describes a rubber stamp annotation.
+type StampAnnotation struct {
+	Page      int             // Page the stamp is placed on.
+	Rect      types.Rectangle // Stamp rectangle in default user space units.
+	Name      string          // Icon name, one of the standard stamp names like Approved or Draft, defaults to Draft.
+	ImageFile string          // Optional PNG or TIFF file used for the appearance instead of the label for Name.
+	Contents  string          // Optional comment.
+	Author    string          // Optional author.
+}
+
+func (sa StampAnnotation) String() string {
+	return fmt.Sprintf("%s page:%d rect:%s", sa.name(), sa.Page, sa.Rect)
+}
+
+func (sa StampAnnotation) name() string {
+	if sa.Name == "" {
+		return "Draft"
+	}
+	return sa.Name
+}
+
+// stampLabel returns the text and RGB color used for the appearance of a standard stamp.
+func stampLabel(name string) (string, string) {
+
+	var b strings.Builder
+	for i, r := range name {
+		if i > 0 && r >= 'A' && r <= 'Z' {
+			b.WriteRune(' ')
+		}
+		b.WriteRune(r)
+	}
+
+	s := strings.ToUpper(b.String())
+
+	switch name {
+	case "Approved", "Final", "ForPublicRelease", "Sold":
+		return s, "0.13 0.55 0.13"
+	case "NotApproved", "Expired", "NotForPublicRelease", "Confidential", "TopSecret":
+		return s, "0.80 0.10 0.10"
+	}
+
+	return s, "0.10 0.20 0.60"
+}
+
+// stampLabelAppearance renders the label of a standard stamp into a box of size r.
+func stampLabelAppearance(xRefTable *XRefTable, r types.Rectangle, name string) (*IndirectRef, error) {
+
+	font, err := fontDictIndRef(xRefTable, "Helvetica-Bold")
+	if err != nil {
+		return nil, err
+	}
+
+	s, col := stampLabel(name)
+
+	w, h := r.Width(), r.Height()
+
+	// Metrics cover Helvetica only, Helvetica-Bold is about 5% wider.
+	gw := metrics.TextWidth(s, "Helvetica", 1000) * 1.05
+
+	size := h * .5
+	if max := w * .85 * 1000 / gw; max < size {
+		size = max
+	}
+	tw := gw * size / 1000
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "q %s RG %s rg 3 w 1.5 1.5 %.2f %.2f re S ", col, col, w-3, h-3)
+	fmt.Fprintf(&b, "BT /Helvetica-Bold %.2f Tf %.2f %.2f Td (%s) Tj ET Q", size, (w-tw)/2, (h-size*.7)/2, s)
+
+	return formXObject(xRefTable, r, b.Bytes(), "Helvetica-Bold", font)
+}
+
+// fontDictIndRef returns a new font dict for the Adobe base font fontName.
+func fontDictIndRef(xRefTable *XRefTable, fontName string) (*IndirectRef, error) {
+
+	d := NewDict()
+	d.InsertName("Type", "Font")
+	d.InsertName("Subtype", "Type1")
+	d.InsertName("BaseFont", fontName)
+	d.InsertName("Encoding", "WinAnsiEncoding")
+
+	return xRefTable.IndRefForNewObject(d)
+}
+
+// stampImageAppearance renders the image read from fileName scaled to fit into r, preserving its aspect ratio.
+func stampImageAppearance(xRefTable *XRefTable, r types.Rectangle, fileName string) (*IndirectRef, error) {
+
+	f := ReadTIFFFile
+	if strings.ToLower(filepath.Ext(fileName)) == ".png" {
+		f = ReadPNGFile
+	}
+
+	sd, err := f(xRefTable, fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := xRefTable.IndRefForNewObject(*sd)
+	if err != nil {
+		return nil, err
+	}
+
+	w, h := r.Width(), r.Height()
+	iw, ih := float64(*sd.IntEntry("Width")), float64(*sd.IntEntry("Height"))
+
+	scale := w / iw
+	if s := h / ih; s < scale {
+		scale = s
+	}
+	iw, ih = iw*scale, ih*scale
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "q %.2f 0 0 %.2f %.2f %.2f cm /Im0 Do Q", iw, ih, (w-iw)/2, (h-ih)/2)
+
+	d := Dict(
+		map[string]Object{
+			"Type":      Name("XObject"),
+			"Subtype":   Name("Form"),
+			"FormType":  Integer(1),
+			"BBox":      NewRectangle(0, 0, w, h),
+			"Matrix":    NewIntegerArray(1, 0, 0, 1, 0, 0),
+			"Resources": Dict(map[string]Object{"XObject": Dict(map[string]Object{"Im0": *img})}),
+		},
+	)
+
+	ap := &StreamDict{Dict: d, Content: b.Bytes()}
+
+	if err := encodeStream(ap); err != nil {
+		return nil, err
+	}
+
+	return xRefTable.IndRefForNewObject(*ap)
+}
+
+func addStampAnnotation(xRefTable *XRefTable, sa StampAnnotation) error {
+
+	name := sa.name()
+
+	standard := false
+	for _, s := range stampNames {
+		if s == name {
+			standard = true
+		}
+	}
+
+	if !standard && sa.ImageFile == "" {
+		return errors.Errorf("stamp annotation %s: custom stamps need an image", name)
+	}
+
+	pageDict, d, err := markupAnnotationDict(xRefTable, "Stamp", sa.Page, sa.Rect, sa.Contents, sa.Author)
+	if err != nil {
+		return err
+	}
+
+	var ap *IndirectRef
+	if sa.ImageFile != "" {
+		ap, err = stampImageAppearance(xRefTable, sa.Rect, sa.ImageFile)
+	} else {
+		ap, err = stampLabelAppearance(xRefTable, sa.Rect, name)
+	}
+	if err != nil {
+		return err
+	}
+
+	d.Insert("Name", Name(name))
+	d.Insert("AP", Dict(map[string]Object{"N": *ap}))
+
+	_, err = addAnnotation(xRefTable, pageDict, d)
+
+	return err
+}
+
+// AddStampAnnotations adds rubber stamp annotations with generated appearances.
+// Unlike watermarks stamps are annotations, so they remain editable in any viewer.
+// Standard stamps without an image show their label, custom stamp names require an image.
+func AddStampAnnotations(xRefTable *XRefTable, stamps []StampAnnotation) error {
+
+	for _, sa := range stamps {
+
+		log.Debug.Printf("AddStampAnnotations: %s\n", sa)
+
+		if err := addStampAnnotation(xRefTable, sa); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RGBColor represents a DeviceRGB color with intensities between 0 and 1.
+type RGBColor struct {
+	R, G, B float64
+}
+
+func (c RGBColor) String() string {
+	return fmt.Sprintf("%.3f %.3f %.3f", c.R, c.G, c.B)
+}
+
+func (c RGBColor) valid() bool {
+	for _, f := range []float64{c.R, c.G, c.B} {
+		if f < 0 || f > 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// hex returns the CSS representation of c.
+func (c RGBColor) hex() string {
+	return fmt.Sprintf("#%02x%02x%02x", int(c.R*255+.5), int(c.G*255+.5), int(c.B*255+.5))
+}
+
+// FreeTextAnnotation describes a free text annotation displaying text directly on the page.
+type FreeTextAnnotation struct {
+	Page        int
+	Rect        types.Rectangle // Text box in default user space units.
+	Text        string          // Lines break at '\n' and wherever they exceed the text box.
+	Author      string          // Optional author.
+	FontName    string          // One of the Adobe base fonts Helvetica, Times-Roman, Courier. Defaults to Helvetica.
+	FontSize    int             // Defaults to 12.
+	Color       RGBColor        // Text color.
+	Alignment   int             // 0: left, 1: centered, 2: right
+	FillColor   *RGBColor       // Optional background color.
+	BorderColor *RGBColor       // Border and callout line color, defaults to the text color.
+	BorderWidth float64         // 0 means no border.
+	Callout     []types.Point   // Optional callout line of 2 or 3 points from the arrow head to the text box.
+}
+
+func (fa FreeTextAnnotation) String() string {
+	return fmt.Sprintf("page:%d rect:%s callout:%v", fa.Page, fa.Rect, fa.Callout)
+}
+
+func (fa FreeTextAnnotation) fontName() string {
+	if fa.FontName == "" {
+		return "Helvetica"
+	}
+	return fa.FontName
+}
+
+func (fa FreeTextAnnotation) fontSize() int {
+	if fa.FontSize <= 0 {
+		return 12
+	}
+	return fa.FontSize
+}
+
+func (fa FreeTextAnnotation) lineColor() RGBColor {
+	if fa.BorderColor == nil {
+		return fa.Color
+	}
+	return *fa.BorderColor
+}
+
+func (fa FreeTextAnnotation) validate() error {
+
+	if !supportedWatermarkFont(fa.fontName()) {
+		return errors.Errorf("free text annotation: %s is unsupported, try one of Helvetica, Times-Roman, Courier", fa.fontName())
+	}
+
+	if fa.Alignment < 0 || fa.Alignment > 2 {
+		return errors.Errorf("free text annotation: invalid alignment %d", fa.Alignment)
+	}
+
+	if n := len(fa.Callout); n == 1 || n > 3 {
+		return errors.Errorf("free text annotation: a callout line needs 2 or 3 points, got %d", n)
+	}
+
+	if fa.BorderWidth < 0 {
+		return errors.Errorf("free text annotation: invalid border width %.2f", fa.BorderWidth)
+	}
+
+	for _, c := range []*RGBColor{&fa.Color, fa.FillColor, fa.BorderColor} {
+		if c != nil && !c.valid() {
+			return errors.Errorf("free text annotation: invalid color %s", c)
+		}
+	}
+
+	return nil
+}
+
+// arrowHead returns the open arrow head at p pointing away from q.
+func arrowHead(p, q types.Point, l float64) (types.Point, types.Point) {
+
+	dx, dy := p.X-q.X, p.Y-q.Y
+	d := math.Hypot(dx, dy)
+	if d == 0 {
+		return p, p
+	}
+	dx, dy = dx/d*l, dy/d*l
+
+	// Rotate the reversed direction by +/- 30 degrees.
+	sin, cos := math.Sin(math.Pi/6), math.Cos(math.Pi/6)
+
+	return types.Point{X: p.X - (dx*cos - dy*sin), Y: p.Y - (dx*sin + dy*cos)},
+		types.Point{X: p.X - (dx*cos + dy*sin), Y: p.Y - (-dx*sin + dy*cos)}
+}
+
+// freeTextRect returns the annotation rectangle enclosing the text box and the callout line.
+func (fa FreeTextAnnotation) freeTextRect(arrowLen float64) types.Rectangle {
+
+	r := fa.Rect
+
+	for _, p := range fa.Callout {
+		r.LL.X = math.Min(r.LL.X, p.X-arrowLen)
+		r.LL.Y = math.Min(r.LL.Y, p.Y-arrowLen)
+		r.UR.X = math.Max(r.UR.X, p.X+arrowLen)
+		r.UR.Y = math.Max(r.UR.Y, p.Y+arrowLen)
+	}
+
+	return r
+}
+
+// freeTextContent renders the text box and the callout line in default user space.
+func (fa FreeTextAnnotation) freeTextContent(font fieldFont, lines []string, arrowLen float64) []byte {
+
+	var b bytes.Buffer
+
+	r, bw := fa.Rect, fa.BorderWidth
+	lc := fa.lineColor()
+
+	if fa.FillColor != nil {
+		fmt.Fprintf(&b, "q %s rg %.2f %.2f %.2f %.2f re f Q ", fa.FillColor, r.LL.X, r.LL.Y, r.Width(), r.Height())
+	}
+
+	if bw > 0 {
+		fmt.Fprintf(&b, "q %s RG %.2f w %.2f %.2f %.2f %.2f re S Q ", lc, bw, r.LL.X+bw/2, r.LL.Y+bw/2, r.Width()-bw, r.Height()-bw)
+	}
+
+	if len(fa.Callout) > 0 {
+		w := math.Max(bw, 1)
+		fmt.Fprintf(&b, "q %s RG %.2f w ", lc, w)
+		for i, p := range fa.Callout {
+			op := "l"
+			if i == 0 {
+				op = "m"
+			}
+			fmt.Fprintf(&b, "%.2f %.2f %s ", p.X, p.Y, op)
+		}
+		p1, p2 := arrowHead(fa.Callout[0], fa.Callout[1], arrowLen)
+		p := fa.Callout[0]
+		fmt.Fprintf(&b, "S %.2f %.2f m %.2f %.2f l %.2f %.2f l S Q ", p1.X, p1.Y, p.X, p.Y, p2.X, p2.Y)
+	}
+
+	pad := bw + 2
+	size := float64(fa.fontSize())
+	leading := size * 1.15
+
+	fmt.Fprintf(&b, "q %.2f %.2f %.2f %.2f re W n ", r.LL.X+bw, r.LL.Y+bw, r.Width()-2*bw, r.Height()-2*bw)
+	fmt.Fprintf(&b, "BT /%s %.2f Tf %s rg ", font.name, size, fa.Color)
+
+	for i, l := range lines {
+		x := r.LL.X + pad
+		switch tw := font.textWidth(l, size); fa.Alignment {
+		case 1:
+			x = r.LL.X + (r.Width()-tw)/2
+		case 2:
+			x = r.UR.X - pad - tw
+		}
+		writeTextLine(&b, l, x, r.UR.Y-pad-.8*size-leading*float64(i))
+	}
+
+	b.WriteString("ET Q")
+
+	return b.Bytes()
+}
+
+// richText returns the rich text representation of text using the default style ds.
+func richText(text, ds string) string {
+
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, `<?xml version="1.0"?><body xmlns="http://www.w3.org/1999/xhtml" xmlns:xfa="http://www.xfa.org/schema/xfa-data/1.0/" xfa:spec="2.0.2" style="%s">`, ds)
+	for _, s := range strings.Split(text, "\n") {
+		fmt.Fprintf(&b, "<p>%s</p>", html.EscapeString(s))
+	}
+	b.WriteString("</body>")
+
+	return b.String()
+}
+
+func addFreeTextAnnotation(xRefTable *XRefTable, fa FreeTextAnnotation) error {
+
+	if err := fa.validate(); err != nil {
+		return err
+	}
+
+	text, err := latin1(strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(fa.Text))
+	if err != nil {
+		return errors.Wrap(err, "free text annotation")
+	}
+
+	arrowLen := 6 + 2*fa.BorderWidth
+	r := fa.freeTextRect(arrowLen)
+
+	pageDict, d, err := markupAnnotationDict(xRefTable, "FreeText", fa.Page, r, fa.Text, fa.Author)
+	if err != nil {
+		return err
+	}
+
+	fontName, size := fa.fontName(), fa.fontSize()
+
+	ir, err := fontDictIndRef(xRefTable, fontName)
+	if err != nil {
+		return err
+	}
+
+	font := fieldFont{name: fontName, ir: ir, baseFont: fontName}
+	ft := fieldText{font: &font}
+	lines := ft.wrap(text, fa.Rect.Width()-2*(fa.BorderWidth+2), float64(size))
+
+	sd := &StreamDict{
+		Dict: Dict(
+			map[string]Object{
+				"Type":      Name("XObject"),
+				"Subtype":   Name("Form"),
+				"FormType":  Integer(1),
+				"BBox":      NewRectangle(r.LL.X, r.LL.Y, r.UR.X, r.UR.Y),
+				"Matrix":    NewIntegerArray(1, 0, 0, 1, 0, 0),
+				"Resources": Dict(map[string]Object{"Font": Dict(map[string]Object{fontName: *ir})}),
+			},
+		),
+		Content: fa.freeTextContent(font, lines, arrowLen),
+	}
+
+	if err = encodeStream(sd); err != nil {
+		return err
+	}
+
+	ap, err := xRefTable.IndRefForNewObject(*sd)
+	if err != nil {
+		return err
+	}
+
+	align := []string{"left", "center", "right"}[fa.Alignment]
+	ds := fmt.Sprintf("font: %s %dpt; text-align:%s; color:%s", fontName, size, align, fa.Color.hex())
+
+	rc, err := textString(richText(text, ds))
+	if err != nil {
+		return err
+	}
+
+	// Viewers use the stroke color for the border and the callout line.
+	d.Insert("DA", StringLiteral(fmt.Sprintf("/%s %d Tf %s rg %s RG", fontName, size, fa.Color, fa.lineColor())))
+	d.Insert("DS", StringLiteral(ds))
+	d.Insert("RC", rc)
+	d.Insert("Q", Integer(fa.Alignment))
+	d.Insert("BS", Dict(map[string]Object{"W": Float(fa.BorderWidth)}))
+	d.Insert("RD", NewRectangle(fa.Rect.LL.X-r.LL.X, fa.Rect.LL.Y-r.LL.Y, r.UR.X-fa.Rect.UR.X, r.UR.Y-fa.Rect.UR.Y))
+	d.Insert("AP", Dict(map[string]Object{"N": *ap}))
+
+	if fa.FillColor != nil {
+		// The background color.
+		d.Insert("C", NewNumberArray(fa.FillColor.R, fa.FillColor.G, fa.FillColor.B))
+	}
+
+	if len(fa.Callout) > 0 {
+		var cl []float64
+		for _, p := range fa.Callout {
+			cl = append(cl, p.X, p.Y)
+		}
+		d.Insert("IT", Name("FreeTextCallout"))
+		d.Insert("CL", NewNumberArray(cl...))
+		d.Insert("LE", Name("OpenArrow"))
+	}
+
+	_, err = addAnnotation(xRefTable, pageDict, d)
+
+	return err
+}
+
+// AddFreeTextAnnotations adds free text annotations with generated appearances,
+// eg. for injecting review comments programmatically.
+func AddFreeTextAnnotations(xRefTable *XRefTable, annots []FreeTextAnnotation) error {
+
+	for _, fa := range annots {
+
+		log.Debug.Printf("AddFreeTextAnnotations: %s\n", fa)
+
+		if err := addFreeTextAnnotation(xRefTable, fa); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,356 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jplu/pdfcpu/pkg/log"
+	"github.com/jplu/pdfcpu/pkg/types"
+	"github.com/pkg/errors"
+)
+
+// The markup annotation subtypes, see 12.5.6.2 Markup Annotations.
+var markupAnnotationTypes = []string{
+	"Text", "FreeText", "Line", "Square", "Circle", "Polygon", "PolyLine", "Highlight", "Underline",
+	"Squiggly", "StrikeOut", "Stamp", "Caret", "Ink", "FileAttachment", "Sound", "Redact",
+}
+
+// textMarkupAnnotationTypes need QuadPoints.
+var textMarkupAnnotationTypes = []string{"Highlight", "Underline", "Squiggly", "StrikeOut"}
+
+// Annotation is the exchange format for markup annotations used to round-trip comments with external review tools.
+//
+// JSON example:
+//
+//	{"type":"Highlight","page":1,"rect":[72,700,300,712],"color":[1,1,0],"contents":"Check this",
+//	 "author":"Jane","created":"2019-03-01T10:00:00Z","quadPoints":[72,712,300,712,72,700,300,700]}
+type Annotation struct {
+	ID         string     `json:"id,omitempty"`    // The unique annotation name NM, identifies annotations to be updated on import.
+	Type       string     `json:"type"`            // The annotation subtype, eg. Text, FreeText, Highlight, Square, Stamp.
+	Page       int        `json:"page"`            // The page number starting at 1.
+	Rect       [4]float64 `json:"rect"`            // llx, lly, urx, ury in default user space units.
+	Color      []float64  `json:"color,omitempty"` // Gray, RGB or CMYK components between 0 and 1.
+	Contents   string     `json:"contents,omitempty"`
+	Author     string     `json:"author,omitempty"`
+	Subject    string     `json:"subject,omitempty"`
+	Icon       string     `json:"icon,omitempty"` // Name of the icon of Text and Stamp annotations.
+	Created    *time.Time `json:"created,omitempty"`
+	Modified   *time.Time `json:"modified,omitempty"`
+	QuadPoints []float64  `json:"quadPoints,omitempty"` // Text markup annotations: 8 numbers per quadrilateral.
+}
+
+func (a Annotation) String() string {
+	return fmt.Sprintf("%s page:%d rect:%v", a.Type, a.Page, a.Rect)
+}
+
+func (a Annotation) validate(pageCount int) error {
+
+	if !MemberOf(a.Type, markupAnnotationTypes) {
+		return errors.Errorf("annotation %s: unsupported type", a)
+	}
+
+	if a.Page < 1 || a.Page > pageCount {
+		return NewError(ErrPageOutOfRange, "annotation %s: invalid page", a)
+	}
+
+	if a.Rect[2] <= a.Rect[0] || a.Rect[3] <= a.Rect[1] {
+		return errors.Errorf("annotation %s: invalid rectangle", a)
+	}
+
+	if n := len(a.Color); n != 0 && n != 1 && n != 3 && n != 4 {
+		return errors.Errorf("annotation %s: color needs 1, 3 or 4 components", a)
+	}
+
+	for _, f := range a.Color {
+		if f < 0 || f > 1 {
+			return errors.Errorf("annotation %s: invalid color %v", a, a.Color)
+		}
+	}
+
+	if MemberOf(a.Type, textMarkupAnnotationTypes) && (len(a.QuadPoints) == 0 || len(a.QuadPoints)%8 > 0) {
+		return errors.Errorf("annotation %s: quadPoints need 8 numbers per quadrilateral", a)
+	}
+
+	return nil
+}
+
+// textEntry returns the text string entry key of d.
+func textEntry(xRefTable *XRefTable, d Dict, key string) (string, error) {
+
+	o, err := xRefTable.Dereference(d[key])
+	if err != nil || o == nil {
+		return "", err
+	}
+
+	switch s := o.(type) {
+	case StringLiteral:
+		return StringLiteralToString(s.Value())
+	case HexLiteral:
+		return HexLiteralToString(s.Value())
+	}
+
+	return "", errors.Errorf("corrupt entry %s: %v", key, o)
+}
+
+// dateEntry returns the date entry key of d or nil if missing or corrupt.
+func dateEntry(xRefTable *XRefTable, d Dict, key string) *time.Time {
+
+	s, err := textEntry(xRefTable, d, key)
+	if err != nil || s == "" {
+		return nil
+	}
+
+	t, ok := DateTime(s)
+	if !ok {
+		log.Info.Printf("dateEntry: corrupt date %s: %s\n", key, s)
+		return nil
+	}
+
+	return &t
+}
+
+func numberArrayEntry(xRefTable *XRefTable, d Dict, key string) []float64 {
+
+	a, err := xRefTable.DereferenceArray(d[key])
+	if err != nil {
+		return nil
+	}
+
+	var ff []float64
+	for _, o := range a {
+		ff = append(ff, xRefTable.DereferenceNumber(o))
+	}
+
+	return ff
+}
+
+func exportAnnotation(xRefTable *XRefTable, pageNr int, d Dict) (*Annotation, error) {
+
+	r := numberArrayEntry(xRefTable, d, "Rect")
+	if len(r) != 4 {
+		return nil, errors.Errorf("page %d: corrupt annotation rectangle", pageNr)
+	}
+
+	a := Annotation{
+		Type:       *d.Subtype(),
+		Page:       pageNr,
+		Rect:       [4]float64{r[0], r[1], r[2], r[3]},
+		Color:      numberArrayEntry(xRefTable, d, "C"),
+		Created:    dateEntry(xRefTable, d, "CreationDate"),
+		Modified:   dateEntry(xRefTable, d, "M"),
+		QuadPoints: numberArrayEntry(xRefTable, d, "QuadPoints"),
+	}
+
+	var err error
+
+	for k, s := range map[string]*string{"NM": &a.ID, "Contents": &a.Contents, "T": &a.Author, "Subj": &a.Subject} {
+		if *s, err = textEntry(xRefTable, d, k); err != nil {
+			return nil, errors.Wrapf(err, "page %d", pageNr)
+		}
+	}
+
+	if n := d.NameEntry("Name"); n != nil {
+		a.Icon = *n
+	}
+
+	return &a, nil
+}
+
+// ExportAnnotations returns the markup annotations of the selected pages in page order.
+func ExportAnnotations(xRefTable *XRefTable, selectedPages IntSet) ([]Annotation, error) {
+
+	var annots []Annotation
+
+	for _, pageNr := range SortedSelectedPages(selectedPages) {
+
+		pageDict, _, err := xRefTable.PageDict(pageNr)
+		if err != nil {
+			return nil, err
+		}
+		if pageDict == nil {
+			return nil, errors.Errorf("ExportAnnotations: missing page %d", pageNr)
+		}
+
+		a, err := xRefTable.DereferenceArray(pageDict["Annots"])
+		if err != nil {
+			return nil, err
+		}
+
+		for _, o := range a {
+
+			d, err := xRefTable.DereferenceDict(o)
+			if err != nil {
+				return nil, err
+			}
+
+			if d == nil || d.Subtype() == nil || !MemberOf(*d.Subtype(), markupAnnotationTypes) {
+				continue
+			}
+
+			annot, err := exportAnnotation(xRefTable, pageNr, d)
+			if err != nil {
+				return nil, errors.Wrap(err, "ExportAnnotations")
+			}
+
+			annots = append(annots, *annot)
+		}
+	}
+
+	return annots, nil
+}
+
+// annotationByID returns the annotation of pageDict with the unique name id.
+func annotationByID(xRefTable *XRefTable, pageDict Dict, id string) (Dict, error) {
+
+	a, err := xRefTable.DereferenceArray(pageDict["Annots"])
+	if err != nil {
+		return nil, err
+	}
+
+	for _, o := range a {
+		d, err := xRefTable.DereferenceDict(o)
+		if err != nil {
+			return nil, err
+		}
+		if d == nil {
+			continue
+		}
+		if s, err := textEntry(xRefTable, d, "NM"); err == nil && s == id {
+			return d, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func updateTextEntry(d Dict, key, s string) error {
+
+	if s == "" {
+		d.Delete(key)
+		return nil
+	}
+
+	ts, err := textString(s)
+	if err != nil {
+		return errors.Wrapf(err, "entry %s", key)
+	}
+
+	d.Update(key, ts)
+
+	return nil
+}
+
+func updateAnnotation(xRefTable *XRefTable, d Dict, a Annotation) error {
+
+	d.Update("Rect", NewRectangle(a.Rect[0], a.Rect[1], a.Rect[2], a.Rect[3]))
+
+	for k, s := range map[string]string{"NM": a.ID, "Contents": a.Contents, "T": a.Author, "Subj": a.Subject} {
+		if err := updateTextEntry(d, k, s); err != nil {
+			return errors.Wrapf(err, "annotation %s", a)
+		}
+	}
+
+	if len(a.Color) > 0 {
+		d.Update("C", NewNumberArray(a.Color...))
+	} else {
+		d.Delete("C")
+	}
+
+	if len(a.QuadPoints) > 0 {
+		d.Update("QuadPoints", NewNumberArray(a.QuadPoints...))
+	}
+
+	if a.Icon != "" {
+		d.Update("Name", Name(a.Icon))
+	}
+
+	if a.Created != nil {
+		d.Update("CreationDate", StringLiteral(DateString(*a.Created)))
+	}
+
+	modified := xRefTable.currentTime()
+	if a.Modified != nil {
+		modified = *a.Modified
+	}
+	d.Update("M", StringLiteral(DateString(modified)))
+
+	// Any appearance would be outdated.
+	d.Delete("AP")
+
+	return nil
+}
+
+// ImportAnnotations adds markup annotations to the pages of xRefTable and returns the number of annotations imported.
+// Annotations with an ID matching the unique name of an annotation of the same page update this annotation.
+// Imported annotations carry no appearance streams, viewers generate these on demand.
+func ImportAnnotations(xRefTable *XRefTable, annots []Annotation) (int, error) {
+
+	for _, a := range annots {
+		if err := a.validate(xRefTable.PageCount); err != nil {
+			return 0, errors.Wrap(err, "ImportAnnotations")
+		}
+	}
+
+	for _, a := range annots {
+
+		log.Debug.Printf("ImportAnnotations: %s\n", a)
+
+		pageDict, _, err := xRefTable.PageDict(a.Page)
+		if err != nil {
+			return 0, err
+		}
+
+		if a.ID != "" {
+			d, err := annotationByID(xRefTable, pageDict, a.ID)
+			if err != nil {
+				return 0, err
+			}
+			if d != nil {
+				if st := d.Subtype(); st == nil || *st != a.Type {
+					return 0, errors.Errorf("ImportAnnotations: annotation %s: type mismatch for id %s", a, a.ID)
+				}
+				if err = updateAnnotation(xRefTable, d, a); err != nil {
+					return 0, errors.Wrap(err, "ImportAnnotations")
+				}
+				continue
+			}
+		}
+
+		r := types.NewRectangle(a.Rect[0], a.Rect[1], a.Rect[2], a.Rect[3])
+
+		_, d, err := markupAnnotationDict(xRefTable, a.Type, a.Page, r, "", "")
+		if err != nil {
+			return 0, err
+		}
+
+		if err = updateAnnotation(xRefTable, d, a); err != nil {
+			return 0, errors.Wrap(err, "ImportAnnotations")
+		}
+
+		if a.Type == "FreeText" {
+			d.Insert("DA", StringLiteral("/Helvetica 12 Tf 0 g"))
+		}
+
+		if _, err = addAnnotation(xRefTable, pageDict, d); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(annots), nil
+}
@@ -0,0 +1,260 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"path/filepath"
+	"sort"
+
+	"github.com/jplu/pdfcpu/pkg/log"
+	"github.com/pkg/errors"
+)
+
+// AFRelationships lists the relationships of associated files to the PDF component referring to them, see 14.13.2.
+var AFRelationships = []string{"Source", "Data", "Alternative", "Supplement", "EncryptedPayload", "FormData", "Schema", "Unspecified"}
+
+// AssociatedFile represents an entry of an /AF array (PDF 2.0, PDF/A-3).
+// Files are associated with the document unless Page or ObjNr is set.
+type AssociatedFile struct {
+	FileName     string `json:"fileName"`
+	Relationship string `json:"relationship"`
+	Description  string `json:"description,omitempty"`
+	Page         int    `json:"page,omitempty"`  // The page the file is associated with.
+	ObjNr        int    `json:"objNr,omitempty"` // The object the file is associated with, eg. an annotation, image or form XObject.
+}
+
+func associatedFileEntries(xRefTable *XRefTable, d Dict) ([]AssociatedFile, error) {
+
+	a, err := xRefTable.DereferenceArray(d["AF"])
+	if err != nil || a == nil {
+		return nil, err
+	}
+
+	var afs []AssociatedFile
+
+	for _, o := range a {
+
+		fsDict, err := xRefTable.DereferenceDict(o)
+		if err != nil {
+			return nil, err
+		}
+		if fsDict == nil {
+			continue
+		}
+
+		af := AssociatedFile{Relationship: "Unspecified"}
+
+		for _, k := range []string{"UF", "F"} {
+			if o, found := fsDict.Find(k); found {
+				if af.FileName, err = xRefTable.DereferenceText(o); err != nil {
+					return nil, err
+				}
+				break
+			}
+		}
+
+		if n := fsDict.NameEntry("AFRelationship"); n != nil {
+			af.Relationship = *n
+		}
+
+		if o, found := fsDict.Find("Desc"); found {
+			if af.Description, err = xRefTable.DereferenceText(o); err != nil {
+				return nil, err
+			}
+		}
+
+		afs = append(afs, af)
+	}
+
+	return afs, nil
+}
+
+// AssociatedFiles returns the files associated with the document, its pages and other objects.
+func AssociatedFiles(xRefTable *XRefTable) ([]AssociatedFile, error) {
+
+	rootDict, err := xRefTable.Catalog()
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := associatedFileEntries(xRefTable, rootDict)
+	if err != nil {
+		return nil, err
+	}
+
+	for p := 1; p <= xRefTable.PageCount; p++ {
+
+		d, _, err := xRefTable.PageDict(p)
+		if err != nil {
+			return nil, err
+		}
+
+		afs, err := associatedFileEntries(xRefTable, d)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, af := range afs {
+			af.Page = p
+			list = append(list, af)
+		}
+	}
+
+	// Object level associations in object number order.
+	var objNrs []int
+	for objNr, entry := range xRefTable.Table {
+		if entry == nil || entry.Free {
+			continue
+		}
+		objNrs = append(objNrs, objNr)
+	}
+	sort.Ints(objNrs)
+
+	for _, objNr := range objNrs {
+
+		var d Dict
+
+		switch o := xRefTable.Table[objNr].Object.(type) {
+		case Dict:
+			d = o
+		case StreamDict:
+			d = o.Dict
+		}
+
+		// Skip the catalog and pages which have been taken care of.
+		if d == nil || d["AF"] == nil || d.Type() != nil && MemberOf(*d.Type(), []string{"Catalog", "Page", "Pages"}) {
+			continue
+		}
+
+		afs, err := associatedFileEntries(xRefTable, d)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, af := range afs {
+			af.ObjNr = objNr
+			list = append(list, af)
+		}
+	}
+
+	return list, nil
+}
+
+// associatedFileOwner returns the dict af gets associated with.
+func associatedFileOwner(xRefTable *XRefTable, af AssociatedFile) (Dict, error) {
+
+	if af.Page > 0 {
+		d, _, err := xRefTable.PageDict(af.Page)
+		if err != nil {
+			return nil, err
+		}
+		if d == nil {
+			return nil, NewError(ErrPageOutOfRange, "invalid page number: %d", af.Page)
+		}
+		return d, nil
+	}
+
+	if af.ObjNr > 0 {
+		entry, found := xRefTable.FindTableEntryLight(af.ObjNr)
+		if found && entry != nil && !entry.Free {
+			switch o := entry.Object.(type) {
+			case Dict:
+				return o, nil
+			case StreamDict:
+				// Dict is shared with the table entry.
+				return o.Dict, nil
+			}
+		}
+		return nil, errors.Errorf("obj#%d is not a dict", af.ObjNr)
+	}
+
+	return xRefTable.Catalog()
+}
+
+// AddAssociatedFiles embeds files and associates them via /AF with the document, pages or objects.
+// Files associated with the document are also listed as attachments.
+func AddAssociatedFiles(xRefTable *XRefTable, afs []AssociatedFile) error {
+
+	for _, af := range afs {
+
+		if af.Relationship == "" {
+			af.Relationship = "Unspecified"
+		}
+		if !MemberOf(af.Relationship, AFRelationships) {
+			return errors.Errorf("invalid AFRelationship: %s", af.Relationship)
+		}
+
+		d, err := associatedFileOwner(xRefTable, af)
+		if err != nil {
+			return err
+		}
+
+		sd, err := xRefTable.NewEmbeddedFileStreamDict(af.FileName)
+		if err != nil {
+			return err
+		}
+
+		if err = encodeStream(sd); err != nil {
+			return err
+		}
+
+		ir, err := xRefTable.IndRefForNewObject(*sd)
+		if err != nil {
+			return err
+		}
+
+		_, fn := filepath.Split(af.FileName)
+
+		fsDict, err := xRefTable.NewFileSpecDict(fn, *ir)
+		if err != nil {
+			return err
+		}
+
+		fsDict.InsertName("AFRelationship", af.Relationship)
+		if af.Description != "" {
+			if err = updateTextEntry(fsDict, "Desc", af.Description); err != nil {
+				return err
+			}
+		}
+
+		if ir, err = xRefTable.IndRefForNewObject(fsDict); err != nil {
+			return err
+		}
+
+		a, err := xRefTable.DereferenceArray(d["AF"])
+		if err != nil {
+			return err
+		}
+
+		d.Update("AF", append(a, *ir))
+
+		if af.Page == 0 && af.ObjNr == 0 {
+			if xRefTable.Names["EmbeddedFiles"] == nil {
+				if err = xRefTable.LocateNameTree("EmbeddedFiles", true); err != nil {
+					return err
+				}
+			}
+			if err = xRefTable.Names["EmbeddedFiles"].Add(xRefTable, fn, *ir); err != nil {
+				return err
+			}
+		}
+
+		log.Debug.Printf("AddAssociatedFiles: %s (%s)\n", fn, af.Relationship)
+	}
+
+	return nil
+}
@@ -17,9 +17,12 @@ limitations under the License.
 package pdfcpu
 
 import (
-	"io/ioutil"
-	"os"
+	"bytes"
+	"compress/zlib"
+	"io"
+	"io/fs"
 	"path/filepath"
+	"time"
 
 	"github.com/jplu/pdfcpu/pkg/filter"
 	"github.com/jplu/pdfcpu/pkg/log"
@@ -86,29 +89,25 @@ func decodedFileSpecStreamDict(xRefTable *XRefTable, fileName string, o Object)
 	return sd, nil
 }
 
-func extractAttachedFiles(ctx *Context, files StringSet) error {
+// AttachmentFunc consumes the content of an extracted attachment.
+type AttachmentFunc func(fileName string, content []byte) error
 
-	writeFile := func(xRefTable *XRefTable, fileName string, o Object) error {
+func extractAttachedFiles(ctx *Context, files StringSet, f AttachmentFunc) error {
 
-		path := ctx.Write.DirName + "/" + fileName
+	writeFile := func(xRefTable *XRefTable, fileName string, o Object) error {
 
-		log.Debug.Printf("writeFile begin: %s\n", path)
+		log.Debug.Printf("writeFile begin: %s\n", fileName)
 
 		sd, err := decodedFileSpecStreamDict(xRefTable, fileName, o)
-		if err != nil {
+		if err != nil || sd == nil {
 			return err
 		}
 
-		log.Info.Printf("writing %s\n", path)
-
-		// TODO Refactor into returning only stream object numbers for files to be extracted.
-		// No writing to file in library!
-		err = ioutil.WriteFile(path, sd.Content, os.ModePerm)
-		if err != nil {
+		if err = f(fileName, sd.Content); err != nil {
 			return err
 		}
 
-		log.Debug.Printf("writeFile end: %s \n", path)
+		log.Debug.Printf("writeFile end: %s \n", fileName)
 
 		return nil
 	}
@@ -137,18 +136,136 @@ func extractAttachedFiles(ctx *Context, files StringSet) error {
 	return ctx.Names["EmbeddedFiles"].Process(ctx.XRefTable, writeFile)
 }
 
-func fileSpectDict(xRefTable *XRefTable, filename string) (*IndirectRef, error) {
+// AttachmentCompression specifies how the data of an embedded file gets stored.
+type AttachmentCompression int
+
+// The available attachment compressions.
+const (
+	AttachmentCompressionDefault AttachmentCompression = iota // Flate using the default compression level.
+	AttachmentCompressionNone                                 // Uncompressed, eg. for already compressed payloads.
+	AttachmentCompressionBest                                 // Flate using the best compression level.
+)
 
-	sd, err := xRefTable.NewEmbeddedFileStreamDict(filename)
+// AttachmentOptions controls how a file gets embedded.
+type AttachmentOptions struct {
+	Compression AttachmentCompression
+
+	// Stream the file content into the output file at write time instead of reading it into memory.
+	// Encrypted output falls back to reading the file into memory.
+	Stream bool
+}
+
+// zlib compression level in effect.
+func (opts AttachmentOptions) level() int {
+
+	switch opts.Compression {
+
+	case AttachmentCompressionNone:
+		return zlib.NoCompression
+
+	case AttachmentCompressionBest:
+		return zlib.BestCompression
+	}
+
+	return zlib.DefaultCompression
+}
+
+// encodeStreamFlate encodes sd.Content using Flate with a specific zlib compression level.
+func encodeStreamFlate(sd *StreamDict, level int) error {
+
+	f, err := filter.NewFilter(filter.Flate, map[string]int{filter.CompressionLevel: level})
+	if err != nil {
+		return err
+	}
+
+	b, err := f.Encode(bytes.NewReader(sd.Content))
+	if err != nil {
+		return err
+	}
+
+	sd.Raw = b.Bytes()
+
+	streamLength := int64(len(sd.Raw))
+	sd.StreamLength = &streamLength
+	sd.Update("Length", Integer(streamLength))
+
+	return nil
+}
+
+func encodeEmbeddedFileStream(sd *StreamDict, opts AttachmentOptions) error {
+
+	switch opts.Compression {
+
+	case AttachmentCompressionNone:
+		sd.FilterPipeline = nil
+		sd.Delete("Filter")
+
+	case AttachmentCompressionBest:
+		return encodeStreamFlate(sd, opts.level())
+	}
+
+	return encodeStream(sd)
+}
+
+// newStreamedEmbeddedFileStreamDict returns an embedded file stream dict whose content gets streamed from r at write time.
+func (xRefTable *XRefTable) newStreamedEmbeddedFileStreamDict(r io.Reader, modTime time.Time, opts AttachmentOptions) (*StreamDict, error) {
+
+	sd, err := xRefTable.newStreamedStreamDict(r, opts.level())
 	if err != nil {
 		return nil, err
 	}
 
-	err = encodeStream(sd)
+	sd.InsertName("Type", "EmbeddedFile")
+
+	d := NewDict()
+	d.Insert("ModDate", StringLiteral(DateString(modTime)))
+
+	err = xRefTable.withStreamedParams(sd, d)
 	if err != nil {
 		return nil, err
 	}
 
+	return sd, nil
+}
+
+func fileSpectDict(xRefTable *XRefTable, filename string, opts AttachmentOptions) (*IndirectRef, error) {
+
+	var sd *StreamDict
+
+	if opts.Stream {
+
+		fsys := xRefTable.fileSystem()
+
+		fi, err := fs.Stat(fsys, filename)
+		if err != nil {
+			return nil, err
+		}
+
+		sd, err = xRefTable.newStreamedEmbeddedFileStreamDict(&lazyFileReader{fsys: fsys, fileName: filename}, fi.ModTime(), opts)
+		if err != nil {
+			return nil, err
+		}
+
+	} else {
+
+		var err error
+
+		sd, err = xRefTable.NewEmbeddedFileStreamDict(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		err = encodeEmbeddedFileStream(sd, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return fileSpecDictForStreamDict(xRefTable, filename, sd)
+}
+
+func fileSpecDictForStreamDict(xRefTable *XRefTable, filename string, sd *StreamDict) (*IndirectRef, error) {
+
 	ir, err := xRefTable.IndRefForNewObject(*sd)
 	if err != nil {
 		return nil, err
@@ -163,7 +280,7 @@ func fileSpectDict(xRefTable *XRefTable, filename string) (*IndirectRef, error)
 }
 
 // ok returns true if at least one attachment was added.
-func addAttachedFiles(xRefTable *XRefTable, files StringSet) (ok bool, err error) {
+func addAttachedFiles(xRefTable *XRefTable, files map[string]AttachmentOptions) (ok bool, err error) {
 
 	// Ensure a Collection entry in the catalog.
 	err = xRefTable.EnsureCollection()
@@ -171,9 +288,9 @@ func addAttachedFiles(xRefTable *XRefTable, files StringSet) (ok bool, err error
 		return false, err
 	}
 
-	for fileName := range files {
+	for fileName, opts := range files {
 
-		ir, err := fileSpectDict(xRefTable, fileName)
+		ir, err := fileSpectDict(xRefTable, fileName, opts)
 		if err != nil {
 			return false, err
 		}
@@ -276,7 +393,21 @@ func AttachList(xRefTable *XRefTable) (list []string, err error) {
 
 // AttachExtract exports specified embedded files.
 // If no files specified extract all embedded files.
-func AttachExtract(ctx *Context, files StringSet) (err error) {
+func AttachExtract(ctx *Context, files StringSet) error {
+
+	return AttachExtractFunc(ctx, files, func(fileName string, content []byte) error {
+
+		path := filepath.Join(ctx.Write.DirName, fileName)
+
+		log.Info.Printf("writing %s\n", path)
+
+		return WriteFile(ctx.FileSystem(), path, content, ExtractFilePerm)
+	})
+}
+
+// AttachExtractFunc passes the content of specified embedded files to f.
+// If no files specified all embedded files get extracted.
+func AttachExtractFunc(ctx *Context, files StringSet, f AttachmentFunc) (err error) {
 
 	log.Debug.Println("Extract begin")
 
@@ -291,7 +422,7 @@ func AttachExtract(ctx *Context, files StringSet) (err error) {
 		return errors.Errorf("no attachments available.")
 	}
 
-	err = extractAttachedFiles(ctx, files)
+	err = extractAttachedFiles(ctx, files, f)
 	if err != nil {
 		return err
 	}
@@ -301,11 +432,24 @@ func AttachExtract(ctx *Context, files StringSet) (err error) {
 	return nil
 }
 
-// AttachAdd embeds specified files.
+// AttachAdd embeds specified files using default options.
 // Existing attachments are replaced.
 // ok returns true if at least one attachment was added.
 func AttachAdd(xRefTable *XRefTable, files StringSet) (ok bool, err error) {
 
+	m := map[string]AttachmentOptions{}
+	for fileName := range files {
+		m[fileName] = AttachmentOptions{}
+	}
+
+	return AttachAddWithOptions(xRefTable, m)
+}
+
+// AttachAddWithOptions embeds specified files using individual options.
+// Existing attachments are replaced.
+// ok returns true if at least one attachment was added.
+func AttachAddWithOptions(xRefTable *XRefTable, files map[string]AttachmentOptions) (ok bool, err error) {
+
 	log.Debug.Println("Add begin")
 
 	if xRefTable.Names["EmbeddedFiles"] == nil {
@@ -322,6 +466,45 @@ func AttachAdd(xRefTable *XRefTable, files StringSet) (ok bool, err error) {
 	return ok, err
 }
 
+// AttachAddFromReader embeds the content of r as attachment fileName.
+// Instead of being read into memory the content gets streamed into the output file in chunks at write time,
+// so r must stay readable until the context has been written and can be written only once.
+// Encrypted output falls back to reading r into memory.
+// An existing attachment named fileName is replaced.
+func AttachAddFromReader(xRefTable *XRefTable, fileName string, r io.Reader, modTime time.Time, opts AttachmentOptions) error {
+
+	log.Debug.Println("AddFromReader begin")
+
+	if xRefTable.Names["EmbeddedFiles"] == nil {
+		err := xRefTable.LocateNameTree("EmbeddedFiles", true)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Ensure a Collection entry in the catalog.
+	err := xRefTable.EnsureCollection()
+	if err != nil {
+		return err
+	}
+
+	sd, err := xRefTable.newStreamedEmbeddedFileStreamDict(r, modTime, opts)
+	if err != nil {
+		return err
+	}
+
+	ir, err := fileSpecDictForStreamDict(xRefTable, fileName, sd)
+	if err != nil {
+		return err
+	}
+
+	err = xRefTable.Names["EmbeddedFiles"].Add(xRefTable, fileName, *ir)
+
+	log.Debug.Println("AddFromReader end")
+
+	return err
+}
+
 // AttachRemove deletes specified embedded files.
 // ok returns true if at least one attachment could be removed.
 func AttachRemove(xRefTable *XRefTable, files StringSet) (ok bool, err error) {
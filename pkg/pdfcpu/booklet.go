@@ -0,0 +1,312 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/jplu/pdfcpu/pkg/filter"
+	"github.com/jplu/pdfcpu/pkg/log"
+	"github.com/jplu/pdfcpu/pkg/types"
+	"github.com/pkg/errors"
+)
+
+// bookletPage is a page to be placed onto a booklet sheet.
+type bookletPage struct {
+	form *IndirectRef
+	vp   types.Rectangle // the visible region of the page.
+	rot  int             // the page rotation, one of 0, 90, 180, 270.
+}
+
+// displaySize returns the size of the page as displayed taking the page rotation into account.
+func (bp bookletPage) displaySize() (float64, float64) {
+	if bp.rot == 90 || bp.rot == 270 {
+		return bp.vp.Height(), bp.vp.Width()
+	}
+	return bp.vp.Width(), bp.vp.Height()
+}
+
+// BookletPageOrder returns the page sequence for saddle-stitched booklet sheets holding pageCount pages.
+// Each pair of consecutive entries makes up the left and right half of one side of a sheet.
+// pageCount gets padded to a multiple of 4, page numbers beyond pageCount represent blank pages.
+func BookletPageOrder(pageCount int) []int {
+
+	n := (pageCount + 3) / 4 * 4
+
+	pp := make([]int, 0, n)
+
+	for i := 0; i < n/2; i++ {
+		if i%2 == 0 {
+			pp = append(pp, n-i, i+1)
+			continue
+		}
+		pp = append(pp, i+1, n-i)
+	}
+
+	return pp
+}
+
+// pageForm returns a form XObject rendering page pageNr.
+func pageForm(xRefTable *XRefTable, pageNr int) (*bookletPage, error) {
+
+	pageDict, inhPAttrs, err := xRefTable.PageDict(pageNr)
+	if err != nil {
+		return nil, err
+	}
+	if pageDict == nil {
+		return nil, errors.Errorf("Booklet: missing page %d", pageNr)
+	}
+
+	content, err := pageContent(xRefTable, pageDict)
+	if err != nil {
+		return nil, err
+	}
+
+	bp := &bookletPage{vp: viewPort(xRefTable, inhPAttrs), rot: inhPAttrs.Rotate() % 360}
+	if bp.rot < 0 {
+		bp.rot += 360
+	}
+
+	d := Dict(
+		map[string]Object{
+			"Type":     Name("XObject"),
+			"Subtype":  Name("Form"),
+			"FormType": Integer(1),
+			"BBox":     NewRectangle(bp.vp.LL.X, bp.vp.LL.Y, bp.vp.UR.X, bp.vp.UR.Y),
+			"Matrix":   NewIntegerArray(1, 0, 0, 1, 0, 0),
+		},
+	)
+
+	// Keep shared resources shared.
+	if o, found := pageDict.Find("Resources"); found {
+		d.Insert("Resources", o)
+	} else if inhPAttrs.Resources() != nil {
+		d.Insert("Resources", inhPAttrs.Resources())
+	}
+
+	if o, found := pageDict.Find("Group"); found {
+		d.Insert("Group", o)
+	}
+
+	sd := &StreamDict{
+		Dict:           d,
+		Content:        content,
+		FilterPipeline: []PDFFilter{{Name: filter.Flate, DecodeParms: nil}}}
+
+	sd.InsertName("Filter", filter.Flate)
+
+	if err = encodeStream(sd); err != nil {
+		return nil, err
+	}
+
+	if bp.form, err = xRefTable.IndRefForNewObject(*sd); err != nil {
+		return nil, err
+	}
+
+	return bp, nil
+}
+
+// placement returns the matrix mapping the form space of bp centered into the cell of size w x h at x, y.
+// See 8.3.4 Transformation Matrices
+func (bp bookletPage) placement(x, y, w, h float64) matrix {
+
+	dw, dh := bp.displaySize()
+
+	s := w / dw
+	if h/dh < s {
+		s = h / dh
+	}
+
+	tx := x + (w-dw*s)/2
+	ty := y + (h-dh*s)/2
+
+	// Move the visible region to the origin, then rotate the page as displayed.
+	m := translationMatrix(-bp.vp.LL.X, -bp.vp.LL.Y)
+
+	switch bp.rot {
+	case 90:
+		m = m.multiply(newMatrix(0, -1, 1, 0, 0, bp.vp.Width()))
+	case 180:
+		m = m.multiply(newMatrix(-1, 0, 0, -1, bp.vp.Width(), bp.vp.Height()))
+	case 270:
+		m = m.multiply(newMatrix(0, 1, -1, 0, bp.vp.Height(), 0))
+	}
+
+	return m.multiply(newMatrix(s, 0, 0, s, tx, ty))
+}
+
+// placeForm returns the content rendering the form formID using m.
+func placeForm(formID string, m matrix) string {
+	return fmt.Sprintf("q %.6f %.6f %.6f %.6f %.4f %.4f cm /%s Do Q\n",
+		m[0][0], m[0][1], m[1][0], m[1][1], m[2][0], m[2][1], formID)
+}
+
+// removePageReferences drops document level data referring to individual pages.
+func removePageReferences(xRefTable *XRefTable) error {
+
+	rootDict, err := xRefTable.Catalog()
+	if err != nil {
+		return err
+	}
+
+	for _, k := range []string{"Outlines", "PageLabels", "StructTreeRoot", "AcroForm", "OpenAction", "Dests"} {
+		rootDict.Delete(k)
+	}
+
+	if _, found := xRefTable.Names["Dests"]; !found {
+		return nil
+	}
+
+	delete(xRefTable.Names, "Dests")
+
+	// Only drop the entry, the destinations refer to pages reachable from elsewhere.
+	// The name tree left behind is not reachable from the root anymore and won't get written.
+	namesDict, err := xRefTable.NamesDict()
+	if err != nil {
+		return err
+	}
+
+	namesDict.Delete("Dests")
+	if namesDict.Len() == 0 {
+		rootDict.Delete("Names")
+	}
+
+	return nil
+}
+
+// Booklet imposes all pages 2-up onto sheets in saddle-stitch order so the output can be printed duplex
+// (flipping on the short edge) and folded into a booklet. The page count gets padded with blank pages to a multiple of 4.
+// Each sheet side is twice as wide as the largest page, pages get scaled to fit their half of a side.
+// Document level data referring to individual pages like outlines, page labels, destinations and form fields gets removed,
+// as do page annotations.
+func Booklet(xRefTable *XRefTable) error {
+
+	log.Debug.Printf("Booklet begin: %d pages\n", xRefTable.PageCount)
+
+	if xRefTable.PageCount == 0 {
+		return errors.New("Booklet: no pages")
+	}
+
+	if err := xRefTable.MaterializeInheritedPageAttrs(); err != nil {
+		return err
+	}
+
+	bps := make([]*bookletPage, xRefTable.PageCount)
+
+	var w, h float64
+
+	for i := range bps {
+
+		bp, err := pageForm(xRefTable, i+1)
+		if err != nil {
+			return err
+		}
+
+		dw, dh := bp.displaySize()
+		if dw > w {
+			w = dw
+		}
+		if dh > h {
+			h = dh
+		}
+
+		bps[i] = bp
+	}
+
+	root, err := xRefTable.Pages()
+	if err != nil {
+		return err
+	}
+
+	rootDict, err := xRefTable.DereferenceDict(*root)
+	if err != nil {
+		return err
+	}
+
+	// The sheet sides replace the original pages.
+	for _, k := range inheritablePageAttrs {
+		rootDict.Delete(k)
+	}
+
+	order := BookletPageOrder(xRefTable.PageCount)
+
+	kids := Array{}
+
+	for i := 0; i < len(order); i += 2 {
+
+		var b bytes.Buffer
+		xObjects := Dict{}
+
+		for j, pageNr := range order[i : i+2] {
+
+			if pageNr > len(bps) {
+				continue
+			}
+
+			formID := fmt.Sprintf("Pg%d", j)
+			xObjects.Insert(formID, *bps[pageNr-1].form)
+			b.WriteString(placeForm(formID, bps[pageNr-1].placement(float64(j)*w, 0, w, h)))
+		}
+
+		sd := &StreamDict{
+			Dict:           NewDict(),
+			Content:        b.Bytes(),
+			FilterPipeline: []PDFFilter{{Name: filter.Flate, DecodeParms: nil}}}
+
+		sd.InsertName("Filter", filter.Flate)
+
+		if err = encodeStream(sd); err != nil {
+			return err
+		}
+
+		contents, err := xRefTable.IndRefForNewObject(*sd)
+		if err != nil {
+			return err
+		}
+
+		d := Dict(
+			map[string]Object{
+				"Type":      Name("Page"),
+				"Parent":    *root,
+				"MediaBox":  NewRectangle(0, 0, 2*w, h),
+				"Resources": Dict(map[string]Object{"XObject": xObjects}),
+				"Contents":  *contents,
+			},
+		)
+
+		ir, err := xRefTable.IndRefForNewObject(d)
+		if err != nil {
+			return err
+		}
+
+		kids = append(kids, *ir)
+	}
+
+	rootDict.Update("Kids", kids)
+	rootDict.Update("Count", Integer(len(kids)))
+
+	xRefTable.PageCount = len(kids)
+
+	if err = removePageReferences(xRefTable); err != nil {
+		return err
+	}
+
+	log.Debug.Printf("Booklet end: %d sheet sides\n", len(kids))
+
+	return nil
+}
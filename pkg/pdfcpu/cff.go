@@ -0,0 +1,1023 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+)
+
+// Compact Font Format (CFF) font programs are embedded as FontFile3 with Subtype Type1C or CIDFontType0C.
+// A bare CFF program cannot be installed, so it gets wrapped into an OpenType container (sfnt version OTTO).
+// See Adobe Technical Notes #5176 (CFF), #5177 (Type 2 Charstrings) and the OpenType specification.
+
+var errCorruptCFF = errors.New("pdfcpu: corrupt CFF font program")
+
+// CFF DICT operators we care about. Two byte operators (12 x) are mapped to 1200+x.
+const (
+	cffOpFontBBox      = 5
+	cffOpCharset       = 15
+	cffOpCharStrings   = 17
+	cffOpPrivate       = 18
+	cffOpDefaultWidthX = 20
+	cffOpNominalWidthX = 21
+	cffOpFontMatrix    = 1207
+	cffOpROS           = 1230
+	cffOpFDArray       = 1236
+	cffOpFDSelect      = 1237
+)
+
+// cffStandardStrings are the standard strings for SIDs 0 to 228 making up the ISOAdobe charset.
+// The remaining standard strings name expert glyphs which have no Unicode mapping of their own.
+var cffStandardStrings = []string{
+	".notdef", "space", "exclam", "quotedbl", "numbersign", "dollar", "percent", "ampersand", "quoteright",
+	"parenleft", "parenright", "asterisk", "plus", "comma", "hyphen", "period", "slash", "zero", "one", "two",
+	"three", "four", "five", "six", "seven", "eight", "nine", "colon", "semicolon", "less", "equal", "greater",
+	"question", "at", "A", "B", "C", "D", "E", "F", "G", "H", "I", "J", "K", "L", "M", "N", "O", "P", "Q", "R",
+	"S", "T", "U", "V", "W", "X", "Y", "Z", "bracketleft", "backslash", "bracketright", "asciicircum",
+	"underscore", "quoteleft", "a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l", "m", "n", "o", "p",
+	"q", "r", "s", "t", "u", "v", "w", "x", "y", "z", "braceleft", "bar", "braceright", "asciitilde",
+	"exclamdown", "cent", "sterling", "fraction", "yen", "florin", "section", "currency", "quotesingle",
+	"quotedblleft", "guillemotleft", "guilsinglleft", "guilsinglright", "fi", "fl", "endash", "dagger",
+	"daggerdbl", "periodcentered", "paragraph", "bullet", "quotesinglbase", "quotedblbase", "quotedblright",
+	"guillemotright", "ellipsis", "perthousand", "questiondown", "grave", "acute", "circumflex", "tilde",
+	"macron", "breve", "dotaccent", "dieresis", "ring", "cedilla", "hungarumlaut", "ogonek", "caron", "emdash",
+	"AE", "ordfeminine", "Lslash", "Oslash", "OE", "ordmasculine", "ae", "dotlessi", "lslash", "oslash", "oe",
+	"germandbls", "onesuperior", "logicalnot", "mu", "trademark", "Eth", "onehalf", "plusminus", "Thorn",
+	"onequarter", "divide", "brokenbar", "degree", "thorn", "threequarters", "twosuperior", "registered",
+	"minus", "eth", "multiply", "threesuperior", "copyright", "Aacute", "Acircumflex", "Adieresis", "Agrave",
+	"Aring", "Atilde", "Ccedilla", "Eacute", "Ecircumflex", "Edieresis", "Egrave", "Iacute", "Icircumflex",
+	"Idieresis", "Igrave", "Ntilde", "Oacute", "Ocircumflex", "Odieresis", "Ograve", "Otilde", "Scaron",
+	"Uacute", "Ucircumflex", "Udieresis", "Ugrave", "Yacute", "Ydieresis", "Zcaron", "aacute", "acircumflex",
+	"adieresis", "agrave", "aring", "atilde", "ccedilla", "eacute", "ecircumflex", "edieresis", "egrave",
+	"iacute", "icircumflex", "idieresis", "igrave", "ntilde", "oacute", "ocircumflex", "odieresis", "ograve",
+	"otilde", "scaron", "uacute", "ucircumflex", "udieresis", "ugrave", "yacute", "ydieresis", "zcaron",
+}
+
+// cffFont holds the parts of a CFF font program needed to build the surrounding OpenType tables.
+type cffFont struct {
+	name       string
+	bbox       [4]int // in glyph space units
+	unitsPerEm int
+	widths     []int    // advance width for each glyph id
+	glyphNames []string // glyph name for each glyph id of a name-keyed font
+	cids       []int    // CID for each glyph id of a CID-keyed font
+}
+
+func (f cffFont) numGlyphs() int {
+	return len(f.widths)
+}
+
+// cffIndex parses the INDEX structure at off and returns its items and the offset following it.
+func cffIndex(b []byte, off int) ([][]byte, int, error) {
+
+	if off < 0 || off+2 > len(b) {
+		return nil, 0, errCorruptCFF
+	}
+
+	count := int(b[off])<<8 | int(b[off+1])
+	if count == 0 {
+		return nil, off + 2, nil
+	}
+
+	if off+3 > len(b) {
+		return nil, 0, errCorruptCFF
+	}
+
+	offSize := int(b[off+2])
+	if offSize < 1 || offSize > 4 {
+		return nil, 0, errCorruptCFF
+	}
+
+	base := off + 3
+	if base+(count+1)*offSize > len(b) {
+		return nil, 0, errCorruptCFF
+	}
+
+	offset := func(i int) int {
+		v := 0
+		for j := 0; j < offSize; j++ {
+			v = v<<8 | int(b[base+i*offSize+j])
+		}
+		return v
+	}
+
+	// Offsets are relative to the byte preceding the object data.
+	data := base + (count+1)*offSize - 1
+
+	items := make([][]byte, count)
+	for i := 0; i < count; i++ {
+		o1, o2 := offset(i), offset(i+1)
+		if o1 < 1 || o2 < o1 || data+o2 > len(b) {
+			return nil, 0, errCorruptCFF
+		}
+		items[i] = b[data+o1 : data+o2]
+	}
+
+	return items, data + offset(count), nil
+}
+
+func cffReal(b []byte, i int) (float64, int, error) {
+
+	var sb strings.Builder
+
+	for ; i < len(b); i++ {
+		for _, nib := range []byte{b[i] >> 4, b[i] & 0x0f} {
+			switch {
+			case nib <= 9:
+				sb.WriteByte('0' + nib)
+			case nib == 0x0a:
+				sb.WriteByte('.')
+			case nib == 0x0b:
+				sb.WriteByte('E')
+			case nib == 0x0c:
+				sb.WriteString("E-")
+			case nib == 0x0e:
+				sb.WriteByte('-')
+			case nib == 0x0f:
+				f, err := strconv.ParseFloat(sb.String(), 64)
+				if err != nil {
+					return 0, 0, errCorruptCFF
+				}
+				return f, i + 1, nil
+			default:
+				return 0, 0, errCorruptCFF
+			}
+		}
+	}
+
+	return 0, 0, errCorruptCFF
+}
+
+// cffDict parses a DICT into a map of operators to operands.
+func cffDict(b []byte) (map[int][]float64, error) {
+
+	d := map[int][]float64{}
+	var operands []float64
+
+	for i := 0; i < len(b); {
+
+		b0 := int(b[i])
+
+		switch {
+
+		case b0 <= 21:
+			op := b0
+			i++
+			if b0 == 12 {
+				if i >= len(b) {
+					return nil, errCorruptCFF
+				}
+				op = 1200 + int(b[i])
+				i++
+			}
+			d[op] = operands
+			operands = nil
+
+		case b0 == 28:
+			if i+3 > len(b) {
+				return nil, errCorruptCFF
+			}
+			operands = append(operands, float64(int16(uint16(b[i+1])<<8|uint16(b[i+2]))))
+			i += 3
+
+		case b0 == 29:
+			if i+5 > len(b) {
+				return nil, errCorruptCFF
+			}
+			v := int32(uint32(b[i+1])<<24 | uint32(b[i+2])<<16 | uint32(b[i+3])<<8 | uint32(b[i+4]))
+			operands = append(operands, float64(v))
+			i += 5
+
+		case b0 == 30:
+			f, j, err := cffReal(b, i+1)
+			if err != nil {
+				return nil, err
+			}
+			operands = append(operands, f)
+			i = j
+
+		case b0 >= 32 && b0 <= 246:
+			operands = append(operands, float64(b0-139))
+			i++
+
+		case b0 >= 247 && b0 <= 254:
+			if i+2 > len(b) {
+				return nil, errCorruptCFF
+			}
+			v := (b0-247)*256 + int(b[i+1]) + 108
+			if b0 >= 251 {
+				v = -(b0-251)*256 - int(b[i+1]) - 108
+			}
+			operands = append(operands, float64(v))
+			i += 2
+
+		default:
+			return nil, errCorruptCFF
+		}
+	}
+
+	return d, nil
+}
+
+// cffPrivateWidths returns defaultWidthX and nominalWidthX of the Private DICT referenced by fontDict.
+func cffPrivateWidths(b []byte, fontDict map[int][]float64) (defaultWidth, nominalWidth float64, err error) {
+
+	p := fontDict[cffOpPrivate]
+	if len(p) != 2 {
+		return 0, 0, nil
+	}
+
+	size, off := int(p[0]), int(p[1])
+	if size < 0 || off < 0 || off+size > len(b) {
+		return 0, 0, errCorruptCFF
+	}
+
+	d, err := cffDict(b[off : off+size])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if v := d[cffOpDefaultWidthX]; len(v) == 1 {
+		defaultWidth = v[0]
+	}
+
+	if v := d[cffOpNominalWidthX]; len(v) == 1 {
+		nominalWidth = v[0]
+	}
+
+	return defaultWidth, nominalWidth, nil
+}
+
+// cffFDSelect returns the FDArray index for each glyph of a CID-keyed font.
+func cffFDSelect(b []byte, off, numGlyphs int) ([]int, error) {
+
+	if off < 0 || off >= len(b) {
+		return nil, errCorruptCFF
+	}
+
+	fds := make([]int, numGlyphs)
+
+	switch b[off] {
+
+	case 0:
+		if off+1+numGlyphs > len(b) {
+			return nil, errCorruptCFF
+		}
+		for i := range fds {
+			fds[i] = int(b[off+1+i])
+		}
+
+	case 3:
+		if off+3 > len(b) {
+			return nil, errCorruptCFF
+		}
+		nRanges := int(b[off+1])<<8 | int(b[off+2])
+		r := off + 3
+		if r+nRanges*3+2 > len(b) {
+			return nil, errCorruptCFF
+		}
+		for i := 0; i < nRanges; i++ {
+			first := int(b[r])<<8 | int(b[r+1])
+			fd := int(b[r+2])
+			next := int(b[r+3])<<8 | int(b[r+4])
+			for gid := first; gid < next && gid < numGlyphs; gid++ {
+				fds[gid] = fd
+			}
+			r += 3
+		}
+
+	default:
+		return nil, errCorruptCFF
+	}
+
+	return fds, nil
+}
+
+// cffCharset returns the SID, or CID for CID-keyed fonts, for each glyph of the charset at off.
+// The predefined expert charsets are not supported.
+func cffCharset(b []byte, off, numGlyphs int, cid bool) ([]int, error) {
+
+	ids := make([]int, numGlyphs)
+
+	if off == 0 && !cid {
+		// ISOAdobe
+		for gid := range ids {
+			ids[gid] = gid
+		}
+		return ids, nil
+	}
+
+	if off <= 2 || off >= len(b) {
+		return nil, errCorruptCFF
+	}
+
+	// Glyph 0 is always .notdef and is omitted.
+	format, r := b[off], off+1
+
+	for gid := 1; gid < numGlyphs; {
+
+		switch format {
+
+		case 0:
+			if r+2 > len(b) {
+				return nil, errCorruptCFF
+			}
+			ids[gid] = int(b[r])<<8 | int(b[r+1])
+			gid++
+			r += 2
+
+		case 1, 2:
+			n := 3
+			if format == 2 {
+				n = 4
+			}
+			if r+n > len(b) {
+				return nil, errCorruptCFF
+			}
+			first := int(b[r])<<8 | int(b[r+1])
+			nLeft := int(b[r+2])
+			if format == 2 {
+				nLeft = nLeft<<8 | int(b[r+3])
+			}
+			for i := 0; i <= nLeft && gid < numGlyphs; i++ {
+				ids[gid] = first + i
+				gid++
+			}
+			r += n
+
+		default:
+			return nil, errCorruptCFF
+		}
+	}
+
+	return ids, nil
+}
+
+// cffGlyphWidth extracts the advance width of a Type 2 charstring.
+// The width is an optional extra operand preceding the first stack clearing operator.
+func cffGlyphWidth(cs []byte, defaultWidth, nominalWidth float64) float64 {
+
+	var first float64
+	n := 0
+
+	for i := 0; i < len(cs); {
+
+		b0 := int(cs[i])
+
+		switch {
+
+		case b0 == 28:
+			if i+3 > len(cs) {
+				return defaultWidth
+			}
+			if n == 0 {
+				first = float64(int16(uint16(cs[i+1])<<8 | uint16(cs[i+2])))
+			}
+			n++
+			i += 3
+
+		case b0 >= 32 && b0 <= 246:
+			if n == 0 {
+				first = float64(b0 - 139)
+			}
+			n++
+			i++
+
+		case b0 >= 247 && b0 <= 254:
+			if i+2 > len(cs) {
+				return defaultWidth
+			}
+			if n == 0 {
+				first = float64((b0-247)*256 + int(cs[i+1]) + 108)
+				if b0 >= 251 {
+					first = float64(-(b0-251)*256 - int(cs[i+1]) - 108)
+				}
+			}
+			n++
+			i += 2
+
+		case b0 == 255:
+			if i+5 > len(cs) {
+				return defaultWidth
+			}
+			if n == 0 {
+				v := int32(uint32(cs[i+1])<<24 | uint32(cs[i+2])<<16 | uint32(cs[i+3])<<8 | uint32(cs[i+4]))
+				first = float64(v) / 65536
+			}
+			n++
+			i += 5
+
+		default:
+			var hasWidth bool
+			switch b0 {
+			case 1, 3, 14, 18, 19, 20, 23: // hstem, vstem, endchar, hstemhm, hintmask, cntrmask, vstemhm
+				hasWidth = n%2 == 1
+			case 21: // rmoveto
+				hasWidth = n > 2
+			case 4, 22: // vmoveto, hmoveto
+				hasWidth = n > 1
+			default:
+				// Subroutine calls and the like before the first stack clearing operator.
+				return defaultWidth
+			}
+			if hasWidth {
+				return nominalWidth + first
+			}
+			return defaultWidth
+		}
+	}
+
+	return defaultWidth
+}
+
+// parseCFF parses a bare CFF font program.
+func parseCFF(b []byte) (*cffFont, error) {
+
+	if len(b) < 4 || b[0] != 1 {
+		return nil, errCorruptCFF
+	}
+
+	names, off, err := cffIndex(b, int(b[2]))
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, errCorruptCFF
+	}
+
+	topDicts, off, err := cffIndex(b, off)
+	if err != nil {
+		return nil, err
+	}
+	if len(topDicts) == 0 {
+		return nil, errCorruptCFF
+	}
+
+	top, err := cffDict(topDicts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	strs, _, err := cffIndex(b, off)
+	if err != nil {
+		return nil, err
+	}
+
+	f := cffFont{name: string(names[0]), unitsPerEm: 1000}
+
+	if m := top[cffOpFontMatrix]; len(m) == 6 && m[0] > 0 {
+		f.unitsPerEm = int(math.Round(1 / m[0]))
+	}
+
+	if bb := top[cffOpFontBBox]; len(bb) == 4 {
+		for i, v := range bb {
+			f.bbox[i] = int(math.Round(v))
+		}
+	}
+
+	cs := top[cffOpCharStrings]
+	if len(cs) != 1 {
+		return nil, errCorruptCFF
+	}
+
+	charStrings, _, err := cffIndex(b, int(cs[0]))
+	if err != nil {
+		return nil, err
+	}
+	if len(charStrings) == 0 {
+		return nil, errCorruptCFF
+	}
+
+	// Each glyph uses the width defaults of its Private DICT.
+	// CID-keyed fonts have one Private DICT per FDArray entry.
+	defaultWidths, nominalWidths := []float64{0}, []float64{0}
+	fds := make([]int, len(charStrings))
+
+	if _, cid := top[cffOpROS]; !cid {
+		defaultWidths[0], nominalWidths[0], err = cffPrivateWidths(b, top)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		fda, fds1 := top[cffOpFDArray], top[cffOpFDSelect]
+		if len(fda) != 1 || len(fds1) != 1 {
+			return nil, errCorruptCFF
+		}
+		fontDicts, _, err := cffIndex(b, int(fda[0]))
+		if err != nil {
+			return nil, err
+		}
+		defaultWidths = make([]float64, len(fontDicts))
+		nominalWidths = make([]float64, len(fontDicts))
+		for i, fd := range fontDicts {
+			d, err := cffDict(fd)
+			if err != nil {
+				return nil, err
+			}
+			if defaultWidths[i], nominalWidths[i], err = cffPrivateWidths(b, d); err != nil {
+				return nil, err
+			}
+		}
+		if fds, err = cffFDSelect(b, int(fds1[0]), len(charStrings)); err != nil {
+			return nil, err
+		}
+	}
+
+	f.widths = make([]int, len(charStrings))
+	for gid, cs := range charStrings {
+		fd := fds[gid]
+		if fd >= len(defaultWidths) {
+			return nil, errCorruptCFF
+		}
+		f.widths[gid] = int(math.Round(cffGlyphWidth(cs, defaultWidths[fd], nominalWidths[fd])))
+	}
+
+	charset := 0
+	if v := top[cffOpCharset]; len(v) == 1 {
+		charset = int(v[0])
+	}
+
+	_, cid := top[cffOpROS]
+
+	ids, err := cffCharset(b, charset, len(charStrings), cid)
+	if err != nil {
+		// Glyphs without names or CIDs do not map to Unicode.
+		return &f, nil
+	}
+
+	if cid {
+		f.cids = ids
+		return &f, nil
+	}
+
+	f.glyphNames = make([]string, len(ids))
+	for gid, sid := range ids {
+		switch {
+		case sid < len(cffStandardStrings):
+			f.glyphNames[gid] = cffStandardStrings[sid]
+		case sid >= 391 && sid-391 < len(strs):
+			f.glyphNames[gid] = string(strs[sid-391])
+		}
+	}
+
+	return &f, nil
+}
+
+// unicodes maps Unicode code points of the BMP to glyph ids.
+// Name-keyed fonts are mapped by glyph names.
+// CID-keyed fonts are mapped by toUnicode, a ToUnicode CMap for Identity encoded character codes.
+func (f cffFont) unicodes(toUnicode *toUnicodeCMap) map[rune]int {
+
+	m := map[rune]int{}
+
+	add := func(s string, gid int) {
+		r := []rune(s)
+		if len(r) != 1 || r[0] > 0xFFFF {
+			return
+		}
+		// The first glyph mapping to a code point wins.
+		if _, ok := m[r[0]]; !ok {
+			m[r[0]] = gid
+		}
+	}
+
+	for gid, name := range f.glyphNames {
+		if gid == 0 || name == "" {
+			continue
+		}
+		if s, ok := glyphText(name); ok {
+			add(s, gid)
+		}
+	}
+
+	if toUnicode != nil {
+		for gid, cid := range f.cids {
+			if gid == 0 {
+				continue
+			}
+			if s, ok := toUnicode.m[string([]byte{byte(cid >> 8), byte(cid)})]; ok {
+				add(s, gid)
+			}
+		}
+	}
+
+	return m
+}
+
+// fontMetrics holds the font wide metrics of a FontDescriptor in glyph space units.
+type fontMetrics struct {
+	ascent, descent int
+	capHeight       int
+	xHeight         int
+	italicAngle     float64
+	weight          int
+	fixedPitch      bool
+	bbox            [4]int
+}
+
+// fontDescriptorMetrics collects the metrics of fontDescriptor scaled to unitsPerEm.
+func fontDescriptorMetrics(xRefTable *XRefTable, fontDescriptor Dict, unitsPerEm int) fontMetrics {
+
+	scale := func(key string) int {
+		o, found := fontDescriptor.Find(key)
+		if !found {
+			return 0
+		}
+		return int(math.Round(xRefTable.DereferenceNumber(o) * float64(unitsPerEm) / 1000))
+	}
+
+	m := fontMetrics{
+		ascent:    scale("Ascent"),
+		descent:   scale("Descent"),
+		capHeight: scale("CapHeight"),
+		xHeight:   scale("XHeight"),
+		weight:    400,
+	}
+
+	if o, found := fontDescriptor.Find("ItalicAngle"); found {
+		m.italicAngle = xRefTable.DereferenceNumber(o)
+	}
+
+	if o, found := fontDescriptor.Find("FontWeight"); found {
+		if w := int(xRefTable.DereferenceNumber(o)); w > 0 {
+			m.weight = w
+		}
+	}
+
+	if o, found := fontDescriptor.Find("Flags"); found {
+		m.fixedPitch = int(xRefTable.DereferenceNumber(o))&1 > 0
+	}
+
+	if a, err := xRefTable.DereferenceArray(fontDescriptor["FontBBox"]); err == nil && len(a) == 4 {
+		for i, o := range a {
+			m.bbox[i] = int(math.Round(xRefTable.DereferenceNumber(o) * float64(unitsPerEm) / 1000))
+		}
+	}
+
+	return m
+}
+
+func putU16(b []byte, v int) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func putU32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func sfntChecksum(b []byte) uint32 {
+	var sum uint32
+	for i := 0; i < len(b); i += 4 {
+		var v uint32
+		for j := 0; j < 4; j++ {
+			v <<= 8
+			if i+j < len(b) {
+				v |= uint32(b[i+j])
+			}
+		}
+		sum += v
+	}
+	return sum
+}
+
+func otfHead(f *cffFont, bbox [4]int) []byte {
+	b := putU32(nil, 0x00010000) // version
+	b = putU32(b, 0x00010000)    // fontRevision
+	b = putU32(b, 0)             // checkSumAdjustment, set once the font is assembled.
+	b = putU32(b, 0x5F0F3CF5)    // magicNumber
+	b = putU16(b, 0x0003)        // flags: baseline at y=0, left sidebearing at x=0
+	b = putU16(b, f.unitsPerEm)
+	b = append(b, make([]byte, 16)...) // created, modified
+	for _, v := range bbox {
+		b = putU16(b, v)
+	}
+	b = putU16(b, 0) // macStyle
+	b = putU16(b, 8) // lowestRecPPEM
+	b = putU16(b, 2) // fontDirectionHint
+	b = putU16(b, 0) // indexToLocFormat
+	return putU16(b, 0)
+}
+
+func otfHhea(f *cffFont, m fontMetrics, bbox [4]int) []byte {
+	maxAdvance := 0
+	for _, w := range f.widths {
+		if w > maxAdvance {
+			maxAdvance = w
+		}
+	}
+	b := putU32(nil, 0x00010000)
+	b = putU16(b, m.ascent)
+	b = putU16(b, m.descent)
+	b = putU16(b, 0) // lineGap
+	b = putU16(b, maxAdvance)
+	b = putU16(b, 0)       // minLeftSideBearing
+	b = putU16(b, 0)       // minRightSideBearing
+	b = putU16(b, bbox[2]) // xMaxExtent
+	b = putU16(b, 1)       // caretSlopeRise
+	b = putU16(b, 0)       // caretSlopeRun
+	b = append(b, make([]byte, 12)...)
+	return putU16(b, f.numGlyphs()) // numberOfHMetrics
+}
+
+func otfHmtx(f *cffFont) []byte {
+	var b []byte
+	for _, w := range f.widths {
+		b = putU16(b, w)
+		b = putU16(b, 0)
+	}
+	return b
+}
+
+func otfMaxp(f *cffFont) []byte {
+	// Version 0.5 is used for fonts with CFF outlines.
+	return putU16(putU32(nil, 0x00005000), f.numGlyphs())
+}
+
+// otfCmap returns a Unicode BMP cmap (format 4) for m mapping code points to glyph ids.
+func otfCmap(m map[rune]int) []byte {
+
+	codes := make([]int, 0, len(m))
+	for r := range m {
+		codes = append(codes, int(r))
+	}
+	sort.Ints(codes)
+
+	// Each segment covers consecutive codes mapping to consecutive glyph ids.
+	type segment struct{ start, end, delta int }
+
+	var segs []segment
+	for _, c := range codes {
+		if c == 0xFFFF {
+			continue
+		}
+		delta := m[rune(c)] - c
+		if n := len(segs); n > 0 && segs[n-1].end == c-1 && segs[n-1].delta == delta {
+			segs[n-1].end = c
+			continue
+		}
+		segs = append(segs, segment{c, c, delta})
+	}
+
+	// The last segment is mandatory and maps 0xFFFF to .notdef.
+	segs = append(segs, segment{0xFFFF, 0xFFFF, 1})
+
+	segCount := len(segs)
+	entrySelector := 0
+	for 1<<(entrySelector+1) <= segCount {
+		entrySelector++
+	}
+	searchRange := 2 << entrySelector
+
+	b := putU16(nil, 0) // version
+	b = putU16(b, 1)    // numTables
+	b = putU16(b, 3)    // platformID: Windows
+	b = putU16(b, 1)    // encodingID: Unicode BMP
+	b = putU32(b, 12)   // offset
+	b = putU16(b, 4)    // format
+	b = putU16(b, 16+8*segCount)
+	b = putU16(b, 0) // language
+	b = putU16(b, 2*segCount)
+	b = putU16(b, searchRange)
+	b = putU16(b, entrySelector)
+	b = putU16(b, 2*segCount-searchRange)
+	for _, seg := range segs {
+		b = putU16(b, seg.end)
+	}
+	b = putU16(b, 0) // reservedPad
+	for _, seg := range segs {
+		b = putU16(b, seg.start)
+	}
+	for _, seg := range segs {
+		b = putU16(b, seg.delta)
+	}
+	for range segs {
+		b = putU16(b, 0) // idRangeOffset
+	}
+
+	return b
+}
+
+func otfOS2(f *cffFont, m fontMetrics) []byte {
+
+	var sum, n int
+	for _, w := range f.widths {
+		if w > 0 {
+			sum += w
+			n++
+		}
+	}
+	avg := 0
+	if n > 0 {
+		avg = sum / n
+	}
+
+	fsSelection := 0x0040 // REGULAR
+	if m.italicAngle != 0 {
+		fsSelection = 0x0001 // ITALIC
+	}
+	if m.weight >= 700 {
+		fsSelection = fsSelection&^0x0040 | 0x0020 // BOLD
+	}
+
+	upem := f.unitsPerEm
+
+	b := putU16(nil, 4) // version
+	b = putU16(b, avg)
+	b = putU16(b, m.weight)
+	b = putU16(b, 5) // usWidthClass: medium
+	b = putU16(b, 0) // fsType: installable embedding
+
+	// Sub- and superscript metrics, strikeout
+	for _, v := range []int{upem * 65 / 100, upem * 60 / 100, 0, upem * 7 / 100,
+		upem * 65 / 100, upem * 60 / 100, 0, upem * 35 / 100,
+		upem * 5 / 100, upem * 26 / 100} {
+		b = putU16(b, v)
+	}
+
+	b = putU16(b, 0)                   // sFamilyClass
+	b = append(b, make([]byte, 10)...) // panose
+	b = append(b, make([]byte, 16)...) // ulUnicodeRange1-4
+	b = append(b, []byte("PDFC")...)   // achVendID
+	b = putU16(b, fsSelection)
+	b = putU16(b, 0xFFFF) // usFirstCharIndex
+	b = putU16(b, 0xFFFF) // usLastCharIndex
+	b = putU16(b, m.ascent)
+	b = putU16(b, m.descent)
+	b = putU16(b, 0) // sTypoLineGap
+	b = putU16(b, m.ascent)
+	b = putU16(b, -m.descent)
+	b = putU32(b, 1) // ulCodePageRange1: Latin 1
+	b = putU32(b, 0)
+	b = putU16(b, m.xHeight)
+	b = putU16(b, m.capHeight)
+	b = putU16(b, 0)  // usDefaultChar
+	b = putU16(b, 32) // usBreakChar
+	return putU16(b, 0)
+}
+
+func otfName(psName string, m fontMetrics) []byte {
+
+	family := psName
+	if i := strings.IndexByte(family, '+'); i == 6 {
+		// Drop the subset tag.
+		family = family[i+1:]
+	}
+
+	var styles []string
+	if m.weight >= 700 {
+		styles = append(styles, "Bold")
+	}
+	if m.italicAngle != 0 {
+		styles = append(styles, "Italic")
+	}
+	subFamily := "Regular"
+	if len(styles) > 0 {
+		subFamily = strings.Join(styles, " ")
+	}
+
+	records := []struct {
+		id int
+		s  string
+	}{
+		{1, family},
+		{2, subFamily},
+		{3, psName},
+		{4, family},
+		{6, psName},
+	}
+
+	b := putU16(nil, 0) // format
+	b = putU16(b, len(records))
+	b = putU16(b, 6+12*len(records))
+
+	var data []byte
+	for _, r := range records {
+		var s []byte
+		for _, u := range utf16.Encode([]rune(r.s)) {
+			s = putU16(s, int(u))
+		}
+		b = putU16(b, 3)     // platformID: Windows
+		b = putU16(b, 1)     // encodingID: Unicode BMP
+		b = putU16(b, 0x409) // languageID: en-US
+		b = putU16(b, r.id)
+		b = putU16(b, len(s))
+		b = putU16(b, len(data))
+		data = append(data, s...)
+	}
+
+	return append(b, data...)
+}
+
+func otfPost(f *cffFont, m fontMetrics) []byte {
+	b := putU32(nil, 0x00030000) // version 3.0: no glyph names
+	b = putU32(b, uint32(int32(math.Round(m.italicAngle*65536))))
+	b = putU16(b, -f.unitsPerEm/10) // underlinePosition
+	b = putU16(b, f.unitsPerEm/20)  // underlineThickness
+	var fixed uint32
+	if m.fixedPitch {
+		fixed = 1
+	}
+	b = putU32(b, fixed)
+	return append(b, make([]byte, 16)...)
+}
+
+// cffToOTF wraps the CFF font program cff into an OpenType font.
+// Missing font wide metrics are taken from the font descriptor.
+// toUnicode is used to map the glyphs of CID-keyed fonts to Unicode and may be nil.
+func cffToOTF(xRefTable *XRefTable, cff []byte, fontDescriptor Dict, toUnicode *toUnicodeCMap) ([]byte, error) {
+
+	f, err := parseCFF(cff)
+	if err != nil {
+		return nil, err
+	}
+
+	m := fontDescriptorMetrics(xRefTable, fontDescriptor, f.unitsPerEm)
+
+	bbox := f.bbox
+	if bbox == [4]int{} {
+		bbox = m.bbox
+	}
+
+	if m.ascent == 0 && m.descent == 0 {
+		m.ascent, m.descent = bbox[3], bbox[1]
+	}
+
+	tables := map[string][]byte{
+		"CFF ": cff,
+		"OS/2": otfOS2(f, m),
+		"cmap": otfCmap(f.unicodes(toUnicode)),
+		"head": otfHead(f, bbox),
+		"hhea": otfHhea(f, m, bbox),
+		"hmtx": otfHmtx(f),
+		"maxp": otfMaxp(f),
+		"name": otfName(f.name, m),
+		"post": otfPost(f, m),
+	}
+
+	tags := make([]string, 0, len(tables))
+	for tag := range tables {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	numTables := len(tags)
+	entrySelector := 0
+	for 1<<(entrySelector+1) <= numTables {
+		entrySelector++
+	}
+	searchRange := 16 << entrySelector
+
+	b := putU32(nil, 0x4F54544F) // OTTO
+	b = putU16(b, numTables)
+	b = putU16(b, searchRange)
+	b = putU16(b, entrySelector)
+	b = putU16(b, numTables*16-searchRange)
+
+	off := 12 + numTables*16
+	var headOff int
+	var data []byte
+
+	for _, tag := range tags {
+		t := tables[tag]
+		if tag == "head" {
+			headOff = off
+		}
+		b = append(b, tag...)
+		b = putU32(b, sfntChecksum(t))
+		b = putU32(b, uint32(off))
+		b = putU32(b, uint32(len(t)))
+		data = append(data, t...)
+		for len(data)%4 > 0 {
+			data = append(data, 0)
+		}
+		off = 12 + numTables*16 + len(data)
+	}
+
+	b = append(b, data...)
+
+	adj := 0xB1B0AFBA - sfntChecksum(b)
+	binary.BigEndian.PutUint32(b[headOff+8:], adj)
+
+	return b, nil
+}
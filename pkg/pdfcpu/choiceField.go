@@ -0,0 +1,290 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"sort"
+
+	"github.com/jplu/pdfcpu/pkg/log"
+	"github.com/pkg/errors"
+)
+
+// FieldSort is the choice field flag requesting options to be sorted alphabetically, see Table 230.
+const FieldSort = 1 << 19
+
+// ChoiceOption represents an option of a list box or combo box.
+type ChoiceOption struct {
+	Export  string // The value of the field if this option is selected.
+	Display string // The text displayed, defaults to Export.
+}
+
+func (opt ChoiceOption) display() string {
+	if opt.Display == "" {
+		return opt.Export
+	}
+	return opt.Display
+}
+
+func optionText(xRefTable *XRefTable, o Object) (string, error) {
+
+	o, err := xRefTable.Dereference(o)
+	if err != nil {
+		return "", err
+	}
+
+	switch s := o.(type) {
+	case StringLiteral:
+		return StringLiteralToString(s.Value())
+	case HexLiteral:
+		return HexLiteralToString(s.Value())
+	}
+
+	return "", errors.Errorf("corrupt option: %v", o)
+}
+
+// choiceOptions returns the options of the choice field d.
+func choiceOptions(xRefTable *XRefTable, d Dict) ([]ChoiceOption, error) {
+
+	o, err := fieldInheritedEntry(xRefTable, d, "Opt")
+	if err != nil {
+		return nil, err
+	}
+
+	opts, _ := o.(Array)
+
+	var options []ChoiceOption
+
+	for _, o := range opts {
+
+		o, err := xRefTable.Dereference(o)
+		if err != nil {
+			return nil, err
+		}
+
+		e, s := o, o
+		if a, ok := o.(Array); ok && len(a) == 2 {
+			e, s = a[0], a[1]
+		}
+
+		opt := ChoiceOption{}
+
+		if opt.Export, err = optionText(xRefTable, e); err != nil {
+			return nil, err
+		}
+
+		if opt.Display, err = optionText(xRefTable, s); err != nil {
+			return nil, err
+		}
+
+		options = append(options, opt)
+	}
+
+	return options, nil
+}
+
+func choiceField(xRefTable *XRefTable, name string) (Dict, Dict, error) {
+
+	acroForm, d, err := formField(xRefTable, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	o, err := fieldInheritedEntry(xRefTable, d, "FT")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if ft, _ := o.(Name); ft != "Ch" {
+		return nil, nil, errors.Errorf("%s is no choice field", name)
+	}
+
+	return acroForm, d, nil
+}
+
+// ChoiceOptions returns the options of the list box or combo box named by its fully qualified name.
+func ChoiceOptions(xRefTable *XRefTable, fieldName string) ([]ChoiceOption, error) {
+
+	_, d, err := choiceField(xRefTable, fieldName)
+	if err != nil {
+		return nil, errors.Wrap(err, "ChoiceOptions")
+	}
+
+	return choiceOptions(xRefTable, d)
+}
+
+// refreshChoiceField selects the option with export value v of the choice field d and regenerates its appearances.
+// A combo box may take any value, an empty value clears the selection.
+func refreshChoiceField(xRefTable *XRefTable, acroForm, d Dict, options []ChoiceOption, v string) error {
+
+	ft, err := newFieldText(xRefTable, acroForm, d)
+	if err != nil {
+		return err
+	}
+
+	selected := -1
+	for i, opt := range options {
+		if opt.Export == v {
+			selected = i
+			break
+		}
+	}
+
+	combo := ft.ff&FieldCombo > 0
+
+	if selected < 0 && !combo && v != "" {
+		return errors.Errorf("unknown option %q", v)
+	}
+
+	if v == "" {
+		d.Delete("V")
+	} else {
+		s, err := textString(v)
+		if err != nil {
+			return err
+		}
+		d.Update("V", s)
+	}
+
+	s := v
+	if selected >= 0 {
+		s = options[selected].display()
+		d.Update("I", NewIntegerArray(selected))
+	} else {
+		d.Delete("I")
+	}
+
+	var lines []string
+	if !combo {
+		for _, opt := range options {
+			lines = append(lines, opt.display())
+		}
+		s = ""
+	}
+
+	widgets, err := fieldWidgets(xRefTable, d)
+	if err != nil {
+		return err
+	}
+
+	for _, wd := range widgets {
+		if err = refreshWidgetAppearance(xRefTable, *ft, wd, s, lines, selected); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func fillChoiceField(xRefTable *XRefTable, acroForm, d Dict, v string) error {
+
+	options, err := choiceOptions(xRefTable, d)
+	if err != nil {
+		return err
+	}
+
+	return refreshChoiceField(xRefTable, acroForm, d, options, v)
+}
+
+// SetChoiceOptions replaces the options of the list box or combo box named by its fully qualified name.
+// Options get added, removed or reordered by passing the complete new list, see ChoiceOptions.
+// Options of fields flagged for sorting get sorted by display text.
+// A selected value no longer available gets cleared except for combo boxes, and all appearances are regenerated.
+func SetChoiceOptions(xRefTable *XRefTable, fieldName string, options []ChoiceOption) error {
+
+	acroForm, d, err := choiceField(xRefTable, fieldName)
+	if err != nil {
+		return errors.Wrap(err, "SetChoiceOptions")
+	}
+
+	var choices []ChoiceOption
+	exports := StringSet{}
+
+	for _, opt := range options {
+
+		if opt.Export == "" {
+			return errors.Errorf("SetChoiceOptions: %s: missing export value", fieldName)
+		}
+
+		// Display texts need to be renderable.
+		if _, err := latin1(opt.display()); err != nil {
+			return errors.Wrapf(err, "SetChoiceOptions: %s", fieldName)
+		}
+
+		if exports[opt.Export] {
+			return errors.Errorf("SetChoiceOptions: %s: duplicate export value %q", fieldName, opt.Export)
+		}
+		exports[opt.Export] = true
+
+		choices = append(choices, ChoiceOption{Export: opt.Export, Display: opt.display()})
+	}
+
+	ff, err := fieldInheritedInt(xRefTable, nil, d, "Ff")
+	if err != nil {
+		return err
+	}
+
+	if ff&FieldSort > 0 {
+		sort.SliceStable(choices, func(i, j int) bool { return choices[i].Display < choices[j].Display })
+	}
+
+	opts := Array{}
+
+	for _, opt := range choices {
+
+		es, err := textString(opt.Export)
+		if err != nil {
+			return err
+		}
+
+		if opt.Display == opt.Export {
+			opts = append(opts, es)
+			continue
+		}
+
+		ds, err := textString(opt.Display)
+		if err != nil {
+			return err
+		}
+
+		opts = append(opts, Array{es, ds})
+	}
+
+	d.Update("Opt", opts)
+
+	// The first visible option of a list box.
+	d.Delete("TI")
+
+	v := ""
+
+	o, err := fieldInheritedEntry(xRefTable, d, "V")
+	if err != nil {
+		return err
+	}
+	if o != nil {
+		if v, err = optionText(xRefTable, o); err != nil {
+			return err
+		}
+	}
+
+	if !exports[v] && ff&FieldCombo == 0 {
+		v = ""
+	}
+
+	log.Debug.Printf("SetChoiceOptions: %s: %d options, value %q\n", fieldName, len(choices), v)
+
+	return refreshChoiceField(xRefTable, acroForm, d, choices, v)
+}
@@ -0,0 +1,288 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+// cloneObject returns a deep copy of o.
+// Stream data is shared since pdfcpu always replaces Raw and Content instead of modifying them in place.
+func cloneObject(o Object) Object {
+
+	switch o := o.(type) {
+
+	case Dict:
+		return cloneDict(o)
+
+	case Array:
+		return cloneArray(o)
+
+	case StreamDict:
+		return cloneStreamDict(o)
+
+	case ObjectStreamDict:
+		o.StreamDict = cloneStreamDict(o.StreamDict)
+		o.ObjArray = cloneArray(o.ObjArray)
+		return o
+
+	case XRefStreamDict:
+		o.StreamDict = cloneStreamDict(o.StreamDict)
+		o.Objects = append([]int(nil), o.Objects...)
+		return o
+	}
+
+	// All other objects are immutable values.
+	return o
+}
+
+func cloneDict(d Dict) Dict {
+
+	if d == nil {
+		return nil
+	}
+
+	d1 := make(Dict, len(d))
+	for k, v := range d {
+		d1[k] = cloneObject(v)
+	}
+
+	return d1
+}
+
+func cloneArray(a Array) Array {
+
+	if a == nil {
+		return nil
+	}
+
+	a1 := make(Array, len(a))
+	for i, v := range a {
+		a1[i] = cloneObject(v)
+	}
+
+	return a1
+}
+
+func cloneStreamDict(sd StreamDict) StreamDict {
+	sd.Dict = cloneDict(sd.Dict)
+	sd.FilterPipeline = append([]PDFFilter(nil), sd.FilterPipeline...)
+	return sd
+}
+
+func cloneIntSet(s IntSet) IntSet {
+
+	if s == nil {
+		return nil
+	}
+
+	s1 := make(IntSet, len(s))
+	for k, v := range s {
+		s1[k] = v
+	}
+
+	return s1
+}
+
+func cloneIntPtr(i *int) *int {
+	if i == nil {
+		return nil
+	}
+	i1 := *i
+	return &i1
+}
+
+func cloneInt64Ptr(i *int64) *int64 {
+	if i == nil {
+		return nil
+	}
+	i1 := *i
+	return &i1
+}
+
+func cloneXRefTableEntry(e *XRefTableEntry) *XRefTableEntry {
+
+	if e == nil {
+		return nil
+	}
+
+	e1 := *e
+	e1.Offset = cloneInt64Ptr(e.Offset)
+	e1.Generation = cloneIntPtr(e.Generation)
+	e1.ObjectStream = cloneIntPtr(e.ObjectStream)
+	e1.ObjectStreamInd = cloneIntPtr(e.ObjectStreamInd)
+	e1.Object = cloneObject(e.Object)
+
+	return &e1
+}
+
+func cloneNode(n *Node) *Node {
+
+	if n == nil {
+		return nil
+	}
+
+	n1 := *n
+
+	if n.Kids != nil {
+		n1.Kids = make([]*Node, len(n.Kids))
+		for i, k := range n.Kids {
+			n1.Kids[i] = cloneNode(k)
+		}
+	}
+
+	if n.Names != nil {
+		n1.Names = make([]entry, len(n.Names))
+		for i, e := range n.Names {
+			n1.Names[i] = entry{e.k, cloneObject(e.v)}
+		}
+	}
+
+	if n.IndRef != nil {
+		ir := *n.IndRef
+		n1.IndRef = &ir
+	}
+
+	return &n1
+}
+
+func (xRefTable *XRefTable) clone() *XRefTable {
+
+	x := *xRefTable
+
+	x.Table = make(map[int]*XRefTableEntry, len(xRefTable.Table))
+	for k, v := range xRefTable.Table {
+		x.Table[k] = cloneXRefTableEntry(v)
+	}
+
+	x.Size = cloneIntPtr(xRefTable.Size)
+
+	if xRefTable.Root != nil {
+		ir := *xRefTable.Root
+		x.Root = &ir
+		if e, ok := x.Table[ir.ObjectNumber.Value()]; ok && xRefTable.RootDict != nil {
+			x.RootDict, _ = e.Object.(Dict)
+		}
+	}
+
+	x.Names = make(map[string]*Node, len(xRefTable.Names))
+	for k, v := range xRefTable.Names {
+		x.Names[k] = cloneNode(v)
+	}
+
+	if xRefTable.Encrypt != nil {
+		ir := *xRefTable.Encrypt
+		x.Encrypt = &ir
+	}
+
+	if xRefTable.E != nil {
+		e := *xRefTable.E
+		x.E = &e
+	}
+
+	if xRefTable.EncKey != nil {
+		x.EncKey = append([]byte(nil), xRefTable.EncKey...)
+	}
+
+	if xRefTable.Info != nil {
+		ir := *xRefTable.Info
+		x.Info = &ir
+	}
+
+	x.ID = cloneArray(xRefTable.ID)
+	x.LinearizationObjs = cloneIntSet(xRefTable.LinearizationObjs)
+
+	if xRefTable.AdditionalStreams != nil {
+		a := cloneArray(*xRefTable.AdditionalStreams)
+		x.AdditionalStreams = &a
+	}
+
+	x.Stats = PDFStats{rootAttrs: cloneIntSet(xRefTable.Stats.rootAttrs), pageAttrs: cloneIntSet(xRefTable.Stats.pageAttrs)}
+
+	return &x
+}
+
+func (oc *OptimizationContext) clone() *OptimizationContext {
+
+	o := *oc
+
+	o.PageFonts = make([]IntSet, len(oc.PageFonts))
+	for i, s := range oc.PageFonts {
+		o.PageFonts[i] = cloneIntSet(s)
+	}
+
+	o.PageImages = make([]IntSet, len(oc.PageImages))
+	for i, s := range oc.PageImages {
+		o.PageImages[i] = cloneIntSet(s)
+	}
+
+	o.FontObjects = make(map[int]*FontObject, len(oc.FontObjects))
+	for k, v := range oc.FontObjects {
+		o.FontObjects[k] = v
+	}
+
+	o.Fonts = make(map[string][]int, len(oc.Fonts))
+	for k, v := range oc.Fonts {
+		o.Fonts[k] = append([]int(nil), v...)
+	}
+
+	o.DuplicateFonts = make(map[int]Dict, len(oc.DuplicateFonts))
+	for k, v := range oc.DuplicateFonts {
+		o.DuplicateFonts[k] = v
+	}
+
+	o.DuplicateFontFiles = make(map[int]*StreamDict, len(oc.DuplicateFontFiles))
+	for k, v := range oc.DuplicateFontFiles {
+		o.DuplicateFontFiles[k] = v
+	}
+
+	o.ImageObjects = make(map[int]*ImageObject, len(oc.ImageObjects))
+	for k, v := range oc.ImageObjects {
+		o.ImageObjects[k] = v
+	}
+
+	o.DuplicateImages = make(map[int]*StreamDict, len(oc.DuplicateImages))
+	for k, v := range oc.DuplicateImages {
+		o.DuplicateImages[k] = v
+	}
+
+	o.DuplicateFontObjs = cloneIntSet(oc.DuplicateFontObjs)
+	o.DuplicateImageObjs = cloneIntSet(oc.DuplicateImageObjs)
+	o.DuplicateInfoObjects = cloneIntSet(oc.DuplicateInfoObjects)
+	o.NonReferencedObjs = append([]int(nil), oc.NonReferencedObjs...)
+
+	return &o
+}
+
+// Clone returns an independent deep copy of ctx,
+// which may serve as the base for a different output without reading the file again.
+//
+// The clone starts with a fresh write context and a copy of the configuration.
+// Stream data and the font and image registries collected during optimization
+// are shared read-only with ctx. Streamed attachment content may only be written once.
+func (ctx *Context) Clone() *Context {
+
+	config := *ctx.Configuration
+
+	rc := *ctx.Read
+	rc.ObjectStreams = cloneIntSet(ctx.Read.ObjectStreams)
+	rc.XRefStreams = cloneIntSet(ctx.Read.XRefStreams)
+
+	return &Context{
+		&config,
+		ctx.XRefTable.clone(),
+		&rc,
+		ctx.Optimize.clone(),
+		NewWriteContext(ctx.Write.Eol),
+	}
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"github.com/jplu/pdfcpu/pkg/log"
+	"github.com/pkg/errors"
+)
+
+// copyPage returns a copy of the page dict ir sharing content and resources.
+// Annotations do not get copied as they are not written for collected pages anyway.
+func (xRefTable *XRefTable) copyPage(ir IndirectRef) (*IndirectRef, error) {
+
+	d, err := xRefTable.DereferenceDict(ir)
+	if err != nil {
+		return nil, err
+	}
+
+	d1 := cloneDict(d)
+
+	// The structure tree and article threads refer to the original page only.
+	d1.Delete("StructParents")
+	d1.Delete("B")
+	d1.Delete("Annots")
+
+	return xRefTable.IndRefForNewObject(d1)
+}
+
+// Collect rebuilds the page tree from pages, an arbitrary sequence of page numbers.
+// Pages may appear in any order and more than once, every repetition is a copy of the page sharing its content and resources.
+// Pages not in the sequence get dropped.
+func (xRefTable *XRefTable) Collect(pages []int) error {
+
+	log.Debug.Printf("Collect begin: %v\n", pages)
+
+	if len(pages) == 0 {
+		return errors.New("Collect: missing pages")
+	}
+
+	for _, p := range pages {
+		if p < 1 || p > xRefTable.PageCount {
+			return NewError(ErrPageOutOfRange, "Collect: invalid page number: %d", p)
+		}
+	}
+
+	root, err := xRefTable.Pages()
+	if err != nil {
+		return err
+	}
+	if root == nil {
+		return errors.New("Collect: missing page tree root")
+	}
+
+	rootDict, err := xRefTable.DereferenceDict(*root)
+	if err != nil {
+		return err
+	}
+	if rootDict == nil {
+		return errors.New("Collect: missing page tree root")
+	}
+
+	// Make pages self-contained, they all become kids of the root.
+	var leaves []IndirectRef
+	var nodes []int
+
+	if err = xRefTable.collectPageLeaves(*root, Dict{}, IntSet{}, &leaves, &nodes); err != nil {
+		return err
+	}
+
+	kids := make(Array, len(pages))
+	used := IntSet{}
+
+	for i, p := range pages {
+
+		if !used[p] {
+			used[p] = true
+			kids[i] = leaves[p-1]
+			continue
+		}
+
+		ir, err := xRefTable.copyPage(leaves[p-1])
+		if err != nil {
+			return err
+		}
+
+		kids[i] = *ir
+	}
+
+	if err = xRefTable.replacePageTreeKids(*root, rootDict, kids, leaves, nodes); err != nil {
+		return err
+	}
+
+	log.Debug.Printf("Collect end: %d pages\n", len(kids))
+
+	return nil
+}
+
+// replacePageTreeKids makes kids the only kids of the page tree root
+// and drops the former intermediate page tree nodes.
+// Dropped leaves may still be referenced by outlines, destinations, article beads or annotations,
+// so they get re-parented to the root like the kids, leaving nothing pointing to the dropped nodes.
+func (xRefTable *XRefTable) replacePageTreeKids(root IndirectRef, rootDict Dict, kids Array, leaves []IndirectRef, nodes []int) error {
+
+	for _, ir := range leaves {
+		d, err := xRefTable.DereferenceDict(ir)
+		if err != nil {
+			return err
+		}
+		d.Update("Parent", root)
+	}
+
+	rootDict.Update("Kids", kids)
+	rootDict.Update("Count", Integer(len(kids)))
+
+	if err := xRefTable.setPageTreeParents(root, rootDict); err != nil {
+		return err
+	}
+
+	// The former intermediate nodes are orphans now.
+	for _, objNr := range nodes {
+		if objNr == root.ObjectNumber.Value() {
+			continue
+		}
+		if err := xRefTable.DeleteObject(objNr); err != nil {
+			return err
+		}
+	}
+
+	xRefTable.PageCount = len(kids)
+
+	return nil
+}
+
+// RemovePages removes pages from the page tree keeping the order of the remaining pages.
+// Resources only used by removed pages become orphans and do not get written.
+func (xRefTable *XRefTable) RemovePages(pages IntSet) error {
+
+	var keep []int
+
+	for i := 1; i <= xRefTable.PageCount; i++ {
+		if !pages[i] {
+			keep = append(keep, i)
+		}
+	}
+
+	if len(keep) == 0 {
+		return errors.New("RemovePages: can't remove all pages")
+	}
+
+	if len(keep) == xRefTable.PageCount {
+		return nil
+	}
+
+	return xRefTable.Collect(keep)
+}
@@ -0,0 +1,333 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jplu/pdfcpu/pkg/fonts/metrics"
+	"github.com/jplu/pdfcpu/pkg/log"
+	"github.com/jplu/pdfcpu/pkg/types"
+	"github.com/pkg/errors"
+)
+
+// Comment is an entry of a comment summary.
+type Comment struct {
+	Page   int        `json:"page"`
+	Type   string     `json:"type"`
+	Author string     `json:"author,omitempty"`
+	Date   *time.Time `json:"date,omitempty"`  // The modification date falling back to the creation date.
+	Quote  string     `json:"quote,omitempty"` // The text under text markup annotations like Highlight.
+	Text   string     `json:"text,omitempty"`  // The comment.
+}
+
+// quadPointsText returns the text of glyphs centered within the quadrilaterals qp.
+func quadPointsText(glyphs []textGlyph, qp []float64) string {
+
+	var rr []types.Rectangle
+
+	for i := 0; i+8 <= len(qp); i += 8 {
+		x0, y0, x1, y1 := qp[i], qp[i+1], qp[i], qp[i+1]
+		for j := i + 2; j < i+8; j += 2 {
+			if qp[j] < x0 {
+				x0 = qp[j]
+			}
+			if qp[j] > x1 {
+				x1 = qp[j]
+			}
+			if qp[j+1] < y0 {
+				y0 = qp[j+1]
+			}
+			if qp[j+1] > y1 {
+				y1 = qp[j+1]
+			}
+		}
+		rr = append(rr, types.NewRectangle(x0, y0, x1, y1))
+	}
+
+	var selected []textGlyph
+
+	for _, g := range glyphs {
+		c := g.bounds.Center()
+		for _, r := range rr {
+			if r.Contains(c) {
+				selected = append(selected, g)
+				break
+			}
+		}
+	}
+
+	// Quotes spanning several lines are returned as one line.
+	return strings.Join(strings.Fields(glyphsText(selected)), " ")
+}
+
+// Comments returns a summary of the markup annotations of the selected pages in page order.
+func Comments(xRefTable *XRefTable, selectedPages IntSet) ([]Comment, error) {
+
+	annots, err := ExportAnnotations(xRefTable, selectedPages)
+	if err != nil {
+		return nil, err
+	}
+
+	glyphs := map[int][]textGlyph{}
+
+	var cc []Comment
+
+	for _, a := range annots {
+
+		c := Comment{Page: a.Page, Type: a.Type, Author: a.Author, Date: a.Modified, Text: a.Contents}
+		if c.Date == nil {
+			c.Date = a.Created
+		}
+
+		if MemberOf(a.Type, textMarkupAnnotationTypes) && len(a.QuadPoints) >= 8 {
+			gg, ok := glyphs[a.Page]
+			if !ok {
+				if gg, err = pageGlyphs(xRefTable, a.Page); err != nil {
+					return nil, errors.Wrap(err, "Comments")
+				}
+				glyphs[a.Page] = gg
+			}
+			c.Quote = quadPointsText(gg, a.QuadPoints)
+		}
+
+		cc = append(cc, c)
+	}
+
+	return cc, nil
+}
+
+// winAnsi returns s encoded for fonts using WinAnsiEncoding, unsupported characters become '?'.
+func winAnsi(s string) string {
+
+	b := make([]byte, 0, len(s))
+
+	for _, r := range s {
+
+		if r < 0x80 || r >= 0xA0 && r <= 0xFF {
+			b = append(b, byte(r))
+			continue
+		}
+
+		c := byte('?')
+		if r == 0x2022 {
+			// winAnsiHigh maps undefined codes to bullet.
+			c = 0x95
+		} else {
+			for i, r1 := range winAnsiHigh {
+				if r1 == r {
+					c = byte(0x80 + i)
+					break
+				}
+			}
+		}
+
+		b = append(b, c)
+	}
+
+	return string(b)
+}
+
+// wrapText breaks s into lines not exceeding width using a standard font.
+func wrapText(s, fontName string, fontSize int, width float64) []string {
+
+	var lines []string
+
+	for _, para := range strings.Split(strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(s), "\n") {
+		line := ""
+		for _, word := range strings.Fields(para) {
+			if line != "" && metrics.TextWidth(line+" "+word, fontName, fontSize) > width {
+				lines = append(lines, line)
+				line = ""
+			}
+			if line != "" {
+				line += " "
+			}
+			line += word
+		}
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// appendPage adds a new page at the end of the document.
+func appendPage(xRefTable *XRefTable, mediaBox Array, resources Dict, content []byte) error {
+
+	root, err := xRefTable.Pages()
+	if err != nil {
+		return err
+	}
+	if root == nil {
+		return errors.New("missing page tree")
+	}
+
+	pages, err := xRefTable.DereferenceDict(*root)
+	if err != nil {
+		return err
+	}
+
+	sd := &StreamDict{Dict: NewDict(), Content: content}
+	if err = encodeStream(sd); err != nil {
+		return err
+	}
+
+	contents, err := xRefTable.IndRefForNewObject(*sd)
+	if err != nil {
+		return err
+	}
+
+	pageDict := Dict(
+		map[string]Object{
+			"Type":      Name("Page"),
+			"Parent":    *root,
+			"MediaBox":  mediaBox,
+			"Resources": resources,
+			"Contents":  *contents,
+		},
+	)
+
+	ir, err := xRefTable.IndRefForNewObject(pageDict)
+	if err != nil {
+		return err
+	}
+
+	kids, err := xRefTable.DereferenceArray(pages["Kids"])
+	if err != nil {
+		return err
+	}
+
+	pages.Update("Kids", append(kids, *ir))
+	xRefTable.PageCount++
+	pages.Update("Count", Integer(xRefTable.PageCount))
+
+	return nil
+}
+
+// commentSummaryLines returns the lines of a comment summary along with their font ids.
+func commentSummaryLines(comments []Comment, width float64) [][2]string {
+
+	var lines [][2]string
+
+	for i, c := range comments {
+
+		if i > 0 {
+			lines = append(lines, [2]string{"F0", ""})
+		}
+
+		header := fmt.Sprintf("Page %d - %s", c.Page, c.Type)
+		if c.Author != "" {
+			header += " - " + c.Author
+		}
+		if c.Date != nil {
+			header += " - " + c.Date.Format("2006-01-02 15:04")
+		}
+		lines = append(lines, [2]string{"F1", header})
+
+		if c.Quote != "" {
+			for _, s := range wrapText("\""+c.Quote+"\"", "Helvetica", 10, width) {
+				lines = append(lines, [2]string{"F0", s})
+			}
+		}
+
+		if c.Text != "" {
+			for _, s := range wrapText(c.Text, "Helvetica", 10, width) {
+				lines = append(lines, [2]string{"F0", s})
+			}
+		}
+	}
+
+	return lines
+}
+
+// AppendCommentSummary appends pages listing comments to the document and returns the number of pages added.
+// The summary pages use the media box of the last page.
+func AppendCommentSummary(xRefTable *XRefTable, comments []Comment) (int, error) {
+
+	_, inhPAttrs, err := xRefTable.PageDict(xRefTable.PageCount)
+	if err != nil {
+		return 0, err
+	}
+
+	mediaBox := inhPAttrs.MediaBox()
+	if len(mediaBox) != 4 {
+		mediaBox = NewRectangle(0, 0, 612, 792)
+	}
+	r := rect(xRefTable, mediaBox)
+
+	const margin, leading = 54., 13.
+
+	f0, err := fontDictIndRef(xRefTable, "Helvetica")
+	if err != nil {
+		return 0, err
+	}
+
+	f1, err := fontDictIndRef(xRefTable, "Helvetica-Bold")
+	if err != nil {
+		return 0, err
+	}
+
+	resources := Dict(map[string]Object{"Font": Dict(map[string]Object{"F0": *f0, "F1": *f1})})
+
+	lines := commentSummaryLines(comments, r.Width()-2*margin)
+	if len(comments) == 0 {
+		lines = [][2]string{{"F0", "No comments."}}
+	}
+
+	n := 0
+
+	for len(lines) > 0 || n == 0 {
+
+		var b bytes.Buffer
+		b.WriteString("BT ")
+
+		y := r.UR.Y - margin
+		if n == 0 {
+			y -= 16
+			fmt.Fprintf(&b, "/F1 16 Tf 1 0 0 1 %.2f %.2f Tm (Comment Summary) Tj ", r.LL.X+margin, y)
+			y -= 2 * leading
+		}
+
+		for ; len(lines) > 0 && y >= r.LL.Y+margin; y -= leading {
+			l := lines[0]
+			lines = lines[1:]
+			if l[1] == "" {
+				continue
+			}
+			es, err := Escape(winAnsi(l[1]))
+			if err != nil {
+				return 0, err
+			}
+			fmt.Fprintf(&b, "/%s 10 Tf 1 0 0 1 %.2f %.2f Tm (%s) Tj ", l[0], r.LL.X+margin, y, *es)
+		}
+
+		b.WriteString("ET")
+
+		if err = appendPage(xRefTable, mediaBox, resources, b.Bytes()); err != nil {
+			return 0, err
+		}
+
+		n++
+	}
+
+	log.Debug.Printf("AppendCommentSummary: %d comments on %d pages\n", len(comments), n)
+
+	return n, nil
+}
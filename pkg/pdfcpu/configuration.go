@@ -16,6 +16,12 @@ limitations under the License.
 
 package pdfcpu
 
+import (
+	"fmt"
+	"reflect"
+	"time"
+)
+
 const (
 
 	// ValidationStrict ensures 100% compliance with the spec (PDF 32000-1:2008).
@@ -62,6 +68,29 @@ const (
 	CHANGEOPW
 	STAMP
 	ADDWATERMARKS
+	EXTRACTTEXT
+	COLLECT
+	REMOVEPAGES
+	CROP
+	RESIZE
+	POSTER
+	REMOVEWATERMARKS
+	RENDERPAGES
+	ADDFORMFIELDS
+	FILLFORMFIELDS
+	GRAYSCALE
+	BOOKLET
+	FLATTENANNOTATIONS
+	LOCKFORMFIELDS
+	SETLANGUAGE
+	SETTABORDER
+	SETCALCULATIONORDER
+	SETCHOICEOPTIONS
+	ADDANNOTATIONS
+	EXPORTANNOTATIONS
+	ADDCOMMENTSUMMARY
+	REMOVEUSAGERIGHTS
+	INSERTPAGES
 )
 
 // Configuration of a Context.
@@ -76,6 +105,10 @@ type Configuration struct {
 	// Validate against ISO-32000: strict or relaxed
 	ValidationMode int
 
+	// Ids of registered validation rules or built-in checks like "catalog.Outlines" to be skipped,
+	// eg. for known-benign quirks of specific producers.
+	SuppressedRules []string
+
 	// End of line char sequence for writing.
 	Eol string
 
@@ -88,6 +121,53 @@ type Configuration struct {
 	// Switches between xRefSection (<=V1.4) and objectStream/xRefStream (>=V1.5) writing.
 	WriteXRefStream bool
 
+	// Writes a hybrid file if WriteXRefStream is true:
+	// A xref table followed by a trailer pointing via XRefStm to a xref stream covering the objects compressed into object streams.
+	// Readers not supporting xref streams are still able to process anything but the compressed objects.
+	WriteHybridXRef bool
+
+	// Limits the number of objects within an object stream written.
+	// 0 means ObjectStreamMaxObjects.
+	MaxObjectsPerObjectStream int
+
+	// Dicts of these types never get compressed into object streams,
+	// eg. "Page" for consumers expecting uncompressed page dicts.
+	ObjectStreamExcludedTypes []string
+
+	// If > 0 the page tree gets flattened and rebalanced before writing
+	// using at most this many kids per page tree node, eg. PageTreeFanOutDefault.
+	// Count and Parent entries are recomputed along the way.
+	PageTreeFanOut int
+
+	// Copy inherited page attributes into the page dicts before splitting or extracting pages,
+	// so the page dicts written are self-contained.
+	MaterializePageAttrs bool
+
+	// If > 0 optimization converts inline images with more than this many bytes of image data
+	// into image XObjects, so they can be shared and deduplicated.
+	InlineImageThreshold int
+
+	// Rules for recompressing images during optimization, eg. converting bilevel images to CCITT G4
+	// or downsampling high resolution scans. See RecompressImages.
+	ImagePolicy ImagePolicy
+
+	// Image extraction also writes the placements of the extracted images on their pages
+	// into a JSON file, so the page layout may be reconstructed. See PageImagePlacements.
+	ExtractImagePlacements bool
+
+	// Names the files written by extracting images, fonts and content, eg. "{file}_{page}_{name}".
+	// Supported placeholders: {file} the input file name without extension, {name} the resource name,
+	// {page} the page number and {obj} the object number. The extension gets appended.
+	// Empty means the default naming of each extraction. Duplicate names get a numeric suffix.
+	ExtractFileNameTemplate string
+
+	// Extraction keeps existing files instead of overwriting them.
+	ExtractSkipExisting bool
+
+	// Remove transparency before writing for targets not supporting it, eg. PDF/A-1 or some printers.
+	// See FlattenTransparency.
+	FlattenTransparency bool
+
 	// Turns on stats collection.
 	// TODO Decision - unused.
 	CollectStats bool
@@ -113,11 +193,91 @@ type Configuration struct {
 	// false: use 40 bit key
 	EncryptUsing128BitKey bool
 
+	// EncryptMetadata controls the encryption of metadata streams.
+	// false: leave metadata readable by tools not knowing the password, eg. for PDF/A.
+	// Needs EncryptUsing128BitKey.
+	EncryptMetadata bool
+
+	// Allow encryption using RC4 with a 40 bit key
+	// and override ForbidWeakEncryption.
+	ForceWeakEncryption bool
+
+	// Forbid writing files encrypted using RC4 or AES-128.
+	// Files encrypted this way may still be read and decrypted.
+	// ForceWeakEncryption takes precedence.
+	ForbidWeakEncryption bool
+
+	// Requirements for passwords used for encryption.
+	// nil means no requirements.
+	PasswordPolicy *PasswordPolicy
+
+	// Skip enforcing user access permissions if only the user password was supplied.
+	// Intended for authorized use only.
+	IgnorePermissions bool
+
 	// Supplied user access permissions, see Table 22
 	UserAccessPermissions int16
 
 	// Command being executed.
 	Mode CommandMode
+
+	// Re-read and validate any written file using ValidationMode.
+	// Fail the command if the written file does not validate.
+	VerifyWrite bool
+
+	// After Trim and Merge re-read the written file and
+	// verify its page count and page content digests against the pages expected.
+	VerifyPages bool
+
+	// Producer written into the document info dict.
+	// Empty means the pdfcpu version string.
+	CustomProducer string
+
+	// Keep Producer of the document info dict as found, overrides CustomProducer.
+	// Creator is never touched.
+	PreserveProducer bool
+
+	// Keep CreationDate and ModDate of the document info dict as found.
+	SuppressModDate bool
+
+	// Record the source files and pages of split and merged files
+	// in the document info dict entry "Provenance".
+	RecordProvenance bool
+
+	// How Merge combines the outlines (bookmarks) of the files merged.
+	MergeOutlines OutlineMergeMode
+
+	// Split keeps document level data relevant to each page in each file written:
+	// outlines pointing to the page and its page label.
+	SplitDocumentData bool
+
+	// Split skips pages whose file has already been written by a previous run, so batch jobs may be resumed.
+	// Split records size and SHA-256 checksum of each file written in a manifest in the output dir
+	// and only skips files matching their record.
+	SplitSkipExisting bool
+
+	// Writing to an existing file keeps the original as <file>.bak.
+	WriteBackup bool
+
+	// The PDF version written, nil means V17.
+	// Object and xref streams get avoided below V15,
+	// writing fails for any other features not available in the target version.
+	TargetVersion *Version
+
+	// Clock used for generated dates like CreationDate and ModDate.
+	// nil means time.Now.
+	Now func() time.Time
+
+	// Time zone used for generated dates.
+	// nil means the location of the time returned by Now.
+	TimeZone *time.Location
+
+	// Custom transformations applied during reading and writing.
+	Hooks Hooks
+
+	// The file system files get read from and written to, nil means the local file system.
+	// This applies to all file based commands but not to the readers and writers passed to the api.
+	FS FileSystem
 }
 
 // NewDefaultConfiguration returns the default pdfcpu configuration.
@@ -133,6 +293,7 @@ func NewDefaultConfiguration() *Configuration {
 		CollectStats:          true,
 		EncryptUsingAES:       true,
 		EncryptUsing128BitKey: true,
+		EncryptMetadata:       true,
 		UserAccessPermissions: PermissionsNone,
 	}
 }
@@ -150,3 +311,180 @@ func (c *Configuration) ValidationModeString() string {
 
 	return ""
 }
+
+// Validate checks c for invalid or inconsistent settings.
+// Encryption settings depend on Mode and get checked when writing.
+// The returned error matches ErrInvalidConfig using errors.Is.
+func (c *Configuration) Validate() error {
+
+	if c.ValidationMode != ValidationStrict && c.ValidationMode != ValidationRelaxed {
+		return NewError(ErrInvalidConfig, "config: unknown ValidationMode %d, use ValidationStrict or ValidationRelaxed", c.ValidationMode)
+	}
+
+	switch c.Eol {
+	case EolLF, EolCR, EolCRLF:
+	default:
+		return NewError(ErrInvalidConfig, "config: invalid Eol %q, use EolLF, EolCR or EolCRLF", c.Eol)
+	}
+
+	if c.WriteHybridXRef && !c.WriteXRefStream {
+		return NewError(ErrInvalidConfig, "config: WriteHybridXRef needs WriteXRefStream")
+	}
+
+	if c.MaxObjectsPerObjectStream < 0 {
+		return NewError(ErrInvalidConfig, "config: MaxObjectsPerObjectStream must not be negative, 0 means the default")
+	}
+
+	if c.PageTreeFanOut < 0 || c.PageTreeFanOut == 1 {
+		return NewError(ErrInvalidConfig, "config: PageTreeFanOut must be 0 (off) or at least 2, got %d", c.PageTreeFanOut)
+	}
+
+	if c.InlineImageThreshold < 0 {
+		return NewError(ErrInvalidConfig, "config: InlineImageThreshold must not be negative, 0 means off")
+	}
+
+	if err := c.ImagePolicy.Validate(); err != nil {
+		return NewError(ErrInvalidConfig, "config: ImagePolicy: %v", err)
+	}
+
+	if c.MergeOutlines < OutlinesNone || c.MergeOutlines > OutlinesNested {
+		return NewError(ErrInvalidConfig, "config: unknown MergeOutlines %d", c.MergeOutlines)
+	}
+
+	if c.TargetVersion != nil && (*c.TargetVersion < V10 || *c.TargetVersion > V17) {
+		return NewError(ErrInvalidConfig, "config: unknown TargetVersion %d", *c.TargetVersion)
+	}
+
+	return nil
+}
+
+// validateEncryption checks the encryption settings of c for the command in c.Mode.
+func (c *Configuration) validateEncryption() error {
+
+	if c.Mode == CHANGEUPW && c.UserPWNew == nil {
+		return NewError(ErrInvalidConfig, "config: changing the user password needs UserPWNew")
+	}
+
+	if c.Mode == CHANGEOPW && c.OwnerPWNew == nil {
+		return NewError(ErrInvalidConfig, "config: changing the owner password needs OwnerPWNew")
+	}
+
+	if c.Mode != ENCRYPT {
+		return nil
+	}
+
+	if c.EncryptUsingAES && !c.EncryptUsing128BitKey {
+		return NewError(ErrInvalidConfig, "config: AES encryption needs EncryptUsing128BitKey")
+	}
+
+	if !c.EncryptMetadata && !c.EncryptUsing128BitKey {
+		return NewError(ErrInvalidConfig, "config: unencrypted metadata needs EncryptUsing128BitKey")
+	}
+
+	if c.TargetVersion != nil {
+		if c.EncryptUsingAES && *c.TargetVersion < V16 {
+			return NewError(ErrInvalidConfig, "config: AES encryption needs PDF 1.6, TargetVersion is %s", *c.TargetVersion)
+		}
+		if c.EncryptUsing128BitKey && *c.TargetVersion < V15 {
+			return NewError(ErrInvalidConfig, "config: encryption using a 128 bit key needs PDF 1.5, TargetVersion is %s", *c.TargetVersion)
+		}
+	}
+
+	if !c.EncryptUsing128BitKey && !c.ForceWeakEncryption {
+		return NewError(ErrInvalidConfig, "config: RC4 with a 40 bit key needs ForceWeakEncryption")
+	}
+
+	// ForceWeakEncryption wins over ForbidWeakEncryption.
+	if c.ForbidWeakEncryption && !c.ForceWeakEncryption {
+		return NewError(ErrInvalidConfig, "config: ForbidWeakEncryption rejects all encryption supported for writing, set ForceWeakEncryption to encrypt anyway")
+	}
+
+	return nil
+}
+
+// NonDefaults lists the settings of c differing from NewDefaultConfiguration as "Name: value".
+// Passwords, functions, hooks and the file system are reported as set without revealing their value.
+func (c *Configuration) NonDefaults() []string {
+
+	var ss []string
+
+	v := reflect.ValueOf(*c)
+	vDefault := reflect.ValueOf(*NewDefaultConfiguration())
+
+	for i := 0; i < v.NumField(); i++ {
+
+		name := v.Type().Field(i).Name
+		f := v.Field(i)
+
+		switch {
+
+		case f.Kind() == reflect.Func:
+			if !f.IsNil() {
+				ss = append(ss, name+": set")
+			}
+
+		case name == "Hooks":
+			if !reflect.DeepEqual(f.Interface(), Hooks{}) {
+				ss = append(ss, name+": set")
+			}
+
+		case name == "FS":
+			if !f.IsNil() {
+				ss = append(ss, name+": set")
+			}
+
+		case name == "UserPW" || name == "OwnerPW":
+			if f.String() != "" {
+				ss = append(ss, name+": set")
+			}
+
+		case name == "UserPWNew" || name == "OwnerPWNew":
+			if !f.IsNil() {
+				ss = append(ss, name+": set")
+			}
+
+		case !reflect.DeepEqual(f.Interface(), vDefault.Field(i).Interface()):
+			if f.Kind() == reflect.Ptr && !f.IsNil() {
+				f = f.Elem()
+			}
+			ss = append(ss, fmt.Sprintf("%s: %v", name, f.Interface()))
+		}
+	}
+
+	return ss
+}
+
+// CurrentTime returns the current time as seen by the configured clock and time zone.
+func (c *Configuration) CurrentTime() time.Time {
+
+	now := time.Now
+	if c.Now != nil {
+		now = c.Now
+	}
+
+	t := now()
+
+	if c.TimeZone != nil {
+		t = t.In(c.TimeZone)
+	}
+
+	return t
+}
+
+// EncryptionSettings describe the protection of a single output file.
+type EncryptionSettings struct {
+	UserPW, OwnerPW       string
+	EncryptUsingAES       bool
+	EncryptUsing128BitKey bool
+	UserAccessPermissions int16
+}
+
+// ApplyEncryption configures c for writing a file encrypted according to es.
+func (c *Configuration) ApplyEncryption(es EncryptionSettings) {
+	c.Mode = ENCRYPT
+	c.UserPW = es.UserPW
+	c.OwnerPW = es.OwnerPW
+	c.EncryptUsingAES = es.EncryptUsingAES
+	c.EncryptUsing128BitKey = es.EncryptUsing128BitKey
+	c.UserAccessPermissions = es.UserAccessPermissions
+}
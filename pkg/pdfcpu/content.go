@@ -0,0 +1,182 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// contentScanner splits a decoded content stream into tokens.
+// Arrays and dicts are returned as single tokens so every token is either a complete operand or an operator.
+// See 7.8.2 Content Streams
+type contentScanner struct {
+	b   []byte
+	pos int
+}
+
+func contentWhitespace(c byte) bool {
+	return c == 0x00 || c == 0x09 || c == 0x0A || c == 0x0C || c == 0x0D || c == 0x20
+}
+
+func contentDelimiter(c byte) bool {
+	return bytes.IndexByte([]byte("()<>[]{}/%"), c) >= 0
+}
+
+func (s *contentScanner) skipWhitespaceAndComments() {
+
+	for s.pos < len(s.b) {
+
+		c := s.b[s.pos]
+
+		if contentWhitespace(c) {
+			s.pos++
+			continue
+		}
+
+		if c != '%' {
+			return
+		}
+
+		for s.pos < len(s.b) && s.b[s.pos] != 0x0A && s.b[s.pos] != 0x0D {
+			s.pos++
+		}
+	}
+}
+
+// skipStringLiteral advances past the string literal starting at s.pos.
+func (s *contentScanner) skipStringLiteral() error {
+
+	depth := 0
+
+	for ; s.pos < len(s.b); s.pos++ {
+
+		switch s.b[s.pos] {
+
+		case '\\':
+			s.pos++
+
+		case '(':
+			depth++
+
+		case ')':
+			depth--
+			if depth == 0 {
+				s.pos++
+				return nil
+			}
+		}
+	}
+
+	return errors.New("contentScanner: unterminated string literal")
+}
+
+// skipComposite advances past the array or dict whose opening delimiter has been consumed.
+func (s *contentScanner) skipComposite(closing string) error {
+
+	for {
+		tok, _, err := s.next()
+		if err == io.EOF {
+			return errors.Errorf("contentScanner: missing %q", closing)
+		}
+		if err != nil {
+			return err
+		}
+		if string(tok) == closing {
+			return nil
+		}
+	}
+}
+
+// next returns the next token along with its offset.
+// io.EOF signals the end of the content stream.
+func (s *contentScanner) next() ([]byte, int, error) {
+
+	s.skipWhitespaceAndComments()
+
+	if s.pos >= len(s.b) {
+		return nil, s.pos, io.EOF
+	}
+
+	start := s.pos
+	c := s.b[s.pos]
+
+	switch c {
+
+	case '(':
+		if err := s.skipStringLiteral(); err != nil {
+			return nil, start, err
+		}
+
+	case '<':
+		if s.pos+1 < len(s.b) && s.b[s.pos+1] == '<' {
+			s.pos += 2
+			if err := s.skipComposite(">>"); err != nil {
+				return nil, start, err
+			}
+			break
+		}
+		i := bytes.IndexByte(s.b[s.pos:], '>')
+		if i < 0 {
+			return nil, start, errors.New("contentScanner: unterminated hex string")
+		}
+		s.pos += i + 1
+
+	case '>':
+		if s.pos+1 >= len(s.b) || s.b[s.pos+1] != '>' {
+			return nil, start, errors.New("contentScanner: unexpected '>'")
+		}
+		s.pos += 2
+
+	case '[':
+		s.pos++
+		if err := s.skipComposite("]"); err != nil {
+			return nil, start, err
+		}
+
+	case ']', '{', '}', ')':
+		s.pos++
+
+	default:
+		// Names, numbers, booleans, null and operators.
+		s.pos++
+		for s.pos < len(s.b) && !contentWhitespace(s.b[s.pos]) && !contentDelimiter(s.b[s.pos]) {
+			s.pos++
+		}
+	}
+
+	return s.b[start:s.pos], start, nil
+}
+
+// contentOperator returns true if tok is an operator as opposed to an operand.
+func contentOperator(tok []byte) bool {
+
+	if len(tok) == 0 {
+		return false
+	}
+
+	switch string(tok) {
+	case "true", "false", "null":
+		return false
+	}
+
+	c := tok[0]
+
+	return !(contentDelimiter(c) || c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9'))
+}
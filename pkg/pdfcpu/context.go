@@ -20,6 +20,7 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"path/filepath"
 	"sort"
 	"strings"
 
@@ -27,6 +28,12 @@ import (
 )
 
 // Context represents an environment for processing PDF files.
+//
+// Once read, validated and optimized a Context may serve concurrent read only operations
+// like PageDict, PageContentDigest, PageText, PageTextSpans, ExtractImageData, ExtractFontData, ExtractStreamData
+// and DiffContexts, eg. for extracting the text and images of different pages in parallel goroutines.
+// These operations decode copies of stream dicts and never populate shared caches, warnings get recorded synchronized.
+// Any operation modifying a Context needs exclusive access, use Clone to derive an independent Context instead.
 type Context struct {
 	*Configuration
 	*XRefTable
@@ -42,9 +49,13 @@ func NewContext(rs io.ReadSeeker, fileName string, fileSize int64, config *Confi
 		config = NewDefaultConfiguration()
 	}
 
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
 	ctx := &Context{
 		config,
-		newXRefTable(config.ValidationMode),
+		newXRefTable(config),
 		newReadContext(rs, fileName, fileSize),
 		newOptimizationContext(),
 		NewWriteContext(config.Eol),
@@ -152,6 +163,7 @@ type ReadContext struct {
 	FileName            string // The input PDF-File.
 	FileSize            int64
 	rs                  io.ReadSeeker
+	headerOffset        int64  // Junk in front of the header.
 	BinaryTotalSize     int64  // total stream data
 	BinaryImageSize     int64  // total image stream data
 	BinaryFontSize      int64  // total font stream data (fontfiles)
@@ -163,6 +175,7 @@ type ReadContext struct {
 	ObjectStreams       IntSet // All object numbers of any object streams found which need to be decoded.
 	UsingXRefStreams    bool   // File is using xref streams.
 	XRefStreams         IntSet // All object numbers of any xref streams found.
+	hiddenObjs          IntSet // Free entries of the xref section being parsed, see parseHybridXRefStream.
 }
 
 func newReadContext(rs io.ReadSeeker, fileName string, fileSize int64) *ReadContext {
@@ -256,22 +269,23 @@ func (rc *ReadContext) LogStats(optimized bool) {
 }
 
 // ReadFileSize returns the size of the input file, if there is one.
-func (rc *ReadContext) ReadFileSize() int {
+func (rc *ReadContext) ReadFileSize() int64 {
 	if rc == nil {
 		return 0
 	}
-	return int(rc.FileSize)
+	return rc.FileSize
 }
 
 // OptimizationContext represents the context for the optimiziation of a PDF file.
 type OptimizationContext struct {
 
 	// Font section
-	PageFonts         []IntSet            // For each page a registry of font object numbers.
-	FontObjects       map[int]*FontObject // FontObject lookup table by font object number.
-	Fonts             map[string][]int    // All font object numbers registered for a font name.
-	DuplicateFonts    map[int]Dict        // Registry of duplicate font dicts.
-	DuplicateFontObjs IntSet              // The set of objects that represents the union of the object graphs of all duplicate font dicts.
+	PageFonts          []IntSet            // For each page a registry of font object numbers.
+	FontObjects        map[int]*FontObject // FontObject lookup table by font object number.
+	Fonts              map[string][]int    // All font object numbers registered for a font name.
+	DuplicateFonts     map[int]Dict        // Registry of duplicate font dicts.
+	DuplicateFontObjs  IntSet              // The set of objects that represents the union of the object graphs of all duplicate font dicts.
+	DuplicateFontFiles map[int]*StreamDict // Registry of duplicate font files (embedded font programs).
 
 	// Image section
 	PageImages         []IntSet             // For each page a registry of image object numbers.
@@ -289,6 +303,7 @@ func newOptimizationContext() *OptimizationContext {
 		Fonts:                map[string][]int{},
 		DuplicateFonts:       map[int]Dict{},
 		DuplicateFontObjs:    IntSet{},
+		DuplicateFontFiles:   map[int]*StreamDict{},
 		ImageObjects:         map[int]*ImageObject{},
 		DuplicateImages:      map[int]*StreamDict{},
 		DuplicateImageObjs:   IntSet{},
@@ -552,9 +567,23 @@ type WriteContext struct {
 	BinaryFontSize      int64         // total font stream data (fontfiles) = copy of Read.BinaryFontSize.
 	Table               map[int]int64 // object write offsets
 	Offset              int64         // current write offset
-	WriteToObjectStream bool          // if true start to embed objects into object streams and obey ObjectStreamMaxObjects.
+	WriteToObjectStream bool          // if true start to embed objects into object streams and obey MaxObjectsPerObjectStream.
 	CurrentObjStream    *int          // if not nil, any new non-stream-object gets added to the object stream with this object number.
 	Eol                 string        // end of line char sequence
+
+	// Object stream packing stats.
+	ObjectStreams           int   // number of object streams written.
+	ObjectStreamObjects     int   // number of objects compressed into object streams.
+	ObjectStreamSize        int64 // total object stream data before encoding.
+	ObjectStreamEncodedSize int64 // total object stream data written.
+
+	writerAt io.WriterAt     // if not nil, object streams get encoded and file segments get written concurrently.
+	segments []*writeSegment // file segments pending for writerAt.
+}
+
+// FilePath returns the path of the file to be written.
+func (wc *WriteContext) FilePath() string {
+	return filepath.Join(wc.DirName, wc.FileName)
 }
 
 // NewWriteContext returns a new WriteContext.
@@ -614,6 +643,18 @@ func (wc *WriteContext) LogStats() {
 	log.Stats.Printf("images               : %s (%d bytes) %4.1f%%\n", ByteSize(binaryImageSize), binaryImageSize, float32(binaryImageSize)/float32(binaryTotalSize)*100)
 	log.Stats.Printf("fonts                : %s (%d bytes) %4.1f%%\n", ByteSize(binaryFontSize), binaryFontSize, float32(binaryFontSize)/float32(binaryTotalSize)*100)
 	log.Stats.Printf("other                : %s (%d bytes) %4.1f%%\n\n", ByteSize(binaryOtherSize), binaryOtherSize, float32(binaryOtherSize)/float32(binaryTotalSize)*100)
+
+	if wc.ObjectStreams == 0 {
+		return
+	}
+
+	objCount := len(wc.Table)
+
+	log.Stats.Println("Object streams:")
+	log.Stats.Printf("object streams       : %d\n", wc.ObjectStreams)
+	log.Stats.Printf("compressed objects   : %d of %d %4.1f%%\n", wc.ObjectStreamObjects, objCount, float32(wc.ObjectStreamObjects)/float32(objCount)*100)
+	log.Stats.Printf("objects per stream   : %4.1f\n", float32(wc.ObjectStreamObjects)/float32(wc.ObjectStreams))
+	log.Stats.Printf("packed size          : %s -> %s %4.1f%%\n\n", ByteSize(wc.ObjectStreamSize), ByteSize(wc.ObjectStreamEncodedSize), float32(wc.ObjectStreamEncodedSize)/float32(wc.ObjectStreamSize)*100)
 }
 
 // WriteEol writes an end of line sequence.
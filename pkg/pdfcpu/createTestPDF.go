@@ -31,9 +31,10 @@ var testAudioFileWAV = filepath.Join("testdata", "resources", "test.wav")
 func createXRefTableWithRootDict() (*XRefTable, error) {
 
 	xRefTable := &XRefTable{
-		Table: map[int]*XRefTableEntry{},
-		Names: map[string]*Node{},
-		Stats: NewPDFStats(),
+		Table:    map[int]*XRefTableEntry{},
+		Names:    map[string]*Node{},
+		Stats:    NewPDFStats(),
+		warnings: &warnings{},
 	}
 
 	xRefTable.Table[0] = NewFreeHeadXRefTableEntry()
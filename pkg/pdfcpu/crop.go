@@ -0,0 +1,352 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/jplu/pdfcpu/pkg/log"
+	"github.com/jplu/pdfcpu/pkg/types"
+	"github.com/pkg/errors"
+)
+
+// Anchor represents the position of a rectangle within a page.
+type Anchor int
+
+// The positions a crop box of given dimensions may be anchored at.
+const (
+	Center Anchor = iota
+	TopLeft
+	TopCenter
+	TopRight
+	Left
+	Right
+	BottomLeft
+	BottomCenter
+	BottomRight
+)
+
+var anchors = map[string]Anchor{
+	"tl": TopLeft,
+	"tc": TopCenter,
+	"tr": TopRight,
+	"l":  Left,
+	"c":  Center,
+	"r":  Right,
+	"bl": BottomLeft,
+	"bc": BottomCenter,
+	"br": BottomRight,
+}
+
+// Crop describes a page box in terms of the media box of a page.
+// Exactly one of Rect, Margins and Width/Height is in effect.
+// All values are in user space units and refer to the unrotated page.
+type Crop struct {
+	Rect          *types.Rectangle // An absolute rectangle, gets clipped to the media box.
+	Margins       []float64        // Margins inside the media box: top, right, bottom, left.
+	Width, Height float64          // Dimensions positioned within the media box according to Pos.
+	Pos           Anchor
+	Boxes         []string // Page boxes set in addition to the crop box, any of TrimBox, BleedBox, ArtBox.
+}
+
+func parseCropError() error {
+	return errors.New("Invalid crop configuration string. Please consult pdfcpu help crop.")
+}
+
+func parseCropFloats(v string, negative bool, n ...int) ([]float64, error) {
+
+	ss := strings.Fields(v)
+
+	ok := false
+	for _, i := range n {
+		if len(ss) == i {
+			ok = true
+			break
+		}
+	}
+	if !ok {
+		return nil, errors.Errorf("illegal number of values: %s\n", v)
+	}
+
+	ff := make([]float64, len(ss))
+
+	for i, s := range ss {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, errors.Errorf("value must be a float value: %s\n", s)
+		}
+		if f < 0 && !negative {
+			return nil, errors.Errorf("value must not be negative: %s\n", s)
+		}
+		ff[i] = f
+	}
+
+	return ff, nil
+}
+
+func parseCropRect(v string, c *Crop) error {
+
+	ff, err := parseCropFloats(v, true, 4)
+	if err != nil {
+		return err
+	}
+
+	r := types.NewRectangle(
+		math.Min(ff[0], ff[2]), math.Min(ff[1], ff[3]),
+		math.Max(ff[0], ff[2]), math.Max(ff[1], ff[3]))
+
+	if r.Width() == 0 || r.Height() == 0 {
+		return errors.Errorf("empty rectangle: %s\n", v)
+	}
+
+	c.Rect = &r
+
+	return nil
+}
+
+func parseCropMargins(v string, c *Crop) error {
+
+	ff, err := parseCropFloats(v, false, 1, 2, 4)
+	if err != nil {
+		return err
+	}
+
+	// Expand like CSS: all, vertical horizontal, top right bottom left.
+	switch len(ff) {
+	case 1:
+		c.Margins = []float64{ff[0], ff[0], ff[0], ff[0]}
+	case 2:
+		c.Margins = []float64{ff[0], ff[1], ff[0], ff[1]}
+	default:
+		c.Margins = ff
+	}
+
+	return nil
+}
+
+func parseCropDimensions(v string, c *Crop) error {
+
+	ff, err := parseCropFloats(v, false, 2)
+	if err != nil {
+		return err
+	}
+
+	if ff[0] == 0 || ff[1] == 0 {
+		return errors.Errorf("empty dimensions: %s\n", v)
+	}
+
+	c.Width, c.Height = ff[0], ff[1]
+
+	return nil
+}
+
+func parseCropBoxes(v string, c *Crop) error {
+
+	for _, s := range strings.Fields(v) {
+		switch s {
+		case "trim":
+			c.Boxes = append(c.Boxes, "TrimBox")
+		case "bleed":
+			c.Boxes = append(c.Boxes, "BleedBox")
+		case "art":
+			c.Boxes = append(c.Boxes, "ArtBox")
+		default:
+			return errors.Errorf("illegal page box: allowed trim, bleed, art, %s\n", s)
+		}
+	}
+
+	return nil
+}
+
+// ParseCropDetails parses a crop command string into an internal structure.
+func ParseCropDetails(s string) (*Crop, error) {
+
+	c := Crop{}
+
+	var setPos bool
+
+	for _, s := range strings.Split(s, ",") {
+
+		ss := strings.Split(s, ":")
+		if len(ss) != 2 {
+			return nil, parseCropError()
+		}
+
+		k := strings.TrimSpace(ss[0])
+		v := strings.TrimSpace(ss[1])
+
+		var err error
+
+		switch k {
+		case "rect":
+			err = parseCropRect(v, &c)
+
+		case "margin":
+			err = parseCropMargins(v, &c)
+
+		case "dim":
+			err = parseCropDimensions(v, &c)
+
+		case "pos":
+			a, ok := anchors[v]
+			if !ok {
+				err = errors.Errorf("illegal position: allowed tl, tc, tr, l, c, r, bl, bc, br, %s\n", v)
+			}
+			c.Pos = a
+			setPos = true
+
+		case "boxes":
+			err = parseCropBoxes(v, &c)
+
+		default:
+			err = parseCropError()
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if setPos && c.Width == 0 {
+		return nil, errors.New("Please specify pos in combination with dim only")
+	}
+
+	return &c, c.validate()
+}
+
+func (c Crop) validate() error {
+
+	n := 0
+	if c.Rect != nil {
+		n++
+	}
+	if c.Margins != nil {
+		n++
+	}
+	if c.Width > 0 || c.Height > 0 {
+		n++
+	}
+
+	if n != 1 {
+		return errors.New("Please specify one of rect, margin or dim")
+	}
+
+	if c.Margins != nil && len(c.Margins) != 4 {
+		return errors.New("Please specify margins for top, right, bottom and left")
+	}
+
+	return nil
+}
+
+// anchorPosition returns the lower left corner of a rectangle of size w x h positioned at a within r.
+func anchorPosition(r types.Rectangle, w, h float64, a Anchor) (float64, float64) {
+
+	x := r.LL.X + (r.Width()-w)/2
+	y := r.LL.Y + (r.Height()-h)/2
+
+	switch a {
+	case TopLeft, Left, BottomLeft:
+		x = r.LL.X
+	case TopRight, Right, BottomRight:
+		x = r.UR.X - w
+	}
+
+	switch a {
+	case TopLeft, TopCenter, TopRight:
+		y = r.UR.Y - h
+	case BottomLeft, BottomCenter, BottomRight:
+		y = r.LL.Y
+	}
+
+	return x, y
+}
+
+// box returns the page box described by c for a page with media box mb.
+func (c Crop) box(mb types.Rectangle) (types.Rectangle, error) {
+
+	var r types.Rectangle
+
+	switch {
+
+	case c.Rect != nil:
+		r = types.NewRectangle(
+			math.Max(c.Rect.LL.X, mb.LL.X), math.Max(c.Rect.LL.Y, mb.LL.Y),
+			math.Min(c.Rect.UR.X, mb.UR.X), math.Min(c.Rect.UR.Y, mb.UR.Y))
+
+	case c.Margins != nil:
+		r = types.NewRectangle(
+			mb.LL.X+c.Margins[3], mb.LL.Y+c.Margins[2],
+			mb.UR.X-c.Margins[1], mb.UR.Y-c.Margins[0])
+
+	default:
+		w := math.Min(c.Width, mb.Width())
+		h := math.Min(c.Height, mb.Height())
+		x, y := anchorPosition(mb, w, h, c.Pos)
+		r = types.NewRectangle(x, y, x+w, y+h)
+	}
+
+	if r.Width() <= 0 || r.Height() <= 0 {
+		return r, errors.Errorf("crop box outside media box %s", mb)
+	}
+
+	return r, nil
+}
+
+// Crop sets the crop box and any additional page boxes described by c for selectedPages.
+func (xRefTable *XRefTable) Crop(selectedPages IntSet, c *Crop) error {
+
+	log.Debug.Printf("Crop begin: %v\n", selectedPages)
+
+	if err := c.validate(); err != nil {
+		return err
+	}
+
+	for i := 1; i <= xRefTable.PageCount; i++ {
+
+		if !selectedPages[i] {
+			continue
+		}
+
+		d, inhPAttrs, err := xRefTable.PageDict(i)
+		if err != nil {
+			return err
+		}
+		if d == nil || inhPAttrs.mediaBox == nil {
+			return errors.Errorf("Crop: missing page %d", i)
+		}
+
+		mb := rect(xRefTable, inhPAttrs.mediaBox)
+		mb = types.NewRectangle(
+			math.Min(mb.LL.X, mb.UR.X), math.Min(mb.LL.Y, mb.UR.Y),
+			math.Max(mb.LL.X, mb.UR.X), math.Max(mb.LL.Y, mb.UR.Y))
+
+		r, err := c.box(mb)
+		if err != nil {
+			return errors.Wrapf(err, "Crop: page %d", i)
+		}
+
+		for _, k := range append([]string{"CropBox"}, c.Boxes...) {
+			d.Update(k, NewRectangle(r.LL.X, r.LL.Y, r.UR.X, r.UR.Y))
+		}
+	}
+
+	log.Debug.Println("Crop end")
+
+	return nil
+}
@@ -29,7 +29,6 @@ import (
 	"fmt"
 	"io"
 	"strconv"
-	"time"
 
 	"github.com/jplu/pdfcpu/pkg/log"
 	"github.com/pkg/errors"
@@ -47,29 +46,52 @@ var (
 	}
 
 	// Needed permission bits for pdfcpu commands.
-	perm = map[CommandMode]struct{ extract, modify int }{
-		VALIDATE:           {0, 0},
-		OPTIMIZE:           {0, 0},
-		SPLIT:              {1, 0},
-		MERGE:              {0, 0},
-		EXTRACTIMAGES:      {1, 0},
-		EXTRACTFONTS:       {1, 0},
-		EXTRACTPAGES:       {1, 0},
-		EXTRACTCONTENT:     {1, 0},
-		EXTRACTMETADATA:    {1, 0},
-		TRIM:               {0, 1},
-		LISTATTACHMENTS:    {0, 0},
-		EXTRACTATTACHMENTS: {1, 0},
-		ADDATTACHMENTS:     {0, 1},
-		REMOVEATTACHMENTS:  {0, 1},
-		LISTPERMISSIONS:    {0, 0},
-		ADDPERMISSIONS:     {0, 0},
-		ADDWATERMARKS:      {1, 0},
+	perm = map[CommandMode]struct{ extract, modify, annotate int }{
+		VALIDATE:            {0, 0, 0},
+		OPTIMIZE:            {0, 0, 0},
+		SPLIT:               {1, 0, 0},
+		MERGE:               {0, 0, 0},
+		EXTRACTIMAGES:       {1, 0, 0},
+		EXTRACTFONTS:        {1, 0, 0},
+		EXTRACTPAGES:        {1, 0, 0},
+		EXTRACTCONTENT:      {1, 0, 0},
+		EXTRACTMETADATA:     {1, 0, 0},
+		EXTRACTTEXT:         {1, 0, 0},
+		RENDERPAGES:         {1, 0, 0},
+		TRIM:                {0, 1, 0},
+		COLLECT:             {0, 1, 0},
+		REMOVEPAGES:         {0, 1, 0},
+		CROP:                {0, 1, 0},
+		RESIZE:              {0, 1, 0},
+		POSTER:              {0, 1, 0},
+		LISTATTACHMENTS:     {0, 0, 0},
+		EXTRACTATTACHMENTS:  {1, 0, 0},
+		ADDATTACHMENTS:      {0, 1, 0},
+		REMOVEATTACHMENTS:   {0, 1, 0},
+		LISTPERMISSIONS:     {0, 0, 0},
+		ADDPERMISSIONS:      {0, 0, 0},
+		ADDWATERMARKS:       {1, 0, 0},
+		REMOVEWATERMARKS:    {0, 1, 0},
+		ADDFORMFIELDS:       {0, 1, 0},
+		FILLFORMFIELDS:      {0, 1, 0},
+		GRAYSCALE:           {0, 1, 0},
+		BOOKLET:             {0, 1, 0},
+		FLATTENANNOTATIONS:  {0, 1, 0},
+		LOCKFORMFIELDS:      {0, 1, 0},
+		SETLANGUAGE:         {0, 1, 0},
+		SETTABORDER:         {0, 1, 0},
+		SETCALCULATIONORDER: {0, 1, 0},
+		SETCHOICEOPTIONS:    {0, 1, 0},
+		ADDANNOTATIONS:      {0, 0, 1},
+		EXPORTANNOTATIONS:   {1, 0, 0},
+		ADDCOMMENTSUMMARY:   {0, 1, 0},
+		REMOVEUSAGERIGHTS:   {0, 1, 0},
+		INSERTPAGES:         {0, 1, 0},
 	}
 )
 
 // NewEncryptDict creates a new EncryptDict using the standard security handler.
-func newEncryptDict(needAES, need128BitKey bool, permissions int16) Dict {
+func newEncryptDict(needAES, need128BitKey, encryptMetadata bool, permissions int16) Dict {
 
 	d := NewDict()
 
@@ -92,6 +114,10 @@ func newEncryptDict(needAES, need128BitKey bool, permissions int16) Dict {
 	d.Insert("StmF", Name("StdCF"))
 	d.Insert("StrF", Name("StdCF"))
 
+	if need128BitKey && !encryptMetadata {
+		d.Insert("EncryptMetadata", Boolean(false))
+	}
+
 	d1 := NewDict()
 	d1.Insert("AuthEvent", Name("DocOpen"))
 
@@ -483,6 +509,19 @@ func maskModify(mode CommandMode, secHandlerRev int) int {
 	return 0x0008 // need bit 4
 }
 
+func maskAnnotate(mode CommandMode) int {
+
+	p, ok := perm[mode]
+
+	// no permissions defined or don't need annotate permission
+	if !ok || p.annotate == 0 {
+		return 0
+	}
+
+	// need annotate permission
+	return 0x0020 // need bit 6
+}
+
 // HasNeededPermissions returns true if permissions for pdfcpu processing are present.
 func hasNeededPermissions(mode CommandMode, enc *Enc) bool {
 
@@ -504,6 +543,44 @@ func hasNeededPermissions(mode CommandMode, enc *Enc) bool {
 		}
 	}
 
+	m = maskAnnotate(mode)
+	if m > 0 {
+		if enc.P&m == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// stringsEncrypted returns true if strings are subject to encryption.
+func (xRefTable *XRefTable) stringsEncrypted() bool {
+	return xRefTable.EncKey != nil && !xRefTable.Identity4Strings
+}
+
+// streamEncrypted returns true if sd is subject to encryption.
+// Exempt are xref streams, streams using the Identity crypt filter
+// and metadata streams unless EncryptMetadata is true, see 7.6.5 Crypt Filters.
+func (xRefTable *XRefTable) streamEncrypted(sd StreamDict) bool {
+
+	if xRefTable.EncKey == nil || xRefTable.Identity4Streams {
+		return false
+	}
+
+	if t := sd.Type(); t != nil {
+		if *t == "XRef" || *t == "Metadata" && xRefTable.E != nil && !xRefTable.E.Emd {
+			return false
+		}
+	}
+
+	// A crypt filter has to be the first filter in the pipeline.
+	if len(sd.FilterPipeline) > 0 && sd.FilterPipeline[0].Name == "Crypt" {
+		dp := sd.FilterPipeline[0].DecodeParms
+		if dp == nil || dp.NameEntry("Name") == nil || *dp.NameEntry("Name") == "Identity" {
+			return false
+		}
+	}
+
 	return true
 }
 
@@ -519,7 +596,9 @@ func getV(d Dict) (*int, error) {
 }
 func checkStmf(ctx *Context, stmf *string, cfDict Dict) error {
 
-	if stmf != nil && *stmf != "Identity" {
+	ctx.Identity4Streams = stmf == nil || *stmf == "Identity"
+
+	if !ctx.Identity4Streams {
 
 		d := cfDict.DictEntry(*stmf)
 		if d == nil {
@@ -563,7 +642,8 @@ func checkV(ctx *Context, d Dict) (*int, error) {
 
 	// StrF
 	strf := d.NameEntry("StrF")
-	if strf != nil && *strf != "Identity" {
+	ctx.Identity4Strings = strf == nil || *strf == "Identity"
+	if !ctx.Identity4Strings {
 		d1 := cfDict.DictEntry(*strf)
 		if d1 == nil {
 			return nil, errors.Errorf("checkV: entry \"%s\" missing in \"CF\"", *strf)
@@ -577,7 +657,7 @@ func checkV(ctx *Context, d Dict) (*int, error) {
 
 	// EFF
 	eff := d.NameEntry("EFF")
-	if eff != nil && *strf != "Identity" {
+	if eff != nil && *eff != "Identity" {
 		d := cfDict.DictEntry(*eff)
 		if d == nil {
 			return nil, errors.Errorf("checkV: entry \"%s\" missing in \"CF\"", *eff)
@@ -769,6 +849,58 @@ func decryptString(needAES bool, s string, objNr, genNr int, key []byte) (*strin
 	return applyRC4Cipher(b, objNr, genNr, key, needAES)
 }
 
+// encryptHexLiteral encrypts the bytes represented by hl using RC4 or AES.
+func encryptHexLiteral(needAES bool, hl HexLiteral, objNr, genNr int, key []byte) (*HexLiteral, error) {
+
+	b, err := hl.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	if needAES {
+		b, err = encryptAESBytes(b, decryptKey(objNr, genNr, key, needAES))
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		s, err := applyRC4Cipher(b, objNr, genNr, key, needAES)
+		if err != nil {
+			return nil, err
+		}
+		b = []byte(*s)
+	}
+
+	hl1 := HexLiteral(hex.EncodeToString(b))
+
+	return &hl1, nil
+}
+
+// decryptHexLiteral decrypts the bytes represented by hl using RC4 or AES.
+func decryptHexLiteral(needAES bool, hl HexLiteral, objNr, genNr int, key []byte) (*HexLiteral, error) {
+
+	b, err := hl.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	if needAES {
+		b, err = decryptAESBytes(b, decryptKey(objNr, genNr, key, needAES))
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		s, err := applyRC4Cipher(b, objNr, genNr, key, needAES)
+		if err != nil {
+			return nil, err
+		}
+		b = []byte(*s)
+	}
+
+	hl1 := HexLiteral(hex.EncodeToString(b))
+
+	return &hl1, nil
+}
+
 func applyRC4Cipher(b []byte, objNr, genNr int, key []byte, needAES bool) (*string, error) {
 
 	log.Debug.Printf("applyRC4Cipher begin s:<%v> %d %d key:%X aes:%t\n", b, objNr, genNr, key, needAES)
@@ -1039,12 +1171,12 @@ func fileID(ctx *Context) (HexLiteral, error) {
 	h := md5.New()
 
 	// Current timestamp.
-	h.Write([]byte(time.Now().String()))
+	h.Write([]byte(ctx.CurrentTime().String()))
 
 	// File location - ignore, we don't have this.
 
 	// File size.
-	h.Write([]byte(strconv.Itoa(ctx.Read.ReadFileSize())))
+	h.Write([]byte(strconv.FormatInt(ctx.Read.ReadFileSize(), 10)))
 
 	// All values of the info dict which is assumed to be there at this point.
 	d, err := ctx.DereferenceDict(*ctx.Info)
@@ -1052,8 +1184,8 @@ func fileID(ctx *Context) (HexLiteral, error) {
 		return "", err
 	}
 
-	for _, v := range d {
-		o, err := ctx.Dereference(v)
+	for _, k := range sortedKeys(d) {
+		o, err := ctx.Dereference(d[k])
 		if err != nil {
 			return "", err
 		}
@@ -397,6 +397,19 @@ func (d Dict) indentedString(level int) string {
 	return strings.Join(logstr, "")
 }
 
+// sortedKeys returns the keys of d in order.
+// Iterate over sortedKeys wherever the order of processing shows in the result, eg. when writing objects.
+func sortedKeys(d Dict) []string {
+
+	keys := make([]string, 0, len(d))
+	for k := range d {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
 // PDFString returns a string representation as found in and written to a PDF file.
 func (d Dict) PDFString() string {
 
@@ -412,6 +425,7 @@ func (d Dict) PDFString() string {
 	for _, k := range keys {
 
 		v := d[k]
+		k := Name(k).PDFString()[1:]
 
 		if v == nil {
 			logstr = append(logstr, fmt.Sprintf("/%s null", k))
@@ -454,6 +468,11 @@ func (d Dict) PDFString() string {
 
 		f, ok := v.(Float)
 		if ok {
+			if t := d.Type(); t != nil && *t == "NumberFormat" {
+				// Keep the precision of conversion factors of measure dicts.
+				logstr = append(logstr, fmt.Sprintf("/%s %s", k, f.preciseString()))
+				continue
+			}
 			logstr = append(logstr, fmt.Sprintf("/%s %s", k, f))
 			continue
 		}
@@ -0,0 +1,245 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+
+	"github.com/jplu/pdfcpu/pkg/filter"
+)
+
+// Difference describes a single difference between two documents.
+type Difference struct {
+	Path string // Location of the value independent of object numbers, eg. "Page 1/Resources/Font/F1/BaseFont".
+	A    string // Value in the first document, empty if missing.
+	B    string // Value in the second document, empty if missing.
+}
+
+func (d Difference) String() string {
+
+	if d.A == "" {
+		return fmt.Sprintf("added    %s: %s", d.Path, d.B)
+	}
+
+	if d.B == "" {
+		return fmt.Sprintf("removed  %s: %s", d.Path, d.A)
+	}
+
+	return fmt.Sprintf("modified %s: %s -> %s", d.Path, d.A, d.B)
+}
+
+// flattener maps all values reachable in a document to paths independent of object numbers.
+type flattener struct {
+	xRefTable *XRefTable
+	visited   map[int]string // object number => first path the object has been reached by.
+	values    map[string]string
+}
+
+func (f *flattener) streamDigest(sd StreamDict) string {
+
+	// sd is a copy, decoding leaves the xRefTable untouched.
+	b := sd.Raw
+	if err := decodeStream(&sd); err == nil {
+		b = sd.Content
+	} else if err != filter.ErrUnsupportedFilter {
+		return fmt.Sprintf("stream (undecodable: %v)", err)
+	}
+
+	return fmt.Sprintf("stream sha256:%x", sha256.Sum256(b))
+}
+
+func (f *flattener) flattenDict(path string, d Dict) error {
+
+	for _, k := range sortedKeys(d) {
+
+		// Parent links point back up and the stream length depends on the encoding used.
+		if k == "Parent" || k == "Length" {
+			continue
+		}
+
+		err := f.flatten(path+"/"+k, d[k])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *flattener) flatten(path string, o Object) error {
+
+	if ir, ok := o.(IndirectRef); ok {
+
+		objNr := ir.ObjectNumber.Value()
+
+		if p, ok := f.visited[objNr]; ok {
+			f.values[path] = "-> " + p
+			return nil
+		}
+
+		f.visited[objNr] = path
+
+		var err error
+		if o, err = f.xRefTable.Dereference(ir); err != nil {
+			return err
+		}
+	}
+
+	switch o := o.(type) {
+
+	case nil:
+		f.values[path] = "null"
+
+	case Dict:
+		return f.flattenDict(path, o)
+
+	case StreamDict:
+		f.values[path] = f.streamDigest(o)
+		return f.flattenDict(path, o.Dict)
+
+	case Array:
+		for i, v := range o {
+			if err := f.flatten(fmt.Sprintf("%s[%d]", path, i), v); err != nil {
+				return err
+			}
+		}
+
+	default:
+		f.values[path] = o.String()
+	}
+
+	return nil
+}
+
+// pageIndRefs returns the indirect references of all pages in page order.
+func (f *flattener) pageIndRefs() ([]IndirectRef, error) {
+
+	root, err := f.xRefTable.Pages()
+	if err != nil {
+		return nil, err
+	}
+
+	var irs []IndirectRef
+
+	var collect func(ir IndirectRef) error
+	collect = func(ir IndirectRef) error {
+
+		d, err := f.xRefTable.DereferenceDict(ir)
+		if err != nil || d == nil {
+			return err
+		}
+
+		if t := d.Type(); t != nil && *t == "Page" {
+			irs = append(irs, ir)
+			return nil
+		}
+
+		for _, o := range d.ArrayEntry("Kids") {
+			if ir, ok := o.(IndirectRef); ok {
+				if err = collect(ir); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	return irs, collect(*root)
+}
+
+func flattenContext(ctx *Context) (map[string]string, error) {
+
+	f := &flattener{xRefTable: ctx.XRefTable, visited: map[int]string{}, values: map[string]string{}}
+
+	// Pages first: anything reachable from a page is addressed by page number.
+	irs, err := f.pageIndRefs()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, ir := range irs {
+		err = f.flatten(fmt.Sprintf("Page %d", i+1), ir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rootDict, err := ctx.Catalog()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, k := range sortedKeys(rootDict) {
+
+		if k == "Pages" {
+			continue
+		}
+
+		err = f.flatten("Root/"+k, rootDict[k])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if ctx.Info != nil {
+		err = f.flatten("Info", *ctx.Info)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return f.values, nil
+}
+
+// DiffContexts compares two documents and returns all added, removed and modified values sorted by path.
+// Objects are aligned by page and role, not by object number,
+// so the result is not affected by a writer renumbering objects.
+// Streams are compared by a digest of their decoded content.
+// Both contexts need to be validated.
+func DiffContexts(a, b *Context) ([]Difference, error) {
+
+	va, err := flattenContext(a)
+	if err != nil {
+		return nil, err
+	}
+
+	vb, err := flattenContext(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []Difference
+
+	for p, s := range va {
+		if t := vb[p]; s != t {
+			diffs = append(diffs, Difference{Path: p, A: s, B: t})
+		}
+	}
+
+	for p, t := range vb {
+		if _, found := va[p]; !found {
+			diffs = append(diffs, Difference{Path: p, B: t})
+		}
+	}
+
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Path < diffs[j].Path })
+
+	return diffs, nil
+}
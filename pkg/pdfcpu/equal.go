@@ -48,6 +48,10 @@ func equalObjects(o1, o2 Object, xRefTable *XRefTable) (ok bool, err error) {
 
 	switch o1.(type) {
 
+	case nil:
+		// Both objects are missing, eg. null or references to free objects.
+		ok = true
+
 	case Name, StringLiteral, HexLiteral,
 		Integer, Float, Boolean:
 		ok = o1 == o2
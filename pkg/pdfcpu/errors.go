@@ -0,0 +1,79 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// Errors callers may check for using errors.Is.
+var (
+	// ErrEncrypted is returned for encrypted files when no password was supplied
+	// and for encrypting files that are already encrypted.
+	ErrEncrypted = errors.New("pdfcpu: file is encrypted")
+
+	// ErrInvalidPassword is returned when the supplied user or owner password does not authenticate.
+	ErrInvalidPassword = errors.New("pdfcpu: invalid password")
+
+	// ErrNotAPDF is returned when the input has no PDF header.
+	ErrNotAPDF = errors.New("pdfcpu: not a PDF file")
+
+	// ErrPageOutOfRange is returned for page numbers not within 1 and the page count.
+	ErrPageOutOfRange = errors.New("pdfcpu: page number out of range")
+
+	// ErrInvalidConfig is returned for invalid or inconsistent configuration settings, see Configuration.Validate.
+	ErrInvalidConfig = errors.New("pdfcpu: invalid configuration")
+
+	// ErrTargetVersion is returned when a file uses features not available in the PDF version to be written.
+	ErrTargetVersion = errors.New("pdfcpu: features not available in target version")
+)
+
+// ValidationError wraps a validation failure together with the object it was detected in.
+// Use errors.As to retrieve it.
+type ValidationError struct {
+	ObjNr, GenNr int
+	Err          error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("obj#%d %d R: %v", e.ObjNr, e.GenNr, e.Err)
+}
+
+// Unwrap returns the underlying validation error.
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+type wrappedError struct {
+	msg string
+	err error
+}
+
+func (e *wrappedError) Error() string {
+	return e.msg
+}
+
+func (e *wrappedError) Unwrap() error {
+	return e.err
+}
+
+// NewError returns an error with a formatted message matching err using errors.Is.
+func NewError(err error, format string, a ...interface{}) error {
+	return &wrappedError{msg: fmt.Sprintf(format, a...), err: err}
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package pdfcpu
 
 import (
+	"os"
 	"strings"
 
 	"github.com/jplu/pdfcpu/pkg/filter"
@@ -24,91 +25,88 @@ import (
 	"github.com/pkg/errors"
 )
 
+// ExtractFilePerm is the permission of files written by extractions: readable by everyone, writable by the owner.
+const ExtractFilePerm os.FileMode = 0644
+
+// MaskObjNrs returns the object numbers of all image objects serving as soft mask or mask of another image.
+func MaskObjNrs(ctx *Context) IntSet {
+
+	m := IntSet{}
+
+	for _, io := range ctx.Optimize.ImageObjects {
+		for _, k := range []string{"SMask", "Mask"} {
+			if ir, ok := io.ImageDict.Dict[k].(IndirectRef); ok {
+				m[ir.ObjectNumber.Value()] = true
+			}
+		}
+	}
+
+	return m
+}
+
 // ExtractImageData extracts image data for objNr.
-// Supported imgTypes: FlateDecode, DCTDecode, JPXDecode
-// TODO: Implementation and usage of these filters: DCTDecode and JPXDecode.
+// The samples of the image get decoded except for DCT, JPX and JBIG2 encoded images,
+// which only get any preceding filters applied. Image masks are extracted as 1 bit gray images.
+// The returned image object is a copy, ctx is not modified.
 // TODO: Should an error be returned instead of nil, nil when filters are not supported?
 func ExtractImageData(ctx *Context, objNr int) (*ImageObject, error) {
 
-	imageObj := ctx.Optimize.ImageObjects[objNr]
-
-	imageDict := imageObj.ImageDict
+	// Work on copies so images may be extracted concurrently.
+	imageObj := *ctx.Optimize.ImageObjects[objNr]
 
-	fpl := imageDict.FilterPipeline
-	if fpl == nil {
-		return nil, nil
-	}
+	sd := *imageObj.ImageDict
+	imageDict := &sd
+	imageObj.ImageDict = imageDict
 
 	var s []string
-	for _, filter := range fpl {
+	for _, filter := range imageDict.FilterPipeline {
 		s = append(s, filter.Name)
 	}
 	filters := strings.Join(s, ",")
 
-	// Ignore filter chains with length > 1
-	if len(fpl) > 1 {
-		log.Info.Printf("extractImageData: ignore obj# %d, more than 1 filter:%s\n", objNr, filters)
-		return nil, nil
-	}
-
-	f := fpl[0].Name
-
-	// We do not extract imageMasks with the exception of CCITTDecoded images
-	if im := imageDict.BooleanEntry("ImageMask"); im != nil && *im {
-		if f != filter.CCITTFax {
-			log.Info.Printf("extractImageData: ignore obj# %d, imageMask\n", objNr)
-			return nil, nil
-		}
-	}
-
-	// Ignore if image has a soft mask defined.
-	// if sm, _ := imageDict.Find("SMask"); sm != nil {
-	// 	log.Info.Printf("extractImageData: ignore obj# %d, unsupported \"SMask\"\n", objNr)
-	// 	return nil, nil
-	// }
+	f := imageFilter(imageDict)
 
 	// Ignore if image has a Mask defined.
 	if sm, _ := imageDict.Find("Mask"); sm != nil {
-		log.Info.Printf("extractImageData: ignore obj# %d, unsupported \"Mask\"\n", objNr)
+		ctx.Warn("extractImage", 0, objNr, "ignoring image with unsupported \"Mask\"")
 		return nil, nil
 	}
 
-	// CCITTDecoded images sometimes don't have a ColorSpace attribute.
-	if f == filter.CCITTFax {
-		_, err := ctx.DereferenceDictEntry(imageDict.Dict, "ColorSpace")
-		if err != nil {
+	// Image masks and CCITTDecoded images sometimes don't have a ColorSpace attribute.
+	im := imageDict.BooleanEntry("ImageMask")
+	isMask := im != nil && *im
+
+	if isMask || f == filter.CCITTFax {
+		if _, err := ctx.DereferenceDictEntry(imageDict.Dict, "ColorSpace"); err != nil {
+			imageDict.Dict = cloneDict(imageDict.Dict)
 			imageDict.InsertName("ColorSpace", DeviceGrayCS)
 		}
+		if imageDict.IntEntry("BitsPerComponent") == nil {
+			imageDict.Dict = cloneDict(imageDict.Dict)
+			imageDict.InsertInt("BitsPerComponent", 1)
+		}
 	}
 
-	switch f {
-
-	case filter.Flate, filter.CCITTFax:
-		// If color space is CMYK then write .tif else write .png
-		err := decodeStream(imageDict)
-		if err != nil {
-			return nil, err
+	if err := decodeImageStream(imageDict); err != nil {
+		if err == filter.ErrUnsupportedFilter {
+			ctx.Warn("extractImage", 0, objNr, "ignoring image with unsupported filter %s", filters)
+			return nil, nil
 		}
-
-	case filter.DCT:
-		//imageObj.Extension = "jpg"
-
-	case filter.JPX:
-		//imageObj.Extension = "jpx"
-
-	default:
-		log.Debug.Printf("extractImageData: ignore obj# %d filter %s unsupported\n", objNr, filters)
-		return nil, nil
+		return nil, err
 	}
 
-	return imageObj, nil
+	return &imageObj, nil
 }
 
 // ExtractFontData extracts font data (the "fontfile") for objNr.
-// Supported fontTypes: TrueType
+// Supported fontTypes: TrueType and fonts embedding a FontFile3.
+// CFF font programs are wrapped into OpenType fonts.
+// The returned font object is a copy, ctx is not modified.
 func ExtractFontData(ctx *Context, objNr int) (*FontObject, error) {
 
-	fontObject := ctx.Optimize.FontObjects[objNr]
+	// Work on a copy so fonts may be extracted concurrently.
+	fo := *ctx.Optimize.FontObjects[objNr]
+	fontObject := &fo
 
 	// Only embedded fonts have binary data.
 	if !fontObject.Embedded() {
@@ -134,40 +132,117 @@ func ExtractFontData(ctx *Context, objNr int) (*FontObject, error) {
 
 	fontType := fontObject.SubType()
 
-	switch fontType {
+	switch {
 
-	case "TrueType":
+	case fontType == "TrueType":
 		// ttf ... true type file
 		// ttc ... true type collection
 		// This is just me guessing..
-		sd, err := ctx.DereferenceStreamDict(*ir)
-		if err != nil {
+		sd, err := fontFileStreamDict(ctx, *ir, objNr, fontObject.FontName)
+		if err != nil || sd == nil {
 			return nil, err
 		}
-		if sd == nil {
-			return nil, errors.Errorf("extractFontData: corrupt font obj#%d for font: %s\n", objNr, fontObject.FontName)
-		}
 
-		// Decode streamDict if used filter is supported only.
-		err = decodeStream(sd)
-		if err == filter.ErrUnsupportedFilter {
-			return nil, nil
-		}
-		if err != nil {
+		fontObject.Data = sd.Content
+		fontObject.Extension = "ttf"
+
+	case d.IndirectRefEntry("FontFile3") != nil:
+		// FontFile3 carries a CFF font program (Type1C, CIDFontType0C) or an OpenType font.
+		sd, err := fontFileStreamDict(ctx, *ir, objNr, fontObject.FontName)
+		if err != nil || sd == nil {
 			return nil, err
 		}
 
-		fontObject.Data = sd.Content
-		fontObject.Extension = "ttf"
+		cffFontData(ctx.XRefTable, fontObject, sd, d)
+
+		if fontObject.Data == nil {
+			ctx.Warn("extractFont", 0, objNr, "ignoring unsupported fontfile type for font: %s", fontObject.FontName)
+			return nil, nil
+		}
 
 	default:
-		log.Info.Printf("extractFontData: ignoring obj#%d - unsupported fonttype %s -  font: %s\n", objNr, fontType, fontObject.FontName)
+		ctx.Warn("extractFont", 0, objNr, "ignoring unsupported fonttype %s for font: %s", fontType, fontObject.FontName)
 		return nil, nil
 	}
 
 	return fontObject, nil
 }
 
+// fontFileStreamDict returns the decoded font file stream dict for ir.
+// A nil stream dict is returned for unsupported filters.
+func fontFileStreamDict(ctx *Context, ir IndirectRef, objNr int, fontName string) (*StreamDict, error) {
+
+	sd, err := ctx.DereferenceStreamDict(ir)
+	if err != nil {
+		return nil, err
+	}
+	if sd == nil {
+		return nil, errors.Errorf("extractFontData: corrupt font obj#%d for font: %s\n", objNr, fontName)
+	}
+
+	// Decode streamDict if used filter is supported only.
+	err = decodeStream(sd)
+	if err == filter.ErrUnsupportedFilter {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return sd, nil
+}
+
+// identityToUnicode returns the ToUnicode CMap of a Type0 font using Identity encoding.
+// Character codes of such fonts are CIDs.
+func identityToUnicode(xRefTable *XRefTable, fontDict Dict) *toUnicodeCMap {
+
+	if enc := fontDict.NameEntry("Encoding"); enc == nil || *enc != "Identity-H" && *enc != "Identity-V" {
+		return nil
+	}
+
+	b, err := streamContent(xRefTable, fontDict["ToUnicode"])
+	if err != nil || b == nil {
+		return nil
+	}
+
+	cm, err := parseToUnicodeCMap(b)
+	if err != nil {
+		log.Info.Printf("identityToUnicode: ignoring corrupt ToUnicode cmap: %v\n", err)
+		return nil
+	}
+
+	return cm
+}
+
+// cffFontData sets the font data for a FontFile3 stream dict.
+// OpenType fonts are extracted as is, CFF font programs get wrapped into an OpenType font.
+// If the CFF font program cannot be parsed the bare CFF is extracted instead.
+func cffFontData(xRefTable *XRefTable, fo *FontObject, sd *StreamDict, fontDescriptor Dict) {
+
+	subType := sd.Subtype()
+	if subType == nil {
+		return
+	}
+
+	switch *subType {
+
+	case "OpenType":
+		fo.Data = sd.Content
+		fo.Extension = "otf"
+
+	case "Type1C", "CIDFontType0C":
+		bb, err := cffToOTF(xRefTable, sd.Content, fontDescriptor, identityToUnicode(xRefTable, fo.FontDict))
+		if err != nil {
+			log.Info.Printf("extractFontData: %v - extracting bare CFF for font: %s\n", err, fo.FontName)
+			fo.Data = sd.Content
+			fo.Extension = "cff"
+			return
+		}
+		fo.Data = bb
+		fo.Extension = "otf"
+	}
+}
+
 // ExtractStreamData extracts the content of a stream dict for a specific objNr.
 func ExtractStreamData(ctx *Context, objNr int) (data []byte, err error) {
 
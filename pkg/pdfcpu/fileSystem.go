@@ -0,0 +1,340 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FileSystem is the file system files get read from and written to.
+// File names get passed through as given to the commands, see Configuration.FS.
+//
+// Reading is based on io/fs, implementing fs.StatFS and fs.ReadFileFS is optional.
+// Files opened for reading should implement io.Seeker, otherwise PDF files get read into memory first.
+type FileSystem interface {
+	fs.FS
+
+	// OpenFile opens the named file for writing using flag, a combination of os.O_* flags,
+	// and perm for files being created.
+	OpenFile(name string, flag int, perm fs.FileMode) (WritableFile, error)
+
+	// Rename renames a file replacing any existing file newName.
+	Rename(oldName, newName string) error
+
+	// Remove removes the named file.
+	Remove(name string) error
+}
+
+// WritableFile is a file opened for writing.
+type WritableFile interface {
+	io.Writer
+	io.Closer
+}
+
+// osFileSystem is the local file system.
+type osFileSystem struct{}
+
+func (osFileSystem) Open(name string) (fs.File, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (osFileSystem) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFileSystem) ReadFile(name string) ([]byte, error) {
+	return ioutil.ReadFile(name)
+}
+
+func (osFileSystem) OpenFile(name string, flag int, perm fs.FileMode) (WritableFile, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (osFileSystem) Rename(oldName, newName string) error {
+	return os.Rename(oldName, newName)
+}
+
+func (osFileSystem) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// OSFileSystem returns the local file system, the default file system.
+func OSFileSystem() FileSystem {
+	return osFileSystem{}
+}
+
+// fileSystem returns the file system for reading files referred to by commands operating on xRefTable.
+func (xRefTable *XRefTable) fileSystem() FileSystem {
+	if xRefTable.fsys == nil {
+		return osFileSystem{}
+	}
+	return xRefTable.fsys
+}
+
+// FileSystem returns the file system configured by c, the local file system by default.
+func (c *Configuration) FileSystem() FileSystem {
+	if c == nil || c.FS == nil {
+		return osFileSystem{}
+	}
+	return c.FS
+}
+
+// openReadSeeker opens the named file of fsys for reading and returns its size.
+func openReadSeeker(fsys FileSystem, name string) (io.ReadSeeker, int64, func() error, error) {
+
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, nil, err
+	}
+
+	if rs, ok := f.(io.ReadSeeker); ok {
+		return rs, fi.Size(), f.Close, nil
+	}
+
+	defer f.Close()
+
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	return bytes.NewReader(b), int64(len(b)), func() error { return nil }, nil
+}
+
+// WriteFile writes data to the named file of fsys.
+func WriteFile(fsys FileSystem, name string, data []byte, perm fs.FileMode) error {
+
+	f, err := fsys.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+
+	return err
+}
+
+// MemFileSystem is a file system held in memory, eg. for serverless environments or testing.
+// File names are used as given and not interpreted as paths.
+// MemFileSystem is safe for concurrent use.
+type MemFileSystem struct {
+	mu    sync.Mutex
+	files map[string]*memFileData
+}
+
+type memFileData struct {
+	data    []byte
+	perm    fs.FileMode
+	modTime time.Time
+}
+
+// NewMemFileSystem returns an empty in-memory file system.
+func NewMemFileSystem() *MemFileSystem {
+	return &MemFileSystem{files: map[string]*memFileData{}}
+}
+
+func memPathError(op, name string, err error) error {
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// Open opens the named file for reading.
+func (m *MemFileSystem) Open(name string) (fs.File, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fd, ok := m.files[name]
+	if !ok {
+		return nil, memPathError("open", name, fs.ErrNotExist)
+	}
+
+	return &memFile{Reader: bytes.NewReader(fd.data), fi: memFileInfo{name: filepath.Base(name), fd: *fd}}, nil
+}
+
+// Stat returns a FileInfo describing the named file.
+func (m *MemFileSystem) Stat(name string) (fs.FileInfo, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fd, ok := m.files[name]
+	if !ok {
+		return nil, memPathError("stat", name, fs.ErrNotExist)
+	}
+
+	return memFileInfo{name: filepath.Base(name), fd: *fd}, nil
+}
+
+// ReadFile returns the content of the named file.
+func (m *MemFileSystem) ReadFile(name string) ([]byte, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fd, ok := m.files[name]
+	if !ok {
+		return nil, memPathError("read", name, fs.ErrNotExist)
+	}
+
+	return append([]byte(nil), fd.data...), nil
+}
+
+// OpenFile opens the named file for writing, the content becomes visible on Close.
+func (m *MemFileSystem) OpenFile(name string, flag int, perm fs.FileMode) (WritableFile, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fd, ok := m.files[name]
+
+	switch {
+	case ok && flag&os.O_CREATE > 0 && flag&os.O_EXCL > 0:
+		return nil, memPathError("open", name, fs.ErrExist)
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, memPathError("open", name, fs.ErrNotExist)
+	case !ok:
+		fd = &memFileData{perm: perm, modTime: time.Now()}
+		m.files[name] = fd
+	}
+
+	w := &memWriter{m: m, name: name}
+	if flag&os.O_TRUNC == 0 {
+		w.buf.Write(fd.data)
+	}
+
+	return w, nil
+}
+
+// Rename renames a file replacing any existing file newName.
+func (m *MemFileSystem) Rename(oldName, newName string) error {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fd, ok := m.files[oldName]
+	if !ok {
+		return memPathError("rename", oldName, fs.ErrNotExist)
+	}
+
+	delete(m.files, oldName)
+	m.files[newName] = fd
+
+	return nil
+}
+
+// Remove removes the named file.
+func (m *MemFileSystem) Remove(name string) error {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.files[name]; !ok {
+		return memPathError("remove", name, fs.ErrNotExist)
+	}
+
+	delete(m.files, name)
+
+	return nil
+}
+
+// Names returns the sorted names of all files.
+func (m *MemFileSystem) Names() []string {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var ss []string
+	for k := range m.files {
+		ss = append(ss, k)
+	}
+	sort.Strings(ss)
+
+	return ss
+}
+
+type memWriter struct {
+	m    *MemFileSystem
+	name string
+	buf  bytes.Buffer
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *memWriter) Close() error {
+
+	w.m.mu.Lock()
+	defer w.m.mu.Unlock()
+
+	fd, ok := w.m.files[w.name]
+	if !ok {
+		// Removed or renamed while writing.
+		fd = &memFileData{perm: 0644}
+		w.m.files[w.name] = fd
+	}
+
+	fd.data = w.buf.Bytes()
+	fd.modTime = time.Now()
+
+	return nil
+}
+
+type memFile struct {
+	*bytes.Reader
+	fi memFileInfo
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.fi, nil }
+func (f *memFile) Close() error               { return nil }
+
+type memFileInfo struct {
+	name string
+	fd   memFileData
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return int64(len(fi.fd.data)) }
+func (fi memFileInfo) Mode() fs.FileMode  { return fi.fd.perm }
+func (fi memFileInfo) ModTime() time.Time { return fi.fd.modTime }
+func (fi memFileInfo) IsDir() bool        { return false }
+func (fi memFileInfo) Sys() interface{}   { return nil }
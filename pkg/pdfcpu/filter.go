@@ -80,6 +80,7 @@ func encodeStream(sd *StreamDict) error {
 	b = bytes.NewReader(sd.Content)
 
 	var c *bytes.Buffer
+	var pooled bool
 
 	// Apply each filter in the pipeline to result of preceding filter.
 	for _, f := range sd.FilterPipeline {
@@ -98,11 +99,21 @@ func encodeStream(sd *StreamDict) error {
 			return err
 		}
 
+		prev, prevPooled := c, pooled
+
 		c, err = fi.Encode(b)
 		if err != nil {
 			return err
 		}
 
+		// Recycle the intermediate result of the preceding filter
+		// unless it got passed through or comes from a custom filter.
+		if prevPooled && prev != c {
+			filter.PutBuffer(prev)
+		}
+
+		pooled = !filter.IsRegistered(f.Name)
+
 		b = c
 	}
 
@@ -144,6 +155,7 @@ func decodeStream(sd *StreamDict) error {
 	//fmt.Printf("decodedStream before:\n%s\n", hex.Dump(sd.Raw))
 
 	var c *bytes.Buffer
+	var pooled bool
 
 	// Apply each filter in the pipeline to result of preceding filter.
 	for _, f := range sd.FilterPipeline {
@@ -162,11 +174,21 @@ func decodeStream(sd *StreamDict) error {
 			return err
 		}
 
+		prev, prevPooled := c, pooled
+
 		c, err = fi.Decode(b)
 		if err != nil {
 			return err
 		}
 
+		// Recycle the intermediate result of the preceding filter
+		// unless it got passed through or comes from a custom filter.
+		if prevPooled && prev != c {
+			filter.PutBuffer(prev)
+		}
+
+		pooled = !filter.IsRegistered(f.Name)
+
 		//fmt.Printf("decodedStream after:%s\n%s\n", f.Name, hex.Dump(c.Bytes()))
 
 		b = c
@@ -0,0 +1,73 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/jplu/pdfcpu/pkg/filter"
+)
+
+// identityFilter passes through the buffer it reads from like an Identity Crypt filter.
+type identityFilter struct{}
+
+func (f identityFilter) pass(r io.Reader) (*bytes.Buffer, error) {
+	if bb, ok := r.(*bytes.Buffer); ok {
+		return bb, nil
+	}
+	var bb bytes.Buffer
+	_, err := bb.ReadFrom(r)
+	return &bb, err
+}
+
+func (f identityFilter) Encode(r io.Reader) (*bytes.Buffer, error) { return f.pass(r) }
+
+func (f identityFilter) Decode(r io.Reader) (*bytes.Buffer, error) { return f.pass(r) }
+
+func TestPassThroughFilter(t *testing.T) {
+
+	filterName := "IdentityPassThrough"
+
+	filter.Register(filterName, func(parms map[string]int) (filter.Filter, error) {
+		return identityFilter{}, nil
+	})
+	defer filter.Unregister(filterName)
+
+	content := bytes.Repeat([]byte("BT /F1 12 Tf (Hello) Tj ET\n"), 100)
+	fpl := []PDFFilter{{Name: filter.Flate}, {Name: filterName}}
+
+	sd := NewStreamDict(NewDict(), 0, nil, nil, fpl)
+	sd.Content = content
+
+	if err := encodeStream(&sd); err != nil {
+		t.Fatalf("encodeStream: %v\n", err)
+	}
+	if len(sd.Raw) == 0 {
+		t.Fatal("encodeStream: result of pass-through filter got recycled\n")
+	}
+
+	sd.Content = nil
+
+	if err := decodeStream(&sd); err != nil {
+		t.Fatalf("decodeStream: %v\n", err)
+	}
+	if !bytes.Equal(sd.Content, content) {
+		t.Fatal("decodeStream: result of pass-through filter got recycled\n")
+	}
+}
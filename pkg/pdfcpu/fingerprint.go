@@ -0,0 +1,272 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// Producer or Creator entries of uncompressed document information dicts of any revision.
+	infoEntryRegexp = regexp.MustCompile(`/(Producer|Creator)\s*(\((?:\\.|[^\\)])*\)|<[0-9A-Fa-f\s]*>)`)
+
+	// Object headers of any revision.
+	objHeaderRegexp = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+
+	// Producer or CreatorTool entries of XMP metadata, as element or as attribute.
+	xmpEntryRegexp = regexp.MustCompile(`(pdf:Producer|xmp:CreatorTool)(?:>([^<]*)<|="([^"]*)")`)
+
+	// Well-known traces left behind by specific generators.
+	generatorArtifacts = []struct {
+		marker, tool string
+	}{
+		{"/PTEX.Fullbanner", "pdfTeX"},
+		{"/AAPL:Keywords", "Apple Quartz PDFContext"},
+		{"/Illustrator", "Adobe Illustrator"},
+		{"/Photoshop", "Adobe Photoshop"},
+		{"/InDesign", "Adobe InDesign"},
+		{"/ADBE_FillSign", "Adobe Fill & Sign"},
+		{"/GTS_PDFX", "PDF/X producing prepress software"},
+	}
+)
+
+// appendIfNew appends s to ss unless it equals the last element.
+func appendIfNew(ss []string, s string) []string {
+
+	if len(s) == 0 || len(ss) > 0 && ss[len(ss)-1] == s {
+		return ss
+	}
+
+	return append(ss, s)
+}
+
+// objectHeaders returns the offsets of all object headers of raw.
+func objectHeaders(raw []byte) [][]int {
+	return objHeaderRegexp.FindAllSubmatchIndex(raw, -1)
+}
+
+// enclosingObject returns the object number and generation of the object starting last before offset.
+func enclosingObject(raw []byte, headers [][]int, offset int) (int, int, bool) {
+
+	i := sort.Search(len(headers), func(i int) bool { return headers[i][0] >= offset })
+	if i == 0 {
+		return 0, 0, false
+	}
+
+	h := headers[i-1]
+
+	objNr, err := strconv.Atoi(string(raw[h[2]:h[3]]))
+	if err != nil {
+		return 0, 0, false
+	}
+
+	genNr, err := strconv.Atoi(string(raw[h[4]:h[5]]))
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return objNr, genNr, true
+}
+
+// infoEntryValue returns the text of the string s found in object objNr of the file ctx has been read from.
+// Strings of encrypted files are decrypted first.
+func infoEntryValue(ctx *Context, s string, objNr, genNr int) (string, error) {
+
+	var (
+		b   []byte
+		err error
+	)
+
+	if strings.HasPrefix(s, "<") {
+		b, err = hex.DecodeString(strings.Join(strings.Fields(s[1:len(s)-1]), ""))
+	} else {
+		b, err = Unescape(s[1 : len(s)-1])
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if ctx.stringsEncrypted() {
+		hl, err := decryptHexLiteral(ctx.AES4Strings, HexLiteral(hex.EncodeToString(b)), objNr, genNr, ctx.EncKey)
+		if err != nil {
+			return "", err
+		}
+		if b, err = hl.Bytes(); err != nil {
+			return "", err
+		}
+	}
+
+	return decodeText(b)
+}
+
+// currentInfoEntry returns the text of an entry of the document information dict in effect.
+func currentInfoEntry(ctx *Context, key string) (string, error) {
+
+	if ctx.Info == nil {
+		return "", nil
+	}
+
+	d, err := ctx.DereferenceDict(*ctx.Info)
+	if err != nil || d == nil {
+		return "", err
+	}
+
+	o, found := d.Find(key)
+	if !found {
+		return "", nil
+	}
+
+	return ctx.DereferenceText(o)
+}
+
+// xmpEntries returns Producer and CreatorTool entries of the XMP metadata of the catalog.
+func xmpEntries(ctx *Context) ([]string, error) {
+
+	rootDict, err := ctx.Catalog()
+	if err != nil {
+		return nil, err
+	}
+
+	sd, err := ctx.DereferenceStreamDict(rootDict["Metadata"])
+	if err != nil || sd == nil {
+		return nil, err
+	}
+
+	// Metadata we cannot decode does not tell us anything.
+	if err = decodeStream(sd); err != nil {
+		ctx.Warn("fingerprint", 0, 0, "skipping metadata: %v", err)
+		return nil, nil
+	}
+
+	var ss []string
+
+	for _, m := range xmpEntryRegexp.FindAllStringSubmatch(string(sd.Content), -1) {
+		ss = append(ss, fmt.Sprintf("XMP %s: %s", m[1], m[2]+m[3]))
+	}
+
+	return ss, nil
+}
+
+// ToolFingerprints reports traces of the software that produced or modified a document.
+// r supplies the raw bytes of the file ctx has been read from.
+// Producer and Creator history is listed oldest first,
+// entries living in compressed object streams of earlier revisions are not detected.
+// Entries of encrypted files are decrypted with the key of the revision in effect.
+func ToolFingerprints(ctx *Context, r io.Reader) ([]string, error) {
+
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := bytes.Count(raw, []byte("%%EOF"))
+	if ctx.Read.Linearized && revisions > 1 {
+		revisions--
+	}
+
+	list := []string{fmt.Sprintf("revisions: %d", revisions)}
+
+	var producers, creators []string
+
+	// Strings of encrypted files are encrypted using the number of the object they live in.
+	var headers [][]int
+	if ctx.stringsEncrypted() {
+		headers = objectHeaders(raw)
+	}
+
+	for _, m := range infoEntryRegexp.FindAllSubmatchIndex(raw, -1) {
+
+		var objNr, genNr int
+
+		if ctx.stringsEncrypted() {
+			var ok bool
+			if objNr, genNr, ok = enclosingObject(raw, headers, m[0]); !ok {
+				continue
+			}
+		}
+
+		s, err := infoEntryValue(ctx, string(raw[m[4]:m[5]]), objNr, genNr)
+		if err != nil {
+			continue
+		}
+
+		if string(raw[m[2]:m[3]]) == "Producer" {
+			producers = appendIfNew(producers, s)
+		} else {
+			creators = appendIfNew(creators, s)
+		}
+	}
+
+	// The information dict in effect may live in an object stream.
+	for _, e := range []struct {
+		key string
+		ss  *[]string
+	}{
+		{"Producer", &producers},
+		{"Creator", &creators},
+	} {
+		s, err := currentInfoEntry(ctx, e.key)
+		if err != nil {
+			return nil, err
+		}
+		*e.ss = appendIfNew(*e.ss, s)
+	}
+
+	for _, s := range producers {
+		list = append(list, "Producer: "+s)
+	}
+
+	for _, s := range creators {
+		list = append(list, "Creator: "+s)
+	}
+
+	ss, err := xmpEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list = append(list, ss...)
+
+	for _, f := range []struct {
+		used bool
+		s    string
+	}{
+		{ctx.Read.UsingXRefStreams, "xref streams"},
+		{ctx.Read.UsingObjectStreams, "object streams"},
+		{ctx.Read.Linearized, "linearized"},
+		{ctx.Read.Hybrid, "hybrid xref"},
+	} {
+		if f.used {
+			list = append(list, "feature: "+f.s)
+		}
+	}
+
+	for _, a := range generatorArtifacts {
+		if bytes.Contains(raw, []byte(a.marker)) {
+			list = append(list, fmt.Sprintf("artifact %s: %s", a.marker, a.tool))
+		}
+	}
+
+	return list, nil
+}
@@ -0,0 +1,413 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/jplu/pdfcpu/pkg/log"
+	"github.com/pkg/errors"
+)
+
+// Annotation flags, see Table 165.
+const (
+	AnnInvisible = 1 << iota
+	AnnHidden
+	AnnPrint
+	AnnNoZoom
+	AnnNoRotate
+	AnnNoView
+)
+
+// annotationAppearance returns the normal appearance of the annotation d in effect or nil if there is none.
+func annotationAppearance(xRefTable *XRefTable, d Dict) (*IndirectRef, error) {
+
+	ap, err := xRefTable.DereferenceDict(d["AP"])
+	if err != nil || ap == nil {
+		return nil, err
+	}
+
+	o, err := xRefTable.Dereference(ap["N"])
+	if err != nil || o == nil {
+		return nil, err
+	}
+
+	if sd, ok := o.(StreamDict); ok {
+		if ir := ap.IndirectRefEntry("N"); ir != nil {
+			return ir, nil
+		}
+		// Form XObjects need to be indirect objects.
+		return xRefTable.IndRefForNewObject(sd)
+	}
+
+	states, ok := o.(Dict)
+	if !ok {
+		return nil, nil
+	}
+
+	as := d.NameEntry("AS")
+	if as == nil {
+		return nil, nil
+	}
+
+	if ir := states.IndirectRefEntry(*as); ir != nil {
+		return ir, nil
+	}
+
+	if sd, ok := states[*as].(StreamDict); ok {
+		return xRefTable.IndRefForNewObject(sd)
+	}
+
+	return nil, nil
+}
+
+// SortedSelectedPages returns the page numbers of selectedPages in ascending order.
+func SortedSelectedPages(selectedPages IntSet) []int {
+
+	var pages []int
+	for pageNr, v := range selectedPages {
+		if v {
+			pages = append(pages, pageNr)
+		}
+	}
+	sort.Ints(pages)
+
+	return pages
+}
+
+// appearanceMatrix returns the matrix mapping the bounding box of the appearance stream sd onto the annotation rectangle.
+// See 12.5.5 Appearance Streams, Algorithm: Appearance streams
+func appearanceMatrix(xRefTable *XRefTable, sd *StreamDict, r Array) ([6]float64, error) {
+
+	m := [6]float64{1, 0, 0, 1, 0, 0}
+
+	bbox := sd.ArrayEntry("BBox")
+	if len(bbox) != 4 || len(r) != 4 {
+		return m, errors.New("corrupt appearance bounding box or annotation rectangle")
+	}
+
+	fm := [6]float64{1, 0, 0, 1, 0, 0}
+	if a := sd.ArrayEntry("Matrix"); len(a) == 6 {
+		for i, o := range a {
+			fm[i] = xRefTable.DereferenceNumber(o)
+		}
+	}
+
+	// The transformed appearance box.
+	x0, y0, x1, y1 := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, c := range [][2]int{{0, 1}, {0, 3}, {2, 1}, {2, 3}} {
+		x, y := xRefTable.DereferenceNumber(bbox[c[0]]), xRefTable.DereferenceNumber(bbox[c[1]])
+		tx, ty := fm[0]*x+fm[2]*y+fm[4], fm[1]*x+fm[3]*y+fm[5]
+		x0, y0, x1, y1 = math.Min(x0, tx), math.Min(y0, ty), math.Max(x1, tx), math.Max(y1, ty)
+	}
+
+	rect := rect(xRefTable, r)
+	if x1 == x0 || y1 == y0 {
+		return m, errors.New("empty appearance bounding box")
+	}
+
+	sx, sy := rect.Width()/(x1-x0), rect.Height()/(y1-y0)
+
+	m[0], m[3] = sx, sy
+	m[4], m[5] = rect.LL.X-x0*sx, rect.LL.Y-y0*sy
+
+	return m, nil
+}
+
+// newXObjectName returns a name not yet used in the XObject resources d.
+func newXObjectName(d Dict) string {
+	for i := 0; ; i++ {
+		s := "Fl" + strconv.Itoa(i)
+		if _, found := d.Find(s); !found {
+			return s
+		}
+	}
+}
+
+// pageXObjects returns the XObject resources of a page and creates them if missing.
+func pageXObjects(xRefTable *XRefTable, pageDict Dict, inhPAttrs *InheritedPageAttrs) (Dict, error) {
+
+	res := inhPAttrs.resources
+	if res == nil {
+		res = NewDict()
+		pageDict.Insert("Resources", res)
+	}
+
+	d, err := xRefTable.DereferenceDict(res["XObject"])
+	if err != nil {
+		return nil, err
+	}
+
+	if d == nil {
+		d = NewDict()
+		res.Insert("XObject", d)
+	}
+
+	return d, nil
+}
+
+// appendPageContent wraps the page content into q/Q and appends content.
+func appendPageContent(xRefTable *XRefTable, pageDict Dict, content []byte) error {
+
+	o, err := xRefTable.Dereference(pageDict["Contents"])
+	if err != nil {
+		return err
+	}
+
+	var a Array
+
+	switch o := o.(type) {
+	case StreamDict:
+		a = Array{pageDict["Contents"]}
+	case Array:
+		a = append(a, o...)
+	}
+
+	var irs []Object
+	for _, b := range [][]byte{[]byte("q"), append([]byte("Q "), content...)} {
+		sd := &StreamDict{Dict: NewDict(), Content: b}
+		if err = encodeStream(sd); err != nil {
+			return err
+		}
+		ir, err := xRefTable.IndRefForNewObject(*sd)
+		if err != nil {
+			return err
+		}
+		irs = append(irs, *ir)
+	}
+
+	a = append(Array{irs[0]}, a...)
+	pageDict.Update("Contents", append(a, irs[1]))
+
+	return nil
+}
+
+// removeFields removes the fields or widgets objNrs from the field tree fields.
+func removeFields(xRefTable *XRefTable, fields Array, objNrs IntSet) (Array, error) {
+
+	a := Array{}
+
+	for _, o := range fields {
+
+		if ir, ok := o.(IndirectRef); ok && objNrs[ir.ObjectNumber.Value()] {
+			continue
+		}
+
+		d, err := xRefTable.DereferenceDict(o)
+		if err != nil {
+			return nil, err
+		}
+
+		if d == nil {
+			continue
+		}
+
+		if kids := d.ArrayEntry("Kids"); kids != nil {
+			if kids, err = removeFields(xRefTable, kids, objNrs); err != nil {
+				return nil, err
+			}
+			if len(kids) == 0 {
+				// All widgets of this field have been flattened.
+				continue
+			}
+			d.Update("Kids", kids)
+		}
+
+		a = append(a, o)
+	}
+
+	return a, nil
+}
+
+func flattenPageAnnotations(xRefTable *XRefTable, pageNr int, subtypes StringSet, widgets IntSet) (int, error) {
+
+	pageDict, inhPAttrs, err := xRefTable.PageDict(pageNr)
+	if err != nil {
+		return 0, err
+	}
+	if pageDict == nil {
+		return 0, errors.Errorf("missing page %d", pageNr)
+	}
+
+	annots, err := xRefTable.DereferenceArray(pageDict["Annots"])
+	if err != nil || len(annots) == 0 {
+		return 0, err
+	}
+
+	var b bytes.Buffer
+	var xObjects Dict
+	removed := IntSet{}
+	n := 0
+
+	for _, o := range annots {
+
+		ir, ok := o.(IndirectRef)
+		if !ok {
+			continue
+		}
+
+		d, err := xRefTable.DereferenceDict(ir)
+		if err != nil {
+			return 0, err
+		}
+
+		if d == nil {
+			continue
+		}
+
+		st := d.Subtype()
+		if st == nil || *st == "Popup" || (len(subtypes) > 0 && !subtypes[*st]) {
+			continue
+		}
+
+		f := 0
+		if i := d.IntEntry("F"); i != nil {
+			f = *i
+		}
+
+		if f&(AnnHidden|AnnNoView) == 0 {
+
+			ap, err := annotationAppearance(xRefTable, d)
+			if err != nil {
+				return 0, err
+			}
+
+			if ap == nil {
+				xRefTable.Warn("flatten", pageNr, 0, "skipping %s annotation without appearance", *st)
+				continue
+			}
+
+			sd, err := xRefTable.DereferenceStreamDict(*ap)
+			if err != nil {
+				return 0, err
+			}
+
+			m, err := appearanceMatrix(xRefTable, sd, d.ArrayEntry("Rect"))
+			if err != nil {
+				return 0, errors.Wrapf(err, "page %d", pageNr)
+			}
+
+			if xObjects == nil {
+				if xObjects, err = pageXObjects(xRefTable, pageDict, inhPAttrs); err != nil {
+					return 0, err
+				}
+			}
+
+			name := newXObjectName(xObjects)
+			xObjects.Insert(name, *ap)
+
+			fmt.Fprintf(&b, "q %.4f %.4f %.4f %.4f %.4f %.4f cm /%s Do Q ", m[0], m[1], m[2], m[3], m[4], m[5], name)
+		}
+
+		removed[ir.ObjectNumber.Value()] = true
+		if popup := d.IndirectRefEntry("Popup"); popup != nil {
+			removed[popup.ObjectNumber.Value()] = true
+		}
+
+		if *st == "Widget" {
+			widgets[ir.ObjectNumber.Value()] = true
+		}
+
+		n++
+	}
+
+	if n == 0 {
+		return 0, nil
+	}
+
+	a := Array{}
+	for _, o := range annots {
+		if ir, ok := o.(IndirectRef); ok && removed[ir.ObjectNumber.Value()] {
+			continue
+		}
+		a = append(a, o)
+	}
+
+	if len(a) == 0 {
+		pageDict.Delete("Annots")
+	} else {
+		pageDict.Update("Annots", a)
+	}
+
+	if b.Len() == 0 {
+		return n, nil
+	}
+
+	return n, appendPageContent(xRefTable, pageDict, b.Bytes())
+}
+
+// FlattenAnnotations renders the normal appearances of the annotations of the selected pages
+// into the page content and removes the annotations along with their popups.
+// If subtypes is not empty only annotations of these subtypes get flattened, eg. Highlight and Stamp keeping Link.
+// Hidden annotations get removed without being rendered, annotations lacking an appearance are kept.
+// Flattened widget annotations get removed from the interactive form. Returns the number of annotations flattened.
+func FlattenAnnotations(xRefTable *XRefTable, selectedPages IntSet, subtypes []string) (int, error) {
+
+	st := StringSet{}
+	for _, s := range subtypes {
+		st[s] = true
+	}
+
+	widgets := IntSet{}
+	n := 0
+
+	for _, pageNr := range SortedSelectedPages(selectedPages) {
+		i, err := flattenPageAnnotations(xRefTable, pageNr, st, widgets)
+		if err != nil {
+			return 0, errors.Wrap(err, "FlattenAnnotations")
+		}
+		n += i
+	}
+
+	log.Debug.Printf("FlattenAnnotations: flattened %d annotations\n", n)
+
+	if len(widgets) == 0 {
+		return n, nil
+	}
+
+	rootDict, err := xRefTable.Catalog()
+	if err != nil {
+		return 0, err
+	}
+
+	acroForm, err := xRefTable.DereferenceDict(rootDict["AcroForm"])
+	if err != nil || acroForm == nil {
+		return n, err
+	}
+
+	fields, err := xRefTable.DereferenceArray(acroForm["Fields"])
+	if err != nil {
+		return 0, err
+	}
+
+	if fields, err = removeFields(xRefTable, fields, widgets); err != nil {
+		return 0, err
+	}
+
+	if len(fields) == 0 {
+		rootDict.Delete("AcroForm")
+		return n, nil
+	}
+
+	acroForm.Update("Fields", fields)
+
+	return n, nil
+}
@@ -0,0 +1,676 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/jplu/pdfcpu/pkg/fonts/metrics"
+	"github.com/jplu/pdfcpu/pkg/log"
+	"github.com/jplu/pdfcpu/pkg/types"
+	"github.com/pkg/errors"
+)
+
+// Field flags common to all field types, see Table 221.
+const (
+	FieldReadOnly = 1 << iota
+	FieldRequired
+	FieldNoExport
+)
+
+// fieldPartialName returns the partial field name "T" of d.
+func fieldPartialName(xRefTable *XRefTable, d Dict) (string, bool, error) {
+
+	o, err := xRefTable.Dereference(d["T"])
+	if err != nil || o == nil {
+		return "", false, err
+	}
+
+	var s string
+
+	switch o := o.(type) {
+	case StringLiteral:
+		s, err = StringLiteralToString(o.Value())
+	case HexLiteral:
+		s, err = HexLiteralToString(o.Value())
+	default:
+		return "", false, errors.Errorf("fieldPartialName: corrupt entry \"T\": %v", o)
+	}
+
+	return s, err == nil, err
+}
+
+// walkFields calls fn for every field of the field tree fields in depth first order
+// passing its fully qualified field name and its indirect reference if any.
+// Widget annotations merely hanging off a field are skipped.
+// If fn returns false the kids of a field are skipped.
+func walkFields(xRefTable *XRefTable, fields Array, parentName string, visited IntSet, fn func(name string, ir *IndirectRef, d Dict) (bool, error)) error {
+
+	for _, o := range fields {
+
+		var ir *IndirectRef
+		if indRef, ok := o.(IndirectRef); ok {
+			if visited[indRef.ObjectNumber.Value()] {
+				continue
+			}
+			visited[indRef.ObjectNumber.Value()] = true
+			ir = &indRef
+		}
+
+		d, err := xRefTable.DereferenceDict(o)
+		if err != nil {
+			return err
+		}
+		if d == nil {
+			continue
+		}
+
+		s, ok, err := fieldPartialName(xRefTable, d)
+		if err != nil {
+			return err
+		}
+
+		name := parentName
+		if ok {
+			if len(name) > 0 {
+				name += "."
+			}
+			name += s
+		} else if _, found := d.Find("FT"); !found && d.ArrayEntry("Kids") == nil {
+			// A widget annotation.
+			continue
+		}
+
+		descend, err := fn(name, ir, d)
+		if err != nil {
+			return err
+		}
+		if !descend {
+			continue
+		}
+
+		if err = walkFields(xRefTable, d.ArrayEntry("Kids"), name, visited, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// formFields returns the root fields of the interactive form or nil if there is none.
+func formFields(xRefTable *XRefTable) (Array, error) {
+
+	rootDict, err := xRefTable.Catalog()
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := xRefTable.DereferenceDict(rootDict["AcroForm"])
+	if err != nil || d == nil {
+		return nil, err
+	}
+
+	return xRefTable.DereferenceArray(d["Fields"])
+}
+
+// FormFieldNames returns the fully qualified names of all fields of the interactive form.
+func FormFieldNames(xRefTable *XRefTable) ([]string, error) {
+
+	fields, err := formFields(xRefTable)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+
+	err = walkFields(xRefTable, fields, "", IntSet{}, func(name string, ir *IndirectRef, d Dict) (bool, error) {
+		names = append(names, name)
+		return true, nil
+	})
+
+	return names, err
+}
+
+// fieldIndRefs returns the indirect references of all fields of the interactive form by fully qualified name.
+func fieldIndRefs(xRefTable *XRefTable) (map[string]IndirectRef, error) {
+
+	fields, err := formFields(xRefTable)
+	if err != nil {
+		return nil, err
+	}
+
+	m := map[string]IndirectRef{}
+
+	err = walkFields(xRefTable, fields, "", IntSet{}, func(name string, ir *IndirectRef, d Dict) (bool, error) {
+		if ir != nil {
+			m[name] = *ir
+		}
+		return true, nil
+	})
+
+	return m, err
+}
+
+// formField returns the interactive form along with the field named by its fully qualified name.
+func formField(xRefTable *XRefTable, name string) (Dict, Dict, error) {
+
+	rootDict, err := xRefTable.Catalog()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	acroForm, err := xRefTable.DereferenceDict(rootDict["AcroForm"])
+	if err != nil {
+		return nil, nil, err
+	}
+	if acroForm == nil {
+		return nil, nil, errors.New("missing interactive form")
+	}
+
+	fields, err := xRefTable.DereferenceArray(acroForm["Fields"])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var field Dict
+
+	err = walkFields(xRefTable, fields, "", IntSet{}, func(s string, ir *IndirectRef, d Dict) (bool, error) {
+		if s == name {
+			field = d
+		}
+		return field == nil, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if field == nil {
+		return nil, nil, errors.Errorf("unknown field %s", name)
+	}
+
+	return acroForm, field, nil
+}
+
+// fieldSelected returns true if the field name or one of its ancestors is in names.
+func fieldSelected(name string, names StringSet) bool {
+
+	for {
+		if names[name] {
+			return true
+		}
+		i := strings.LastIndex(name, ".")
+		if i < 0 {
+			return false
+		}
+		name = name[:i]
+	}
+}
+
+// removeFieldActions deletes all actions of the field d and its widget annotations.
+func removeFieldActions(xRefTable *XRefTable, d Dict) error {
+
+	d.Delete("A")
+	d.Delete("AA")
+
+	for _, o := range d.ArrayEntry("Kids") {
+		kid, err := xRefTable.DereferenceDict(o)
+		if err != nil {
+			return err
+		}
+		if kid != nil && kid["T"] == nil {
+			kid.Delete("A")
+			kid.Delete("AA")
+		}
+	}
+
+	return nil
+}
+
+// LockFormFields makes the fields named by their fully qualified names read-only.
+// Locking a field locks all its descendants. If names is empty all fields get locked.
+// If removeActions is true any actions triggered by the locked fields are removed too.
+// Unlike flattening field values remain accessible. Returns the number of fields locked.
+func LockFormFields(xRefTable *XRefTable, names []string, removeActions bool) (int, error) {
+
+	fields, err := formFields(xRefTable)
+	if err != nil {
+		return 0, err
+	}
+
+	selected, found := StringSet{}, StringSet{}
+	for _, s := range names {
+		selected[s] = true
+	}
+
+	n := 0
+
+	err = walkFields(xRefTable, fields, "", IntSet{}, func(name string, ir *IndirectRef, d Dict) (bool, error) {
+
+		if len(names) > 0 {
+			found[name] = true
+			if !fieldSelected(name, selected) {
+				return true, nil
+			}
+		}
+
+		// Ff is inheritable, so locking the root of a field hierarchy would be sufficient.
+		// Descendant fields may define their own flags though, so keep the flags in effect.
+		ff, err := fieldInheritedInt(xRefTable, nil, d, "Ff")
+		if err != nil {
+			return false, err
+		}
+		d.Update("Ff", Integer(ff|FieldReadOnly))
+
+		if removeActions {
+			if err := removeFieldActions(xRefTable, d); err != nil {
+				return false, err
+			}
+		}
+
+		log.Debug.Printf("LockFormFields: locked %s\n", name)
+		n++
+
+		return true, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, s := range names {
+		if !found[s] {
+			return 0, errors.Errorf("LockFormFields: unknown field %s", s)
+		}
+	}
+
+	return n, nil
+}
+
+// FormFieldType enumerates the types of fields AddFormFields is able to create.
+type FormFieldType int
+
+// The field types supported by AddFormFields.
+const (
+	FormFieldText FormFieldType = iota
+	FormFieldCheckBox
+	FormFieldSignature
+)
+
+func (t FormFieldType) String() string {
+	switch t {
+	case FormFieldText:
+		return "text"
+	case FormFieldCheckBox:
+		return "checkbox"
+	case FormFieldSignature:
+		return "signature"
+	}
+	return "unknown"
+}
+
+// FormField describes a form field to be created along with its widget annotation.
+type FormField struct {
+	Type     FormFieldType
+	Name     string          // Partial field name, needs to be unique among the top level fields.
+	Page     int             // Page the widget annotation is placed on.
+	Rect     types.Rectangle // Widget annotation rectangle in default user space units.
+	Default  string          // Text fields: the default value, signature fields: the placeholder label.
+	Checked  bool            // Check boxes: the default state.
+	FontName string          // Text fields: one of the Adobe base fonts Helvetica, Times-Roman, Courier. Defaults to Helvetica.
+	FontSize int             // Text fields: 0 means auto size.
+}
+
+func (ff FormField) String() string {
+	return fmt.Sprintf("%s %s page:%d rect:%s", ff.Type, ff.Name, ff.Page, ff.Rect)
+}
+
+func (ff FormField) fontName() string {
+	if ff.FontName == "" {
+		return "Helvetica"
+	}
+	return ff.FontName
+}
+
+// fontSize returns the font size used for the appearance of a text field.
+func (ff FormField) fontSize() int {
+
+	if ff.FontSize > 0 {
+		return ff.FontSize
+	}
+
+	size := int(ff.Rect.Height() * .6)
+	if size > 12 {
+		size = 12
+	}
+
+	if len(ff.Default) > 0 {
+		if max := metrics.FontSize(ff.Default, ff.fontName(), ff.Rect.Width()-4); max < size {
+			size = max
+		}
+	}
+
+	if size < 1 {
+		size = 1
+	}
+
+	return size
+}
+
+// formFontIndRef returns the font named fontName of the default resources of the interactive form
+// and adds a font dict for this Adobe base font if missing.
+func formFontIndRef(xRefTable *XRefTable, acroForm Dict, fontName string) (*IndirectRef, error) {
+
+	dr, err := xRefTable.DereferenceDict(acroForm["DR"])
+	if err != nil {
+		return nil, err
+	}
+	if dr == nil {
+		dr = NewDict()
+		acroForm.Insert("DR", dr)
+	}
+
+	fonts, err := xRefTable.DereferenceDict(dr["Font"])
+	if err != nil {
+		return nil, err
+	}
+	if fonts == nil {
+		fonts = NewDict()
+		dr.Insert("Font", fonts)
+	}
+
+	if ir := fonts.IndirectRefEntry(fontName); ir != nil {
+		return ir, nil
+	}
+
+	d := NewDict()
+	d.InsertName("Type", "Font")
+	d.InsertName("Subtype", "Type1")
+	d.InsertName("BaseFont", fontName)
+	if fontName != "Symbol" && fontName != "ZapfDingbats" {
+		d.InsertName("Encoding", "WinAnsiEncoding")
+	}
+
+	ir, err := xRefTable.IndRefForNewObject(d)
+	if err != nil {
+		return nil, err
+	}
+
+	fonts.Update(fontName, *ir)
+
+	return ir, nil
+}
+
+// formXObject returns a new form XObject of the size of r using content and a single font resource.
+func formXObject(xRefTable *XRefTable, r types.Rectangle, content []byte, fontName string, font *IndirectRef) (*IndirectRef, error) {
+
+	d := Dict(
+		map[string]Object{
+			"Type":     Name("XObject"),
+			"Subtype":  Name("Form"),
+			"FormType": Integer(1),
+			"BBox":     NewRectangle(0, 0, r.Width(), r.Height()),
+			"Matrix":   NewIntegerArray(1, 0, 0, 1, 0, 0),
+		},
+	)
+
+	if font != nil {
+		d.Insert("Resources", Dict(map[string]Object{"Font": Dict(map[string]Object{fontName: *font})}))
+	}
+
+	sd := &StreamDict{Dict: d, Content: content}
+
+	if err := encodeStream(sd); err != nil {
+		return nil, err
+	}
+
+	return xRefTable.IndRefForNewObject(*sd)
+}
+
+// textFieldAppearance renders s into a text field widget of size r.
+func textFieldAppearance(xRefTable *XRefTable, r types.Rectangle, s, fontName string, fontSize int, font *IndirectRef) (*IndirectRef, error) {
+
+	var b bytes.Buffer
+	b.WriteString("/Tx BMC ")
+
+	if len(s) > 0 {
+		es, err := Escape(s)
+		if err != nil {
+			return nil, err
+		}
+		w, h := r.Width(), r.Height()
+		y := (h-float64(fontSize))/2 + .25*float64(fontSize)
+		fmt.Fprintf(&b, "q 1 1 %.2f %.2f re W n BT /%s %d Tf 0 g 2 %.2f Td (%s) Tj ET Q ", w-2, h-2, fontName, fontSize, y, *es)
+	}
+
+	b.WriteString("EMC")
+
+	return formXObject(xRefTable, r, b.Bytes(), fontName, font)
+}
+
+func textFieldDict(xRefTable *XRefTable, acroForm Dict, ff FormField) (Dict, error) {
+
+	fontName := ff.fontName()
+	if !supportedWatermarkFont(fontName) {
+		return nil, errors.Errorf("form field %s: %s is unsupported, try one of Helvetica, Times-Roman, Courier", ff.Name, fontName)
+	}
+
+	font, err := formFontIndRef(xRefTable, acroForm, fontName)
+	if err != nil {
+		return nil, err
+	}
+
+	ap, err := textFieldAppearance(xRefTable, ff.Rect, ff.Default, fontName, ff.fontSize(), font)
+	if err != nil {
+		return nil, err
+	}
+
+	d := Dict(
+		map[string]Object{
+			"FT": Name("Tx"),
+			"DA": StringLiteral(fmt.Sprintf("/%s %d Tf 0 g", fontName, ff.FontSize)),
+			"AP": Dict(map[string]Object{"N": *ap}),
+		},
+	)
+
+	if len(ff.Default) > 0 {
+		s, err := textString(ff.Default)
+		if err != nil {
+			return nil, err
+		}
+		d.Insert("V", s)
+		d.Insert("DV", s)
+	}
+
+	return d, nil
+}
+
+// checkBoxAppearances returns the normal appearances for the on state "Yes" and the off state of a check box of size r.
+func checkBoxAppearances(xRefTable *XRefTable, r types.Rectangle, font *IndirectRef) (Dict, error) {
+
+	w, h := r.Width(), r.Height()
+
+	size := w
+	if h < size {
+		size = h
+	}
+	size *= .8
+
+	// The check mark ZapfDingbats a20 is 760 glyph space units wide.
+	x, y := (w-.76*size)/2, (h-.7*size)/2
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "/Tx BMC q BT 0 g /ZapfDingbats %.2f Tf %.2f %.2f Td (4) Tj ET Q EMC", size, x, y)
+
+	on, err := formXObject(xRefTable, r, b.Bytes(), "ZapfDingbats", font)
+	if err != nil {
+		return nil, err
+	}
+
+	off, err := formXObject(xRefTable, r, []byte("/Tx BMC EMC"), "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return Dict(map[string]Object{"Yes": *on, "Off": *off}), nil
+}
+
+func checkBoxFieldDict(xRefTable *XRefTable, acroForm Dict, ff FormField) (Dict, error) {
+
+	font, err := formFontIndRef(xRefTable, acroForm, "ZapfDingbats")
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := checkBoxAppearances(xRefTable, ff.Rect, font)
+	if err != nil {
+		return nil, err
+	}
+
+	v := Name("Off")
+	if ff.Checked {
+		v = Name("Yes")
+	}
+
+	return Dict(
+		map[string]Object{
+			"FT": Name("Btn"),
+			"V":  v,
+			"DV": v,
+			"AS": v,
+			"DA": StringLiteral("/ZapfDingbats 0 Tf 0 g"),
+			"MK": Dict(map[string]Object{"CA": StringLiteral("4")}),
+			"AP": Dict(map[string]Object{"N": n}),
+		},
+	), nil
+}
+
+func addFormField(xRefTable *XRefTable, acroForm Dict, ff FormField) (*IndirectRef, error) {
+
+	if ff.Type == FormFieldSignature {
+		return addSignatureField(xRefTable, SignatureField{Name: ff.Name, Page: ff.Page, Rect: ff.Rect, Label: ff.Default})
+	}
+
+	if ff.Page < 1 || ff.Page > xRefTable.PageCount {
+		return nil, NewError(ErrPageOutOfRange, "form field %s: invalid page %d", ff.Name, ff.Page)
+	}
+
+	if ff.Rect.Width() <= 0 || ff.Rect.Height() <= 0 {
+		return nil, errors.Errorf("form field %s: invalid rectangle %s", ff.Name, ff.Rect)
+	}
+
+	pageDict, _, err := xRefTable.PageDict(ff.Page)
+	if err != nil {
+		return nil, err
+	}
+
+	pageIndRef, err := xRefTable.PageIndRef(ff.Page)
+	if err != nil {
+		return nil, err
+	}
+
+	var d Dict
+
+	switch ff.Type {
+	case FormFieldText:
+		d, err = textFieldDict(xRefTable, acroForm, ff)
+	case FormFieldCheckBox:
+		d, err = checkBoxFieldDict(xRefTable, acroForm, ff)
+	default:
+		err = errors.Errorf("form field %s: unsupported type %d", ff.Name, ff.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := textString(ff.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Merged field and widget annotation dict.
+	d.Insert("T", name)
+	d.Insert("Type", Name("Annot"))
+	d.Insert("Subtype", Name("Widget"))
+	d.Insert("Rect", NewRectangle(ff.Rect.LL.X, ff.Rect.LL.Y, ff.Rect.UR.X, ff.Rect.UR.Y))
+	d.Insert("F", Integer(4)) // Print
+	d.Insert("P", *pageIndRef)
+
+	return addAnnotation(xRefTable, pageDict, d)
+}
+
+// AddFormFields adds text fields, check boxes and signature fields to the interactive form of xRefTable
+// and creates the interactive form if missing.
+func AddFormFields(xRefTable *XRefTable, fields []FormField) error {
+
+	d, err := acroFormDict(xRefTable)
+	if err != nil {
+		return err
+	}
+
+	o, err := xRefTable.Dereference(d["Fields"])
+	if err != nil {
+		return err
+	}
+
+	a, _ := o.(Array)
+
+	names, err := fieldNames(xRefTable, a)
+	if err != nil {
+		return err
+	}
+
+	hasSigFields := false
+
+	for _, ff := range fields {
+
+		if len(ff.Name) == 0 {
+			return errors.New("form field: missing name")
+		}
+
+		if names[ff.Name] {
+			return errors.Errorf("form field %s: duplicate field name", ff.Name)
+		}
+
+		log.Debug.Printf("AddFormFields: %s\n", ff)
+
+		ir, err := addFormField(xRefTable, d, ff)
+		if err != nil {
+			return err
+		}
+
+		a = append(a, *ir)
+		names[ff.Name] = true
+
+		if ff.Type == FormFieldSignature {
+			hasSigFields = true
+		}
+	}
+
+	d.Update("Fields", a)
+
+	if hasSigFields {
+		// SignaturesExist
+		sigFlags := 1
+		if i := d.IntEntry("SigFlags"); i != nil {
+			sigFlags |= *i
+		}
+		d.Update("SigFlags", Integer(sigFlags))
+	}
+
+	return nil
+}
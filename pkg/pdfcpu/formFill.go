@@ -0,0 +1,822 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jplu/pdfcpu/pkg/fonts/metrics"
+	"github.com/jplu/pdfcpu/pkg/log"
+	"github.com/jplu/pdfcpu/pkg/types"
+	"github.com/pkg/errors"
+)
+
+// Field flags specific to button fields, see Table 226.
+const (
+	FieldNoToggleToOff = 1 << (iota + 14)
+	FieldRadio
+	FieldPushbutton
+)
+
+// Field flags specific to text fields, see Table 228.
+const (
+	FieldMultiline  = 1 << 12
+	FieldPassword   = 1 << 13
+	FieldFileSelect = 1 << 20
+	FieldComb       = 1 << 24
+)
+
+// Field flags specific to choice fields, see Table 230.
+const (
+	FieldCombo = 1 << 17
+)
+
+// fieldInheritedEntry returns the entry key of the field d or of its closest ancestor defining it.
+func fieldInheritedEntry(xRefTable *XRefTable, d Dict, key string) (Object, error) {
+
+	visited := IntSet{}
+
+	for {
+		if o, found := d.Find(key); found && o != nil {
+			return xRefTable.Dereference(o)
+		}
+
+		ir := d.IndirectRefEntry("Parent")
+		if ir == nil || visited[ir.ObjectNumber.Value()] {
+			return nil, nil
+		}
+		visited[ir.ObjectNumber.Value()] = true
+
+		p, err := xRefTable.DereferenceDict(*ir)
+		if err != nil || p == nil {
+			return nil, err
+		}
+		d = p
+	}
+}
+
+// fieldInheritedInt returns the integer entry key of the field d, its ancestors or the interactive form.
+func fieldInheritedInt(xRefTable *XRefTable, acroForm, d Dict, key string) (int, error) {
+
+	o, err := fieldInheritedEntry(xRefTable, d, key)
+	if err != nil {
+		return 0, err
+	}
+
+	if o == nil && acroForm != nil {
+		if o, err = xRefTable.Dereference(acroForm[key]); err != nil {
+			return 0, err
+		}
+	}
+
+	if i, ok := o.(Integer); ok {
+		return i.Value(), nil
+	}
+
+	return 0, nil
+}
+
+// fieldWidgets returns the widget annotations of the field d.
+func fieldWidgets(xRefTable *XRefTable, d Dict) ([]Dict, error) {
+
+	if st := d.Subtype(); st != nil && *st == "Widget" {
+		return []Dict{d}, nil
+	}
+
+	var widgets []Dict
+
+	for _, o := range d.ArrayEntry("Kids") {
+		kid, err := xRefTable.DereferenceDict(o)
+		if err != nil {
+			return nil, err
+		}
+		if kid == nil || kid["T"] != nil {
+			continue
+		}
+		if st := kid.Subtype(); st != nil && *st == "Widget" {
+			widgets = append(widgets, kid)
+		}
+	}
+
+	return widgets, nil
+}
+
+// defaultAppearance represents the parsed default appearance string DA of a variable text field.
+// See 12.7.3.3 Variable Text
+type defaultAppearance struct {
+	fontName string
+	fontSize float64 // 0 means auto size.
+	color    string  // Color operators, eg. "0 g"
+}
+
+func parseDefaultAppearance(s string) (*defaultAppearance, error) {
+
+	da := defaultAppearance{}
+	sc := contentScanner{b: []byte(s)}
+
+	var operands []string
+
+	for {
+		tok, _, err := sc.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if !contentOperator(tok) {
+			operands = append(operands, string(tok))
+			continue
+		}
+
+		switch op := string(tok); op {
+
+		case "Tf":
+			if len(operands) != 2 || !strings.HasPrefix(operands[0], "/") {
+				return nil, errors.Errorf("parseDefaultAppearance: corrupt Tf in %q", s)
+			}
+			da.fontName = operands[0][1:]
+			if da.fontSize, err = strconv.ParseFloat(operands[1], 64); err != nil {
+				return nil, errors.Errorf("parseDefaultAppearance: corrupt font size in %q", s)
+			}
+
+		case "g", "rg", "k":
+			da.color = strings.Join(append(operands, op), " ")
+		}
+
+		operands = nil
+	}
+
+	if da.fontName == "" {
+		return nil, errors.Errorf("parseDefaultAppearance: missing Tf in %q", s)
+	}
+
+	if da.color == "" {
+		da.color = "0 g"
+	}
+
+	return &da, nil
+}
+
+// fieldFont provides glyph widths for a font of the default resources of the interactive form.
+type fieldFont struct {
+	name      string // The font resource name.
+	ir        *IndirectRef
+	baseFont  string
+	firstChar int
+	widths    Array
+}
+
+func newFieldFont(xRefTable *XRefTable, acroForm Dict, name string) (*fieldFont, error) {
+
+	dr, err := xRefTable.DereferenceDict(acroForm["DR"])
+	if err != nil {
+		return nil, err
+	}
+
+	var ir *IndirectRef
+	if dr != nil {
+		fonts, err := xRefTable.DereferenceDict(dr["Font"])
+		if err != nil {
+			return nil, err
+		}
+		if fonts != nil {
+			ir = fonts.IndirectRefEntry(name)
+		}
+	}
+
+	if ir == nil {
+		// Fall back to Helvetica for a missing font resource.
+		log.Info.Printf("newFieldFont: missing font resource %s, using Helvetica\n", name)
+		if ir, err = formFontIndRef(xRefTable, acroForm, "Helvetica"); err != nil {
+			return nil, err
+		}
+		name = "Helvetica"
+	}
+
+	d, err := xRefTable.DereferenceDict(*ir)
+	if err != nil {
+		return nil, err
+	}
+
+	f := fieldFont{name: name, ir: ir}
+
+	if d != nil {
+		if bf := d.NameEntry("BaseFont"); bf != nil {
+			f.baseFont = *bf
+			// Strip a subset tag.
+			if i := strings.Index(f.baseFont, "+"); i == 6 {
+				f.baseFont = f.baseFont[7:]
+			}
+		}
+		if i := d.IntEntry("FirstChar"); i != nil {
+			f.firstChar = *i
+		}
+		if f.widths, err = xRefTable.DereferenceArray(d["Widths"]); err != nil {
+			return nil, err
+		}
+	}
+
+	return &f, nil
+}
+
+// charWidth returns the width of c in glyph space units.
+func (f fieldFont) charWidth(c byte) float64 {
+
+	if supportedWatermarkFont(f.baseFont) {
+		return float64(metrics.CharWidth(f.baseFont, int(c)))
+	}
+
+	if i := int(c) - f.firstChar; f.widths != nil && i >= 0 && i < len(f.widths) {
+		switch w := f.widths[i].(type) {
+		case Integer:
+			return float64(w.Value())
+		case Float:
+			return w.Value()
+		}
+	}
+
+	return float64(metrics.CharWidth("Helvetica", int(c)))
+}
+
+// textWidth returns the width of s rendered at size in user space units.
+func (f fieldFont) textWidth(s string, size float64) float64 {
+
+	w := 0.
+	for i := 0; i < len(s); i++ {
+		w += f.charWidth(s[i])
+	}
+
+	return w * size / 1000
+}
+
+// fieldText represents the layout parameters for the appearance of a variable text field widget.
+type fieldText struct {
+	da       *defaultAppearance
+	font     *fieldFont
+	quadding int
+	ff       int
+	maxLen   int
+	bg, bc   Array
+	bw       float64
+}
+
+func colorOperator(a Array, stroke bool) string {
+
+	var ss []string
+	for _, o := range a {
+		switch f := o.(type) {
+		case Integer:
+			ss = append(ss, fmt.Sprintf("%d", f.Value()))
+		case Float:
+			ss = append(ss, fmt.Sprintf("%.3f", f.Value()))
+		}
+	}
+
+	op := map[int]string{1: "g", 3: "rg", 4: "k"}[len(ss)]
+	if op == "" || len(ss) != len(a) {
+		return ""
+	}
+
+	if stroke {
+		op = strings.ToUpper(op)
+	}
+
+	return strings.Join(ss, " ") + " " + op
+}
+
+// wrap breaks s into lines fitting width at font size.
+func (ft fieldText) wrap(s string, width, size float64) []string {
+
+	var lines []string
+
+	s = strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(s)
+
+	for _, para := range strings.Split(s, "\n") {
+		line := ""
+		for _, word := range strings.Split(para, " ") {
+			if line == "" {
+				line = word
+				continue
+			}
+			if ft.font.textWidth(line+" "+word, size) > width {
+				lines = append(lines, line)
+				line = word
+				continue
+			}
+			line += " " + word
+		}
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// fontSize returns the font size for rendering s into a widget of size w x h
+// resolving auto size by fitting s into the widget.
+func (ft fieldText) fontSize(s string, w, h float64) float64 {
+
+	if ft.da.fontSize > 0 {
+		return ft.da.fontSize
+	}
+
+	size := 12.
+
+	switch {
+
+	case ft.ff&FieldMultiline > 0:
+		for ; size > 4; size-- {
+			if float64(len(ft.wrap(s, w-4, size)))*size*1.15 <= h-4 {
+				break
+			}
+		}
+		return size
+
+	case ft.ff&FieldComb > 0 && ft.maxLen > 0:
+		if cw := w / float64(ft.maxLen) * .8; cw < size {
+			size = cw
+		}
+	}
+
+	if s := h * .6; s < size {
+		size = s
+	}
+
+	if tw := ft.font.textWidth(s, size); tw > w-4 && tw > 0 {
+		size *= (w - 4) / tw
+	}
+
+	if size < 1 {
+		size = 1
+	}
+
+	return size
+}
+
+// lineX returns the horizontal offset of a line of width lw according to the quadding.
+func (ft fieldText) lineX(lw, w float64) float64 {
+	switch ft.quadding {
+	case 1:
+		return (w - lw) / 2
+	case 2:
+		return w - 2 - lw
+	}
+	return 2
+}
+
+func writeTextLine(b *bytes.Buffer, s string, x, y float64) {
+	es, _ := Escape(s)
+	fmt.Fprintf(b, "1 0 0 1 %.2f %.2f Tm (%s) Tj ", x, y, *es)
+}
+
+// content returns the content of a normal appearance stream rendering s into a widget of size w x h.
+// selected is the index of the highlighted line of a list box or -1.
+func (ft fieldText) content(s string, w, h float64, lines []string, selected int) []byte {
+
+	var b bytes.Buffer
+
+	if op := colorOperator(ft.bg, false); op != "" {
+		fmt.Fprintf(&b, "q %s 0 0 %.2f %.2f re f Q ", op, w, h)
+	}
+
+	if op := colorOperator(ft.bc, true); op != "" && ft.bw > 0 {
+		fmt.Fprintf(&b, "q %s %.2f w %.2f %.2f %.2f %.2f re S Q ", op, ft.bw, ft.bw/2, ft.bw/2, w-ft.bw, h-ft.bw)
+	}
+
+	b.WriteString("/Tx BMC q 1 1 ")
+	fmt.Fprintf(&b, "%.2f %.2f re W n ", w-2, h-2)
+
+	if ft.ff&FieldPassword > 0 {
+		s = strings.Repeat("*", len(s))
+	}
+
+	size := ft.fontSize(s, w, h)
+	leading := size * 1.15
+
+	if lines != nil && selected >= 0 {
+		y := h - 2 - leading*float64(selected+1)
+		fmt.Fprintf(&b, "0.600 0.757 0.855 rg 1 %.2f %.2f %.2f re f ", y, w-2, leading)
+	}
+
+	fmt.Fprintf(&b, "BT /%s %.2f Tf %s ", ft.font.name, size, ft.da.color)
+
+	switch {
+
+	case lines != nil:
+		// List box
+		for i, l := range lines {
+			writeTextLine(&b, l, 2, h-2-leading*float64(i+1)+.25*leading)
+		}
+
+	case ft.ff&FieldComb > 0 && ft.maxLen > 0 && ft.ff&(FieldMultiline|FieldPassword|FieldFileSelect) == 0:
+		cw := w / float64(ft.maxLen)
+		y := (h-size)/2 + .25*size
+		for i := 0; i < len(s); i++ {
+			x := float64(i)*cw + (cw-ft.font.charWidth(s[i])*size/1000)/2
+			writeTextLine(&b, s[i:i+1], x, y)
+		}
+
+	case ft.ff&FieldMultiline > 0:
+		for i, l := range ft.wrap(s, w-4, size) {
+			writeTextLine(&b, l, ft.lineX(ft.font.textWidth(l, size), w), h-2-size-leading*float64(i))
+		}
+
+	case len(s) > 0:
+		writeTextLine(&b, s, ft.lineX(ft.font.textWidth(s, size), w), (h-size)/2+.25*size)
+	}
+
+	b.WriteString("ET Q EMC")
+
+	return b.Bytes()
+}
+
+// newFieldText gathers the layout parameters of the variable text field d.
+func newFieldText(xRefTable *XRefTable, acroForm, d Dict) (*fieldText, error) {
+
+	o, err := fieldInheritedEntry(xRefTable, d, "DA")
+	if err != nil {
+		return nil, err
+	}
+	if o == nil {
+		if o, err = xRefTable.Dereference(acroForm["DA"]); err != nil {
+			return nil, err
+		}
+	}
+
+	sl, ok := o.(StringLiteral)
+	if !ok {
+		return nil, errors.New("missing default appearance")
+	}
+
+	s, err := StringLiteralToString(sl.Value())
+	if err != nil {
+		return nil, err
+	}
+
+	ft := fieldText{}
+
+	if ft.da, err = parseDefaultAppearance(s); err != nil {
+		return nil, err
+	}
+
+	if ft.font, err = newFieldFont(xRefTable, acroForm, ft.da.fontName); err != nil {
+		return nil, err
+	}
+
+	if ft.quadding, err = fieldInheritedInt(xRefTable, acroForm, d, "Q"); err != nil {
+		return nil, err
+	}
+
+	if ft.ff, err = fieldInheritedInt(xRefTable, nil, d, "Ff"); err != nil {
+		return nil, err
+	}
+
+	if ft.maxLen, err = fieldInheritedInt(xRefTable, nil, d, "MaxLen"); err != nil {
+		return nil, err
+	}
+
+	return &ft, nil
+}
+
+// refreshWidgetAppearance replaces the appearance dict of the widget annotation wd by a normal appearance rendering s.
+func refreshWidgetAppearance(xRefTable *XRefTable, ft fieldText, wd Dict, s string, lines []string, selected int) error {
+
+	// Appearances get rendered single byte encoded.
+	s, err := latin1(s)
+	if err != nil {
+		return err
+	}
+
+	var ll []string
+	for _, l := range lines {
+		l, err := latin1(l)
+		if err != nil {
+			return err
+		}
+		ll = append(ll, l)
+	}
+
+	arr, err := xRefTable.DereferenceArray(wd["Rect"])
+	if err != nil {
+		return err
+	}
+	if len(arr) != 4 {
+		return errors.New("corrupt widget rectangle")
+	}
+
+	var f [4]float64
+	for i, o := range arr {
+		switch v := o.(type) {
+		case Integer:
+			f[i] = float64(v.Value())
+		case Float:
+			f[i] = v.Value()
+		default:
+			return errors.New("corrupt widget rectangle")
+		}
+	}
+
+	r := types.NewRectangle(f[0], f[1], f[2], f[3])
+
+	ft.bg, ft.bc, ft.bw = nil, nil, 1
+	mk, err := xRefTable.DereferenceDict(wd["MK"])
+	if err != nil {
+		return err
+	}
+	if mk != nil {
+		ft.bg, ft.bc = mk.ArrayEntry("BG"), mk.ArrayEntry("BC")
+	}
+	if bs, err := xRefTable.DereferenceDict(wd["BS"]); err == nil && bs != nil {
+		if w, found := bs.Find("W"); found {
+			switch v := w.(type) {
+			case Integer:
+				ft.bw = float64(v.Value())
+			case Float:
+				ft.bw = v.Value()
+			}
+		}
+	}
+
+	ir, err := formXObject(xRefTable, r, ft.content(s, r.Width(), r.Height(), ll, selected), ft.font.name, ft.font.ir)
+	if err != nil {
+		return err
+	}
+
+	// Rollover and down appearances would show the previous value.
+	wd.Update("AP", Dict(map[string]Object{"N": *ir}))
+
+	return nil
+}
+
+// latin1 converts the UTF-8 string s into a single byte encoded string.
+func latin1(s string) (string, error) {
+
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > 0xFF {
+			return "", errors.Errorf("unsupported character %q", r)
+		}
+		b = append(b, byte(r))
+	}
+
+	return string(b), nil
+}
+
+func fillTextField(xRefTable *XRefTable, acroForm, d Dict, v string) error {
+
+	ft, err := newFieldText(xRefTable, acroForm, d)
+	if err != nil {
+		return err
+	}
+
+	if ft.maxLen > 0 && utf8.RuneCountInString(v) > ft.maxLen {
+		return errors.Errorf("value exceeds maximum length %d", ft.maxLen)
+	}
+
+	s, err := textString(v)
+	if err != nil {
+		return err
+	}
+	d.Update("V", s)
+
+	widgets, err := fieldWidgets(xRefTable, d)
+	if err != nil {
+		return err
+	}
+
+	for _, wd := range widgets {
+		if err = refreshWidgetAppearance(xRefTable, *ft, wd, v, nil, -1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// widgetStates returns the names of the normal appearance states of the widget annotation wd.
+func widgetStates(xRefTable *XRefTable, wd Dict) ([]string, error) {
+
+	ap, err := xRefTable.DereferenceDict(wd["AP"])
+	if err != nil || ap == nil {
+		return nil, err
+	}
+
+	n, err := xRefTable.DereferenceDict(ap["N"])
+	if err != nil || n == nil {
+		return nil, err
+	}
+
+	var ss []string
+	for k := range n {
+		ss = append(ss, k)
+	}
+	sort.Strings(ss)
+
+	return ss, nil
+}
+
+// ButtonExportValues returns the export values of all check boxes and radio button groups by fully qualified field name.
+// These are the names of the appearance states for the on state of their widgets, see FillFormFields.
+// The off state "Off" is omitted.
+func ButtonExportValues(xRefTable *XRefTable) (map[string][]string, error) {
+
+	fields, err := formFields(xRefTable)
+	if err != nil {
+		return nil, err
+	}
+
+	m := map[string][]string{}
+
+	err = walkFields(xRefTable, fields, "", IntSet{}, func(name string, ir *IndirectRef, d Dict) (bool, error) {
+
+		o, err := fieldInheritedEntry(xRefTable, d, "FT")
+		if err != nil {
+			return false, err
+		}
+		if ft, _ := o.(Name); ft != "Btn" {
+			return true, nil
+		}
+
+		ff, err := fieldInheritedInt(xRefTable, nil, d, "Ff")
+		if err != nil || ff&FieldPushbutton > 0 {
+			return true, err
+		}
+
+		widgets, err := fieldWidgets(xRefTable, d)
+		if err != nil || len(widgets) == 0 {
+			return true, err
+		}
+
+		found := StringSet{}
+		values := []string{}
+
+		for _, wd := range widgets {
+			ss, err := widgetStates(xRefTable, wd)
+			if err != nil {
+				return false, err
+			}
+			for _, s := range ss {
+				if s != "Off" && !found[s] {
+					found[s] = true
+					values = append(values, s)
+				}
+			}
+		}
+
+		m[name] = values
+
+		return true, nil
+	})
+
+	return m, err
+}
+
+func fillButtonField(xRefTable *XRefTable, d Dict, ff int, v string) error {
+
+	if ff&FieldPushbutton > 0 {
+		return errors.New("push buttons have no value")
+	}
+
+	widgets, err := fieldWidgets(xRefTable, d)
+	if err != nil {
+		return err
+	}
+
+	found := v == "Off"
+
+	for _, wd := range widgets {
+		ss, err := widgetStates(xRefTable, wd)
+		if err != nil {
+			return err
+		}
+		as := "Off"
+		for _, s := range ss {
+			if s == v {
+				as, found = v, true
+			}
+		}
+		wd.Update("AS", Name(as))
+	}
+
+	if !found {
+		return errors.Errorf("unknown state %q", v)
+	}
+
+	d.Update("V", Name(v))
+
+	return nil
+}
+
+func fillFormField(xRefTable *XRefTable, acroForm, d Dict, v string) error {
+
+	o, err := fieldInheritedEntry(xRefTable, d, "FT")
+	if err != nil {
+		return err
+	}
+
+	ft, _ := o.(Name)
+
+	ff, err := fieldInheritedInt(xRefTable, nil, d, "Ff")
+	if err != nil {
+		return err
+	}
+
+	if ft == "Btn" {
+		return fillButtonField(xRefTable, d, ff, v)
+	}
+
+	switch ft {
+	case "Tx":
+		return fillTextField(xRefTable, acroForm, d, v)
+	case "Ch":
+		return fillChoiceField(xRefTable, acroForm, d, v)
+	}
+
+	return errors.Errorf("unsupported field type %q", ft)
+}
+
+// FillFormFields sets the values of the terminal fields named by their fully qualified names.
+//
+// Text and choice fields get their normal appearances regenerated for each widget
+// respecting the font, font size (including auto size), color and quadding of the default appearance,
+// comb fields, multiline fields and the maximum length,
+// so the filled values display the same in all viewers regardless of NeedAppearances.
+// Check boxes and radio buttons take the name of the appearance state to be turned on or "Off".
+func FillFormFields(xRefTable *XRefTable, values map[string]string) error {
+
+	rootDict, err := xRefTable.Catalog()
+	if err != nil {
+		return err
+	}
+
+	acroForm, err := xRefTable.DereferenceDict(rootDict["AcroForm"])
+	if err != nil {
+		return err
+	}
+	if acroForm == nil {
+		return errors.New("FillFormFields: missing interactive form")
+	}
+
+	fields, err := xRefTable.DereferenceArray(acroForm["Fields"])
+	if err != nil {
+		return err
+	}
+
+	found := StringSet{}
+
+	err = walkFields(xRefTable, fields, "", IntSet{}, func(name string, ir *IndirectRef, d Dict) (bool, error) {
+
+		v, ok := values[name]
+		if !ok {
+			return true, nil
+		}
+
+		found[name] = true
+
+		log.Debug.Printf("FillFormFields: %s=%q\n", name, v)
+
+		if err := fillFormField(xRefTable, acroForm, d, v); err != nil {
+			return false, errors.Wrapf(err, "FillFormFields: field %s", name)
+		}
+
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !found[name] {
+			return errors.Errorf("FillFormFields: unknown field %s", name)
+		}
+	}
+
+	return nil
+}
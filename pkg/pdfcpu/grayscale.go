@@ -0,0 +1,495 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"io"
+
+	"github.com/jplu/pdfcpu/pkg/filter"
+	"github.com/jplu/pdfcpu/pkg/log"
+	"github.com/pkg/errors"
+)
+
+// GrayscaleMode specifies the content converted by Grayscale.
+type GrayscaleMode int
+
+// The available grayscale modes.
+const (
+	GrayscaleImages GrayscaleMode = iota // Convert images, leave vector graphics, text and stamps alone.
+	GrayscaleStamps                      // Convert stamps and watermarks including their images.
+)
+
+// Quality used for reencoding converted JPEG images.
+const grayscaleJPEGQuality = 90
+
+type grayscaler struct {
+	xRefTable *XRefTable
+	mode      GrayscaleMode
+	visited   IntSet
+	n         int
+}
+
+// rgbGray and cmykGray convert color components between 0 and 1 as recommended by 10.3.5 Conversion between DeviceCMYK and DeviceGray.
+func rgbGray(r, g, b float64) float64 {
+	return 0.3*r + 0.59*g + 0.11*b
+}
+
+func cmykGray(c, m, y, k float64) float64 {
+	g := 0.3*c + 0.59*m + 0.11*y + k
+	if g > 1 {
+		g = 1
+	}
+	return 1 - g
+}
+
+// isStampForm returns true for form XObjects created by the stamp and watermark commands.
+// These are tied to an optional content group used for page elements in the back- or foreground.
+func isStampForm(xRefTable *XRefTable, d Dict) bool {
+
+	var o Object = d
+	for _, k := range []string{"OC", "Usage", "PageElement"} {
+		d, err := xRefTable.DereferenceDict(o)
+		if err != nil || d == nil {
+			return false
+		}
+		o = d[k]
+	}
+
+	d, err := xRefTable.DereferenceDict(o)
+	if err != nil || d == nil {
+		return false
+	}
+
+	st := d.NameEntry("Subtype")
+
+	return st != nil && (*st == "BG" || *st == "FG")
+}
+
+// colorSpaceComponents returns the number of components of the color space named name
+// if it is a device or ICC based color space, or 0.
+func colorSpaceComponents(xRefTable *XRefTable, resources Dict, name string) int {
+
+	switch name {
+	case DeviceGrayCS:
+		return 1
+	case DeviceRGBCS:
+		return 3
+	case DeviceCMYKCS:
+		return 4
+	}
+
+	d, err := xRefTable.DereferenceDict(resources["ColorSpace"])
+	if err != nil || d == nil {
+		return 0
+	}
+
+	n, _ := imageComponents(xRefTable, d[name])
+
+	return n
+}
+
+// grayOperands returns the gray level for the RGB or CMYK color operands.
+func grayOperands(operands [][]byte) (float64, bool) {
+
+	if len(operands) != 3 && len(operands) != 4 {
+		return 0, false
+	}
+
+	for _, tok := range operands {
+		if contentOperator(tok) || contentDelimiter(tok[0]) {
+			return 0, false
+		}
+	}
+
+	var f [4]float64
+	for i, tok := range operands {
+		f[i] = contentNumber(tok)
+	}
+
+	switch len(operands) {
+	case 3:
+		return rgbGray(f[0], f[1], f[2]), true
+	case 4:
+		return cmykGray(f[0], f[1], f[2], f[3]), true
+	}
+
+	return 0, false
+}
+
+// convertContent replaces RGB and CMYK colors of a decoded content stream by their gray levels.
+func convertContent(xRefTable *XRefTable, content []byte, resources Dict) ([]byte, bool, error) {
+
+	type operand struct {
+		tok []byte
+		pos int
+	}
+
+	var (
+		b         bytes.Buffer
+		operands  []operand
+		last      int
+		changed   bool
+		stack     [][2]int
+		fill, str = 1, 1 // Number of components of the current color spaces.
+	)
+
+	replace := func(s string, end int) {
+		start := end
+		if len(operands) > 0 {
+			start = operands[0].pos
+		}
+		b.Write(content[last:start])
+		b.WriteString(s)
+		last = end
+		changed = true
+	}
+
+	s := &contentScanner{b: content}
+
+	for {
+
+		tok, pos, err := s.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, false, err
+		}
+
+		if !contentOperator(tok) {
+			operands = append(operands, operand{tok, pos})
+			continue
+		}
+
+		op := string(tok)
+		end := pos + len(tok)
+
+		var toks [][]byte
+		for _, o := range operands {
+			toks = append(toks, o.tok)
+		}
+
+		switch op {
+
+		case "BI":
+			if _, err := s.inlineImage(pos); err != nil {
+				return nil, false, err
+			}
+
+		case "q":
+			stack = append(stack, [2]int{fill, str})
+
+		case "Q":
+			if n := len(stack); n > 0 {
+				fill, str, stack = stack[n-1][0], stack[n-1][1], stack[:n-1]
+			}
+
+		case "g":
+			fill = 1
+
+		case "G":
+			str = 1
+
+		case "rg", "k", "RG", "K":
+			if g, ok := grayOperands(toks); ok {
+				if op == "rg" || op == "k" {
+					fill = 1
+					replace(fmt.Sprintf("%.3f g", g), end)
+				} else {
+					str = 1
+					replace(fmt.Sprintf("%.3f G", g), end)
+				}
+			}
+
+		case "cs", "CS":
+			n := 0
+			if len(toks) == 1 && toks[0][0] == '/' {
+				n = colorSpaceComponents(xRefTable, resources, string(toks[0][1:]))
+			}
+			if n == 3 || n == 4 {
+				replace("/DeviceGray "+op, end)
+			}
+			if op == "cs" {
+				fill = n
+			} else {
+				str = n
+			}
+
+		case "sc", "scn", "SC", "SCN":
+			n := fill
+			if op == "SC" || op == "SCN" {
+				n = str
+			}
+			if n != len(toks) {
+				break
+			}
+			if g, ok := grayOperands(toks); ok {
+				replace(fmt.Sprintf("%.3f %s", g, op), end)
+			}
+		}
+
+		operands = nil
+	}
+
+	if !changed {
+		return content, false, nil
+	}
+
+	b.Write(content[last:])
+
+	return b.Bytes(), true, nil
+}
+
+// convertJPEG converts a DCT encoded RGB image.
+func convertJPEG(sd *StreamDict) (bool, error) {
+
+	img, err := jpeg.Decode(bytes.NewReader(sd.Raw))
+	if err != nil {
+		log.Info.Printf("convertJPEG: %v\n", err)
+		return false, nil
+	}
+
+	g := image.NewGray(img.Bounds())
+	draw.Draw(g, g.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	var b bytes.Buffer
+	if err = jpeg.Encode(&b, g, &jpeg.Options{Quality: grayscaleJPEGQuality}); err != nil {
+		return false, err
+	}
+
+	sd.Raw = b.Bytes()
+	sd.Content = nil
+	sd.Delete("DecodeParms")
+
+	streamLength := int64(len(sd.Raw))
+	sd.StreamLength = &streamLength
+	sd.Update("Length", Integer(streamLength))
+
+	return true, nil
+}
+
+// convertImage converts an 8 bit RGB or CMYK image to DeviceGray and returns false if this is not possible.
+func convertImage(xRefTable *XRefTable, sd *StreamDict) (bool, error) {
+
+	if im := sd.BooleanEntry("ImageMask"); im != nil && *im {
+		return false, nil
+	}
+
+	if bpc := sd.IntEntry("BitsPerComponent"); bpc == nil || *bpc != 8 {
+		return false, nil
+	}
+
+	if _, found := sd.Find("Decode"); found {
+		return false, nil
+	}
+
+	w, h := sd.IntEntry("Width"), sd.IntEntry("Height")
+	if w == nil || h == nil {
+		return false, nil
+	}
+
+	comps, _ := imageComponents(xRefTable, sd.Dict["ColorSpace"])
+	if comps != 3 && comps != 4 {
+		return false, nil
+	}
+
+	var ok bool
+
+	if len(sd.FilterPipeline) == 1 && sd.FilterPipeline[0].Name == filter.DCT {
+		if comps != 3 {
+			return false, nil
+		}
+		var err error
+		if ok, err = convertJPEG(sd); err != nil || !ok {
+			return false, err
+		}
+	}
+
+	if !ok {
+
+		err := decodeStream(sd)
+		if err == filter.ErrUnsupportedFilter {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		n := *w * *h
+		if len(sd.Content) < n*comps {
+			log.Info.Println("convertImage: corrupt image data")
+			return false, nil
+		}
+
+		b := make([]byte, n)
+		for i := 0; i < n; i++ {
+			c := sd.Content[i*comps : i*comps+comps]
+			var g float64
+			if comps == 3 {
+				g = rgbGray(float64(c[0]), float64(c[1]), float64(c[2]))
+			} else {
+				g = 255 * cmykGray(float64(c[0])/255, float64(c[1])/255, float64(c[2])/255, float64(c[3])/255)
+			}
+			b[i] = byte(g + 0.5)
+		}
+
+		sd.Content = b
+		sd.FilterPipeline = []PDFFilter{{Name: filter.Flate}}
+		sd.Update("Filter", Name(filter.Flate))
+		sd.Delete("DecodeParms")
+
+		if err = encodeStream(sd); err != nil {
+			return false, err
+		}
+	}
+
+	sd.Update("ColorSpace", Name(DeviceGrayCS))
+
+	return true, nil
+}
+
+// processResources converts the XObjects of resources and of any forms referenced.
+func (gs *grayscaler) processResources(resources Dict, inStamp bool) error {
+
+	xObjects, err := gs.xRefTable.DereferenceDict(resources["XObject"])
+	if err != nil || xObjects == nil {
+		return err
+	}
+
+	for _, o := range xObjects {
+
+		ir, ok := o.(IndirectRef)
+		if !ok {
+			continue
+		}
+
+		objNr := ir.ObjectNumber.Value()
+		if gs.visited[objNr] {
+			continue
+		}
+		gs.visited[objNr] = true
+
+		entry, found := gs.xRefTable.FindTableEntryLight(objNr)
+		if !found || entry.Free {
+			continue
+		}
+
+		sd, ok := entry.Object.(StreamDict)
+		if !ok {
+			continue
+		}
+
+		st := sd.Subtype()
+		if st == nil {
+			continue
+		}
+
+		switch *st {
+
+		case "Image":
+			if inStamp != (gs.mode == GrayscaleStamps) {
+				continue
+			}
+			ok, err := convertImage(gs.xRefTable, &sd)
+			if err != nil {
+				return errors.Wrapf(err, "obj#%d", objNr)
+			}
+			if ok {
+				log.Debug.Printf("Grayscale: converted image obj#%d\n", objNr)
+				entry.Object = sd
+				gs.n++
+			}
+
+		case "Form":
+			stamp := inStamp || isStampForm(gs.xRefTable, sd.Dict)
+			if stamp && gs.mode == GrayscaleImages {
+				continue
+			}
+
+			res, err := gs.xRefTable.DereferenceDict(sd.Dict["Resources"])
+			if err != nil {
+				return err
+			}
+
+			if stamp {
+				if err = decodeStream(&sd); err != nil {
+					return errors.Wrapf(err, "obj#%d", objNr)
+				}
+				b, changed, err := convertContent(gs.xRefTable, sd.Content, res)
+				if err != nil {
+					return errors.Wrapf(err, "obj#%d", objNr)
+				}
+				if changed {
+					sd.Content = b
+					if err = encodeStream(&sd); err != nil {
+						return err
+					}
+					log.Debug.Printf("Grayscale: converted form obj#%d\n", objNr)
+					entry.Object = sd
+					gs.n++
+				}
+			}
+
+			if res != nil {
+				if err = gs.processResources(res, stamp); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Grayscale converts either the images or the stamps and watermarks of the selected pages to grayscale.
+// In GrayscaleImages mode 8 bit RGB and CMYK images are converted leaving text and vector graphics untouched.
+// In GrayscaleStamps mode the colors of stamps and watermarks get converted along with their images.
+// Images shared with unselected pages get converted as well.
+// Inline images and images using other color spaces or bit depths are kept as they are.
+// Returns the number of objects converted.
+func Grayscale(xRefTable *XRefTable, selectedPages IntSet, mode GrayscaleMode) (int, error) {
+
+	gs := &grayscaler{xRefTable: xRefTable, mode: mode, visited: IntSet{}}
+
+	for _, pageNr := range SortedSelectedPages(selectedPages) {
+
+		pageDict, inhPAttrs, err := xRefTable.PageDict(pageNr)
+		if err != nil {
+			return 0, errors.Wrap(err, "Grayscale")
+		}
+		if pageDict == nil {
+			return 0, errors.Errorf("Grayscale: missing page %d", pageNr)
+		}
+
+		if inhPAttrs.resources == nil {
+			continue
+		}
+
+		if err = gs.processResources(inhPAttrs.resources, false); err != nil {
+			return 0, errors.Wrapf(err, "Grayscale: page %d", pageNr)
+		}
+	}
+
+	log.Debug.Printf("Grayscale: converted %d objects\n", gs.n)
+
+	return gs.n, nil
+}
@@ -0,0 +1,36 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import "testing"
+
+func TestConvertContent(t *testing.T) {
+
+	for content, want := range map[string]string{
+		"1 0 0 rg 0 0 1 0 K":    "0.300 g 0.890 G",
+		"1 0 0 0 0 rg 1 0 k":    "1 0 0 0 0 rg 1 0 k",
+		"0 0 0 0 0 0 0 0 0 0 k": "0 0 0 0 0 0 0 0 0 0 k",
+	} {
+		b, _, err := convertContent(nil, []byte(content), nil)
+		if err != nil {
+			t.Fatalf("convertContent(%q): %v\n", content, err)
+		}
+		if got := string(b); got != want {
+			t.Fatalf("convertContent(%q): want %q, got %q\n", content, want, got)
+		}
+	}
+}
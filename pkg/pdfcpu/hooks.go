@@ -0,0 +1,152 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"sort"
+
+	"github.com/jplu/pdfcpu/pkg/log"
+	"github.com/pkg/errors"
+)
+
+// ObjectHook may inspect or replace object objNr.
+// Returning o unchanged leaves the object alone.
+type ObjectHook func(objNr, genNr int, o Object) (Object, error)
+
+// Hooks let users plug custom transformations like string scrubbing or page numbering into processing.
+// Nil hooks are skipped.
+//
+// Writing several files from one context like splitting
+// may result in hooks being called more than once for the same object or page.
+type Hooks struct {
+
+	// Gets called for each object in use right after it has been read and decrypted.
+	OnObjectRead ObjectHook
+
+	// Gets called for each object in use right before writing starts.
+	OnBeforeWriteObject ObjectHook
+
+	// Gets called for each page to be written once processing is done and before OnBeforeWriteObject.
+	// Objects added by the hook are subject to OnBeforeWriteObject.
+	OnPageProcessed func(ctx *Context, pageNr int, pageDict Dict) error
+
+	// Gets called for each warning recorded, see XRefTable.Warnings.
+	OnWarning func(w Warning)
+}
+
+// internalObject returns true for objects serving the file structure only.
+func internalObject(o Object) bool {
+
+	switch o.(type) {
+	case ObjectStreamDict, XRefStreamDict:
+		return true
+	}
+
+	return false
+}
+
+// applyObjectHook passes all objects in use to h in ascending order of object numbers.
+func applyObjectHook(ctx *Context, h ObjectHook) error {
+
+	var keys []int
+	for k := range ctx.Table {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+
+	for _, objNr := range keys {
+
+		entry := ctx.Table[objNr]
+
+		if entry.Free || entry.Object == nil || internalObject(entry.Object) {
+			continue
+		}
+
+		genNr := 0
+		if entry.Generation != nil {
+			genNr = *entry.Generation
+		}
+
+		o, err := h(objNr, genNr, entry.Object)
+		if err != nil {
+			return errors.Wrapf(err, "hook failed for obj #%d", objNr)
+		}
+
+		entry.Object = o
+
+		if ctx.Root != nil && ctx.Root.ObjectNumber.Value() == objNr {
+			if d, ok := o.(Dict); ok {
+				ctx.RootDict = d
+			}
+		}
+	}
+
+	return nil
+}
+
+// pagesToBeWritten returns the numbers of all pages going into the file written.
+func pagesToBeWritten(ctx *Context) []int {
+
+	w := ctx.Write
+
+	if w.ExtractPageNr > 0 {
+		return []int{w.ExtractPageNr}
+	}
+
+	var pages []int
+
+	for i := 1; i <= ctx.PageCount; i++ {
+		if len(w.ExtractPages) == 0 || w.ExtractPage(i) {
+			pages = append(pages, i)
+		}
+	}
+
+	return pages
+}
+
+// applyWriteHooks runs OnPageProcessed and OnBeforeWriteObject.
+func applyWriteHooks(ctx *Context) error {
+
+	h := ctx.Hooks
+
+	if h.OnPageProcessed != nil {
+
+		for _, i := range pagesToBeWritten(ctx) {
+
+			pageDict, _, err := ctx.PageDict(i)
+			if err != nil {
+				return err
+			}
+
+			if pageDict == nil {
+				continue
+			}
+
+			log.Write.Printf("applyWriteHooks: page %d\n", i)
+
+			if err = h.OnPageProcessed(ctx, i, pageDict); err != nil {
+				return errors.Wrapf(err, "hook failed for page %d", i)
+			}
+		}
+	}
+
+	if h.OnBeforeWriteObject != nil {
+		return applyObjectHook(ctx, h.OnBeforeWriteObject)
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"math"
+)
+
+// ImagePlacement describes where an image XObject gets painted on a page.
+// An image XObject painted more than once has a placement for each Do operator.
+type ImagePlacement struct {
+	PageNr       int    `json:"page"`
+	ObjNr        int    `json:"objNr"`
+	ResourceName string `json:"resourceName"`
+
+	// The file the image got extracted to, if any.
+	File string `json:"file,omitempty"`
+
+	// The CTM a b c d e f mapping the unit square onto the page in default user space.
+	Matrix [6]float64 `json:"matrix"`
+
+	// The bounding box of the image on the page.
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+
+	// The counterclockwise rotation of the image in degrees.
+	Rotation float64 `json:"rotation"`
+}
+
+// roundPt rounds to 1/1000 of a point dropping floating point noise.
+func roundPt(f float64) float64 {
+	return math.Round(f*1000) / 1000
+}
+
+func newImagePlacement(pageNr, objNr int, resName string, ctm matrix) ImagePlacement {
+
+	p := ImagePlacement{
+		PageNr:       pageNr,
+		ObjNr:        objNr,
+		ResourceName: resName,
+		Matrix:       [6]float64{ctm[0][0], ctm[0][1], ctm[1][0], ctm[1][1], ctm[2][0], ctm[2][1]},
+		Rotation:     math.Atan2(ctm[0][1], ctm[0][0]) * radToDeg,
+	}
+
+	llx, lly := math.Inf(1), math.Inf(1)
+	urx, ury := math.Inf(-1), math.Inf(-1)
+
+	for _, c := range [][2]float64{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+		x, y := ctm.transform(c[0], c[1])
+		llx, lly = math.Min(llx, x), math.Min(lly, y)
+		urx, ury = math.Max(urx, x), math.Max(ury, y)
+	}
+
+	p.X, p.Y, p.Width, p.Height = roundPt(llx), roundPt(lly), roundPt(urx-llx), roundPt(ury-lly)
+	p.Rotation = roundPt(p.Rotation)
+
+	return p
+}
+
+// PageImagePlacements returns the placements of all image XObjects painted on page pageNr
+// including images painted by form XObjects in content stream order.
+// Inline images are not covered.
+func PageImagePlacements(xRefTable *XRefTable, pageNr int) ([]ImagePlacement, error) {
+
+	var pp []ImagePlacement
+
+	placement := func(objNr int, resName string, sd *StreamDict, ctm matrix) {
+		pp = append(pp, newImagePlacement(pageNr, objNr, resName, ctm))
+	}
+
+	ir := &imageWalker{xRefTable: xRefTable, image: placement, forms: IntSet{}}
+
+	if err := ir.walkPage(pageNr); err != nil {
+		return nil, err
+	}
+
+	return pp, nil
+}
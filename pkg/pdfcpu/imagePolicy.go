@@ -0,0 +1,627 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/jplu/pdfcpu/pkg/filter"
+	"github.com/jplu/pdfcpu/pkg/log"
+	"github.com/pkg/errors"
+)
+
+// The image kinds an ImageRule may be restricted to.
+const (
+	ImageBilevel = "bilevel" // 1 bit gray images and image masks.
+	ImageGray    = "gray"    // 8 bit gray images.
+	ImageRGB     = "rgb"     // 8 bit RGB images.
+	ImageCMYK    = "cmyk"    // 8 bit CMYK images.
+)
+
+// The compressions an ImageRule may apply.
+const (
+	ImageKeep    = "keep"    // Leave the image as it is.
+	ImageFlate   = "flate"   // Lossless, eg. for screenshots.
+	ImageJPEG    = "jpeg"    // Lossy, for gray and RGB images.
+	ImageCCITTG4 = "ccittG4" // Lossless, for bilevel images.
+)
+
+// ImageRule describes the recompression of the images matching all of its conditions.
+type ImageRule struct {
+
+	// Conditions
+	Kind   string `json:"kind,omitempty"`   // One of the image kinds, empty for any.
+	Filter string `json:"filter,omitempty"` // The filter currently used, eg. FlateDecode, empty for any.
+	MinDPI int    `json:"minDPI,omitempty"` // If > 0 images not rendered above this resolution do not match.
+
+	// Action
+	Compression string `json:"compression"`       // One of the compressions.
+	DPI         int    `json:"dpi,omitempty"`     // If > 0 images rendered above this resolution get downsampled.
+	Quality     int    `json:"quality,omitempty"` // JPEG quality 1..100, 0 for the default quality.
+}
+
+// ImagePolicy is a list of rules for recompressing images. The first rule matching an image applies.
+type ImagePolicy []ImageRule
+
+// Validate checks the rules of p for unknown or unsupported settings.
+func (p ImagePolicy) Validate() error {
+
+	for i, r := range p {
+
+		switch r.Kind {
+		case "", ImageBilevel, ImageGray, ImageRGB, ImageCMYK:
+		default:
+			return errors.Errorf("image rule %d: unknown kind %q", i+1, r.Kind)
+		}
+
+		switch r.Compression {
+		case ImageKeep, ImageFlate:
+		case ImageJPEG:
+			if r.Kind == ImageBilevel || r.Kind == ImageCMYK {
+				return errors.Errorf("image rule %d: JPEG unsupported for %s images", i+1, r.Kind)
+			}
+		case ImageCCITTG4:
+			if r.Kind != ImageBilevel {
+				return errors.Errorf("image rule %d: CCITT G4 requires bilevel images", i+1)
+			}
+		default:
+			return errors.Errorf("image rule %d: unknown compression %q", i+1, r.Compression)
+		}
+
+		if r.MinDPI < 0 || r.DPI < 0 || r.Quality < 0 || r.Quality > 100 {
+			return errors.Errorf("image rule %d: invalid resolution or quality", i+1)
+		}
+	}
+
+	return nil
+}
+
+// ParseImagePolicy reads an image policy from a JSON array of rules, eg.
+//
+//	[
+//		{"kind": "bilevel", "compression": "ccittG4"},
+//		{"kind": "gray", "minDPI": 300, "compression": "jpeg", "dpi": 200, "quality": 80},
+//		{"kind": "rgb", "filter": "FlateDecode", "compression": "keep"}
+//	]
+func ParseImagePolicy(r io.Reader) (ImagePolicy, error) {
+
+	var p ImagePolicy
+
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&p); err != nil {
+		return nil, errors.Wrap(err, "ParseImagePolicy")
+	}
+
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// imageWalker walks page content including any nested forms
+// and calls image for each image XObject painted along with the CTM in effect.
+type imageWalker struct {
+	xRefTable *XRefTable
+	image     func(objNr int, resName string, sd *StreamDict, ctm matrix)
+	forms     IntSet
+}
+
+func (ir *imageWalker) doXObject(resources Dict, tok []byte, ctm matrix) error {
+
+	if len(tok) < 2 || tok[0] != '/' {
+		return nil
+	}
+
+	xObjects, err := ir.xRefTable.DereferenceDict(resources["XObject"])
+	if err != nil || xObjects == nil {
+		return err
+	}
+
+	indRef := xObjects.IndirectRefEntry(string(tok[1:]))
+	if indRef == nil {
+		return nil
+	}
+
+	objNr := indRef.ObjectNumber.Value()
+
+	sd, err := ir.xRefTable.DereferenceStreamDict(*indRef)
+	if err != nil || sd == nil || sd.Subtype() == nil {
+		return err
+	}
+
+	if *sd.Subtype() == "Image" {
+		ir.image(objNr, string(tok[1:]), sd, ctm)
+		return nil
+	}
+
+	if *sd.Subtype() != "Form" || ir.forms[objNr] {
+		return nil
+	}
+
+	b, err := streamContent(ir.xRefTable, *indRef)
+	if err != nil || b == nil {
+		return err
+	}
+
+	res, err := ir.xRefTable.DereferenceDict(sd.Dict["Resources"])
+	if err != nil {
+		return err
+	}
+	if res == nil {
+		res = resources
+	}
+
+	if m, err := numberArray(ir.xRefTable, sd.Dict["Matrix"]); err == nil && len(m) == 6 {
+		ctm = newMatrix(m[0], m[1], m[2], m[3], m[4], m[5]).multiply(ctm)
+	}
+
+	ir.forms[objNr] = true
+	defer delete(ir.forms, objNr)
+
+	return ir.process(b, res, ctm)
+}
+
+func (ir *imageWalker) process(content []byte, resources Dict, ctm matrix) error {
+
+	var stack []matrix
+	var operands [][]byte
+
+	s := &contentScanner{b: content}
+
+	for {
+
+		tok, pos, err := s.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if !contentOperator(tok) {
+			operands = append(operands, tok)
+			continue
+		}
+
+		switch string(tok) {
+
+		case "BI":
+			if _, err := s.inlineImage(pos); err != nil {
+				return err
+			}
+
+		case "q":
+			stack = append(stack, ctm)
+
+		case "Q":
+			if n := len(stack); n > 0 {
+				ctm, stack = stack[n-1], stack[:n-1]
+			}
+
+		case "cm":
+			if m, ok := operandMatrix(operands); ok {
+				ctm = m.multiply(ctm)
+			}
+
+		case "Do":
+			if len(operands) > 0 {
+				if err := ir.doXObject(resources, operands[len(operands)-1], ctm); err != nil {
+					return err
+				}
+			}
+		}
+
+		operands = nil
+	}
+}
+
+// walkPage processes the content of page pageNr.
+func (ir *imageWalker) walkPage(pageNr int) error {
+
+	pageDict, inhPAttrs, err := ir.xRefTable.PageDict(pageNr)
+	if err != nil {
+		return err
+	}
+	if pageDict == nil || inhPAttrs.resources == nil {
+		return nil
+	}
+
+	b, err := pageContent(ir.xRefTable, pageDict)
+	if err != nil {
+		return errors.Wrapf(err, "page %d", pageNr)
+	}
+
+	if err = ir.process(b, inhPAttrs.resources, identMatrix); err != nil {
+		return errors.Wrapf(err, "page %d", pageNr)
+	}
+
+	return nil
+}
+
+// renderedImageResolutions returns the lowest resolution of each image XObject used in page content.
+func renderedImageResolutions(xRefTable *XRefTable) (map[int]float64, error) {
+
+	dpi := map[int]float64{}
+
+	lowest := func(objNr int, resName string, sd *StreamDict, ctm matrix) {
+
+		w, h := sd.IntEntry("Width"), sd.IntEntry("Height")
+		if w == nil || h == nil {
+			return
+		}
+
+		// The image gets mapped onto the unit square.
+		rw := math.Hypot(ctm[0][0], ctm[0][1]) / 72
+		rh := math.Hypot(ctm[1][0], ctm[1][1]) / 72
+		if rw == 0 || rh == 0 {
+			return
+		}
+
+		r := math.Min(float64(*w)/rw, float64(*h)/rh)
+		if d, ok := dpi[objNr]; !ok || r < d {
+			dpi[objNr] = r
+		}
+	}
+
+	ir := &imageWalker{xRefTable: xRefTable, image: lowest, forms: IntSet{}}
+
+	for pageNr := 1; pageNr <= xRefTable.PageCount; pageNr++ {
+		if err := ir.walkPage(pageNr); err != nil {
+			return nil, err
+		}
+	}
+
+	return dpi, nil
+}
+
+// imageKind returns the kind of an image along with its number of color components
+// or an empty string for unsupported images.
+func imageKind(xRefTable *XRefTable, sd *StreamDict) (string, int) {
+
+	bpc := sd.IntEntry("BitsPerComponent")
+
+	if im := sd.BooleanEntry("ImageMask"); im != nil && *im {
+		return ImageBilevel, 1
+	}
+
+	if bpc == nil {
+		return "", 0
+	}
+
+	comps, _ := imageComponents(xRefTable, sd.Dict["ColorSpace"])
+
+	if *bpc == 1 {
+		if comps == 1 {
+			return ImageBilevel, 1
+		}
+		return "", 0
+	}
+
+	if *bpc != 8 {
+		return "", 0
+	}
+
+	return map[int]string{1: ImageGray, 3: ImageRGB, 4: ImageCMYK}[comps], comps
+}
+
+// imageFilter returns the name of the image codec of sd.
+func imageFilter(sd *StreamDict) string {
+	if n := len(sd.FilterPipeline); n > 0 {
+		return sd.FilterPipeline[n-1].Name
+	}
+	return ""
+}
+
+func (r ImageRule) matches(kind, filterName string, dpi float64) bool {
+
+	if r.Kind != "" && r.Kind != kind {
+		return false
+	}
+
+	if r.Filter != "" && r.Filter != filterName {
+		return false
+	}
+
+	return r.MinDPI == 0 || dpi > float64(r.MinDPI)
+}
+
+// imageSamples returns the decoded samples of an image or nil if the image cannot be decoded.
+func imageSamples(sd *StreamDict, comps int) ([]byte, error) {
+
+	if imageFilter(sd) != filter.DCT {
+
+		s := *sd
+		err := decodeStream(&s)
+		if err == filter.ErrUnsupportedFilter {
+			return nil, nil
+		}
+
+		return s.Content, err
+	}
+
+	if len(sd.FilterPipeline) > 1 || comps == 4 {
+		return nil, nil
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(sd.Raw))
+	if err != nil {
+		log.Info.Printf("imageSamples: %v\n", err)
+		return nil, nil
+	}
+
+	if comps == 1 {
+		g := image.NewGray(img.Bounds())
+		draw.Draw(g, g.Bounds(), img, img.Bounds().Min, draw.Src)
+		return g.Pix, nil
+	}
+
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	b := make([]byte, 0, len(rgba.Pix)/4*3)
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		b = append(b, rgba.Pix[i:i+3]...)
+	}
+
+	return b, nil
+}
+
+// downsample reduces w x h samples of comps components each to nw x nh by averaging.
+func downsample(b []byte, w, h, comps, nw, nh int) []byte {
+
+	d := make([]byte, nw*nh*comps)
+
+	for y := 0; y < nh; y++ {
+
+		y0, y1 := y*h/nh, (y+1)*h/nh
+
+		for x := 0; x < nw; x++ {
+
+			x0, x1 := x*w/nw, (x+1)*w/nw
+			n := (y1 - y0) * (x1 - x0)
+
+			for c := 0; c < comps; c++ {
+				sum := 0
+				for sy := y0; sy < y1; sy++ {
+					for sx := x0; sx < x1; sx++ {
+						sum += int(b[(sy*w+sx)*comps+c])
+					}
+				}
+				d[(y*nw+x)*comps+c] = byte((sum + n/2) / n)
+			}
+		}
+	}
+
+	return d
+}
+
+// encodeJPEG encodes w x h gray or RGB samples.
+func encodeJPEG(b []byte, w, h, comps, quality int) ([]byte, error) {
+
+	var img image.Image
+
+	if comps == 1 {
+		img = &image.Gray{Pix: b, Stride: w, Rect: image.Rect(0, 0, w, h)}
+	} else {
+		rgba := image.NewRGBA(image.Rect(0, 0, w, h))
+		for i := 0; i < w*h; i++ {
+			copy(rgba.Pix[i*4:], b[i*3:i*3+3])
+			rgba.Pix[i*4+3] = 0xff
+		}
+		img = rgba
+	}
+
+	if quality == 0 {
+		quality = jpeg.DefaultQuality
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// recompressImage applies rule r to the image sd rendered at dpi and returns true if sd got smaller.
+func recompressImage(sd *StreamDict, r ImageRule, kind string, comps int, dpi float64) (bool, error) {
+
+	w, h := sd.IntEntry("Width"), sd.IntEntry("Height")
+	if w == nil || h == nil || *w <= 0 || *h <= 0 {
+		return false, nil
+	}
+
+	if r.Compression == ImageJPEG {
+		if kind == ImageBilevel || comps == 4 {
+			return false, nil
+		}
+		// Lossy compression breaks color key masking.
+		if _, ok := sd.Dict["Mask"].(Array); ok {
+			return false, nil
+		}
+	}
+
+	if r.Compression == ImageCCITTG4 && kind != ImageBilevel {
+		return false, nil
+	}
+
+	b, err := imageSamples(sd, comps)
+	if err != nil || b == nil {
+		return false, err
+	}
+
+	nw, nh := *w, *h
+
+	size := nw * nh * comps
+	if kind == ImageBilevel {
+		size = (nw + 7) / 8 * nh
+	}
+	if len(b) < size {
+		log.Info.Println("recompressImage: corrupt image data")
+		return false, nil
+	}
+	b = b[:size]
+
+	// Bilevel images are not resampled.
+	if r.DPI > 0 && dpi > float64(r.DPI) && kind != ImageBilevel {
+		f := float64(r.DPI) / dpi
+		nw = int(math.Max(1, math.Round(float64(nw)*f)))
+		nh = int(math.Max(1, math.Round(float64(nh)*f)))
+		b = downsample(b, *w, *h, comps, nw, nh)
+	}
+
+	s := StreamDict{Dict: NewDict()}
+
+	switch r.Compression {
+
+	case ImageFlate:
+		s.FilterPipeline = []PDFFilter{{Name: filter.Flate}}
+		s.Content = b
+		err = encodeStream(&s)
+
+	case ImageCCITTG4:
+		parms := Dict(map[string]Object{"K": Integer(-1), "Columns": Integer(nw), "Rows": Integer(nh)})
+		s.FilterPipeline = []PDFFilter{{Name: filter.CCITTFax, DecodeParms: parms}}
+		s.Content = b
+		err = encodeStream(&s)
+
+	case ImageJPEG:
+		s.FilterPipeline = []PDFFilter{{Name: filter.DCT}}
+		s.Raw, err = encodeJPEG(b, nw, nh, comps, r.Quality)
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	if len(s.Raw) >= len(sd.Raw) && nw == *w && nh == *h {
+		return false, nil
+	}
+
+	sd.Raw, sd.Content = s.Raw, nil
+	sd.FilterPipeline = s.FilterPipeline
+
+	sd.Update("Filter", Name(s.FilterPipeline[0].Name))
+	sd.Delete("DecodeParms")
+	if d := s.FilterPipeline[0].DecodeParms; d != nil {
+		sd.Insert("DecodeParms", d)
+	}
+
+	sd.Update("Width", Integer(nw))
+	sd.Update("Height", Integer(nh))
+
+	streamLength := int64(len(sd.Raw))
+	sd.StreamLength = &streamLength
+	sd.Update("Length", Integer(streamLength))
+
+	return true, nil
+}
+
+// RecompressImages applies policy to the image XObjects of xRefTable.
+// Resolutions are taken from the page content, an image used more than once counts with its lowest resolution.
+// Images not drawn by page content only match rules without MinDPI.
+// A recompressed image is only kept if it got smaller or downsampled.
+// Returns the number of images recompressed.
+func RecompressImages(xRefTable *XRefTable, policy ImagePolicy) (int, error) {
+
+	if err := policy.Validate(); err != nil {
+		return 0, err
+	}
+
+	dpi, err := renderedImageResolutions(xRefTable)
+	if err != nil {
+		return 0, errors.Wrap(err, "RecompressImages")
+	}
+
+	var objNrs []int
+	masks := IntSet{}
+
+	for objNr, entry := range xRefTable.Table {
+		sd, ok := entry.Object.(StreamDict)
+		if entry.Free || !ok || !isImageStreamDict(sd) {
+			continue
+		}
+		objNrs = append(objNrs, objNr)
+		for _, k := range []string{"SMask", "Mask"} {
+			if ir, ok := sd.Dict[k].(IndirectRef); ok {
+				masks[ir.ObjectNumber.Value()] = true
+			}
+		}
+	}
+
+	sort.Ints(objNrs)
+
+	n := 0
+	var saved int64
+
+	for _, objNr := range objNrs {
+
+		// Soft masks and stencil masks belong to their images.
+		if masks[objNr] {
+			continue
+		}
+
+		entry := xRefTable.Table[objNr]
+		sd := entry.Object.(StreamDict)
+
+		kind, comps := imageKind(xRefTable, &sd)
+		if kind == "" {
+			continue
+		}
+
+		for _, r := range policy {
+
+			if !r.matches(kind, imageFilter(&sd), dpi[objNr]) {
+				continue
+			}
+
+			if r.Compression == ImageKeep {
+				break
+			}
+
+			size := int64(len(sd.Raw))
+
+			ok, err := recompressImage(&sd, r, kind, comps, dpi[objNr])
+			if err != nil {
+				return 0, errors.Wrapf(err, "RecompressImages: obj#%d", objNr)
+			}
+
+			if ok {
+				log.Debug.Printf("RecompressImages: obj#%d: %s %d -> %d bytes\n", objNr, r.Compression, size, len(sd.Raw))
+				saved += size - int64(len(sd.Raw))
+				entry.Object = sd
+				n++
+			}
+
+			break
+		}
+	}
+
+	log.Info.Printf("recompressed %d images saving %d bytes\n", n, saved)
+
+	return n, nil
+}
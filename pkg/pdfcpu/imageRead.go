@@ -20,7 +20,6 @@ import (
 	"image"
 	"image/color"
 	"image/png"
-	"os"
 
 	"github.com/jplu/pdfcpu/pkg/filter"
 	"github.com/jplu/pdfcpu/tiff"
@@ -261,7 +260,7 @@ func imgToImageDict(xRefTable *XRefTable, img image.Image) (*StreamDict, error)
 // and appends this object to the cross reference table.
 func ReadPNGFile(xRefTable *XRefTable, fileName string) (*StreamDict, error) {
 
-	f, err := os.Open(fileName)
+	f, err := xRefTable.fileSystem().Open(fileName)
 	if err != nil {
 		return nil, err
 	}
@@ -279,7 +278,7 @@ func ReadPNGFile(xRefTable *XRefTable, fileName string) (*StreamDict, error) {
 // and appends this object to the cross reference table.
 func ReadTIFFFile(xRefTable *XRefTable, fileName string) (*StreamDict, error) {
 
-	f, err := os.Open(fileName)
+	f, err := xRefTable.fileSystem().Open(fileName)
 	if err != nil {
 		return nil, err
 	}
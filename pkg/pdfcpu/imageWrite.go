@@ -25,9 +25,9 @@ import (
 	"github.com/pkg/errors"
 	"image"
 	"image/color"
+	"image/draw"
+	"image/jpeg"
 	"image/png"
-	"io/ioutil"
-	"os"
 )
 
 // Errors to be identified.
@@ -90,7 +90,13 @@ func pdfImage(xRefTable *XRefTable, sd *StreamDict, objNr int) (*PDFImage, error
 
 	bpc := *sd.IntEntry("BitsPerComponent")
 	if bpc == 16 {
-		return nil, ErrUnsupported16BPC
+		// Keep the most significant byte of each sample.
+		b := make([]byte, len(sd.Content)/2)
+		for i := range b {
+			b[i] = sd.Content[2*i]
+		}
+		sd.Content = b
+		bpc = 8
 	}
 
 	w := *sd.IntEntry("Width")
@@ -208,6 +214,65 @@ func streamBytes(sd *StreamDict) ([]byte, error) {
 	return sd.Content, nil
 }
 
+// decodeImageStream decodes the samples of an image stream.
+// Images encoded using DCT, JPX or JBIG2 only get any preceding filters like ASCII85 or Flate applied
+// and end up with their encoded data in sd.Raw and a filter pipeline of length 1.
+func decodeImageStream(sd *StreamDict) error {
+
+	f := imageFilter(sd)
+
+	if f != filter.DCT && f != filter.JPX && f != filter.JBIG2 {
+		return decodeStream(sd)
+	}
+
+	fpl := sd.FilterPipeline
+	if len(fpl) == 1 {
+		return nil
+	}
+
+	// Work on a copy so the original stream dict remains untouched.
+	sdc := *sd
+	sdc.FilterPipeline = fpl[:len(fpl)-1]
+	sdc.Content = nil
+
+	if err := decodeStream(&sdc); err != nil {
+		return err
+	}
+
+	sd.Raw = sdc.Content
+	sd.FilterPipeline = fpl[len(fpl)-1:]
+
+	return nil
+}
+
+// softMaskBytes returns the decoded samples of a soft mask, which may also be DCT encoded.
+func softMaskBytes(sd *StreamDict) ([]byte, error) {
+
+	fpl := sd.FilterPipeline
+	if len(fpl) != 1 || fpl[0].Name != filter.DCT {
+		return streamBytes(sd)
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(sd.Raw))
+	if err != nil {
+		log.Info.Printf("softMaskBytes: %v\n", err)
+		return nil, nil
+	}
+
+	g := image.NewGray(img.Bounds())
+	draw.Draw(g, g.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	return g.Pix, nil
+}
+
+// alpha returns the opacity of pixel x,y as defined by the soft mask of this image.
+func (im *PDFImage) alpha(x, y int) uint8 {
+	if im.softMask == nil || x >= im.w {
+		return 255
+	}
+	return im.softMask[y*im.w+x]
+}
+
 // Return the soft mask for this image or nil.
 func softMask(xRefTable *XRefTable, d *StreamDict, w, h, objNr int) ([]byte, error) {
 
@@ -226,27 +291,27 @@ func softMask(xRefTable *XRefTable, d *StreamDict, w, h, objNr int) ([]byte, err
 		return nil, err
 	}
 
-	sm, err := streamBytes(sd)
+	sm, err := softMaskBytes(sd)
 	if err != nil {
 		return nil, err
 	}
 
 	bpc := sd.IntEntry("BitsPerComponent")
 	if bpc == nil {
-		log.Info.Printf("softMask: obj#%d - ignoring soft mask without bpc\n%s\n", objNr, sd)
+		xRefTable.Warn("softMask", 0, objNr, "ignoring soft mask without bpc")
 		return nil, nil
 	}
 
 	// TODO support soft masks with bpc != 8
 	// Will need to return the softmask bpc to caller.
 	if *bpc != 8 {
-		log.Info.Printf("softMask: obj#%d - ignoring soft mask with bpc=%d\n", objNr, *bpc)
+		xRefTable.Warn("softMask", 0, objNr, "ignoring soft mask with bpc=%d", *bpc)
 		return nil, nil
 	}
 
 	if sm != nil {
 		if len(sm) != (*bpc*w*h+7)/8 {
-			log.Info.Printf("softMask: obj#%d - ignoring corrupt softmask\n%s\n", objNr, sd)
+			xRefTable.Warn("softMask", 0, objNr, "ignoring corrupt soft mask")
 			return nil, nil
 		}
 	}
@@ -254,62 +319,94 @@ func softMask(xRefTable *XRefTable, d *StreamDict, w, h, objNr int) ([]byte, err
 	return sm, nil
 }
 
-func writeImgToJPG(filename string, sd *StreamDict, isFile bool) (string, []byte, error) {
-	if isFile {
-		filename += ".jpg"
+func writeImgToJPG(filename string, sd *StreamDict) (string, []byte, error) {
+	filename += ".jpg"
 
-		return filename, nil, ioutil.WriteFile(filename, sd.Raw, os.ModePerm)
-	} else {
-		return "", sd.Raw, nil
-	}
+	return filename, sd.Raw, nil
 }
 
-func writeImgToJPX(filename string, sd *StreamDict, isFile bool) (string, []byte, error) {
-	if isFile {
-		filename += ".jpx"
+func writeImgToJPX(filename string, sd *StreamDict) (string, []byte, error) {
+	filename += ".jpx"
 
-		return filename, nil, ioutil.WriteFile(filename, sd.Raw, os.ModePerm)
-	} else {
-		return "", sd.Raw, nil
-	}
+	return filename, sd.Raw, nil
 }
 
-func writeImgToTIFF(filename string, img *image.CMYK, isFile bool) (string, []byte, error) {
-	if isFile {
-		filename += ".tif"
+// writeImgToJBIG2 writes a JBIG2 embedded stream as standalone JBIG2 file in sequential organization
+// preceded by the global segments referred to by JBIG2Globals, see 7.4.7 and ITU T.88 Annex D.
+func writeImgToJBIG2(xRefTable *XRefTable, filename string, sd *StreamDict) (string, []byte, error) {
+	filename += ".jb2"
 
-		f, err := os.Create(filename)
-		if err != nil {
-			return "", nil, err
-		}
-		defer f.Close()
+	var b bytes.Buffer
 
-		return filename, nil, tiff.Encode(f, img, nil)
-	} else {
-		var b bytes.Buffer
-		writer := bufio.NewWriter(&b)
+	// File header: ID string, flags for sequential organization and known number of pages, one page.
+	b.Write([]byte{0x97, 0x4A, 0x42, 0x32, 0x0D, 0x0A, 0x1A, 0x0A, 0x01, 0x00, 0x00, 0x00, 0x01})
 
-		err := tiff.Encode(writer, img, nil)
-		if err != nil {
-			return "", nil, err
+	if d := sd.FilterPipeline[0].DecodeParms; d != nil {
+		if o, found := d.Find("JBIG2Globals"); found {
+			gsd, err := xRefTable.DereferenceStreamDict(o)
+			if err != nil {
+				return "", nil, err
+			}
+			if gsd != nil {
+				// Work on a copy so images may be extracted concurrently.
+				g := *gsd
+				if err = decodeStream(&g); err != nil {
+					return "", nil, err
+				}
+				b.Write(g.Content)
+			}
 		}
+	}
+
+	b.Write(sd.Raw)
+
+	return filename, b.Bytes(), nil
+}
+
+func writeImgToTIFF(filename string, img *image.CMYK) (string, []byte, error) {
+	filename += ".tif"
 
-		err = writer.Flush()
+	var b bytes.Buffer
+	writer := bufio.NewWriter(&b)
 
-		return "", b.Bytes(), err
+	err := tiff.Encode(writer, img, nil)
+	if err != nil {
+		return "", nil, err
 	}
+
+	err = writer.Flush()
+
+	return filename, b.Bytes(), err
 }
 
-func writeDeviceCMYKToTIFF(filename string, im *PDFImage, isFile bool) (string, []byte, error) {
+func writeDeviceCMYKToTIFF(filename string, im *PDFImage) (string, []byte, error) {
 	b := im.sd.Content
 
 	log.Debug.Printf("writeDeviceCMYKToTIFF: CMYK objNr=%d w=%d h=%d bpc=%d buflen=%d\n", im.objNr, im.w, im.h, im.bpc, len(b))
 
+	if len(b) < (4*im.bpc*im.w*im.h+7)/8 {
+		return "", nil, errors.Errorf("writeDeviceCMYKToTIFF: objNr=%d corrupt image object\n", im.objNr)
+	}
+
+	// TIFF does not take an alpha channel for CMYK, so images with a soft mask are written as RGBA PNG.
+	if im.softMask != nil {
+		img := image.NewNRGBA(image.Rect(0, 0, im.w, im.h))
+		i := 0
+		for y := 0; y < im.h; y++ {
+			for x := 0; x < im.w; x++ {
+				r, g, bl := color.CMYKToRGB(b[i], b[i+1], b[i+2], b[i+3])
+				img.Set(x, y, color.NRGBA{R: r, G: g, B: bl, A: im.alpha(x, y)})
+				i += 4
+			}
+		}
+		return writeImgToPNG(filename, img)
+	}
+
 	img := image.NewCMYK(image.Rect(0, 0, im.w, im.h))
 
 	i := 0
 
-	// TODO support bpc, decode and softMask.
+	// TODO support bpc and decode.
 
 	for y := 0; y < im.h; y++ {
 		for x := 0; x < im.w; x++ {
@@ -318,36 +415,26 @@ func writeDeviceCMYKToTIFF(filename string, im *PDFImage, isFile bool) (string,
 		}
 	}
 
-	return writeImgToTIFF(filename, img, isFile)
+	return writeImgToTIFF(filename, img)
 }
 
-func writeImgToPNG(filename string, img image.Image, isFile bool) (string, []byte, error) {
-	if isFile {
-		filename += ".png"
-
-		f, err := os.Create(filename)
-		if err != nil {
-			return "", nil, err
-		}
-		defer f.Close()
+func writeImgToPNG(filename string, img image.Image) (string, []byte, error) {
+	filename += ".png"
 
-		return filename, nil, png.Encode(f, img)
-	} else {
-		var b bytes.Buffer
-		writer := bufio.NewWriter(&b)
+	var b bytes.Buffer
+	writer := bufio.NewWriter(&b)
 
-		err := png.Encode(writer, img)
-		if err != nil {
-			return "", nil, err
-		}
+	err := png.Encode(writer, img)
+	if err != nil {
+		return "", nil, err
+	}
 
-		err = writer.Flush()
+	err = writer.Flush()
 
-		return "", b.Bytes(), err
-	}
+	return filename, b.Bytes(), err
 }
 
-func writeDeviceGrayToPNG(filename string, im *PDFImage, isFile bool) (string, []byte, error) {
+func writeDeviceGrayToPNG(filename string, im *PDFImage) (string, []byte, error) {
 
 	b := im.sd.Content
 
@@ -359,9 +446,12 @@ func writeDeviceGrayToPNG(filename string, im *PDFImage, isFile bool) (string, [
 		return "", nil, errors.Errorf("writeDeviceGrayToPNG: objNr=%d corrupt image object %v\n", im.objNr, *im.sd)
 	}
 
-	img := image.NewGray(image.Rect(0, 0, im.w, im.h))
+	// Gray images with a soft mask are written as RGBA.
+	var img draw.Image = image.NewGray(image.Rect(0, 0, im.w, im.h))
+	if im.softMask != nil {
+		img = image.NewNRGBA(image.Rect(0, 0, im.w, im.h))
+	}
 
-	// TODO support softmask.
 	i := 0
 	for y := 0; y < im.h; y++ {
 		for x := 0; x < im.w; {
@@ -370,7 +460,11 @@ func writeDeviceGrayToPNG(filename string, im *PDFImage, isFile bool) (string, [
 				pix := p >> (8 - uint8(im.bpc))
 				v := decodePixelColorValue(pix, im.bpc, 0, im.decode)
 				//fmt.Printf("x=%d y=%d pix=#%02x v=#%02x\n", x, y, pix, v)
-				img.Set(x, y, color.Gray{Y: v})
+				if im.softMask != nil {
+					img.Set(x, y, color.NRGBA{R: v, G: v, B: v, A: im.alpha(x, y)})
+				} else {
+					img.Set(x, y, color.Gray{Y: v})
+				}
 				p <<= uint8(im.bpc)
 				x++
 			}
@@ -378,10 +472,10 @@ func writeDeviceGrayToPNG(filename string, im *PDFImage, isFile bool) (string, [
 		}
 	}
 
-	return writeImgToPNG(filename, img, isFile)
+	return writeImgToPNG(filename, img)
 }
 
-func writeDeviceRGBToPNG(filename string, im *PDFImage, isFile bool) (string, []byte, error) {
+func writeDeviceRGBToPNG(filename string, im *PDFImage) (string, []byte, error) {
 
 	b := im.sd.Content
 
@@ -399,19 +493,15 @@ func writeDeviceRGBToPNG(filename string, im *PDFImage, isFile bool) (string, []
 	i := 0
 	for y := 0; y < im.h; y++ {
 		for x := 0; x < im.w; x++ {
-			alpha := uint8(255)
-			if im.softMask != nil {
-				alpha = im.softMask[y*im.w+x]
-			}
-			img.Set(x, y, color.NRGBA{R: b[i], G: b[i+1], B: b[i+2], A: alpha})
+			img.Set(x, y, color.NRGBA{R: b[i], G: b[i+1], B: b[i+2], A: im.alpha(x, y)})
 			i += 3
 		}
 	}
 
-	return writeImgToPNG(filename, img, isFile)
+	return writeImgToPNG(filename, img)
 }
 
-func writeCalRGBToPNG(filename string, im *PDFImage, isFile bool) (string, []byte, error) {
+func writeCalRGBToPNG(filename string, im *PDFImage) (string, []byte, error) {
 
 	b := im.sd.Content
 
@@ -425,19 +515,19 @@ func writeCalRGBToPNG(filename string, im *PDFImage, isFile bool) (string, []byt
 	// This information can be validated against the iccProfile.
 
 	// RGB
-	// TODO Support bpc, decode and softmask.
+	// TODO Support bpc and decode.
 	img := image.NewNRGBA(image.Rect(0, 0, im.w, im.h))
 	i := 0
 	for y := 0; y < im.h; y++ {
 		for x := 0; x < im.w; x++ {
-			img.Set(x, y, color.NRGBA{R: b[i], G: b[i+1], B: b[i+2], A: 255})
+			img.Set(x, y, color.NRGBA{R: b[i], G: b[i+1], B: b[i+2], A: im.alpha(x, y)})
 			i += 3
 		}
 	}
-	return writeImgToPNG(filename, img, isFile)
+	return writeImgToPNG(filename, img)
 }
 
-func writeICCBased(xRefTable *XRefTable, filename string, im *PDFImage, cs Array, isFile bool) (string, []byte, error) {
+func writeICCBased(xRefTable *XRefTable, filename string, im *PDFImage, cs Array) (string, []byte, error) {
 
 	//  Any ICC profile >= ICC.1:2004:10 is sufficient for any PDF version <= 1.7
 	//  If the embedded ICC profile version is newer than the one used by the Reader, substitute with Alternate color space.
@@ -468,21 +558,21 @@ func writeICCBased(xRefTable *XRefTable, filename string, im *PDFImage, cs Array
 	switch n {
 	case 1:
 		// Gray
-		return writeDeviceGrayToPNG(filename, im, isFile)
+		return writeDeviceGrayToPNG(filename, im)
 
 	case 3:
 		// RGB
-		return writeDeviceRGBToPNG(filename, im, isFile)
+		return writeDeviceRGBToPNG(filename, im)
 
 	case 4:
 		// CMYK
-		return writeDeviceCMYKToTIFF(filename, im, isFile)
+		return writeDeviceCMYKToTIFF(filename, im)
 	}
 
 	return "", nil, nil
 }
 
-func writeIndexedRGBToPNG(filename string, im *PDFImage, lookup []byte, isfile bool) (string, []byte, error) {
+func writeIndexedRGBToPNG(filename string, im *PDFImage, lookup []byte) (string, []byte, error) {
 
 	b := im.sd.Content
 
@@ -497,12 +587,8 @@ func writeIndexedRGBToPNG(filename string, im *PDFImage, lookup []byte, isfile b
 			for j := 0; j < 8/im.bpc; j++ {
 				ind := p >> (8 - uint8(im.bpc))
 				//fmt.Printf("x=%d y=%d i=%d j=%d p=#%02x ind=#%02x\n", x, y, i, j, p, ind)
-				alpha := uint8(255)
-				if im.softMask != nil {
-					alpha = im.softMask[y*im.w+x]
-				}
 				l := 3 * int(ind)
-				img.Set(x, y, color.NRGBA{R: lookup[l], G: lookup[l+1], B: lookup[l+2], A: alpha})
+				img.Set(x, y, color.NRGBA{R: lookup[l], G: lookup[l+1], B: lookup[l+2], A: im.alpha(x, y)})
 				p <<= uint8(im.bpc)
 				x++
 			}
@@ -510,10 +596,10 @@ func writeIndexedRGBToPNG(filename string, im *PDFImage, lookup []byte, isfile b
 		}
 	}
 
-	return writeImgToPNG(filename, img, isfile)
+	return writeImgToPNG(filename, img)
 }
 
-func writeIndexedCMYKToTIFF(filename string, im *PDFImage, lookup []byte, isFile bool) (string, []byte, error) {
+func writeIndexedCMYKToTIFF(filename string, im *PDFImage, lookup []byte) (string, []byte, error) {
 
 	b := im.sd.Content
 
@@ -537,10 +623,10 @@ func writeIndexedCMYKToTIFF(filename string, im *PDFImage, lookup []byte, isFile
 		}
 	}
 
-	return writeImgToTIFF(filename, img, isFile)
+	return writeImgToTIFF(filename, img)
 }
 
-func writeIndexedNameCS(filename string, im *PDFImage, cs Name, maxInd int, lookup []byte, isFile bool) (string, []byte, error) {
+func writeIndexedNameCS(filename string, im *PDFImage, cs Name, maxInd int, lookup []byte) (string, []byte, error) {
 
 	switch cs {
 
@@ -550,7 +636,7 @@ func writeIndexedNameCS(filename string, im *PDFImage, cs Name, maxInd int, look
 			return "", nil, errors.Errorf("writeIndexedNameCS: objNr=%d, corrupt DeviceRGB lookup table\n", im.objNr)
 		}
 
-		return writeIndexedRGBToPNG(filename, im, lookup, isFile)
+		return writeIndexedRGBToPNG(filename, im, lookup)
 
 	case DeviceCMYKCS:
 
@@ -558,7 +644,7 @@ func writeIndexedNameCS(filename string, im *PDFImage, cs Name, maxInd int, look
 			return "", nil, errors.Errorf("writeIndexedNameCS: objNr=%d, corrupt DeviceCMYK lookup table\n", im.objNr)
 		}
 
-		return writeIndexedCMYKToTIFF(filename, im, lookup, isFile)
+		return writeIndexedCMYKToTIFF(filename, im, lookup)
 	}
 
 	log.Info.Printf("writeIndexedNameCS: objNr=%d, unsupported base colorspace %s\n", im.objNr, cs.String())
@@ -566,7 +652,7 @@ func writeIndexedNameCS(filename string, im *PDFImage, cs Name, maxInd int, look
 	return "", nil, ErrUnsupportedColorSpace
 }
 
-func writeIndexedArrayCS(xRefTable *XRefTable, filename string, im *PDFImage, csa Array, maxInd int, lookup []byte, isFile bool) (string, []byte, error) {
+func writeIndexedArrayCS(xRefTable *XRefTable, filename string, im *PDFImage, csa Array, maxInd int, lookup []byte) (string, []byte, error) {
 
 	b := im.sd.Content
 
@@ -606,16 +692,16 @@ func writeIndexedArrayCS(xRefTable *XRefTable, filename string, im *PDFImage, cs
 					i++
 				}
 			}
-			return writeImgToPNG(filename, img, isFile)
+			return writeImgToPNG(filename, img)
 
 		case 3:
 			// RGB
-			return writeIndexedRGBToPNG(filename, im, lookup, isFile)
+			return writeIndexedRGBToPNG(filename, im, lookup)
 
 		case 4:
 			// CMYK
 			log.Debug.Printf("writeIndexedArrayCS: CMYK objNr=%d w=%d h=%d bpc=%d buflen=%d\n", im.objNr, im.w, im.h, im.bpc, len(b))
-			return writeIndexedCMYKToTIFF(filename, im, lookup, isFile)
+			return writeIndexedCMYKToTIFF(filename, im, lookup)
 		}
 	}
 
@@ -624,7 +710,7 @@ func writeIndexedArrayCS(xRefTable *XRefTable, filename string, im *PDFImage, cs
 	return "", nil, ErrUnsupportedColorSpace
 }
 
-func writeIndexed(xRefTable *XRefTable, filename string, im *PDFImage, cs Array, isFile bool) (string, []byte, error) {
+func writeIndexed(xRefTable *XRefTable, filename string, im *PDFImage, cs Array) (string, []byte, error) {
 
 	// Identify the base color space.
 	baseCS, _ := xRefTable.Dereference(cs[1])
@@ -656,16 +742,16 @@ func writeIndexed(xRefTable *XRefTable, filename string, im *PDFImage, cs Array,
 
 	switch cs := baseCS.(type) {
 	case Name:
-		return writeIndexedNameCS(filename, im, cs, maxInd.Value(), lookup, isFile)
+		return writeIndexedNameCS(filename, im, cs, maxInd.Value(), lookup)
 
 	case Array:
-		return writeIndexedArrayCS(xRefTable, filename, im, cs, maxInd.Value(), lookup, isFile)
+		return writeIndexedArrayCS(xRefTable, filename, im, cs, maxInd.Value(), lookup)
 	}
 
 	return "", nil, nil
 }
 
-func writeFlateEncodedImage(xRefTable *XRefTable, filename string, sd *StreamDict, objNr int, isFile bool) (string, []byte, error) {
+func writeFlateEncodedImage(xRefTable *XRefTable, filename string, sd *StreamDict, objNr int) (string, []byte, error) {
 
 	pdfImage, err := pdfImage(xRefTable, sd, objNr)
 	if err != nil {
@@ -686,13 +772,13 @@ func writeFlateEncodedImage(xRefTable *XRefTable, filename string, sd *StreamDic
 		switch cs {
 
 		case DeviceGrayCS:
-			im, fn, err = writeDeviceGrayToPNG(filename, pdfImage, isFile)
+			im, fn, err = writeDeviceGrayToPNG(filename, pdfImage)
 
 		case DeviceRGBCS:
-			im, fn, err = writeDeviceRGBToPNG(filename, pdfImage, isFile)
+			im, fn, err = writeDeviceRGBToPNG(filename, pdfImage)
 
 		case DeviceCMYKCS:
-			im, fn, err = writeDeviceCMYKToTIFF(filename, pdfImage, isFile)
+			im, fn, err = writeDeviceCMYKToTIFF(filename, pdfImage)
 
 		default:
 			log.Info.Printf("writeFlateEncodedImage: objNr=%d, unsupported name colorspace %s\n", objNr, cs.String())
@@ -705,13 +791,13 @@ func writeFlateEncodedImage(xRefTable *XRefTable, filename string, sd *StreamDic
 		switch csn {
 
 		case CalRGBCS:
-			im, fn, err = writeCalRGBToPNG(filename, pdfImage, isFile)
+			im, fn, err = writeCalRGBToPNG(filename, pdfImage)
 
 		case ICCBasedCS:
-			im, fn, err = writeICCBased(xRefTable, filename, pdfImage, cs, isFile)
+			im, fn, err = writeICCBased(xRefTable, filename, pdfImage, cs)
 
 		case IndexedCS:
-			im, fn, err = writeIndexed(xRefTable, filename, pdfImage, cs, isFile)
+			im, fn, err = writeIndexed(xRefTable, filename, pdfImage, cs)
 
 		default:
 			log.Info.Printf("writeFlateEncodedImage: objNr=%d, unsupported array colorspace %s\n", objNr, csn)
@@ -724,29 +810,91 @@ func writeFlateEncodedImage(xRefTable *XRefTable, filename string, sd *StreamDic
 	return im, fn, err
 }
 
-// WriteImage writes a PDF image object to disk.
+// writeDCTWithSoftMaskToPNG composites a DCT encoded image and its soft mask into a RGBA PNG.
+// Falls back to writing the plain JPEG if the soft mask is unusable.
+func writeDCTWithSoftMaskToPNG(xRefTable *XRefTable, filename string, sd *StreamDict, objNr int) (string, []byte, error) {
+
+	img, err := jpeg.Decode(bytes.NewReader(sd.Raw))
+	if err != nil {
+		log.Info.Printf("writeDCTWithSoftMaskToPNG: objNr=%d %v\n", objNr, err)
+		return writeImgToJPG(filename, sd)
+	}
+
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+
+	sm, err := softMask(xRefTable, sd, w, h, objNr)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if sm == nil {
+		return writeImgToJPG(filename, sd)
+	}
+
+	rgba := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	for i, a := range sm {
+		rgba.Pix[4*i+3] = a
+	}
+
+	return writeImgToPNG(filename, rgba)
+}
+
+// WriteImage writes a PDF image object to the file system of xRefTable or returns its encoded bytes if isFile is false.
+// The filename returned includes the extension for the image format chosen.
+// sd is expected to be decoded using ExtractImageData.
+// Images get written as PNG, TIFF for CMYK, JPEG, JPEG2000 or JBIG2.
 func WriteImage(xRefTable *XRefTable, filename string, sd *StreamDict, objNr int, isFile bool) (string, []byte, error) {
 
-	switch sd.FilterPipeline[0].Name {
+	fn, b, err := writeImage(xRefTable, filename, sd, objNr)
+	if err != nil || !isFile || b == nil {
+		return fn, b, err
+	}
 
-	case filter.Flate, filter.CCITTFax:
-		// If color space is CMYK then write .tif else write .png
-		im, fn, err := writeFlateEncodedImage(xRefTable, filename, sd, objNr, isFile)
-		if err != nil {
-			if err == ErrUnsupportedColorSpace {
-				log.Info.Printf("Image obj#%d uses an unsupported color space. Please see the logfile for details.\n", objNr)
-				err = nil
+	return fn, nil, WriteFile(xRefTable.fileSystem(), fn, b, ExtractFilePerm)
+}
+
+func writeImage(xRefTable *XRefTable, filename string, sd *StreamDict, objNr int) (string, []byte, error) {
+
+	switch imageFilter(sd) {
+
+	case filter.DCT, filter.JPX, filter.JBIG2:
+		if len(sd.FilterPipeline) > 1 {
+			if err := decodeImageStream(sd); err != nil {
+				return "", nil, err
 			}
 		}
-		return im, fn, err
+
+	default:
+		if err := decodeStream(sd); err != nil {
+			return "", nil, err
+		}
+	}
+
+	switch imageFilter(sd) {
 
 	case filter.DCT:
-		return writeImgToJPG(filename, sd, isFile)
+		if o, _ := sd.Find("SMask"); o != nil {
+			return writeDCTWithSoftMaskToPNG(xRefTable, filename, sd, objNr)
+		}
+		return writeImgToJPG(filename, sd)
 
 	case filter.JPX:
-		return writeImgToJPX(filename, sd, isFile)
+		return writeImgToJPX(filename, sd)
 
-	}
+	case filter.JBIG2:
+		return writeImgToJBIG2(xRefTable, filename, sd)
 
-	return "", nil, nil
+	default:
+		// The samples are decoded, if color space is CMYK then write .tif else write .png
+		im, fn, err := writeFlateEncodedImage(xRefTable, filename, sd, objNr)
+		if err != nil {
+			if err == ErrUnsupportedColorSpace {
+				xRefTable.Warn("writeImage", 0, objNr, "unsupported color space, please see the logfile for details")
+				err = nil
+			}
+		}
+		return im, fn, err
+	}
 }
@@ -406,3 +406,58 @@ func TestReadTIFFWritePNG(t *testing.T) {
 	}
 
 }
+
+// A JBIG2 embedded stream preceded by a Flate filter gets written as standalone JBIG2 file including its global segments.
+func TestWriteJBIG2(t *testing.T) {
+
+	globals := []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00}
+	page := []byte{0x00, 0x00, 0x00, 0x01, 0x30, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00}
+
+	gsd := &StreamDict{Dict: NewDict(), Content: globals}
+	if err := encodeStream(gsd); err != nil {
+		t.Fatalf("err: %v\n", err)
+	}
+
+	ir, err := xRefTable.IndRefForNewObject(*gsd)
+	if err != nil {
+		t.Fatalf("err: %v\n", err)
+	}
+
+	sd := &StreamDict{
+		Dict: Dict(
+			map[string]Object{
+				"Type":    Name("XObject"),
+				"Subtype": Name("Image"),
+				"Width":   Integer(8),
+				"Height":  Integer(8),
+			},
+		),
+		Content: page,
+		FilterPipeline: []PDFFilter{
+			{Name: filter.Flate},
+			{Name: filter.JBIG2, DecodeParms: Dict(map[string]Object{"JBIG2Globals": *ir})}}}
+
+	// Flate encode the JBIG2 data only.
+	sdc := *sd
+	sdc.FilterPipeline = sd.FilterPipeline[:1]
+	if err = encodeStream(&sdc); err != nil {
+		t.Fatalf("err: %v\n", err)
+	}
+	sd.Raw, sd.Content = sdc.Raw, nil
+
+	fn, b, err := WriteImage(xRefTable, "test", sd, 0, false)
+	if err != nil {
+		t.Fatalf("err: %v\n", err)
+	}
+
+	if fn != "test.jb2" {
+		t.Fatalf("want test.jb2, got %s\n", fn)
+	}
+
+	header := []byte{0x97, 0x4A, 0x42, 0x32, 0x0D, 0x0A, 0x1A, 0x0A, 0x01, 0x00, 0x00, 0x00, 0x01}
+	want := append(append(header, globals...), page...)
+
+	if string(b) != string(want) {
+		t.Fatalf("want:\n% x\ngot:\n% x\n", want, b)
+	}
+}
@@ -17,8 +17,8 @@ limitations under the License.
 package pdfcpu
 
 import (
+	"fmt"
 	"strings"
-	"time"
 
 	"github.com/jplu/pdfcpu/pkg/log"
 )
@@ -91,19 +91,23 @@ func ensureInfoDict(ctx *Context) error {
 	// Subject              -
 	// Keywords             -
 	// Creator              -
-	// Producer		        modified by pdfcpu
-	// CreationDate	        modified by pdfcpu
-	// ModDate		        modified by pdfcpu
+	// Producer		        modified by pdfcpu unless PreserveProducer
+	// CreationDate	        modified by pdfcpu unless SuppressModDate
+	// ModDate		        modified by pdfcpu unless SuppressModDate
 	// Trapped              -
 
-	now := DateString(time.Now())
+	now := DateString(ctx.CurrentTime())
 
 	if ctx.Info == nil {
 
 		d := NewDict()
-		d.InsertString("Producer", PDFCPULongVersion)
-		d.InsertString("CreationDate", now)
-		d.InsertString("ModDate", now)
+		if !ctx.PreserveProducer {
+			d.InsertString("Producer", producer(ctx))
+		}
+		if !ctx.SuppressModDate {
+			d.InsertString("CreationDate", now)
+			d.InsertString("ModDate", now)
+		}
 
 		ir, err := ctx.IndRefForNewObject(d)
 		if err != nil {
@@ -125,13 +129,80 @@ func ensureInfoDict(ctx *Context) error {
 		return err
 	}
 
-	d.Update("CreationDate", StringLiteral(now))
-	d.Update("ModDate", StringLiteral(now))
-	d.Update("Producer", StringLiteral(PDFCPULongVersion))
+	if !ctx.SuppressModDate {
+		d.Update("CreationDate", StringLiteral(now))
+		d.Update("ModDate", StringLiteral(now))
+	}
+
+	if !ctx.PreserveProducer {
+		s, err := textString(producer(ctx))
+		if err != nil {
+			return err
+		}
+		d.Update("Producer", s)
+	}
 
 	return nil
 }
 
+// PageProvenance describes a range of pages taken over from a source file.
+type PageProvenance struct {
+	FileName   string // Base name of the source file.
+	From, Thru int    // Page range within the source file.
+	At         int    // Page number of From within the resulting file.
+}
+
+func (pp PageProvenance) String() string {
+
+	if pp.From == pp.Thru {
+		return fmt.Sprintf("%s page %d as %d", pp.FileName, pp.From, pp.At)
+	}
+
+	return fmt.Sprintf("%s pages %d-%d as %d-%d", pp.FileName, pp.From, pp.Thru, pp.At, pp.At+pp.Thru-pp.From)
+}
+
+// RecordProvenance records where the pages of ctx come from
+// in the document info dict entry "Provenance", eg. "in.pdf pages 3-4 as 1-2; other.pdf page 1 as 3".
+func RecordProvenance(ctx *Context, pp []PageProvenance) error {
+
+	ss := make([]string, len(pp))
+	for i, p := range pp {
+		ss[i] = p.String()
+	}
+
+	s, err := textString(strings.Join(ss, "; "))
+	if err != nil {
+		return err
+	}
+
+	if ctx.Info == nil {
+		ir, err := ctx.IndRefForNewObject(NewDict())
+		if err != nil {
+			return err
+		}
+		ctx.Info = ir
+	}
+
+	d, err := ctx.DereferenceDict(*ctx.Info)
+	if err != nil || d == nil {
+		return err
+	}
+
+	d.Update("Provenance", s)
+
+	return nil
+}
+
+// producer returns the Producer to be written into the document info dict.
+func producer(ctx *Context) string {
+
+	if ctx.CustomProducer != "" {
+		return ctx.CustomProducer
+	}
+
+	return PDFCPULongVersion
+}
+
 // Write the document info object for this PDF file.
 func writeDocumentInfoDict(ctx *Context) error {
 
@@ -0,0 +1,633 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/jplu/pdfcpu/pkg/filter"
+	"github.com/jplu/pdfcpu/pkg/log"
+	"github.com/pkg/errors"
+)
+
+// InlineImage represents an image embedded into a content stream using the operators BI, ID and EI.
+// See 8.9.7 Inline Images
+type InlineImage struct {
+	Dict       Dict   // The image dict as found, abbreviations included.
+	Data       []byte // The image data, encoded as specified by the image dict.
+	start, end int    // The range of the content stream covered by BI through EI.
+}
+
+// Abbreviated keys of inline image dicts, see Table 93.
+var inlineImageKeys = map[string]string{
+	"BPC": "BitsPerComponent",
+	"CS":  "ColorSpace",
+	"D":   "Decode",
+	"DP":  "DecodeParms",
+	"F":   "Filter",
+	"H":   "Height",
+	"IM":  "ImageMask",
+	"I":   "Interpolate",
+	"L":   "Length",
+	"W":   "Width",
+}
+
+// Abbreviated color space and filter names of inline image dicts, see Table 94.
+var inlineImageNames = map[string]string{
+	"G":    "DeviceGray",
+	"RGB":  "DeviceRGB",
+	"CMYK": "DeviceCMYK",
+	"I":    "Indexed",
+	"AHx":  filter.ASCIIHex,
+	"A85":  filter.ASCII85,
+	"LZW":  filter.LZW,
+	"Fl":   filter.Flate,
+	"RL":   filter.RunLength,
+	"CCF":  filter.CCITTFax,
+	"DCT":  filter.DCT,
+}
+
+// InlineImageKey returns the unabbreviated form of an inline image dict key
+// and false for keys not allowed in inline image dicts.
+func InlineImageKey(k string) (string, bool) {
+
+	if s, ok := inlineImageKeys[k]; ok {
+		return s, true
+	}
+
+	for _, s := range inlineImageKeys {
+		if s == k {
+			return s, true
+		}
+	}
+
+	return k, k == "Intent"
+}
+
+func expandInlineImageName(o Object) Object {
+
+	switch o := o.(type) {
+
+	case Name:
+		if s, ok := inlineImageNames[o.Value()]; ok {
+			return Name(s)
+		}
+
+	case Array:
+		a := make(Array, len(o))
+		for i, v := range o {
+			a[i] = v
+			if i == 0 || o[0] != Name("I") && o[0] != Name("Indexed") {
+				a[i] = expandInlineImageName(v)
+			}
+		}
+		return a
+
+	}
+
+	return o
+}
+
+// ImageDict returns the image dict with all abbreviations expanded.
+func (img InlineImage) ImageDict() Dict {
+
+	d := Dict{}
+
+	for k, v := range img.Dict {
+		k, _ = InlineImageKey(k)
+		if k == "ColorSpace" || k == "Filter" {
+			v = expandInlineImageName(v)
+		}
+		d[k] = v
+	}
+
+	return d
+}
+
+// The number of bytes of unfiltered image data or -1 if unknown.
+func inlineImageSize(d Dict) int {
+
+	w, h := d.IntEntry("Width"), d.IntEntry("Height")
+	if w == nil || h == nil {
+		return -1
+	}
+
+	bpc, comps := 1, 1
+
+	if b := d.BooleanEntry("ImageMask"); b == nil || !*b {
+
+		if d.IntEntry("BitsPerComponent") == nil {
+			return -1
+		}
+		bpc = *d.IntEntry("BitsPerComponent")
+
+		switch cs := d["ColorSpace"].(type) {
+		case Name:
+			switch cs {
+			case "DeviceGray":
+				comps = 1
+			case "DeviceRGB":
+				comps = 3
+			case "DeviceCMYK":
+				comps = 4
+			default:
+				return -1
+			}
+		case Array:
+			if len(cs) == 0 || cs[0] != Name("Indexed") {
+				return -1
+			}
+		default:
+			return -1
+		}
+	}
+
+	return *h * ((*w*bpc*comps + 7) / 8)
+}
+
+// endOfInlineImage returns true if "EI" follows at i, possibly preceded by whitespace.
+func endOfInlineImage(b []byte, i int) (int, bool) {
+
+	for i < len(b) && contentWhitespace(b[i]) {
+		i++
+	}
+
+	if !bytes.HasPrefix(b[i:], []byte("EI")) {
+		return 0, false
+	}
+
+	i += 2
+
+	return i, i == len(b) || contentWhitespace(b[i]) || contentDelimiter(b[i])
+}
+
+// inlineImage parses the inline image whose BI operator starts at start and ends at s.pos.
+func (s *contentScanner) inlineImage(start int) (*InlineImage, error) {
+
+	from := s.pos
+
+	var to int
+	for {
+		tok, pos, err := s.next()
+		if err == io.EOF {
+			return nil, errors.New("inlineImage: missing ID")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if string(tok) == "ID" {
+			to = pos
+			break
+		}
+	}
+
+	str := "<<" + string(s.b[from:to]) + ">>"
+	o, err := parseObject(&str)
+	if err != nil {
+		return nil, errors.Wrap(err, "inlineImage: corrupt image dict")
+	}
+
+	d, ok := o.(Dict)
+	if !ok {
+		return nil, errors.New("inlineImage: corrupt image dict")
+	}
+
+	// A single whitespace separates ID from the image data.
+	if s.pos >= len(s.b) || !contentWhitespace(s.b[s.pos]) {
+		return nil, errors.New("inlineImage: missing whitespace after ID")
+	}
+	s.pos++
+
+	img := &InlineImage{Dict: d, start: start}
+	id := img.ImageDict()
+
+	// If we know the size of the image data we do not have to guess where it ends.
+	size := -1
+	if l := id.IntEntry("Length"); l != nil {
+		size = *l
+	} else if _, found := id.Find("Filter"); !found {
+		size = inlineImageSize(id)
+	}
+
+	if size >= 0 && s.pos+size <= len(s.b) {
+		if end, ok := endOfInlineImage(s.b, s.pos+size); ok {
+			img.Data = s.b[s.pos : s.pos+size]
+			img.end = end
+			s.pos = end
+			return img, nil
+		}
+	}
+
+	// Look for EI surrounded by whitespace.
+	for i := s.pos; i+2 <= len(s.b); i++ {
+
+		if s.b[i] != 'E' || s.b[i+1] != 'I' || i == s.pos || !contentWhitespace(s.b[i-1]) {
+			continue
+		}
+
+		if i+2 < len(s.b) && !contentWhitespace(s.b[i+2]) && !contentDelimiter(s.b[i+2]) {
+			continue
+		}
+
+		j := i - 1
+		if j > s.pos && s.b[j] == 0x0A && s.b[j-1] == 0x0D {
+			j--
+		}
+
+		img.Data = s.b[s.pos:j]
+		img.end = i + 2
+		s.pos = i + 2
+
+		return img, nil
+	}
+
+	return nil, errors.New("inlineImage: missing EI")
+}
+
+// ParseInlineImages returns the inline images of a decoded content stream.
+func ParseInlineImages(content []byte) ([]InlineImage, error) {
+
+	var imgs []InlineImage
+
+	s := &contentScanner{b: content}
+
+	for {
+
+		tok, pos, err := s.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if string(tok) != "BI" {
+			continue
+		}
+
+		img, err := s.inlineImage(pos)
+		if err != nil {
+			return nil, err
+		}
+
+		imgs = append(imgs, *img)
+	}
+
+	return imgs, nil
+}
+
+// ContentStreamInlineImages returns the inline images of the content stream sd.
+// Content streams using unsupported filters are skipped.
+func ContentStreamInlineImages(sd StreamDict) ([]InlineImage, error) {
+
+	err := decodeStream(&sd)
+	if err == filter.ErrUnsupportedFilter {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseInlineImages(sd.Content)
+}
+
+// streamDict returns an image XObject for img.
+// Color spaces referring to resources get resolved.
+func (img InlineImage) streamDict(ctx *Context, resources Dict) (*StreamDict, error) {
+
+	d := img.ImageDict()
+
+	if cs, ok := d["ColorSpace"].(Name); ok {
+		switch cs {
+		case "DeviceGray", "DeviceRGB", "DeviceCMYK", "Pattern":
+		default:
+			csDict, err := ctx.DereferenceDict(resources["ColorSpace"])
+			if err != nil {
+				return nil, err
+			}
+			o, found := csDict.Find(cs.Value())
+			if !found {
+				return nil, errors.Errorf("inline image: unknown color space %s", cs)
+			}
+			d["ColorSpace"] = o
+		}
+	}
+
+	d.Delete("Length")
+	d.Insert("Type", Name("XObject"))
+	d.Insert("Subtype", Name("Image"))
+
+	fpl, err := pdfFilterPipeline(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+
+	l := int64(len(img.Data))
+	sd := NewStreamDict(d, 0, &l, nil, fpl)
+	sd.Raw = img.Data
+	sd.InsertInt("Length", len(img.Data))
+
+	return &sd, nil
+}
+
+// pageContentStreams returns the indirect references of the content streams of pageDict.
+func pageContentStreams(ctx *Context, pageDict Dict) ([]IndirectRef, error) {
+
+	o, found := pageDict.Find("Contents")
+	if !found || o == nil {
+		return nil, nil
+	}
+
+	if ir, ok := o.(IndirectRef); ok {
+		o, err := ctx.Dereference(ir)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := o.(StreamDict); ok {
+			return []IndirectRef{ir}, nil
+		}
+		pageDict = Dict{"Contents": o}
+	}
+
+	var irs []IndirectRef
+	for _, o := range pageDict.ArrayEntry("Contents") {
+		if ir, ok := o.(IndirectRef); ok {
+			irs = append(irs, ir)
+		}
+	}
+
+	return irs, nil
+}
+
+// ExtractInlineImages returns image XObjects for all inline images of page pageNr.
+// Image data not using DCTDecode, JPXDecode or CCITTFaxDecode gets FlateDecode encoded,
+// so the images can be written using WriteImage.
+func ExtractInlineImages(ctx *Context, pageNr int) ([]*StreamDict, error) {
+
+	pageDict, attrs, err := ctx.PageDict(pageNr)
+	if err != nil {
+		return nil, err
+	}
+	if pageDict == nil {
+		return nil, errors.Errorf("ExtractInlineImages: missing page %d", pageNr)
+	}
+
+	irs, err := pageContentStreams(ctx, pageDict)
+	if err != nil {
+		return nil, err
+	}
+
+	var sds []*StreamDict
+
+	for _, ir := range irs {
+
+		sd, err := ctx.DereferenceStreamDict(ir)
+		if err != nil {
+			return nil, err
+		}
+		if sd == nil {
+			continue
+		}
+
+		imgs, err := ContentStreamInlineImages(*sd)
+		if err != nil {
+			return nil, errors.Wrapf(err, "ExtractInlineImages: page %d", pageNr)
+		}
+
+		for _, img := range imgs {
+
+			sd, err := img.streamDict(ctx, attrs.Resources())
+			if err != nil {
+				log.Info.Printf("ExtractInlineImages: page %d: %v\n", pageNr, err)
+				continue
+			}
+
+			if err = normalizeImageFilter(sd); err != nil {
+				log.Info.Printf("ExtractInlineImages: page %d: %v\n", pageNr, err)
+				continue
+			}
+
+			sds = append(sds, sd)
+		}
+	}
+
+	return sds, nil
+}
+
+// normalizeImageFilter ensures a single image filter as expected by WriteImage.
+func normalizeImageFilter(sd *StreamDict) error {
+
+	fpl := sd.FilterPipeline
+
+	if n := len(fpl); n > 0 {
+		switch fpl[n-1].Name {
+		case filter.DCT, filter.JPX, filter.CCITTFax, filter.Flate:
+			if n == 1 {
+				return nil
+			}
+			// Peel off any leading ASCII or compression filters.
+			sd.FilterPipeline = fpl[:n-1]
+			if err := decodeStream(sd); err != nil {
+				return err
+			}
+			sd.Raw, sd.Content = sd.Content, nil
+			sd.FilterPipeline = fpl[n-1:]
+			sd.Update("Filter", Name(fpl[n-1].Name))
+			sd.Delete("DecodeParms")
+			if fpl[n-1].DecodeParms != nil {
+				sd.Insert("DecodeParms", fpl[n-1].DecodeParms)
+			}
+			l := int64(len(sd.Raw))
+			sd.StreamLength = &l
+			sd.Update("Length", Integer(l))
+			return nil
+		}
+		if err := decodeStream(sd); err != nil {
+			return err
+		}
+	} else {
+		sd.Content = sd.Raw
+	}
+
+	sd.FilterPipeline = []PDFFilter{{Name: filter.Flate}}
+	sd.Update("Filter", Name(filter.Flate))
+	sd.Delete("DecodeParms")
+
+	return encodeStream(sd)
+}
+
+// uniqueResourceName returns a name not yet used in d.
+func uniqueResourceName(d Dict, prefix string) string {
+	for i := 0; ; i++ {
+		k := fmt.Sprintf("%s%d", prefix, i)
+		if _, found := d.Find(k); !found {
+			return k
+		}
+	}
+}
+
+// convertInlineImages replaces inline images of page pageNr with more than threshold bytes of image data
+// by image XObjects. Content streams in shared are left alone.
+func convertInlineImages(ctx *Context, pageNr, threshold int, shared IntSet) error {
+
+	pageDict, attrs, err := ctx.PageDict(pageNr)
+	if err != nil {
+		return err
+	}
+	if pageDict == nil {
+		return errors.Errorf("convertInlineImages: missing page %d", pageNr)
+	}
+
+	resources := attrs.Resources()
+	if resources == nil {
+		return nil
+	}
+
+	irs, err := pageContentStreams(ctx, pageDict)
+	if err != nil {
+		return err
+	}
+
+	for _, ir := range irs {
+
+		if shared[ir.ObjectNumber.Value()] {
+			continue
+		}
+
+		entry, found := ctx.FindTableEntryForIndRef(&ir)
+		if !found {
+			continue
+		}
+
+		sd, ok := entry.Object.(StreamDict)
+		if !ok {
+			continue
+		}
+
+		err = decodeStream(&sd)
+		if err == filter.ErrUnsupportedFilter {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		imgs, err := ParseInlineImages(sd.Content)
+		if err != nil {
+			return err
+		}
+
+		xoDict, err := ctx.DereferenceDict(resources["XObject"])
+		if err != nil {
+			return err
+		}
+
+		var buf bytes.Buffer
+		var from int
+		xObjs := map[string]*StreamDict{}
+		names := Dict{}
+
+		for _, img := range imgs {
+
+			if len(img.Data) <= threshold {
+				continue
+			}
+
+			xo, err := img.streamDict(ctx, resources)
+			if err != nil {
+				log.Info.Printf("convertInlineImages: page %d: %v\n", pageNr, err)
+				continue
+			}
+
+			name := uniqueResourceName(xoDict, "InlineIm")
+			for names[name] != nil {
+				name = uniqueResourceName(names, name+"_")
+			}
+			names[name] = Boolean(true)
+			xObjs[name] = xo
+
+			buf.Write(sd.Content[from:img.start])
+			fmt.Fprintf(&buf, "/%s Do", name)
+			from = img.end
+		}
+
+		if len(xObjs) == 0 {
+			continue
+		}
+
+		buf.Write(sd.Content[from:])
+
+		sd.Content = buf.Bytes()
+		if err = encodeStream(&sd); err != nil {
+			log.Info.Printf("convertInlineImages: page %d: content stream obj#%d: %v\n", pageNr, ir.ObjectNumber, err)
+			continue
+		}
+
+		if xoDict == nil {
+			xoDict = Dict{}
+			resources.Insert("XObject", xoDict)
+		}
+
+		for name, xo := range xObjs {
+			xoIR, err := ctx.IndRefForNewObject(*xo)
+			if err != nil {
+				return err
+			}
+			xoDict.Insert(name, *xoIR)
+			log.Optimize.Printf("convertInlineImages: page %d: %d bytes inline image -> obj#%d\n", pageNr, len(xo.Raw), xoIR.ObjectNumber)
+		}
+
+		entry.Object = sd
+	}
+
+	return nil
+}
+
+// ConvertInlineImages replaces all inline images with more than threshold bytes of image data by image XObjects.
+// Content streams shared by pages are skipped since the pages may use different resources.
+func ConvertInlineImages(ctx *Context, threshold int) error {
+
+	seen, shared := IntSet{}, IntSet{}
+
+	for i := 1; i <= ctx.PageCount; i++ {
+		pageDict, _, err := ctx.PageDict(i)
+		if err != nil {
+			return err
+		}
+		irs, err := pageContentStreams(ctx, pageDict)
+		if err != nil {
+			return err
+		}
+		for _, ir := range irs {
+			objNr := ir.ObjectNumber.Value()
+			if seen[objNr] {
+				shared[objNr] = true
+			}
+			seen[objNr] = true
+		}
+	}
+
+	for i := 1; i <= ctx.PageCount; i++ {
+		if err := convertInlineImages(ctx, i, threshold, shared); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
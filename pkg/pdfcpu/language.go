@@ -0,0 +1,283 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/jplu/pdfcpu/pkg/log"
+	"github.com/pkg/errors"
+)
+
+// Language detection is based on the writing system and for latin and cyrillic scripts on frequent function words.
+// This is good enough for routing documents of a few sentences and more, it is not meant for short snippets.
+
+var stopWords = map[string][]string{
+	"en": {"the", "and", "of", "to", "in", "is", "that", "for", "it", "with", "as", "was", "on", "are", "be", "by", "this", "which", "from", "or", "an", "not", "have", "at", "were", "their", "has"},
+	"de": {"der", "die", "und", "das", "den", "ist", "nicht", "mit", "von", "sich", "des", "auf", "für", "ein", "eine", "dem", "im", "zu", "auch", "werden", "wird", "sind", "oder", "bei", "nach"},
+	"fr": {"le", "la", "les", "et", "des", "est", "une", "du", "dans", "que", "pour", "qui", "pas", "sur", "au", "avec", "ce", "sont", "par", "aux", "ou", "ne", "cette", "il"},
+	"es": {"el", "los", "las", "del", "que", "por", "una", "con", "para", "es", "se", "al", "como", "más", "pero", "su", "sus", "son", "está", "lo", "este", "entre", "y"},
+	"it": {"il", "di", "che", "della", "per", "non", "una", "sono", "con", "del", "gli", "nel", "anche", "alla", "come", "più", "questo", "delle", "dei", "ed", "è"},
+	"pt": {"os", "das", "dos", "não", "uma", "com", "para", "que", "em", "ao", "mais", "como", "pelo", "pela", "são", "está", "foi", "também", "seu", "sua", "nas", "nos"},
+	"nl": {"de", "het", "een", "en", "van", "is", "dat", "niet", "op", "met", "voor", "zijn", "die", "er", "aan", "ook", "bij", "wordt", "worden", "naar", "maar", "deze", "uit"},
+	"ru": {"и", "в", "не", "на", "что", "с", "по", "это", "как", "к", "для", "из", "от", "он", "его", "но", "были", "при", "также"},
+	"uk": {"і", "в", "не", "на", "що", "з", "до", "це", "як", "для", "від", "та", "його", "але", "також", "є", "був"},
+}
+
+// stopWordLangs maps function words to the languages using them.
+var stopWordLangs = func() map[string][]string {
+	m := map[string][]string{}
+	for lang, ww := range stopWords {
+		for _, w := range ww {
+			m[w] = append(m[w], lang)
+		}
+	}
+	return m
+}()
+
+// scriptLangs maps writing systems used by a single language or a dominant one to language tags.
+var scriptLangs = []struct {
+	table *unicode.RangeTable
+	lang  string
+}{
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Hangul, "ko"},
+	{unicode.Han, "zh"},
+	{unicode.Greek, "el"},
+	{unicode.Hebrew, "he"},
+	{unicode.Arabic, "ar"},
+	{unicode.Thai, "th"},
+	{unicode.Devanagari, "hi"},
+}
+
+// DetectLanguage returns the BCP 47 language tag for the language of s or "" if undetermined.
+func DetectLanguage(s string) string {
+
+	var latin, cyrillic, letters int
+	scripts := map[string]int{}
+
+	for _, r := range s {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		switch {
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		case unicode.Is(unicode.Cyrillic, r):
+			cyrillic++
+		default:
+			for _, sl := range scriptLangs {
+				if unicode.Is(sl.table, r) {
+					scripts[sl.lang]++
+					break
+				}
+			}
+		}
+	}
+
+	if letters == 0 {
+		return ""
+	}
+
+	// Japanese mixes kana with Han characters.
+	if scripts["ja"] > 0 && scripts["ja"]+scripts["zh"] > letters/2 {
+		return "ja"
+	}
+
+	for lang, n := range scripts {
+		if n > letters/2 {
+			return lang
+		}
+	}
+
+	if latin+cyrillic <= letters/2 {
+		return ""
+	}
+
+	scores := map[string]int{}
+	for _, w := range Words(s) {
+		for _, lang := range stopWordLangs[w] {
+			scores[lang]++
+		}
+	}
+
+	lang, best := "", 0
+	for l, n := range scores {
+		if n > best || n == best && l < lang {
+			lang, best = l, n
+		}
+	}
+
+	second := 0
+	for l, n := range scores {
+		if l != lang && n > second {
+			second = n
+		}
+	}
+
+	// Require some evidence and a clear winner.
+	if best < 3 || best*4 < second*5 {
+		return ""
+	}
+
+	return lang
+}
+
+// Languages holds the detected languages of a document and its pages, "" if undetermined.
+type Languages struct {
+	Lang  string         `json:"lang"`
+	Pages map[int]string `json:"pages"`
+}
+
+// DetectLanguages detects the language of the text of pages and of all their text as a whole.
+func DetectLanguages(xRefTable *XRefTable, pageNrs []int) (*Languages, error) {
+
+	l := &Languages{Pages: map[int]string{}}
+
+	var sb strings.Builder
+
+	for _, p := range pageNrs {
+
+		if p < 1 || p > xRefTable.PageCount {
+			return nil, NewError(ErrPageOutOfRange, "invalid page number: %d", p)
+		}
+
+		glyphs, err := pageGlyphs(xRefTable, p)
+		if err != nil {
+			return nil, err
+		}
+
+		s := glyphsText(glyphs)
+		l.Pages[p] = DetectLanguage(s)
+
+		sb.WriteString(s)
+		sb.WriteString("\n")
+	}
+
+	l.Lang = DetectLanguage(sb.String())
+
+	return l, nil
+}
+
+var langTagRegexp = regexp.MustCompile(`^[A-Za-z]{2,8}(-[A-Za-z0-9]{1,8})*$`)
+
+var (
+	xmpLanguageRegexp     = regexp.MustCompile(`(?s)<dc:language>.*?</dc:language>|<dc:language\s*/>`)
+	xmpLanguageAttrRegexp = regexp.MustCompile(`dc:language\s*=\s*("[^"]*"|'[^']*')`)
+)
+
+func xmpLanguage(lang string) string {
+	return fmt.Sprintf("<dc:language><rdf:Bag><rdf:li>%s</rdf:li></rdf:Bag></dc:language>", lang)
+}
+
+func newXMPPacket(lang string) []byte {
+	return []byte(`<?xpacket begin="` + "\ufeff" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/">` + xmpLanguage(lang) + `</rdf:Description>
+</rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`)
+}
+
+// patchXMPLanguage returns the XMP packet b with dc:language set to lang.
+func patchXMPLanguage(b []byte, lang string) ([]byte, error) {
+
+	s := string(b)
+
+	if xmpLanguageRegexp.MatchString(s) {
+		return []byte(xmpLanguageRegexp.ReplaceAllLiteralString(s, xmpLanguage(lang))), nil
+	}
+
+	if xmpLanguageAttrRegexp.MatchString(s) {
+		// Drop the attribute form and add the element form below.
+		s = xmpLanguageAttrRegexp.ReplaceAllLiteralString(s, "")
+	}
+
+	i := strings.LastIndex(s, "</rdf:RDF>")
+	if i < 0 {
+		return nil, errors.New("missing rdf:RDF element")
+	}
+
+	desc := `<rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/">` + xmpLanguage(lang) + "</rdf:Description>\n"
+
+	return []byte(s[:i] + desc + s[i:]), nil
+}
+
+func setXMPLanguage(xRefTable *XRefTable, rootDict Dict, lang string) error {
+
+	if o, found := rootDict.Find("Metadata"); found {
+		if ir, ok := o.(IndirectRef); ok {
+			if entry, found := xRefTable.FindTableEntryForIndRef(&ir); found {
+				if sd, ok := entry.Object.(StreamDict); ok && decodeStream(&sd) == nil {
+					if b, err := patchXMPLanguage(sd.Content, lang); err == nil {
+						sd.Content = b
+						if err = encodeStream(&sd); err != nil {
+							return err
+						}
+						entry.Object = sd
+						return nil
+					}
+				}
+			}
+		}
+		// Leave metadata we are unable to patch untouched.
+		log.Info.Printf("setXMPLanguage: skipping metadata\n")
+		return nil
+	}
+
+	sd := StreamDict{
+		Dict:    Dict(map[string]Object{"Type": Name("Metadata"), "Subtype": Name("XML")}),
+		Content: newXMPPacket(lang),
+	}
+
+	if err := encodeStream(&sd); err != nil {
+		return err
+	}
+
+	ir, err := xRefTable.IndRefForNewObject(sd)
+	if err != nil {
+		return err
+	}
+
+	rootDict.Update("Metadata", *ir)
+
+	return nil
+}
+
+// SetLanguage sets the document language lang, a BCP 47 language tag, in the catalog and in the XMP metadata as dc:language.
+// Existing XMP metadata that cannot be patched is left untouched.
+func SetLanguage(xRefTable *XRefTable, lang string) error {
+
+	if !langTagRegexp.MatchString(lang) {
+		return errors.Errorf("invalid language tag: %q", lang)
+	}
+
+	rootDict, err := xRefTable.Catalog()
+	if err != nil {
+		return err
+	}
+
+	rootDict.Update("Lang", StringLiteral(lang))
+
+	return setXMPLanguage(xRefTable, rootDict, lang)
+}
@@ -0,0 +1,481 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+
+	"github.com/jplu/pdfcpu/pkg/fonts/metrics"
+	"github.com/jplu/pdfcpu/pkg/log"
+	"github.com/jplu/pdfcpu/pkg/types"
+	"github.com/pkg/errors"
+)
+
+// Measure describes the scale of a drawing, see 12.9 Measurement Properties.
+type Measure struct {
+	Ratio      string  // The scale ratio as displayed, eg. "1 in = 4 ft".
+	Unit       string  // The unit of distances, eg. "ft".
+	Factor     float64 // Distance in Unit per default user space unit, eg. 4./72 for 1 in = 4 ft.
+	AreaUnit   string  // The unit of areas, defaults to "sq " + Unit.
+	AreaFactor float64 // Area in AreaUnit per square default user space unit, defaults to Factor².
+	Precision  int     // The denominator of the displayed precision, eg. 100 for two decimal places, defaults to 100.
+}
+
+func (m Measure) areaUnit() string {
+	if m.AreaUnit == "" {
+		return "sq " + m.Unit
+	}
+	return m.AreaUnit
+}
+
+func (m Measure) areaFactor() float64 {
+	if m.AreaFactor == 0 {
+		return m.Factor * m.Factor
+	}
+	return m.AreaFactor
+}
+
+func (m Measure) precision() int {
+	if m.Precision <= 0 {
+		return 100
+	}
+	return m.Precision
+}
+
+// format returns v formatted using the precision of m.
+func (m Measure) format(v float64, unit string) string {
+	decimals := int(math.Round(math.Log10(float64(m.precision()))))
+	if decimals < 0 {
+		decimals = 0
+	}
+	return fmt.Sprintf("%.*f %s", decimals, v, unit)
+}
+
+func numberFormatDict(unit string, c float64, precision int) Dict {
+	return Dict(
+		map[string]Object{
+			"Type": Name("NumberFormat"),
+			"U":    StringLiteral(unit),
+			"C":    Float(c),
+			"D":    Integer(precision),
+		},
+	)
+}
+
+// dict returns a rectilinear measure dict for m.
+func (m Measure) dict() (Dict, error) {
+
+	var ss []string
+	for _, s := range []string{m.Ratio, m.Unit, m.areaUnit()} {
+		s, err := latin1(s)
+		if err != nil {
+			return nil, err
+		}
+		es, err := Escape(s)
+		if err != nil {
+			return nil, err
+		}
+		ss = append(ss, *es)
+	}
+
+	// X converts default user space units into Unit, D and A are relative to X.
+	return Dict(
+		map[string]Object{
+			"Type":    Name("Measure"),
+			"Subtype": Name("RL"),
+			"R":       StringLiteral(ss[0]),
+			"X":       Array{numberFormatDict(ss[1], m.Factor, m.precision())},
+			"D":       Array{numberFormatDict(ss[1], 1, m.precision())},
+			"A":       Array{numberFormatDict(ss[2], m.areaFactor()/(m.Factor*m.Factor), m.precision())},
+		},
+	), nil
+}
+
+// numberFormat returns unit, conversion factor and precision of the first number format of the number format array o.
+func numberFormat(xRefTable *XRefTable, o Object) (string, float64, int, error) {
+
+	a, err := xRefTable.DereferenceArray(o)
+	if err != nil || len(a) == 0 {
+		return "", 1, 0, err
+	}
+
+	d, err := xRefTable.DereferenceDict(a[0])
+	if err != nil || d == nil {
+		return "", 1, 0, err
+	}
+
+	unit, err := textEntry(xRefTable, d, "U")
+	if err != nil {
+		return "", 1, 0, err
+	}
+
+	c := 1.
+	if d["C"] != nil {
+		c = xRefTable.DereferenceNumber(d["C"])
+	}
+
+	precision := 0
+	if i := d.IntEntry("D"); i != nil {
+		precision = *i
+	}
+
+	return unit, c, precision, nil
+}
+
+// parseMeasure returns the rectilinear measure represented by d.
+func parseMeasure(xRefTable *XRefTable, d Dict) (*Measure, error) {
+
+	if st := d.Subtype(); st != nil && *st != "RL" {
+		return nil, errors.Errorf("unsupported measure dict subtype: %s", *st)
+	}
+
+	ratio, err := textEntry(xRefTable, d, "R")
+	if err != nil {
+		return nil, err
+	}
+
+	xUnit, xc, xp, err := numberFormat(xRefTable, d["X"])
+	if err != nil {
+		return nil, err
+	}
+
+	m := Measure{Ratio: ratio, Unit: xUnit, Factor: xc, Precision: xp}
+
+	if d["D"] != nil {
+		unit, c, p, err := numberFormat(xRefTable, d["D"])
+		if err != nil {
+			return nil, err
+		}
+		m.Unit, m.Factor, m.Precision = unit, xc*c, p
+	}
+
+	if d["A"] != nil {
+		unit, c, _, err := numberFormat(xRefTable, d["A"])
+		if err != nil {
+			return nil, err
+		}
+		m.AreaUnit, m.AreaFactor = unit, xc*xc*c
+	}
+
+	return &m, nil
+}
+
+// MeasureAnnotation describes a Line, Polygon or PolyLine annotation measuring a distance, an area or a perimeter
+// as used by CAD and construction drawings.
+type MeasureAnnotation struct {
+	Page     int
+	Type     string        // Line, Polygon or PolyLine.
+	Points   []types.Point // The end points of a line or the vertices in default user space units.
+	Measure  Measure
+	Color    RGBColor // The line color.
+	Contents string   // Defaults to the measured value, eg. "12.50 ft".
+	Author   string   // Optional author.
+}
+
+func (ma MeasureAnnotation) String() string {
+	return fmt.Sprintf("%s page:%d points:%v", ma.Type, ma.Page, ma.Points)
+}
+
+// Value returns the length of a line, the area of a polygon or the length of a polyline in the units of its measure.
+func (ma MeasureAnnotation) Value() float64 {
+
+	pp := ma.Points
+	v := 0.
+
+	if ma.Type == "Polygon" {
+		// Shoelace formula
+		for i, p := range pp {
+			q := pp[(i+1)%len(pp)]
+			v += p.X*q.Y - q.X*p.Y
+		}
+		return math.Abs(v) / 2 * ma.Measure.areaFactor()
+	}
+
+	for i := 1; i < len(pp); i++ {
+		v += math.Hypot(pp[i].X-pp[i-1].X, pp[i].Y-pp[i-1].Y)
+	}
+
+	return v * ma.Measure.Factor
+}
+
+// Label returns the formatted measured value.
+func (ma MeasureAnnotation) Label() string {
+	if ma.Type == "Polygon" {
+		return ma.Measure.format(ma.Value(), ma.Measure.areaUnit())
+	}
+	return ma.Measure.format(ma.Value(), ma.Measure.Unit)
+}
+
+func (ma MeasureAnnotation) validate() error {
+
+	min := map[string]int{"Line": 2, "PolyLine": 2, "Polygon": 3}[ma.Type]
+	if min == 0 {
+		return errors.Errorf("measure annotation %s: type must be Line, Polygon or PolyLine", ma)
+	}
+
+	if len(ma.Points) < min || ma.Type == "Line" && len(ma.Points) != 2 {
+		return errors.Errorf("measure annotation %s: invalid number of points", ma)
+	}
+
+	if ma.Measure.Factor <= 0 || ma.Measure.Unit == "" {
+		return errors.Errorf("measure annotation %s: missing measure unit or factor", ma)
+	}
+
+	if !ma.Color.valid() {
+		return errors.Errorf("measure annotation %s: invalid color", ma)
+	}
+
+	return nil
+}
+
+// measureContent returns the appearance of ma.
+func (ma MeasureAnnotation) measureContent(label string, arrowLen float64) []byte {
+
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "%s RG 1 w ", ma.Color)
+
+	for i, p := range ma.Points {
+		op := "l"
+		if i == 0 {
+			op = "m"
+		}
+		fmt.Fprintf(&b, "%.2f %.2f %s ", p.X, p.Y, op)
+	}
+
+	if ma.Type == "Polygon" {
+		b.WriteString("s ")
+	} else {
+		b.WriteString("S ")
+	}
+
+	if ma.Type != "Line" {
+		return b.Bytes()
+	}
+
+	p, q := ma.Points[0], ma.Points[1]
+
+	for _, e := range [][2]types.Point{{p, q}, {q, p}} {
+		l, r := arrowHead(e[0], e[1], arrowLen)
+		fmt.Fprintf(&b, "%.2f %.2f m %.2f %.2f l %.2f %.2f l S ", l.X, l.Y, e[0].X, e[0].Y, r.X, r.Y)
+	}
+
+	// The caption is centered above the line.
+	a := math.Atan2(q.Y-p.Y, q.X-p.X)
+	if a > math.Pi/2 || a < -math.Pi/2 {
+		a += math.Pi
+	}
+	sin, cos := math.Sin(a), math.Cos(a)
+	w := metrics.TextWidth(winAnsi(label), "Helvetica", 9)
+
+	es, _ := Escape(winAnsi(label))
+	fmt.Fprintf(&b, "q %.4f %.4f %.4f %.4f %.2f %.2f cm BT %s rg /Helvetica 9 Tf %.2f 3 Td (%s) Tj ET Q",
+		cos, sin, -sin, cos, (p.X+q.X)/2, (p.Y+q.Y)/2, ma.Color, -w/2, *es)
+
+	return b.Bytes()
+}
+
+func addMeasureAnnotation(xRefTable *XRefTable, ma MeasureAnnotation) error {
+
+	if err := ma.validate(); err != nil {
+		return err
+	}
+
+	label := ma.Label()
+
+	contents := ma.Contents
+	if contents == "" {
+		contents = label
+	}
+
+	contents, err := latin1(contents)
+	if err != nil {
+		return errors.Wrapf(err, "measure annotation %s", ma)
+	}
+
+	const arrowLen, margin = 6., 14.
+
+	r := types.NewRectangle(math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1))
+	var coords []float64
+	for _, p := range ma.Points {
+		r.LL.X, r.LL.Y = math.Min(r.LL.X, p.X-margin), math.Min(r.LL.Y, p.Y-margin)
+		r.UR.X, r.UR.Y = math.Max(r.UR.X, p.X+margin), math.Max(r.UR.Y, p.Y+margin)
+		coords = append(coords, p.X, p.Y)
+	}
+
+	pageDict, d, err := markupAnnotationDict(xRefTable, ma.Type, ma.Page, r, contents, ma.Author)
+	if err != nil {
+		return err
+	}
+
+	md, err := ma.Measure.dict()
+	if err != nil {
+		return errors.Wrapf(err, "measure annotation %s", ma)
+	}
+
+	font, err := fontDictIndRef(xRefTable, "Helvetica")
+	if err != nil {
+		return err
+	}
+
+	sd := &StreamDict{
+		Dict: Dict(
+			map[string]Object{
+				"Type":      Name("XObject"),
+				"Subtype":   Name("Form"),
+				"FormType":  Integer(1),
+				"BBox":      NewRectangle(r.LL.X, r.LL.Y, r.UR.X, r.UR.Y),
+				"Matrix":    NewIntegerArray(1, 0, 0, 1, 0, 0),
+				"Resources": Dict(map[string]Object{"Font": Dict(map[string]Object{"Helvetica": *font})}),
+			},
+		),
+		Content: ma.measureContent(label, arrowLen),
+	}
+
+	if err = encodeStream(sd); err != nil {
+		return err
+	}
+
+	ap, err := xRefTable.IndRefForNewObject(*sd)
+	if err != nil {
+		return err
+	}
+
+	d.Insert("C", NewNumberArray(ma.Color.R, ma.Color.G, ma.Color.B))
+	d.Insert("BS", Dict(map[string]Object{"W": Float(1)}))
+	d.Insert("Measure", md)
+	d.Insert("AP", Dict(map[string]Object{"N": *ap}))
+
+	switch ma.Type {
+
+	case "Line":
+		d.Insert("L", NewNumberArray(coords...))
+		d.Insert("LE", NewNameArray("OpenArrow", "OpenArrow"))
+		d.Insert("IT", Name("LineDimension"))
+		// Display the contents as caption.
+		d.Insert("Cap", Boolean(true))
+
+	default:
+		d.Insert("Vertices", NewNumberArray(coords...))
+		d.Insert("IT", Name(ma.Type+"Dimension"))
+	}
+
+	_, err = addAnnotation(xRefTable, pageDict, d)
+
+	return err
+}
+
+// AddMeasureAnnotations adds dimension lines, area polygons and perimeter polylines carrying measure dicts.
+func AddMeasureAnnotations(xRefTable *XRefTable, annots []MeasureAnnotation) error {
+
+	for _, ma := range annots {
+
+		log.Debug.Printf("AddMeasureAnnotations: %s\n", ma)
+
+		if err := addMeasureAnnotation(xRefTable, ma); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func measureAnnotation(xRefTable *XRefTable, pageNr int, d Dict) (*MeasureAnnotation, error) {
+
+	md, err := xRefTable.DereferenceDict(d["Measure"])
+	if err != nil || md == nil {
+		return nil, err
+	}
+
+	m, err := parseMeasure(xRefTable, md)
+	if err != nil {
+		return nil, err
+	}
+
+	ma := MeasureAnnotation{Page: pageNr, Type: *d.Subtype(), Measure: *m}
+
+	key := "Vertices"
+	if ma.Type == "Line" {
+		key = "L"
+	}
+
+	coords := numberArrayEntry(xRefTable, d, key)
+	for i := 0; i+1 < len(coords); i += 2 {
+		ma.Points = append(ma.Points, types.Point{X: coords[i], Y: coords[i+1]})
+	}
+
+	if c := numberArrayEntry(xRefTable, d, "C"); len(c) == 3 {
+		ma.Color = RGBColor{c[0], c[1], c[2]}
+	}
+
+	if ma.Contents, err = textEntry(xRefTable, d, "Contents"); err != nil {
+		return nil, err
+	}
+
+	if ma.Author, err = textEntry(xRefTable, d, "T"); err != nil {
+		return nil, err
+	}
+
+	return &ma, nil
+}
+
+// MeasureAnnotations returns the Line, Polygon and PolyLine annotations of the selected pages carrying rectilinear measure dicts.
+func MeasureAnnotations(xRefTable *XRefTable, selectedPages IntSet) ([]MeasureAnnotation, error) {
+
+	var mm []MeasureAnnotation
+
+	for _, pageNr := range SortedSelectedPages(selectedPages) {
+
+		pageDict, _, err := xRefTable.PageDict(pageNr)
+		if err != nil {
+			return nil, err
+		}
+		if pageDict == nil {
+			return nil, errors.Errorf("MeasureAnnotations: missing page %d", pageNr)
+		}
+
+		annots, err := xRefTable.DereferenceArray(pageDict["Annots"])
+		if err != nil {
+			return nil, err
+		}
+
+		for _, o := range annots {
+
+			d, err := xRefTable.DereferenceDict(o)
+			if err != nil {
+				return nil, err
+			}
+
+			if d == nil || d.Subtype() == nil || !MemberOf(*d.Subtype(), []string{"Line", "Polygon", "PolyLine"}) {
+				continue
+			}
+
+			ma, err := measureAnnotation(xRefTable, pageNr, d)
+			if err != nil {
+				return nil, errors.Wrapf(err, "MeasureAnnotations: page %d", pageNr)
+			}
+
+			if ma != nil {
+				mm = append(mm, *ma)
+			}
+		}
+	}
+
+	return mm, nil
+}
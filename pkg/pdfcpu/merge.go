@@ -17,7 +17,11 @@ limitations under the License.
 package pdfcpu
 
 import (
+	"fmt"
+	"sort"
+
 	"github.com/jplu/pdfcpu/pkg/log"
+	"github.com/pkg/errors"
 )
 
 func patchIndRef(ir *IndirectRef, lookup map[int]int) {
@@ -107,11 +111,12 @@ func objNrsIntSet(ctx *Context) IntSet {
 	return objNrs
 }
 
+// lookupTable maps keys in ascending order onto consecutive object numbers starting at i.
 func lookupTable(keys IntSet, i int) map[int]int {
 
 	m := map[int]int{}
 
-	for k := range keys {
+	for _, k := range sortedIntKeys(keys) {
 		m[k] = i
 		i++
 	}
@@ -288,6 +293,347 @@ func mergeDuplicateObjNumberIntSets(ctxSource, ctxDest *Context) {
 	log.Debug.Println("mergeDuplicateObjNumberIntSets end")
 }
 
+// mergeAcroForms merges the interactive form of the source document into the one of the dest document.
+// Fields and their calculation order get appended, missing default resources get added.
+// Fields of both documents sharing a fully qualified name end up sharing their value.
+func mergeAcroForms(srcRootDict Dict, ctxDest *Context) error {
+
+	srcForm, err := ctxDest.DereferenceDict(srcRootDict["AcroForm"])
+	if err != nil || srcForm == nil {
+		return err
+	}
+
+	destRootDict, err := ctxDest.Catalog()
+	if err != nil {
+		return err
+	}
+
+	destForm, err := ctxDest.DereferenceDict(destRootDict["AcroForm"])
+	if err != nil {
+		return err
+	}
+
+	if destForm == nil {
+		destRootDict.Insert("AcroForm", srcRootDict["AcroForm"])
+		return nil
+	}
+
+	for _, k := range []string{"Fields", "CO"} {
+
+		src, err := ctxDest.DereferenceArray(srcForm[k])
+		if err != nil {
+			return err
+		}
+		if len(src) == 0 {
+			continue
+		}
+
+		dest, err := ctxDest.DereferenceArray(destForm[k])
+		if err != nil {
+			return err
+		}
+
+		destForm.Update(k, append(append(Array{}, dest...), src...))
+	}
+
+	// XFA forms would not know about the fields appended.
+	destForm.Delete("XFA")
+
+	if err = mergeDefaultResources(ctxDest.XRefTable, srcForm, destForm); err != nil {
+		return err
+	}
+
+	if b := srcForm.BooleanEntry("NeedAppearances"); b != nil && *b {
+		destForm.Update("NeedAppearances", Boolean(true))
+	}
+
+	if i := srcForm.IntEntry("SigFlags"); i != nil {
+		sigFlags := *i
+		if j := destForm.IntEntry("SigFlags"); j != nil {
+			sigFlags |= *j
+		}
+		destForm.Update("SigFlags", Integer(sigFlags))
+	}
+
+	for _, k := range []string{"DA", "Q"} {
+		if o, found := srcForm.Find(k); found {
+			destForm.Insert(k, o)
+		}
+	}
+
+	return nil
+}
+
+// mergeDefaultResources adds the default resources of srcForm missing in destForm.
+func mergeDefaultResources(xRefTable *XRefTable, srcForm, destForm Dict) error {
+
+	src, err := xRefTable.DereferenceDict(srcForm["DR"])
+	if err != nil || src == nil {
+		return err
+	}
+
+	dest, err := xRefTable.DereferenceDict(destForm["DR"])
+	if err != nil {
+		return err
+	}
+
+	if dest == nil {
+		destForm.Insert("DR", srcForm["DR"])
+		return nil
+	}
+
+	for k, o := range src {
+
+		srcRes, err := xRefTable.DereferenceDict(o)
+		if err != nil {
+			return err
+		}
+
+		destRes, err := xRefTable.DereferenceDict(dest[k])
+		if err != nil {
+			return err
+		}
+
+		if srcRes == nil || destRes == nil {
+			dest.Insert(k, o)
+			continue
+		}
+
+		for id, res := range srcRes {
+			destRes.Insert(id, res)
+		}
+	}
+
+	return nil
+}
+
+// destKey returns the string form of a name tree key or a destination string.
+func destKey(o Object) (string, bool) {
+
+	switch o := o.(type) {
+
+	case StringLiteral:
+		return o.Value(), true
+
+	case HexLiteral:
+		b, err := o.Bytes()
+		if err != nil {
+			return "", false
+		}
+		s, err := Escape(string(b))
+		if err != nil {
+			return "", false
+		}
+		return *s, true
+	}
+
+	return "", false
+}
+
+// nameTreeEntries calls f for each key value pair of the name tree node o.
+func nameTreeEntries(xRefTable *XRefTable, o Object, f func(k string, v Object) error) error {
+
+	d, err := xRefTable.DereferenceDict(o)
+	if err != nil || d == nil {
+		return err
+	}
+
+	kids, err := xRefTable.DereferenceArray(d["Kids"])
+	if err != nil {
+		return err
+	}
+
+	for _, kid := range kids {
+		if err = nameTreeEntries(xRefTable, kid, f); err != nil {
+			return err
+		}
+	}
+
+	a, err := xRefTable.DereferenceArray(d["Names"])
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i+1 < len(a); i += 2 {
+		o, err := xRefTable.Dereference(a[i])
+		if err != nil {
+			return err
+		}
+		k, ok := destKey(o)
+		if !ok {
+			continue
+		}
+		if err = f(k, a[i+1]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// uniqueDestName returns name or if taken name with the smallest numeric suffix available.
+func uniqueDestName(name string, taken func(string) bool) string {
+
+	s := name
+	for i := 2; taken(s); i++ {
+		s = fmt.Sprintf("%s_%d", name, i)
+	}
+
+	return s
+}
+
+// renameDestRefs patches named destinations referenced by link annotations, outline items and GoTo actions.
+// names maps renamed keys of the /Dests dict, strs renamed keys of the /Dests name tree.
+func renameDestRefs(o Object, names, strs map[string]string) {
+
+	rename := func(o Object) Object {
+		switch o := o.(type) {
+		case Name:
+			if s, ok := names[o.Value()]; ok {
+				return Name(s)
+			}
+		case StringLiteral, HexLiteral:
+			if k, ok := destKey(o); ok {
+				if s, ok := strs[k]; ok {
+					return StringLiteral(s)
+				}
+			}
+		}
+		return o
+	}
+
+	switch o := o.(type) {
+
+	case Dict:
+		if d, found := o["Dest"]; found {
+			o["Dest"] = rename(d)
+		}
+		if s := o.NameEntry("S"); s != nil && *s == "GoTo" {
+			if d, found := o["D"]; found {
+				o["D"] = rename(d)
+			}
+		}
+		for _, v := range o {
+			renameDestRefs(v, names, strs)
+		}
+
+	case StreamDict:
+		renameDestRefs(o.Dict, names, strs)
+
+	case Array:
+		for _, v := range o {
+			renameDestRefs(v, names, strs)
+		}
+	}
+}
+
+// mergeNamedDests adds the named destinations of the source document to the dest document.
+// Names already used in the dest document get renamed along with the references to them.
+// Explicit destinations need no remapping since pages keep their objects.
+func mergeNamedDests(ctxSource, ctxDest *Context, srcRootDict Dict) error {
+
+	xRefTable := ctxDest.XRefTable
+
+	destRootDict, err := xRefTable.Catalog()
+	if err != nil {
+		return err
+	}
+
+	names, strs := map[string]string{}, map[string]string{}
+
+	// PDF 1.1 style destinations keyed by name.
+	srcDests, err := xRefTable.DereferenceDict(srcRootDict["Dests"])
+	if err != nil {
+		return err
+	}
+
+	if len(srcDests) > 0 {
+
+		destDests, err := xRefTable.DereferenceDict(destRootDict["Dests"])
+		if err != nil {
+			return err
+		}
+
+		if destDests == nil {
+			destDests = NewDict()
+			ir, err := xRefTable.IndRefForNewObject(destDests)
+			if err != nil {
+				return err
+			}
+			destRootDict.Update("Dests", *ir)
+		}
+
+		taken := func(s string) bool {
+			_, found := destDests[s]
+			return found
+		}
+
+		keys := make([]string, 0, len(srcDests))
+		for k := range srcDests {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			s := uniqueDestName(k, taken)
+			if s != k {
+				names[k] = s
+			}
+			destDests[s] = srcDests[k]
+		}
+	}
+
+	// Destinations keyed by string.
+	srcNames, err := xRefTable.DereferenceDict(srcRootDict["Names"])
+	if err != nil {
+		return err
+	}
+
+	if o, found := srcNames.Find("Dests"); found {
+
+		if xRefTable.Names["Dests"] == nil {
+			if err = xRefTable.LocateNameTree("Dests", true); err != nil {
+				return err
+			}
+		}
+
+		tree := xRefTable.Names["Dests"]
+
+		taken := func(s string) bool {
+			_, found := tree.Value(s)
+			return found
+		}
+
+		if err = nameTreeEntries(xRefTable, o, func(k string, v Object) error {
+			s := uniqueDestName(k, taken)
+			if s != k {
+				strs[k] = s
+			}
+			return tree.Add(xRefTable, s, v)
+		}); err != nil {
+			return err
+		}
+
+		if err = xRefTable.bindNameTreeNode("Dests", tree, true); err != nil {
+			return err
+		}
+	}
+
+	if len(names) == 0 && len(strs) == 0 {
+		return nil
+	}
+
+	log.Debug.Printf("mergeNamedDests: renamed %d names, %d strings\n", len(names), len(strs))
+
+	for _, entry := range ctxSource.Table {
+		if entry != nil && !entry.Free {
+			renameDestRefs(entry.Object, names, strs)
+		}
+	}
+
+	return nil
+}
+
 // MergeXRefTables merges Context ctxSource into ctxDest by appending its page tree.
 func MergeXRefTables(ctxSource, ctxDest *Context) (err error) {
 
@@ -305,6 +651,30 @@ func MergeXRefTables(ctxSource, ctxDest *Context) (err error) {
 	log.Debug.Println("appendSourceObjectsToDest")
 	appendSourceObjectsToDest(ctxSource, ctxDest)
 
+	// Keep the source's form fields.
+	srcRootDict, err := ctxDest.DereferenceDict(*ctxSource.Root)
+	if err != nil {
+		return err
+	}
+
+	log.Debug.Println("mergeAcroForms")
+	err = mergeAcroForms(srcRootDict, ctxDest)
+	if err != nil {
+		return err
+	}
+
+	log.Debug.Println("mergeNamedDests")
+	err = mergeNamedDests(ctxSource, ctxDest, srcRootDict)
+	if err != nil {
+		return err
+	}
+
+	log.Debug.Println("mergeOutlines")
+	err = mergeOutlines(ctxSource, ctxDest, srcRootDict)
+	if err != nil {
+		return err
+	}
+
 	// Mark source's root object as free.
 	err = ctxDest.DeleteObject(int(ctxSource.Root.ObjectNumber))
 	if err != nil {
@@ -328,3 +698,131 @@ func MergeXRefTables(ctxSource, ctxDest *Context) (err error) {
 
 	return nil
 }
+
+// moveAppendedPages moves the page tree node appended last to the page tree root behind page afterPage,
+// in front of the first page for afterPage 0.
+func moveAppendedPages(xRefTable *XRefTable, afterPage int) error {
+
+	rootIR, err := xRefTable.Pages()
+	if err != nil {
+		return err
+	}
+
+	root, err := xRefTable.DereferenceDict(*rootIR)
+	if err != nil {
+		return err
+	}
+
+	kids := root.ArrayEntry("Kids")
+	if len(kids) == 0 {
+		return errors.New("moveAppendedPages: corrupt page tree")
+	}
+
+	ir, ok := kids[len(kids)-1].(IndirectRef)
+	if !ok {
+		return errors.New("moveAppendedPages: corrupt page tree")
+	}
+
+	d, err := xRefTable.DereferenceDict(ir)
+	if err != nil {
+		return err
+	}
+
+	c, rc := d.IntEntry("Count"), root.IntEntry("Count")
+	if c == nil || rc == nil {
+		return errors.New("moveAppendedPages: missing page count")
+	}
+	n := *c
+
+	// Detach the appended pages.
+	root.Update("Kids", append(Array{}, kids[:len(kids)-1]...))
+	root.Update("Count", Integer(*rc-n))
+	xRefTable.PageCount -= n
+
+	p := afterPage
+	if p == 0 {
+		p = 1
+	}
+
+	leafIR, err := xRefTable.PageIndRef(p)
+	if err != nil {
+		return err
+	}
+
+	leaf, err := xRefTable.DereferenceDict(*leafIR)
+	if err != nil {
+		return err
+	}
+
+	parentIR := leaf.IndirectRefEntry("Parent")
+	if parentIR == nil {
+		return errors.New("moveAppendedPages: missing page parent")
+	}
+
+	parent, err := xRefTable.DereferenceDict(*parentIR)
+	if err != nil {
+		return err
+	}
+
+	kids = parent.ArrayEntry("Kids")
+
+	i := -1
+	for j, o := range kids {
+		if kid, ok := o.(IndirectRef); ok && kid.ObjectNumber == leafIR.ObjectNumber {
+			i = j
+			break
+		}
+	}
+	if i < 0 {
+		return errors.New("moveAppendedPages: corrupt page tree")
+	}
+
+	if afterPage > 0 {
+		i++
+	}
+
+	a := append(Array{}, kids[:i]...)
+	a = append(a, ir)
+	parent.Update("Kids", append(a, kids[i:]...))
+
+	d.Update("Parent", *parentIR)
+
+	// Update the page counts up to the root.
+	for parent != nil {
+		if c := parent.IntEntry("Count"); c != nil {
+			parent.Update("Count", Integer(*c+n))
+		}
+		o, found := parent.Find("Parent")
+		if !found {
+			break
+		}
+		if parent, err = xRefTable.DereferenceDict(o); err != nil {
+			return err
+		}
+	}
+
+	xRefTable.PageCount += n
+
+	return nil
+}
+
+// InsertXRefTables merges Context ctxSource into ctxDest inserting its pages behind page afterPage of ctxDest.
+// For afterPage 0 the pages get inserted in front of the first page.
+func InsertXRefTables(ctxSource, ctxDest *Context, afterPage int) error {
+
+	pageCount := ctxDest.PageCount
+
+	if afterPage < 0 || afterPage > pageCount {
+		return NewError(ErrPageOutOfRange, "InsertXRefTables: invalid page number: %d", afterPage)
+	}
+
+	if err := MergeXRefTables(ctxSource, ctxDest); err != nil {
+		return err
+	}
+
+	if afterPage == pageCount {
+		return nil
+	}
+
+	return moveAppendedPages(ctxDest.XRefTable, afterPage)
+}
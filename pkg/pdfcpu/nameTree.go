@@ -198,6 +198,14 @@ func (n *Node) Add(xRefTable *XRefTable, k string, v Object) error {
 		return nil
 	}
 
+	// Keep the limits of intermediary nodes up to date.
+	if k < n.Kmin {
+		n.Kmin = k
+	}
+	if k > n.Kmax {
+		n.Kmax = k
+	}
+
 	// For intermediary nodes we delegate to the corresponding subtree.
 	for _, a := range n.Kids {
 		if k < a.Kmin || a.withinLimits(k) {
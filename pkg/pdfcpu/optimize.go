@@ -17,10 +17,14 @@ limitations under the License.
 package pdfcpu
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"reflect"
 	"sort"
 	"strings"
 
+	"github.com/jplu/pdfcpu/pkg/filter"
 	"github.com/jplu/pdfcpu/pkg/log"
 	"github.com/pkg/errors"
 )
@@ -236,8 +240,10 @@ func optimizeFontResourcesDict(ctx *Context, rDict Dict, pageNumber, pageObjNumb
 
 	pageFonts := pageFonts(ctx, pageNumber)
 
-	// Iterate over font resource dict.
-	for rName, v := range rDict {
+	// Iterate over font resource dict in order so the same fonts survive each run.
+	for _, rName := range sortedKeys(rDict) {
+
+		v := rDict[rName]
 
 		indRef, ok := v.(IndirectRef)
 		if !ok {
@@ -327,7 +333,9 @@ func handleDuplicateImageObject(ctx *Context, imageDict *StreamDict, resourceNam
 	pageImages := ctx.Optimize.PageImages[pageNumber]
 
 	// Process image dict, check if this is a duplicate.
-	for imageObjNr, imageObject := range ctx.Optimize.ImageObjects {
+	for _, imageObjNr := range sortedIntKeys(ctx.Optimize.ImageObjects) {
+
+		imageObject := ctx.Optimize.ImageObjects[imageObjNr]
 
 		log.Optimize.Printf("handleDuplicateImageObject: comparing with imagedict Obj %d\n", imageObjNr)
 
@@ -369,8 +377,10 @@ func optimizeXObjectResourcesDict(ctx *Context, rDict Dict, pageNumber, pageObjN
 
 	pageImages := pageImages(ctx, pageNumber)
 
-	// Iterate over XObject resource dict.
-	for rName, v := range rDict {
+	// Iterate over XObject resource dict in order so the same images survive each run.
+	for _, rName := range sortedKeys(rDict) {
+
+		v := rDict[rName]
 
 		indRef, ok := v.(IndirectRef)
 		if !ok {
@@ -629,8 +639,8 @@ func traverseObjectGraphAndMarkDuplicates(xRefTable *XRefTable, obj Object, dupl
 
 	case Dict:
 		log.Optimize.Println("traverseObjectGraphAndMarkDuplicates: dict.")
-		for _, value := range x {
-			err := traverse(xRefTable, value, duplObjs)
+		for _, k := range sortedKeys(x) {
+			err := traverse(xRefTable, x[k], duplObjs)
 			if err != nil {
 				return err
 			}
@@ -638,8 +648,8 @@ func traverseObjectGraphAndMarkDuplicates(xRefTable *XRefTable, obj Object, dupl
 
 	case StreamDict:
 		log.Optimize.Println("traverseObjectGraphAndMarkDuplicates: streamDict.")
-		for _, value := range x.Dict {
-			err := traverse(xRefTable, value, duplObjs)
+		for _, k := range sortedKeys(x.Dict) {
+			err := traverse(xRefTable, x.Dict[k], duplObjs)
 			if err != nil {
 				return err
 			}
@@ -665,7 +675,8 @@ func calcRedundantObjects(ctx *Context) error {
 
 	log.Optimize.Println("calcRedundantObjects begin")
 
-	for i, fontDict := range ctx.Optimize.DuplicateFonts {
+	for _, i := range sortedIntKeys(ctx.Optimize.DuplicateFonts) {
+		fontDict := ctx.Optimize.DuplicateFonts[i]
 		ctx.Optimize.DuplicateFontObjs[i] = true
 		// Identify and mark all involved potential duplicate objects for a redundant font.
 		err := traverseObjectGraphAndMarkDuplicates(ctx.XRefTable, fontDict, ctx.Optimize.DuplicateFontObjs)
@@ -674,7 +685,8 @@ func calcRedundantObjects(ctx *Context) error {
 		}
 	}
 
-	for i, sd := range ctx.Optimize.DuplicateImages {
+	for _, i := range sortedIntKeys(ctx.Optimize.DuplicateImages) {
+		sd := ctx.Optimize.DuplicateImages[i]
 		ctx.Optimize.DuplicateImageObjs[i] = true
 		// Identify and mark all involved potential duplicate objects for a redundant image.
 		err := traverseObjectGraphAndMarkDuplicates(ctx.XRefTable, *sd, ctx.Optimize.DuplicateImageObjs)
@@ -732,6 +744,12 @@ func optimizeFontAndImages(ctx *Context) error {
 		return err
 	}
 
+	// Get rid of duplicate font programs embedded by different font dicts.
+	err = consolidateFontFiles(ctx)
+	if err != nil {
+		return err
+	}
+
 	// Identify all duplicate objects.
 	err = calcRedundantObjects(ctx)
 	if err != nil {
@@ -743,6 +761,428 @@ func optimizeFontAndImages(ctx *Context) error {
 	return nil
 }
 
+// fontDescriptorFontFileEntry returns the entry name and the indirect object for the font file for given font descriptor.
+func fontDescriptorFontFileEntry(fontDescriptorDict Dict) (string, *IndirectRef) {
+
+	for _, entryName := range []string{"FontFile", "FontFile2", "FontFile3"} {
+		if ir := fontDescriptorDict.IndirectRefEntry(entryName); ir != nil {
+			return entryName, ir
+		}
+	}
+
+	return "", nil
+}
+
+// handleDuplicateFontFile returns nil or the object number of the registered font file if it matches this font file.
+func handleDuplicateFontFile(ctx *Context, fontFiles map[int]*StreamDict, fontFileObjNrs []int, sd *StreamDict, objNr int) (*int, error) {
+
+	for _, fontFileObjNr := range fontFileObjNrs {
+
+		ok, err := equalStreamDicts(fontFiles[fontFileObjNr], sd, ctx.XRefTable)
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			continue
+		}
+
+		log.Optimize.Printf("handleDuplicateFontFile: redundant fontFile obj#:%d already registered with obj#:%d !\n", objNr, fontFileObjNr)
+
+		// Register font file as duplicate.
+		ctx.Optimize.DuplicateFontFiles[objNr] = sd
+		ctx.Optimize.DuplicateFontObjs[objNr] = true
+
+		return &fontFileObjNr, nil
+	}
+
+	return nil, nil
+}
+
+// Get rid of redundant font programs.
+// Font dicts coming from different files (eg. as a result of merging) usually differ in
+// subset prefix, widths or encoding and therefore survive font dict consolidation,
+// although they may embed identical font programs.
+// Font descriptors referring to such a duplicate get patched to refer to the original font file.
+// Font dicts and the resource names referring to them are kept, see ShareFontResourceNames.
+func consolidateFontFiles(ctx *Context) error {
+
+	log.Optimize.Println("consolidateFontFiles begin")
+
+	fontFiles := map[int]*StreamDict{}
+	var fontFileObjNrs []int
+
+	var objectNumbers []int
+	for k := range ctx.Optimize.FontObjects {
+		objectNumbers = append(objectNumbers, k)
+	}
+	sort.Ints(objectNumbers)
+
+	for _, objectNumber := range objectNumbers {
+
+		fontObject := ctx.Optimize.FontObjects[objectNumber]
+
+		// Only embedded fonts have font files.
+		if !fontObject.Embedded() {
+			continue
+		}
+
+		d, err := fontDescriptor(ctx.XRefTable, fontObject.FontDict, objectNumber)
+		if err != nil {
+			return err
+		}
+
+		if d == nil {
+			continue
+		}
+
+		entryName, ir := fontDescriptorFontFileEntry(d)
+		if ir == nil {
+			continue
+		}
+
+		objNr := ir.ObjectNumber.Value()
+
+		if _, found := fontFiles[objNr]; found {
+			// This font file has already been registered.
+			continue
+		}
+
+		sd, err := ctx.DereferenceStreamDict(*ir)
+		if err != nil {
+			return err
+		}
+
+		if sd == nil || sd.Raw == nil {
+			continue
+		}
+
+		originalObjNr, err := handleDuplicateFontFile(ctx, fontFiles, fontFileObjNrs, sd, objNr)
+		if err != nil {
+			return err
+		}
+
+		if originalObjNr != nil {
+			// We have identified a redundant font program!
+			// Update the font descriptor so that it points to the original.
+			d.Update(entryName, *NewIndirectRef(*originalObjNr, 0))
+			continue
+		}
+
+		fontFiles[objNr] = sd
+		fontFileObjNrs = append(fontFileObjNrs, objNr)
+	}
+
+	log.Optimize.Println("consolidateFontFiles end")
+
+	return nil
+}
+
+// renameFontOperands replaces the font resource names used by Tf operators of a decoded content stream.
+func renameFontOperands(content []byte, names map[string]string) ([]byte, bool, error) {
+
+	var (
+		b        bytes.Buffer
+		operands [][]byte
+		pos      []int
+		last     int
+		changed  bool
+	)
+
+	s := &contentScanner{b: content}
+
+	for {
+
+		tok, p, err := s.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, false, err
+		}
+
+		if !contentOperator(tok) {
+			operands = append(operands, tok)
+			pos = append(pos, p)
+			continue
+		}
+
+		switch string(tok) {
+
+		case "BI":
+			if _, err := s.inlineImage(p); err != nil {
+				return nil, false, err
+			}
+
+		case "Tf":
+			if len(operands) != 2 || operands[0][0] != '/' {
+				break
+			}
+			n, err := DecodeName(string(operands[0][1:]))
+			if err != nil {
+				break
+			}
+			if newName, ok := names[n]; ok {
+				b.Write(content[last:pos[0]])
+				b.WriteString("/" + EncodeName(newName))
+				last = pos[0] + len(operands[0])
+				changed = true
+			}
+		}
+
+		operands, pos = nil, nil
+	}
+
+	if !changed {
+		return content, false, nil
+	}
+
+	b.Write(content[last:])
+
+	return b.Bytes(), true, nil
+}
+
+// pageFontNames returns the renames needed for the fonts of fontDict to use the resource names
+// registered first for them during optimization.
+// Names already taken by another font are kept.
+func pageFontNames(ctx *Context, fontDict Dict) map[string]string {
+
+	taken := map[string]int{}
+	for n, o := range fontDict {
+		taken[n] = -1
+		if ir, ok := o.(IndirectRef); ok {
+			taken[n] = ir.ObjectNumber.Value()
+		}
+	}
+
+	names := map[string]string{}
+
+	for _, n := range sortedKeys(fontDict) {
+
+		ir, ok := fontDict[n].(IndirectRef)
+		if !ok {
+			continue
+		}
+		objNr := ir.ObjectNumber.Value()
+
+		fo, found := ctx.Optimize.FontObjects[objNr]
+		if !found || len(fo.ResourceNames) == 0 {
+			continue
+		}
+
+		newName := fo.ResourceNames[0]
+		if newName == n {
+			continue
+		}
+
+		if i, found := taken[newName]; found && i != objNr {
+			continue
+		}
+
+		names[n] = newName
+		taken[newName] = objNr
+	}
+
+	return names
+}
+
+// hasFormWithoutResources returns true if resources refers to a form XObject inheriting the resources of its page.
+func hasFormWithoutResources(ctx *Context, resources Dict) (bool, error) {
+
+	xoDict, err := ctx.DereferenceDict(resources["XObject"])
+	if err != nil || xoDict == nil {
+		return false, err
+	}
+
+	for _, o := range xoDict {
+		sd, err := ctx.DereferenceStreamDict(o)
+		if err != nil {
+			return false, err
+		}
+		if sd == nil {
+			continue
+		}
+		if st := sd.Subtype(); st != nil && *st == "Form" && sd.Dict["Resources"] == nil {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// shareFontResourceNames remaps the font resource names of page pageNr and returns true if its content changed.
+// Pages using objects in shared are left alone.
+func shareFontResourceNames(ctx *Context, pageNr int, shared IntSet) (bool, error) {
+
+	pageDict, _, err := ctx.PageDict(pageNr)
+	if err != nil {
+		return false, err
+	}
+	if pageDict == nil {
+		return false, errors.Errorf("shareFontResourceNames: missing page %d", pageNr)
+	}
+
+	// Inherited resources may be used by other pages.
+	o, found := pageDict.Find("Resources")
+	if !found {
+		return false, nil
+	}
+	if ir, ok := o.(IndirectRef); ok && shared[ir.ObjectNumber.Value()] {
+		return false, nil
+	}
+
+	resources, err := ctx.DereferenceDict(o)
+	if err != nil || resources == nil {
+		return false, err
+	}
+
+	if ir, ok := resources["Font"].(IndirectRef); ok && shared[ir.ObjectNumber.Value()] {
+		return false, nil
+	}
+
+	fontDict, err := ctx.DereferenceDict(resources["Font"])
+	if err != nil || fontDict == nil {
+		return false, err
+	}
+
+	names := pageFontNames(ctx, fontDict)
+	if len(names) == 0 {
+		return false, nil
+	}
+
+	// Forms without resources use the font names of the page.
+	ok, err := hasFormWithoutResources(ctx, resources)
+	if err != nil || ok {
+		return false, err
+	}
+
+	irs, err := pageContentStreams(ctx, pageDict)
+	if err != nil {
+		return false, err
+	}
+
+	// Either all content streams get remapped or none.
+	entries := map[int]*XRefTableEntry{}
+	sds := map[int]StreamDict{}
+
+	for _, ir := range irs {
+
+		objNr := ir.ObjectNumber.Value()
+		if shared[objNr] {
+			return false, nil
+		}
+
+		entry, found := ctx.FindTableEntryForIndRef(&ir)
+		if !found {
+			return false, nil
+		}
+
+		sd, ok := entry.Object.(StreamDict)
+		if !ok {
+			return false, nil
+		}
+
+		err = decodeStream(&sd)
+		if err == filter.ErrUnsupportedFilter {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		b, changed, err := renameFontOperands(sd.Content, names)
+		if err != nil {
+			log.Info.Printf("shareFontResourceNames: page %d: content stream obj#%d: %v\n", pageNr, objNr, err)
+			return false, nil
+		}
+
+		if changed {
+			sd.Content = b
+			entries[objNr] = entry
+			sds[objNr] = sd
+		}
+	}
+
+	for objNr, sd := range sds {
+		if err = encodeStream(&sd); err != nil {
+			return false, err
+		}
+		entries[objNr].Object = sd
+	}
+
+	for _, n := range sortedKeys(fontDict) {
+		if newName, ok := names[n]; ok {
+			log.Optimize.Printf("shareFontResourceNames: page %d: font %s -> %s\n", pageNr, n, newName)
+			fontDict[newName] = fontDict[n]
+			delete(fontDict, n)
+		}
+	}
+
+	return len(sds) > 0, nil
+}
+
+// ShareFontResourceNames lets all pages refer to a consolidated font by the same resource name.
+// Font dicts coming from different files (eg. as a result of merging) get consolidated during optimization,
+// but each page still uses the resource name of the file it comes from.
+// The name registered first for a font gets used on all pages, remapping the Tf operators of their content streams.
+// Pages inheriting their resources, sharing resources or content streams with other pages
+// and pages using form XObjects without resources are left alone.
+// Returns the set of pages whose content streams changed.
+func ShareFontResourceNames(ctx *Context) (IntSet, error) {
+
+	if ctx.Optimize == nil || !ctx.Optimized {
+		return nil, errors.New("ShareFontResourceNames: missing optimization")
+	}
+
+	seen, shared := IntSet{}, IntSet{}
+
+	mark := func(o Object) {
+		if ir, ok := o.(IndirectRef); ok {
+			objNr := ir.ObjectNumber.Value()
+			if seen[objNr] {
+				shared[objNr] = true
+			}
+			seen[objNr] = true
+		}
+	}
+
+	for i := 1; i <= ctx.PageCount; i++ {
+
+		pageDict, _, err := ctx.PageDict(i)
+		if err != nil {
+			return nil, err
+		}
+
+		mark(pageDict["Resources"])
+
+		if resources, err := ctx.DereferenceDict(pageDict["Resources"]); err == nil && resources != nil {
+			mark(resources["Font"])
+		}
+
+		irs, err := pageContentStreams(ctx, pageDict)
+		if err != nil {
+			return nil, err
+		}
+		for _, ir := range irs {
+			mark(ir)
+		}
+	}
+
+	pages := IntSet{}
+
+	for i := 1; i <= ctx.PageCount; i++ {
+		changed, err := shareFontResourceNames(ctx, i, shared)
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			pages[i] = true
+		}
+	}
+
+	return pages, nil
+}
+
 // Return stream length for font file object.
 func streamLengthFontFile(xRefTable *XRefTable, indirectRef *IndirectRef) (*int64, error) {
 
@@ -796,7 +1236,7 @@ func calcEmbeddedFontsMemoryUsage(ctx *Context) error {
 	}
 
 	// Iterate over font file references and calculate total font size.
-	for ir := range fontFileIndRefs {
+	for _, ir := range sortedIndRefs(fontFileIndRefs) {
 		streamLength, err := streamLengthFontFile(ctx.XRefTable, &ir)
 		if err != nil {
 			return err
@@ -927,6 +1367,38 @@ func fontDescriptor(xRefTable *XRefTable, fontDict Dict, objNr int) (Dict, error
 	return d, nil
 }
 
+// sortedIntKeys returns the keys of m in ascending order, m being a map with int keys.
+func sortedIntKeys(m interface{}) []int {
+
+	v := reflect.ValueOf(m)
+
+	keys := make([]int, 0, v.Len())
+	for _, k := range v.MapKeys() {
+		keys = append(keys, int(k.Int()))
+	}
+	sort.Ints(keys)
+
+	return keys
+}
+
+// sortedIndRefs returns the indirect references of m ordered by object number.
+func sortedIndRefs(m map[IndirectRef]bool) []IndirectRef {
+
+	irs := make([]IndirectRef, 0, len(m))
+	for ir := range m {
+		irs = append(irs, ir)
+	}
+
+	sort.Slice(irs, func(i, j int) bool {
+		if irs[i].ObjectNumber != irs[j].ObjectNumber {
+			return irs[i].ObjectNumber < irs[j].ObjectNumber
+		}
+		return irs[i].GenerationNumber < irs[j].GenerationNumber
+	})
+
+	return irs
+}
+
 // Record font file objects referenced by this fonts font descriptor for stats and size calculation.
 func processFontFilesForFontDict(xRefTable *XRefTable, fontDict Dict, objectNumber int, indRefsMap map[IndirectRef]bool) error {
 
@@ -959,7 +1431,9 @@ func calcRedundantEmbeddedFontsMemoryUsage(ctx *Context) error {
 	fontFileIndRefs := map[IndirectRef]bool{}
 
 	// Iterate over all duplicate fonts and record font file references.
-	for objectNumber, fontDict := range ctx.Optimize.DuplicateFonts {
+	for _, objectNumber := range sortedIntKeys(ctx.Optimize.DuplicateFonts) {
+
+		fontDict := ctx.Optimize.DuplicateFonts[objectNumber]
 
 		// Duplicate Fonts have to be embedded, so no check here.
 		if err := processFontFilesForFontDict(ctx.XRefTable, fontDict, objectNumber, fontFileIndRefs); err != nil {
@@ -969,7 +1443,7 @@ func calcRedundantEmbeddedFontsMemoryUsage(ctx *Context) error {
 	}
 
 	// Iterate over font file references and calculate total font size.
-	for ir := range fontFileIndRefs {
+	for _, ir := range sortedIndRefs(fontFileIndRefs) {
 
 		streamLength, err := streamLengthFontFile(ctx.XRefTable, &ir)
 		if err != nil {
@@ -979,6 +1453,13 @@ func calcRedundantEmbeddedFontsMemoryUsage(ctx *Context) error {
 		ctx.Read.BinaryFontDuplSize += *streamLength
 	}
 
+	// Add the memory used by duplicate font files referenced by consolidated font descriptors.
+	for _, sd := range ctx.Optimize.DuplicateFontFiles {
+		if sd.StreamLength != nil {
+			ctx.Read.BinaryFontDuplSize += *sd.StreamLength
+		}
+	}
+
 	log.Optimize.Println("calcRedundantEmbeddedFontsMemoryUsage end")
 
 	return nil
@@ -1050,6 +1531,13 @@ func OptimizeXRefTable(ctx *Context) error {
 
 	log.Optimize.Println("optimizeXRefTable begin")
 
+	// Turn large inline images into XObjects so they take part in image deduplication.
+	if ctx.InlineImageThreshold > 0 {
+		if err := ConvertInlineImages(ctx, ctx.InlineImageThreshold); err != nil {
+			return err
+		}
+	}
+
 	// Get rid of duplicate embedded fonts and images.
 	err := optimizeFontAndImages(ctx)
 	if err != nil {
@@ -1062,6 +1550,12 @@ func OptimizeXRefTable(ctx *Context) error {
 		return err
 	}
 
+	if len(ctx.ImagePolicy) > 0 {
+		if _, err = RecompressImages(ctx.XRefTable, ctx.ImagePolicy); err != nil {
+			return err
+		}
+	}
+
 	ctx.Optimized = true
 
 	log.Optimize.Println("optimizeXRefTable end")
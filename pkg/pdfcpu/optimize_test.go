@@ -0,0 +1,40 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import "testing"
+
+func TestRenameFontOperands(t *testing.T) {
+
+	names := map[string]string{"F2": "F1", "F 3": "F3"}
+
+	for content, want := range map[string]string{
+		"BT /F2 12 Tf (F2) Tj ET":            "BT /F1 12 Tf (F2) Tj ET",
+		"BT /F#203 9 Tf /F2 1 Tf ET":         "BT /F3 9 Tf /F1 1 Tf ET",
+		"/F2 gs /F2 Do BT /F4 12 Tf ET":      "/F2 gs /F2 Do BT /F4 12 Tf ET",
+		"BI /W 1 /H 1 ID /F2 EI /F2 12 Tf":   "BI /W 1 /H 1 ID /F2 EI /F1 12 Tf",
+		"/P <</MCID 0>> BDC /F2 10.5 Tf EMC": "/P <</MCID 0>> BDC /F1 10.5 Tf EMC",
+	} {
+		b, _, err := renameFontOperands([]byte(content), names)
+		if err != nil {
+			t.Fatalf("renameFontOperands(%q): %v\n", content, err)
+		}
+		if got := string(b); got != want {
+			t.Fatalf("renameFontOperands(%q): want %q, got %q\n", content, want, got)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/jplu/pdfcpu/pkg/log"
+	"github.com/pkg/errors"
+)
+
+// OutlineMergeMode specifies how the outlines of merged files are combined.
+type OutlineMergeMode int
+
+// The available outline merge modes.
+const (
+	OutlinesNone   OutlineMergeMode = iota // Drop all outlines.
+	OutlinesFlat                           // Concatenate the outlines of all files.
+	OutlinesNested                         // Nest the outlines of each file under an entry named after the file.
+)
+
+// firstPageIndRef returns the first page of the page tree node ir.
+func firstPageIndRef(xRefTable *XRefTable, ir IndirectRef) (*IndirectRef, error) {
+
+	for i := 0; i < 1000; i++ {
+
+		d, err := xRefTable.DereferenceDict(ir)
+		if err != nil {
+			return nil, err
+		}
+		if d == nil {
+			return nil, errors.New("corrupt page tree")
+		}
+
+		if t := d.Type(); t != nil && *t == "Page" {
+			return &ir, nil
+		}
+
+		kids := d.ArrayEntry("Kids")
+		if len(kids) == 0 {
+			return nil, errors.New("empty page tree")
+		}
+
+		next, ok := kids[0].(IndirectRef)
+		if !ok {
+			return nil, errors.New("corrupt page tree")
+		}
+		ir = next
+	}
+
+	return nil, errors.New("page tree too deep")
+}
+
+// visibleOutlineItems returns the number of visible items of the sibling chain starting with first.
+func visibleOutlineItems(xRefTable *XRefTable, first Object) (int, error) {
+
+	n := 0
+	seen := IntSet{}
+
+	for o := first; o != nil; {
+
+		ir, ok := o.(IndirectRef)
+		if !ok || seen[ir.ObjectNumber.Value()] {
+			break
+		}
+		seen[ir.ObjectNumber.Value()] = true
+
+		d, err := xRefTable.DereferenceDict(ir)
+		if err != nil {
+			return 0, err
+		}
+		if d == nil {
+			break
+		}
+
+		n++
+
+		// Open items show their descendants.
+		if c := d.IntEntry("Count"); c != nil && *c > 0 {
+			n += *c
+		}
+
+		o = d["Next"]
+	}
+
+	return n, nil
+}
+
+func updateOutlineCount(xRefTable *XRefTable, d Dict) error {
+
+	n, err := visibleOutlineItems(xRefTable, d["First"])
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		d.Delete("Count")
+		return nil
+	}
+
+	d.Update("Count", Integer(n))
+
+	return nil
+}
+
+// appendOutlineItems moves the sibling chain first..last below parent.
+func appendOutlineItems(xRefTable *XRefTable, parent Dict, parentIR IndirectRef, first, last IndirectRef) error {
+
+	seen := IntSet{}
+
+	for ir := first; ; {
+
+		if seen[ir.ObjectNumber.Value()] {
+			break
+		}
+		seen[ir.ObjectNumber.Value()] = true
+
+		d, err := xRefTable.DereferenceDict(ir)
+		if err != nil {
+			return err
+		}
+		if d == nil {
+			return errors.New("corrupt outline item")
+		}
+
+		d.Update("Parent", parentIR)
+
+		next, ok := d["Next"].(IndirectRef)
+		if !ok || ir.ObjectNumber == last.ObjectNumber {
+			break
+		}
+		ir = next
+	}
+
+	if l, ok := parent["Last"].(IndirectRef); ok {
+		d, err := xRefTable.DereferenceDict(l)
+		if err != nil {
+			return err
+		}
+		d.Update("Next", first)
+		f, err := xRefTable.DereferenceDict(first)
+		if err != nil {
+			return err
+		}
+		f.Update("Prev", l)
+	} else {
+		parent.Update("First", first)
+	}
+
+	parent.Update("Last", last)
+
+	return updateOutlineCount(xRefTable, parent)
+}
+
+// outlineRoot returns the outline dictionary of rootDict, if create is true a missing one gets created.
+func outlineRoot(xRefTable *XRefTable, rootDict Dict, create bool) (Dict, *IndirectRef, error) {
+
+	if ir, ok := rootDict["Outlines"].(IndirectRef); ok {
+		d, err := xRefTable.DereferenceDict(ir)
+		if err != nil || d != nil {
+			return d, &ir, err
+		}
+	}
+
+	if !create {
+		return nil, nil, nil
+	}
+
+	d := Dict(map[string]Object{"Type": Name("Outlines")})
+
+	ir, err := xRefTable.IndRefForNewObject(d)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rootDict.Update("Outlines", *ir)
+
+	return d, ir, nil
+}
+
+// outlineTitle returns a title for the outline entry of a merged file.
+func outlineTitle(fileName string, from, thru int) string {
+
+	if fileName != "" {
+		fn := filepath.Base(fileName)
+		return strings.TrimSuffix(fn, filepath.Ext(fn))
+	}
+
+	return fmt.Sprintf("Pages %d-%d", from, thru)
+}
+
+// addOutlineEntry appends an item titled title pointing to page to the outline root
+// and moves the items of outline dictionary d below it.
+func addOutlineEntry(xRefTable *XRefTable, root Dict, rootIR IndirectRef, title string, page IndirectRef, d Dict) error {
+
+	t, err := textString(title)
+	if err != nil {
+		return err
+	}
+
+	item := Dict(
+		map[string]Object{
+			"Title":  t,
+			"Parent": rootIR,
+			"Dest":   Array{page, Name("Fit")},
+		},
+	)
+
+	ir, err := xRefTable.IndRefForNewObject(item)
+	if err != nil {
+		return err
+	}
+
+	if d != nil {
+		first, ok1 := d["First"].(IndirectRef)
+		last, ok2 := d["Last"].(IndirectRef)
+		if ok1 && ok2 {
+			if err = appendOutlineItems(xRefTable, item, *ir, first, last); err != nil {
+				return err
+			}
+		}
+	}
+
+	return appendOutlineItems(xRefTable, root, rootIR, *ir, *ir)
+}
+
+// nestDestOutlines moves the outlines of the first file of a merge below an entry named after the file.
+func nestDestOutlines(ctxDest *Context, destRootDict Dict, pageCount int) error {
+
+	d, _, err := outlineRoot(ctxDest.XRefTable, destRootDict, false)
+	if err != nil {
+		return err
+	}
+
+	// Detach the original outline dictionary and start over.
+	destRootDict.Delete("Outlines")
+
+	root, rootIR, err := outlineRoot(ctxDest.XRefTable, destRootDict, true)
+	if err != nil {
+		return err
+	}
+
+	pages, err := ctxDest.Pages()
+	if err != nil {
+		return err
+	}
+
+	page, err := firstPageIndRef(ctxDest.XRefTable, *pages)
+	if err != nil {
+		return err
+	}
+
+	return addOutlineEntry(ctxDest.XRefTable, root, *rootIR, outlineTitle(ctxDest.Read.FileName, 1, pageCount), *page, d)
+}
+
+// mergeOutlines combines the outlines of ctxSource with those of ctxDest according to ctxDest.MergeOutlines.
+// Destinations need no remapping since pages keep their objects.
+func mergeOutlines(ctxSource, ctxDest *Context, srcRootDict Dict) error {
+
+	mode := ctxDest.MergeOutlines
+	if mode == OutlinesNone {
+		return nil
+	}
+
+	xRefTable := ctxDest.XRefTable
+
+	destRootDict, err := xRefTable.Catalog()
+	if err != nil {
+		return err
+	}
+
+	if mode == OutlinesNested && !xRefTable.outlinesNested {
+		if err = nestDestOutlines(ctxDest, destRootDict, ctxDest.PageCount-ctxSource.PageCount); err != nil {
+			return err
+		}
+		xRefTable.outlinesNested = true
+	}
+
+	src, _, err := outlineRoot(xRefTable, srcRootDict, false)
+	if err != nil {
+		return err
+	}
+
+	root, rootIR, err := outlineRoot(xRefTable, destRootDict, src != nil || mode == OutlinesNested)
+	if err != nil || root == nil {
+		return err
+	}
+
+	if mode == OutlinesNested {
+
+		pages, ok := srcRootDict["Pages"].(IndirectRef)
+		if !ok {
+			return errors.New("mergeOutlines: missing source page tree")
+		}
+
+		page, err := firstPageIndRef(xRefTable, pages)
+		if err != nil {
+			return err
+		}
+
+		from := ctxDest.PageCount - ctxSource.PageCount + 1
+		title := outlineTitle(ctxSource.Read.FileName, from, ctxDest.PageCount)
+
+		log.Debug.Printf("mergeOutlines: nesting outlines of %s\n", title)
+
+		return addOutlineEntry(xRefTable, root, *rootIR, title, *page, src)
+	}
+
+	first, ok1 := src["First"].(IndirectRef)
+	last, ok2 := src["Last"].(IndirectRef)
+	if !ok1 || !ok2 {
+		return nil
+	}
+
+	return appendOutlineItems(xRefTable, root, *rootIR, first, last)
+}
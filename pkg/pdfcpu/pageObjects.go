@@ -0,0 +1,290 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Page attributes that may be inherited from an ancestor page tree node.
+// See 7.7.3.4 Inheritance of Page Attributes
+var inheritablePageAttrs = []string{"Resources", "MediaBox", "CropBox", "Rotate"}
+
+func isPageNode(d Dict) bool {
+	t := d.Type()
+	return t != nil && (*t == "Page" || *t == "Pages")
+}
+
+// Collect the object numbers of all objects reachable from o.
+// Page tree nodes are not followed via their "Parent" entry.
+func objectClosure(o Object, objNrs IntSet, load func(objNr int) (Object, error)) error {
+	return collectObjects(o, objNrs, load, false)
+}
+
+// Collect the object numbers of all objects reachable from the page ir.
+// Other pages referred to eg. by annotations via "Dest", "P" or by article beads are not followed.
+func pageObjectClosure(ir IndirectRef, objNrs IntSet, load func(objNr int) (Object, error)) error {
+
+	objNr := ir.ObjectNumber.Value()
+	if objNrs[objNr] {
+		return nil
+	}
+	objNrs[objNr] = true
+
+	o, err := load(objNr)
+	if err != nil {
+		return err
+	}
+
+	return collectObjects(o, objNrs, load, true)
+}
+
+func collectObjects(o Object, objNrs IntSet, load func(objNr int) (Object, error), stopAtPages bool) error {
+
+	switch o := o.(type) {
+
+	case IndirectRef:
+		objNr := o.ObjectNumber.Value()
+		if objNrs[objNr] {
+			return nil
+		}
+		obj, err := load(objNr)
+		if err != nil {
+			return err
+		}
+		if d, ok := obj.(Dict); ok && stopAtPages && isPageNode(d) {
+			return nil
+		}
+		objNrs[objNr] = true
+		return collectObjects(obj, objNrs, load, stopAtPages)
+
+	case Dict:
+		for k, v := range o {
+			if k == "Parent" && isPageNode(o) {
+				continue
+			}
+			if err := collectObjects(v, objNrs, load, stopAtPages); err != nil {
+				return err
+			}
+		}
+
+	case StreamDict:
+		return collectObjects(o.Dict, objNrs, load, stopAtPages)
+
+	case Array:
+		for _, v := range o {
+			if err := collectObjects(v, objNrs, load, stopAtPages); err != nil {
+				return err
+			}
+		}
+
+	}
+
+	return nil
+}
+
+func dictForIndRef(ir IndirectRef, load func(objNr int) (Object, error)) (Dict, error) {
+
+	o, err := load(ir.ObjectNumber.Value())
+	if err != nil {
+		return nil, err
+	}
+
+	d, ok := o.(Dict)
+	if !ok {
+		return nil, errors.Errorf("dictForIndRef: corrupt dict, obj#%d", ir.ObjectNumber.Value())
+	}
+
+	return d, nil
+}
+
+// pageNodeType returns the type of the page tree node d.
+// A missing type is derived from the presence of "Kids".
+func pageNodeType(d Dict) string {
+
+	if t := d.Type(); t != nil {
+		return *t
+	}
+
+	if _, found := d.Find("Kids"); found {
+		return "Pages"
+	}
+
+	return "Page"
+}
+
+// Walk down the page tree visiting only the page nodes needed to locate page pageNr.
+// Inheritable page attributes along the way are recorded in attrs.
+func locatePage(ir IndirectRef, attrs Dict, p *int, pageNr int, load func(objNr int) (Object, error)) (*IndirectRef, Dict, error) {
+	return locatePageNode(ir, attrs, p, pageNr, load, IntSet{})
+}
+
+func locatePageNode(ir IndirectRef, attrs Dict, p *int, pageNr int, load func(objNr int) (Object, error), visited IntSet) (*IndirectRef, Dict, error) {
+
+	if visited[ir.ObjectNumber.Value()] {
+		return nil, nil, errors.New("locatePage: corrupt page tree")
+	}
+	visited[ir.ObjectNumber.Value()] = true
+
+	d, err := dictForIndRef(ir, load)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, k := range inheritablePageAttrs {
+		if o, found := d.Find(k); found {
+			attrs.Update(k, o)
+		}
+	}
+
+	if pageNodeType(d) == "Page" {
+		return &ir, d, nil
+	}
+
+	kids := d.ArrayEntry("Kids")
+	if kids == nil {
+		return nil, nil, errors.New("locatePage: corrupt \"Kids\" entry")
+	}
+
+	for _, o := range kids {
+
+		if o == nil {
+			continue
+		}
+
+		kidIndRef, ok := o.(IndirectRef)
+		if !ok {
+			return nil, nil, errors.New("locatePage: corrupt page node dict")
+		}
+
+		kid, err := dictForIndRef(kidIndRef, load)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch pageNodeType(kid) {
+
+		case "Pages":
+			pageCount := kid.IntEntry("Count")
+			if pageCount == nil {
+				return nil, nil, errors.New("locatePage: missing \"Count\"")
+			}
+			if *p+*pageCount < pageNr {
+				// Skip sub pagetree.
+				*p += *pageCount
+				continue
+			}
+			return locatePageNode(kidIndRef, attrs, p, pageNr, load, visited)
+
+		case "Page":
+			*p++
+			if *p == pageNr {
+				return locatePageNode(kidIndRef, attrs, p, pageNr, load, visited)
+			}
+
+		}
+	}
+
+	return nil, nil, NewError(ErrPageOutOfRange, "locatePage: page %d not found", pageNr)
+}
+
+// loadObject returns the object for objNr, nil for free or missing objects.
+func (xRefTable *XRefTable) loadObject(objNr int) (Object, error) {
+
+	entry, found := xRefTable.Find(objNr)
+	if !found || entry.Free {
+		return nil, nil
+	}
+
+	return entry.Object, nil
+}
+
+// Return the approximate number of bytes o occupies when written.
+func objectSize(o Object) int64 {
+
+	if o == nil {
+		return 0
+	}
+
+	size := int64(len(o.PDFString()))
+
+	if sd, ok := o.(StreamDict); ok {
+		if sd.StreamLength != nil {
+			size += *sd.StreamLength
+		} else {
+			size += int64(len(sd.Raw))
+		}
+	}
+
+	return size
+}
+
+// PageObjects returns the object numbers of all objects reachable from page pageNr
+// mapped to their approximate size in bytes including any stream data.
+// Resources, MediaBox, CropBox and Rotate inherited from ancestor page tree nodes are taken into account.
+// Other pages referred to by this page are not, see ReadPage.
+func (xRefTable *XRefTable) PageObjects(pageNr int) (map[int]int64, error) {
+
+	if pageNr < 1 {
+		return nil, NewError(ErrPageOutOfRange, "PageObjects: invalid page number %d", pageNr)
+	}
+
+	root, err := xRefTable.Pages()
+	if err != nil {
+		return nil, err
+	}
+
+	if root == nil {
+		return nil, errors.New("PageObjects: missing \"Pages\"")
+	}
+
+	load := xRefTable.loadObject
+
+	attrs := Dict{}
+	p := 0
+
+	pageIndRef, pageDict, err := locatePage(*root, attrs, &p, pageNr, load)
+	if err != nil {
+		return nil, err
+	}
+
+	objNrs := IntSet{}
+
+	err = pageObjectClosure(*pageIndRef, objNrs, load)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range attrs {
+		if _, found := pageDict.Find(k); found {
+			continue
+		}
+		err = collectObjects(v, objNrs, load, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	m := map[int]int64{}
+
+	for objNr := range objNrs {
+		o, _ := load(objNr)
+		m[objNr] = objectSize(o)
+	}
+
+	return m, nil
+}
@@ -0,0 +1,247 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"github.com/jplu/pdfcpu/pkg/log"
+	"github.com/pkg/errors"
+)
+
+// PageTreeFanOutDefault is a reasonable number of kids per page tree node.
+const PageTreeFanOutDefault = 10
+
+// collectPageLeaves appends the page dicts of the page tree node ir in document order to leaves
+// and the objNrs of all intermediate page tree nodes to nodes.
+// Attributes inherited from intermediate page tree nodes get copied into any page not defining them.
+func (xRefTable *XRefTable) collectPageLeaves(ir IndirectRef, inherited Dict, visited IntSet, leaves *[]IndirectRef, nodes *[]int) error {
+
+	objNr := ir.ObjectNumber.Value()
+	if visited[objNr] {
+		return errors.Errorf("collectPageLeaves: page tree node obj#%d referenced more than once", objNr)
+	}
+	visited[objNr] = true
+
+	d, err := xRefTable.DereferenceDict(ir)
+	if err != nil {
+		return err
+	}
+	if d == nil {
+		return errors.Errorf("collectPageLeaves: missing page tree node obj#%d", objNr)
+	}
+
+	if t := d.Type(); t == nil || *t != "Pages" {
+		for _, k := range inheritablePageAttrs {
+			if o, found := inherited[k]; found {
+				d.Insert(k, cloneObject(o))
+			}
+		}
+		*leaves = append(*leaves, ir)
+		return nil
+	}
+
+	*nodes = append(*nodes, objNr)
+
+	inh := Dict{}
+	for k, v := range inherited {
+		inh[k] = v
+	}
+	for _, k := range inheritablePageAttrs {
+		if o, found := d.Find(k); found && o != nil {
+			inh[k] = o
+		}
+	}
+
+	for _, o := range d.ArrayEntry("Kids") {
+		kid, ok := o.(IndirectRef)
+		if !ok {
+			continue
+		}
+		if err = xRefTable.collectPageLeaves(kid, inh, visited, leaves, nodes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RebalancePageTree flattens the page tree and rebuilds it with at most fanOut kids per node.
+// Page order and inherited page attributes are preserved, Count and Parent entries are recomputed.
+func (xRefTable *XRefTable) RebalancePageTree(fanOut int) error {
+
+	log.Debug.Printf("RebalancePageTree begin: fanOut=%d\n", fanOut)
+
+	if fanOut < 2 {
+		return errors.Errorf("RebalancePageTree: fanOut must be at least 2, got %d", fanOut)
+	}
+
+	root, err := xRefTable.Pages()
+	if err != nil {
+		return err
+	}
+	if root == nil {
+		return errors.New("RebalancePageTree: missing page tree root")
+	}
+
+	rootDict, err := xRefTable.DereferenceDict(*root)
+	if err != nil {
+		return err
+	}
+	if rootDict == nil {
+		return errors.New("RebalancePageTree: missing page tree root")
+	}
+
+	// The root keeps its attributes, so only attributes of intermediate nodes need to be pushed down.
+	var leaves []IndirectRef
+	var nodes []int
+	visited := IntSet{root.ObjectNumber.Value(): true}
+
+	for _, o := range rootDict.ArrayEntry("Kids") {
+		kid, ok := o.(IndirectRef)
+		if !ok {
+			continue
+		}
+		if err = xRefTable.collectPageLeaves(kid, Dict{}, visited, &leaves, &nodes); err != nil {
+			return err
+		}
+	}
+
+	// Build the tree bottom up.
+	kids := make(Array, len(leaves))
+	counts := make([]int, len(leaves))
+	for i, ir := range leaves {
+		kids[i] = ir
+		counts[i] = 1
+	}
+
+	for len(kids) > fanOut {
+
+		var level Array
+		var levelCounts []int
+
+		for i := 0; i < len(kids); i += fanOut {
+
+			j := i + fanOut
+			if j > len(kids) {
+				j = len(kids)
+			}
+
+			c := 0
+			for _, n := range counts[i:j] {
+				c += n
+			}
+
+			d := Dict(map[string]Object{
+				"Type":  Name("Pages"),
+				"Kids":  append(Array{}, kids[i:j]...),
+				"Count": Integer(c),
+			})
+
+			ir, err := xRefTable.IndRefForNewObject(d)
+			if err != nil {
+				return err
+			}
+
+			level = append(level, *ir)
+			levelCounts = append(levelCounts, c)
+		}
+
+		kids, counts = level, levelCounts
+	}
+
+	rootDict.Update("Kids", kids)
+	rootDict.Update("Count", Integer(len(leaves)))
+
+	if err = xRefTable.setPageTreeParents(*root, rootDict); err != nil {
+		return err
+	}
+
+	// The former intermediate nodes are orphans now.
+	for _, objNr := range nodes {
+		if err = xRefTable.DeleteObject(objNr); err != nil {
+			return err
+		}
+	}
+
+	xRefTable.PageCount = len(leaves)
+
+	log.Debug.Printf("RebalancePageTree end: %d pages\n", len(leaves))
+
+	return nil
+}
+
+// MaterializeInheritedPageAttrs copies the attributes inherited from ancestor page tree nodes
+// into every page dict not defining them, so page dicts become self-contained.
+func (xRefTable *XRefTable) MaterializeInheritedPageAttrs() error {
+
+	root, err := xRefTable.Pages()
+	if err != nil {
+		return err
+	}
+	if root == nil {
+		return errors.New("MaterializeInheritedPageAttrs: missing page tree root")
+	}
+
+	var leaves []IndirectRef
+	var nodes []int
+
+	return xRefTable.collectPageLeaves(*root, Dict{}, IntSet{}, &leaves, &nodes)
+}
+
+func (xRefTable *XRefTable) setPageTreeParents(ir IndirectRef, d Dict) error {
+
+	for _, o := range d.ArrayEntry("Kids") {
+
+		kid := o.(IndirectRef)
+
+		kidDict, err := xRefTable.DereferenceDict(kid)
+		if err != nil {
+			return err
+		}
+
+		kidDict.Update("Parent", ir)
+
+		if t := kidDict.Type(); t != nil && *t == "Pages" {
+			if err = xRefTable.setPageTreeParents(kid, kidDict); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// PageIndRef returns the indirect reference of the page dict for page.
+func (xRefTable *XRefTable) PageIndRef(page int) (*IndirectRef, error) {
+
+	ir, err := xRefTable.Pages()
+	if err != nil {
+		return nil, err
+	}
+	if ir == nil {
+		return nil, errors.New("PageIndRef: missing page tree root")
+	}
+
+	if page < 1 || page > xRefTable.PageCount {
+		return nil, NewError(ErrPageOutOfRange, "PageIndRef: invalid page number: %d", page)
+	}
+
+	p := 0
+
+	ir, _, err = locatePage(*ir, Dict{}, &p, page, xRefTable.loadObject)
+
+	return ir, err
+}
@@ -0,0 +1,218 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"fmt"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// PasswordPolicy defines the requirements passwords have to meet when encrypting
+// or when changing the user or owner password.
+//
+// An empty user password is always accepted because it means
+// the document may be opened without supplying a password.
+type PasswordPolicy struct {
+
+	// Minimum number of characters.
+	MinLength int
+
+	// Minimum number of character classes used.
+	// The classes are lower case letters, upper case letters, digits and other characters.
+	MinCharClasses int
+}
+
+// passwordCharClasses returns the number of character classes used in pw.
+func passwordCharClasses(pw string) int {
+
+	var lower, upper, digit, other bool
+
+	for _, r := range pw {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+
+	c := 0
+	for _, b := range []bool{lower, upper, digit, other} {
+		if b {
+			c++
+		}
+	}
+
+	return c
+}
+
+// Check returns an error if pw does not satisfy p.
+func (p PasswordPolicy) Check(pw, name string) error {
+
+	if l := len([]rune(pw)); l < p.MinLength {
+		return errors.Errorf("password policy: %s password too short: %d characters, need at least %d", name, l, p.MinLength)
+	}
+
+	if c := passwordCharClasses(pw); c < p.MinCharClasses {
+		return errors.Errorf("password policy: %s password uses %d character classes, need at least %d", name, c, p.MinCharClasses)
+	}
+
+	return nil
+}
+
+// checkPasswordPolicy checks the passwords about to be used for writing against the configured policy.
+func checkPasswordPolicy(ctx *Context) error {
+
+	p := ctx.PasswordPolicy
+	if p == nil {
+		return nil
+	}
+
+	upw, opw := &ctx.UserPW, &ctx.OwnerPW
+
+	if ctx.Mode != ENCRYPT {
+		// Only check passwords getting changed.
+		upw, opw = ctx.UserPWNew, ctx.OwnerPWNew
+	}
+
+	if upw != nil && len(*upw) > 0 {
+		if err := p.Check(*upw, "user"); err != nil {
+			return err
+		}
+	}
+
+	if opw != nil {
+		if err := p.Check(*opw, "owner"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// encryptionStrength returns the algorithm and the key length in bits of the encrypt dict d.
+func encryptionStrength(d Dict) (string, int) {
+
+	alg := "RC4"
+	if cf := d.DictEntry("CF").DictEntry("StdCF"); cf != nil {
+		if cfm := cf.NameEntry("CFM"); cfm != nil && (*cfm == "AESV2" || *cfm == "AESV3") {
+			alg = "AES"
+		}
+	}
+
+	bits := 40
+	if l := d.IntEntry("Length"); l != nil {
+		bits = *l
+	}
+
+	return alg, bits
+}
+
+// checkEncryptionStrength refuses to encrypt using a key shorter than 128 bits unless forced.
+func checkEncryptionStrength(ctx *Context, d Dict) error {
+
+	if ctx.ForceWeakEncryption {
+		return nil
+	}
+
+	alg, bits := encryptionStrength(d)
+	if bits >= 128 {
+		return nil
+	}
+
+	return errors.Errorf("encrypt: refusing to use %s with a %d bit key, set ForceWeakEncryption to override", alg, bits)
+}
+
+// weakEncryption returns a description of the encryption in effect for writing ctx if it is considered weak.
+// All encryption supported for writing is weak: RC4 and AES using a 128 bit key.
+func weakEncryption(ctx *Context) (string, bool) {
+
+	if ctx.E == nil || ctx.EncKey == nil {
+		return "", false
+	}
+
+	if ctx.E.V == 4 && ctx.AES4Streams {
+		if len(ctx.EncKey) > 16 {
+			return "", false
+		}
+		return "AES-128", true
+	}
+
+	return fmt.Sprintf("RC4-%d", len(ctx.EncKey)*8), true
+}
+
+// checkEncryptionPolicy refuses to write weak encryption if forbidden by the configuration.
+func checkEncryptionPolicy(ctx *Context) error {
+
+	if !ctx.ForbidWeakEncryption || ctx.ForceWeakEncryption {
+		return nil
+	}
+
+	alg, weak := weakEncryption(ctx)
+	if !weak {
+		return nil
+	}
+
+	return errors.Errorf("write: %s encryption is deprecated and forbidden by ForbidWeakEncryption, set ForceWeakEncryption to override", alg)
+}
+
+func passwordReport(pw, name string) string {
+
+	if len(pw) == 0 {
+		return fmt.Sprintf("%s password: empty", name)
+	}
+
+	return fmt.Sprintf("%s password: %d characters, %d character classes", name, len([]rune(pw)), passwordCharClasses(pw))
+}
+
+// EncryptionReport describes the encryption and key derivation in effect for writing ctx.
+func EncryptionReport(ctx *Context) []string {
+
+	e := ctx.E
+	if e == nil || ctx.EncKey == nil {
+		return []string{"no encryption"}
+	}
+
+	alg := "RC4"
+	if e.V == 4 && ctx.AES4Streams {
+		alg = "AES"
+	}
+
+	iter := "MD5"
+	if e.R >= 3 {
+		iter = "MD5 + 50 iterations"
+	}
+
+	ss := []string{
+		fmt.Sprintf("algorithm: %s %d bit (V=%d R=%d)", alg, len(ctx.EncKey)*8, e.V, e.R),
+		fmt.Sprintf("key derivation: padded password, %s, key length %d bytes", iter, len(ctx.EncKey)),
+		passwordReport(ctx.UserPW, "user"),
+		passwordReport(ctx.OwnerPW, "owner"),
+	}
+
+	if !e.Emd {
+		ss = append(ss, "metadata: not encrypted")
+	}
+
+	return ss
+}
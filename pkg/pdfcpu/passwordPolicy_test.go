@@ -0,0 +1,47 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import "testing"
+
+func TestCheckEncryptionStrength(t *testing.T) {
+
+	aes256 := newEncryptDict(true, true, true, PermissionsAll)
+	aes256.Update("Length", Integer(256))
+	aes256.DictEntry("CF").DictEntry("StdCF").Update("CFM", Name("AESV3"))
+
+	for _, tt := range []struct {
+		msg  string
+		d    Dict
+		weak bool
+	}{
+		{"RC4 40", newEncryptDict(false, false, true, PermissionsAll), true},
+		{"RC4 128", newEncryptDict(false, true, true, PermissionsAll), false},
+		{"AES 128", newEncryptDict(true, true, true, PermissionsAll), false},
+		{"AES 256", aes256, false},
+	} {
+		ctx := &Context{Configuration: NewDefaultConfiguration()}
+		if err := checkEncryptionStrength(ctx, tt.d); (err != nil) != tt.weak {
+			t.Fatalf("%s: want refused=%t, got %v\n", tt.msg, tt.weak, err)
+		}
+
+		ctx.ForceWeakEncryption = true
+		if err := checkEncryptionStrength(ctx, tt.d); err != nil {
+			t.Fatalf("%s: forced: %v\n", tt.msg, err)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import "unicode/utf8"
+
+// pdfDocEncoding maps the PDFDocEncoding codes deviating from Latin-1 to unicode, see Annex D.2.
+// The codes 0x7F, 0x9F and 0xAD are undefined.
+var pdfDocEncoding = map[byte]rune{
+	0x18: '˘', // breve
+	0x19: 'ˇ', // caron
+	0x1A: 'ˆ', // circumflex
+	0x1B: '˙', // dotaccent
+	0x1C: '˝', // hungarumlaut
+	0x1D: '˛', // ogonek
+	0x1E: '˚', // ring
+	0x1F: '˜', // tilde
+	0x7F: utf8.RuneError,
+	0x80: '•', // bullet
+	0x81: '†', // dagger
+	0x82: '‡', // daggerdbl
+	0x83: '…', // ellipsis
+	0x84: '—', // emdash
+	0x85: '–', // endash
+	0x86: 'ƒ', // florin
+	0x87: '⁄', // fraction
+	0x88: '‹', // guilsinglleft
+	0x89: '›', // guilsinglright
+	0x8A: '−', // minus
+	0x8B: '‰', // perthousand
+	0x8C: '„', // quotedblbase
+	0x8D: '“', // quotedblleft
+	0x8E: '”', // quotedblright
+	0x8F: '‘', // quoteleft
+	0x90: '’', // quoteright
+	0x91: '‚', // quotesinglbase
+	0x92: '™', // trademark
+	0x93: 'ﬁ', // fi
+	0x94: 'ﬂ', // fl
+	0x95: 'Ł', // Lslash
+	0x96: 'Œ', // OE
+	0x97: 'Š', // Scaron
+	0x98: 'Ÿ', // Ydieresis
+	0x99: 'Ž', // Zcaron
+	0x9A: 'ı', // dotlessi
+	0x9B: 'ł', // lslash
+	0x9C: 'œ', // oe
+	0x9D: 'š', // scaron
+	0x9E: 'ž', // zcaron
+	0x9F: utf8.RuneError,
+	0xA0: '€', // Euro
+	0xAD: utf8.RuneError,
+}
+
+// pdfDocEncodingCodes is the inverse of pdfDocEncoding.
+var pdfDocEncodingCodes = func() map[rune]byte {
+	m := map[rune]byte{}
+	for b, r := range pdfDocEncoding {
+		if r != utf8.RuneError {
+			m[r] = b
+		}
+	}
+	return m
+}()
+
+// decodePDFDocEncoding returns the UTF-8 representation of the PDFDocEncoding encoded bytes b.
+func decodePDFDocEncoding(b []byte) string {
+
+	rr := make([]rune, len(b))
+
+	for i, c := range b {
+		if r, ok := pdfDocEncoding[c]; ok {
+			rr[i] = r
+			continue
+		}
+		rr[i] = rune(c)
+	}
+
+	return string(rr)
+}
+
+// encodePDFDocEncoding returns s PDFDocEncoding encoded
+// or false if s contains a character not available in PDFDocEncoding.
+func encodePDFDocEncoding(s string) (string, bool) {
+
+	b := make([]byte, 0, len(s))
+
+	for _, r := range s {
+
+		if c, ok := pdfDocEncodingCodes[r]; ok {
+			b = append(b, c)
+			continue
+		}
+
+		if _, ok := pdfDocEncoding[byte(r)]; ok || r > 0xFF {
+			return "", false
+		}
+
+		b = append(b, byte(r))
+	}
+
+	return string(b), true
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"bufio"
+	"io"
+	"sync"
+
+	"github.com/jplu/pdfcpu/pkg/filter"
+)
+
+// Buffered readers and writers get recycled in order to reduce GC pressure.
+// Pooling is turned off by filter.SetMaxPooledBufferSize(0).
+var bufioReaderPool, bufioWriterPool sync.Pool
+
+func newBufioReader(r io.Reader) *bufio.Reader {
+
+	if rd, ok := bufioReaderPool.Get().(*bufio.Reader); ok {
+		rd.Reset(r)
+		return rd
+	}
+
+	return bufio.NewReader(r)
+}
+
+func putBufioReader(rd *bufio.Reader) {
+
+	if rd == nil || filter.MaxPooledBufferSize() <= 0 {
+		return
+	}
+
+	rd.Reset(nil)
+	bufioReaderPool.Put(rd)
+}
+
+func newBufioWriter(w io.Writer) *bufio.Writer {
+
+	if bw, ok := bufioWriterPool.Get().(*bufio.Writer); ok {
+		bw.Reset(w)
+		return bw
+	}
+
+	return bufio.NewWriter(w)
+}
+
+// Hand back a flushed writer for reuse.
+func putBufioWriter(bw *bufio.Writer) {
+
+	if bw == nil || filter.MaxPooledBufferSize() <= 0 {
+		return
+	}
+
+	bw.Reset(nil)
+	bufioWriterPool.Put(bw)
+}
@@ -0,0 +1,320 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/jplu/pdfcpu/pkg/filter"
+	"github.com/jplu/pdfcpu/pkg/log"
+	"github.com/jplu/pdfcpu/pkg/types"
+	"github.com/pkg/errors"
+)
+
+// Poster describes how to split pages into tiles.
+// Either Cols and Rows or Dim is in effect.
+type Poster struct {
+	Cols, Rows  int       // The grid of tiles a page gets split into.
+	Dim         types.Dim // The tile size, the grid follows from the page size.
+	Orientation bool      // Swap width and height of Dim if this results in fewer tiles.
+	Overlap     float64   // The content repeated along adjacent tile edges.
+}
+
+func parsePosterError() error {
+	return errors.New("Invalid poster configuration string. Please consult pdfcpu help poster.")
+}
+
+func parsePosterGrid(v string, p *Poster) error {
+
+	ss := strings.Fields(v)
+	if len(ss) != 2 {
+		return errors.Errorf("illegal grid: cols rows, %s\n", v)
+	}
+
+	cols, err := strconv.Atoi(ss[0])
+	if err != nil || cols <= 0 {
+		return errors.Errorf("cols must be a positive integer: %s\n", ss[0])
+	}
+
+	rows, err := strconv.Atoi(ss[1])
+	if err != nil || rows <= 0 {
+		return errors.Errorf("rows must be a positive integer: %s\n", ss[1])
+	}
+
+	p.Cols, p.Rows = cols, rows
+
+	return nil
+}
+
+// ParsePosterDetails parses a poster command string into an internal structure.
+func ParsePosterDetails(s string) (*Poster, error) {
+
+	p := Poster{}
+
+	var n int
+
+	for _, s := range strings.Split(s, ",") {
+
+		ss := strings.Split(s, ":")
+		if len(ss) != 2 {
+			return nil, parsePosterError()
+		}
+
+		k := strings.TrimSpace(ss[0])
+		v := strings.TrimSpace(ss[1])
+
+		var err error
+
+		switch k {
+		case "grid":
+			err = parsePosterGrid(v, &p)
+			n++
+
+		case "paper":
+			r := Resize{}
+			err = parseResizePaperSize(v, &r)
+			p.Dim, p.Orientation = r.Dim, r.Orientation
+			n++
+
+		case "dim":
+			r := Resize{}
+			err = parseResizeDimensions(v, &r)
+			p.Dim = r.Dim
+			n++
+
+		case "overlap":
+			p.Overlap, err = strconv.ParseFloat(v, 64)
+			if err != nil {
+				err = errors.Errorf("overlap must be a float value: %s\n", v)
+			}
+
+		default:
+			err = parsePosterError()
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if n != 1 {
+		return nil, errors.New("Please specify one of grid, paper or dim")
+	}
+
+	return &p, p.validate()
+}
+
+func (p Poster) validate() error {
+
+	if p.Overlap < 0 {
+		return errors.Errorf("invalid overlap %.2f", p.Overlap)
+	}
+
+	if p.Cols > 0 && p.Rows > 0 {
+		return nil
+	}
+
+	if p.Dim.W <= 0 || p.Dim.H <= 0 {
+		return errors.Errorf("invalid tile size: %.2f x %.2f", p.Dim.W, p.Dim.H)
+	}
+
+	if p.Overlap >= p.Dim.W || p.Overlap >= p.Dim.H {
+		return errors.Errorf("invalid overlap %.2f for tile size %.2f x %.2f", p.Overlap, p.Dim.W, p.Dim.H)
+	}
+
+	return nil
+}
+
+// tileCount returns the number of tiles of size t overlapping by o needed to cover the length l.
+func tileCount(l, t, o float64) int {
+	n := int(math.Ceil((l - o) / (t - o)))
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// grid returns the grid and tile size for splitting a page displayed as w x h.
+func (p Poster) grid(w, h float64) (int, int, types.Dim, error) {
+
+	o := p.Overlap
+
+	if p.Cols > 0 {
+		if w <= o || h <= o {
+			return 0, 0, types.Dim{}, errors.Errorf("invalid overlap %.2f for page size %.2f x %.2f", o, w, h)
+		}
+		d := types.Dim{
+			W: (w + float64(p.Cols-1)*o) / float64(p.Cols),
+			H: (h + float64(p.Rows-1)*o) / float64(p.Rows)}
+		return p.Cols, p.Rows, d, nil
+	}
+
+	d := p.Dim
+	cols, rows := tileCount(w, d.W, o), tileCount(h, d.H, o)
+
+	if p.Orientation {
+		cols1, rows1 := tileCount(w, d.H, o), tileCount(h, d.W, o)
+		if cols1*rows1 < cols*rows {
+			return cols1, rows1, types.Dim{W: d.H, H: d.W}, nil
+		}
+	}
+
+	return cols, rows, d, nil
+}
+
+// posterTile returns a new page rendering the region of size d at x, y of the page displayed by bp.
+func posterTile(xRefTable *XRefTable, bp *bookletPage, x, y float64, d types.Dim) (*IndirectRef, error) {
+
+	dw, dh := bp.displaySize()
+
+	sd := &StreamDict{
+		Dict:           NewDict(),
+		Content:        []byte(placeForm("Fm0", bp.placement(-x, -y, dw, dh))),
+		FilterPipeline: []PDFFilter{{Name: filter.Flate, DecodeParms: nil}}}
+
+	sd.InsertName("Filter", filter.Flate)
+
+	if err := encodeStream(sd); err != nil {
+		return nil, err
+	}
+
+	contents, err := xRefTable.IndRefForNewObject(*sd)
+	if err != nil {
+		return nil, err
+	}
+
+	pageDict := Dict(
+		map[string]Object{
+			"Type":      Name("Page"),
+			"MediaBox":  NewRectangle(0, 0, d.W, d.H),
+			"Resources": Dict(map[string]Object{"XObject": Dict(map[string]Object{"Fm0": *bp.form})}),
+			"Contents":  *contents,
+		},
+	)
+
+	return xRefTable.IndRefForNewObject(pageDict)
+}
+
+// posterTiles returns the tiles for page pageNr ordered by rows from top to bottom and left to right.
+func posterTiles(xRefTable *XRefTable, pageNr int, p *Poster) (Array, error) {
+
+	bp, err := pageForm(xRefTable, pageNr)
+	if err != nil {
+		return nil, err
+	}
+
+	dw, dh := bp.displaySize()
+	if dw <= 0 || dh <= 0 {
+		return nil, errors.Errorf("Poster: page %d: empty page", pageNr)
+	}
+
+	cols, rows, d, err := p.grid(dw, dh)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Poster: page %d", pageNr)
+	}
+
+	// Center the page within the area covered by all tiles.
+	o := p.Overlap
+	dx := (float64(cols)*(d.W-o) + o - dw) / 2
+	dy := (float64(rows)*(d.H-o) + o - dh) / 2
+
+	log.Debug.Printf("Poster: page %d: %d x %d tiles of %.2f x %.2f\n", pageNr, cols, rows, d.W, d.H)
+
+	var a Array
+
+	for r := 0; r < rows; r++ {
+		for c := 0; c < cols; c++ {
+			x := float64(c)*(d.W-o) - dx
+			y := dh + dy - d.H - float64(r)*(d.H-o)
+			ir, err := posterTile(xRefTable, bp, x, y, d)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, *ir)
+		}
+	}
+
+	return a, nil
+}
+
+// Poster splits each page of selectedPages into a grid of tiles at the original scale,
+// so large format pages can be printed on standard paper and assembled.
+// Tiles replace their page, adjacent tiles repeat the content along their common edge by p.Overlap.
+// The page content gets rendered as displayed taking the page rotation into account, annotations get dropped.
+func (xRefTable *XRefTable) Poster(selectedPages IntSet, p *Poster) error {
+
+	log.Debug.Printf("Poster begin: %v\n", selectedPages)
+
+	if err := p.validate(); err != nil {
+		return err
+	}
+
+	root, err := xRefTable.Pages()
+	if err != nil {
+		return err
+	}
+	if root == nil {
+		return errors.New("Poster: missing page tree root")
+	}
+
+	rootDict, err := xRefTable.DereferenceDict(*root)
+	if err != nil {
+		return err
+	}
+	if rootDict == nil {
+		return errors.New("Poster: missing page tree root")
+	}
+
+	tiles := map[int]Array{}
+
+	for i := 1; i <= xRefTable.PageCount; i++ {
+		if !selectedPages[i] {
+			continue
+		}
+		if tiles[i], err = posterTiles(xRefTable, i, p); err != nil {
+			return err
+		}
+	}
+
+	// Make pages self-contained, they all become kids of the root.
+	var leaves []IndirectRef
+	var nodes []int
+
+	if err = xRefTable.collectPageLeaves(*root, Dict{}, IntSet{}, &leaves, &nodes); err != nil {
+		return err
+	}
+
+	var kids Array
+
+	for i, ir := range leaves {
+		if a, ok := tiles[i+1]; ok {
+			kids = append(kids, a...)
+			continue
+		}
+		kids = append(kids, ir)
+	}
+
+	if err = xRefTable.replacePageTreeKids(*root, rootDict, kids, leaves, nodes); err != nil {
+		return err
+	}
+
+	log.Debug.Printf("Poster end: %d pages\n", len(kids))
+
+	return nil
+}
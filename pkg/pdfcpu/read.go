@@ -20,7 +20,6 @@ import (
 	"bufio"
 	"bytes"
 	"io"
-	"os"
 	"sort"
 	"strconv"
 	"strings"
@@ -40,25 +39,21 @@ func ReadFile(fileIn string, config *Configuration) (*Context, error) {
 
 	log.Info.Printf("reading %s..\n", fileIn)
 
-	f, err := os.Open(fileIn)
+	rs, size, closeFile, err := openReadSeeker(config.FileSystem(), fileIn)
 	if err != nil {
 		return nil, errors.Wrapf(err, "can't open %q", fileIn)
 	}
 
 	defer func() {
-		f.Close()
+		closeFile()
 	}()
 
-	fileInfo, err := f.Stat()
-	if err != nil {
-		return nil, err
-	}
-
-	return Read(f, fileIn, fileInfo.Size(), config)
+	return Read(rs, fileIn, size, config)
 }
 
 // Read takes a readSeeker and generates a Context,
 // an in-memory representation containing a cross reference table.
+// Offsets beyond 2GB are supported, the content of all streams however gets loaded into memory.
 func Read(rs io.ReadSeeker, fileName string, fileSize int64, config *Configuration) (*Context, error) {
 
 	log.Read.Println("Read: begin")
@@ -147,7 +142,7 @@ func newPositionedReader(rs io.ReadSeeker, offset *int64) (*bufio.Reader, error)
 
 	log.Read.Printf("newPositionedReader: positioned to offset: %d\n", *offset)
 
-	return bufio.NewReader(rs), nil
+	return newBufioReader(rs), nil
 }
 
 // Get the file offset of the last XRefSection.
@@ -194,7 +189,7 @@ func offsetLastXRefSection(ctx *Context) (*int64, error) {
 }
 
 // Read next subsection entry and generate corresponding xref table entry.
-func parseXRefTableEntry(s *bufio.Scanner, xRefTable *XRefTable, objectNumber int) error {
+func parseXRefTableEntry(s *bufio.Scanner, ctx *Context, objectNumber int) error {
 
 	log.Read.Println("parseXRefTableEntry: begin")
 
@@ -203,6 +198,8 @@ func parseXRefTableEntry(s *bufio.Scanner, xRefTable *XRefTable, objectNumber in
 		return err
 	}
 
+	xRefTable := ctx.XRefTable
+
 	if xRefTable.Exists(objectNumber) {
 		log.Read.Printf("parseXRefTableEntry: end - Skip entry %d - already assigned\n", objectNumber)
 		return nil
@@ -260,6 +257,8 @@ func parseXRefTableEntry(s *bufio.Scanner, xRefTable *XRefTable, objectNumber in
 				Offset:     &offset,
 				Generation: &generation}
 
+		// May be a hidden object of a hybrid file living in an object stream.
+		ctx.Read.hiddenObjs[objectNumber] = true
 	}
 
 	log.Read.Printf("parseXRefTableEntry: Insert new xreftable entry for Object %d\n", objectNumber)
@@ -272,7 +271,7 @@ func parseXRefTableEntry(s *bufio.Scanner, xRefTable *XRefTable, objectNumber in
 }
 
 // Process xRef table subsection and create corrresponding xRef table entries.
-func parseXRefTableSubSection(s *bufio.Scanner, xRefTable *XRefTable, fields []string) error {
+func parseXRefTableSubSection(s *bufio.Scanner, ctx *Context, fields []string) error {
 
 	log.Read.Println("parseXRefTableSubSection: begin")
 
@@ -290,7 +289,7 @@ func parseXRefTableSubSection(s *bufio.Scanner, xRefTable *XRefTable, fields []s
 
 	// Process all entries of this subsection into xRefTable entries.
 	for i := 0; i < objCount; i++ {
-		if err = parseXRefTableEntry(s, xRefTable, startObjNumber+i); err != nil {
+		if err = parseXRefTableEntry(s, ctx, startObjNumber+i); err != nil {
 			return err
 		}
 	}
@@ -403,6 +402,13 @@ func extractXRefTableEntriesFromXRefStream(buf []byte, xsd *XRefStreamDict, ctx
 	i2 := xsd.W[1]
 	i3 := xsd.W[2]
 
+	// Every field needs to fit into an int64.
+	for _, w := range xsd.W {
+		if w < 0 || w > 8 {
+			return errors.Errorf("extractXRefTableEntriesFromXRefStream: corrupt field width %d", w)
+		}
+	}
+
 	xrefEntryLen := i1 + i2 + i3
 	log.Read.Printf("extractXRefTableEntriesFromXRefStream: begin xrefEntryLen = %d\n", xrefEntryLen)
 
@@ -487,7 +493,7 @@ func extractXRefTableEntriesFromXRefStream(buf []byte, xsd *XRefStreamDict, ctx
 
 		}
 
-		if ctx.XRefTable.Exists(objectNumber) {
+		if ctx.XRefTable.Exists(objectNumber) && !ctx.Read.hiddenObjs[objectNumber] {
 			log.Read.Printf("extractXRefTableEntriesFromXRefStream: Skip entry %d - already assigned\n", objectNumber)
 		} else {
 			ctx.Table[objectNumber] = &xRefTableEntry
@@ -809,10 +815,14 @@ func parseXRefSection(s *bufio.Scanner, ctx *Context) (*int64, error) {
 
 	fields := strings.Fields(line)
 
+	// The XRefStm of a hybrid file takes precedence over free entries of its own xref section only.
+	ctx.Read.hiddenObjs = IntSet{}
+	defer func() { ctx.Read.hiddenObjs = nil }()
+
 	// Process all sub sections of this xRef section.
 	for !strings.HasPrefix(line, "trailer") && len(fields) == 2 {
 
-		if err = parseXRefTableSubSection(s, ctx.XRefTable, fields); err != nil {
+		if err = parseXRefTableSubSection(s, ctx, fields); err != nil {
 			return nil, err
 		}
 
@@ -890,14 +900,14 @@ func parseXRefSection(s *bufio.Scanner, ctx *Context) (*int64, error) {
 // if present, shall be used instead of the version specified in the Header.
 // Save PDF Version from header to xRefTable.
 // The header version comes as the first line of the file.
-func headerVersion(rs io.ReadSeeker) (*Version, error) {
+func headerVersion(rs io.ReadSeeker, offset int64) (*Version, error) {
 
 	log.Read.Println("headerVersion begin")
 
 	// Get first line of file which holds the version of this PDFFile.
 	// We call this the header version.
 
-	_, err := rs.Seek(0, io.SeekStart)
+	_, err := rs.Seek(offset, io.SeekStart)
 	if err != nil {
 		return nil, err
 	}
@@ -915,7 +925,7 @@ func headerVersion(rs io.ReadSeeker) (*Version, error) {
 	s := strings.TrimSpace(string(buf))
 
 	if len(s) < 8 || !strings.HasPrefix(s, prefix) {
-		return nil, errors.New("headerVersion: corrupt pfd file - no header version available")
+		return nil, NewError(ErrNotAPDF, "headerVersion: corrupt pfd file - no header version available")
 	}
 
 	pdfVersion, err := PDFVersion(s[len(prefix) : len(prefix)+3])
@@ -935,7 +945,7 @@ func buildXRefTableStartingAt(ctx *Context, offset *int64) error {
 
 	rs := ctx.Read.rs
 
-	hv, err := headerVersion(rs)
+	hv, err := headerVersion(rs, ctx.Read.headerOffset)
 	if err != nil {
 		return err
 	}
@@ -996,6 +1006,11 @@ func readXRefTable(ctx *Context) (err error) {
 
 	log.Read.Println("readXRefTable: begin")
 
+	err = handleLeadingJunk(ctx)
+	if err != nil {
+		return
+	}
+
 	offset, err := offsetLastXRefSection(ctx)
 	if err != nil {
 		return
@@ -1023,17 +1038,56 @@ func readXRefTable(ctx *Context) (err error) {
 	return
 }
 
+// Probe checks the integrity of header, startxref, %%EOF marker, xref sections and trailers of rs
+// without loading any objects except for xref streams.
+func Probe(rs io.ReadSeeker, config *Configuration) error {
+
+	fileSize, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	if fileSize == 0 {
+		return errors.New("Probe: empty file")
+	}
+
+	ctx, err := NewContext(rs, "", fileSize, config)
+	if err != nil {
+		return err
+	}
+
+	err = readXRefTable(ctx)
+	if err != nil {
+		return errors.Wrap(err, "Probe")
+	}
+
+	if ctx.Root == nil {
+		return errors.New("Probe: trailer: missing \"Root\"")
+	}
+
+	if ctx.Size == nil {
+		return errors.New("Probe: trailer: missing \"Size\"")
+	}
+
+	if _, found := ctx.Find(ctx.Root.ObjectNumber.Value()); !found {
+		return errors.Errorf("Probe: missing xref table entry for root obj #%d", ctx.Root.ObjectNumber.Value())
+	}
+
+	return nil
+}
+
 func growBufBy(buf []byte, size int, rd io.Reader) ([]byte, error) {
 
 	b := make([]byte, size)
 
-	_, err := rd.Read(b)
-	if err != nil {
+	// A single Read may return less than requested, the last chunk of a file is short.
+	n, err := io.ReadFull(rd, b)
+	if err != nil && (err != io.ErrUnexpectedEOF || n == 0) {
 		return nil, err
 	}
 	//log.Read.Printf("growBufBy: Read %d bytes\n", n)
 
-	return append(buf, b...), nil
+	return append(buf, b[:n]...), nil
 }
 
 func nextStreamOffset(line string, streamInd int) (off int) {
@@ -1320,7 +1374,7 @@ func streamDictForObject(ctx *Context, d Dict, objNr, streamInd int, streamOffse
 
 func dict(ctx *Context, d1 Dict, objNr, genNr, endInd, streamInd int) (d2 Dict, err error) {
 
-	if ctx.EncKey != nil {
+	if ctx.stringsEncrypted() {
 		_, err := decryptDeepObject(d1, objNr, genNr, ctx.EncKey, ctx.AES4Strings)
 		if err != nil {
 			return nil, err
@@ -1337,8 +1391,7 @@ func dict(ctx *Context, d1 Dict, objNr, genNr, endInd, streamInd int) (d2 Dict,
 
 func object(ctx *Context, offset int64, objNr, genNr int) (o Object, endInd, streamInd int, streamOffset int64, err error) {
 
-	var rd io.Reader
-	rd, err = newPositionedReader(ctx.Read.rs, &offset)
+	rd, err := newPositionedReader(ctx.Read.rs, &offset)
 	if err != nil {
 		return nil, 0, 0, 0, err
 	}
@@ -1350,6 +1403,7 @@ func object(ctx *Context, offset int64, objNr, genNr int) (o Object, endInd, str
 	//                                  -1 if absent                    -1 if absent
 	var buf []byte
 	buf, endInd, streamInd, streamOffset, err = buffer(rd)
+	putBufioReader(rd)
 	if err != nil {
 		return nil, 0, 0, 0, err
 	}
@@ -1425,7 +1479,7 @@ func ParseObject(ctx *Context, offset int64, objNr, genNr int) (Object, error) {
 		return streamDictForObject(ctx, o, objNr, streamInd, streamOffset, offset)
 
 	case Array:
-		if ctx.EncKey != nil {
+		if ctx.stringsEncrypted() {
 			if _, err = decryptDeepObject(o, objNr, genNr, ctx.EncKey, ctx.AES4Strings); err != nil {
 				return nil, err
 			}
@@ -1433,7 +1487,7 @@ func ParseObject(ctx *Context, offset int64, objNr, genNr int) (Object, error) {
 		return o, nil
 
 	case StringLiteral:
-		if ctx.EncKey != nil {
+		if ctx.stringsEncrypted() {
 			s1, err := decryptString(ctx.AES4Strings, o.Value(), objNr, genNr, ctx.EncKey)
 			if err != nil {
 				return nil, err
@@ -1443,19 +1497,18 @@ func ParseObject(ctx *Context, offset int64, objNr, genNr int) (Object, error) {
 		return o, nil
 
 	case HexLiteral:
-		if ctx.EncKey != nil {
-			s1, err := decryptString(ctx.AES4Strings, o.Value(), objNr, genNr, ctx.EncKey)
+		if ctx.stringsEncrypted() {
+			hl, err := decryptHexLiteral(ctx.AES4Strings, o, objNr, genNr, ctx.EncKey)
 			if err != nil {
 				return nil, err
 			}
-			return HexLiteral(*s1), nil
+			return *hl, nil
 		}
+		return o, nil
 
 	default:
 		return o, nil
 	}
-
-	return nil, nil
 }
 
 func dereferencedObject(ctx *Context, objectNumber int) (Object, error) {
@@ -1546,13 +1599,9 @@ func readContentStream(rd io.Reader, streamLength int) ([]byte, error) {
 
 	buf := make([]byte, streamLength)
 
-	for totalCount := 0; totalCount < streamLength; {
-		count, err := rd.Read(buf[totalCount:])
-		if err != nil {
-			return nil, err
-		}
-		log.Read.Printf("readContentStream: count=%d, buflen=%d(%X)\n", count, len(buf), len(buf))
-		totalCount += count
+	_, err := io.ReadFull(rd, buf)
+	if err != nil {
+		return nil, err
 	}
 
 	log.Read.Printf("readContentStream: end\n")
@@ -1560,6 +1609,20 @@ func readContentStream(rd io.Reader, streamLength int) ([]byte, error) {
 	return buf, nil
 }
 
+// checkStreamLength ensures a stream of length l at offset fits into the input file and into memory.
+func checkStreamLength(ctx *Context, offset, l int64) error {
+
+	if l < 0 || int64(int(l)) != l {
+		return errors.Errorf("invalid stream length %d", l)
+	}
+
+	if fileSize := ctx.Read.FileSize; fileSize > 0 && offset+l > fileSize {
+		return errors.Errorf("stream length %d at offset %d exceeds file size %d", l, offset, fileSize)
+	}
+
+	return nil
+}
+
 // LoadEncodedStreamContent loads the encoded stream content from file into StreamDict.
 func loadEncodedStreamContent(ctx *Context, sd *StreamDict) ([]byte, error) {
 
@@ -1588,6 +1651,11 @@ func loadEncodedStreamContent(ctx *Context, sd *StreamDict) ([]byte, error) {
 		log.Read.Printf("LoadEncodedStreamContent: new indirect streamLength:%d\n", *sd.StreamLength)
 	}
 
+	err = checkStreamLength(ctx, sd.StreamOffset, *sd.StreamLength)
+	if err != nil {
+		return nil, errors.Wrap(err, "LoadEncodedStreamContent")
+	}
+
 	newOffset := sd.StreamOffset
 	rd, err := newPositionedReader(ctx.Read.rs, &newOffset)
 	if err != nil {
@@ -1599,6 +1667,7 @@ func loadEncodedStreamContent(ctx *Context, sd *StreamDict) ([]byte, error) {
 	// Buffer stream contents.
 	// Read content from disk.
 	rawContent, err := readContentStream(rd, int(*sd.StreamLength))
+	putBufioReader(rd)
 	if err != nil {
 		return nil, err
 	}
@@ -1635,7 +1704,7 @@ func saveDecodedStreamContent(ctx *Context, sd *StreamDict, objNr, genNr int, de
 
 	// ctx gets created after XRefStream parsing.
 	// XRefStreams are not encrypted.
-	if ctx != nil && ctx.EncKey != nil {
+	if ctx != nil && ctx.streamEncrypted(*sd) {
 		sd.Raw, err = decryptStream(ctx.AES4Streams, sd.Raw, objNr, genNr, ctx.EncKey)
 		if err != nil {
 			return err
@@ -1732,86 +1801,95 @@ func logStream(o Object) {
 
 }
 
-// Decode all object streams so contained objects are ready to be used.
-func decodeObjectStreams(ctx *Context) error {
+// Decode object stream objectNumber so contained objects are ready to be used.
+func decodeObjectStream(ctx *Context, objectNumber int) error {
 
-	// Note:
-	// Entry "Extends" intentionally left out.
-	// No object stream collection validation necessary.
+	// Get XRefTableEntry.
+	entry := ctx.XRefTable.Table[objectNumber]
+	if entry == nil {
+		return errors.Errorf("decodeObjectStream: missing entry for obj#%d\n", objectNumber)
+	}
 
-	log.Read.Println("decodeObjectStreams: begin")
+	log.Read.Printf("decodeObjectStream: parsing object stream for obj#%d\n", objectNumber)
 
-	// Get sorted slice of object numbers.
-	var keys []int
-	for k := range ctx.Read.ObjectStreams {
-		keys = append(keys, k)
+	// Parse object stream from file.
+	o, err := ParseObject(ctx, *entry.Offset, objectNumber, *entry.Generation)
+	if err != nil || o == nil {
+		return errors.New("decodeObjectStream: corrupt object stream")
 	}
-	sort.Ints(keys)
 
-	for _, objectNumber := range keys {
+	// Ensure StreamDict
+	sd, ok := o.(StreamDict)
+	if !ok {
+		return errors.New("decodeObjectStream: corrupt object stream")
+	}
 
-		// Get XRefTableEntry.
-		entry := ctx.XRefTable.Table[objectNumber]
-		if entry == nil {
-			return errors.Errorf("decodeObjectStream: missing entry for obj#%d\n", objectNumber)
-		}
+	// Load encoded stream content to xRefTable.
+	if _, err = loadEncodedStreamContent(ctx, &sd); err != nil {
+		return errors.Wrapf(err, "decodeObjectStream: problem dereferencing object stream %d", objectNumber)
+	}
 
-		log.Read.Printf("decodeObjectStreams: parsing object stream for obj#%d\n", objectNumber)
+	// Save decoded stream content to xRefTable.
+	if err = saveDecodedStreamContent(ctx, &sd, objectNumber, *entry.Generation, true); err != nil {
+		log.Read.Printf("obj %d: %s", objectNumber, err)
+		return err
+	}
 
-		// Parse object stream from file.
-		o, err := ParseObject(ctx, *entry.Offset, objectNumber, *entry.Generation)
-		if err != nil || o == nil {
-			return errors.New("decodeObjectStreams: corrupt object stream")
-		}
+	// Ensure decoded objectArray for object stream dicts.
+	if !sd.IsObjStm() {
+		return errors.New("decodeObjectStream: corrupt object stream")
+	}
 
-		// Ensure StreamDict
-		sd, ok := o.(StreamDict)
-		if !ok {
-			return errors.New("decodeObjectStreams: corrupt object stream")
-		}
+	// We have an object stream.
+	log.Read.Printf("decodeObjectStream: object stream #%d\n", objectNumber)
 
-		// Load encoded stream content to xRefTable.
-		if _, err = loadEncodedStreamContent(ctx, &sd); err != nil {
-			return errors.Wrapf(err, "decodeObjectStreams: problem dereferencing object stream %d", objectNumber)
-		}
+	ctx.Read.UsingObjectStreams = true
 
-		// Save decoded stream content to xRefTable.
-		if err = saveDecodedStreamContent(ctx, &sd, objectNumber, *entry.Generation, true); err != nil {
-			log.Read.Printf("obj %d: %s", objectNumber, err)
-			return err
-		}
+	// Create new object stream dict.
+	osd, err := objectStreamDict(&sd)
+	if err != nil {
+		return errors.Wrapf(err, "decodeObjectStream: problem dereferencing object stream %d", objectNumber)
+	}
 
-		// Ensure decoded objectArray for object stream dicts.
-		if !sd.IsObjStm() {
-			return errors.New("decodeObjectStreams: corrupt object stream")
-		}
+	log.Read.Printf("decodeObjectStream: decoding object stream %d:\n", objectNumber)
 
-		// We have an object stream.
-		log.Read.Printf("decodeObjectStreams: object stream #%d\n", objectNumber)
+	// Parse all objects of this object stream and save them to ObjectStreamDict.ObjArray.
+	if err = parseObjectStream(osd); err != nil {
+		return errors.Wrapf(err, "decodeObjectStream: problem decoding object stream %d\n", objectNumber)
+	}
 
-		ctx.Read.UsingObjectStreams = true
+	if osd.ObjArray == nil {
+		return errors.Wrap(err, "decodeObjectStream: objArray should be set!")
+	}
 
-		// Create new object stream dict.
-		osd, err := objectStreamDict(&sd)
-		if err != nil {
-			return errors.Wrapf(err, "decodeObjectStreams: problem dereferencing object stream %d", objectNumber)
-		}
+	log.Read.Printf("decodeObjectStream: decoded object stream %d:\n", objectNumber)
 
-		log.Read.Printf("decodeObjectStreams: decoding object stream %d:\n", objectNumber)
+	// Save object stream dict to xRefTableEntry.
+	entry.Object = *osd
 
-		// Parse all objects of this object stream and save them to ObjectStreamDict.ObjArray.
-		if err = parseObjectStream(osd); err != nil {
-			return errors.Wrapf(err, "decodeObjectStreams: problem decoding object stream %d\n", objectNumber)
-		}
+	return nil
+}
 
-		if osd.ObjArray == nil {
-			return errors.Wrap(err, "decodeObjectStreams: objArray should be set!")
-		}
+// Decode all object streams so contained objects are ready to be used.
+func decodeObjectStreams(ctx *Context) error {
+
+	// Note:
+	// Entry "Extends" intentionally left out.
+	// No object stream collection validation necessary.
+
+	log.Read.Println("decodeObjectStreams: begin")
 
-		log.Read.Printf("decodeObjectStreams: decoded object stream %d:\n", objectNumber)
+	// Get sorted slice of object numbers.
+	var keys []int
+	for k := range ctx.Read.ObjectStreams {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
 
-		// Save object stream dict to xRefTableEntry.
-		entry.Object = *osd
+	for _, objectNumber := range keys {
+		if err := decodeObjectStream(ctx, objectNumber); err != nil {
+			return err
+		}
 	}
 
 	log.Read.Println("decodeObjectStreams: end")
@@ -2006,6 +2084,12 @@ func dereferenceObjects(ctx *Context) error {
 		}
 	}
 
+	if h := ctx.Hooks.OnObjectRead; h != nil {
+		if err := applyObjectHook(ctx, h); err != nil {
+			return err
+		}
+	}
+
 	log.Read.Println("dereferenceObjects: end")
 
 	return nil
@@ -2175,7 +2259,7 @@ func setupEncryptionKey(ctx *Context, encryptDictObjNr int) error {
 	// If the owner password does not match we generally move on if the user password is correct
 	// unless we need to insist on a correct owner password.
 	if !ok && needsOwnerAndUserPassword(ctx.Mode) {
-		return errors.New("owner password authentication error")
+		return NewError(ErrInvalidPassword, "owner password authentication error")
 	}
 
 	// Generally the owner password, which is also regarded as the master password or set permissions password
@@ -2190,11 +2274,14 @@ func setupEncryptionKey(ctx *Context, encryptDictObjNr int) error {
 		return err
 	}
 	if !ok {
-		return errors.New("user password authentication error")
+		if len(ctx.UserPW) == 0 && len(ctx.OwnerPW) == 0 {
+			return NewError(ErrEncrypted, "user password authentication error")
+		}
+		return NewError(ErrInvalidPassword, "user password authentication error")
 	}
 
-	if !hasNeededPermissions(ctx.Mode, ctx.E) {
-		return errors.New("Insufficient access permissions")
+	if !ctx.IgnorePermissions && !hasNeededPermissions(ctx.Mode, ctx.E) {
+		return errors.New("Insufficient access permissions, supply the owner password or set IgnorePermissions")
 	}
 
 	return nil
@@ -2214,7 +2301,7 @@ func checkForEncryption(ctx *Context) error {
 
 	if ctx.Mode == ENCRYPT {
 		// We want to encrypt this file.
-		return errors.New("encrypt: This file is already encrypted")
+		return NewError(ErrEncrypted, "encrypt: This file is already encrypted")
 	}
 
 	// We need to decrypt this file in order to read it.
@@ -0,0 +1,267 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"io"
+
+	"github.com/jplu/pdfcpu/pkg/log"
+	"github.com/pkg/errors"
+)
+
+// Dereference the object stream holding a compressed object on demand.
+func ensureObjectStreamDecoded(ctx *Context, entry *XRefTableEntry) error {
+
+	if !entry.Compressed {
+		return nil
+	}
+
+	osEntry, found := ctx.Find(*entry.ObjectStream)
+	if !found {
+		return errors.Errorf("ensureObjectStreamDecoded: missing object stream %d", *entry.ObjectStream)
+	}
+
+	if _, ok := osEntry.Object.(ObjectStreamDict); ok {
+		return nil
+	}
+
+	return decodeObjectStream(ctx, *entry.ObjectStream)
+}
+
+// Load a single object from file unless it is in memory already.
+func loadObject(ctx *Context, objNr int) (Object, error) {
+
+	entry, found := ctx.Find(objNr)
+	if !found || entry.Free {
+		return nil, nil
+	}
+
+	err := ensureObjectStreamDecoded(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	err = dereferenceObject(ctx, objNr)
+	if err != nil {
+		return nil, err
+	}
+
+	return entry.Object, nil
+}
+
+// Drop all xref table entries not recorded in objNrs.
+func pruneXRefTable(xRefTable *XRefTable, objNrs IntSet) {
+
+	for objNr := range xRefTable.Table {
+		if objNr > 0 && !objNrs[objNr] {
+			delete(xRefTable.Table, objNr)
+		}
+	}
+
+	// Reset the free list.
+	zero := int64(0)
+	xRefTable.Table[0].Offset = &zero
+}
+
+// readCatalog reads the xref table of rs and the catalog
+// and returns a loader for any other objects needed.
+func readCatalog(rs io.ReadSeeker, config *Configuration) (*Context, Dict, func(objNr int) (Object, error), error) {
+
+	fileSize, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	ctx, err := NewContext(rs, "", fileSize, config)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	err = readXRefTable(ctx)
+	if err != nil {
+		return nil, nil, nil, errors.Wrap(err, "xRefTable failed")
+	}
+
+	err = checkForEncryption(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if ctx.Root == nil {
+		return nil, nil, nil, errors.New("missing root")
+	}
+
+	// Objects get loaded from file on demand.
+	load := func(objNr int) (Object, error) { return loadObject(ctx, objNr) }
+
+	rootDict, err := dictForIndRef(*ctx.Root, load)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Identify an optional Version entry in the root object/catalog.
+	err = identifyRootVersion(ctx.XRefTable)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return ctx, rootDict, load, nil
+}
+
+// ReadPageCount returns the page count of the page tree root of rs
+// loading nothing but the xref table, the catalog and the page tree root.
+// The file does not get validated.
+func ReadPageCount(rs io.ReadSeeker, config *Configuration) (int, error) {
+
+	_, rootDict, load, err := readCatalog(rs, config)
+	if err != nil {
+		return 0, errors.Wrap(err, "ReadPageCount")
+	}
+
+	pagesIndRef := rootDict.IndirectRefEntry("Pages")
+	if pagesIndRef == nil {
+		return 0, errors.New("ReadPageCount: missing \"Pages\"")
+	}
+
+	d, err := dictForIndRef(*pagesIndRef, load)
+	if err != nil {
+		return 0, err
+	}
+
+	o := d["Count"]
+
+	if ir, ok := o.(IndirectRef); ok {
+		if o, err = load(ir.ObjectNumber.Value()); err != nil {
+			return 0, err
+		}
+	}
+
+	i, ok := o.(Integer)
+	if !ok || i < 0 {
+		return 0, errors.New("ReadPageCount: corrupt \"Count\"")
+	}
+
+	return i.Value(), nil
+}
+
+// ReadVersion returns the PDF version of rs taking into account a Version entry of the catalog
+// loading nothing but the xref table and the catalog.
+// The file does not get validated.
+func ReadVersion(rs io.ReadSeeker, config *Configuration) (Version, error) {
+
+	ctx, _, _, err := readCatalog(rs, config)
+	if err != nil {
+		return 0, errors.Wrap(err, "ReadVersion")
+	}
+
+	return ctx.Version(), nil
+}
+
+// ReadPage takes a readSeeker and generates a minimal Context
+// containing nothing but the object closure of page pageNr.
+//
+// The page becomes the single page of a new page tree referenced by a new catalog.
+// Inherited page attributes are copied into the page dict.
+// Like for Split the page's annotations are dropped.
+func ReadPage(rs io.ReadSeeker, pageNr int, config *Configuration) (*Context, error) {
+
+	log.Read.Printf("ReadPage: begin, page %d\n", pageNr)
+
+	if pageNr < 1 {
+		return nil, NewError(ErrPageOutOfRange, "ReadPage: invalid page number %d", pageNr)
+	}
+
+	ctx, rootDict, load, err := readCatalog(rs, config)
+	if err != nil {
+		return nil, errors.Wrap(err, "ReadPage")
+	}
+
+	pagesIndRef := rootDict.IndirectRefEntry("Pages")
+	if pagesIndRef == nil {
+		return nil, errors.New("ReadPage: missing \"Pages\"")
+	}
+
+	attrs := Dict{}
+	p := 0
+
+	pageIndRef, pageDict, err := locatePage(*pagesIndRef, attrs, &p, pageNr, load)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range attrs {
+		if _, found := pageDict.Find(k); !found {
+			pageDict.Insert(k, v)
+		}
+	}
+
+	pageDict.Delete("Annots")
+
+	// Article beads link to other pages.
+	pageDict.Delete("B")
+
+	objNrs := IntSet{}
+
+	if err = pageObjectClosure(*pageIndRef, objNrs, load); err != nil {
+		return nil, err
+	}
+
+	for _, ir := range []*IndirectRef{ctx.Encrypt, ctx.Info} {
+		if ir == nil {
+			continue
+		}
+		err = objectClosure(*ir, objNrs, load)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	pruneXRefTable(ctx.XRefTable, objNrs)
+
+	pagesIndRef, err = ctx.IndRefForNewObject(
+		Dict(
+			map[string]Object{
+				"Type":  Name("Pages"),
+				"Count": Integer(1),
+				"Kids":  Array{*pageIndRef},
+			},
+		),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	pageDict.Update("Parent", *pagesIndRef)
+
+	rootDict = Dict(
+		map[string]Object{
+			"Type":  Name("Catalog"),
+			"Pages": *pagesIndRef,
+		},
+	)
+
+	ctx.Root, err = ctx.IndRefForNewObject(rootDict)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx.RootDict = rootDict
+
+	log.Read.Println("ReadPage: end")
+
+	return ctx, nil
+}
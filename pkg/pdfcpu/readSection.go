@@ -0,0 +1,162 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+
+	"github.com/jplu/pdfcpu/pkg/log"
+	"github.com/pkg/errors"
+)
+
+// The header may be preceded by junk like a byte order mark, see 7.5.2 File Header (Implementation note).
+const maxHeaderOffset = 1024
+
+// "xref" or an object header like "12 0 obj" at the start of a xref section.
+var xRefSectionRegexp = regexp.MustCompile(`^\s*(xref|\d+\s+\d+\s+obj)`)
+
+// sectionReadSeeker exposes size bytes of rs starting at off as a ReadSeeker of its own.
+type sectionReadSeeker struct {
+	rs   io.ReadSeeker
+	off  int64 // Start of the section within rs.
+	size int64 // Size of the section.
+	pos  int64 // Current position within the section.
+}
+
+// NewSectionReadSeeker returns a ReadSeeker exposing size bytes of rs starting at offset,
+// eg. for reading a PDF file embedded in another file in place.
+func NewSectionReadSeeker(rs io.ReadSeeker, offset, size int64) io.ReadSeeker {
+	return &sectionReadSeeker{rs: rs, off: offset, size: size}
+}
+
+func (s *sectionReadSeeker) Read(p []byte) (int, error) {
+
+	if s.pos >= s.size {
+		return 0, io.EOF
+	}
+
+	if max := s.size - s.pos; int64(len(p)) > max {
+		p = p[:max]
+	}
+
+	n, err := s.rs.Read(p)
+	s.pos += int64(n)
+
+	return n, err
+}
+
+func (s *sectionReadSeeker) Seek(offset int64, whence int) (int64, error) {
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.pos
+	case io.SeekEnd:
+		offset += s.size
+	default:
+		return 0, errors.New("sectionReadSeeker: invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("sectionReadSeeker: negative position")
+	}
+
+	if _, err := s.rs.Seek(s.off+offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	s.pos = offset
+
+	return offset, nil
+}
+
+// headerOffset returns the offset of the header within the first 1024 bytes of rs.
+func headerOffset(rs io.ReadSeeker) (int64, error) {
+
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, maxHeaderOffset+len("%PDF-"))
+
+	n, err := io.ReadFull(rs, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return 0, err
+	}
+
+	i := bytes.Index(buf[:n], []byte("%PDF-"))
+	if i < 0 {
+		return 0, NewError(ErrNotAPDF, "headerOffset: corrupt pdf file - no header version available")
+	}
+
+	return int64(i), nil
+}
+
+// xRefSectionAt returns true if there is a xref section or a xref stream at offset.
+func xRefSectionAt(rs io.ReadSeeker, offset int64) bool {
+
+	if _, err := rs.Seek(offset, io.SeekStart); err != nil {
+		return false
+	}
+
+	buf := make([]byte, 32)
+
+	n, _ := io.ReadFull(rs, buf)
+
+	return xRefSectionRegexp.Match(buf[:n])
+}
+
+// handleLeadingJunk deals with files carrying junk like a byte order mark in front of the header (relaxed mode only).
+// Offsets of such files are either relative to the beginning of the file or to the header.
+// The latter get read starting at the header.
+func handleLeadingJunk(ctx *Context) error {
+
+	rs := ctx.Read.rs
+
+	off, err := headerOffset(rs)
+	if err != nil {
+		return err
+	}
+
+	if off == 0 || ctx.XRefTable.ValidationMode != ValidationRelaxed {
+		return nil
+	}
+
+	log.Info.Printf("skipping %d bytes in front of header\n", off)
+
+	ctx.Read.headerOffset = off
+
+	offset, err := offsetLastXRefSection(ctx)
+	if err != nil {
+		return err
+	}
+
+	if xRefSectionAt(rs, *offset) || !xRefSectionAt(rs, *offset+off) {
+		// Offsets are relative to the beginning of the file.
+		return nil
+	}
+
+	log.Info.Println("offsets are relative to the header")
+
+	ctx.Read.rs = &sectionReadSeeker{rs: rs, off: off, size: ctx.Read.FileSize - off}
+	ctx.Read.FileSize -= off
+	ctx.Read.headerOffset = 0
+
+	return nil
+}
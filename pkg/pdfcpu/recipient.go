@@ -0,0 +1,259 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"github.com/jplu/pdfcpu/pkg/log"
+	"github.com/pkg/errors"
+)
+
+// StampField places the value of a recipient record at a fixed position of a page.
+type StampField struct {
+	Key      string  // Recipient record key supplying the text, eg. "name", "email" or "serial".
+	X, Y     float64 // Start of the baseline in default user space units.
+	FontName string  // One of the Adobe base fonts Helvetica, Times-Roman, Courier. Defaults to Helvetica.
+	FontSize int     // Defaults to 10 points.
+}
+
+// Recipient describes a single personalized output.
+type Recipient struct {
+	FileName string            // Output file name.
+	Values   map[string]string // Stamp field key => text.
+}
+
+func (sf StampField) fontName() string {
+	if sf.FontName == "" {
+		return "Helvetica"
+	}
+	return sf.FontName
+}
+
+func (sf StampField) fontSize() int {
+	if sf.FontSize <= 0 {
+		return 10
+	}
+	return sf.FontSize
+}
+
+// validateStampFields checks fields before any output gets produced.
+func validateStampFields(fields []StampField) error {
+
+	for _, sf := range fields {
+
+		if sf.Key == "" {
+			return errors.New("stamp field: missing key")
+		}
+
+		if !supportedWatermarkFont(sf.fontName()) {
+			return errors.Errorf("stamp field %s: %s is unsupported, try one of Helvetica, Times-Roman, Courier", sf.Key, sf.FontName)
+		}
+	}
+
+	return nil
+}
+
+// recipientContent renders fields for r using font resource ids from fontIDs.
+func recipientContent(fields []StampField, r Recipient, fontIDs map[string]string) ([]byte, error) {
+
+	var b bytes.Buffer
+
+	b.WriteString("Q q BT 0 g ")
+
+	for _, sf := range fields {
+
+		v, ok := r.Values[sf.Key]
+		if !ok || v == "" {
+			continue
+		}
+
+		// The base fonts get registered using WinAnsiEncoding.
+		s, err := Escape(winAnsi(v))
+		if err != nil {
+			return nil, err
+		}
+
+		fmt.Fprintf(&b, "/%s %d Tf 1 0 0 1 %.2f %.2f Tm (%s) Tj ", fontIDs[sf.fontName()], sf.fontSize(), sf.X, sf.Y, *s)
+	}
+
+	b.WriteString("ET Q")
+
+	return b.Bytes(), nil
+}
+
+// pageFontDict returns the font resource dict of pageDict and creates any missing parts.
+func pageFontDict(xRefTable *XRefTable, pageDict Dict, inhPAttrs *InheritedPageAttrs) (Dict, error) {
+
+	resDict := inhPAttrs.resources
+	if resDict == nil {
+		resDict = NewDict()
+		pageDict.Insert("Resources", resDict)
+	}
+
+	d, err := xRefTable.DereferenceDict(resDict["Font"])
+	if err != nil {
+		return nil, err
+	}
+
+	if d == nil {
+		d = NewDict()
+		resDict.Update("Font", d)
+	}
+
+	return d, nil
+}
+
+// stampRecipientPage appends the content for r to page i.
+func stampRecipientPage(xRefTable *XRefTable, i int, fields []StampField, r Recipient, fonts map[string]IndirectRef) error {
+
+	pageDict, inhPAttrs, err := xRefTable.PageDict(i)
+	if err != nil {
+		return err
+	}
+
+	if pageDict == nil {
+		return errors.Errorf("stamp recipient: missing page %d", i)
+	}
+
+	fontDict, err := pageFontDict(xRefTable, pageDict, inhPAttrs)
+	if err != nil {
+		return err
+	}
+
+	// Register each font under an id not used by the page yet.
+	// Pages may share their resources, so reuse any id already registered.
+	fontIDs := map[string]string{}
+	for fontName, ir := range fonts {
+		for j := 0; ; j++ {
+			id := "FRcp" + strconv.Itoa(j)
+			o, found := fontDict.Find(id)
+			if !found {
+				fontDict.Insert(id, ir)
+			} else if o != ir {
+				continue
+			}
+			fontIDs[fontName] = id
+			break
+		}
+	}
+
+	b, err := recipientContent(fields, r, fontIDs)
+	if err != nil {
+		return err
+	}
+
+	o, err := xRefTable.Dereference(pageDict["Contents"])
+	if err != nil {
+		return err
+	}
+
+	var contents Array
+
+	switch o := o.(type) {
+
+	case StreamDict:
+		// Content arrays hold indirect references only.
+		ir, ok := pageDict["Contents"].(IndirectRef)
+		if !ok {
+			pir, err := xRefTable.IndRefForNewObject(o)
+			if err != nil {
+				return err
+			}
+			ir = *pir
+		}
+		contents = Array{ir}
+
+	case Array:
+		contents = o
+	}
+
+	// Wrap the page content into q/Q so any leftover graphics state does not affect the stamp.
+	var irs []*IndirectRef
+	for _, c := range [][]byte{[]byte("q"), b} {
+
+		sd := &StreamDict{Dict: NewDict(), Content: c}
+
+		err = encodeStream(sd)
+		if err != nil {
+			return err
+		}
+
+		ir, err := xRefTable.IndRefForNewObject(*sd)
+		if err != nil {
+			return err
+		}
+
+		irs = append(irs, ir)
+	}
+
+	a := Array{*irs[0]}
+	a = append(a, contents...)
+	a = append(a, *irs[1])
+
+	pageDict.Update("Contents", a)
+
+	return nil
+}
+
+// StampRecipient stamps the values of r at the positions given by fields on all selected pages.
+// Use Context.Clone to produce several personalized outputs from one parsed document.
+func StampRecipient(ctx *Context, selectedPages IntSet, fields []StampField, r Recipient) error {
+
+	log.Debug.Printf("StampRecipient: %s\n", r.FileName)
+
+	err := validateStampFields(fields)
+	if err != nil {
+		return err
+	}
+
+	xRefTable := ctx.XRefTable
+
+	fonts := map[string]IndirectRef{}
+
+	for _, sf := range fields {
+
+		fontName := sf.fontName()
+		if _, ok := fonts[fontName]; ok {
+			continue
+		}
+
+		d := NewDict()
+		d.InsertName("Type", "Font")
+		d.InsertName("Subtype", "Type1")
+		d.InsertName("BaseFont", fontName)
+		d.InsertName("Encoding", "WinAnsiEncoding")
+
+		ir, err := xRefTable.IndRefForNewObject(d)
+		if err != nil {
+			return err
+		}
+
+		fonts[fontName] = *ir
+	}
+
+	for _, i := range SortedSelectedPages(selectedPages) {
+		err = stampRecipientPage(xRefTable, i, fields, r, fonts)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,966 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+
+	"github.com/jplu/pdfcpu/pkg/filter"
+	"github.com/jplu/pdfcpu/pkg/types"
+	"github.com/jplu/pdfcpu/tiff"
+	"github.com/pkg/errors"
+)
+
+// The renderer rasterizes the subset of the imaging model needed for previews and thumbnails:
+// Paths get filled, stroked and used for clipping, image XObjects and Form XObjects get painted.
+// Colors are taken from DeviceGray, DeviceRGB and DeviceCMYK or approximated by the number of components.
+// Text is greeked, ie. each glyph is painted as a bar, since font programs are not interpreted.
+// Shadings, patterns, dashes, blend modes, inline images and annotations are not rendered.
+
+// maxRenderPixels limits the size of a rendered page.
+const maxRenderPixels = 100000000
+
+// renderSubSamples is the number of sample rows per pixel row used for anti-aliasing.
+const renderSubSamples = 4
+
+type point struct {
+	x, y float64
+}
+
+// renderState is the graphics state relevant to rendering.
+type renderState struct {
+	text                   textGraphicsState
+	fill, stroke           color.NRGBA
+	fillCS, strokeCS       string // The color space family: gray, rgb, cmyk or other.
+	fillAlpha, strokeAlpha float64
+	lineWidth              float64
+	clip                   *image.Alpha // nil means unclipped.
+}
+
+type renderer struct {
+	*textExtractor
+	dst    *image.RGBA
+	dev    matrix // Maps default user space to device space.
+	gs     *renderState
+	images map[int]image.Image // Decoded image XObjects by object number.
+}
+
+// edge is a non horizontal polygon edge with y0 < y1.
+type edge struct {
+	x0, y0, x1, y1 float64
+	dir            int
+}
+
+func polygonEdges(pp [][]point) ([]edge, image.Rectangle) {
+
+	var ee []edge
+	x0, y0, x1, y1 := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+
+	for _, p := range pp {
+		for i := range p {
+			a, b := p[i], p[(i+1)%len(p)]
+			x0, y0 = math.Min(x0, a.x), math.Min(y0, a.y)
+			x1, y1 = math.Max(x1, a.x), math.Max(y1, a.y)
+			if a.y == b.y {
+				continue
+			}
+			if a.y < b.y {
+				ee = append(ee, edge{a.x, a.y, b.x, b.y, 1})
+			} else {
+				ee = append(ee, edge{b.x, b.y, a.x, a.y, -1})
+			}
+		}
+	}
+
+	if len(ee) == 0 {
+		return nil, image.Rectangle{}
+	}
+
+	return ee, image.Rect(int(math.Floor(x0)), int(math.Floor(y0)), int(math.Ceil(x1)), int(math.Ceil(y1)))
+}
+
+// rasterize returns the anti-aliased coverage of the polygons pp clipped to r.
+func rasterize(pp [][]point, evenOdd bool, r image.Rectangle) *image.Alpha {
+
+	ee, bb := polygonEdges(pp)
+
+	bb = bb.Intersect(r)
+	if bb.Empty() {
+		return nil
+	}
+
+	sort.Slice(ee, func(i, j int) bool { return ee[i].y0 < ee[j].y0 })
+
+	type crossing struct {
+		x   float64
+		dir int
+	}
+
+	mask := image.NewAlpha(bb)
+	acc := make([]float64, bb.Dx())
+	var active []edge
+	var xx []crossing
+	next := 0
+
+	for y := bb.Min.Y; y < bb.Max.Y; y++ {
+
+		for i := range acc {
+			acc[i] = 0
+		}
+
+		for s := 0; s < renderSubSamples; s++ {
+
+			sy := float64(y) + (float64(s)+0.5)/renderSubSamples
+
+			for next < len(ee) && ee[next].y0 <= sy {
+				active = append(active, ee[next])
+				next++
+			}
+
+			xx = xx[:0]
+			j := 0
+			for _, e := range active {
+				if e.y1 <= sy {
+					continue
+				}
+				active[j] = e
+				j++
+				if sy >= e.y0 {
+					xx = append(xx, crossing{e.x0 + (sy-e.y0)*(e.x1-e.x0)/(e.y1-e.y0), e.dir})
+				}
+			}
+			active = active[:j]
+
+			sort.Slice(xx, func(i, j int) bool { return xx[i].x < xx[j].x })
+
+			w := 0
+			for i := 0; i < len(xx)-1; i++ {
+				if evenOdd {
+					w ^= 1
+				} else {
+					w += xx[i].dir
+				}
+				if w == 0 {
+					continue
+				}
+				a := math.Max(xx[i].x, float64(bb.Min.X))
+				b := math.Min(xx[i+1].x, float64(bb.Max.X))
+				for px := int(math.Floor(a)); float64(px) < b; px++ {
+					acc[px-bb.Min.X] += (math.Min(b, float64(px+1)) - math.Max(a, float64(px))) / renderSubSamples
+				}
+			}
+		}
+
+		for i, f := range acc {
+			mask.Pix[(y-bb.Min.Y)*mask.Stride+i] = uint8(math.Min(f, 1)*255 + 0.5)
+		}
+	}
+
+	return mask
+}
+
+// strokePolygons returns the outline of the subpaths pp stroked with half width hw.
+// Segments are extended by hw at both ends which approximates joins and caps.
+func strokePolygons(pp [][]point, closed []bool, hw float64) [][]point {
+
+	var qq [][]point
+
+	for i, p := range pp {
+
+		n := len(p) - 1
+		if closed[i] {
+			n = len(p)
+		}
+
+		for j := 0; j < n; j++ {
+
+			a, b := p[j], p[(j+1)%len(p)]
+
+			l := math.Hypot(b.x-a.x, b.y-a.y)
+			if l == 0 {
+				continue
+			}
+
+			dx, dy := (b.x-a.x)/l*hw, (b.y-a.y)/l*hw
+
+			qq = append(qq, []point{
+				{a.x - dx - dy, a.y - dy + dx},
+				{b.x + dx - dy, b.y + dy + dx},
+				{b.x + dx + dy, b.y + dy - dx},
+				{a.x - dx + dy, a.y - dy - dx},
+			})
+		}
+	}
+
+	return qq
+}
+
+func (r *renderer) paintMask(mask *image.Alpha, c color.NRGBA, alpha float64) {
+
+	if mask == nil {
+		return
+	}
+
+	if clip := r.gs.clip; clip != nil {
+		for y := mask.Rect.Min.Y; y < mask.Rect.Max.Y; y++ {
+			for x := mask.Rect.Min.X; x < mask.Rect.Max.X; x++ {
+				i := mask.PixOffset(x, y)
+				mask.Pix[i] = uint8(int(mask.Pix[i]) * int(clip.AlphaAt(x, y).A) / 255)
+			}
+		}
+	}
+
+	c.A = uint8(math.Max(0, math.Min(1, alpha))*255 + 0.5)
+
+	draw.DrawMask(r.dst, mask.Rect, image.NewUniform(c), image.Point{}, mask, mask.Rect.Min, draw.Over)
+}
+
+// device returns the transformation from user space to device space.
+func (r *renderer) device() matrix {
+	return r.gs.text.ctm.multiply(r.dev)
+}
+
+// deviceScale returns the average scale of m.
+func deviceScale(m matrix) float64 {
+	return math.Sqrt(math.Abs(m[0][0]*m[1][1] - m[0][1]*m[1][0]))
+}
+
+func (r *renderer) fillPath(pp [][]point, evenOdd bool) {
+	r.paintMask(rasterize(pp, evenOdd, r.dst.Rect), r.gs.fill, r.gs.fillAlpha)
+}
+
+func (r *renderer) strokePath(pp [][]point, closed []bool) {
+	hw := math.Max(r.gs.lineWidth*deviceScale(r.device()), 1) / 2
+	r.paintMask(rasterize(strokePolygons(pp, closed, hw), false, r.dst.Rect), r.gs.stroke, r.gs.strokeAlpha)
+}
+
+func (r *renderer) clipPath(pp [][]point, evenOdd bool) {
+
+	clip := image.NewAlpha(r.dst.Rect)
+
+	if mask := rasterize(pp, evenOdd, r.dst.Rect); mask != nil {
+		for y := mask.Rect.Min.Y; y < mask.Rect.Max.Y; y++ {
+			for x := mask.Rect.Min.X; x < mask.Rect.Max.X; x++ {
+				a := int(mask.AlphaAt(x, y).A)
+				if r.gs.clip != nil {
+					a = a * int(r.gs.clip.AlphaAt(x, y).A) / 255
+				}
+				clip.SetAlpha(x, y, color.Alpha{A: uint8(a)})
+			}
+		}
+	}
+
+	r.gs.clip = clip
+}
+
+// showGlyph paints a bar covering the lower half of the em box of a glyph.
+func (r *renderer) showGlyph(gs *textGraphicsState, trm matrix, w0 float64) {
+
+	c, alpha := r.gs.fill, r.gs.fillAlpha
+
+	switch gs.renderMode {
+	case 3, 7:
+		// Invisible text, eg. the text layer of scanned pages.
+		return
+	case 1, 5:
+		c, alpha = r.gs.stroke, r.gs.strokeAlpha
+	}
+
+	m := trm.multiply(r.dev)
+
+	var p []point
+	for _, q := range [][2]float64{{0.05 * w0, 0}, {0.95 * w0, 0}, {0.95 * w0, 0.5}, {0.05 * w0, 0.5}} {
+		x, y := m.transform(q[0], q[1])
+		p = append(p, point{x, y})
+	}
+
+	r.paintMask(rasterize([][]point{p}, false, r.dst.Rect), c, alpha*0.6)
+}
+
+func clamp01(f float64) float64 {
+	return math.Max(0, math.Min(1, f))
+}
+
+// colorFor returns the color for the operands of a color operator in color space family cs.
+func colorFor(cs string, operands [][]byte) color.NRGBA {
+
+	var f []float64
+	for _, tok := range operands {
+		if len(tok) > 0 && tok[0] == '/' {
+			// Pattern
+			return color.NRGBA{128, 128, 128, 255}
+		}
+		f = append(f, clamp01(contentNumber(tok)))
+	}
+
+	if cs == "other" && len(f) == 1 {
+		// Separation tints denote the amount of colorant.
+		f[0] = 1 - f[0]
+	}
+
+	switch len(f) {
+
+	case 1:
+		g := uint8(f[0]*255 + 0.5)
+		return color.NRGBA{g, g, g, 255}
+
+	case 3:
+		return color.NRGBA{uint8(f[0]*255 + 0.5), uint8(f[1]*255 + 0.5), uint8(f[2]*255 + 0.5), 255}
+
+	case 4:
+		k := 1 - f[3]
+		return color.NRGBA{uint8((1-f[0])*k*255 + 0.5), uint8((1-f[1])*k*255 + 0.5), uint8((1-f[2])*k*255 + 0.5), 255}
+	}
+
+	return color.NRGBA{0, 0, 0, 255}
+}
+
+// colorSpaceFamily returns the family of the color space named by tok.
+func (r *renderer) colorSpaceFamily(resources Dict, tok []byte) string {
+
+	if len(tok) < 2 {
+		return "other"
+	}
+
+	name := string(tok[1:])
+
+	switch name {
+	case DeviceGrayCS, CalGrayCS:
+		return "gray"
+	case DeviceRGBCS, CalRGBCS:
+		return "rgb"
+	case DeviceCMYKCS:
+		return "cmyk"
+	}
+
+	css, err := r.xRefTable.DereferenceDict(resources["ColorSpace"])
+	if err != nil || css == nil {
+		return "other"
+	}
+
+	o, err := r.xRefTable.Dereference(css[name])
+	if err != nil {
+		return "other"
+	}
+
+	a, ok := o.(Array)
+	if !ok || len(a) == 0 {
+		return "other"
+	}
+
+	if n, ok := a[0].(Name); ok {
+		switch n.Value() {
+		case CalGrayCS:
+			return "gray"
+		case CalRGBCS, LabCS:
+			return "rgb"
+		case ICCBasedCS:
+			return "icc"
+		}
+	}
+
+	return "other"
+}
+
+// extGState applies the alpha constants and the line width of the graphics state parameter dict named by tok.
+func (r *renderer) extGState(resources Dict, tok []byte) error {
+
+	if len(tok) < 2 {
+		return nil
+	}
+
+	gss, err := r.xRefTable.DereferenceDict(resources["ExtGState"])
+	if err != nil || gss == nil {
+		return err
+	}
+
+	d, err := r.xRefTable.DereferenceDict(gss[string(tok[1:])])
+	if err != nil || d == nil {
+		return err
+	}
+
+	if o, found := d.Find("ca"); found {
+		r.gs.fillAlpha = r.xRefTable.DereferenceNumber(o)
+	}
+
+	if o, found := d.Find("CA"); found {
+		r.gs.strokeAlpha = r.xRefTable.DereferenceNumber(o)
+	}
+
+	if o, found := d.Find("LW"); found {
+		r.gs.lineWidth = r.xRefTable.DereferenceNumber(o)
+	}
+
+	return nil
+}
+
+// decodeImage returns the image XObject sd, image masks are returned as *image.Alpha.
+func (r *renderer) decodeImage(sd *StreamDict, objNr int) (image.Image, error) {
+
+	if img, found := r.images[objNr]; found {
+		return img, nil
+	}
+
+	r.images[objNr] = nil
+
+	// Work on a copy so the xRefTable is not modified.
+	sdc := *sd
+
+	if err := decodeImageStream(&sdc); err != nil {
+		if err == filter.ErrUnsupportedFilter {
+			r.xRefTable.Warn("render", 0, objNr, "skipping image with unsupported filter pipeline")
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if im := sdc.BooleanEntry("ImageMask"); im != nil && *im {
+		if sdc.Content == nil {
+			r.xRefTable.Warn("render", 0, objNr, "skipping %s encoded image mask", imageFilter(&sdc))
+			return nil, nil
+		}
+		img, err := stencilMask(r.xRefTable, &sdc)
+		if err == nil {
+			r.images[objNr] = img
+		}
+		return img, err
+	}
+
+	fileName, b, err := WriteImage(r.xRefTable, "", &sdc, objNr, false)
+	if err != nil || b == nil {
+		return nil, err
+	}
+
+	var img image.Image
+
+	switch filepath.Ext(fileName) {
+	case ".png":
+		img, err = png.Decode(bytes.NewReader(b))
+	case ".jpg":
+		img, err = jpeg.Decode(bytes.NewReader(b))
+	case ".tif":
+		img, err = tiff.Decode(bytes.NewReader(b))
+	default:
+		r.xRefTable.Warn("render", 0, objNr, "skipping image with unsupported format %s", filepath.Ext(fileName))
+		return nil, nil
+	}
+
+	if err != nil {
+		r.xRefTable.Warn("render", 0, objNr, "skipping image: %v", err)
+		return nil, nil
+	}
+
+	r.images[objNr] = img
+
+	return img, nil
+}
+
+// stencilMask returns an image mask as *image.Alpha with opaque pixels where the fill color gets painted.
+func stencilMask(xRefTable *XRefTable, sd *StreamDict) (*image.Alpha, error) {
+
+	w, h := sd.IntEntry("Width"), sd.IntEntry("Height")
+	if w == nil || h == nil || *w <= 0 || *h <= 0 {
+		return nil, errors.New("render: corrupt image mask dimensions")
+	}
+
+	paint := byte(0)
+	if a, err := numberArray(xRefTable, sd.Dict["Decode"]); err == nil && len(a) == 2 && a[0] == 1 {
+		paint = 1
+	}
+
+	img := image.NewAlpha(image.Rect(0, 0, *w, *h))
+	stride := (*w + 7) / 8
+
+	for y := 0; y < *h; y++ {
+		for x := 0; x < *w; x++ {
+			i := y*stride + x/8
+			if i >= len(sd.Content) {
+				return img, nil
+			}
+			if (sd.Content[i]>>(7-uint(x%8)))&1 == paint {
+				img.Pix[y*img.Stride+x] = 255
+			}
+		}
+	}
+
+	return img, nil
+}
+
+// drawImage paints img into the unit square of user space.
+func (r *renderer) drawImage(img image.Image) {
+
+	m := r.device()
+
+	det := m[0][0]*m[1][1] - m[0][1]*m[1][0]
+	if det == 0 {
+		return
+	}
+
+	x0, y0, x1, y1 := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, q := range [][2]float64{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+		x, y := m.transform(q[0], q[1])
+		x0, y0, x1, y1 = math.Min(x0, x), math.Min(y0, y), math.Max(x1, x), math.Max(y1, y)
+	}
+
+	bb := image.Rect(int(math.Floor(x0)), int(math.Floor(y0)), int(math.Ceil(x1)), int(math.Ceil(y1))).Intersect(r.dst.Rect)
+
+	sb := img.Bounds()
+	sw, sh := float64(sb.Dx()), float64(sb.Dy())
+
+	stencil, isStencil := img.(*image.Alpha)
+	fill := r.gs.fill
+
+	for y := bb.Min.Y; y < bb.Max.Y; y++ {
+		for x := bb.Min.X; x < bb.Max.X; x++ {
+
+			// Map the pixel center back into the unit square.
+			dx, dy := float64(x)+0.5-m[2][0], float64(y)+0.5-m[2][1]
+			u := (dx*m[1][1] - dy*m[1][0]) / det
+			v := (dy*m[0][0] - dx*m[0][1]) / det
+			if u < 0 || u >= 1 || v < 0 || v >= 1 {
+				continue
+			}
+
+			sx, sy := sb.Min.X+int(u*sw), sb.Min.Y+int((1-v)*sh)
+
+			var cr, cg, cb, ca uint32
+			if isStencil {
+				a := uint32(stencil.AlphaAt(sx, sy).A)
+				cr, cg, cb, ca = uint32(fill.R)*a/255, uint32(fill.G)*a/255, uint32(fill.B)*a/255, a
+			} else {
+				cr, cg, cb, ca = img.At(sx, sy).RGBA()
+				cr, cg, cb, ca = cr>>8, cg>>8, cb>>8, ca>>8
+			}
+
+			// Scale the premultiplied color by the constant alpha and the clip.
+			f := clamp01(r.gs.fillAlpha)
+			if r.gs.clip != nil {
+				f *= float64(r.gs.clip.AlphaAt(x, y).A) / 255
+			}
+			if f == 0 || ca == 0 {
+				continue
+			}
+
+			i := r.dst.PixOffset(x, y)
+			p := r.dst.Pix[i : i+4 : i+4]
+			a := float64(ca) * f
+			p[0] = uint8(float64(cr)*f + float64(p[0])*(1-a/255) + 0.5)
+			p[1] = uint8(float64(cg)*f + float64(p[1])*(1-a/255) + 0.5)
+			p[2] = uint8(float64(cb)*f + float64(p[2])*(1-a/255) + 0.5)
+			p[3] = uint8(a + float64(p[3])*(1-a/255) + 0.5)
+		}
+	}
+}
+
+// doXObject paints the image or Form XObject named by tok.
+func (r *renderer) doXObject(resources Dict, tok []byte) error {
+
+	if len(tok) < 2 || tok[0] != '/' {
+		return nil
+	}
+
+	xObjects, err := r.xRefTable.DereferenceDict(resources["XObject"])
+	if err != nil || xObjects == nil {
+		return err
+	}
+
+	ir := xObjects.IndirectRefEntry(string(tok[1:]))
+	if ir == nil {
+		return nil
+	}
+
+	objNr := ir.ObjectNumber.Value()
+	if r.forms[objNr] {
+		return nil
+	}
+
+	sd, err := r.xRefTable.DereferenceStreamDict(*ir)
+	if err != nil || sd == nil {
+		return err
+	}
+
+	st := sd.Subtype()
+	if st == nil {
+		return nil
+	}
+
+	if *st == "Image" {
+		img, err := r.decodeImage(sd, objNr)
+		if err != nil || img == nil {
+			return err
+		}
+		r.drawImage(img)
+		return nil
+	}
+
+	if *st != "Form" {
+		return nil
+	}
+
+	b, err := streamContent(r.xRefTable, *ir)
+	if err != nil || b == nil {
+		return err
+	}
+
+	res, err := r.xRefTable.DereferenceDict(sd.Dict["Resources"])
+	if err != nil {
+		return err
+	}
+	if res == nil {
+		res = resources
+	}
+
+	saved := *r.gs
+	defer func() { *r.gs = saved }()
+
+	if m, err := numberArray(r.xRefTable, sd.Dict["Matrix"]); err == nil && len(m) == 6 {
+		r.gs.text.ctm = newMatrix(m[0], m[1], m[2], m[3], m[4], m[5]).multiply(r.gs.text.ctm)
+	}
+
+	if bb, err := numberArray(r.xRefTable, sd.Dict["BBox"]); err == nil && len(bb) == 4 {
+		m := r.device()
+		var p []point
+		for _, q := range [][2]float64{{bb[0], bb[1]}, {bb[2], bb[1]}, {bb[2], bb[3]}, {bb[0], bb[3]}} {
+			x, y := m.transform(q[0], q[1])
+			p = append(p, point{x, y})
+		}
+		r.clipPath([][]point{p}, false)
+	}
+
+	r.forms[objNr] = true
+	defer delete(r.forms, objNr)
+
+	return r.process(b, res)
+}
+
+// process renders a decoded content stream.
+func (r *renderer) process(content []byte, resources Dict) error {
+
+	var (
+		stack    []renderState
+		operands [][]byte
+		path     [][]point
+		closed   []bool
+		cur      point
+		clip     int // 1 for W, 2 for W*
+	)
+
+	tm, tlm := identMatrix, identMatrix
+
+	num := func(i int) float64 {
+		return contentNumber(operands[len(operands)-i])
+	}
+
+	// pt transforms a point given in user space into device space.
+	pt := func(x, y float64) point {
+		x, y = r.device().transform(x, y)
+		return point{x, y}
+	}
+
+	moveTo := func(p point) {
+		path = append(path, []point{p})
+		closed = append(closed, false)
+		cur = p
+	}
+
+	lineTo := func(p point) {
+		if len(path) == 0 {
+			moveTo(cur)
+		}
+		path[len(path)-1] = append(path[len(path)-1], p)
+		cur = p
+	}
+
+	curveTo := func(p1, p2, p3 point) {
+		p0 := cur
+		n := int(math.Max(4, math.Min(64, (math.Hypot(p1.x-p0.x, p1.y-p0.y)+math.Hypot(p2.x-p1.x, p2.y-p1.y)+math.Hypot(p3.x-p2.x, p3.y-p2.y))/2)))
+		for i := 1; i <= n; i++ {
+			t := float64(i) / float64(n)
+			a, b, c, d := (1-t)*(1-t)*(1-t), 3*t*(1-t)*(1-t), 3*t*t*(1-t), t*t*t
+			lineTo(point{a*p0.x + b*p1.x + c*p2.x + d*p3.x, a*p0.y + b*p1.y + c*p2.y + d*p3.y})
+		}
+	}
+
+	closePath := func() {
+		if n := len(path); n > 0 {
+			closed[n-1] = true
+			cur = path[n-1][0]
+		}
+	}
+
+	endPath := func() {
+		if clip > 0 {
+			r.clipPath(path, clip == 2)
+		}
+		path, closed, clip = nil, nil, 0
+	}
+
+	s := &contentScanner{b: content}
+
+	for {
+
+		tok, pos, err := s.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if !contentOperator(tok) {
+			operands = append(operands, tok)
+			continue
+		}
+
+		op := string(tok)
+		n := len(operands)
+
+		switch op {
+
+		case "BI":
+			if _, err := s.inlineImage(pos); err != nil {
+				return err
+			}
+
+		case "q":
+			stack = append(stack, *r.gs)
+
+		case "Q":
+			if n := len(stack); n > 0 {
+				*r.gs, stack = stack[n-1], stack[:n-1]
+			}
+
+		case "cm":
+			if m, ok := operandMatrix(operands); ok {
+				r.gs.text.ctm = m.multiply(r.gs.text.ctm)
+			}
+
+		case "w":
+			if n > 0 {
+				r.gs.lineWidth = num(1)
+			}
+
+		case "gs":
+			if n > 0 {
+				if err := r.extGState(resources, operands[n-1]); err != nil {
+					return err
+				}
+			}
+
+		case "g", "rg", "k":
+			r.gs.fill = colorFor("", operands)
+
+		case "G", "RG", "K":
+			r.gs.stroke = colorFor("", operands)
+
+		case "cs":
+			if n > 0 {
+				r.gs.fillCS = r.colorSpaceFamily(resources, operands[n-1])
+			}
+			r.gs.fill = color.NRGBA{0, 0, 0, 255}
+
+		case "CS":
+			if n > 0 {
+				r.gs.strokeCS = r.colorSpaceFamily(resources, operands[n-1])
+			}
+			r.gs.stroke = color.NRGBA{0, 0, 0, 255}
+
+		case "sc", "scn":
+			r.gs.fill = colorFor(r.gs.fillCS, operands)
+
+		case "SC", "SCN":
+			r.gs.stroke = colorFor(r.gs.strokeCS, operands)
+
+		case "m":
+			if n >= 2 {
+				moveTo(pt(num(2), num(1)))
+			}
+
+		case "l":
+			if n >= 2 {
+				lineTo(pt(num(2), num(1)))
+			}
+
+		case "c":
+			if n >= 6 {
+				curveTo(pt(num(6), num(5)), pt(num(4), num(3)), pt(num(2), num(1)))
+			}
+
+		case "v":
+			if n >= 4 {
+				curveTo(cur, pt(num(4), num(3)), pt(num(2), num(1)))
+			}
+
+		case "y":
+			if n >= 4 {
+				p := pt(num(2), num(1))
+				curveTo(pt(num(4), num(3)), p, p)
+			}
+
+		case "h":
+			closePath()
+
+		case "re":
+			if n >= 4 {
+				x, y, w, h := num(4), num(3), num(2), num(1)
+				moveTo(pt(x, y))
+				lineTo(pt(x+w, y))
+				lineTo(pt(x+w, y+h))
+				lineTo(pt(x, y+h))
+				closePath()
+			}
+
+		case "W":
+			clip = 1
+
+		case "W*":
+			clip = 2
+
+		case "f", "F", "f*":
+			r.fillPath(path, op == "f*")
+			endPath()
+
+		case "S", "s":
+			if op == "s" {
+				closePath()
+			}
+			r.strokePath(path, closed)
+			endPath()
+
+		case "B", "B*", "b", "b*":
+			if op[0] == 'b' {
+				closePath()
+			}
+			r.fillPath(path, op[len(op)-1] == '*')
+			r.strokePath(path, closed)
+			endPath()
+
+		case "n":
+			endPath()
+
+		case "Do":
+			if n > 0 {
+				if err := r.doXObject(resources, operands[n-1]); err != nil {
+					return err
+				}
+			}
+
+		default:
+			if err := r.textOperator(op, operands, resources, &r.gs.text, &tm, &tlm); err != nil {
+				return err
+			}
+		}
+
+		operands = nil
+	}
+}
+
+// deviceMatrix returns the transformation from default user space into the pixels of the rendered box
+// scaled by s and rotated clockwise by rot degrees.
+func deviceMatrix(box types.Rectangle, rot int, s float64) matrix {
+
+	llx, lly, urx, ury := box.LL.X, box.LL.Y, box.UR.X, box.UR.Y
+
+	switch rot {
+	case 90:
+		return newMatrix(0, s, s, 0, -lly*s, -llx*s)
+	case 180:
+		return newMatrix(-s, 0, 0, s, urx*s, -lly*s)
+	case 270:
+		return newMatrix(0, -s, -s, 0, ury*s, urx*s)
+	}
+
+	return newMatrix(s, 0, 0, -s, -llx*s, ury*s)
+}
+
+// RenderPage rasterizes page pageNr at dpi dots per inch taking into account crop box and page rotation.
+func RenderPage(xRefTable *XRefTable, pageNr int, dpi float64) (*image.RGBA, error) {
+
+	if pageNr < 1 || pageNr > xRefTable.PageCount {
+		return nil, NewError(ErrPageOutOfRange, "RenderPage: invalid page number: %d", pageNr)
+	}
+
+	if dpi <= 0 {
+		return nil, errors.Errorf("RenderPage: invalid resolution: %.2f dpi", dpi)
+	}
+
+	pageDict, inhPAttrs, err := xRefTable.PageDict(pageNr)
+	if err != nil {
+		return nil, err
+	}
+	if pageDict == nil || inhPAttrs.CropBox() == nil {
+		return nil, errors.Errorf("RenderPage: missing page %d", pageNr)
+	}
+
+	box := rect(xRefTable, inhPAttrs.CropBox())
+
+	rot := inhPAttrs.Rotate() % 360
+	if rot < 0 {
+		rot += 360
+	}
+
+	s := dpi / 72
+	w, h := int(math.Ceil(box.Width()*s)), int(math.Ceil(box.Height()*s))
+	if rot == 90 || rot == 270 {
+		w, h = h, w
+	}
+
+	if w <= 0 || h <= 0 || w*h > maxRenderPixels {
+		return nil, errors.Errorf("RenderPage: page %d: invalid image size %d x %d", pageNr, w, h)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Rect, image.White, image.Point{}, draw.Src)
+
+	b, err := pageContent(xRefTable, pageDict)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &renderer{
+		textExtractor: newTextExtractor(xRefTable),
+		dst:           dst,
+		dev:           deviceMatrix(box, rot, s),
+		images:        map[int]image.Image{},
+		gs: &renderState{
+			text:        textGraphicsState{ctm: identMatrix, hScale: 100},
+			fill:        color.NRGBA{0, 0, 0, 255},
+			stroke:      color.NRGBA{0, 0, 0, 255},
+			fillAlpha:   1,
+			strokeAlpha: 1,
+			lineWidth:   1,
+		},
+	}
+
+	r.show = r.showGlyph
+
+	if err = r.process(b, inhPAttrs.resources); err != nil {
+		// Return what we got so far.
+		xRefTable.Warn("render", pageNr, 0, "incomplete rendering: %v", err)
+	}
+
+	return dst, nil
+}
@@ -0,0 +1,288 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/jplu/pdfcpu/pkg/filter"
+	"github.com/jplu/pdfcpu/pkg/log"
+	"github.com/jplu/pdfcpu/pkg/types"
+	"github.com/pkg/errors"
+)
+
+// Resize describes the new size of pages.
+type Resize struct {
+	Dim         types.Dim // The new page size.
+	Orientation bool      // Swap width and height of Dim for pages displayed in the other orientation.
+	Margin      float64   // The minimum space around the scaled page content.
+}
+
+func parseResizeError() error {
+	return errors.New("Invalid resize configuration string. Please consult pdfcpu help resize.")
+}
+
+func parseResizePaperSize(v string, r *Resize) error {
+
+	if d, ok := types.PaperSize[v]; ok {
+		r.Dim = *d
+		r.Orientation = true
+		return nil
+	}
+
+	// A trailing L or P fixes landscape or portrait orientation.
+	if !strings.HasSuffix(v, "L") && !strings.HasSuffix(v, "P") {
+		return errors.Errorf("unknown paper size: %s\n", v)
+	}
+
+	d, ok := types.PaperSize[v[:len(v)-1]]
+	if !ok {
+		return errors.Errorf("unknown paper size: %s\n", v)
+	}
+
+	r.Dim = *d
+	if strings.HasSuffix(v, "L") {
+		r.Dim = types.Dim{W: d.H, H: d.W}
+	}
+
+	return nil
+}
+
+func parseResizeDimensions(v string, r *Resize) error {
+
+	ss := strings.Fields(v)
+	if len(ss) != 2 {
+		return errors.Errorf("illegal dimensions: w h, %s\n", v)
+	}
+
+	w, err := strconv.ParseFloat(ss[0], 64)
+	if err != nil {
+		return errors.Errorf("width must be a float value: %s\n", ss[0])
+	}
+
+	h, err := strconv.ParseFloat(ss[1], 64)
+	if err != nil {
+		return errors.Errorf("height must be a float value: %s\n", ss[1])
+	}
+
+	r.Dim = types.Dim{W: w, H: h}
+
+	return nil
+}
+
+// ParseResizeDetails parses a resize command string into an internal structure.
+func ParseResizeDetails(s string) (*Resize, error) {
+
+	r := Resize{}
+
+	var setPaper, setDim bool
+
+	for _, s := range strings.Split(s, ",") {
+
+		ss := strings.Split(s, ":")
+		if len(ss) != 2 {
+			return nil, parseResizeError()
+		}
+
+		k := strings.TrimSpace(ss[0])
+		v := strings.TrimSpace(ss[1])
+
+		var err error
+
+		switch k {
+		case "paper":
+			err = parseResizePaperSize(v, &r)
+			setPaper = true
+
+		case "dim":
+			err = parseResizeDimensions(v, &r)
+			setDim = true
+
+		case "margin":
+			r.Margin, err = strconv.ParseFloat(v, 64)
+			if err != nil {
+				err = errors.Errorf("margin must be a float value: %s\n", v)
+			}
+
+		default:
+			err = parseResizeError()
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if setPaper == setDim {
+		return nil, errors.New("Please specify one of paper or dim")
+	}
+
+	return &r, r.validate()
+}
+
+func (r Resize) validate() error {
+
+	if r.Dim.W <= 0 || r.Dim.H <= 0 {
+		return errors.Errorf("invalid page size: %.2f x %.2f", r.Dim.W, r.Dim.H)
+	}
+
+	if r.Margin < 0 || 2*r.Margin >= r.Dim.W || 2*r.Margin >= r.Dim.H {
+		return errors.Errorf("invalid margin %.2f for page size %.2f x %.2f", r.Margin, r.Dim.W, r.Dim.H)
+	}
+
+	return nil
+}
+
+// transformRect returns the bounding box of rect a transformed by m.
+func transformRect(xRefTable *XRefTable, a Array, m matrix) Array {
+
+	r := rect(xRefTable, a)
+
+	x1, y1 := m.transform(r.LL.X, r.LL.Y)
+	x2, y2 := m.transform(r.UR.X, r.UR.Y)
+
+	return NewRectangle(math.Min(x1, x2), math.Min(y1, y2), math.Max(x1, x2), math.Max(y1, y2))
+}
+
+// resizeAnnotations moves the annotations of pageDict along with the page content.
+func resizeAnnotations(xRefTable *XRefTable, pageDict Dict, m matrix) error {
+
+	annots, err := xRefTable.DereferenceArray(pageDict["Annots"])
+	if err != nil {
+		return err
+	}
+
+	for _, o := range annots {
+
+		d, err := xRefTable.DereferenceDict(o)
+		if err != nil {
+			return err
+		}
+
+		if a := d.ArrayEntry("Rect"); len(a) == 4 {
+			d.Update("Rect", transformRect(xRefTable, a, m))
+		}
+	}
+
+	return nil
+}
+
+// resizePage scales the content of page pageNr to fit the page size described by r.
+func resizePage(xRefTable *XRefTable, pageNr int, r *Resize) error {
+
+	pageDict, _, err := xRefTable.PageDict(pageNr)
+	if err != nil {
+		return err
+	}
+
+	bp, err := pageForm(xRefTable, pageNr)
+	if err != nil {
+		return err
+	}
+
+	if bp.vp.Width() <= 0 || bp.vp.Height() <= 0 {
+		return errors.Errorf("Resize: page %d: empty page", pageNr)
+	}
+
+	dw, dh := bp.displaySize()
+
+	dim := r.Dim
+	if r.Orientation && dim.Landscape() != (dw > dh) {
+		dim = types.Dim{W: dim.H, H: dim.W}
+	}
+
+	m := bp.placement(r.Margin, r.Margin, dim.W-2*r.Margin, dim.H-2*r.Margin)
+
+	sd := &StreamDict{
+		Dict:           NewDict(),
+		Content:        []byte(placeForm("Fm0", m)),
+		FilterPipeline: []PDFFilter{{Name: filter.Flate, DecodeParms: nil}}}
+
+	sd.InsertName("Filter", filter.Flate)
+
+	if err = encodeStream(sd); err != nil {
+		return err
+	}
+
+	contents, err := xRefTable.IndRefForNewObject(*sd)
+	if err != nil {
+		return err
+	}
+
+	// The page gets displayed as is.
+	for _, k := range []string{"CropBox", "TrimBox", "BleedBox", "ArtBox"} {
+		pageDict.Delete(k)
+	}
+
+	pageDict.Update("MediaBox", NewRectangle(0, 0, dim.W, dim.H))
+	pageDict.Update("Rotate", Integer(0))
+	pageDict.Update("Resources", Dict(map[string]Object{"XObject": Dict(map[string]Object{"Fm0": *bp.form})}))
+	pageDict.Update("Contents", *contents)
+
+	return resizeAnnotations(xRefTable, pageDict, m)
+}
+
+// Resize scales the content of selectedPages to a new page size preserving the aspect ratio.
+// The page content gets centered within the margin and rendered as displayed taking the page rotation into account.
+// Annotations move along with the page content.
+func (xRefTable *XRefTable) Resize(selectedPages IntSet, r *Resize) error {
+
+	log.Debug.Printf("Resize begin: %v\n", selectedPages)
+
+	if err := r.validate(); err != nil {
+		return err
+	}
+
+	root, err := xRefTable.Pages()
+	if err != nil {
+		return err
+	}
+	if root == nil {
+		return errors.New("Resize: missing page tree root")
+	}
+
+	// Make pages self-contained so nothing gets inherited by resized pages.
+	var leaves []IndirectRef
+	var nodes []int
+
+	if err = xRefTable.collectPageLeaves(*root, Dict{}, IntSet{}, &leaves, &nodes); err != nil {
+		return err
+	}
+
+	for _, objNr := range nodes {
+		if d, err := xRefTable.DereferenceDict(*NewIndirectRef(objNr, 0)); err == nil && d != nil {
+			for _, k := range inheritablePageAttrs {
+				d.Delete(k)
+			}
+		}
+	}
+
+	for i := 1; i <= xRefTable.PageCount; i++ {
+		if !selectedPages[i] {
+			continue
+		}
+		if err = resizePage(xRefTable, i, r); err != nil {
+			return err
+		}
+	}
+
+	log.Debug.Println("Resize end")
+
+	return nil
+}
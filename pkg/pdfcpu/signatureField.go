@@ -0,0 +1,210 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/jplu/pdfcpu/pkg/types"
+	"github.com/pkg/errors"
+)
+
+// SignatureField describes an empty signature field to be signed later on.
+type SignatureField struct {
+	Name  string          // Partial field name, needs to be unique among the top level fields.
+	Page  int             // Page the widget annotation is placed on.
+	Rect  types.Rectangle // Widget annotation rectangle in default user space units.
+	Label string          // Optional text rendered into the placeholder appearance.
+}
+
+func (sf SignatureField) String() string {
+	return fmt.Sprintf("%s page:%d rect:%s", sf.Name, sf.Page, sf.Rect)
+}
+
+// acroFormDict returns the AcroForm dict of the catalog and creates one if missing.
+func acroFormDict(xRefTable *XRefTable) (Dict, error) {
+
+	rootDict, err := xRefTable.Catalog()
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := xRefTable.DereferenceDict(rootDict["AcroForm"])
+	if err != nil {
+		return nil, err
+	}
+
+	if d == nil {
+		d = NewDict()
+		d.Insert("Fields", Array{})
+		rootDict.Insert("AcroForm", d)
+	}
+
+	return d, nil
+}
+
+// fieldNames returns the partial names of all top level fields.
+func fieldNames(xRefTable *XRefTable, fields Array) (StringSet, error) {
+
+	names := StringSet{}
+
+	for _, o := range fields {
+
+		d, err := xRefTable.DereferenceDict(o)
+		if err != nil {
+			return nil, err
+		}
+
+		if d == nil {
+			continue
+		}
+
+		o, err := xRefTable.Dereference(d["T"])
+		if err != nil {
+			return nil, err
+		}
+
+		var s string
+
+		switch o := o.(type) {
+		case StringLiteral:
+			s, err = StringLiteralToString(o.Value())
+		case HexLiteral:
+			s, err = HexLiteralToString(o.Value())
+		default:
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		names[s] = true
+	}
+
+	return names, nil
+}
+
+func createSignatureFieldAppearance(xRefTable *XRefTable, sf SignatureField) (*IndirectRef, error) {
+
+	w, h := sf.Rect.Width(), sf.Rect.Height()
+
+	// Placeholder: outline plus optional label.
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "0.5 G 0 0 %.2f %.2f re s ", w, h)
+
+	d := Dict(
+		map[string]Object{
+			"Type":     Name("XObject"),
+			"Subtype":  Name("Form"),
+			"FormType": Integer(1),
+			"BBox":     NewRectangle(0, 0, w, h),
+			"Matrix":   NewIntegerArray(1, 0, 0, 1, 0, 0),
+		},
+	)
+
+	if len(sf.Label) > 0 {
+
+		s, err := Escape(sf.Label)
+		if err != nil {
+			return nil, err
+		}
+
+		fontSize := 10.
+		if h < 2*fontSize {
+			fontSize = h / 2
+		}
+
+		fmt.Fprintf(&b, "BT 0 g /F1 %.2f Tf 2 %.2f Td (%s) Tj ET", fontSize, (h-fontSize)/2, *s)
+
+		fontDict := NewDict()
+		fontDict.InsertName("Type", "Font")
+		fontDict.InsertName("Subtype", "Type1")
+		fontDict.InsertName("BaseFont", "Helvetica")
+
+		d.Insert("Resources", Dict(map[string]Object{"Font": Dict(map[string]Object{"F1": fontDict})}))
+	}
+
+	sd := &StreamDict{Dict: d, Content: b.Bytes()}
+
+	err := encodeStream(sd)
+	if err != nil {
+		return nil, err
+	}
+
+	return xRefTable.IndRefForNewObject(*sd)
+}
+
+func addSignatureField(xRefTable *XRefTable, sf SignatureField) (*IndirectRef, error) {
+
+	if sf.Page < 1 || sf.Page > xRefTable.PageCount {
+		return nil, NewError(ErrPageOutOfRange, "signature field %s: invalid page %d", sf.Name, sf.Page)
+	}
+
+	if sf.Rect.Width() <= 0 || sf.Rect.Height() <= 0 {
+		return nil, errors.Errorf("signature field %s: invalid rectangle %s", sf.Name, sf.Rect)
+	}
+
+	pageDict, _, err := xRefTable.PageDict(sf.Page)
+	if err != nil {
+		return nil, err
+	}
+
+	pageIndRef, err := xRefTable.PageIndRef(sf.Page)
+	if err != nil {
+		return nil, err
+	}
+
+	ap, err := createSignatureFieldAppearance(xRefTable, sf)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := textString(sf.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Merged field and widget annotation dict.
+	d := Dict(
+		map[string]Object{
+			"FT":      Name("Sig"),
+			"T":       name,
+			"Type":    Name("Annot"),
+			"Subtype": Name("Widget"),
+			"Rect":    NewRectangle(sf.Rect.LL.X, sf.Rect.LL.Y, sf.Rect.UR.X, sf.Rect.UR.Y),
+			"F":       Integer(4), // Print
+			"P":       *pageIndRef,
+			"AP":      Dict(map[string]Object{"N": *ap}),
+		},
+	)
+
+	return addAnnotation(xRefTable, pageDict, d)
+}
+
+// AddSignatureFields adds empty signature fields to the interactive form of xRefTable.
+// The resulting document may be signed later on by another party.
+func AddSignatureFields(xRefTable *XRefTable, fields []SignatureField) error {
+
+	ffs := make([]FormField, len(fields))
+	for i, sf := range fields {
+		ffs[i] = FormField{Type: FormFieldSignature, Name: sf.Name, Page: sf.Page, Rect: sf.Rect, Default: sf.Label}
+	}
+
+	return AddFormFields(xRefTable, ffs)
+}
@@ -0,0 +1,309 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"github.com/jplu/pdfcpu/pkg/log"
+	"github.com/pkg/errors"
+)
+
+// resolveDest returns the explicit destination for the destination o which may be named.
+func resolveDest(xRefTable *XRefTable, o Object, depth int) (Array, error) {
+
+	if depth > 10 {
+		return nil, errors.New("resolveDest: destination nesting too deep")
+	}
+
+	o, err := xRefTable.Dereference(o)
+	if err != nil || o == nil {
+		return nil, err
+	}
+
+	switch o := o.(type) {
+
+	case Array:
+		return o, nil
+
+	case Dict:
+		return resolveDest(xRefTable, o["D"], depth+1)
+
+	case Name:
+		rootDict, err := xRefTable.Catalog()
+		if err != nil {
+			return nil, err
+		}
+		d, err := xRefTable.DereferenceDict(rootDict["Dests"])
+		if err != nil || d == nil {
+			return nil, err
+		}
+		return resolveDest(xRefTable, d[o.Value()], depth+1)
+
+	case StringLiteral, HexLiteral:
+		k, ok := destKey(o)
+		if !ok || xRefTable.Names["Dests"] == nil {
+			return nil, nil
+		}
+		v, found := xRefTable.Names["Dests"].Value(k)
+		if !found {
+			return nil, nil
+		}
+		return resolveDest(xRefTable, v, depth+1)
+	}
+
+	return nil, nil
+}
+
+// outlineItemDest returns the explicit destination of an outline item.
+func outlineItemDest(xRefTable *XRefTable, d Dict) (Array, error) {
+
+	if o, found := d.Find("Dest"); found {
+		return resolveDest(xRefTable, o, 0)
+	}
+
+	a, err := xRefTable.DereferenceDict(d["A"])
+	if err != nil || a == nil {
+		return nil, err
+	}
+
+	if s := a.NameEntry("S"); s == nil || *s != "GoTo" {
+		return nil, nil
+	}
+
+	return resolveDest(xRefTable, a["D"], 0)
+}
+
+// pruneOutlineItems keeps the items below parent pointing to page or having descendants doing so.
+// Kept items pointing elsewhere get redirected to page.
+func pruneOutlineItems(xRefTable *XRefTable, parent Dict, page IndirectRef, visited IntSet) (bool, error) {
+
+	var kept []IndirectRef
+
+	for o := parent["First"]; o != nil; {
+
+		ir, ok := o.(IndirectRef)
+		if !ok || visited[ir.ObjectNumber.Value()] {
+			break
+		}
+		visited[ir.ObjectNumber.Value()] = true
+
+		d, err := xRefTable.DereferenceDict(ir)
+		if err != nil {
+			return false, err
+		}
+		if d == nil {
+			break
+		}
+
+		o = d["Next"]
+
+		keep, err := pruneOutlineItems(xRefTable, d, page, visited)
+		if err != nil {
+			return false, err
+		}
+
+		dest, err := outlineItemDest(xRefTable, d)
+		if err != nil {
+			return false, err
+		}
+
+		onPage := false
+		if len(dest) > 0 {
+			if pir, ok := dest[0].(IndirectRef); ok && pir.ObjectNumber == page.ObjectNumber {
+				onPage = true
+			}
+		}
+
+		if !onPage && !keep {
+			continue
+		}
+
+		// Named destinations do not survive splitting.
+		if !onPage {
+			dest = Array{page, Name("Fit")}
+		}
+		d.Update("Dest", dest)
+		d.Delete("A")
+		d.Delete("SE")
+
+		kept = append(kept, ir)
+	}
+
+	parent.Delete("First")
+	parent.Delete("Last")
+
+	for i, ir := range kept {
+		d, err := xRefTable.DereferenceDict(ir)
+		if err != nil {
+			return false, err
+		}
+		d.Delete("Prev")
+		d.Delete("Next")
+		if i > 0 {
+			d.Insert("Prev", kept[i-1])
+		}
+		if i < len(kept)-1 {
+			d.Insert("Next", kept[i+1])
+		}
+	}
+
+	if len(kept) > 0 {
+		parent.Insert("First", kept[0])
+		parent.Insert("Last", kept[len(kept)-1])
+	}
+
+	// Closed items have a negative count.
+	c := parent.IntEntry("Count")
+	closed := c != nil && *c < 0
+
+	if err := updateOutlineCount(xRefTable, parent); err != nil {
+		return false, err
+	}
+
+	if c = parent.IntEntry("Count"); closed && c != nil {
+		parent.Update("Count", Integer(-*c))
+	}
+
+	return len(kept) > 0, nil
+}
+
+// numberTreeEntries calls f for each key value pair of the number tree node o.
+func numberTreeEntries(xRefTable *XRefTable, o Object, f func(k int, v Object) error) error {
+
+	d, err := xRefTable.DereferenceDict(o)
+	if err != nil || d == nil {
+		return err
+	}
+
+	kids, err := xRefTable.DereferenceArray(d["Kids"])
+	if err != nil {
+		return err
+	}
+
+	for _, kid := range kids {
+		if err = numberTreeEntries(xRefTable, kid, f); err != nil {
+			return err
+		}
+	}
+
+	a, err := xRefTable.DereferenceArray(d["Nums"])
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i+1 < len(a); i += 2 {
+		k, err := xRefTable.DereferenceInteger(a[i])
+		if err != nil || k == nil {
+			continue
+		}
+		if err = f(k.Value(), a[i+1]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// keepPageLabel reduces the page labels of the catalog rootDict to the label of page.
+func keepPageLabel(xRefTable *XRefTable, rootDict Dict, page int) error {
+
+	o, found := rootDict.Find("PageLabels")
+	if !found {
+		return nil
+	}
+
+	start := -1
+	var label Dict
+
+	// Page labels are keyed by the page index of the first page of a range.
+	if err := numberTreeEntries(xRefTable, o, func(k int, v Object) error {
+		if k > page-1 || k < start {
+			return nil
+		}
+		d, err := xRefTable.DereferenceDict(v)
+		if err != nil {
+			return err
+		}
+		start, label = k, d
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if label == nil {
+		rootDict.Delete("PageLabels")
+		return nil
+	}
+
+	d := NewDict()
+	for k, v := range label {
+		d[k] = v
+	}
+
+	st := 1
+	if i := label.IntEntry("St"); i != nil {
+		st = *i
+	}
+
+	if st += page - 1 - start; st != 1 {
+		d.Update("St", Integer(st))
+	} else {
+		d.Delete("St")
+	}
+
+	rootDict.Update("PageLabels", Dict(map[string]Object{"Nums": Array{Integer(0), d}}))
+
+	return nil
+}
+
+// KeepDocumentDataForPage reduces document level data to what is relevant for page written as a single page file:
+// The outlines get limited to items pointing to page and its page label is retained.
+// Output intents, metadata and the document language apply to any page and are kept as they are.
+// Since this modifies xRefTable, use it on a clone.
+func KeepDocumentDataForPage(xRefTable *XRefTable, page int) error {
+
+	pageIR, err := xRefTable.PageIndRef(page)
+	if err != nil {
+		return err
+	}
+
+	rootDict, err := xRefTable.Catalog()
+	if err != nil {
+		return err
+	}
+
+	if err = keepPageLabel(xRefTable, rootDict, page); err != nil {
+		return err
+	}
+
+	d, _, err := outlineRoot(xRefTable, rootDict, false)
+	if err != nil || d == nil {
+		return err
+	}
+
+	kept, err := pruneOutlineItems(xRefTable, d, *pageIR, IntSet{})
+	if err != nil {
+		return err
+	}
+
+	if !kept {
+		rootDict.Delete("Outlines")
+	}
+
+	log.Debug.Printf("KeepDocumentDataForPage: page %d outlines:%t\n", page, kept)
+
+	return nil
+}
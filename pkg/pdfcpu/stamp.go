@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"math"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 
@@ -108,8 +109,8 @@ type Watermark struct {
 	width, height int // image or page dimensions.
 
 	// for a PDF watermark
-	resDict *IndirectRef // page resource dict.
-	cs      []byte       // page content stream.
+	resDict *IndirectRef // form resource dict referring to the imported page.
+	pdfPage *bookletPage // the imported page.
 
 	// page specific
 	bb      types.Rectangle // bounding box of the form representing this watermark.
@@ -177,12 +178,12 @@ func (wm Watermark) OnTopString() string {
 
 // IsPDF returns whether the watermark content is an image or text.
 func (wm Watermark) isPDF() bool {
-	return len(wm.fileName) > 0 && filepath.Ext(wm.fileName) == ".pdf"
+	return len(wm.fileName) > 0 && strings.ToLower(filepath.Ext(wm.fileName)) == ".pdf"
 }
 
 // IsImage returns whether the watermark content is an image or text.
 func (wm Watermark) isImage() bool {
-	return len(wm.fileName) > 0 && filepath.Ext(wm.fileName) != ".pdf"
+	return len(wm.fileName) > 0 && strings.ToLower(filepath.Ext(wm.fileName)) != ".pdf"
 }
 
 func (wm *Watermark) calcBoundingBox() {
@@ -307,7 +308,7 @@ func setWatermarkType(s string, wm *Watermark) error {
 
 	ss := strings.Split(s, ":")
 
-	ext := filepath.Ext(ss[0])
+	ext := strings.ToLower(filepath.Ext(ss[0]))
 	if ext == ".png" || ext == ".tif" || ext == ".tiff" || ext == ".pdf" {
 		wm.fileName = ss[0]
 	} else {
@@ -588,65 +589,6 @@ func createFontResForWM(xRefTable *XRefTable, wm *Watermark) error {
 	return nil
 }
 
-func contentStream(xRefTable *XRefTable, o Object) ([]byte, error) {
-
-	o, err := xRefTable.Dereference(o)
-	if err != nil {
-		return nil, err
-	}
-
-	bb := []byte{}
-
-	switch o := o.(type) {
-
-	case StreamDict:
-
-		// Decode streamDict for supported filters only.
-		err := decodeStream(&o)
-		if err == filter.ErrUnsupportedFilter {
-			return nil, errors.New("unsupported filter: unable to decode content for PDF watermark")
-		}
-		if err != nil {
-			return nil, err
-		}
-
-		//fmt.Printf("found %d content bytes\n", len(o.Content))
-		bb = o.Content
-
-	case Array:
-		for _, o := range o {
-
-			if o == nil {
-				continue
-			}
-
-			sd, err := xRefTable.DereferenceStreamDict(o)
-			if err != nil {
-				return nil, err
-			}
-
-			// Decode streamDict for supported filters only.
-			err = decodeStream(sd)
-			if err == filter.ErrUnsupportedFilter {
-				return nil, errors.New("unsupported filter: unable to decode content for PDF watermark")
-			}
-			if err != nil {
-				return nil, err
-			}
-
-			//fmt.Printf("append %d content bytes\n", len(sd.Content))
-			bb = append(bb, sd.Content...)
-			//fmt.Printf("len bb = %d\n", len(bb))
-		}
-	}
-
-	if len(bb) == 0 {
-		return nil, errors.New("PDF page has no content")
-	}
-
-	return bb, nil
-}
-
 func identifyObjNrs(ctx *Context, o Object, objNrs IntSet) error {
 
 	switch o := o.(type) {
@@ -735,65 +677,64 @@ func migrateObject(ctxSource, ctxDest *Context, o Object) error {
 	return nil
 }
 
+// createPDFResForWM imports page wm.page of the PDF file wm.fileName as a form XObject.
+// The page content including all resources gets carried over as is without rasterizing.
 func createPDFResForWM(ctx *Context, wm *Watermark) error {
 
 	xRefTable := ctx.XRefTable
 
 	// This PDF file is assumed to be valid.
-	otherCtx, err := ReadFile(wm.fileName, NewDefaultConfiguration())
+	config := NewDefaultConfiguration()
+	config.FS = ctx.FileSystem()
+
+	otherCtx, err := ReadFile(wm.fileName, config)
 	if err != nil {
 		return err
 	}
 
 	otherXRefTable := otherCtx.XRefTable
 
-	d, inhPAttrs, err := otherXRefTable.PageDict(wm.page)
+	d, _, err := otherXRefTable.PageDict(wm.page)
 	if err != nil {
 		return err
 	}
 	if d == nil {
-		return errors.Errorf("unknown page number: %d\n", wm.page)
+		return NewError(ErrPageOutOfRange, "unknown page number: %d", wm.page)
 	}
 
-	// fmt.Printf("detected pageDict=%s\n", d)
-	// fmt.Printf("detected resDict= %s\n", inhPAttrs.resources)
-	// fmt.Printf("detected rotate= %f\n", inhPAttrs.rotate)
-	// fmt.Printf("detected mediaBox= %s\n", inhPAttrs.mediaBox)
-	// fmt.Printf("detected cropBox= %s\n", inhPAttrs.cropBox)
-
-	// Retrieve content stream bytes.
-
-	o, found := d.Find("Contents")
-	if !found {
-		return errors.New("PDF page has no content")
-	}
-
-	wm.cs, err = contentStream(otherXRefTable, o)
+	bp, err := pageForm(otherXRefTable, wm.page)
 	if err != nil {
 		return err
 	}
 
-	// Migrate all objects referenced by this external resource dict into this context.
-	err = migrateObject(otherCtx, ctx, inhPAttrs.resources)
-	if err != nil {
-		return err
+	w, h := bp.displaySize()
+	if w <= 0 || h <= 0 {
+		return errors.Errorf("PDF page %d is empty\n", wm.page)
 	}
 
-	//fmt.Printf("migrated resDict inhPAttrs.resources %s\n", inhPAttrs.resources)
+	// Migrate the form and all objects referenced into this context.
+	d = Dict(
+		map[string]Object{
+			"ProcSet": NewNameArray("PDF"),
+			"XObject": Dict(map[string]Object{"Fm0": *bp.form}),
+		},
+	)
 
-	// Create an object for this resDict in xRefTable.
-	ir, err := xRefTable.IndRefForNewObject(inhPAttrs.resources)
-	if err != nil {
+	if err = migrateObject(otherCtx, ctx, d); err != nil {
 		return err
 	}
 
-	wm.resDict = ir
+	ir, err := xRefTable.IndRefForNewObject(d)
+	if err != nil {
+		return err
+	}
 
-	vp := viewPort(xRefTable, inhPAttrs)
-	//fmt.Printf("createPDFResForWM: vp = %s\n", vp)
+	bp.form = d.DictEntry("XObject").IndirectRefEntry("Fm0")
 
-	wm.width = int(vp.Width())
-	wm.height = int(vp.Height())
+	wm.resDict = ir
+	wm.pdfPage = bp
+	wm.width = int(math.Round(w))
+	wm.height = int(math.Round(h))
 
 	return nil
 }
@@ -801,7 +742,7 @@ func createPDFResForWM(ctx *Context, wm *Watermark) error {
 func createImageResForWM(xRefTable *XRefTable, wm *Watermark) error {
 
 	f := ReadTIFFFile
-	if filepath.Ext(wm.fileName) == ".png" {
+	if strings.ToLower(filepath.Ext(wm.fileName)) == ".png" {
 		f = ReadPNGFile
 	}
 
@@ -928,7 +869,6 @@ func prepareOCPropertiesInRoot(rootDict Dict, wm *Watermark) error {
 func createFormResDict(xRefTable *XRefTable, wm *Watermark) (*IndirectRef, error) {
 
 	if wm.isPDF() {
-		//return consolidated ResDict of for PDF/PageBoxColorInfo
 		return wm.resDict, nil
 	}
 
@@ -973,11 +913,7 @@ func createForm(xRefTable *XRefTable, wm *Watermark, withBB bool) error {
 	var b bytes.Buffer
 
 	if wm.isPDF() {
-		fmt.Fprintf(&b, "%f 0 0 %f 0 0 cm ", bb.Width()/wm.vp.Width(), bb.Height()/wm.vp.Height())
-		_, err := b.Write(wm.cs)
-		if err != nil {
-			return err
-		}
+		b.WriteString(placeForm("Fm0", wm.pdfPage.placement(0, 0, bb.Width(), bb.Height())))
 	} else if wm.isImage() {
 		fmt.Fprintf(&b, "q %f 0 0 %f 0 0 cm /Im0 Do Q", bb.Width(), bb.Height())
 	} else {
@@ -1086,7 +1022,7 @@ func updatePageResourcesForWM(xRefTable *XRefTable, resDict Dict, wm *Watermark,
 	} else {
 		d, _ := xRefTable.DereferenceDict(o)
 		for i := 0; i < 1000; i++ {
-			*gsID = "GS" + strconv.Itoa(i)
+			*gsID = wmExtGStatePrefix + strconv.Itoa(i)
 			if _, found := d.Find(*gsID); !found {
 				break
 			}
@@ -1100,7 +1036,7 @@ func updatePageResourcesForWM(xRefTable *XRefTable, resDict Dict, wm *Watermark,
 	} else {
 		d, _ := xRefTable.DereferenceDict(o)
 		for i := 0; i < 1000; i++ {
-			*xoID = "Fm" + strconv.Itoa(i)
+			*xoID = wmXObjectPrefix + strconv.Itoa(i)
 			if _, found := d.Find(*xoID); !found {
 				break
 			}
@@ -1111,6 +1047,21 @@ func updatePageResourcesForWM(xRefTable *XRefTable, resDict Dict, wm *Watermark,
 	return nil
 }
 
+// Page resource names of watermarks and stamps start with these prefixes
+// marking them for detection by RemoveWatermarks.
+// Earlier versions used GS and Fm instead.
+const (
+	wmExtGStatePrefix = "pdfcpuGS"
+	wmXObjectPrefix   = "pdfcpuFm"
+)
+
+// wmContentRegExp matches the content rendering a watermark or stamp as written by wmContent.
+var wmContentRegExp = regexp.MustCompile(` /Artifact <</Subtype /Watermark /Type /Pagination >>BDC q (?:\S+ ){6}cm /(` +
+	`(?:` + wmExtGStatePrefix + `|GS)\d+) gs /((?:` + wmXObjectPrefix + `|Fm)\d+) Do Q EMC `)
+
+// wmXObjectRegExp matches the resource names of watermark and stamp forms.
+var wmXObjectRegExp = regexp.MustCompile(`^(?:` + wmXObjectPrefix + `|Fm)\d+$`)
+
 func wmContent(wm *Watermark, gsID, xoID string) []byte {
 
 	m := wm.calcTransformMatrix()
@@ -1292,8 +1243,8 @@ func watermarkPage(xRefTable *XRefTable, i int, wm *Watermark) error {
 	// }
 	// fmt.Printf("%s\n", *d)
 
-	gsID := "GS0"
-	xoID := "Fm0"
+	gsID := wmExtGStatePrefix + "0"
+	xoID := wmXObjectPrefix + "0"
 
 	if inhPAttrs.resources == nil {
 		err = insertPageResourcesForWM(xRefTable, d, wm, gsID, xoID)
@@ -1372,14 +1323,439 @@ func AddWatermarks(ctx *Context, selectedPages IntSet, wm *Watermark) error {
 		return err
 	}
 
-	for k, v := range selectedPages {
-		if v {
-			err := watermarkPage(xRefTable, k, wm)
-			if err != nil {
+	for _, k := range SortedSelectedPages(selectedPages) {
+		err := watermarkPage(xRefTable, k, wm)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WatermarkFunc computes the watermark or stamp for page pageNr, eg. for serial numbers or page specific codes.
+// pageDim is the visible region of the page. Returning nil leaves the page alone.
+type WatermarkFunc func(pageNr int, pageDim types.Rectangle) (*Watermark, error)
+
+// AddWatermarksFunc adds the watermarks computed by f to all pages selected in page order.
+func AddWatermarksFunc(ctx *Context, selectedPages IntSet, f WatermarkFunc) error {
+
+	xRefTable := ctx.XRefTable
+
+	rootDict, err := xRefTable.Catalog()
+	if err != nil {
+		return err
+	}
+
+	var ocg *IndirectRef
+
+	// Text watermarks share their font dicts.
+	fonts := map[string]*IndirectRef{}
+
+	for _, p := range SortedSelectedPages(selectedPages) {
+
+		_, inhPAttrs, err := xRefTable.PageDict(p)
+		if err != nil {
+			return err
+		}
+
+		wm, err := f(p, viewPort(xRefTable, inhPAttrs))
+		if err != nil {
+			return err
+		}
+		if wm == nil {
+			continue
+		}
+
+		log.Debug.Printf("AddWatermarksFunc page %d wm:\n%s\n", p, wm)
+
+		if wm.objs == nil {
+			wm.objs = IntSet{}
+		}
+		if wm.fCache == nil {
+			wm.fCache = formCache{}
+		}
+
+		// All watermarks belong to the same optional content group.
+		if ocg == nil {
+			if err = createOCG(xRefTable, wm); err != nil {
+				return err
+			}
+			if err = prepareOCPropertiesInRoot(rootDict, wm); err != nil {
+				return err
+			}
+			ocg = wm.ocg
+		}
+		wm.ocg = ocg
+
+		if ir, ok := fonts[wm.fontName]; ok && !wm.isPDF() && !wm.isImage() {
+			wm.font = ir
+		} else {
+			if err = createResourcesForWM(ctx, wm); err != nil {
 				return err
 			}
+			if wm.font != nil {
+				fonts[wm.fontName] = wm.font
+			}
+		}
+
+		if err = createExtGStateForStamp(xRefTable, wm); err != nil {
+			return err
+		}
+
+		if err = watermarkPage(xRefTable, p, wm); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// cutWatermarks returns the content b without any watermarks or stamps along with their resource names.
+// A stamp gets appended to the content wrapped into q/Q, see patchContentForWM.
+// If first is true, b is the first content stream of the page and the leading q gets removed as well.
+// onTop returns true if a stamp has been cut off whose leading q is still left in the first content stream.
+func cutWatermarks(b []byte, first bool) (bb []byte, gsIDs, xoIDs []string, onTop bool) {
+
+	ii := wmContentRegExp.FindAllSubmatchIndex(b, -1)
+	if len(ii) == 0 {
+		return b, nil, nil, false
+	}
+
+	var i int
+
+	for _, m := range ii {
+		bb = append(bb, b[i:m[0]]...)
+		gsIDs = append(gsIDs, string(b[m[2]:m[3]]))
+		xoIDs = append(xoIDs, string(b[m[4]:m[5]]))
+		i = m[1]
+	}
+
+	bb = append(bb, b[i:]...)
+
+	if ii[len(ii)-1][1] == len(b) && bytes.HasSuffix(bb, []byte(" Q")) {
+		bb = bb[:len(bb)-2]
+		onTop = true
+	}
+
+	if onTop && first && bytes.HasPrefix(bb, []byte("q ")) {
+		bb = bb[2:]
+		onTop = false
+	}
+
+	return bb, gsIDs, xoIDs, onTop
+}
+
+// pageContentRefs returns the content streams of pageDict.
+func pageContentRefs(xRefTable *XRefTable, pageDict Dict) ([]IndirectRef, error) {
+
+	o, found := pageDict.Find("Contents")
+	if !found {
+		return nil, nil
+	}
+
+	if ir, ok := o.(IndirectRef); ok {
+		o1, err := xRefTable.Dereference(ir)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := o1.(StreamDict); ok {
+			return []IndirectRef{ir}, nil
+		}
+		o = o1
+	}
+
+	a, ok := o.(Array)
+	if !ok {
+		return nil, errors.New("RemoveWatermarks: corrupt page content")
+	}
+
+	var irs []IndirectRef
+
+	for _, o := range a {
+		if ir, ok := o.(IndirectRef); ok {
+			irs = append(irs, ir)
+		}
+	}
+
+	return irs, nil
+}
+
+// patchContentStream applies f to the decoded content stream ir.
+// Content streams using unsupported filters are left alone.
+func patchContentStream(xRefTable *XRefTable, ir IndirectRef, f func([]byte) []byte) error {
+
+	entry, found := xRefTable.FindTableEntry(ir.ObjectNumber.Value(), ir.GenerationNumber.Value())
+	if !found {
+		return nil
+	}
+
+	sd, ok := entry.Object.(StreamDict)
+	if !ok {
+		return nil
+	}
+
+	err := decodeStream(&sd)
+	if err == filter.ErrUnsupportedFilter {
+		log.Info.Println("unsupported filter: unable to remove watermarks from content.")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	b := f(sd.Content)
+	if b == nil {
+		return nil
+	}
+
+	sd.Content = b
+
+	if err = encodeStream(&sd); err != nil {
+		return err
+	}
+
+	entry.Object = sd
+
+	return nil
+}
+
+// deleteResources deletes the resources named ids of category resType from resDict.
+// The category dict gets replaced by a copy as it may be shared with other pages.
+func deleteResources(xRefTable *XRefTable, resDict Dict, resType string, ids []string) error {
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	o, found := resDict.Find(resType)
+	if !found {
+		return nil
+	}
+
+	d, err := xRefTable.DereferenceDict(o)
+	if err != nil || d == nil {
+		return err
+	}
+
+	d = cloneDict(d)
+
+	for _, id := range ids {
+		d.Delete(id)
+	}
+
+	if len(d) == 0 {
+		resDict.Delete(resType)
+		return nil
+	}
+
+	resDict.Update(resType, d)
+
+	return nil
+}
+
+// wmResourceNames holds the resource names of the watermarks and stamps cut off a content stream.
+type wmResourceNames struct {
+	gsIDs, xoIDs []string
+}
+
+// removeWatermarksFromPage removes all watermarks and stamps from page pageNr.
+// objs tracks the content streams already processed, which may be shared by pages.
+// Returns true if any watermarks or stamps have been removed.
+func removeWatermarksFromPage(xRefTable *XRefTable, pageNr int, objs map[int]*wmResourceNames) (bool, error) {
+
+	log.Debug.Printf("removeWatermarksFromPage %d\n", pageNr)
+
+	d, inhPAttrs, err := xRefTable.PageDict(pageNr)
+	if err != nil {
+		return false, err
+	}
+	if d == nil {
+		return false, errors.Errorf("RemoveWatermarks: missing page %d", pageNr)
+	}
+
+	irs, err := pageContentRefs(xRefTable, d)
+	if err != nil {
+		return false, err
+	}
+
+	var gsIDs, xoIDs []string
+	var onTop bool
+
+	for i, ir := range irs {
+
+		objNr := ir.ObjectNumber.Value()
+
+		// A shared content stream has already been patched,
+		// still its watermarks need to be removed from the resources of this page.
+		if names, ok := objs[objNr]; ok {
+			gsIDs = append(gsIDs, names.gsIDs...)
+			xoIDs = append(xoIDs, names.xoIDs...)
+			continue
+		}
+
+		names := &wmResourceNames{}
+
+		err = patchContentStream(xRefTable, ir, func(b []byte) []byte {
+			bb, gs, xo, top := cutWatermarks(b, i == 0)
+			if len(gs) == 0 {
+				return nil
+			}
+			names.gsIDs, names.xoIDs = gs, xo
+			onTop = onTop || top
+			return bb
+		})
+		if err != nil {
+			return false, err
+		}
+
+		gsIDs = append(gsIDs, names.gsIDs...)
+		xoIDs = append(xoIDs, names.xoIDs...)
+		objs[objNr] = names
+	}
+
+	if onTop {
+		// Remove the q left in the first content stream by a stamp.
+		err = patchContentStream(xRefTable, irs[0], func(b []byte) []byte {
+			if !bytes.HasPrefix(b, []byte("q ")) {
+				return nil
+			}
+			return b[2:]
+		})
+		if err != nil {
+			return false, err
 		}
 	}
 
+	if len(xoIDs) == 0 || inhPAttrs.resources == nil {
+		return len(xoIDs) > 0, nil
+	}
+
+	// The resources may be inherited or shared by pages not selected.
+	// Leave them alone and assign a cleaned up copy to this page.
+	resDict := cloneDict(inhPAttrs.resources)
+
+	if err = deleteResources(xRefTable, resDict, "ExtGState", gsIDs); err != nil {
+		return false, err
+	}
+
+	if err = deleteResources(xRefTable, resDict, "XObject", xoIDs); err != nil {
+		return false, err
+	}
+
+	d.Update("Resources", resDict)
+
+	return true, nil
+}
+
+// hasWatermarks returns true if any page refers to a watermark or stamp.
+func hasWatermarks(xRefTable *XRefTable) (bool, error) {
+
+	for i := 1; i <= xRefTable.PageCount; i++ {
+
+		_, inhPAttrs, err := xRefTable.PageDict(i)
+		if err != nil {
+			return false, err
+		}
+		if inhPAttrs.resources == nil {
+			continue
+		}
+
+		o, found := inhPAttrs.resources.Find("XObject")
+		if !found {
+			continue
+		}
+
+		d, err := xRefTable.DereferenceDict(o)
+		if err != nil {
+			return false, err
+		}
+
+		for k, o := range d {
+			if !wmXObjectRegExp.MatchString(k) {
+				continue
+			}
+			// Watermarks and stamps are optional content.
+			sd, err := xRefTable.DereferenceStreamDict(o)
+			if err != nil {
+				return false, err
+			}
+			if sd != nil && sd.IndirectRefEntry("OC") != nil {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// removeWatermarkOCG removes the optional content group of watermarks and stamps from the document catalog,
+// see prepareOCPropertiesInRoot.
+func removeWatermarkOCG(xRefTable *XRefTable) error {
+
+	rootDict, err := xRefTable.Catalog()
+	if err != nil {
+		return err
+	}
+
+	o, found := rootDict.Find("OCProperties")
+	if !found {
+		return nil
+	}
+
+	d, err := xRefTable.DereferenceDict(o)
+	if err != nil || d == nil {
+		return err
+	}
+
+	a, err := xRefTable.DereferenceArray(d["OCGs"])
+	if err != nil || len(a) != 1 {
+		return err
+	}
+
+	ocg, err := xRefTable.DereferenceDict(a[0])
+	if err != nil || ocg == nil {
+		return err
+	}
+
+	if s := ocg.StringEntry("Name"); s == nil || (*s != "Watermark" && *s != "Background") {
+		return nil
+	}
+
+	rootDict.Delete("OCProperties")
+
 	return nil
 }
+
+// RemoveWatermarks removes all watermarks and stamps from the pages selected
+// restoring the content as it was before applying them.
+// Watermarks and stamps are detected by their page resource names, see AddWatermarks.
+func RemoveWatermarks(ctx *Context, selectedPages IntSet) error {
+
+	log.Debug.Printf("RemoveWatermarks begin: %v\n", selectedPages)
+
+	xRefTable := ctx.XRefTable
+
+	var found bool
+	objs := map[int]*wmResourceNames{}
+
+	for _, k := range SortedSelectedPages(selectedPages) {
+		ok, err := removeWatermarksFromPage(xRefTable, k, objs)
+		if err != nil {
+			return err
+		}
+		found = found || ok
+	}
+
+	if !found {
+		return errors.New("RemoveWatermarks: no watermarks found")
+	}
+
+	ok, err := hasWatermarks(xRefTable)
+	if err != nil || ok {
+		return err
+	}
+
+	// Allow for new watermarks or stamps.
+	return removeWatermarkOCG(xRefTable)
+}
@@ -47,6 +47,7 @@ const (
 	RootRequirements
 	RootCollection
 	RootNeedsRendering
+	RootAF
 )
 
 // The PDF page object fields.
@@ -79,6 +80,7 @@ const (
 	PagePresSteps
 	PageUserUnit
 	PageVP
+	PageAF
 )
 
 // PDFStats is a container for stats.
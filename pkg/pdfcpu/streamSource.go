@@ -0,0 +1,305 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"compress/zlib"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"io/ioutil"
+
+	"github.com/jplu/pdfcpu/pkg/filter"
+	"github.com/jplu/pdfcpu/pkg/log"
+	"github.com/pkg/errors"
+)
+
+// Chunk size used for streaming content into the output file.
+const streamChunkSize = 1 << 16
+
+// A streamSource provides the decoded content of a stream dict at write time.
+//
+// The stream length and the optional Size and CheckSum parameters are unknown until the content has been streamed,
+// so the stream dict refers to them as indirect objects which get fixed up right after the stream has been written.
+type streamSource struct {
+	r         io.Reader
+	level     int // zlib compression level, Flate only.
+	lengthRef IndirectRef
+	sizeRef   *IndirectRef // Params Size
+	sumRef    *IndirectRef // Params CheckSum
+	consumed  bool
+}
+
+// A lazyFileReader opens its file on the first read and closes it on EOF or Close.
+type lazyFileReader struct {
+	fsys     FileSystem
+	fileName string
+	f        fs.File
+}
+
+func (lr *lazyFileReader) Read(p []byte) (int, error) {
+
+	if lr.f == nil {
+		f, err := lr.fsys.Open(lr.fileName)
+		if err != nil {
+			return 0, err
+		}
+		lr.f = f
+	}
+
+	n, err := lr.f.Read(p)
+	if err == io.EOF {
+		lr.Close()
+	}
+
+	return n, err
+}
+
+// Close closes the file if it is open.
+func (lr *lazyFileReader) Close() error {
+
+	if lr.f == nil {
+		return nil
+	}
+
+	err := lr.f.Close()
+	lr.f = nil
+
+	return err
+}
+
+// close releases any file opened for src once consumed, whether streaming succeeded or not.
+// Readers passed in by the caller are left alone.
+func (src *streamSource) close() {
+
+	if lr, ok := src.r.(*lazyFileReader); ok {
+		if err := lr.Close(); err != nil {
+			log.Info.Printf("streamSource: %v\n", err)
+		}
+	}
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// newStreamedStreamDict returns a stream dict whose content gets read from r and streamed at write time.
+// level is the zlib compression level to be used, or zlib.NoCompression for storing the content unfiltered.
+func (xRefTable *XRefTable) newStreamedStreamDict(r io.Reader, level int) (*StreamDict, error) {
+
+	lengthRef, err := xRefTable.IndRefForNewObject(Integer(0))
+	if err != nil {
+		return nil, err
+	}
+
+	sd := StreamDict{
+		Dict:   NewDict(),
+		source: &streamSource{r: r, level: level, lengthRef: *lengthRef},
+	}
+
+	sd.Insert("Length", *lengthRef)
+
+	if level != zlib.NoCompression {
+		sd.FilterPipeline = []PDFFilter{{Name: filter.Flate, DecodeParms: nil}}
+		sd.InsertName("Filter", filter.Flate)
+	}
+
+	return &sd, nil
+}
+
+// withStreamedParams adds a Params dict whose Size and CheckSum get recorded while streaming.
+func (xRefTable *XRefTable) withStreamedParams(sd *StreamDict, d Dict) error {
+
+	sizeRef, err := xRefTable.IndRefForNewObject(Integer(0))
+	if err != nil {
+		return err
+	}
+
+	sumRef, err := xRefTable.IndRefForNewObject(HexLiteral(""))
+	if err != nil {
+		return err
+	}
+
+	d.Insert("Size", *sizeRef)
+	d.Insert("CheckSum", *sumRef)
+
+	sd.source.sizeRef = sizeRef
+	sd.source.sumRef = sumRef
+
+	sd.Insert("Params", d)
+
+	return nil
+}
+
+func (xRefTable *XRefTable) updateObject(ir *IndirectRef, o Object) {
+
+	if ir == nil {
+		return
+	}
+
+	if entry, found := xRefTable.FindTableEntryLight(ir.ObjectNumber.Value()); found {
+		entry.Object = o
+	}
+}
+
+// Fix up the indirect objects of a stream source once its content is known.
+func (xRefTable *XRefTable) updateStreamSourceObjects(src *streamSource, length, size int64, h hash.Hash) {
+
+	xRefTable.updateObject(&src.lengthRef, Integer(length))
+	xRefTable.updateObject(src.sizeRef, Integer(size))
+	xRefTable.updateObject(src.sumRef, HexLiteral(hex.EncodeToString(h.Sum(nil))))
+}
+
+// loadStreamSource reads the entire content of a stream source into memory and encodes it.
+// This is the fallback whenever streaming is not possible eg. for encrypted output.
+func loadStreamSource(xRefTable *XRefTable, sd *StreamDict) error {
+
+	src := sd.source
+
+	if src.consumed {
+		return errors.New("loadStreamSource: stream source already consumed")
+	}
+	src.consumed = true
+	defer src.close()
+
+	buf, err := ioutil.ReadAll(src.r)
+	if err != nil {
+		return err
+	}
+
+	h := md5.New()
+	h.Write(buf)
+
+	sd.Content = buf
+	sd.source = nil
+
+	if sd.FilterPipeline == nil {
+		err = encodeStream(sd)
+	} else {
+		err = encodeStreamFlate(sd, src.level)
+	}
+	if err != nil {
+		return err
+	}
+
+	xRefTable.updateStreamSourceObjects(src, *sd.StreamLength, int64(len(buf)), h)
+
+	return nil
+}
+
+// writeStreamedStreamDictObject writes a stream dict object reading its content in chunks from its stream source.
+func writeStreamedStreamDictObject(ctx *Context, objNumber, genNumber int, sd StreamDict) error {
+
+	log.Write.Printf("writeStreamedStreamDictObject begin: object #%d\n", objNumber)
+
+	src := sd.source
+
+	if src.consumed {
+		return errors.Errorf("writeStreamedStreamDictObject: stream source of object #%d already consumed", objNumber)
+	}
+	src.consumed = true
+	defer src.close()
+
+	var inObjStream bool
+
+	if ctx.Write.WriteToObjectStream == true {
+		inObjStream = true
+		ctx.Write.WriteToObjectStream = false
+	}
+
+	w := ctx.Write
+
+	w.SetWriteOffset(objNumber)
+
+	h, err := writeObjectHeader(w, objNumber, genNumber)
+	if err != nil {
+		return err
+	}
+
+	pdfString := sd.PDFString()
+	_, err = w.WriteString(pdfString)
+	if err != nil {
+		return err
+	}
+
+	b, err := w.WriteString(fmt.Sprintf("%sstream%s", w.Eol, w.Eol))
+	if err != nil {
+		return err
+	}
+
+	cw := &countingWriter{w: w.Writer}
+
+	var dst io.Writer = cw
+	var zw *zlib.Writer
+
+	if sd.FilterPipeline != nil {
+		zw, err = zlib.NewWriterLevel(cw, src.level)
+		if err != nil {
+			return err
+		}
+		dst = zw
+	}
+
+	sum := md5.New()
+
+	size, err := io.CopyBuffer(dst, io.TeeReader(src.r, sum), make([]byte, streamChunkSize))
+	if err != nil {
+		return errors.Wrapf(err, "writeStreamedStreamDictObject: object #%d", objNumber)
+	}
+
+	if zw != nil {
+		err = zw.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	e, err := w.WriteString("endstream")
+	if err != nil {
+		return err
+	}
+
+	t, err := writeObjectTrailer(w)
+	if err != nil {
+		return err
+	}
+
+	// Length, Size and CheckSum get written as indirect objects after this stream.
+	ctx.updateStreamSourceObjects(src, cw.n, size, sum)
+
+	w.Offset += int64(h+len(pdfString)+b+e+t) + cw.n
+	w.BinaryTotalSize += cw.n
+
+	if inObjStream {
+		ctx.Write.WriteToObjectStream = true
+	}
+
+	log.Write.Printf("writeStreamedStreamDictObject end: object #%d streamed %d bytes\n", objNumber, cw.n)
+
+	return nil
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"io/fs"
+	"testing"
+)
+
+// openFilesFS counts the files of a MemFileSystem currently open for reading.
+type openFilesFS struct {
+	*MemFileSystem
+	open int
+}
+
+type countedFile struct {
+	fs.File
+	fsys *openFilesFS
+}
+
+func (f countedFile) Close() error {
+	f.fsys.open--
+	return f.File.Close()
+}
+
+func (fsys *openFilesFS) Open(name string) (fs.File, error) {
+	f, err := fsys.MemFileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	fsys.open++
+	return countedFile{f, fsys}, nil
+}
+
+func TestLazyFileReaderClose(t *testing.T) {
+
+	fsys := &openFilesFS{MemFileSystem: NewMemFileSystem()}
+	if err := WriteFile(fsys, "attachment.txt", []byte("streamed attachment"), 0644); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	src := &streamSource{r: &lazyFileReader{fsys: fsys, fileName: "attachment.txt"}}
+
+	// Streaming got interrupted after the first chunk.
+	if _, err := src.r.Read(make([]byte, 4)); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if fsys.open != 1 {
+		t.Fatalf("want 1 open file, got %d\n", fsys.open)
+	}
+
+	src.close()
+	src.close()
+
+	if fsys.open != 0 {
+		t.Fatalf("want no open files, got %d\n", fsys.open)
+	}
+}
@@ -40,6 +40,7 @@ type StreamDict struct {
 	Raw               []byte // Encoded
 	Content           []byte // Decoded
 	IsPageContent     bool
+	source            *streamSource // Decoded content streamed at write time.
 }
 
 // NewStreamDict creates a new PDFStreamDict for given PDFDict, stream offset and length.
@@ -53,6 +54,7 @@ func NewStreamDict(d Dict, streamOffset int64, streamLength *int64, streamLength
 		nil,
 		nil,
 		false,
+		nil,
 	}
 }
 
@@ -18,6 +18,8 @@ package pdfcpu
 
 import (
 	"bytes"
+	"encoding/hex"
+	"fmt"
 	"math"
 	"strings"
 
@@ -118,6 +120,13 @@ func Unescape(s string) ([]byte, error) {
 			}
 		}
 
+		// Octal codes may have 1 or 2 digits only.
+		if len(octalCode) > 0 && !strings.ContainsRune("01234567", rune(c)) {
+			b.WriteByte(byteForOctalString(octalCode))
+			octalCode = nil
+			esc = false
+		}
+
 		if regularChar(c, esc) {
 			b.WriteByte(c)
 			continue
@@ -127,9 +136,6 @@ func Unescape(s string) ([]byte, error) {
 			if !esc { // Start escape sequence.
 				esc = true
 			} else { // Escaped \
-				if len(octalCode) > 0 {
-					return nil, errors.Errorf("Unescape: illegal \\ in octal code sequence detected %X", octalCode)
-				}
 				b.WriteByte(c)
 				esc = false
 			}
@@ -139,9 +145,6 @@ func Unescape(s string) ([]byte, error) {
 		// escaped = true && any other than \
 
 		if len(octalCode) > 0 {
-			if !strings.ContainsRune("01234567", rune(c)) {
-				return nil, errors.Errorf("Unescape: illegal octal sequence detected %X", octalCode)
-			}
 			octalCode = append(octalCode, c)
 			if len(octalCode) == 3 {
 				b.WriteByte(byteForOctalString(octalCode))
@@ -162,8 +165,11 @@ func Unescape(s string) ([]byte, error) {
 			continue
 		}
 
+		// The reverse solidus of an unknown escape sequence gets ignored, see 7.3.4.2.
 		if !strings.ContainsRune("nrtbf()01234567", rune(c)) {
-			return nil, errors.Errorf("Unescape: illegal escape sequence \\%c detected", c)
+			b.WriteByte(c)
+			esc = false
+			continue
 		}
 
 		var octal bool
@@ -177,5 +183,150 @@ func Unescape(s string) ([]byte, error) {
 		esc = false
 	}
 
+	if len(octalCode) > 0 {
+		b.WriteByte(byteForOctalString(octalCode))
+	}
+
 	return b.Bytes(), nil
 }
+
+// balanceStringLiteral returns the escaped string literal s safe for writing:
+// Unbalanced parentheses and a trailing reverse solidus get escaped,
+// carriage returns too because they would be read as line feeds, see 7.3.4.2.
+func balanceStringLiteral(s string) string {
+
+	b := make([]byte, 0, len(s))
+	var open []int
+
+	for i := 0; i < len(s); i++ {
+
+		c := s[i]
+
+		switch c {
+
+		case '\\':
+			if i == len(s)-1 {
+				b = append(b, '\\', '\\')
+				continue
+			}
+			b = append(b, c, s[i+1])
+			i++
+
+		case '(':
+			open = append(open, len(b))
+			b = append(b, c)
+
+		case ')':
+			if len(open) == 0 {
+				b = append(b, '\\', c)
+				continue
+			}
+			open = open[:len(open)-1]
+			b = append(b, c)
+
+		case 0x0D:
+			b = append(b, '\\', 'r')
+
+		default:
+			b = append(b, c)
+		}
+	}
+
+	// Escape unmatched opening parentheses back to front.
+	for i := len(open) - 1; i >= 0; i-- {
+		j := open[i]
+		b = append(b[:j], append([]byte{'\\'}, b[j:]...)...)
+	}
+
+	return string(b)
+}
+
+// regularNameChar returns true if c may appear unescaped within a name, see 7.3.5.
+func regularNameChar(c byte) bool {
+	return c > 0x20 && c < 0x7F && !strings.ContainsRune("()<>[]{}/%#", rune(c))
+}
+
+func isHexDigit(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// EncodeName returns s as name object value applying #-escapes to any characters not allowed within names.
+// Use this for building names from arbitrary text, eg. Name(EncodeName("Font Name")).
+func EncodeName(s string) string {
+
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if regularNameChar(c) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "#%02X", c)
+	}
+
+	return b.String()
+}
+
+// DecodeName resolves all #-escapes of the name object value s.
+func DecodeName(s string) (string, error) {
+
+	if !strings.Contains(s, "#") {
+		return s, nil
+	}
+
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+
+		c := s[i]
+		if c != '#' {
+			b.WriteByte(c)
+			continue
+		}
+
+		if i+2 >= len(s) || !isHexDigit(s[i+1]) || !isHexDigit(s[i+2]) {
+			return "", errors.Errorf("DecodeName: corrupt name: %s", s)
+		}
+
+		bb, err := hex.DecodeString(s[i+1 : i+3])
+		if err != nil {
+			return "", err
+		}
+		if bb[0] == 0 {
+			return "", errors.Errorf("DecodeName: name must not contain null: %s", s)
+		}
+
+		b.WriteByte(bb[0])
+		i += 2
+	}
+
+	return b.String(), nil
+}
+
+// normalizeName returns the name object value s safe for writing.
+// Valid #-escapes are retained, any other characters not allowed within names get escaped.
+func normalizeName(s string) string {
+
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+
+		c := s[i]
+
+		if c == '#' && i+2 < len(s) && isHexDigit(s[i+1]) && isHexDigit(s[i+2]) {
+			b.WriteString(s[i : i+3])
+			i += 2
+			continue
+		}
+
+		if regularNameChar(c) {
+			b.WriteByte(c)
+			continue
+		}
+
+		fmt.Fprintf(&b, "#%02X", c)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import "testing"
+
+func TestUnescape(t *testing.T) {
+
+	for s, want := range map[string]string{
+		`gopher\(go\)`:     "gopher(go)",
+		`\0a\101\7`:        "\x00aA\x07",
+		`\12\1234`:         "\nS4",
+		`go\pher`:          "gopher",
+		"go\\\x0d\x0apher": "gopher",
+	} {
+		b, err := Unescape(s)
+		if err != nil {
+			t.Fatalf("Unescape %q: %v\n", s, err)
+		}
+		if string(b) != want {
+			t.Errorf("Unescape %q: want %q, got %q\n", s, want, b)
+		}
+	}
+}
+
+func TestNameEncoding(t *testing.T) {
+
+	for _, s := range []string{"Name", "My Font", "A/B#C", "(x)<y>[z]{%}", "Grüße"} {
+		s1, err := DecodeName(EncodeName(s))
+		if err != nil {
+			t.Fatalf("DecodeName %q: %v\n", s, err)
+		}
+		if s1 != s {
+			t.Errorf("EncodeName %q: round trip returned %q\n", s, s1)
+		}
+	}
+
+	if _, err := DecodeName("A#2"); err == nil {
+		t.Error("DecodeName: incomplete escape should fail\n")
+	}
+}
+
+func TestWriteNamesAndStrings(t *testing.T) {
+
+	d := Dict(map[string]Object{
+		"My Key":   Name("A B/C#"),
+		"Escaped":  Name("A#20B"),
+		"Open":     StringLiteral("a(b"),
+		"Close":    StringLiteral(`x)y\`),
+		"Balanced": StringLiteral(`(a\)b)`),
+		"CR":       StringLiteral("c\rd"),
+		"Array":    Array{Name("x y"), StringLiteral("((")},
+	})
+
+	s := d.PDFString()
+
+	o, err := parseObject(&s)
+	if err != nil {
+		t.Fatalf("parse %s: %v\n", d.PDFString(), err)
+	}
+
+	d1, ok := o.(Dict)
+	if !ok || len(d1) != len(d) {
+		t.Fatalf("unexpected round trip: %s\n", d.PDFString())
+	}
+
+	names := map[string]string{}
+	for k, v := range d1 {
+		k1, err := DecodeName(k)
+		if err != nil {
+			t.Fatalf("%s: %v\n", k, err)
+		}
+		names[k1] = k
+		if n, ok := v.(Name); ok {
+			if names[k1], err = DecodeName(n.Value()); err != nil {
+				t.Fatalf("%s: %v\n", n, err)
+			}
+		}
+	}
+
+	if names["My Key"] != "A B/C#" || names["Escaped"] != "A B" {
+		t.Errorf("unexpected names: %v\n", names)
+	}
+
+	for k, want := range map[string]string{"Open": "a(b", "Close": `x)y\`, "Balanced": "(a)b)", "CR": "c\rd"} {
+		b, err := d1.StringEntryBytes(k)
+		if err != nil {
+			t.Fatalf("%s: %v\n", k, err)
+		}
+		if string(b) != want {
+			t.Errorf("%s: want %q, got %q\n", k, want, b)
+		}
+	}
+
+	a := d1.ArrayEntry("Array")
+	if len(a) != 2 || a[0] != Name("x#20y") || a[1] != StringLiteral(`\(\(`) {
+		t.Errorf("unexpected array: %v\n", a)
+	}
+}
+
+func TestWriteFloats(t *testing.T) {
+
+	for _, tt := range []struct {
+		d    Dict
+		want string
+	}{
+		{Dict(map[string]Object{"C": Float(0.0352778)}), "<</C 0.04>>"},
+		{Dict(map[string]Object{"Type": Name("NumberFormat"), "C": Float(0.0352778)}), "<</C 0.03528/Type/NumberFormat>>"},
+		{Dict(map[string]Object{"Type": Name("NumberFormat"), "C": Float(2.5)}), "<</C 2.50/Type/NumberFormat>>"},
+	} {
+		if s := tt.d.PDFString(); s != tt.want {
+			t.Fatalf("want %s, got %s\n", tt.want, s)
+		}
+	}
+}
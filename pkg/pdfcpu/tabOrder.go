@@ -0,0 +1,151 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Tab orders for annotations of a page, see Table 30 "Tabs".
+const (
+	TabOrderRow       = "R" // Row order.
+	TabOrderColumn    = "C" // Column order.
+	TabOrderStructure = "S" // Structure order.
+	TabOrderWidget    = "W" // Widget order.
+)
+
+// SetTabOrder sets the tab order used for the annotations of the selected pages.
+// An empty order removes any tab order, leaving the choice to the viewer.
+func SetTabOrder(xRefTable *XRefTable, selectedPages IntSet, order string) error {
+
+	if order != "" && !MemberOf(order, []string{TabOrderRow, TabOrderColumn, TabOrderStructure, TabOrderWidget}) {
+		return errors.Errorf("SetTabOrder: invalid tab order %s, try one of R, C, S, W", order)
+	}
+
+	for _, pageNr := range SortedSelectedPages(selectedPages) {
+
+		pageDict, _, err := xRefTable.PageDict(pageNr)
+		if err != nil {
+			return err
+		}
+		if pageDict == nil {
+			return errors.Errorf("SetTabOrder: missing page %d", pageNr)
+		}
+
+		if order == "" {
+			pageDict.Delete("Tabs")
+			continue
+		}
+
+		pageDict.Update("Tabs", Name(order))
+	}
+
+	return nil
+}
+
+// CalculationOrder returns the fully qualified names of the fields in the calculation order "CO" of the interactive form.
+func CalculationOrder(xRefTable *XRefTable) ([]string, error) {
+
+	rootDict, err := xRefTable.Catalog()
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := xRefTable.DereferenceDict(rootDict["AcroForm"])
+	if err != nil || d == nil {
+		return nil, err
+	}
+
+	co, err := xRefTable.DereferenceArray(d["CO"])
+	if err != nil || co == nil {
+		return nil, err
+	}
+
+	irs, err := fieldIndRefs(xRefTable)
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[int]string{}
+	for name, ir := range irs {
+		names[ir.ObjectNumber.Value()] = name
+	}
+
+	var ss []string
+
+	for _, o := range co {
+		ir, ok := o.(IndirectRef)
+		if !ok {
+			continue
+		}
+		name, found := names[ir.ObjectNumber.Value()]
+		if !found {
+			xRefTable.Warn("calculationOrder", 0, ir.ObjectNumber.Value(), "ignoring object, not a field")
+			continue
+		}
+		ss = append(ss, name)
+	}
+
+	return ss, nil
+}
+
+// SetCalculationOrder sets the order in which the values of the fields named by their fully qualified names get recalculated.
+// An empty list removes the calculation order.
+func SetCalculationOrder(xRefTable *XRefTable, fieldNames []string) error {
+
+	rootDict, err := xRefTable.Catalog()
+	if err != nil {
+		return err
+	}
+
+	d, err := xRefTable.DereferenceDict(rootDict["AcroForm"])
+	if err != nil {
+		return err
+	}
+	if d == nil {
+		return errors.New("SetCalculationOrder: missing interactive form")
+	}
+
+	if len(fieldNames) == 0 {
+		d.Delete("CO")
+		return nil
+	}
+
+	irs, err := fieldIndRefs(xRefTable)
+	if err != nil {
+		return err
+	}
+
+	co := Array{}
+	seen := StringSet{}
+
+	for _, name := range fieldNames {
+		ir, found := irs[name]
+		if !found {
+			return errors.Errorf("SetCalculationOrder: unknown field %s", name)
+		}
+		if seen[name] {
+			return errors.Errorf("SetCalculationOrder: duplicate field %s", name)
+		}
+		seen[name] = true
+		co = append(co, ir)
+	}
+
+	d.Update("CO", co)
+
+	return nil
+}
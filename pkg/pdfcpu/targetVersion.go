@@ -0,0 +1,189 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The PDF versions introducing catalog entries, see Table 28.
+var rootEntryVersions = map[string]Version{
+	"PageLabels":     V13,
+	"StructTreeRoot": V13,
+	"SpiderInfo":     V13,
+	"Metadata":       V14,
+	"MarkInfo":       V14,
+	"Lang":           V14,
+	"OutputIntents":  V14,
+	"PieceInfo":      V14,
+	"Perms":          V15,
+	"Legal":          V15,
+	"OCProperties":   V15,
+	"Requirements":   V17,
+	"Collection":     V17,
+	"NeedsRendering": V17,
+}
+
+// The PDF versions introducing stream filters, see Table 6.
+var filterVersions = map[string]Version{
+	"JBIG2Decode": V14,
+	"JPXDecode":   V15,
+	"Crypt":       V15,
+}
+
+// targetVersion returns the PDF version to be written.
+func (ctx *Context) targetVersion() Version {
+	if ctx.TargetVersion == nil {
+		return V17
+	}
+	return *ctx.TargetVersion
+}
+
+// encryptionVersion returns the PDF version needed for the encryption in effect for writing.
+func encryptionVersion(ctx *Context) (string, Version) {
+
+	if ctx.Encrypt == nil || ctx.EncKey == nil || ctx.E == nil {
+		return "", V10
+	}
+
+	switch {
+	case ctx.E.V == 4 && ctx.AES4Streams:
+		return "AES encryption", V16
+	case ctx.E.V == 4:
+		return "crypt filters", V15
+	case ctx.E.R == 3:
+		return "RC4 encryption using a 128 bit key", V14
+	}
+
+	return "", V10
+}
+
+// streamVersion records the features used by sd along with the PDF versions introducing them.
+func streamVersion(sd StreamDict, features map[string]Version) {
+
+	for _, f := range sd.FilterPipeline {
+		if v, ok := filterVersions[f.Name]; ok {
+			features[f.Name+" filter"] = v
+		}
+	}
+
+	if st := sd.Subtype(); st != nil && *st == "Image" {
+		if _, found := sd.Find("SMask"); found {
+			features["soft masks"] = V14
+		}
+	}
+
+	if st := sd.Subtype(); st != nil && *st == "Form" {
+		if _, found := sd.Find("Group"); found {
+			features["transparency groups"] = V14
+		}
+	}
+}
+
+// versionBlockers returns the features of ctx not available in PDF version v.
+func versionBlockers(ctx *Context, v Version) []string {
+
+	features := map[string]Version{}
+
+	if s, ev := encryptionVersion(ctx); ev > v {
+		features[s] = ev
+	}
+
+	for k, ev := range rootEntryVersions {
+		if _, found := ctx.RootDict.Find(k); found {
+			features[k] = ev
+		}
+	}
+
+	for _, e := range ctx.Table {
+
+		if e == nil || e.Free {
+			continue
+		}
+
+		switch o := e.Object.(type) {
+
+		case StreamDict:
+			streamVersion(o, features)
+
+		case Dict:
+			if t := o.Type(); t != nil && *t == "Page" {
+				if _, found := o.Find("Group"); found {
+					features["transparency groups"] = V14
+				}
+			}
+		}
+	}
+
+	var ss []string
+	for f, fv := range features {
+		if fv > v {
+			ss = append(ss, fmt.Sprintf("%s (PDF %s)", f, fv))
+		}
+	}
+	sort.Strings(ss)
+
+	return ss
+}
+
+// dropPageEntry removes key from all page dicts of ctx.
+func dropPageEntry(ctx *Context, key string) {
+
+	for _, e := range ctx.Table {
+
+		if e == nil || e.Free {
+			continue
+		}
+
+		if d, ok := e.Object.(Dict); ok {
+			if t := d.Type(); t != nil && *t == "Page" {
+				d.Delete(key)
+			}
+		}
+	}
+}
+
+// prepareTargetVersion avoids features not available in the target version
+// and fails for any features that can't be avoided.
+func prepareTargetVersion(ctx *Context) error {
+
+	v := ctx.targetVersion()
+	if v == V17 {
+		return nil
+	}
+
+	// The header carries the target version, a catalog version would override it.
+	ctx.RootDict.Delete("Version")
+
+	if v < V15 {
+		// Object streams and xref streams are PDF 1.5 features.
+		ctx.WriteObjectStream = false
+		ctx.WriteXRefStream = false
+		ctx.WriteHybridXRef = false
+
+		// The tab order of annotations is a PDF 1.5 page entry and only a hint for viewers.
+		dropPageEntry(ctx, "Tabs")
+	}
+
+	if ss := versionBlockers(ctx, v); len(ss) > 0 {
+		return NewError(ErrTargetVersion, "write: can't write PDF %s because of: %s", v, strings.Join(ss, ", "))
+	}
+
+	return nil
+}
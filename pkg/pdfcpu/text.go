@@ -0,0 +1,876 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/jplu/pdfcpu/pkg/filter"
+	"github.com/jplu/pdfcpu/pkg/fonts/metrics"
+	"github.com/jplu/pdfcpu/pkg/log"
+	"github.com/jplu/pdfcpu/pkg/types"
+	"github.com/pkg/errors"
+)
+
+func (m matrix) transform(x, y float64) (float64, float64) {
+	return x*m[0][0] + y*m[1][0] + m[2][0], x*m[0][1] + y*m[1][1] + m[2][1]
+}
+
+func newMatrix(a, b, c, d, e, f float64) matrix {
+	return matrix{{a, b, 0}, {c, d, 0}, {e, f, 1}}
+}
+
+func translationMatrix(tx, ty float64) matrix {
+	return newMatrix(1, 0, 0, 1, tx, ty)
+}
+
+// textFont maps the character codes of a font to Unicode text and glyph widths.
+type textFont struct {
+	name         string
+	cid          bool // Type0 fonts use multi byte codes.
+	toUnicode    *toUnicodeCMap
+	encoding     string          // The base encoding of a simple font.
+	differences  map[byte]string // The encoding differences of a simple font.
+	firstChar    int
+	widths       []float64
+	missingWidth float64
+	cidWidths    map[int]float64
+	metricsFont  string  // A standard font providing widths for fonts lacking Widths.
+	scale        float64 // Glyph space to text space, 1/1000 except for Type 3 fonts.
+}
+
+// standardFontFamily returns the standard font providing metrics for the base font name.
+func standardFontFamily(name string) string {
+
+	for _, s := range []string{"Helvetica", "Arial", "Times", "Courier"} {
+		if !strings.HasPrefix(name, s) {
+			continue
+		}
+		switch s {
+		case "Arial":
+			s = "Helvetica"
+		case "Times":
+			s = "Times-Roman"
+		}
+		if supportedWatermarkFont(s) {
+			return s
+		}
+	}
+
+	return ""
+}
+
+func numberArray(xRefTable *XRefTable, o Object) ([]float64, error) {
+
+	a, err := xRefTable.DereferenceArray(o)
+	if err != nil {
+		return nil, err
+	}
+
+	ff := make([]float64, len(a))
+	for i, o := range a {
+		ff[i] = xRefTable.DereferenceNumber(o)
+	}
+
+	return ff, nil
+}
+
+// streamContent returns the decoded content of the stream o or nil for unsupported filters.
+func streamContent(xRefTable *XRefTable, o Object) ([]byte, error) {
+
+	sd, err := xRefTable.DereferenceStreamDict(o)
+	if err != nil || sd == nil {
+		return nil, err
+	}
+
+	// sd is a copy, decoding leaves the xRefTable untouched.
+	err = decodeStream(sd)
+	if err == filter.ErrUnsupportedFilter {
+		return nil, nil
+	}
+
+	return sd.Content, err
+}
+
+func (f *textFont) parseEncoding(xRefTable *XRefTable, o Object) error {
+
+	o, err := xRefTable.Dereference(o)
+	if err != nil || o == nil {
+		return err
+	}
+
+	switch o := o.(type) {
+
+	case Name:
+		f.encoding = o.Value()
+
+	case Dict:
+		if be := o.NameEntry("BaseEncoding"); be != nil {
+			f.encoding = *be
+		}
+		diffs, err := xRefTable.DereferenceArray(o["Differences"])
+		if err != nil {
+			return err
+		}
+		f.differences = map[byte]string{}
+		code := 0
+		for _, o := range diffs {
+			switch o := o.(type) {
+			case Integer:
+				code = o.Value()
+			case Name:
+				if t, ok := glyphText(o.Value()); ok && code >= 0 && code < 256 {
+					f.differences[byte(code)] = t
+				}
+				code++
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseCIDWidths parses the W array of a CIDFont, see 9.7.4.3 Glyph Metrics in CIDFonts.
+func (f *textFont) parseCIDWidths(xRefTable *XRefTable, o Object) error {
+
+	a, err := xRefTable.DereferenceArray(o)
+	if err != nil {
+		return err
+	}
+
+	f.cidWidths = map[int]float64{}
+
+	for i := 0; i+1 < len(a); {
+
+		c := int(xRefTable.DereferenceNumber(a[i]))
+
+		o, err := xRefTable.Dereference(a[i+1])
+		if err != nil {
+			return err
+		}
+
+		if ww, ok := o.(Array); ok {
+			for j, w := range ww {
+				f.cidWidths[c+j] = xRefTable.DereferenceNumber(w)
+			}
+			i += 2
+			continue
+		}
+
+		if i+2 >= len(a) {
+			break
+		}
+
+		last := int(xRefTable.DereferenceNumber(a[i+1]))
+		w := xRefTable.DereferenceNumber(a[i+2])
+		for c1 := c; c1 <= last && c1-c < 0x10000; c1++ {
+			f.cidWidths[c1] = w
+		}
+		i += 3
+	}
+
+	return nil
+}
+
+func newTextFont(xRefTable *XRefTable, d Dict) (*textFont, error) {
+
+	f := &textFont{scale: 0.001}
+
+	if bf := d.NameEntry("BaseFont"); bf != nil {
+		f.name = *bf
+		// Strip a subset tag.
+		if i := strings.Index(f.name, "+"); i == 6 {
+			f.name = f.name[7:]
+		}
+	}
+
+	if _, found := d.Find("ToUnicode"); found {
+		b, err := streamContent(xRefTable, d["ToUnicode"])
+		if err != nil {
+			return nil, err
+		}
+		if b != nil {
+			if f.toUnicode, err = parseToUnicodeCMap(b); err != nil {
+				xRefTable.Warn("text", 0, 0, "%s: ignoring corrupt ToUnicode cmap: %v", f.name, err)
+			}
+		}
+	}
+
+	subtype := ""
+	if st := d.Subtype(); st != nil {
+		subtype = *st
+	}
+
+	if subtype == "Type0" {
+
+		f.cid = true
+		f.missingWidth = 1000
+
+		a, err := xRefTable.DereferenceArray(d["DescendantFonts"])
+		if err != nil || len(a) == 0 {
+			return f, err
+		}
+
+		df, err := xRefTable.DereferenceDict(a[0])
+		if err != nil || df == nil {
+			return f, err
+		}
+
+		if dw := df["DW"]; dw != nil {
+			f.missingWidth = xRefTable.DereferenceNumber(dw)
+		}
+
+		return f, f.parseCIDWidths(xRefTable, df["W"])
+	}
+
+	f.encoding = "StandardEncoding"
+	if subtype == "TrueType" {
+		f.encoding = "WinAnsiEncoding"
+	}
+	if err := f.parseEncoding(xRefTable, d["Encoding"]); err != nil {
+		return nil, err
+	}
+
+	if subtype == "Type3" {
+		if fm, err := numberArray(xRefTable, d["FontMatrix"]); err == nil && len(fm) == 6 {
+			f.scale = fm[0]
+		}
+	}
+
+	if i := d.IntEntry("FirstChar"); i != nil {
+		f.firstChar = *i
+	}
+
+	var err error
+	if f.widths, err = numberArray(xRefTable, d["Widths"]); err != nil {
+		return nil, err
+	}
+
+	fd, err := xRefTable.DereferenceDict(d["FontDescriptor"])
+	if err != nil {
+		return nil, err
+	}
+	if fd != nil && fd["MissingWidth"] != nil {
+		f.missingWidth = xRefTable.DereferenceNumber(fd["MissingWidth"])
+	}
+
+	if len(f.widths) == 0 {
+		f.metricsFont = standardFontFamily(f.name)
+		if f.missingWidth == 0 {
+			f.missingWidth = 500
+		}
+	}
+
+	return f, nil
+}
+
+// codeLength returns the length of the character code at the beginning of b.
+func (f *textFont) codeLength(b []byte) int {
+
+	n := 1
+	if f.cid {
+		n = 2
+	}
+
+	if f.toUnicode != nil && len(f.toUnicode.codeSpaces) > 0 {
+		if l := f.toUnicode.codeLength(b); l > 0 {
+			n = l
+		}
+	}
+
+	if n > len(b) {
+		n = len(b)
+	}
+
+	return n
+}
+
+// text returns the Unicode text of a character code.
+func (f *textFont) text(code []byte) string {
+
+	if f.toUnicode != nil {
+		if s, ok := f.toUnicode.m[string(code)]; ok {
+			return s
+		}
+	}
+
+	if f.cid {
+		// Without a ToUnicode CMap there is no generally applicable way to map CIDs to Unicode.
+		return ""
+	}
+
+	c := code[0]
+
+	if s, ok := f.differences[c]; ok {
+		return s
+	}
+
+	r := encodingRune(f.encoding, c)
+	if r < 0x20 {
+		return ""
+	}
+
+	return string(r)
+}
+
+// width returns the horizontal displacement of the glyph for a character code in text space units.
+func (f *textFont) width(code []byte) float64 {
+
+	c := 0
+	for _, b := range code {
+		c = c<<8 | int(b)
+	}
+
+	if f.cid {
+		if w, ok := f.cidWidths[c]; ok {
+			return w * f.scale
+		}
+		return f.missingWidth * f.scale
+	}
+
+	if i := c - f.firstChar; i >= 0 && i < len(f.widths) {
+		return f.widths[i] * f.scale
+	}
+
+	if f.metricsFont != "" {
+		return float64(metrics.CharWidth(f.metricsFont, c)) * f.scale
+	}
+
+	return f.missingWidth * f.scale
+}
+
+// textGlyph is a glyph shown on a page.
+type textGlyph struct {
+	text   string
+	x, y   float64         // The origin on the baseline in default user space.
+	adv    float64         // The horizontal advance in default user space.
+	bounds types.Rectangle // The glyph box in default user space.
+	font   string          // The base font name.
+	size   float64         // The font size in default user space.
+}
+
+// textGraphicsState is the part of the graphics state relevant to text extraction.
+type textGraphicsState struct {
+	ctm        matrix
+	font       *textFont
+	fontSize   float64
+	charSpace  float64
+	wordSpace  float64
+	hScale     float64
+	leading    float64
+	rise       float64
+	renderMode int
+}
+
+// textExtractor collects the glyphs shown by content streams, see 9.4 Text Objects.
+type textExtractor struct {
+	xRefTable *XRefTable
+	fonts     map[int]*textFont // Fonts by object number.
+	forms     IntSet            // Form XObjects being processed, guards against cycles.
+	glyphs    []textGlyph
+
+	// show gets called for each glyph shown except for blanks with trm mapping glyph space to default user space
+	// and w0 being the horizontal glyph displacement in glyph space.
+	show func(gs *textGraphicsState, trm matrix, w0 float64)
+}
+
+func newTextExtractor(xRefTable *XRefTable) *textExtractor {
+	return &textExtractor{xRefTable: xRefTable, fonts: map[int]*textFont{}, forms: IntSet{}}
+}
+
+func contentNumber(tok []byte) float64 {
+	f, _ := strconv.ParseFloat(string(tok), 64)
+	return f
+}
+
+// contentString returns the bytes of a string literal or hex string token.
+func contentString(tok []byte) ([]byte, bool) {
+
+	if len(tok) < 2 {
+		return nil, false
+	}
+
+	switch tok[0] {
+
+	case '(':
+		b, err := Unescape(string(tok[1 : len(tok)-1]))
+		if err != nil {
+			return nil, false
+		}
+		return b, true
+
+	case '<':
+		b, err := hexTokenBytes(tok)
+		if err != nil {
+			return nil, false
+		}
+		return b, true
+	}
+
+	return nil, false
+}
+
+func operandMatrix(operands [][]byte) (matrix, bool) {
+
+	if len(operands) < 6 {
+		return identMatrix, false
+	}
+
+	var f [6]float64
+	for i, tok := range operands[len(operands)-6:] {
+		f[i] = contentNumber(tok)
+	}
+
+	return newMatrix(f[0], f[1], f[2], f[3], f[4], f[5]), true
+}
+
+func (e *textExtractor) font(resources Dict, tok []byte) (*textFont, error) {
+
+	if len(tok) < 2 || tok[0] != '/' {
+		return nil, nil
+	}
+
+	fonts, err := e.xRefTable.DereferenceDict(resources["Font"])
+	if err != nil || fonts == nil {
+		return nil, err
+	}
+
+	o, found := fonts.Find(string(tok[1:]))
+	if !found {
+		log.Info.Printf("textExtractor: missing font resource %s\n", tok)
+		return nil, nil
+	}
+
+	ir, ok := o.(IndirectRef)
+	if ok {
+		if f, found := e.fonts[ir.ObjectNumber.Value()]; found {
+			return f, nil
+		}
+	}
+
+	d, err := e.xRefTable.DereferenceDict(o)
+	if err != nil || d == nil {
+		return nil, err
+	}
+
+	f, err := newTextFont(e.xRefTable, d)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok {
+		e.fonts[ir.ObjectNumber.Value()] = f
+	}
+
+	return f, nil
+}
+
+// showText adds the glyphs for the string b and advances the text matrix.
+func (e *textExtractor) showText(gs *textGraphicsState, tm *matrix, b []byte) {
+
+	f := gs.font
+	if f == nil {
+		return
+	}
+
+	th := gs.hScale / 100
+
+	for len(b) > 0 {
+
+		n := f.codeLength(b)
+		code := b[:n]
+		b = b[n:]
+
+		w0 := f.width(code)
+
+		trm := newMatrix(gs.fontSize*th, 0, 0, gs.fontSize, 0, gs.rise).multiply(*tm).multiply(gs.ctm)
+
+		s := f.text(code)
+
+		if e.show != nil && (s == "" || strings.TrimSpace(s) != "") {
+			e.show(gs, trm, w0)
+		}
+
+		if s != "" {
+
+			x0, y0, x1, y1 := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+			for _, p := range [][2]float64{{0, -0.2}, {0, 0.8}, {w0, -0.2}, {w0, 0.8}} {
+				x, y := trm.transform(p[0], p[1])
+				x0, y0, x1, y1 = math.Min(x0, x), math.Min(y0, y), math.Max(x1, x), math.Max(y1, y)
+			}
+
+			x, y := trm.transform(0, 0)
+			xa, ya := trm.transform(w0, 0)
+
+			e.glyphs = append(e.glyphs, textGlyph{
+				text:   s,
+				x:      x,
+				y:      y,
+				adv:    math.Hypot(xa-x, ya-y),
+				bounds: types.NewRectangle(x0, y0, x1, y1),
+				font:   f.name,
+				size:   math.Hypot(trm[1][0], trm[1][1]),
+			})
+		}
+
+		tx := (w0*gs.fontSize + gs.charSpace) * th
+		if n == 1 && code[0] == ' ' {
+			tx += gs.wordSpace * th
+		}
+
+		*tm = translationMatrix(tx, 0).multiply(*tm)
+	}
+}
+
+// showTextArray processes the operand of TJ.
+func (e *textExtractor) showTextArray(gs *textGraphicsState, tm *matrix, tok []byte) {
+
+	if len(tok) < 2 || tok[0] != '[' {
+		return
+	}
+
+	s := &contentScanner{b: tok[1 : len(tok)-1]}
+
+	for {
+
+		t, _, err := s.next()
+		if err != nil {
+			return
+		}
+
+		if b, ok := contentString(t); ok {
+			e.showText(gs, tm, b)
+			continue
+		}
+
+		tj := contentNumber(t)
+		*tm = translationMatrix(-tj/1000*gs.fontSize*gs.hScale/100, 0).multiply(*tm)
+	}
+}
+
+// doXObject processes the Form XObject named by tok.
+func (e *textExtractor) doXObject(resources Dict, tok []byte, gs textGraphicsState) error {
+
+	if len(tok) < 2 || tok[0] != '/' {
+		return nil
+	}
+
+	xObjects, err := e.xRefTable.DereferenceDict(resources["XObject"])
+	if err != nil || xObjects == nil {
+		return err
+	}
+
+	ir := xObjects.IndirectRefEntry(string(tok[1:]))
+	if ir == nil {
+		return nil
+	}
+
+	objNr := ir.ObjectNumber.Value()
+	if e.forms[objNr] {
+		return nil
+	}
+
+	sd, err := e.xRefTable.DereferenceStreamDict(*ir)
+	if err != nil || sd == nil {
+		return err
+	}
+
+	if st := sd.Subtype(); st == nil || *st != "Form" {
+		return nil
+	}
+
+	b, err := streamContent(e.xRefTable, *ir)
+	if err != nil || b == nil {
+		return err
+	}
+
+	res, err := e.xRefTable.DereferenceDict(sd.Dict["Resources"])
+	if err != nil {
+		return err
+	}
+	if res == nil {
+		res = resources
+	}
+
+	if m, err := numberArray(e.xRefTable, sd.Dict["Matrix"]); err == nil && len(m) == 6 {
+		gs.ctm = newMatrix(m[0], m[1], m[2], m[3], m[4], m[5]).multiply(gs.ctm)
+	}
+
+	e.forms[objNr] = true
+	defer delete(e.forms, objNr)
+
+	return e.process(b, res, gs)
+}
+
+// textOperator processes the text state and text showing operators, other operators are ignored.
+func (e *textExtractor) textOperator(op string, operands [][]byte, resources Dict, gs *textGraphicsState, tm, tlm *matrix) error {
+
+	var lastNumber float64
+	if len(operands) > 0 {
+		lastNumber = contentNumber(operands[len(operands)-1])
+	}
+
+	nextLine := func(tx, ty float64) {
+		*tlm = translationMatrix(tx, ty).multiply(*tlm)
+		*tm = *tlm
+	}
+
+	switch op {
+
+	case "BT":
+		*tm, *tlm = identMatrix, identMatrix
+
+	case "Tf":
+		if len(operands) >= 2 {
+			var err error
+			if gs.font, err = e.font(resources, operands[len(operands)-2]); err != nil {
+				return err
+			}
+			gs.fontSize = lastNumber
+		}
+
+	case "Tc":
+		gs.charSpace = lastNumber
+
+	case "Tw":
+		gs.wordSpace = lastNumber
+
+	case "Tz":
+		gs.hScale = lastNumber
+
+	case "TL":
+		gs.leading = lastNumber
+
+	case "Ts":
+		gs.rise = lastNumber
+
+	case "Tr":
+		gs.renderMode = int(lastNumber)
+
+	case "Td", "TD":
+		if len(operands) >= 2 {
+			tx := contentNumber(operands[len(operands)-2])
+			if op == "TD" {
+				gs.leading = -lastNumber
+			}
+			nextLine(tx, lastNumber)
+		}
+
+	case "Tm":
+		if m, ok := operandMatrix(operands); ok {
+			*tm, *tlm = m, m
+		}
+
+	case "T*":
+		nextLine(0, -gs.leading)
+
+	case "Tj", "'", "\"":
+		if len(operands) == 0 {
+			break
+		}
+		if op != "Tj" {
+			if op == "\"" && len(operands) >= 3 {
+				gs.wordSpace = contentNumber(operands[len(operands)-3])
+				gs.charSpace = contentNumber(operands[len(operands)-2])
+			}
+			nextLine(0, -gs.leading)
+		}
+		if b, ok := contentString(operands[len(operands)-1]); ok {
+			e.showText(gs, tm, b)
+		}
+
+	case "TJ":
+		if len(operands) > 0 {
+			e.showTextArray(gs, tm, operands[len(operands)-1])
+		}
+	}
+
+	return nil
+}
+
+// process interprets the text related operators of a decoded content stream.
+func (e *textExtractor) process(content []byte, resources Dict, gs textGraphicsState) error {
+
+	var stack []textGraphicsState
+	var operands [][]byte
+	tm, tlm := identMatrix, identMatrix
+
+	s := &contentScanner{b: content}
+
+	for {
+
+		tok, pos, err := s.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if !contentOperator(tok) {
+			operands = append(operands, tok)
+			continue
+		}
+
+		switch string(tok) {
+
+		case "BI":
+			if _, err := s.inlineImage(pos); err != nil {
+				return err
+			}
+
+		case "q":
+			stack = append(stack, gs)
+
+		case "Q":
+			if n := len(stack); n > 0 {
+				gs, stack = stack[n-1], stack[:n-1]
+			}
+
+		case "cm":
+			if m, ok := operandMatrix(operands); ok {
+				gs.ctm = m.multiply(gs.ctm)
+			}
+
+		case "Do":
+			if len(operands) > 0 {
+				if err := e.doXObject(resources, operands[len(operands)-1], gs); err != nil {
+					return err
+				}
+			}
+
+		default:
+			if err := e.textOperator(string(tok), operands, resources, &gs, &tm, &tlm); err != nil {
+				return err
+			}
+		}
+
+		operands = nil
+	}
+}
+
+// pageContent returns the concatenated decoded content streams of pageDict.
+func pageContent(xRefTable *XRefTable, pageDict Dict) ([]byte, error) {
+
+	o, err := xRefTable.Dereference(pageDict["Contents"])
+	if err != nil {
+		return nil, err
+	}
+
+	var a Array
+
+	switch o := o.(type) {
+	case StreamDict:
+		a = Array{o}
+	case Array:
+		a = o
+	}
+
+	var b bytes.Buffer
+
+	for _, o := range a {
+
+		bb, err := streamContent(xRefTable, o)
+		if err != nil {
+			return nil, err
+		}
+
+		// Content streams may be split at any token boundary.
+		b.Write(bb)
+		b.WriteByte('\n')
+	}
+
+	return b.Bytes(), nil
+}
+
+// pageGlyphs returns the glyphs shown on page pageNr in content stream order.
+func pageGlyphs(xRefTable *XRefTable, pageNr int) ([]textGlyph, error) {
+
+	pageDict, inhPAttrs, err := xRefTable.PageDict(pageNr)
+	if err != nil {
+		return nil, err
+	}
+	if pageDict == nil {
+		return nil, errors.Errorf("missing page %d", pageNr)
+	}
+
+	b, err := pageContent(xRefTable, pageDict)
+	if err != nil {
+		return nil, err
+	}
+
+	e := newTextExtractor(xRefTable)
+
+	gs := textGraphicsState{ctm: identMatrix, hScale: 100}
+
+	if err = e.process(b, inhPAttrs.resources, gs); err != nil {
+		// Return what we got so far.
+		xRefTable.Warn("text", pageNr, 0, "incomplete text: %v", err)
+	}
+
+	return e.glyphs, nil
+}
+
+// glyphsText returns the text of glyphs with spaces and line breaks inferred from their positions.
+func glyphsText(glyphs []textGlyph) string {
+
+	var sb strings.Builder
+
+	for i, g := range glyphs {
+
+		if i > 0 {
+
+			p := glyphs[i-1]
+
+			size := math.Min(p.size, g.size)
+			if size <= 0 {
+				size = math.Max(p.size, g.size)
+			}
+
+			gap := g.x - (p.x + p.adv)
+
+			switch {
+
+			case math.Abs(g.y-p.y) > size/2 || gap < -size*2:
+				sb.WriteByte('\n')
+
+			case gap > size*0.15 && !strings.HasSuffix(p.text, " ") && !strings.HasPrefix(g.text, " "):
+				sb.WriteByte(' ')
+			}
+		}
+
+		sb.WriteString(g.text)
+	}
+
+	return sb.String()
+}
+
+// PageText returns the text shown on page pageNr as UTF-8
+// with spaces and line breaks inferred from the glyph positions.
+func PageText(xRefTable *XRefTable, pageNr int) (string, error) {
+
+	glyphs, err := pageGlyphs(xRefTable, pageNr)
+	if err != nil {
+		return "", err
+	}
+
+	return glyphsText(glyphs), nil
+}
@@ -0,0 +1,365 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// winAnsiHigh maps the codes 0x80-0x9F of WinAnsiEncoding, all other codes map to Latin-1.
+// See D.2 Latin Character Set and Encodings
+var winAnsiHigh = [32]rune{
+	0x20AC, 0x2022, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021, 0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0x2022, 0x017D, 0x2022,
+	0x2022, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014, 0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0x2022, 0x017E, 0x0178,
+}
+
+// macRomanHigh maps the codes 0x80-0xFF of MacRomanEncoding.
+var macRomanHigh = []rune("ÄÅÇÉÑÖÜáàâäãåçéèêëíìîïñóòôöõúùûü" +
+	"†°¢£§•¶ß®©™´¨≠ÆØ∞±≤≥¥µ∂∑∏π∫ªºΩæø" +
+	"¿¡¬√ƒ≈∆«»… ÀÃÕŒœ–—“”‘’÷◊ÿŸ⁄€‹›ﬁﬂ" +
+	"‡·‚„‰ÂÊÁËÈÍÎÏÌÓÔÒÚÛÙıˆ˜¯˘˙˚¸˝˛ˇ")
+
+// standardDiffs maps the codes of StandardEncoding deviating from Latin-1.
+var standardDiffs = map[byte]rune{
+	0x27: '’', 0x60: '‘', 0xA4: '⁄', 0xA6: 'ƒ', 0xA8: '¤', 0xA9: '\'', 0xAA: '“', 0xAC: '‹', 0xAD: '›',
+	0xAE: 'ﬁ', 0xAF: 'ﬂ', 0xB1: '–', 0xB2: '†', 0xB3: '‡', 0xB4: '·', 0xB7: '•', 0xB8: '‚', 0xB9: '„',
+	0xBA: '”', 0xBC: '…', 0xBD: '‰', 0xC1: '`', 0xC2: '´', 0xC3: 'ˆ', 0xC4: '˜', 0xC5: '¯', 0xC6: '˘',
+	0xC7: '˙', 0xC8: '¨', 0xCA: '˚', 0xCB: '¸', 0xCD: '˝', 0xCE: '˛', 0xCF: 'ˇ', 0xD0: '—', 0xE1: 'Æ',
+	0xE3: 'ª', 0xE8: 'Ł', 0xE9: 'Ø', 0xEA: 'Œ', 0xEB: 'º', 0xF1: 'æ', 0xF5: 'ı', 0xF8: 'ł', 0xF9: 'ø',
+	0xFA: 'œ', 0xFB: 'ß',
+}
+
+// encodingRune returns the Unicode character for code c of the base encoding enc.
+func encodingRune(enc string, c byte) rune {
+
+	switch enc {
+
+	case "WinAnsiEncoding":
+		if c >= 0x80 && c <= 0x9F {
+			return winAnsiHigh[c-0x80]
+		}
+
+	case "MacRomanEncoding":
+		if c >= 0x80 {
+			return macRomanHigh[c-0x80]
+		}
+
+	case "StandardEncoding":
+		if r, ok := standardDiffs[c]; ok {
+			return r
+		}
+	}
+
+	return rune(c)
+}
+
+// glyphNames maps glyph names used by Differences arrays to Unicode, see the Adobe Glyph List.
+// Single letter names and uniXXXX names are handled by glyphText.
+var glyphNames = map[string]rune{
+	"space": ' ', "exclam": '!', "quotedbl": '"', "numbersign": '#', "dollar": '$', "percent": '%',
+	"ampersand": '&', "quotesingle": '\'', "quoteright": '’', "parenleft": '(', "parenright": ')',
+	"asterisk": '*', "plus": '+', "comma": ',', "hyphen": '-', "minus": '−', "period": '.', "slash": '/',
+	"zero": '0', "one": '1', "two": '2', "three": '3', "four": '4', "five": '5', "six": '6', "seven": '7',
+	"eight": '8', "nine": '9', "colon": ':', "semicolon": ';', "less": '<', "equal": '=', "greater": '>',
+	"question": '?', "at": '@', "bracketleft": '[', "backslash": '\\', "bracketright": ']',
+	"asciicircum": '^', "underscore": '_', "grave": '`', "quoteleft": '‘', "braceleft": '{', "bar": '|',
+	"braceright": '}', "asciitilde": '~', "exclamdown": '¡', "cent": '¢', "sterling": '£', "currency": '¤',
+	"yen": '¥', "brokenbar": '¦', "section": '§', "dieresis": '¨', "copyright": '©', "ordfeminine": 'ª',
+	"guillemotleft": '«', "logicalnot": '¬', "registered": '®', "macron": '¯', "degree": '°',
+	"plusminus": '±', "twosuperior": '²', "threesuperior": '³', "acute": '´', "mu": 'µ', "paragraph": '¶',
+	"periodcentered": '·', "cedilla": '¸', "onesuperior": '¹', "ordmasculine": 'º', "guillemotright": '»',
+	"onequarter": '¼', "onehalf": '½', "threequarters": '¾', "questiondown": '¿', "multiply": '×',
+	"divide": '÷', "Agrave": 'À', "Aacute": 'Á', "Acircumflex": 'Â', "Atilde": 'Ã', "Adieresis": 'Ä',
+	"Aring": 'Å', "AE": 'Æ', "Ccedilla": 'Ç', "Egrave": 'È', "Eacute": 'É', "Ecircumflex": 'Ê',
+	"Edieresis": 'Ë', "Igrave": 'Ì', "Iacute": 'Í', "Icircumflex": 'Î', "Idieresis": 'Ï', "Eth": 'Ð',
+	"Ntilde": 'Ñ', "Ograve": 'Ò', "Oacute": 'Ó', "Ocircumflex": 'Ô', "Otilde": 'Õ', "Odieresis": 'Ö',
+	"Oslash": 'Ø', "Ugrave": 'Ù', "Uacute": 'Ú', "Ucircumflex": 'Û', "Udieresis": 'Ü', "Yacute": 'Ý',
+	"Thorn": 'Þ', "germandbls": 'ß', "agrave": 'à', "aacute": 'á', "acircumflex": 'â', "atilde": 'ã',
+	"adieresis": 'ä', "aring": 'å', "ae": 'æ', "ccedilla": 'ç', "egrave": 'è', "eacute": 'é',
+	"ecircumflex": 'ê', "edieresis": 'ë', "igrave": 'ì', "iacute": 'í', "icircumflex": 'î',
+	"idieresis": 'ï', "eth": 'ð', "ntilde": 'ñ', "ograve": 'ò', "oacute": 'ó', "ocircumflex": 'ô',
+	"otilde": 'õ', "odieresis": 'ö', "oslash": 'ø', "ugrave": 'ù', "uacute": 'ú', "ucircumflex": 'û',
+	"udieresis": 'ü', "yacute": 'ý', "thorn": 'þ', "ydieresis": 'ÿ', "Ydieresis": 'Ÿ', "OE": 'Œ', "oe": 'œ',
+	"Scaron": 'Š', "scaron": 'š', "Zcaron": 'Ž', "zcaron": 'ž', "Lslash": 'Ł', "lslash": 'ł',
+	"dotlessi": 'ı', "florin": 'ƒ', "circumflex": 'ˆ', "tilde": '˜', "caron": 'ˇ', "breve": '˘',
+	"dotaccent": '˙', "ring": '˚', "ogonek": '˛', "hungarumlaut": '˝', "endash": '–', "emdash": '—',
+	"quotesinglbase": '‚', "quotedblleft": '“', "quotedblright": '”', "quotedblbase": '„', "dagger": '†',
+	"daggerdbl": '‡', "bullet": '•', "ellipsis": '…', "perthousand": '‰', "guilsinglleft": '‹',
+	"guilsinglright": '›', "fraction": '⁄', "Euro": '€', "trademark": '™', "fi": 'ﬁ', "fl": 'ﬂ',
+	"ff": 'ﬀ', "ffi": 'ﬃ', "ffl": 'ﬄ', "nbspace": '\u00a0', "sfthyphen": '\u00ad',
+}
+
+// glyphText returns the text for a glyph name.
+func glyphText(name string) (string, bool) {
+
+	// Strip variant suffixes like in a.sc or one.oldstyle
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		name = name[:i]
+	}
+
+	if r, ok := glyphNames[name]; ok {
+		return string(r), true
+	}
+
+	if len(name) == 1 && (name[0] >= 'A' && name[0] <= 'Z' || name[0] >= 'a' && name[0] <= 'z') {
+		return name, true
+	}
+
+	if strings.HasPrefix(name, "uni") && len(name) >= 7 && (len(name)-3)%4 == 0 {
+		var u16 []uint16
+		for i := 3; i < len(name); i += 4 {
+			v, err := strconv.ParseUint(name[i:i+4], 16, 16)
+			if err != nil {
+				return "", false
+			}
+			u16 = append(u16, uint16(v))
+		}
+		return string(utf16.Decode(u16)), true
+	}
+
+	if name[0] == 'u' && len(name) >= 5 && len(name) <= 7 {
+		v, err := strconv.ParseUint(name[1:], 16, 32)
+		if err == nil {
+			return string(rune(v)), true
+		}
+	}
+
+	// Ligatures like f_f_i
+	if strings.Contains(name, "_") {
+		var sb strings.Builder
+		for _, s := range strings.Split(name, "_") {
+			t, ok := glyphText(s)
+			if !ok {
+				return "", false
+			}
+			sb.WriteString(t)
+		}
+		return sb.String(), true
+	}
+
+	return "", false
+}
+
+// hexTokenBytes decodes a hex string token including its angle brackets.
+func hexTokenBytes(tok []byte) ([]byte, error) {
+
+	s := strings.Map(func(r rune) rune {
+		if r == '<' || r == '>' || contentWhitespace(byte(r)) {
+			return -1
+		}
+		return r
+	}, string(tok))
+
+	if len(s)%2 > 0 {
+		s += "0"
+	}
+
+	return hex.DecodeString(s)
+}
+
+// utf16BEText decodes UTF-16BE data without BOM.
+func utf16BEText(b []byte) string {
+
+	if len(b)%2 > 0 {
+		b = append(b, 0)
+	}
+
+	u16 := make([]uint16, len(b)/2)
+	for i := range u16 {
+		u16[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+	}
+
+	return string(utf16.Decode(u16))
+}
+
+type codeSpaceRange struct {
+	lo, hi []byte
+}
+
+// toUnicodeCMap maps character codes to Unicode text, see 9.10.3 ToUnicode CMaps.
+type toUnicodeCMap struct {
+	codeSpaces []codeSpaceRange
+	m          map[string]string // Keys are character codes.
+}
+
+// codeLength returns the length of the character code at the beginning of b.
+func (cm toUnicodeCMap) codeLength(b []byte) int {
+
+	min := 0
+
+	for _, cs := range cm.codeSpaces {
+
+		n := len(cs.lo)
+		if n == 0 || n != len(cs.hi) {
+			continue
+		}
+
+		if min == 0 || n < min {
+			min = n
+		}
+
+		if n > len(b) {
+			continue
+		}
+
+		match := true
+		for i := 0; i < n; i++ {
+			if b[i] < cs.lo[i] || b[i] > cs.hi[i] {
+				match = false
+				break
+			}
+		}
+
+		if match {
+			return n
+		}
+	}
+
+	return min
+}
+
+// mapRange maps the codes lo through hi starting at the Unicode value dst.
+func (cm *toUnicodeCMap) mapRange(lo, hi, dst []byte) {
+
+	if len(lo) != len(hi) || len(lo) == 0 || len(dst) == 0 {
+		return
+	}
+
+	code := append([]byte{}, lo...)
+
+	// Guard against bogus ranges.
+	for i := 0; i < 0x10000 && bytes.Compare(code, hi) <= 0; i++ {
+
+		cm.m[string(code)] = utf16BEText(dst)
+
+		dst = append([]byte{}, dst...)
+		dst[len(dst)-1]++
+		if dst[len(dst)-1] == 0 && len(dst) > 1 {
+			dst[len(dst)-2]++
+		}
+
+		if !incrementCode(code) {
+			break
+		}
+	}
+}
+
+// incrementCode increments code as a big endian number and returns false on overflow.
+func incrementCode(code []byte) bool {
+	for i := len(code) - 1; i >= 0; i-- {
+		code[i]++
+		if code[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// parseToUnicodeCMap parses the bfchar and bfrange mappings of a decoded ToUnicode CMap.
+func parseToUnicodeCMap(b []byte) (*toUnicodeCMap, error) {
+
+	cm := &toUnicodeCMap{m: map[string]string{}}
+	s := &contentScanner{b: b}
+
+	var operands [][]byte
+
+	for {
+
+		tok, _, err := s.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if !contentOperator(tok) {
+			operands = append(operands, tok)
+			continue
+		}
+
+		switch string(tok) {
+
+		case "endcodespacerange":
+			for i := 0; i+1 < len(operands); i += 2 {
+				lo, err1 := hexTokenBytes(operands[i])
+				hi, err2 := hexTokenBytes(operands[i+1])
+				if err1 == nil && err2 == nil {
+					cm.codeSpaces = append(cm.codeSpaces, codeSpaceRange{lo: lo, hi: hi})
+				}
+			}
+
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				code, err := hexTokenBytes(operands[i])
+				if err != nil {
+					continue
+				}
+				dst := operands[i+1]
+				if dst[0] == '/' {
+					if t, ok := glyphText(string(dst[1:])); ok {
+						cm.m[string(code)] = t
+					}
+					continue
+				}
+				if bb, err := hexTokenBytes(dst); err == nil {
+					cm.m[string(code)] = utf16BEText(bb)
+				}
+			}
+
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				lo, err1 := hexTokenBytes(operands[i])
+				hi, err2 := hexTokenBytes(operands[i+1])
+				if err1 != nil || err2 != nil {
+					continue
+				}
+				dst := operands[i+2]
+				if dst[0] != '[' {
+					if bb, err := hexTokenBytes(dst); err == nil {
+						cm.mapRange(lo, hi, bb)
+					}
+					continue
+				}
+				// An array of destinations, one per code.
+				as := &contentScanner{b: dst[1 : len(dst)-1]}
+				code := append([]byte{}, lo...)
+				for {
+					t, _, err := as.next()
+					if err != nil {
+						break
+					}
+					if bb, err := hexTokenBytes(t); err == nil {
+						cm.m[string(code)] = utf16BEText(bb)
+					}
+					if !incrementCode(code) || bytes.Compare(code, hi) > 0 {
+						break
+					}
+				}
+			}
+		}
+
+		operands = nil
+	}
+
+	return cm, nil
+}
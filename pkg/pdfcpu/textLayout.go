@@ -0,0 +1,437 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jplu/pdfcpu/pkg/types"
+)
+
+// The layout analysis below is a heuristic approximation meant for indexing.
+// Lines are built from glyphs, arranged into columns and grouped into headings, list items and paragraphs.
+
+// textLine is a line of text within a column.
+type textLine struct {
+	glyphs []textGlyph
+	text   string
+	bounds types.Rectangle
+	y      float64 // The baseline.
+	size   float64 // The dominant font size.
+	column int     // -1 for lines spanning several columns.
+}
+
+const (
+	blockParagraph = iota
+	blockHeading
+	blockListItem
+)
+
+// textBlock is a heading, list item or paragraph.
+type textBlock struct {
+	kind    int
+	level   int     // The heading level starting at 1.
+	ordered bool    // Numbered list item.
+	x       float64 // The left edge of the first line.
+	text    string
+}
+
+var listItemRE = regexp.MustCompile(`^(?:([\x{2022}\x{25E6}\x{25AA}\x{2013}*-])|(\d{1,3}[.)]|[a-zA-Z][.)]))\s+`)
+
+func newTextLine(glyphs []textGlyph) textLine {
+
+	l := textLine{glyphs: glyphs, y: glyphs[0].y, bounds: glyphs[0].bounds}
+
+	// The dominant font size is the one used by most glyphs.
+	sizes := map[float64]int{}
+
+	for _, g := range glyphs {
+		l.bounds.LL.X = math.Min(l.bounds.LL.X, g.bounds.LL.X)
+		l.bounds.LL.Y = math.Min(l.bounds.LL.Y, g.bounds.LL.Y)
+		l.bounds.UR.X = math.Max(l.bounds.UR.X, g.bounds.UR.X)
+		l.bounds.UR.Y = math.Max(l.bounds.UR.Y, g.bounds.UR.Y)
+		if strings.TrimSpace(g.text) != "" {
+			s := math.Round(g.size*2) / 2
+			sizes[s]++
+			if sizes[s] > sizes[l.size] || sizes[s] == sizes[l.size] && s > l.size {
+				l.size = s
+			}
+		}
+	}
+
+	l.text = strings.Join(strings.Fields(glyphsText(glyphs)), " ")
+
+	return l
+}
+
+// textLines breaks glyphs into lines.
+// A line also ends at wide horizontal gaps so that columns sharing baselines are kept apart.
+func textLines(glyphs []textGlyph) []textLine {
+
+	var (
+		lines []textLine
+		gg    []textGlyph
+	)
+
+	flush := func() {
+		if len(gg) > 0 {
+			if l := newTextLine(gg); l.text != "" {
+				lines = append(lines, l)
+			}
+		}
+		gg = nil
+	}
+
+	for i, g := range glyphs {
+
+		if i > 0 {
+			p := glyphs[i-1]
+			size := math.Max(math.Min(p.size, g.size), 1)
+			gap := g.x - (p.x + p.adv)
+			if math.Abs(g.y-p.y) > size/2 || gap < -size*2 || gap > size*3 {
+				flush()
+			}
+		}
+
+		gg = append(gg, g)
+	}
+
+	flush()
+
+	return lines
+}
+
+// textColumns assigns lines to columns separated by vertical gutters and returns the number of columns found.
+func textColumns(lines []textLine) int {
+
+	if len(lines) == 0 {
+		return 0
+	}
+
+	x0, x1 := lines[0].bounds.LL.X, lines[0].bounds.UR.X
+	for _, l := range lines {
+		x0, x1 = math.Min(x0, l.bounds.LL.X), math.Max(x1, l.bounds.UR.X)
+	}
+
+	// Lines covering most of the width like titles do not take part in column detection.
+	wide := func(l textLine) bool { return l.bounds.Width() > (x1-x0)*0.6 }
+
+	type interval struct{ x0, x1 float64 }
+
+	var ii []interval
+	for _, l := range lines {
+		if !wide(l) {
+			ii = append(ii, interval{l.bounds.LL.X, l.bounds.UR.X})
+		}
+	}
+
+	sort.Slice(ii, func(i, j int) bool { return ii[i].x0 < ii[j].x0 })
+
+	// Merge overlapping intervals, the remaining gaps are gutters.
+	var cols []interval
+	for _, in := range ii {
+		if n := len(cols); n > 0 && in.x0 <= cols[n-1].x1+10 {
+			cols[n-1].x1 = math.Max(cols[n-1].x1, in.x1)
+			continue
+		}
+		cols = append(cols, in)
+	}
+
+	if len(cols) < 2 {
+		for i := range lines {
+			lines[i].column = 0
+		}
+		return 1
+	}
+
+	for i, l := range lines {
+		lines[i].column = -1
+		if wide(l) {
+			continue
+		}
+		for j, c := range cols {
+			if l.bounds.LL.X >= c.x0 && l.bounds.LL.X <= c.x1 {
+				lines[i].column = j
+				break
+			}
+		}
+	}
+
+	return len(cols)
+}
+
+// readingOrder returns lines in reading order: column by column between lines spanning all columns.
+func readingOrder(lines []textLine, columns int) []textLine {
+
+	sorted := make([]textLine, len(lines))
+	copy(sorted, lines)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if math.Abs(a.y-b.y) > math.Min(a.size, b.size)/2 {
+			return a.y > b.y
+		}
+		return a.bounds.LL.X < b.bounds.LL.X
+	})
+
+	if columns < 2 {
+		return sorted
+	}
+
+	var ordered []textLine
+	cols := make([][]textLine, columns)
+
+	flush := func() {
+		for i, c := range cols {
+			ordered = append(ordered, c...)
+			cols[i] = nil
+		}
+	}
+
+	for _, l := range sorted {
+		if l.column < 0 {
+			flush()
+			ordered = append(ordered, l)
+			continue
+		}
+		cols[l.column] = append(cols[l.column], l)
+	}
+
+	flush()
+
+	return ordered
+}
+
+// bodySize returns the font size used for most of the text.
+func bodySize(lines []textLine) float64 {
+
+	var size float64
+
+	m := map[float64]int{}
+
+	for _, l := range lines {
+		m[l.size] += len(l.text)
+		if m[l.size] > m[size] || m[l.size] == m[size] && l.size < size {
+			size = l.size
+		}
+	}
+
+	return size
+}
+
+// headingLevels maps font sizes noticeably larger than body text to heading levels.
+func headingLevels(lines []textLine, body float64) map[float64]int {
+
+	var sizes []float64
+
+	m := map[float64]int{}
+
+	for _, l := range lines {
+		if l.size >= body*1.15 && m[l.size] == 0 {
+			m[l.size] = 1
+			sizes = append(sizes, l.size)
+		}
+	}
+
+	sort.Sort(sort.Reverse(sort.Float64Slice(sizes)))
+
+	for i, s := range sizes {
+		level := i + 1
+		if level > 6 {
+			level = 6
+		}
+		m[s] = level
+	}
+
+	return m
+}
+
+// textBlocks groups lines in reading order into headings, list items and paragraphs.
+func textBlocks(glyphs []textGlyph) []textBlock {
+
+	lines := textLines(glyphs)
+
+	lines = readingOrder(lines, textColumns(lines))
+
+	body := bodySize(lines)
+	levels := headingLevels(lines, body)
+
+	var (
+		bb   []textBlock
+		prev *textLine
+	)
+
+	for i := range lines {
+
+		l := &lines[i]
+
+		b := textBlock{kind: blockParagraph, x: l.bounds.LL.X, text: l.text}
+
+		if level, ok := levels[l.size]; ok {
+			b.kind, b.level = blockHeading, level
+		} else if sm := listItemRE.FindStringSubmatch(l.text); sm != nil {
+			b.kind, b.ordered = blockListItem, sm[2] != ""
+			if !b.ordered {
+				b.text = strings.TrimSpace(l.text[len(sm[0]):])
+			}
+		}
+
+		if prev != nil && len(bb) > 0 && b.kind != blockListItem {
+
+			last := &bb[len(bb)-1]
+
+			// Continue the previous block for lines of the same column, size and kind that are close enough.
+			near := prev.y-l.y > 0 && prev.y-l.y <= math.Max(prev.size, l.size)*1.8
+			same := prev.column == l.column && math.Abs(prev.size-l.size) < 1
+
+			if near && same && (last.kind == b.kind && last.level == b.level || last.kind == blockListItem && b.kind == blockParagraph && l.bounds.LL.X > last.x+1) {
+				last.text = joinLines(last.text, l.text)
+				prev = l
+				continue
+			}
+		}
+
+		bb = append(bb, b)
+		prev = l
+	}
+
+	return bb
+}
+
+// joinLines joins two lines of a block undoing hyphenation.
+func joinLines(s1, s2 string) string {
+	if strings.HasSuffix(s1, "-") && len(s1) > 1 && !strings.HasSuffix(s1, " -") {
+		return s1[:len(s1)-1] + s2
+	}
+	return s1 + " " + s2
+}
+
+func pageBlocks(xRefTable *XRefTable, pageNr int) ([]textBlock, error) {
+
+	if pageNr < 1 || pageNr > xRefTable.PageCount {
+		return nil, NewError(ErrPageOutOfRange, "invalid page number: %d", pageNr)
+	}
+
+	glyphs, err := pageGlyphs(xRefTable, pageNr)
+	if err != nil {
+		return nil, err
+	}
+
+	return textBlocks(glyphs), nil
+}
+
+// PageMarkdown returns the text of a page as Markdown using an approximate layout analysis.
+func PageMarkdown(xRefTable *XRefTable, pageNr int) (string, error) {
+
+	bb, err := pageBlocks(xRefTable, pageNr)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+
+	for i, b := range bb {
+
+		if i > 0 && !(b.kind == blockListItem && bb[i-1].kind == blockListItem) {
+			sb.WriteString("\n")
+		}
+
+		switch b.kind {
+
+		case blockHeading:
+			fmt.Fprintf(&sb, "%s %s\n", strings.Repeat("#", b.level), b.text)
+
+		case blockListItem:
+			if b.ordered {
+				fmt.Fprintf(&sb, "%s\n", b.text)
+				continue
+			}
+			fmt.Fprintf(&sb, "- %s\n", b.text)
+
+		default:
+			fmt.Fprintf(&sb, "%s\n", b.text)
+		}
+	}
+
+	return sb.String(), nil
+}
+
+// PageHTML returns the text of a page as HTML document using an approximate layout analysis.
+func PageHTML(xRefTable *XRefTable, pageNr int) (string, error) {
+
+	bb, err := pageBlocks(xRefTable, pageNr)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>Page %d</title>\n</head>\n<body>\n", pageNr)
+
+	list := ""
+
+	for _, b := range bb {
+
+		tag := ""
+		if b.kind == blockListItem {
+			tag = "ul"
+			if b.ordered {
+				tag = "ol"
+			}
+		}
+
+		if list != tag {
+			if list != "" {
+				fmt.Fprintf(&sb, "</%s>\n", list)
+			}
+			if tag != "" {
+				fmt.Fprintf(&sb, "<%s>\n", tag)
+			}
+			list = tag
+		}
+
+		s := html.EscapeString(b.text)
+
+		switch b.kind {
+
+		case blockHeading:
+			fmt.Fprintf(&sb, "<h%d>%s</h%d>\n", b.level, s, b.level)
+
+		case blockListItem:
+			if b.ordered {
+				s = html.EscapeString(strings.TrimSpace(b.text[len(listItemRE.FindString(b.text)):]))
+			}
+			fmt.Fprintf(&sb, "<li>%s</li>\n", s)
+
+		default:
+			fmt.Fprintf(&sb, "<p>%s</p>\n", s)
+		}
+	}
+
+	if list != "" {
+		fmt.Fprintf(&sb, "</%s>\n", list)
+	}
+
+	sb.WriteString("</body>\n</html>\n")
+
+	return sb.String(), nil
+}
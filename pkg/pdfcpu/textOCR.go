@@ -0,0 +1,298 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"math"
+	"strings"
+
+	"github.com/jplu/pdfcpu/pkg/types"
+	"github.com/pkg/errors"
+)
+
+// hOCR and ALTO expect image coordinates with the origin in the upper left corner.
+// Boxes are written in points relative to the crop box, ie. as pixels of a 72 dpi rendering.
+// Page rotation is not taken into account.
+
+// textWord is a word of a text line.
+type textWord struct {
+	text   string
+	bounds types.Rectangle
+}
+
+// words breaks l into words at spaces and at gaps between glyphs.
+func (l textLine) words() []textWord {
+
+	var (
+		ww []textWord
+		w  *textWord
+	)
+
+	for i, g := range l.glyphs {
+
+		if i > 0 && w != nil {
+			p := l.glyphs[i-1]
+			if g.x-(p.x+p.adv) > math.Max(math.Min(p.size, g.size), 1)*0.15 {
+				w = nil
+			}
+		}
+
+		for _, r := range g.text {
+
+			if r == ' ' || r == '\t' || r == '\u00a0' {
+				w = nil
+				continue
+			}
+
+			if w == nil {
+				ww = append(ww, textWord{bounds: g.bounds})
+				w = &ww[len(ww)-1]
+			}
+
+			w.text += string(r)
+			w.bounds.LL.X = math.Min(w.bounds.LL.X, g.bounds.LL.X)
+			w.bounds.LL.Y = math.Min(w.bounds.LL.Y, g.bounds.LL.Y)
+			w.bounds.UR.X = math.Max(w.bounds.UR.X, g.bounds.UR.X)
+			w.bounds.UR.Y = math.Max(w.bounds.UR.Y, g.bounds.UR.Y)
+		}
+	}
+
+	return ww
+}
+
+// textPage is the text layout of a page.
+type textPage struct {
+	nr     int
+	box    types.Rectangle // The crop box.
+	blocks [][]textLine    // Runs of lines in reading order belonging to the same column.
+}
+
+// imageBox returns the corners of r in image coordinates.
+func (tp textPage) imageBox(r types.Rectangle) (x0, y0, x1, y1 int) {
+	x0 = int(math.Floor(r.LL.X - tp.box.LL.X))
+	y0 = int(math.Floor(tp.box.UR.Y - r.UR.Y))
+	x1 = int(math.Ceil(r.UR.X - tp.box.LL.X))
+	y1 = int(math.Ceil(tp.box.UR.Y - r.LL.Y))
+	return
+}
+
+func pageTextLayout(xRefTable *XRefTable, pageNr int) (*textPage, error) {
+
+	if pageNr < 1 || pageNr > xRefTable.PageCount {
+		return nil, NewError(ErrPageOutOfRange, "invalid page number: %d", pageNr)
+	}
+
+	_, inhPAttrs, err := xRefTable.PageDict(pageNr)
+	if err != nil {
+		return nil, err
+	}
+
+	glyphs, err := pageGlyphs(xRefTable, pageNr)
+	if err != nil {
+		return nil, err
+	}
+
+	tp := &textPage{nr: pageNr, box: types.NewRectangle(0, 0, 612, 792)}
+	if cropBox := inhPAttrs.CropBox(); len(cropBox) == 4 {
+		tp.box = rect(xRefTable, cropBox)
+	}
+
+	lines := textLines(glyphs)
+	lines = readingOrder(lines, textColumns(lines))
+
+	for i, l := range lines {
+		if i == 0 || l.column != lines[i-1].column {
+			tp.blocks = append(tp.blocks, nil)
+		}
+		tp.blocks[len(tp.blocks)-1] = append(tp.blocks[len(tp.blocks)-1], l)
+	}
+
+	return tp, nil
+}
+
+func textPages(xRefTable *XRefTable, pageNrs []int) ([]*textPage, error) {
+
+	var tps []*textPage
+
+	for _, p := range pageNrs {
+		tp, err := pageTextLayout(xRefTable, p)
+		if err != nil {
+			return nil, err
+		}
+		tps = append(tps, tp)
+	}
+
+	return tps, nil
+}
+
+func blockBounds(lines []textLine) types.Rectangle {
+
+	r := lines[0].bounds
+
+	for _, l := range lines[1:] {
+		r.LL.X = math.Min(r.LL.X, l.bounds.LL.X)
+		r.LL.Y = math.Min(r.LL.Y, l.bounds.LL.Y)
+		r.UR.X = math.Max(r.UR.X, l.bounds.UR.X)
+		r.UR.Y = math.Max(r.UR.Y, l.bounds.UR.Y)
+	}
+
+	return r
+}
+
+// HOCR returns the text of pages with word and line boxes as hOCR document.
+func HOCR(xRefTable *XRefTable, pageNrs []int) ([]byte, error) {
+
+	tps, err := textPages(xRefTable, pageNrs)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Transitional//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd">
+<html xmlns="http://www.w3.org/1999/xhtml" xml:lang="en" lang="en">
+<head>
+<title></title>
+<meta http-equiv="Content-Type" content="text/html;charset=utf-8"/>
+<meta name="ocr-system" content="pdfcpu"/>
+<meta name="ocr-capabilities" content="ocr_page ocr_carea ocr_line ocrx_word"/>
+</head>
+<body>
+`)
+
+	bbox := func(tp *textPage, r types.Rectangle) string {
+		x0, y0, x1, y1 := tp.imageBox(r)
+		return fmt.Sprintf("bbox %d %d %d %d", x0, y0, x1, y1)
+	}
+
+	for _, tp := range tps {
+
+		fmt.Fprintf(&b, "<div class=\"ocr_page\" id=\"page_%d\" title=\"bbox 0 0 %d %d; ppageno %d\">\n",
+			tp.nr, int(math.Round(tp.box.Width())), int(math.Round(tp.box.Height())), tp.nr-1)
+
+		for i, block := range tp.blocks {
+
+			fmt.Fprintf(&b, "<div class=\"ocr_carea\" id=\"block_%d_%d\" title=\"%s\">\n", tp.nr, i+1, bbox(tp, blockBounds(block)))
+
+			for j, l := range block {
+
+				fmt.Fprintf(&b, "<span class=\"ocr_line\" id=\"line_%d_%d_%d\" title=\"%s\">", tp.nr, i+1, j+1, bbox(tp, l.bounds))
+
+				for k, w := range l.words() {
+					if k > 0 {
+						b.WriteByte(' ')
+					}
+					fmt.Fprintf(&b, "<span class=\"ocrx_word\" title=\"%s; x_fsize %d\">%s</span>", bbox(tp, w.bounds), int(math.Round(l.size)), html.EscapeString(w.text))
+				}
+
+				b.WriteString("</span>\n")
+			}
+
+			b.WriteString("</div>\n")
+		}
+
+		b.WriteString("</div>\n")
+	}
+
+	b.WriteString("</body>\n</html>\n")
+
+	return b.Bytes(), nil
+}
+
+// ALTO returns the text of pages with word and line boxes as ALTO document.
+func ALTO(xRefTable *XRefTable, pageNrs []int) ([]byte, error) {
+
+	tps, err := textPages(xRefTable, pageNrs)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<alto xmlns="http://www.loc.gov/standards/alto/ns-v3#" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="http://www.loc.gov/standards/alto/ns-v3# http://www.loc.gov/alto/v3/alto-3-1.xsd">
+<Description>
+<MeasurementUnit>pixel</MeasurementUnit>
+<sourceImageInformation><fileName></fileName></sourceImageInformation>
+</Description>
+<Layout>
+`)
+
+	pos := func(tp *textPage, r types.Rectangle) string {
+		x0, y0, x1, y1 := tp.imageBox(r)
+		return fmt.Sprintf(`HPOS="%d" VPOS="%d" WIDTH="%d" HEIGHT="%d"`, x0, y0, x1-x0, y1-y0)
+	}
+
+	for _, tp := range tps {
+
+		w, h := int(math.Round(tp.box.Width())), int(math.Round(tp.box.Height()))
+
+		fmt.Fprintf(&b, "<Page ID=\"page_%d\" PHYSICAL_IMG_NR=\"%d\" WIDTH=\"%d\" HEIGHT=\"%d\">\n", tp.nr, tp.nr, w, h)
+		fmt.Fprintf(&b, "<PrintSpace HPOS=\"0\" VPOS=\"0\" WIDTH=\"%d\" HEIGHT=\"%d\">\n", w, h)
+
+		for i, block := range tp.blocks {
+
+			fmt.Fprintf(&b, "<TextBlock ID=\"block_%d_%d\" %s>\n", tp.nr, i+1, pos(tp, blockBounds(block)))
+
+			for j, l := range block {
+
+				fmt.Fprintf(&b, "<TextLine ID=\"line_%d_%d_%d\" %s>", tp.nr, i+1, j+1, pos(tp, l.bounds))
+
+				ww := l.words()
+
+				for k, w := range ww {
+					if k > 0 {
+						x0, _, _, _ := tp.imageBox(w.bounds)
+						_, _, x1, _ := tp.imageBox(ww[k-1].bounds)
+						if x0 < x1 {
+							x0 = x1
+						}
+						fmt.Fprintf(&b, `<SP HPOS="%d" WIDTH="%d"/>`, x1, x0-x1)
+					}
+					fmt.Fprintf(&b, `<String CONTENT="%s" %s/>`, html.EscapeString(w.text), pos(tp, w.bounds))
+				}
+
+				b.WriteString("</TextLine>\n")
+			}
+
+			b.WriteString("</TextBlock>\n")
+		}
+
+		b.WriteString("</PrintSpace>\n</Page>\n")
+	}
+
+	b.WriteString("</Layout>\n</alto>\n")
+
+	return b.Bytes(), nil
+}
+
+// OCRText returns the text of pages with coordinates using format which is either "hocr" or "alto".
+func OCRText(xRefTable *XRefTable, pageNrs []int, format string) ([]byte, error) {
+
+	switch strings.ToLower(format) {
+	case "hocr":
+		return HOCR(xRefTable, pageNrs)
+	case "alto":
+		return ALTO(xRefTable, pageNrs)
+	}
+
+	return nil, errors.Errorf("unsupported format %q, use hocr or alto", format)
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+)
+
+// TextSpan is a run of text on a single line shown using the same font and font size.
+type TextSpan struct {
+	PageNr int `json:"page"`
+
+	// The bounding box llx lly urx ury in default user space.
+	Rect [4]float64 `json:"rect"`
+
+	Font string  `json:"font"`
+	Size float64 `json:"size"`
+	Text string  `json:"text"`
+}
+
+func newTextSpan(pageNr int, glyphs []textGlyph) (TextSpan, bool) {
+
+	ts := TextSpan{
+		PageNr: pageNr,
+		Font:   glyphs[0].font,
+		Size:   roundPt(glyphs[0].size),
+		Text:   strings.TrimSpace(glyphsText(glyphs)),
+	}
+
+	if ts.Text == "" {
+		return ts, false
+	}
+
+	first := true
+
+	// Leading and trailing blanks do not count.
+	for _, g := range glyphs {
+		if strings.TrimSpace(g.text) == "" {
+			continue
+		}
+		r := g.bounds
+		if first {
+			ts.Rect = [4]float64{r.LL.X, r.LL.Y, r.UR.X, r.UR.Y}
+			first = false
+			continue
+		}
+		ts.Rect[0] = math.Min(ts.Rect[0], r.LL.X)
+		ts.Rect[1] = math.Min(ts.Rect[1], r.LL.Y)
+		ts.Rect[2] = math.Max(ts.Rect[2], r.UR.X)
+		ts.Rect[3] = math.Max(ts.Rect[3], r.UR.Y)
+	}
+
+	for i, f := range ts.Rect {
+		ts.Rect[i] = roundPt(f)
+	}
+
+	return ts, true
+}
+
+// textSpans breaks glyphs into spans in content stream order.
+// A span ends where the font or font size changes, at line breaks and at wide horizontal gaps.
+func textSpans(pageNr int, glyphs []textGlyph) []TextSpan {
+
+	var (
+		spans []TextSpan
+		gg    []textGlyph
+	)
+
+	flush := func() {
+		if len(gg) > 0 {
+			if ts, ok := newTextSpan(pageNr, gg); ok {
+				spans = append(spans, ts)
+			}
+		}
+		gg = nil
+	}
+
+	for i, g := range glyphs {
+
+		if i > 0 {
+			p := glyphs[i-1]
+			size := math.Max(math.Min(p.size, g.size), 1)
+			gap := g.x - (p.x + p.adv)
+			if g.font != p.font || math.Abs(g.size-p.size) > 0.01 ||
+				math.Abs(g.y-p.y) > size/2 || gap < -size*2 || gap > size*3 {
+				flush()
+			}
+		}
+
+		gg = append(gg, g)
+	}
+
+	flush()
+
+	return spans
+}
+
+// PageTextSpans returns the text shown on page pageNr as spans carrying their position, font name and font size.
+func PageTextSpans(xRefTable *XRefTable, pageNr int) ([]TextSpan, error) {
+
+	glyphs, err := pageGlyphs(xRefTable, pageNr)
+	if err != nil {
+		return nil, err
+	}
+
+	return textSpans(pageNr, glyphs), nil
+}
+
+// PageTextSpansJSON returns the text spans of page pageNr as JSON.
+func PageTextSpansJSON(xRefTable *XRefTable, pageNr int) (string, error) {
+
+	spans, err := PageTextSpans(xRefTable, pageNr)
+	if err != nil {
+		return "", err
+	}
+
+	if spans == nil {
+		spans = []TextSpan{}
+	}
+
+	b, err := json.MarshalIndent(spans, "", "\t")
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
@@ -0,0 +1,250 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"github.com/jplu/pdfcpu/pkg/filter"
+	"github.com/jplu/pdfcpu/pkg/log"
+)
+
+// FlattenTransparency removes all transparency from the document for targets not supporting it, eg. PDF/A-1.
+//
+// Soft masks of 8 bit gray, RGB or CMYK images get merged into the image by compositing it against a white backdrop,
+// all other soft masks are dropped. Graphics states are reset to opaque normal blending
+// and transparency group attributes of pages and forms are removed.
+// Content overlapping semi transparent objects is not rasterized, so the result may differ visually.
+func FlattenTransparency(xRefTable *XRefTable) error {
+
+	log.Debug.Println("FlattenTransparency begin")
+
+	for objNr, entry := range xRefTable.Table {
+
+		if entry.Free {
+			continue
+		}
+
+		switch o := entry.Object.(type) {
+
+		case Dict:
+			if err := flattenTransparencyDict(xRefTable, o); err != nil {
+				return err
+			}
+
+		case StreamDict:
+			if err := flattenTransparencyDict(xRefTable, o.Dict); err != nil {
+				return err
+			}
+			if !isImageStreamDict(o) {
+				continue
+			}
+			if err := flattenImageSoftMask(xRefTable, objNr, &o); err != nil {
+				return err
+			}
+			entry.Object = o
+		}
+	}
+
+	log.Debug.Println("FlattenTransparency end")
+
+	return nil
+}
+
+func isImageStreamDict(sd StreamDict) bool {
+	st := sd.Subtype()
+	return st != nil && *st == "Image"
+}
+
+// flattenTransparencyDict removes transparency from graphics states referenced by the resources of d
+// and from d itself if d is a page, form or annotation dict.
+func flattenTransparencyDict(xRefTable *XRefTable, d Dict) error {
+
+	if g := d.DictEntry("Group"); g != nil {
+		if s := g.NameEntry("S"); s != nil && *s == "Transparency" {
+			d.Delete("Group")
+		}
+	}
+
+	if _, found := d.Find("Rect"); found {
+		// Annotations may define a constant opacity.
+		if _, found := d.Find("CA"); found {
+			d.Update("CA", Float(1))
+		}
+	}
+
+	resources, err := xRefTable.DereferenceDict(d["Resources"])
+	if err != nil || resources == nil {
+		return err
+	}
+
+	gStates, err := xRefTable.DereferenceDict(resources["ExtGState"])
+	if err != nil || gStates == nil {
+		return err
+	}
+
+	for _, o := range gStates {
+
+		gs, err := xRefTable.DereferenceDict(o)
+		if err != nil {
+			return err
+		}
+		if gs == nil {
+			continue
+		}
+
+		if _, found := gs.Find("SMask"); found {
+			gs.Update("SMask", Name("None"))
+		}
+		if _, found := gs.Find("BM"); found {
+			gs.Update("BM", Name("Normal"))
+		}
+		for _, k := range []string{"CA", "ca"} {
+			if _, found := gs.Find(k); found {
+				gs.Update(k, Float(1))
+			}
+		}
+	}
+
+	return nil
+}
+
+// flattenImageSoftMask merges the soft mask of an image into the image or removes it if that is not possible.
+func flattenImageSoftMask(xRefTable *XRefTable, objNr int, sd *StreamDict) error {
+
+	sd.Delete("SMaskInData")
+
+	o, found := sd.Find("SMask")
+	if !found {
+		return nil
+	}
+
+	sd.Delete("SMask")
+
+	smask, err := xRefTable.DereferenceStreamDict(o)
+	if err != nil || smask == nil {
+		return err
+	}
+
+	ok, err := mergeSoftMask(xRefTable, sd, smask)
+	if err != nil {
+		return err
+	}
+
+	if ok {
+		log.Debug.Printf("flattenImageSoftMask: obj#%d: merged soft mask\n", objNr)
+	} else {
+		log.Info.Printf("flattenImageSoftMask: obj#%d: dropped soft mask\n", objNr)
+	}
+
+	return nil
+}
+
+// imageComponents returns the number of color components of a device or ICC based color space
+// along with the component value for white, or 0 for any other color space.
+func imageComponents(xRefTable *XRefTable, o Object) (int, int) {
+
+	o, err := xRefTable.Dereference(o)
+	if err != nil {
+		return 0, 0
+	}
+
+	cs, ok := o.(Name)
+	if !ok {
+		a, ok := o.(Array)
+		if !ok || len(a) != 2 || a[0] != Name("ICCBased") {
+			return 0, 0
+		}
+		sd, err := xRefTable.DereferenceStreamDict(a[1])
+		if err != nil || sd == nil || sd.IntEntry("N") == nil {
+			return 0, 0
+		}
+		cs = map[int]Name{1: "DeviceGray", 3: "DeviceRGB", 4: "DeviceCMYK"}[*sd.IntEntry("N")]
+	}
+
+	switch cs {
+	case "DeviceGray":
+		return 1, 255
+	case "DeviceRGB":
+		return 3, 255
+	case "DeviceCMYK":
+		return 4, 0
+	}
+
+	return 0, 0
+}
+
+// mergeSoftMask composites the 8 bit gray, RGB or CMYK image sd with its soft mask against a white backdrop
+// and returns false if this is not possible.
+func mergeSoftMask(xRefTable *XRefTable, sd, smask *StreamDict) (bool, error) {
+
+	w, h := sd.IntEntry("Width"), sd.IntEntry("Height")
+	if w == nil || h == nil {
+		return false, nil
+	}
+
+	mw, mh := smask.IntEntry("Width"), smask.IntEntry("Height")
+	if mw == nil || mh == nil || *mw != *w || *mh != *h {
+		return false, nil
+	}
+
+	for _, d := range []Dict{sd.Dict, smask.Dict} {
+		if bpc := d.IntEntry("BitsPerComponent"); bpc == nil || *bpc != 8 {
+			return false, nil
+		}
+		if _, found := d.Find("Decode"); found {
+			return false, nil
+		}
+	}
+
+	comps, white := imageComponents(xRefTable, sd.Dict["ColorSpace"])
+	if comps == 0 {
+		return false, nil
+	}
+
+	img, mask := *sd, *smask
+
+	for _, s := range []*StreamDict{&img, &mask} {
+		err := decodeStream(s)
+		if err == filter.ErrUnsupportedFilter {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+
+	n := *w * *h
+	if len(img.Content) < n*comps || len(mask.Content) < n {
+		log.Info.Println("mergeSoftMask: corrupt image data")
+		return false, nil
+	}
+
+	b := make([]byte, n*comps)
+	for i := 0; i < n; i++ {
+		a := int(mask.Content[i])
+		for j := 0; j < comps; j++ {
+			c := int(img.Content[i*comps+j])
+			b[i*comps+j] = byte((c*a + white*(255-a) + 127) / 255)
+		}
+	}
+
+	sd.Content = b
+	sd.FilterPipeline = []PDFFilter{{Name: filter.Flate}}
+	sd.Update("Filter", Name(filter.Flate))
+	sd.Delete("DecodeParms")
+
+	return true, encodeStream(sd)
+}
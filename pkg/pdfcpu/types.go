@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"io"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -109,6 +110,21 @@ func (f Float) PDFString() string {
 	return f.String()
 }
 
+// preciseString returns a string representation for values that do not survive rounding to 2 decimals
+// like the conversion factors of number format dicts, using up to 5 decimals.
+func (f Float) preciseString() string {
+	s := f.String()
+	if v, err := strconv.ParseFloat(s, 64); err == nil && v == float64(f) {
+		return s
+	}
+	s = strconv.FormatFloat(float64(f), 'f', 5, 64)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	if s == "" || s == "-" || s == "-0" {
+		return "0"
+	}
+	return s
+}
+
 // Value returns a float64 value for this PDF object.
 func (f Float) Value() float64 {
 	return float64(f)
@@ -143,6 +159,7 @@ func NewRectangle(llx, lly, urx, ury float64) Array {
 ///////////////////////////////////////////////////////////////////////////////////
 
 // Name represents a PDF name object.
+// The value is the name as written including any #-escapes, see EncodeName and DecodeName.
 type Name string
 
 func (nameObject Name) String() string {
@@ -150,10 +167,11 @@ func (nameObject Name) String() string {
 }
 
 // PDFString returns a string representation as found in and written to a PDF file.
+// Characters not allowed within names get #-escaped.
 func (nameObject Name) PDFString() string {
 	s := " "
 	if len(nameObject) > 0 {
-		s = string(nameObject)
+		s = normalizeName(string(nameObject))
 	}
 	return fmt.Sprintf("/%s", s)
 }
@@ -166,10 +184,12 @@ func (nameObject Name) Value() string {
 ///////////////////////////////////////////////////////////////////////////////////
 
 // StringLiteral represents a PDF string literal object.
+// The value is the string as written including any escape sequences, see Escape and Unescape.
 type StringLiteral string
 
+// String returns the string literal with balanced parentheses.
 func (stringliteral StringLiteral) String() string {
-	return fmt.Sprintf("(%s)", string(stringliteral))
+	return fmt.Sprintf("(%s)", balanceStringLiteral(string(stringliteral)))
 }
 
 // PDFString returns a string representation as found in and written to a PDF file.
@@ -187,10 +207,68 @@ func DateString(t time.Time) string {
 
 	_, tz := t.Zone()
 
-	return fmt.Sprintf("D:%d%02d%02d%02d%02d%02d+%02d'%02d'",
+	sign := "+"
+	if tz < 0 {
+		sign = "-"
+		tz = -tz
+	}
+
+	return fmt.Sprintf("D:%d%02d%02d%02d%02d%02d%s%02d'%02d'",
 		t.Year(), t.Month(), t.Day(),
 		t.Hour(), t.Minute(), t.Second(),
-		tz/60/60, tz/60%60)
+		sign, tz/60/60, tz/60%60)
+}
+
+// DateTime parses the PDF date string s, see 7.9.4 Dates.
+func DateTime(s string) (time.Time, bool) {
+
+	s = strings.TrimPrefix(strings.TrimSpace(s), "D:")
+
+	// Year, month, day, hour, minute and second, all but the year being optional.
+	v := []int{0, 1, 1, 0, 0, 0}
+	lens := []int{4, 2, 2, 2, 2, 2}
+
+	i := 0
+	for j, l := range lens {
+		if i+l > len(s) || strings.IndexAny(s[i:i+1], "Z+-") == 0 {
+			if j == 0 {
+				return time.Time{}, false
+			}
+			break
+		}
+		n, err := strconv.Atoi(s[i : i+l])
+		if err != nil {
+			return time.Time{}, false
+		}
+		v[j] = n
+		i += l
+	}
+
+	loc := time.UTC
+
+	if i < len(s) && (s[i] == '+' || s[i] == '-') {
+		tz := strings.Replace(strings.TrimSuffix(s[i+1:], "'"), "'", "", 1)
+		if len(tz) < 2 {
+			return time.Time{}, false
+		}
+		h, err := strconv.Atoi(tz[:2])
+		if err != nil {
+			return time.Time{}, false
+		}
+		m := 0
+		if len(tz) >= 4 {
+			if m, err = strconv.Atoi(tz[2:4]); err != nil {
+				return time.Time{}, false
+			}
+		}
+		offset := h*3600 + m*60
+		if s[i] == '-' {
+			offset = -offset
+		}
+		loc = time.FixedZone("", offset)
+	}
+
+	return time.Date(v[0], time.Month(v[1]), v[2], v[3], v[4], v[5], 0, loc), true
 }
 
 ///////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,171 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Usage rights signatures live in the Perms dict of the catalog (see 12.8.4 Permissions).
+// UR3 enables Adobe Reader features, UR is its legacy predecessor (Acrobat 6).
+// Both invalidate as soon as a document gets rewritten and are therefore worth detecting.
+var usageRightsKeys = []string{"UR3", "UR"}
+
+// usageRightsSummary describes the rights granted by a usage rights signature dict.
+func usageRightsSummary(xRefTable *XRefTable, d Dict) (string, error) {
+
+	var ss []string
+
+	if f := d.NameEntry("Filter"); f != nil {
+		ss = append(ss, "Filter="+*f)
+	}
+
+	if sf := d.NameEntry("SubFilter"); sf != nil {
+		ss = append(ss, "SubFilter="+*sf)
+	}
+
+	o, err := xRefTable.Dereference(d["Reference"])
+	if err != nil {
+		return "", err
+	}
+
+	refs, _ := o.(Array)
+
+	for _, o := range refs {
+
+		sigRefDict, err := xRefTable.DereferenceDict(o)
+		if err != nil {
+			return "", err
+		}
+
+		if sigRefDict == nil {
+			continue
+		}
+
+		tp, err := xRefTable.DereferenceDict(sigRefDict["TransformParams"])
+		if err != nil {
+			return "", err
+		}
+
+		for _, k := range []string{"Document", "Annots", "Form", "Signature", "EF"} {
+
+			o, err := xRefTable.Dereference(tp[k])
+			if err != nil {
+				return "", err
+			}
+
+			a, ok := o.(Array)
+			if !ok {
+				continue
+			}
+
+			var rights []string
+			for _, o := range a {
+				if n, ok := o.(Name); ok {
+					rights = append(rights, n.Value())
+				}
+			}
+
+			ss = append(ss, fmt.Sprintf("%s=%s", k, strings.Join(rights, ",")))
+		}
+	}
+
+	return strings.Join(ss, " "), nil
+}
+
+// UsageRights returns a description of any usage rights signatures found in the catalog.
+func UsageRights(xRefTable *XRefTable) ([]string, error) {
+
+	rootDict, err := xRefTable.Catalog()
+	if err != nil {
+		return nil, err
+	}
+
+	permsDict, err := xRefTable.DereferenceDict(rootDict["Perms"])
+	if err != nil || permsDict == nil {
+		return nil, err
+	}
+
+	var list []string
+
+	for _, k := range usageRightsKeys {
+
+		d, err := xRefTable.DereferenceDict(permsDict[k])
+		if err != nil {
+			return nil, err
+		}
+
+		if d == nil {
+			continue
+		}
+
+		s, err := usageRightsSummary(xRefTable, d)
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, fmt.Sprintf("%s: %s", k, s))
+	}
+
+	// Report other permission handlers like DocMDP without touching them.
+	var others []string
+	for k := range permsDict {
+		if k != "UR3" && k != "UR" {
+			others = append(others, k)
+		}
+	}
+	sort.Strings(others)
+
+	for _, k := range others {
+		list = append(list, fmt.Sprintf("%s: present", k))
+	}
+
+	return list, nil
+}
+
+// RemoveUsageRights removes usage rights signatures from the catalog.
+// Other permission handlers like DocMDP are kept.
+// Returns true if anything was removed.
+func RemoveUsageRights(xRefTable *XRefTable) (bool, error) {
+
+	rootDict, err := xRefTable.Catalog()
+	if err != nil {
+		return false, err
+	}
+
+	permsDict, err := xRefTable.DereferenceDict(rootDict["Perms"])
+	if err != nil || permsDict == nil {
+		return false, err
+	}
+
+	var ok bool
+
+	for _, k := range usageRightsKeys {
+		if _, found := permsDict.Find(k); found {
+			permsDict.Delete(k)
+			ok = true
+		}
+	}
+
+	if len(permsDict) == 0 {
+		rootDict.Delete("Perms")
+	}
+
+	return ok, nil
+}
@@ -44,7 +44,8 @@ func IsStringUTF16BE(s string) bool {
 // IsUTF16BE checks for Big Endian byte order mark.
 func IsUTF16BE(b []byte) (ok bool, err error) {
 
-	if len(b) == 0 {
+	// Check BOM
+	if len(b) < 2 || b[0] != 0xFE || b[1] != 0xFF {
 		return false, nil
 	}
 
@@ -52,10 +53,17 @@ func IsUTF16BE(b []byte) (ok bool, err error) {
 		return false, errors.Errorf("DecodeUTF16String: UTF16 needs even number of bytes: %v\n", b)
 	}
 
-	// Check BOM
-	ok = b[0] == 0xFE && b[1] == 0xFF
+	return true, nil
+}
+
+// isUTF16LE checks for Little Endian byte order mark.
+func isUTF16LE(b []byte) bool {
+	return len(b) >= 2 && len(b)%2 == 0 && b[0] == 0xFF && b[1] == 0xFE
+}
 
-	return ok, nil
+// isUTF8 checks for an UTF-8 byte order mark.
+func isUTF8(b []byte) bool {
+	return len(b) >= 3 && b[0] == 0xEF && b[1] == 0xBB && b[2] == 0xBF
 }
 
 func decodeUTF16String(b []byte) (s string, err error) {
@@ -87,7 +95,7 @@ func decodeUTF16String(b []byte) (s string, err error) {
 
 		val := (uint16(b[i]) << 8) + uint16(b[i+1])
 
-		if val <= 0xD7FF || val > 0xE000 && val <= 0xFFFF {
+		if val <= 0xD7FF || val >= 0xE000 {
 			// Basic Multilingual Plane
 			log.Debug.Println("decodeUTF16String: Basic Multilingual Plane detected")
 			u16 = append(u16, val)
@@ -96,7 +104,7 @@ func decodeUTF16String(b []byte) (s string, err error) {
 		}
 
 		// Ensure bytes needed in order to decode surrogate pair.
-		if i+2 >= len(b) {
+		if i+4 > len(b) {
 			err = errors.Errorf("decodeUTF16String: corrupt UTF16BE on unicode point 1: %v", b)
 			return
 		}
@@ -142,26 +150,49 @@ func DecodeUTF16String(s string) (string, error) {
 	return decodeUTF16String([]byte(s))
 }
 
-// StringLiteralToString returns the best possible string rep for a string literal.
-func StringLiteralToString(s string) (string, error) {
+// decodeText returns the UTF-8 representation of the text string bytes b, see 7.9.2.2.
+// Text strings are either UTF-16BE encoded starting with a byte order mark or PDFDocEncoding encoded.
+// Text strings using UTF-16LE or UTF-8 with byte order mark, as written by some producers, get accepted too.
+func decodeText(b []byte) (string, error) {
 
-	b, err := Unescape(s)
+	// Check for Big Endian UTF-16.
+	isUTF16BE, err := IsUTF16BE(b)
 	if err != nil {
 		return "", err
 	}
 
-	s1 := string(b)
+	switch {
 
-	// Check for Big Endian UTF-16.
-	if IsStringUTF16BE(s1) {
-		return DecodeUTF16String(s1)
+	case isUTF16BE:
+		return decodeUTF16String(b)
+
+	case isUTF16LE(b):
+		// Swap to Big Endian byte order.
+		b1 := make([]byte, len(b))
+		for i := 0; i < len(b); i += 2 {
+			b1[i], b1[i+1] = b[i+1], b[i]
+		}
+		return decodeUTF16String(b1)
+
+	case isUTF8(b) && utf8.Valid(b[3:]):
+		return string(b[3:]), nil
+	}
+
+	return decodePDFDocEncoding(b), nil
+}
+
+// StringLiteralToString returns the best possible string rep for a string literal.
+func StringLiteralToString(s string) (string, error) {
+
+	b, err := Unescape(s)
+	if err != nil {
+		return "", err
 	}
 
-	// if no acceptable UTF16 encoding found, just return str.
-	return s1, nil
+	return decodeText(b)
 }
 
-// HexLiteralToString returns a possibly UTF16 encoded string for a hex string.
+// HexLiteralToString returns the best possible string rep for a hex string.
 func HexLiteralToString(hexString string) (string, error) {
 
 	// Get corresponding byte slice.
@@ -170,16 +201,25 @@ func HexLiteralToString(hexString string) (string, error) {
 		return "", err
 	}
 
-	// Check for Big Endian UTF-16.
-	isUTF16BE, err := IsUTF16BE(b)
-	if err != nil {
-		return "", err
+	return decodeText(b)
+}
+
+// textString returns s as text string object, see 7.9.2.2.
+// Text available in PDFDocEncoding gets written as string literal, anything else as UTF-16BE hex literal with byte order mark.
+func textString(s string) (Object, error) {
+
+	if s1, ok := encodePDFDocEncoding(s); ok {
+		es, err := Escape(s1)
+		if err != nil {
+			return nil, err
+		}
+		return StringLiteral(*es), nil
 	}
 
-	if isUTF16BE {
-		return decodeUTF16String(b)
+	b := []byte{0xFE, 0xFF}
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(u>>8), byte(u))
 	}
 
-	// if no acceptable UTF16 encoding found, just return decoded hexstring.
-	return string(b), nil
+	return HexLiteral(hex.EncodeToString(b)), nil
 }
@@ -648,6 +648,12 @@ func validateAnnotationDictPolyLine(xRefTable *pdf.XRefTable, d pdf.Dict, dictNa
 		}
 	}
 
+	// Measure, optional, measure dict, since V1.7
+	err = validateEntryMeasure(xRefTable, d, dictName, OPTIONAL, pdf.V17)
+	if err != nil {
+		return err
+	}
+
 	return validateEntryIT(xRefTable, d, dictName, OPTIONAL, pdf.V16)
 }
 
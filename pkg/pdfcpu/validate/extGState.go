@@ -17,6 +17,7 @@ limitations under the License.
 package validate
 
 import (
+	"github.com/jplu/pdfcpu/pkg/log"
 	pdf "github.com/jplu/pdfcpu/pkg/pdfcpu"
 	"github.com/pkg/errors"
 )
@@ -32,6 +33,16 @@ func validateBlendMode(s string) bool {
 		"Hue", "Saturation", "Color", "Luminosity"})
 }
 
+// alphaValidator returns a check for alpha constants being within 0 and 1 in strict mode only.
+func alphaValidator(xRefTable *pdf.XRefTable) func(f float64) bool {
+
+	if xRefTable.ValidationMode == pdf.ValidationRelaxed {
+		return nil
+	}
+
+	return func(f float64) bool { return f >= 0 && f <= 1 }
+}
+
 func validateLineDashPatternEntry(xRefTable *pdf.XRefTable, d pdf.Dict, dictName string, entryName string, required bool, sinceVersion pdf.Version) error {
 
 	a, err := validateArrayEntry(xRefTable, d, dictName, entryName, required, sinceVersion, func(a pdf.Array) bool { return len(a) == 2 })
@@ -578,6 +589,10 @@ func validateSoftMaskDict(xRefTable *pdf.XRefTable, d pdf.Dict) error {
 		if err != nil {
 			return err
 		}
+		err = validateSoftMaskGroup(xRefTable, *sd)
+		if err != nil {
+			return err
+		}
 	}
 
 	// TR (Optional) function or name
@@ -596,6 +611,25 @@ func validateSoftMaskDict(xRefTable *pdf.XRefTable, d pdf.Dict) error {
 	return err
 }
 
+// validateSoftMaskGroup checks that the form sd used by a soft mask is a transparency group XObject.
+func validateSoftMaskGroup(xRefTable *pdf.XRefTable, sd pdf.StreamDict) error {
+
+	if st := sd.Subtype(); st != nil && *st == "Form" {
+		if g := sd.DictEntry("Group"); g != nil {
+			if s := g.NameEntry("S"); s != nil && *s == "Transparency" {
+				return nil
+			}
+		}
+	}
+
+	if xRefTable.ValidationMode == pdf.ValidationRelaxed {
+		log.Validate.Println("validateSoftMaskGroup: ignoring \"G\" not being a transparency group XObject")
+		return nil
+	}
+
+	return errors.New("validateSoftMaskGroup: \"G\" must be a transparency group XObject")
+}
+
 func validateSoftMaskEntry(xRefTable *pdf.XRefTable, d pdf.Dict, dictName string, entryName string, required bool, sinceVersion pdf.Version) error {
 
 	// see 11.3.7.2 Source Shape and Opacity
@@ -609,8 +643,9 @@ func validateSoftMaskEntry(xRefTable *pdf.XRefTable, d pdf.Dict, dictName string
 	switch o := o.(type) {
 
 	case pdf.Name:
+		// The only valid name is None, relaxed mode also tolerates blend modes.
 		s := o.String()
-		if !validateBlendMode(s) {
+		if s != "None" && (xRefTable.ValidationMode == pdf.ValidationStrict || !validateBlendMode(s)) {
 			return errors.Errorf("validateSoftMaskEntry: invalid soft mask: %s\n", s)
 		}
 
@@ -777,7 +812,7 @@ func validateExtGStateDictPart3(xRefTable *pdf.XRefTable, d pdf.Dict, dictName s
 	if xRefTable.ValidationMode == pdf.ValidationRelaxed {
 		sinceVersion = pdf.V13
 	}
-	_, err = validateNumberEntry(xRefTable, d, dictName, "CA", OPTIONAL, sinceVersion, nil)
+	_, err = validateNumberEntry(xRefTable, d, dictName, "CA", OPTIONAL, sinceVersion, alphaValidator(xRefTable))
 	if err != nil {
 		return err
 	}
@@ -787,7 +822,7 @@ func validateExtGStateDictPart3(xRefTable *pdf.XRefTable, d pdf.Dict, dictName s
 	if xRefTable.ValidationMode == pdf.ValidationRelaxed {
 		sinceVersion = pdf.V13
 	}
-	_, err = validateNumberEntry(xRefTable, d, dictName, "ca", OPTIONAL, sinceVersion, nil)
+	_, err = validateNumberEntry(xRefTable, d, dictName, "ca", OPTIONAL, sinceVersion, alphaValidator(xRefTable))
 	if err != nil {
 		return err
 	}
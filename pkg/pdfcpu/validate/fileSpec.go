@@ -390,6 +390,17 @@ func validateFileSpecDict(xRefTable *pdf.XRefTable, d pdf.Dict) error {
 
 	// CI, optional, collection item dict, since V1.7
 	_, err = validateDictEntry(xRefTable, d, dictName, "CI", OPTIONAL, pdf.V17, nil)
+	if err != nil {
+		return err
+	}
+
+	// AFRelationship, optional, name, since V2.0, also used by PDF/A-3 based on V1.7
+	validateRel := func(s string) bool { return pdf.MemberOf(s, pdf.AFRelationships) }
+	if xRefTable.ValidationMode == pdf.ValidationRelaxed {
+		// Second-class names are allowed too.
+		validateRel = nil
+	}
+	_, err = validateNameEntry(xRefTable, d, dictName, "AFRelationship", OPTIONAL, pdf.V17, validateRel)
 
 	return err
 }
@@ -431,6 +442,33 @@ func validateFileSpecification(xRefTable *pdf.XRefTable, o pdf.Object) (pdf.Obje
 	return o, nil
 }
 
+// validateEntryAF validates an array of associated file specifications, see 14.13.
+func validateEntryAF(xRefTable *pdf.XRefTable, d pdf.Dict, dictName string, required bool, sinceVersion pdf.Version) error {
+
+	a, err := validateArrayEntry(xRefTable, d, dictName, "AF", required, sinceVersion, nil)
+	if err != nil || a == nil {
+		return err
+	}
+
+	for _, o := range a {
+
+		o, err = xRefTable.Dereference(o)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := o.(pdf.Dict); !ok {
+			return errors.Errorf("validateEntryAF: %s: file specification dict expected", dictName)
+		}
+
+		if _, err = validateFileSpecification(xRefTable, o); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func validateURLSpecification(xRefTable *pdf.XRefTable, o pdf.Object) (pdf.Object, error) {
 
 	// See 7.11.4
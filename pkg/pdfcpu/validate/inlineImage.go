@@ -0,0 +1,99 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validate
+
+import (
+	"github.com/jplu/pdfcpu/pkg/log"
+	pdf "github.com/jplu/pdfcpu/pkg/pdfcpu"
+	"github.com/pkg/errors"
+)
+
+func validateInlineImageDict(xRefTable *pdf.XRefTable, img pdf.InlineImage) error {
+
+	dictName := "inlineImageDict"
+
+	for k := range img.Dict {
+		if _, ok := pdf.InlineImageKey(k); !ok {
+			if xRefTable.ValidationMode == pdf.ValidationStrict {
+				return errors.Errorf("validateInlineImageDict: invalid entry \"%s\"", k)
+			}
+			log.Validate.Printf("validateInlineImageDict: ignoring invalid entry \"%s\"\n", k)
+		}
+	}
+
+	d := img.ImageDict()
+
+	_, err := validateIntegerEntry(xRefTable, d, dictName, "Width", REQUIRED, pdf.V10, func(i int) bool { return i > 0 })
+	if err != nil {
+		return err
+	}
+
+	_, err = validateIntegerEntry(xRefTable, d, dictName, "Height", REQUIRED, pdf.V10, func(i int) bool { return i > 0 })
+	if err != nil {
+		return err
+	}
+
+	_, err = validateIntegerEntry(xRefTable, d, dictName, "BitsPerComponent", OPTIONAL, pdf.V10, validateBitsPerComponent)
+	if err != nil {
+		return err
+	}
+
+	_, err = validateBooleanEntry(xRefTable, d, dictName, "ImageMask", OPTIONAL, pdf.V10, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = validateBooleanEntry(xRefTable, d, dictName, "Interpolate", OPTIONAL, pdf.V10, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = validateNumberArrayEntry(xRefTable, d, dictName, "Decode", OPTIONAL, pdf.V10, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = validateNameEntry(xRefTable, d, dictName, "Intent", OPTIONAL, pdf.V11, nil)
+	if err != nil {
+		return err
+	}
+
+	return validateNameOrArrayOfNameEntry(xRefTable, d, dictName, "Filter", OPTIONAL, pdf.V10)
+}
+
+// validateInlineImages checks the dicts of all inline images of a content stream.
+// Content streams are not parsed during validation otherwise,
+// so content streams pdfcpu fails to scan get tolerated.
+func validateInlineImages(xRefTable *pdf.XRefTable, sd pdf.StreamDict) error {
+
+	imgs, err := pdf.ContentStreamInlineImages(sd)
+	if err != nil {
+		log.Validate.Printf("validateInlineImages: ignoring corrupt content stream: %v\n", err)
+		return nil
+	}
+
+	for _, img := range imgs {
+		if err = validateInlineImageDict(xRefTable, img); err != nil {
+			if xRefTable.ValidationMode == pdf.ValidationStrict {
+				return err
+			}
+			log.Validate.Printf("validateInlineImages: ignoring invalid inline image: %v\n", err)
+		}
+	}
+
+	return nil
+}
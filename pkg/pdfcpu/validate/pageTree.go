@@ -0,0 +1,89 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validate
+
+import (
+	"fmt"
+
+	pdf "github.com/jplu/pdfcpu/pkg/pdfcpu"
+)
+
+// CheckPageTree is a rule reporting page tree nodes with a wrong "Count" or "Parent" as warnings.
+// Configuration.PageTreeFanOut repairs these on writing.
+//
+// Register it eg. using RegisterRule("page-tree", CheckPageTree).
+func CheckPageTree(xRefTable *pdf.XRefTable) []Violation {
+
+	root, err := xRefTable.Pages()
+	if err != nil || root == nil {
+		return []Violation{{Message: "missing page tree root"}}
+	}
+
+	var vs []Violation
+	checkPageTreeNode(xRefTable, *root, nil, pdf.IntSet{}, &vs)
+
+	return vs
+}
+
+// checkPageTreeNode returns the number of pages below the page tree node ir.
+func checkPageTreeNode(xRefTable *pdf.XRefTable, ir pdf.IndirectRef, parent *pdf.IndirectRef, visited pdf.IntSet, vs *[]Violation) int {
+
+	warn := func(format string, a ...interface{}) {
+		*vs = append(*vs, Violation{Severity: SeverityWarning, Message: fmt.Sprintf(format, a...)})
+	}
+
+	objNr := ir.ObjectNumber.Value()
+	if visited[objNr] {
+		warn("page tree node obj#%d referenced more than once", objNr)
+		return 0
+	}
+	visited[objNr] = true
+
+	d, err := xRefTable.DereferenceDict(ir)
+	if err != nil || d == nil {
+		warn("page tree node obj#%d missing", objNr)
+		return 0
+	}
+
+	if parent != nil {
+		p := d.IndirectRefEntry("Parent")
+		if p == nil || p.ObjectNumber != parent.ObjectNumber {
+			warn("page tree node obj#%d: \"Parent\" does not refer to obj#%d", objNr, parent.ObjectNumber.Value())
+		}
+	}
+
+	if t := d.Type(); t == nil || *t != "Pages" {
+		return 1
+	}
+
+	count := 0
+	for _, o := range d.ArrayEntry("Kids") {
+		if kid, ok := o.(pdf.IndirectRef); ok {
+			count += checkPageTreeNode(xRefTable, kid, &ir, visited, vs)
+		}
+	}
+
+	if c := d.IntEntry("Count"); c == nil || *c != count {
+		declared := "missing"
+		if c != nil {
+			declared = fmt.Sprintf("%d", *c)
+		}
+		warn("page tree node obj#%d: \"Count\" is %s, found %d pages", objNr, declared, count)
+	}
+
+	return count
+}
@@ -73,25 +73,31 @@ func validatePageContents(xRefTable *pdf.XRefTable, d pdf.Dict) (hasContents boo
 	switch o := o.(type) {
 
 	case pdf.StreamDict:
-		// no further processing.
 		hasContents = true
+		if err = validateInlineImages(xRefTable, o); err != nil {
+			return false, err
+		}
 
 	case pdf.Array:
 		// process array of content stream dicts.
 
 		for _, o := range o {
 
-			o, err = xRefTable.DereferenceStreamDict(o)
+			sd, err := xRefTable.DereferenceStreamDict(o)
 			if err != nil {
 				return false, err
 			}
 
-			if o == nil {
+			if sd == nil {
 				continue
 			}
 
 			hasContents = true
 
+			if err = validateInlineImages(xRefTable, *sd); err != nil {
+				return false, err
+			}
+
 		}
 
 	default:
@@ -686,6 +692,11 @@ func validateViewportDict(xRefTable *pdf.XRefTable, d pdf.Dict, sinceVersion pdf
 	return err
 }
 
+func validatePageEntryAF(xRefTable *pdf.XRefTable, d pdf.Dict, required bool, sinceVersion pdf.Version) error {
+
+	return validateEntryAF(xRefTable, d, "pageDict", required, sinceVersion)
+}
+
 func validatePageEntryVP(xRefTable *pdf.XRefTable, d pdf.Dict, required bool, sinceVersion pdf.Version) error {
 
 	// see table 260
@@ -800,6 +811,7 @@ func validatePageDict(xRefTable *pdf.XRefTable, d pdf.Dict, objNumber, genNumber
 		{validatePageEntryPresSteps, OPTIONAL, pdf.V15},
 		{validatePageEntryUserUnit, OPTIONAL, pdf.V16},
 		{validatePageEntryVP, OPTIONAL, pdf.V16},
+		{validatePageEntryAF, OPTIONAL, pdf.V17},
 	} {
 		err = f.validate(xRefTable, d, f.required, f.sinceVersion)
 		if err != nil {
@@ -882,7 +894,7 @@ func validatePagesDict(xRefTable *pdf.XRefTable, d pdf.Dict, objNumber, genNumbe
 	//var dHasResources, dHasMediaBox bool
 	dHasResources, dHasMediaBox, err := validatePagesDictGeneralEntries(xRefTable, d)
 	if err != nil {
-		return err
+		return &pdf.ValidationError{ObjNr: objNumber, GenNr: genNumber, Err: err}
 	}
 
 	if dHasResources {
@@ -923,7 +935,7 @@ func validatePagesDict(xRefTable *pdf.XRefTable, d pdf.Dict, objNumber, genNumbe
 
 		dictType, err := dictTypeForPageNodeDict(pageNodeDict)
 		if err != nil {
-			return err
+			return &pdf.ValidationError{ObjNr: objNumber, GenNr: genNumber, Err: err}
 		}
 
 		switch dictType {
@@ -938,7 +950,7 @@ func validatePagesDict(xRefTable *pdf.XRefTable, d pdf.Dict, objNumber, genNumbe
 		case "Page":
 			err = validatePageDict(xRefTable, pageNodeDict, objNumber, genNumber, hasResources, hasMediaBox)
 			if err != nil {
-				return err
+				return &pdf.ValidationError{ObjNr: objNumber, GenNr: genNumber, Err: err}
 			}
 
 		default:
@@ -0,0 +1,227 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validate
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jplu/pdfcpu/pkg/log"
+	pdf "github.com/jplu/pdfcpu/pkg/pdfcpu"
+)
+
+// Severity classifies a violation.
+type Severity int
+
+// Only errors fail validation.
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+	SeverityInfo
+)
+
+func (s Severity) String() string {
+
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityInfo:
+		return "info"
+	}
+
+	return fmt.Sprintf("severity %d", s)
+}
+
+// Violation describes a single finding of a validation rule.
+type Violation struct {
+	RuleID   string // The id the rule has been registered with.
+	Severity Severity
+	Message  string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s %s: %s", v.Severity, v.RuleID, v.Message)
+}
+
+// Rule checks a document against an additional requirement,
+// eg. a house rule like "no RGB images" or "must contain OutputIntent".
+type Rule func(xRefTable *pdf.XRefTable) []Violation
+
+// ViolationsError is returned by XRefTable for any errors found by registered rules.
+type ViolationsError struct {
+	Violations []Violation
+}
+
+func (e *ViolationsError) Error() string {
+
+	ss := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		ss[i] = v.String()
+	}
+
+	return "validation rules violated:\n" + strings.Join(ss, "\n")
+}
+
+var (
+	rulesMu    sync.RWMutex
+	rules      = map[string]Rule{}
+	severities = map[string]Severity{}
+)
+
+// RegisterRule adds a rule with a unique id running along the built-in validation.
+// Registering another rule using the same id replaces the former.
+func RegisterRule(id string, r Rule) {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	rules[id] = r
+}
+
+// UnregisterRule removes the rule registered as id.
+func UnregisterRule(id string) {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	delete(rules, id)
+}
+
+// RegisteredRules returns the sorted ids of all registered rules.
+func RegisteredRules() []string {
+
+	rulesMu.RLock()
+	defer rulesMu.RUnlock()
+
+	ids := make([]string, 0, len(rules))
+	for id := range rules {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// SetRuleSeverity overrides the severity of all violations reported by the rule or built-in check id.
+// Built-in checks are ids like "catalog.Outlines", "annotations" or "info" and report errors by default.
+func SetRuleSeverity(id string, s Severity) {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	severities[id] = s
+}
+
+// ResetRuleSeverity removes the severity override for id.
+func ResetRuleSeverity(id string) {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	delete(severities, id)
+}
+
+func ruleSeverity(id string) (Severity, bool) {
+	rulesMu.RLock()
+	defer rulesMu.RUnlock()
+	s, ok := severities[id]
+	return s, ok
+}
+
+func rule(id string) Rule {
+	rulesMu.RLock()
+	defer rulesMu.RUnlock()
+	return rules[id]
+}
+
+func suppressed(xRefTable *pdf.XRefTable, id string) bool {
+
+	for _, s := range xRefTable.SuppressedRules {
+		if s == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// builtinCheck runs the built-in check id unless suppressed.
+// Its failure is returned unless lowered to a warning or info, which gets recorded in findings instead.
+func builtinCheck(xRefTable *pdf.XRefTable, id string, findings *[]Violation, check func() error) error {
+
+	if suppressed(xRefTable, id) {
+		log.Validate.Printf("builtinCheck: %s suppressed\n", id)
+		return nil
+	}
+
+	err := check()
+	if err == nil {
+		return nil
+	}
+
+	s, ok := ruleSeverity(id)
+	if !ok || s == SeverityError {
+		return err
+	}
+
+	v := Violation{RuleID: id, Severity: s, Message: err.Error()}
+	log.Info.Println(v)
+	*findings = append(*findings, v)
+
+	return nil
+}
+
+// validateRules runs all registered rules not suppressed in order of their ids
+// and returns all warnings and infos found.
+func validateRules(xRefTable *pdf.XRefTable) ([]Violation, error) {
+
+	var errs, findings []Violation
+
+	for _, id := range RegisteredRules() {
+
+		if suppressed(xRefTable, id) {
+			log.Validate.Printf("validateRules: %s suppressed\n", id)
+			continue
+		}
+
+		r := rule(id)
+		if r == nil {
+			// Unregistered in the meantime.
+			continue
+		}
+
+		log.Validate.Printf("validateRules: %s\n", id)
+
+		for _, v := range r(xRefTable) {
+
+			v.RuleID = id
+			if s, ok := ruleSeverity(id); ok {
+				v.Severity = s
+			}
+
+			if v.Severity == SeverityError {
+				errs = append(errs, v)
+				continue
+			}
+
+			log.Info.Println(v)
+			findings = append(findings, v)
+		}
+	}
+
+	if len(errs) > 0 {
+		return findings, &ViolationsError{errs}
+	}
+
+	return findings, nil
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validate
+
+import (
+	"fmt"
+	"sort"
+
+	pdf "github.com/jplu/pdfcpu/pkg/pdfcpu"
+)
+
+// CheckTransparency is a rule reporting any use of transparency as warnings,
+// for targets not supporting it like PDF/A-1 (ISO 19005-1, 6.4).
+// Configuration.FlattenTransparency removes transparency on writing.
+//
+// Register it eg. using RegisterRule("transparency", CheckTransparency).
+func CheckTransparency(xRefTable *pdf.XRefTable) []Violation {
+
+	var vs []Violation
+
+	warn := func(objNr int, format string, a ...interface{}) {
+		msg := fmt.Sprintf("obj#%d: ", objNr) + fmt.Sprintf(format, a...)
+		vs = append(vs, Violation{Severity: SeverityWarning, Message: msg})
+	}
+
+	var objNrs []int
+	for objNr := range xRefTable.Table {
+		objNrs = append(objNrs, objNr)
+	}
+	sort.Ints(objNrs)
+
+	for _, objNr := range objNrs {
+
+		entry := xRefTable.Table[objNr]
+		if entry.Free {
+			continue
+		}
+
+		var d pdf.Dict
+
+		switch o := entry.Object.(type) {
+		case pdf.Dict:
+			d = o
+		case pdf.StreamDict:
+			d = o.Dict
+			if st := o.Subtype(); st != nil && *st == "Image" {
+				if _, found := o.Find("SMask"); found {
+					warn(objNr, "image with soft mask")
+				}
+				if i := o.IntEntry("SMaskInData"); i != nil && *i != 0 {
+					warn(objNr, "image with soft mask in data")
+				}
+			}
+		default:
+			continue
+		}
+
+		if g := d.DictEntry("Group"); g != nil {
+			if s := g.NameEntry("S"); s != nil && *s == "Transparency" {
+				warn(objNr, "transparency group")
+			}
+		}
+
+		if _, found := d.Find("Rect"); found {
+			if _, found := d.Find("CA"); found && xRefTable.DereferenceNumber(d["CA"]) != 1 {
+				warn(objNr, "annotation with constant opacity")
+			}
+		}
+
+		checkExtGStateTransparency(xRefTable, objNr, d, warn)
+	}
+
+	return vs
+}
+
+// checkExtGStateTransparency warns about transparency parameters of the graphics states referenced by the resources of d.
+func checkExtGStateTransparency(xRefTable *pdf.XRefTable, objNr int, d pdf.Dict, warn func(int, string, ...interface{})) {
+
+	resources, err := xRefTable.DereferenceDict(d["Resources"])
+	if err != nil || resources == nil {
+		return
+	}
+
+	gStates, err := xRefTable.DereferenceDict(resources["ExtGState"])
+	if err != nil || gStates == nil {
+		return
+	}
+
+	var names []string
+	for k := range gStates {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for _, k := range names {
+		gs, err := xRefTable.DereferenceDict(gStates[k])
+		if err != nil || gs == nil {
+			continue
+		}
+		checkGStateTransparency(xRefTable, objNr, k, gs, warn)
+	}
+}
+
+func checkGStateTransparency(xRefTable *pdf.XRefTable, objNr int, name string, d pdf.Dict, warn func(int, string, ...interface{})) {
+
+	if o, found := d.Find("SMask"); found {
+		if n, ok := o.(pdf.Name); !ok || n != "None" {
+			warn(objNr, "graphics state %s with soft mask", name)
+		}
+	}
+
+	if o, found := d.Find("BM"); found {
+		if n, ok := o.(pdf.Name); !ok || n != "Normal" && n != "Compatible" {
+			warn(objNr, "graphics state %s with blend mode %s", name, o)
+		}
+	}
+
+	for _, k := range []string{"CA", "ca"} {
+		if o, found := d.Find(k); found && xRefTable.DereferenceNumber(o) != 1 {
+			warn(objNr, "graphics state %s with %s %s", name, k, o)
+		}
+	}
+}
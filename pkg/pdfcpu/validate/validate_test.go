@@ -16,7 +16,11 @@ limitations under the License.
 
 package validate
 
-import "testing"
+import (
+	"testing"
+
+	pdf "github.com/jplu/pdfcpu/pkg/pdfcpu"
+)
 
 func doTestValidateDateOK(s string, t *testing.T) {
 
@@ -83,3 +87,18 @@ func TestValidateDateCommand(t *testing.T) {
 	s = "D:20170430155901+66'A9'"
 	doTestValidateDateFail(s, t)
 }
+
+func TestValidateAlpha(t *testing.T) {
+
+	d := pdf.Dict{"CA": pdf.Float(1.5)}
+
+	for mode, ok := range map[int]bool{pdf.ValidationStrict: false, pdf.ValidationRelaxed: true} {
+		xRefTable := &pdf.XRefTable{ValidationMode: mode}
+		v := pdf.V17
+		xRefTable.HeaderVersion = &v
+		_, err := validateNumberEntry(xRefTable, d, "extGStateDict", "CA", OPTIONAL, pdf.V14, alphaValidator(xRefTable))
+		if (err == nil) != ok {
+			t.Errorf("validationMode %d: CA 1.5 accepted=%t, want %t\n", mode, err == nil, ok)
+		}
+	}
+}
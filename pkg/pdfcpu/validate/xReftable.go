@@ -25,33 +25,51 @@ import (
 
 // XRefTable validates a PDF cross reference table obeying the validation mode.
 func XRefTable(xRefTable *pdf.XRefTable) error {
+	_, err := XRefTableFindings(xRefTable)
+	return err
+}
+
+// XRefTableFindings validates a PDF cross reference table obeying the validation mode
+// and returns any warnings and infos found by built-in checks and registered rules.
+func XRefTableFindings(xRefTable *pdf.XRefTable) ([]Violation, error) {
 
 	log.Info.Println("validating")
 	log.Validate.Println("*** validateXRefTable begin ***")
 
+	var findings []Violation
+
 	// Validate root object(aka the document catalog) and page tree.
-	err := validateRootObject(xRefTable)
+	err := validateRootObject(xRefTable, &findings)
 	if err != nil {
-		return err
+		return findings, err
 	}
 
 	// Validate document information dictionary.
-	err = validateDocumentInfoObject(xRefTable)
+	err = builtinCheck(xRefTable, "info", &findings, func() error {
+		return validateDocumentInfoObject(xRefTable)
+	})
 	if err != nil {
-		return err
+		return findings, err
 	}
 
 	// Validate offspec additional streams as declared in pdf trailer.
 	err = validateAdditionalStreams(xRefTable)
 	if err != nil {
-		return err
+		return findings, err
+	}
+
+	// Validate registered rules.
+	ruleFindings, err := validateRules(xRefTable)
+	findings = append(findings, ruleFindings...)
+	if err != nil {
+		return findings, err
 	}
 
 	xRefTable.Valid = true
 
 	log.Validate.Println("*** validateXRefTable end ***")
 
-	return nil
+	return findings, nil
 }
 
 func validateRootVersion(xRefTable *pdf.XRefTable, rootDict pdf.Dict, required bool, sinceVersion pdf.Version) error {
@@ -370,6 +388,11 @@ func validateMarkInfo(xRefTable *pdf.XRefTable, rootDict pdf.Dict, required bool
 	return err
 }
 
+func validateRootAF(xRefTable *pdf.XRefTable, rootDict pdf.Dict, required bool, sinceVersion pdf.Version) error {
+
+	return validateEntryAF(xRefTable, rootDict, "rootDict", required, sinceVersion)
+}
+
 func validateLang(xRefTable *pdf.XRefTable, rootDict pdf.Dict, required bool, sinceVersion pdf.Version) error {
 
 	_, err := validateStringEntry(xRefTable, rootDict, "rootDict", "Lang", required, sinceVersion, nil)
@@ -802,7 +825,7 @@ func validateNeedsRendering(xRefTable *pdf.XRefTable, rootDict pdf.Dict, require
 	return err
 }
 
-func validateRootObject(xRefTable *pdf.XRefTable) error {
+func validateRootObject(xRefTable *pdf.XRefTable, findings *[]Violation) error {
 
 	log.Validate.Println("*** validateRootObject begin ***")
 
@@ -848,7 +871,7 @@ func validateRootObject(xRefTable *pdf.XRefTable) error {
 	// Type
 	_, err = validateNameEntry(xRefTable, d, "rootDict", "Type", REQUIRED, pdf.V10, func(s string) bool { return s == "Catalog" })
 	if err != nil {
-		return err
+		return catalogError(xRefTable, err)
 	}
 
 	// Pages
@@ -858,52 +881,67 @@ func validateRootObject(xRefTable *pdf.XRefTable) error {
 	}
 
 	for _, f := range []struct {
+		id           string
 		validate     func(xRefTable *pdf.XRefTable, d pdf.Dict, required bool, sinceVersion pdf.Version) (err error)
 		required     bool
 		sinceVersion pdf.Version
 	}{
-		{validateRootVersion, OPTIONAL, pdf.V14},
-		{validateExtensions, OPTIONAL, pdf.V10},
-		{validatePageLabels, OPTIONAL, pdf.V13},
-		{validateNames, OPTIONAL, pdf.V12},
-		{validateNamedDestinations, OPTIONAL, pdf.V11},
-		{validateViewerPreferences, OPTIONAL, pdf.V12},
-		{validatePageLayout, OPTIONAL, pdf.V10},
-		{validatePageMode, OPTIONAL, pdf.V10},
-		{validateOutlines, OPTIONAL, pdf.V10},
-		{validateThreads, OPTIONAL, pdf.V11},
-		{validateOpenAction, OPTIONAL, pdf.V11},
-		{validateRootAdditionalActions, OPTIONAL, pdf.V14},
-		{validateURI, OPTIONAL, pdf.V11},
-		{validateAcroForm, OPTIONAL, pdf.V12},
-		{validateRootMetadata, OPTIONAL, pdf.V14},
-		{validateStructTree, OPTIONAL, pdf.V13},
-		{validateMarkInfo, OPTIONAL, pdf.V14},
-		{validateLang, OPTIONAL, pdf.V10},
-		{validateSpiderInfo, OPTIONAL, pdf.V13},
-		{validateOutputIntents, OPTIONAL, pdf.V14},
-		{validateRootPieceInfo, OPTIONAL, pdf.V14},
-		{validateOCProperties, OPTIONAL, pdf.V15},
-		{validatePermissions, OPTIONAL, pdf.V15},
-		{validateLegal, OPTIONAL, pdf.V17},
-		{validateRequirements, OPTIONAL, pdf.V17},
-		{validateCollection, OPTIONAL, pdf.V17},
-		{validateNeedsRendering, OPTIONAL, pdf.V17},
+		{"catalog.Version", validateRootVersion, OPTIONAL, pdf.V14},
+		{"catalog.Extensions", validateExtensions, OPTIONAL, pdf.V10},
+		{"catalog.PageLabels", validatePageLabels, OPTIONAL, pdf.V13},
+		{"catalog.Names", validateNames, OPTIONAL, pdf.V12},
+		{"catalog.Dests", validateNamedDestinations, OPTIONAL, pdf.V11},
+		{"catalog.ViewerPreferences", validateViewerPreferences, OPTIONAL, pdf.V12},
+		{"catalog.PageLayout", validatePageLayout, OPTIONAL, pdf.V10},
+		{"catalog.PageMode", validatePageMode, OPTIONAL, pdf.V10},
+		{"catalog.Outlines", validateOutlines, OPTIONAL, pdf.V10},
+		{"catalog.Threads", validateThreads, OPTIONAL, pdf.V11},
+		{"catalog.OpenAction", validateOpenAction, OPTIONAL, pdf.V11},
+		{"catalog.AA", validateRootAdditionalActions, OPTIONAL, pdf.V14},
+		{"catalog.URI", validateURI, OPTIONAL, pdf.V11},
+		{"catalog.AcroForm", validateAcroForm, OPTIONAL, pdf.V12},
+		{"catalog.Metadata", validateRootMetadata, OPTIONAL, pdf.V14},
+		{"catalog.StructTreeRoot", validateStructTree, OPTIONAL, pdf.V13},
+		{"catalog.MarkInfo", validateMarkInfo, OPTIONAL, pdf.V14},
+		{"catalog.Lang", validateLang, OPTIONAL, pdf.V10},
+		{"catalog.SpiderInfo", validateSpiderInfo, OPTIONAL, pdf.V13},
+		{"catalog.OutputIntents", validateOutputIntents, OPTIONAL, pdf.V14},
+		{"catalog.PieceInfo", validateRootPieceInfo, OPTIONAL, pdf.V14},
+		{"catalog.OCProperties", validateOCProperties, OPTIONAL, pdf.V15},
+		{"catalog.Perms", validatePermissions, OPTIONAL, pdf.V15},
+		{"catalog.Legal", validateLegal, OPTIONAL, pdf.V17},
+		{"catalog.Requirements", validateRequirements, OPTIONAL, pdf.V17},
+		{"catalog.Collection", validateCollection, OPTIONAL, pdf.V17},
+		{"catalog.NeedsRendering", validateNeedsRendering, OPTIONAL, pdf.V17},
+		{"catalog.AF", validateRootAF, OPTIONAL, pdf.V17},
 	} {
-		err = f.validate(xRefTable, d, f.required, f.sinceVersion)
+		err = builtinCheck(xRefTable, f.id, findings, func() error {
+			if err := f.validate(xRefTable, d, f.required, f.sinceVersion); err != nil {
+				return catalogError(xRefTable, err)
+			}
+			return nil
+		})
 		if err != nil {
 			return err
 		}
 	}
 
 	// Validate remainder of annotations after AcroForm validation only.
-	err = validatePagesAnnotations(xRefTable, rootPageNodeDict)
+	err = builtinCheck(xRefTable, "annotations", findings, func() error {
+		return validatePagesAnnotations(xRefTable, rootPageNodeDict)
+	})
 
 	log.Validate.Println("*** validateRootObject end ***")
 
 	return err
 }
 
+// catalogError ties err to the document catalog.
+func catalogError(xRefTable *pdf.XRefTable, err error) error {
+	ir := xRefTable.Root
+	return &pdf.ValidationError{ObjNr: ir.ObjectNumber.Value(), GenNr: ir.GenerationNumber.Value(), Err: err}
+}
+
 func validateAdditionalStreams(xRefTable *pdf.XRefTable) error {
 
 	// Out of spec scope.
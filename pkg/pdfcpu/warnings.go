@@ -0,0 +1,92 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/jplu/pdfcpu/pkg/log"
+)
+
+// Warning describes a non fatal problem like an object skipped or only partially processed,
+// which may mean the result lacks data found in the input.
+type Warning struct {
+	Op     string `json:"op"`
+	PageNr int    `json:"page,omitempty"`
+	ObjNr  int    `json:"objNr,omitempty"`
+	Msg    string `json:"msg"`
+}
+
+func (w Warning) String() string {
+
+	ss := []string{w.Op}
+
+	if w.PageNr > 0 {
+		ss = append(ss, fmt.Sprintf("page %d", w.PageNr))
+	}
+
+	if w.ObjNr > 0 {
+		ss = append(ss, fmt.Sprintf("obj#%d", w.ObjNr))
+	}
+
+	return strings.Join(ss, ": ") + ": " + w.Msg
+}
+
+// warnings collects the warnings of a cross reference table and any clones of it.
+type warnings struct {
+	mu   sync.Mutex
+	ww   []Warning
+	hook func(w Warning)
+}
+
+// Warn records and logs a warning for op concerning page pageNr and object objNr, zero meaning none.
+func (xRefTable *XRefTable) Warn(op string, pageNr, objNr int, format string, a ...interface{}) {
+
+	w := Warning{Op: op, PageNr: pageNr, ObjNr: objNr, Msg: fmt.Sprintf(format, a...)}
+
+	log.Info.Println(w)
+
+	ws := xRefTable.warnings
+	if ws == nil {
+		return
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	ws.ww = append(ws.ww, w)
+
+	if ws.hook != nil {
+		ws.hook(w)
+	}
+}
+
+// Warnings returns the warnings recorded so far.
+func (xRefTable *XRefTable) Warnings() []Warning {
+
+	ws := xRefTable.warnings
+	if ws == nil {
+		return nil
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	return append([]Warning(nil), ws.ww...)
+}
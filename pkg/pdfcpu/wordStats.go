@@ -0,0 +1,131 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// WordCount is the number of occurrences of a word.
+type WordCount struct {
+	Word  string `json:"word"`
+	Count int    `json:"count"`
+}
+
+// PageWordStats represents the word statistics of a page.
+type PageWordStats struct {
+	Page   int            `json:"page"`
+	Words  int            `json:"words"`
+	Counts map[string]int `json:"counts"`
+}
+
+// WordStats represents the word statistics of a document.
+type WordStats struct {
+	Pages  []PageWordStats `json:"pages"`
+	Words  int             `json:"words"`
+	Counts map[string]int  `json:"counts"`
+}
+
+// topWords returns the n most frequent words of counts, all words for n <= 0.
+func topWords(counts map[string]int, n int) []WordCount {
+
+	wc := make([]WordCount, 0, len(counts))
+	for w, c := range counts {
+		wc = append(wc, WordCount{w, c})
+	}
+
+	sort.Slice(wc, func(i, j int) bool {
+		if wc[i].Count != wc[j].Count {
+			return wc[i].Count > wc[j].Count
+		}
+		return wc[i].Word < wc[j].Word
+	})
+
+	if n > 0 && n < len(wc) {
+		wc = wc[:n]
+	}
+
+	return wc
+}
+
+// Top returns the n most frequent words of a page, all words for n <= 0.
+func (ps PageWordStats) Top(n int) []WordCount {
+	return topWords(ps.Counts, n)
+}
+
+// Top returns the n most frequent words of a document, all words for n <= 0.
+func (ws WordStats) Top(n int) []WordCount {
+	return topWords(ws.Counts, n)
+}
+
+// Words breaks extracted text into lower case words consisting of letters and digits.
+// Words hyphenated at line ends are joined.
+func Words(s string) []string {
+
+	s = strings.NewReplacer("-\r\n", "", "-\n", "", "\u00ad\n", "", "\u00ad", "").Replace(s)
+
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+}
+
+func countWords(s string) (int, map[string]int) {
+
+	n, m := 0, map[string]int{}
+
+	for _, w := range Words(s) {
+		w = strings.Trim(w, "'")
+		if w == "" {
+			continue
+		}
+		m[w]++
+		n++
+	}
+
+	return n, m
+}
+
+// WordFrequencies returns per page and document wide word counts of the text of pages.
+func WordFrequencies(xRefTable *XRefTable, pageNrs []int) (*WordStats, error) {
+
+	ws := &WordStats{Pages: []PageWordStats{}, Counts: map[string]int{}}
+
+	for _, p := range pageNrs {
+
+		if p < 1 || p > xRefTable.PageCount {
+			return nil, NewError(ErrPageOutOfRange, "invalid page number: %d", p)
+		}
+
+		glyphs, err := pageGlyphs(xRefTable, p)
+		if err != nil {
+			return nil, err
+		}
+
+		n, m := countWords(glyphsText(glyphs))
+
+		ws.Pages = append(ws.Pages, PageWordStats{Page: p, Words: n, Counts: m})
+
+		ws.Words += n
+		for w, c := range m {
+			ws.Counts[w] += c
+		}
+	}
+
+	return ws, nil
+}
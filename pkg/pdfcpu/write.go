@@ -17,11 +17,13 @@ limitations under the License.
 package pdfcpu
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/hex"
 	"fmt"
+	"io/fs"
+	"math/rand"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 
@@ -29,39 +31,54 @@ import (
 	"github.com/pkg/errors"
 )
 
+// Xref table entries hold 10 digit offsets, larger files need an xref stream.
+const maxXRefTableOffset = 9999999999
+
 // Write generates a PDF file for the cross reference table contained in Context.
-func Write(ctx *Context) error {
+//
+// Unless a writer has been supplied the file gets written to a temporary file in the destination dir
+// which replaces the destination only once writing succeeded.
+func Write(ctx *Context) (err error) {
 
-	var file *os.File
-	var err error
+	var file WritableFile
 
 	// Create a writer for dirname and filename if not already supplied.
 	if ctx.Write.Writer == nil {
 
-		fileName := ctx.Write.DirName + ctx.Write.FileName
+		fsys := ctx.FileSystem()
+		fileName := ctx.Write.FilePath()
 
 		log.Info.Printf("writing to %s\n", fileName)
 
-		file, err = os.Create(fileName)
+		var tmpName string
+
+		file, tmpName, err = createTempFile(fsys, fileName)
 		if err != nil {
 			return errors.Wrapf(err, "can't create %s\n%s", fileName, err)
 		}
 
-		ctx.Write.Writer = bufio.NewWriter(file)
+		ctx.Write.Writer = newBufioWriter(file)
 
 		defer func() {
 
 			// The underlying bufio.Writer has already been flushed.
+			putBufioWriter(ctx.Write.Writer)
+			ctx.Write.Writer = nil
 
 			// Processing error takes precedence.
 			if err != nil {
 				file.Close()
+				fsys.Remove(tmpName)
 				return
 			}
 
 			// Do not miss out on closing errors.
-			err = file.Close()
+			if err = file.Close(); err != nil {
+				fsys.Remove(tmpName)
+				return
+			}
 
+			err = replaceFile(fsys, tmpName, fileName, ctx.WriteBackup)
 		}()
 
 	}
@@ -71,9 +88,9 @@ func Write(ctx *Context) error {
 		return err
 	}
 
-	// Since we support PDF Collections (since V1.7) for file attachments
-	// we need to always generate V1.7 PDF filess.
-	err = writeHeader(ctx.Write, V17)
+	// Unless asked for a lower version we generate V1.7 PDF files,
+	// since we support PDF Collections (since V1.7) for file attachments.
+	err = writeHeader(ctx.Write, ctx.targetVersion())
 	if err != nil {
 		return err
 	}
@@ -116,6 +133,13 @@ func Write(ctx *Context) error {
 	// eg. duplicate resources, compressed objects, linearization dicts..
 	deleteRedundantObjects(ctx)
 
+	if ctx.Write.writerAt != nil {
+		err = writeDeferredObjectStreams(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
 	err = writeXRef(ctx)
 	if err != nil {
 		return err
@@ -132,6 +156,13 @@ func Write(ctx *Context) error {
 		return err
 	}
 
+	if ctx.Write.writerAt != nil {
+		err = writeSegments(ctx.Write)
+		if err != nil {
+			return err
+		}
+	}
+
 	if ctx.Read != nil {
 		ctx.Write.BinaryImageSize = ctx.Read.BinaryImageSize
 		ctx.Write.BinaryFontSize = ctx.Read.BinaryFontSize
@@ -148,7 +179,31 @@ func prepareContextForWriting(ctx *Context) error {
 		return err
 	}
 
-	return handleEncryption(ctx)
+	// Single page writes only keep the path down to the page anyway.
+	if ctx.PageTreeFanOut > 0 && ctx.Write.ExtractPageNr == 0 {
+		err = ctx.RebalancePageTree(ctx.PageTreeFanOut)
+		if err != nil {
+			return err
+		}
+	}
+
+	if ctx.FlattenTransparency {
+		err = FlattenTransparency(ctx.XRefTable)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = applyWriteHooks(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = handleEncryption(ctx); err != nil {
+		return err
+	}
+
+	return prepareTargetVersion(ctx)
 }
 
 func writeAdditionalStreams(ctx *Context) error {
@@ -257,6 +312,25 @@ func writePages(ctx *Context, rootDict Dict) error {
 	return stopObjectStream(ctx)
 }
 
+// keepDestsNameTree reduces the name dictionary of the catalog d to the destinations name tree.
+func keepDestsNameTree(xRefTable *XRefTable, d Dict) error {
+
+	namesDict, err := xRefTable.DereferenceDict(d["Names"])
+	if err != nil {
+		return err
+	}
+
+	o, found := namesDict.Find("Dests")
+	if !found {
+		d.Delete("Names")
+		return nil
+	}
+
+	d.Update("Names", Dict(map[string]Object{"Dests": o}))
+
+	return nil
+}
+
 func writeRootObject(ctx *Context) error {
 
 	// => 7.7.2 Document Catalog
@@ -289,11 +363,28 @@ func writeRootObject(ctx *Context) error {
 
 	if ctx.Write.ReducedFeatureSet() {
 		log.Write.Println("writeRootObject: exclude complex entries on split,trim and page extraction.")
-		d.Delete("Names")
-		d.Delete("Dests")
-		d.Delete("Outlines")
+		if ctx.Write.Command == "Merge" {
+			// Merging takes care of named destinations, see mergeNamedDests.
+			if err = keepDestsNameTree(xRefTable, d); err != nil {
+				return err
+			}
+		} else {
+			d.Delete("Names")
+			d.Delete("Dests")
+		}
+		switch {
+		case ctx.Write.Command == "Merge" && ctx.MergeOutlines != OutlinesNone:
+			// Merging takes care of outlines, see mergeOutlines.
+		case ctx.Write.Command == "Split" && ctx.SplitDocumentData:
+			// Outlines are limited to the page, see KeepDocumentDataForPage.
+		default:
+			d.Delete("Outlines")
+		}
 		d.Delete("OpenAction")
-		d.Delete("AcroForm")
+		if ctx.Write.Command != "Merge" {
+			// Merging takes care of interactive forms, see mergeAcroForms.
+			d.Delete("AcroForm")
+		}
 		d.Delete("StructTreeRoot")
 		d.Delete("OCProperties")
 	}
@@ -362,6 +453,7 @@ func writeRootObject(ctx *Context) error {
 		{"Requirements", RootRequirements},
 		{"Collection", RootCollection},
 		{"NeedsRendering", RootNeedsRendering},
+		{"AF", RootAF},
 	} {
 		err = writeRootEntry(ctx, d, dictName, e.entryName, e.statsAttr)
 		if err != nil {
@@ -374,7 +466,9 @@ func writeRootObject(ctx *Context) error {
 	return nil
 }
 
-func writeTrailerDict(ctx *Context) error {
+// writeTrailerDict writes the trailer dict of a xref table,
+// xRefStm is the offset of the xref stream of a hybrid file or nil.
+func writeTrailerDict(ctx *Context, xRefStm *int64) error {
 
 	log.Write.Printf("writeTrailerDict begin\n")
 
@@ -407,6 +501,10 @@ func writeTrailerDict(ctx *Context) error {
 		d.Insert("ID", xRefTable.ID)
 	}
 
+	if xRefStm != nil {
+		d.Insert("XRefStm", Integer(*xRefStm))
+	}
+
 	_, err = w.WriteString(d.PDFString())
 	if err != nil {
 		return err
@@ -417,7 +515,9 @@ func writeTrailerDict(ctx *Context) error {
 	return nil
 }
 
-func writeXRefSubsection(ctx *Context, start int, size int) error {
+// writeXRefSubsection writes the xref table entries for objects start..start+size-1.
+// Objects compressed into object streams are only allowed for hybrid files and show up as free entries.
+func writeXRefSubsection(ctx *Context, start int, size int, hybrid bool) error {
 
 	log.Write.Printf("writeXRefSubsection: start=%d size=%d\n", start, size)
 
@@ -434,13 +534,16 @@ func writeXRefSubsection(ctx *Context, start int, size int) error {
 
 		entry := ctx.XRefTable.Table[i]
 
-		if entry.Compressed {
+		if entry.Compressed && !hybrid {
 			return errors.New("writeXRefSubsection: compressed entries present")
 		}
 
 		var s string
 
-		if entry.Free {
+		if entry.Compressed {
+			// Hidden object, never to be reused by readers not supporting xref streams.
+			s = fmt.Sprintf("%010d %05d f%2s", 0, 65535, w.Eol)
+		} else if entry.Free {
 			s = fmt.Sprintf("%010d %05d f%2s", *entry.Offset, *entry.Generation, w.Eol)
 		} else {
 			var off int64
@@ -448,6 +551,9 @@ func writeXRefSubsection(ctx *Context, start int, size int) error {
 			if found {
 				off = writeOffset
 			}
+			if off > maxXRefTableOffset {
+				return errors.Errorf("writeXRefSubsection: offset %d of obj #%d exceeds the limits of an xref table, use an xref stream", off, i)
+			}
 			s = fmt.Sprintf("%010d %05d n%2s", off, *entry.Generation, w.Eol)
 		}
 
@@ -566,12 +672,19 @@ func writeXRefTable(ctx *Context) error {
 		return err
 	}
 
-	keys := sortedWritableKeys(ctx)
+	return writeXRefSection(ctx, sortedWritableKeys(ctx), nil)
+}
+
+// writeXRefSection writes a xref table for keys followed by the trailer,
+// xRefStm is the offset of the xref stream of a hybrid file or nil.
+func writeXRefSection(ctx *Context, keys []int, xRefStm *int64) error {
 
 	objCount := len(keys)
 	log.Write.Printf("xref has %d entries\n", objCount)
 
-	_, err = ctx.Write.WriteString("xref")
+	hybrid := xRefStm != nil
+
+	_, err := ctx.Write.WriteString("xref")
 	if err != nil {
 		return err
 	}
@@ -588,7 +701,7 @@ func writeXRefTable(ctx *Context) error {
 
 		if keys[i]-keys[i-1] > 1 {
 
-			err = writeXRefSubsection(ctx, start, size)
+			err = writeXRefSubsection(ctx, start, size, hybrid)
 			if err != nil {
 				return err
 			}
@@ -601,12 +714,12 @@ func writeXRefTable(ctx *Context) error {
 		size++
 	}
 
-	err = writeXRefSubsection(ctx, start, size)
+	err = writeXRefSubsection(ctx, start, size, hybrid)
 	if err != nil {
 		return err
 	}
 
-	err = writeTrailerDict(ctx)
+	err = writeTrailerDict(ctx, xRefStm)
 	if err != nil {
 		return err
 	}
@@ -660,7 +773,8 @@ func int64ToBuf(i int64, byteCount int) (buf []byte) {
 	return
 }
 
-func createXRefStream(ctx *Context, i1, i2, i3 int) ([]byte, *Array, error) {
+// createXRefStream returns the xref stream data and the Index array for the entries of keys.
+func createXRefStream(ctx *Context, keys []int, i1, i2, i3 int) ([]byte, *Array, error) {
 
 	log.Write.Println("createXRefStream begin")
 
@@ -671,14 +785,6 @@ func createXRefStream(ctx *Context, i1, i2, i3 int) ([]byte, *Array, error) {
 		a   Array
 	)
 
-	var keys []int
-	for i, e := range xRefTable.Table {
-		if e.Free || ctx.Write.HasWriteOffset(i) {
-			keys = append(keys, i)
-		}
-	}
-	sort.Ints(keys)
-
 	objCount := len(keys)
 	log.Write.Printf("createXRefStream: xref has %d entries\n", objCount)
 
@@ -752,6 +858,15 @@ func createXRefStream(ctx *Context, i1, i2, i3 int) ([]byte, *Array, error) {
 	return buf, &a, nil
 }
 
+// byteCount returns the number of bytes needed to represent i.
+func byteCount(i int64) (c int) {
+	for i > 0 {
+		i >>= 8
+		c++
+	}
+	return c
+}
+
 func writeXRefStream(ctx *Context) error {
 
 	log.Write.Println("writeXRefStream begin")
@@ -783,13 +898,7 @@ func writeXRefStream(ctx *Context) error {
 
 	i1 := 1 // 0, 1 or 2 always fit into 1 byte.
 
-	i2 := func(i int64) (byteCount int) {
-		for i > 0 {
-			i >>= 8
-			byteCount++
-		}
-		return byteCount
-	}(i2Base)
+	i2 := byteCount(i2Base)
 
 	i3 := 2 // scale for max objectstream index <= 0x ff ff
 
@@ -797,7 +906,7 @@ func writeXRefStream(ctx *Context) error {
 	xRefStreamDict.Insert("W", wArr)
 
 	// Generate xRefStreamDict data = xref entries -> xRefStreamDict.Content
-	content, indArr, err := createXRefStream(ctx, i1, i2, i3)
+	content, indArr, err := createXRefStream(ctx, sortedWritableKeys(ctx), i1, i2, i3)
 	if err != nil {
 		return err
 	}
@@ -871,14 +980,23 @@ func writeEncryptDict(ctx *Context) error {
 
 func setupEncryption(ctx *Context) error {
 
-	var err error
-
 	d := newEncryptDict(
 		ctx.EncryptUsingAES,
 		ctx.EncryptUsing128BitKey,
+		ctx.EncryptMetadata,
 		ctx.UserAccessPermissions,
 	)
 
+	err := checkEncryptionStrength(ctx, d)
+	if err != nil {
+		return err
+	}
+
+	err = checkPasswordPolicy(ctx)
+	if err != nil {
+		return err
+	}
+
 	ctx.E, err = supportedEncryption(ctx, d)
 	if err != nil {
 		return err
@@ -924,6 +1042,10 @@ func setupEncryption(ctx *Context) error {
 
 	ctx.Encrypt = NewIndirectRef(objNumber, 0)
 
+	for _, s := range EncryptionReport(ctx) {
+		log.Info.Printf("encrypt: %s\n", s)
+	}
+
 	return nil
 }
 
@@ -934,6 +1056,11 @@ func updateEncryption(ctx *Context) error {
 		return err
 	}
 
+	err = checkPasswordPolicy(ctx)
+	if err != nil {
+		return err
+	}
+
 	if ctx.Mode == ADDPERMISSIONS {
 		//fmt.Printf("updating permissions to: %v\n", ctx.UserAccessPermissions)
 		ctx.E.P = int(ctx.UserAccessPermissions)
@@ -976,6 +1103,10 @@ func updateEncryption(ctx *Context) error {
 
 func handleEncryption(ctx *Context) error {
 
+	if err := ctx.validateEncryption(); err != nil {
+		return err
+	}
+
 	if ctx.Mode == ENCRYPT || ctx.Mode == DECRYPT {
 
 		if ctx.Mode == DECRYPT {
@@ -1001,6 +1132,11 @@ func handleEncryption(ctx *Context) error {
 
 	}
 
+	err := checkEncryptionPolicy(ctx)
+	if err != nil {
+		return err
+	}
+
 	// write xrefstream if using xrefstream only.
 	if ctx.Encrypt != nil && ctx.EncKey != nil && !ctx.Read.UsingXRefStreams {
 		ctx.WriteObjectStream = false
@@ -1010,8 +1146,85 @@ func handleEncryption(ctx *Context) error {
 	return nil
 }
 
+// writeHybridXRef writes a xref stream for all objects compressed into object streams
+// followed by a xref table for all remaining objects pointing to it via XRefStm.
+// See 7.5.8.4 Compatibility with Applications That Do Not Support Compressed Reference Streams.
+// Readers not supporting xref streams see the compressed objects as free.
+func writeHybridXRef(ctx *Context) error {
+
+	log.Write.Println("writeHybridXRef begin")
+
+	xRefTable := ctx.XRefTable
+
+	var compressed []int
+	maxObjStream := 0
+
+	for _, i := range sortedWritableKeys(ctx) {
+		if e := xRefTable.Table[i]; e.Compressed {
+			compressed = append(compressed, i)
+			if *e.ObjectStream > maxObjStream {
+				maxObjStream = *e.ObjectStream
+			}
+		}
+	}
+
+	if len(compressed) == 0 {
+		// Nothing to hide.
+		return writeXRefTable(ctx)
+	}
+
+	xRefStreamDict := NewXRefStreamDict(ctx)
+	xRefTableEntry := NewXRefTableEntryGen0(*xRefStreamDict)
+
+	objNumber, err := xRefTable.InsertAndUseRecycled(*xRefTableEntry)
+	if err != nil {
+		return err
+	}
+
+	err = xRefTable.EnsureValidFreeList()
+	if err != nil {
+		return err
+	}
+
+	xRefStreamDict.Insert("Size", Integer(*xRefTable.Size))
+
+	// The xref stream only covers compressed objects.
+	i1, i2, i3 := 1, byteCount(int64(maxObjStream)), 2
+
+	xRefStreamDict.Insert("W", Array{Integer(i1), Integer(i2), Integer(i3)})
+
+	content, indArr, err := createXRefStream(ctx, compressed, i1, i2, i3)
+	if err != nil {
+		return err
+	}
+
+	xRefStreamDict.Content = content
+	xRefStreamDict.Insert("Index", *indArr)
+
+	err = encodeStream(&xRefStreamDict.StreamDict)
+	if err != nil {
+		return err
+	}
+
+	offset := ctx.Write.Offset
+
+	err = writeStreamDictObject(ctx, objNumber, 0, xRefStreamDict.StreamDict)
+	if err != nil {
+		return err
+	}
+
+	log.Write.Println("writeHybridXRef end")
+
+	return writeXRefSection(ctx, sortedWritableKeys(ctx), &offset)
+}
+
 func writeXRef(ctx *Context) error {
 
+	if ctx.WriteXRefStream && ctx.WriteHybridXRef {
+		// Write cross reference stream for compressed objects and a cross reference table section for all others.
+		return writeHybridXRef(ctx)
+	}
+
 	if ctx.WriteXRefStream {
 		// Write cross reference stream and generate objectstreams.
 		return writeXRefStream(ctx)
@@ -1021,7 +1234,64 @@ func writeXRef(ctx *Context) error {
 	return writeXRefTable(ctx)
 }
 
-func setFileSizeOfWrittenFile(w *WriteContext, f *os.File) error {
+// createTempFile creates a temporary file of fsys in the dir of fileName using the permissions of an existing fileName.
+// Returns the file and its name.
+func createTempFile(fsys FileSystem, fileName string) (WritableFile, string, error) {
+
+	dir, base := filepath.Split(fileName)
+
+	perm := os.FileMode(0644)
+	if fi, err := fs.Stat(fsys, fileName); err == nil {
+		perm = fi.Mode().Perm()
+	}
+
+	for i := 0; ; i++ {
+
+		tmpName := filepath.Join(dir, fmt.Sprintf(".%s.%d.tmp", base, rand.Uint32()))
+
+		f, err := fsys.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+		if err != nil {
+			if os.IsExist(err) && i < 10 {
+				continue
+			}
+			return nil, "", err
+		}
+
+		// Not subject to umask.
+		if c, ok := f.(interface{ Chmod(fs.FileMode) error }); ok {
+			if err = c.Chmod(perm); err != nil {
+				f.Close()
+				fsys.Remove(tmpName)
+				return nil, "", err
+			}
+		}
+
+		return f, tmpName, nil
+	}
+}
+
+// replaceFile renames tmpName to fileName.
+// If backup is true an existing fileName is kept as fileName.bak.
+func replaceFile(fsys FileSystem, tmpName, fileName string, backup bool) error {
+
+	if backup {
+		if _, err := fs.Stat(fsys, fileName); err == nil {
+			if err = fsys.Rename(fileName, fileName+".bak"); err != nil {
+				fsys.Remove(tmpName)
+				return errors.Wrapf(err, "can't backup %s", fileName)
+			}
+		}
+	}
+
+	if err := fsys.Rename(tmpName, fileName); err != nil {
+		fsys.Remove(tmpName)
+		return errors.Wrapf(err, "can't write %s", fileName)
+	}
+
+	return nil
+}
+
+func setFileSizeOfWrittenFile(w *WriteContext, f WritableFile) error {
 
 	err := w.Flush()
 	if err != nil {
@@ -1035,7 +1305,13 @@ func setFileSizeOfWrittenFile(w *WriteContext, f *os.File) error {
 
 	// Writing is file based.
 
-	fileInfo, err := f.Stat()
+	s, ok := f.(interface{ Stat() (fs.FileInfo, error) })
+	if !ok {
+		w.FileSize = w.Offset
+		return nil
+	}
+
+	fileInfo, err := s.Stat()
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,269 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pdfcpu
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/jplu/pdfcpu/pkg/log"
+	"github.com/pkg/errors"
+)
+
+// A writeSegment is a contiguous part of a PDF file written via io.WriterAt.
+type writeSegment struct {
+	offset     int64         // file offset, excluding any preceding deferred object streams until relocated.
+	buf        *bytes.Buffer // serialized content.
+	objNr      int           // object number of a deferred object stream, 0 otherwise.
+	sd         StreamDict    // deferred object stream pending encoding.
+	binarySize int64         // stream data of a deferred object stream.
+}
+
+// WriteAt generates a PDF file for the cross reference table contained in Context using an io.WriterAt.
+//
+// Object streams get encoded concurrently once all objects have been serialized.
+// The resulting file segments get written concurrently at precomputed offsets.
+func WriteAt(ctx *Context, wa io.WriterAt) error {
+
+	if wa == nil {
+		return errors.New("WriteAt: missing io.WriterAt")
+	}
+
+	ctx.Write.writerAt = wa
+	ctx.Write.segments = nil
+
+	defer func() {
+		ctx.Write.Writer = nil
+		ctx.Write.writerAt = nil
+		ctx.Write.segments = nil
+	}()
+
+	startSegment(ctx.Write)
+
+	return Write(ctx)
+}
+
+// Start a new segment at the current write offset and redirect all writing into it.
+func startSegment(w *WriteContext) {
+
+	s := &writeSegment{offset: w.Offset, buf: &bytes.Buffer{}}
+	w.segments = append(w.segments, s)
+	w.Writer = newBufioWriter(s.buf)
+}
+
+// Record an object stream for concurrent encoding and continue with a new segment.
+func deferObjectStream(w *WriteContext, objNr int, sd StreamDict) error {
+
+	log.Write.Printf("deferObjectStream: obj#%d at offset %d\n", objNr, w.Offset)
+
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+
+	putBufioWriter(w.Writer)
+
+	w.segments = append(w.segments, &writeSegment{offset: w.Offset, buf: &bytes.Buffer{}, objNr: objNr, sd: sd})
+
+	startSegment(w)
+
+	return nil
+}
+
+// Encode a deferred object stream and serialize it into its segment.
+func serializeObjectStream(ctx *Context, s *writeSegment) error {
+
+	err := encodeStream(&s.sd)
+	if err != nil {
+		return err
+	}
+
+	// Serialize using a private write context sharing everything else.
+	c := *ctx
+	c.Write = NewWriteContext(ctx.Write.Eol)
+	c.Write.Writer = newBufioWriter(s.buf)
+
+	err = writeStreamDictObject(&c, s.objNr, 0, s.sd)
+	if err != nil {
+		return err
+	}
+
+	s.binarySize = c.Write.BinaryTotalSize
+
+	// Release memory.
+	s.sd.Content = nil
+	s.sd.Raw = nil
+
+	err = c.Write.Flush()
+	if err != nil {
+		return err
+	}
+
+	putBufioWriter(c.Write.Writer)
+
+	return nil
+}
+
+// Relocate the write offsets of all objects serialized so far
+// in order to make up for the size of the preceding deferred object streams.
+func relocateWriteOffsets(w *WriteContext) int64 {
+
+	var delta int64
+
+	// The segment offsets are in ascending order.
+	// Deferred object streams do not take up any space until relocated.
+	var starts, deltas []int64
+	deferred := map[int]int64{}
+
+	for _, s := range w.segments {
+		if s.objNr > 0 {
+			deferred[s.objNr] = s.offset + delta
+			delta += int64(s.buf.Len())
+			continue
+		}
+		starts = append(starts, s.offset)
+		deltas = append(deltas, delta)
+	}
+
+	for objNr, off := range w.Table {
+		if _, ok := deferred[objNr]; ok {
+			continue
+		}
+		// Locate the last segment starting at or before off.
+		for i := len(starts) - 1; i >= 0; i-- {
+			if starts[i] <= off {
+				w.Table[objNr] = off + deltas[i]
+				break
+			}
+		}
+	}
+
+	for objNr, off := range deferred {
+		w.Table[objNr] = off
+	}
+
+	delta = 0
+	for _, s := range w.segments {
+		s.offset += delta
+		if s.objNr > 0 {
+			delta += int64(s.buf.Len())
+		}
+	}
+
+	return delta
+}
+
+// Encode all deferred object streams concurrently, fix all write offsets and write everything serialized so far.
+func writeDeferredObjectStreams(ctx *Context) error {
+
+	log.Write.Println("writeDeferredObjectStreams begin")
+
+	w := ctx.Write
+
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+
+	errs := make([]error, len(w.segments))
+
+	var wg sync.WaitGroup
+
+	for i, s := range w.segments {
+		if s.objNr == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, s *writeSegment) {
+			defer wg.Done()
+			errs[i] = serializeObjectStream(ctx, s)
+		}(i, s)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, s := range w.segments {
+		w.BinaryTotalSize += s.binarySize
+		w.ObjectStreamEncodedSize += s.binarySize
+	}
+
+	w.Offset += relocateWriteOffsets(w)
+
+	err = writeSegments(w)
+	if err != nil {
+		return err
+	}
+
+	// Continue with a new segment for the cross reference section and the trailer.
+	startSegment(w)
+
+	log.Write.Println("writeDeferredObjectStreams end")
+
+	return nil
+}
+
+// Write all pending segments concurrently to their file offsets.
+func writeSegments(w *WriteContext) error {
+
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+
+	putBufioWriter(w.Writer)
+	w.Writer = nil
+
+	errs := make([]error, len(w.segments))
+
+	var wg sync.WaitGroup
+
+	for i, s := range w.segments {
+
+		if end := s.offset + int64(s.buf.Len()); end > w.FileSize {
+			w.FileSize = end
+		}
+
+		if s.buf.Len() == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, s *writeSegment) {
+			defer wg.Done()
+			_, errs[i] = w.writerAt.WriteAt(s.buf.Bytes(), s.offset)
+		}(i, s)
+	}
+
+	wg.Wait()
+
+	w.segments = nil
+
+	for _, err := range errs {
+		if err != nil {
+			return errors.Wrap(err, "writeSegments")
+		}
+	}
+
+	return nil
+}
@@ -62,6 +62,33 @@ func writeObjectTrailer(w *WriteContext) (int, error) {
 	return w.WriteString(fmt.Sprintf("%sendobj%s", w.Eol, w.Eol))
 }
 
+// maxObjectStreamObjects returns the number of objects an object stream written may hold.
+func maxObjectStreamObjects(ctx *Context) int {
+
+	if ctx.MaxObjectsPerObjectStream > 0 {
+		return ctx.MaxObjectsPerObjectStream
+	}
+
+	return ObjectStreamMaxObjects
+}
+
+// excludedFromObjectStream returns true if d is of a type to be kept out of object streams.
+func excludedFromObjectStream(ctx *Context, d Dict) bool {
+
+	t := d.Type()
+	if t == nil {
+		return false
+	}
+
+	for _, s := range ctx.ObjectStreamExcludedTypes {
+		if s == *t {
+			return true
+		}
+	}
+
+	return false
+}
+
 func startObjectStream(ctx *Context) error {
 
 	// See 7.5.7 Object streams
@@ -105,29 +132,47 @@ func stopObjectStream(ctx *Context) error {
 	// When we are ready to write: append prolog and content
 	osd.Finalize()
 
-	// Encode objStreamDict.Content -> objStreamDict.Raw
-	// and wipe (decoded) content to free up memory.
-	err := encodeStream(&osd.StreamDict)
-	if err != nil {
-		return err
-	}
-
-	// Release memory.
-	osd.Content = nil
-
 	osd.StreamDict.Insert("First", Integer(osd.FirstObjOffset))
 	osd.StreamDict.Insert("N", Integer(osd.ObjCount))
 
-	// for each objStream execute at the end right before xRefStreamDict gets written.
-	log.Write.Printf("stopObjectStream: objStreamDict: %s\n", osd)
+	w := ctx.Write
+	w.ObjectStreams++
+	w.ObjectStreamObjects += osd.ObjCount
+	w.ObjectStreamSize += int64(len(osd.Content))
 
-	err = writeStreamDictObject(ctx, *ctx.Write.CurrentObjStream, 0, osd.StreamDict)
-	if err != nil {
-		return err
-	}
+	if ctx.Write.writerAt != nil {
 
-	// Release memory.
-	osd.Raw = nil
+		// Encoding and writing gets done concurrently right before the xref stream gets written.
+		err := deferObjectStream(ctx.Write, *ctx.Write.CurrentObjStream, osd.StreamDict)
+		if err != nil {
+			return err
+		}
+
+	} else {
+
+		// Encode objStreamDict.Content -> objStreamDict.Raw
+		// and wipe (decoded) content to free up memory.
+		err := encodeStream(&osd.StreamDict)
+		if err != nil {
+			return err
+		}
+
+		w.ObjectStreamEncodedSize += int64(len(osd.Raw))
+
+		// Release memory.
+		osd.Content = nil
+
+		// for each objStream execute at the end right before xRefStreamDict gets written.
+		log.Write.Printf("stopObjectStream: objStreamDict: %s\n", osd)
+
+		err = writeStreamDictObject(ctx, *ctx.Write.CurrentObjStream, 0, osd.StreamDict)
+		if err != nil {
+			return err
+		}
+
+		// Release memory.
+		osd.Raw = nil
+	}
 
 	ctx.Write.CurrentObjStream = nil
 	ctx.Write.WriteToObjectStream = false
@@ -181,7 +226,7 @@ func writeToObjectStream(ctx *Context, objNumber, genNumber int) (ok bool, err e
 
 		log.Write.Printf("writeObject end, obj#%d written to objectStream #%d\n", objNumber, *ctx.Write.CurrentObjStream)
 
-		if objStreamDict.ObjCount == ObjectStreamMaxObjects {
+		if objStreamDict.ObjCount == maxObjectStreamObjects(ctx) {
 			err = stopObjectStream(ctx)
 			if err != nil {
 				return false, err
@@ -290,7 +335,7 @@ func writeStringLiteralObject(ctx *Context, objNumber, genNumber int, stringLite
 
 	sl := stringLiteral
 
-	if ctx.EncKey != nil {
+	if ctx.stringsEncrypted() {
 		s1, err := encryptString(ctx.AES4Strings, stringLiteral.Value(), objNumber, genNumber, ctx.EncKey)
 		if err != nil {
 			return err
@@ -315,13 +360,13 @@ func writeHexLiteralObject(ctx *Context, objNumber, genNumber int, hexLiteral He
 
 	hl := hexLiteral
 
-	if ctx.EncKey != nil {
-		s1, err := encryptString(ctx.AES4Strings, hexLiteral.Value(), objNumber, genNumber, ctx.EncKey)
+	if ctx.stringsEncrypted() {
+		hl1, err := encryptHexLiteral(ctx.AES4Strings, hexLiteral, objNumber, genNumber, ctx.EncKey)
 		if err != nil {
 			return err
 		}
 
-		hl = HexLiteral(*s1)
+		hl = *hl1
 	}
 
 	return writeObject(ctx, objNumber, genNumber, hl.PDFString())
@@ -357,16 +402,19 @@ func writeFloatObject(ctx *Context, objNumber, genNumber int, float Float) error
 
 func writeDictObject(ctx *Context, objNumber, genNumber int, d Dict) error {
 
-	ok, err := writeToObjectStream(ctx, objNumber, genNumber)
-	if err != nil {
-		return err
-	}
+	if !excludedFromObjectStream(ctx, d) {
 
-	if ok {
-		return nil
+		ok, err := writeToObjectStream(ctx, objNumber, genNumber)
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			return nil
+		}
 	}
 
-	if ctx.EncKey != nil {
+	if ctx.stringsEncrypted() {
 		_, err := encryptDeepObject(d, objNumber, genNumber, ctx.EncKey, ctx.AES4Strings)
 		if err != nil {
 			return err
@@ -387,7 +435,7 @@ func writeArrayObject(ctx *Context, objNumber, genNumber int, a Array) error {
 		return nil
 	}
 
-	if ctx.EncKey != nil {
+	if ctx.stringsEncrypted() {
 		_, err := encryptDeepObject(a, objNumber, genNumber, ctx.EncKey, ctx.AES4Strings)
 		if err != nil {
 			return err
@@ -447,6 +495,17 @@ func writeStreamDictObject(ctx *Context, objNumber, genNumber int, sd StreamDict
 
 	log.Write.Printf("writeStreamDictObject begin: object #%d\n%v", objNumber, sd)
 
+	if sd.source != nil {
+		if ctx.EncKey == nil {
+			return writeStreamedStreamDictObject(ctx, objNumber, genNumber, sd)
+		}
+		// Encryption needs the entire content.
+		err := loadStreamSource(ctx.XRefTable, &sd)
+		if err != nil {
+			return err
+		}
+	}
+
 	var inObjStream bool
 
 	if ctx.Write.WriteToObjectStream == true {
@@ -464,11 +523,7 @@ func writeStreamDictObject(ctx *Context, objNumber, genNumber int, sd StreamDict
 
 	var err error
 
-	// Unless the "Identity" crypt filter is used we have to encrypt.
-	isXRefStreamDict := sd.Type() != nil && *sd.Type() == "XRef"
-	if ctx.EncKey != nil &&
-		!isXRefStreamDict &&
-		!(len(sd.FilterPipeline) == 1 && sd.FilterPipeline[0].Name == "Crypt") {
+	if ctx.streamEncrypted(sd) {
 
 		sd.Raw, err = encryptStream(ctx.AES4Streams, sd.Raw, objNumber, genNumber, ctx.EncKey)
 		if err != nil {
@@ -523,8 +578,8 @@ func writeDirectObject(ctx *Context, o Object) error {
 	switch o := o.(type) {
 
 	case Dict:
-		for _, v := range o {
-			_, _, err := writeDeepObject(ctx, v)
+		for _, k := range sortedKeys(o) {
+			_, _, err := writeDeepObject(ctx, o[k])
 			if err != nil {
 				return err
 			}
@@ -568,8 +623,8 @@ func writeDeepDict(ctx *Context, d Dict, objNr, genNr int) error {
 		return err
 	}
 
-	for _, v := range d {
-		_, _, err = writeDeepObject(ctx, v)
+	for _, k := range sortedKeys(d) {
+		_, _, err = writeDeepObject(ctx, d[k])
 		if err != nil {
 			return err
 		}
@@ -580,7 +635,7 @@ func writeDeepDict(ctx *Context, d Dict, objNr, genNr int) error {
 
 func writeDeepStreamDict(ctx *Context, sd *StreamDict, objNr, genNr int) error {
 
-	if ctx.EncKey != nil {
+	if ctx.stringsEncrypted() {
 		_, err := encryptDeepObject(*sd, objNr, genNr, ctx.EncKey, ctx.AES4Strings)
 		if err != nil {
 			return err
@@ -592,8 +647,8 @@ func writeDeepStreamDict(ctx *Context, sd *StreamDict, objNr, genNr int) error {
 		return err
 	}
 
-	for _, v := range sd.Dict {
-		_, _, err = writeDeepObject(ctx, v)
+	for _, k := range sortedKeys(sd.Dict) {
+		_, _, err = writeDeepObject(ctx, sd.Dict[k])
 		if err != nil {
 			return err
 		}
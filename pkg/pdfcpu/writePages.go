@@ -46,7 +46,8 @@ func writePageDict(ctx *Context, ir *IndirectRef, pageDict Dict) error {
 	dictName := "pageDict"
 
 	// For extracted pages we do not generate Annotations.
-	if ctx.Write.ReducedFeatureSet() {
+	// Merged pages keep theirs for links and form fields, see mergeNamedDests and mergeAcroForms.
+	if ctx.Write.ReducedFeatureSet() && ctx.Write.Command != "Merge" {
 		pageDict.Delete("Annots")
 	}
 
@@ -93,6 +94,7 @@ func writePageDict(ctx *Context, ir *IndirectRef, pageDict Dict) error {
 		{"PresSteps", PagePresSteps},
 		{"UserUnit", PageUserUnit},
 		{"VP", PageVP},
+		{"AF", PageAF},
 	} {
 		err = writePageEntry(ctx, pageDict, dictName, e.entryName, e.statsAttr)
 		if err != nil {
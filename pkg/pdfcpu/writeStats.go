@@ -18,6 +18,7 @@ package pdfcpu
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -235,20 +236,22 @@ func AppendStatsFile(ctx *Context) error {
 
 	fileName := ctx.StatsFileName
 
+	fsys := ctx.FileSystem()
+
 	// if file does not exist, create file
-	file, err := os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, 0600)
+	file, err := fsys.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, 0600)
 	if err != nil {
 
 		if os.IsExist(err) {
 			return errors.Errorf("can't open %s\n%s", fileName, err)
 		}
 
-		file, err = os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+		file, err = fsys.OpenFile(fileName, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
 		if err != nil {
 			return errors.Errorf("can't create %s\n%s", fileName, err)
 		}
 
-		_, err = file.WriteString(*statsHeadLine())
+		_, err = io.WriteString(file, *statsHeadLine())
 		if err != nil {
 			return err
 		}
@@ -259,7 +262,7 @@ func AppendStatsFile(ctx *Context) error {
 		file.Close()
 	}()
 
-	_, err = file.WriteString(*statsLine(ctx))
+	_, err = io.WriteString(file, *statsLine(ctx))
 
 	return err
 }
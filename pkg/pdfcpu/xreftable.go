@@ -17,12 +17,15 @@ limitations under the License.
 package pdfcpu
 
 import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"io/ioutil"
-	"os"
+	"io/fs"
 	"path"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/jplu/pdfcpu/pkg/filter"
 	"github.com/jplu/pdfcpu/pkg/log"
@@ -86,6 +89,8 @@ type XRefTable struct {
 	EncKey              []byte // Encrypt key.
 	AES4Strings         bool
 	AES4Streams         bool
+	Identity4Strings    bool // StrF is the Identity crypt filter, strings are not encrypted.
+	Identity4Streams    bool // StmF is the Identity crypt filter, streams are not encrypted.
 	AES4EmbeddedStreams bool
 
 	// PDF Version
@@ -113,21 +118,42 @@ type XRefTable struct {
 	Tagged bool // File is using tags. This is important for ???
 
 	// Validation
-	Valid          bool // true means successful validated against ISO 32000.
-	ValidationMode int  // see Configuration
+	Valid           bool     // true means successful validated against ISO 32000.
+	ValidationMode  int      // see Configuration
+	SuppressedRules []string // see Configuration
 
 	Optimized bool
+
+	outlinesNested bool // The outlines of merged files are nested under entries per file.
+
+	fsys FileSystem // The file system for reading files like images and attachments.
+
+	now func() time.Time // The configured clock, see Configuration.CurrentTime.
+
+	warnings *warnings // Shared with clones.
 }
 
 // NewXRefTable creates a new XRefTable.
-func newXRefTable(validationMode int) (xRefTable *XRefTable) {
+func newXRefTable(config *Configuration) (xRefTable *XRefTable) {
 	return &XRefTable{
 		Table:             map[int]*XRefTableEntry{},
 		Names:             map[string]*Node{},
 		LinearizationObjs: IntSet{},
 		Stats:             NewPDFStats(),
-		ValidationMode:    validationMode,
+		ValidationMode:    config.ValidationMode,
+		SuppressedRules:   config.SuppressedRules,
+		warnings:          &warnings{hook: config.Hooks.OnWarning},
+		fsys:              config.FS,
+		now:               config.CurrentTime,
+	}
+}
+
+// currentTime returns the current time as seen by the configured clock.
+func (xRefTable *XRefTable) currentTime() time.Time {
+	if xRefTable.now == nil {
+		return time.Now()
 	}
+	return xRefTable.now()
 }
 
 // Version returns the PDF version of the PDF writer that created this file.
@@ -354,7 +380,7 @@ func (xRefTable *XRefTable) IndRefForNewObject(obj Object) (*IndirectRef, error)
 // NewStreamDict creates a streamDict for buf.
 func (xRefTable *XRefTable) NewStreamDict(filename string) (*StreamDict, error) {
 
-	buf, err := ioutil.ReadFile(filename)
+	buf, err := fs.ReadFile(xRefTable.fileSystem(), filename)
 	if err != nil {
 		return nil, err
 	}
@@ -378,15 +404,18 @@ func (xRefTable *XRefTable) NewEmbeddedFileStreamDict(filename string) (*StreamD
 		return nil, err
 	}
 
-	fi, err := os.Stat(filename)
+	fi, err := fs.Stat(xRefTable.fileSystem(), filename)
 	if err != nil {
 		return nil, err
 	}
 
 	sd.InsertName("Type", "EmbeddedFile")
 
+	sum := md5.Sum(sd.Content)
+
 	d := NewDict()
-	d.InsertInt("Size", int(fi.Size()))
+	d.InsertInt("Size", len(sd.Content))
+	d.Insert("CheckSum", HexLiteral(hex.EncodeToString(sum[:])))
 	d.Insert("ModDate", StringLiteral(DateString(fi.ModTime())))
 	sd.Insert("Params", d)
 
@@ -514,7 +543,7 @@ func (xRefTable *XRefTable) EnsureValidFreeList() error {
 	// insert remaining free objects into verified linked list
 	// unless they are forever deleted with generation 65535.
 	// In that case they have to point to obj 0.
-	for i := range m {
+	for _, i := range sortedIntKeys(m) {
 
 		entry, found := xRefTable.FindTableEntryLight(i)
 		if !found {
@@ -1502,6 +1531,29 @@ type InheritedPageAttrs struct {
 	rotate    float64
 }
 
+// Resources returns the resource dict in effect.
+func (a InheritedPageAttrs) Resources() Dict {
+	return a.resources
+}
+
+// MediaBox returns the media box in effect.
+func (a InheritedPageAttrs) MediaBox() Array {
+	return a.mediaBox
+}
+
+// CropBox returns the crop box in effect which defaults to the media box.
+func (a InheritedPageAttrs) CropBox() Array {
+	if a.cropBox == nil {
+		return a.mediaBox
+	}
+	return a.cropBox
+}
+
+// Rotate returns the rotation in effect in degrees.
+func (a InheritedPageAttrs) Rotate() int {
+	return int(a.rotate)
+}
+
 func (xRefTable *XRefTable) checkInheritedPageAttrs(pageDict Dict, pAttrs *InheritedPageAttrs) error {
 
 	var err error
@@ -1635,3 +1687,59 @@ func (xRefTable *XRefTable) PageDict(page int) (Dict, *InheritedPageAttrs, error
 
 	return pageDict, &inhPAttrs, nil
 }
+
+// PageContentDigest returns a SHA-256 digest of the decoded content of a specific page.
+// Content streams using unsupported filters contribute their encoded content.
+func (xRefTable *XRefTable) PageContentDigest(page int) ([]byte, error) {
+
+	pageDict, _, err := xRefTable.PageDict(page)
+	if err != nil {
+		return nil, err
+	}
+
+	if pageDict == nil {
+		return nil, errors.Errorf("PageContentDigest: missing page %d", page)
+	}
+
+	h := sha256.New()
+
+	o, err := xRefTable.Dereference(pageDict["Contents"])
+	if err != nil {
+		return nil, err
+	}
+
+	var a Array
+
+	switch o := o.(type) {
+	case StreamDict:
+		a = Array{o}
+	case Array:
+		a = o
+	}
+
+	for _, o := range a {
+
+		sd, err := xRefTable.DereferenceStreamDict(o)
+		if err != nil {
+			return nil, err
+		}
+
+		if sd == nil {
+			continue
+		}
+
+		// sd is a copy, decoding leaves the xRefTable untouched.
+		err = decodeStream(sd)
+		if err == filter.ErrUnsupportedFilter {
+			h.Write(sd.Raw)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		h.Write(sd.Content)
+	}
+
+	return h.Sum(nil), nil
+}
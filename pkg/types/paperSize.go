@@ -0,0 +1,55 @@
+/*
+Copyright 2018 The pdfcpu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+// Dim represents the dimensions of a rectangular region in userspace.
+type Dim struct {
+	W, H float64
+}
+
+// Landscape returns true if d is wider than high.
+func (d Dim) Landscape() bool {
+	return d.W > d.H
+}
+
+// PaperSize is a map of known paper sizes in portrait orientation in user space units (1/72 inch).
+var PaperSize = map[string]*Dim{
+
+	// ISO 216 A series
+	"A0": {2383.94, 3370.39},
+	"A1": {1683.78, 2383.94},
+	"A2": {1190.55, 1683.78},
+	"A3": {841.89, 1190.55},
+	"A4": {595.28, 841.89},
+	"A5": {419.53, 595.28},
+	"A6": {297.64, 419.53},
+	"A7": {209.76, 297.64},
+	"A8": {147.40, 209.76},
+
+	// ISO 216 B series
+	"B3": {1000.63, 1417.32},
+	"B4": {708.66, 1000.63},
+	"B5": {498.90, 708.66},
+	"B6": {354.33, 498.90},
+
+	// North America
+	"Letter":    {612, 792},
+	"Legal":     {612, 1008},
+	"Tabloid":   {792, 1224},
+	"Ledger":    {792, 1224},
+	"Executive": {521.86, 756},
+}
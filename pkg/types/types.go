@@ -44,6 +44,16 @@ func (r Rectangle) AspectRatio() float64 {
 	return r.Width() / r.Height()
 }
 
+// Center returns the center point of a rectangle.
+func (r Rectangle) Center() Point {
+	return Point{(r.LL.X + r.UR.X) / 2, (r.LL.Y + r.UR.Y) / 2}
+}
+
+// Contains returns true if p is located inside r.
+func (r Rectangle) Contains(p Point) bool {
+	return p.X >= r.LL.X && p.X <= r.UR.X && p.Y >= r.LL.Y && p.Y <= r.UR.Y
+}
+
 func (r Rectangle) String() string {
 	return fmt.Sprintf("(%3.2f, %3.2f, %3.2f, %3.2f) w=%f h=%f ar=%f", r.LL.X, r.LL.Y, r.UR.X, r.UR.Y, r.Width(), r.Height(), r.AspectRatio())
 }